@@ -0,0 +1,270 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/gofiber/fiber/v2/middleware/logger"
+	"github.com/gofiber/fiber/v2/middleware/recover"
+	"messenger/internal/api"
+	"messenger/internal/database"
+	"messenger/internal/models"
+	"messenger/internal/services"
+	"messenger/internal/websocket"
+)
+
+func main() {
+	// Refuse to run with a guessable secret outside development
+	if err := services.CheckJWTSecret(); err != nil {
+		if !services.IsDevelopment() {
+			log.Fatalf("Refusing to start: %v. Set a random JWT_SECRET or APP_ENV=development", err)
+		}
+		log.Printf("Warning: %v (allowed because APP_ENV=development)", err)
+	}
+
+	// Initialize database
+	database.Init()
+	database.Migrate(
+		&models.User{},
+		&models.Message{},
+		&models.MessageDeletion{},
+		&models.Contact{},
+		&models.Media{},
+		&models.Group{},
+		&models.GroupMember{},
+		&models.Block{},
+		&models.DeviceToken{},
+		&models.Reaction{},
+		&models.LinkPreview{},
+		&models.StarredMessage{},
+		&models.ConversationSettings{},
+		&models.Poll{},
+		&models.PollOption{},
+		&models.PollVote{},
+		&models.PinnedMessage{},
+		&models.MessageReadReceipt{},
+		&models.ArchivedConversation{},
+		&models.BroadcastList{},
+		&models.BroadcastListRecipient{},
+		&models.ChatTheme{},
+		&models.Story{},
+		&models.StoryView{},
+		&models.StorageQuota{},
+		&models.Session{},
+		&models.RefreshToken{},
+		&models.TwoFactor{},
+		&models.RecoveryCode{},
+		&models.TwoFactorChallenge{},
+		&models.PhoneVerification{},
+		&models.PasswordResetToken{},
+		&models.ExternalIdentity{},
+		&models.OIDCAuthRequest{},
+		&models.SigningKey{},
+		&models.WebSocketTicket{},
+		&models.DeviceLinkRequest{},
+		&models.AccountExport{},
+		&models.AccountDeletion{},
+		&models.LoginEvent{},
+		&models.LoginLockout{},
+		&models.NotificationPreferences{},
+		&models.PushJob{},
+		&models.PushDeadLetter{},
+		&models.PushBurst{},
+		&models.PushAttempt{},
+		&models.Call{},
+		&models.CallParticipant{},
+		// E2EE models
+		&models.IdentityKey{},
+		&models.PreKey{},
+		&models.SignedPreKey{},
+		&models.EncryptionDevice{},
+		&models.SenderKey{},
+	)
+
+	// Calls only live in memory while they ring or run; close any a restart cut off
+	if n, err := models.FinishUnendedCalls(database.DB); err != nil {
+		log.Printf("Failed to close unended calls: %v", err)
+	} else if n > 0 {
+		log.Printf("Closed %d calls left open by a restart", n)
+	}
+
+	// Create WebSocket hub
+	hub := websocket.NewHub()
+	go hub.Run()
+
+	// Drop live connections when their session is revoked
+	services.SetSessionRevokedHandler(hub.DisconnectSession)
+
+	// Tell contacts when an account is erased so clients can update their lists
+	services.SetAccountDeletedHandler(func(userID string, contactIDs []string) {
+		for _, contactID := range contactIDs {
+			hub.SendJSONToUser(contactID, map[string]interface{}{
+				"type":    "account_deleted",
+				"user_id": userID,
+			})
+		}
+	})
+
+	// Deliver new-device login alerts from the bot to connected clients
+	services.SetNewDeviceLoginHandler(func(userID string, message *models.Message) {
+		hub.SendJSONToUser(userID, websocket.ChatMessage{
+			Type:      "message",
+			ID:        message.ID,
+			From:      services.BotUserID,
+			To:        userID,
+			Content:   message.Content,
+			CreatedAt: message.CreatedAt.Format(time.RFC3339),
+		})
+	})
+
+	// Create bot user if not exists
+	createBotUser()
+
+	// Start message cleanup service (for disappearing messages)
+	cleanupService := services.NewMessageCleanupService(database.DB, 1*time.Minute)
+	cleanupService.Start()
+
+	// Start push notification workers; jobs queued before a restart are picked up again
+	pushQueue := services.GetPushService().Queue()
+	pushQueue.Start()
+
+	// Close burst windows that were open when the server last stopped
+	services.GetPushService().ResumePushBursts()
+
+	// Start scheduled message service
+	schedulerService := services.NewSchedulerService(func(msg *models.Message) {
+		deliverScheduledMessage(hub, msg)
+	})
+	schedulerService.Start()
+
+	// Create Fiber app
+	app := fiber.New(fiber.Config{
+		ErrorHandler: func(c *fiber.Ctx, err error) error {
+			code := fiber.StatusInternalServerError
+			if e, ok := err.(*fiber.Error); ok {
+				code = e.Code
+			}
+			return c.Status(code).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		},
+	})
+
+	// Middleware
+	app.Use(recover.New())
+	app.Use(logger.New(logger.Config{
+		Format: "${time} ${status} ${method} ${path} ${latency}\n",
+	}))
+	app.Use(cors.New(cors.Config{
+		AllowOrigins:  "*",
+		AllowHeaders:  "Origin, Content-Type, Accept, Authorization, Range, If-Range, If-None-Match, If-Modified-Since",
+		ExposeHeaders: "Content-Range, Accept-Ranges, Content-Length, ETag, Last-Modified",
+	}))
+
+	// Uploaded files are served through the authenticated /media routes
+
+	// Setup routes
+	api.SetupRoutes(app, hub)
+
+	// Create upload directories
+	os.MkdirAll("./uploads/quarantine", 0755)
+	os.MkdirAll("./uploads/approved", 0755)
+
+	// Get port from environment or default
+	port := os.Getenv("PORT")
+	if port == "" {
+		port = "8080"
+	}
+
+	// Graceful shutdown
+	go func() {
+		sigChan := make(chan os.Signal, 1)
+		signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+		<-sigChan
+
+		log.Println("Shutting down server...")
+		app.Shutdown()
+	}()
+
+	// Start server
+	log.Printf("Server starting on port %s", port)
+	if err := app.Listen(":" + port); err != nil {
+		log.Fatal("Failed to start server:", err)
+	}
+
+	// Let in-flight pushes finish; anything still queued is sent after restart
+	pushQueue.Stop()
+}
+
+// deliverScheduledMessage delivers a scheduled message via WebSocket
+func deliverScheduledMessage(hub *websocket.Hub, msg *models.Message) {
+	outMsg := map[string]interface{}{
+		"type":       "message",
+		"id":         msg.ID,
+		"from":       msg.SenderID,
+		"content":    msg.Content,
+		"created_at": msg.CreatedAt.Format(time.RFC3339),
+	}
+
+	if msg.MediaID != nil {
+		outMsg["media_id"] = *msg.MediaID
+	}
+	if msg.Latitude != nil {
+		outMsg["latitude"] = *msg.Latitude
+	}
+	if msg.Longitude != nil {
+		outMsg["longitude"] = *msg.Longitude
+	}
+	if msg.LocationName != nil {
+		outMsg["location_name"] = *msg.LocationName
+	}
+
+	if msg.GroupID != nil {
+		outMsg["group_id"] = *msg.GroupID
+		msgBytes, _ := json.Marshal(outMsg)
+		sentCount := hub.SendToGroup(*msg.GroupID, msg.SenderID, msgBytes)
+		if sentCount > 0 {
+			database.DB.Model(msg).Update("status", models.MessageStatusDelivered)
+		}
+		// Push to offline members
+		offlineMembers := hub.GetOfflineGroupMemberIDs(*msg.GroupID, msg.SenderID)
+		for _, memberID := range offlineMembers {
+			services.PushMessageToOfflineUser(database.DB, memberID, msg.SenderID, msg.ID, msg.Content, true, *msg.GroupID)
+		}
+	} else if msg.RecipientID != nil {
+		outMsg["to"] = *msg.RecipientID
+		msgBytes, _ := json.Marshal(outMsg)
+		if hub.SendToUser(*msg.RecipientID, msgBytes) {
+			database.DB.Model(msg).Update("status", models.MessageStatusDelivered)
+		} else {
+			services.PushMessageToOfflineUser(database.DB, *msg.RecipientID, msg.SenderID, msg.ID, msg.Content, false, *msg.RecipientID)
+		}
+	}
+}
+
+// createBotUser ensures the bot user exists in the database
+func createBotUser() {
+	var existingBot models.User
+	result := database.DB.Where("id = ?", services.BotUserID).First(&existingBot)
+
+	if result.Error != nil {
+		// Create bot user
+		botUser := models.User{
+			ID:           services.BotUserID,
+			Username:     services.BotUsername,
+			DisplayName:  services.BotDisplayName,
+			PasswordHash: "", // Bot doesn't need a password
+		}
+		if err := database.DB.Create(&botUser).Error; err != nil {
+			log.Printf("Warning: Could not create bot user: %v", err)
+		} else {
+			log.Println("Bot user created successfully")
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"messenger/internal/api/middleware"
+	"messenger/internal/services"
+)
+
+type AccountHandler struct {
+	authService   *services.AuthService
+	exportService *services.AccountExportService
+}
+
+func NewAccountHandler() *AccountHandler {
+	return &AccountHandler{
+		authService:   services.NewAuthService(),
+		exportService: services.NewAccountExportService(),
+	}
+}
+
+// NewAccountHandlerWithExportService creates a handler with an explicitly configured export service
+func NewAccountHandlerWithExportService(exportService *services.AccountExportService) *AccountHandler {
+	return &AccountHandler{
+		authService:   services.NewAuthService(),
+		exportService: exportService,
+	}
+}
+
+// RequestExport starts building a zip of the current user's data. Poll the
+// returned export until its status is "completed", then download it.
+func (h *AccountHandler) RequestExport(c *fiber.Ctx) error {
+	export, err := h.exportService.Request(middleware.GetUserID(c))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(export)
+}
+
+// GetExport returns the status of an export
+func (h *AccountHandler) GetExport(c *fiber.Ctx) error {
+	export, err := h.exportService.Get(middleware.GetUserID(c), c.Params("id"))
+	if err != nil {
+		return accountError(c, err)
+	}
+
+	return c.JSON(export)
+}
+
+// DownloadExport sends a finished export's zip file
+func (h *AccountHandler) DownloadExport(c *fiber.Ctx) error {
+	path, err := h.exportService.File(middleware.GetUserID(c), c.Params("id"))
+	if err != nil {
+		return accountError(c, err)
+	}
+
+	return c.Download(path, "messenger-export-"+time.Now().Format("2006-01-02")+".zip")
+}
+
+// Delete schedules the current user's account for deletion after a grace
+// period. Requires the password, and a 2FA code if enabled. Accounts without
+// a password send only a 2FA code or a code from RequestDeletionCode.
+func (h *AccountHandler) Delete(c *fiber.Ctx) error {
+	var input struct {
+		Password string `json:"password,omitempty"`
+		Code     string `json:"code,omitempty"`
+	}
+	if err := c.BodyParser(&input); err != nil || (input.Password == "" && input.Code == "") {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Password or code is required",
+		})
+	}
+
+	deletion, err := h.authService.ScheduleAccountDeletion(middleware.GetUserID(c), input.Password, input.Code, c.IP())
+	if err != nil {
+		return accountError(c, err)
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(deletion)
+}
+
+// RequestDeletionCode texts a code for confirming the deletion of an account
+// without a password to the user's verified number
+func (h *AccountHandler) RequestDeletionCode(c *fiber.Ctx) error {
+	if err := h.authService.RequestAccountDeletionCode(c.Context(), middleware.GetUserID(c)); err != nil {
+		return accountError(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Code sent",
+	})
+}
+
+// GetDeletion returns the current user's scheduled deletion
+func (h *AccountHandler) GetDeletion(c *fiber.Ctx) error {
+	deletion, err := h.authService.GetAccountDeletion(middleware.GetUserID(c))
+	if err != nil {
+		return accountError(c, err)
+	}
+
+	return c.JSON(deletion)
+}
+
+// CancelDeletion cancels a scheduled deletion during the grace period
+func (h *AccountHandler) CancelDeletion(c *fiber.Ctx) error {
+	if err := h.authService.CancelAccountDeletion(middleware.GetUserID(c)); err != nil {
+		return accountError(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Account deletion cancelled",
+	})
+}
+
+func accountError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, services.ErrExportNotFound), errors.Is(err, services.ErrDeletionNotScheduled):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, services.ErrExportNotReady):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, services.ErrInvalidPassword), errors.Is(err, services.ErrInvalidTwoFactorCode):
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, services.ErrTwoFactorCodeRequired), errors.Is(err, services.ErrDeletionCodeRequired),
+		errors.Is(err, services.ErrDeletionCodeNotNeeded):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, services.ErrNoDeletionProof):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, services.ErrInvalidOTP), errors.Is(err, services.ErrOTPTooManyAttempts),
+		errors.Is(err, services.ErrOTPRateLimited), errors.Is(err, services.ErrSMSNotConfigured),
+		errors.Is(err, services.ErrSMSDeliveryFailed):
+		return phoneError(c, err)
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": err.Error(),
+	})
+}
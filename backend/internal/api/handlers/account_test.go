@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"messenger/internal/api/middleware"
+	"messenger/internal/database"
+	"messenger/internal/models"
+	"messenger/internal/services"
+)
+
+func setupAccountTestApp(t *testing.T) *fiber.App {
+	app := fiber.New()
+	handler := NewAccountHandlerWithExportService(services.NewAccountExportServiceWithDir(t.TempDir()))
+
+	account := app.Group("/account", middleware.AuthRequired())
+	account.Post("/export", handler.RequestExport)
+	account.Get("/export/:id", handler.GetExport)
+	account.Get("/export/:id/download", handler.DownloadExport)
+	account.Delete("/", handler.Delete)
+	account.Post("/deletion/code", handler.RequestDeletionCode)
+	account.Get("/deletion", handler.GetDeletion)
+	account.Delete("/deletion", handler.CancelDeletion)
+
+	return app
+}
+
+func TestAccountHandler_Export(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	_, token := createTestUser(t, "exporter", "password123")
+	_, otherToken := createTestUser(t, "snooper", "password123")
+	app := setupAccountTestApp(t)
+
+	resp, body := makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/account/export",
+		Token:  token,
+	})
+	assertStatus(t, resp, http.StatusAccepted)
+	exportID := parseResponse(body)["id"].(string)
+
+	status := ""
+	deadline := time.Now().Add(5 * time.Second)
+	for status != "completed" && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+		resp, body = makeRequest(app, testRequest{
+			Method: "GET",
+			Path:   "/account/export/" + exportID,
+			Token:  token,
+		})
+		assertStatus(t, resp, http.StatusOK)
+		status, _ = parseResponse(body)["status"].(string)
+	}
+	if status != "completed" {
+		t.Fatalf("Export did not complete, last status %q", status)
+	}
+
+	t.Run("download", func(t *testing.T) {
+		resp, body := makeRequest(app, testRequest{
+			Method: "GET",
+			Path:   "/account/export/" + exportID + "/download",
+			Token:  token,
+		})
+		assertStatus(t, resp, http.StatusOK)
+		if len(body) < 4 || string(body[:2]) != "PK" {
+			t.Error("Expected a zip file")
+		}
+	})
+
+	t.Run("other users cannot download", func(t *testing.T) {
+		resp, _ := makeRequest(app, testRequest{
+			Method: "GET",
+			Path:   "/account/export/" + exportID + "/download",
+			Token:  otherToken,
+		})
+		assertStatus(t, resp, http.StatusNotFound)
+	})
+}
+
+func TestAccountHandler_Delete(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	_, token := createTestUser(t, "leaving", "password123")
+	app := setupAccountTestApp(t)
+
+	t.Run("wrong password", func(t *testing.T) {
+		resp, _ := makeRequest(app, testRequest{
+			Method: "DELETE",
+			Path:   "/account",
+			Body:   map[string]interface{}{"password": "wrong"},
+			Token:  token,
+		})
+		assertStatus(t, resp, http.StatusUnauthorized)
+	})
+
+	t.Run("schedule and cancel", func(t *testing.T) {
+		resp, body := makeRequest(app, testRequest{
+			Method: "DELETE",
+			Path:   "/account",
+			Body:   map[string]interface{}{"password": "password123"},
+			Token:  token,
+		})
+		assertStatus(t, resp, http.StatusAccepted)
+		assertJSONFieldExists(t, parseResponse(body), "scheduled_for")
+
+		resp, _ = makeRequest(app, testRequest{
+			Method: "GET",
+			Path:   "/account/deletion",
+			Token:  token,
+		})
+		assertStatus(t, resp, http.StatusOK)
+
+		resp, _ = makeRequest(app, testRequest{
+			Method: "DELETE",
+			Path:   "/account/deletion",
+			Token:  token,
+		})
+		assertStatus(t, resp, http.StatusOK)
+
+		resp, _ = makeRequest(app, testRequest{
+			Method: "GET",
+			Path:   "/account/deletion",
+			Token:  token,
+		})
+		assertStatus(t, resp, http.StatusNotFound)
+	})
+	t.Run("account without a password", func(t *testing.T) {
+		sender := services.NewMemorySMSSender()
+		services.SetSMSSender(sender)
+		defer services.SetSMSSender(nil)
+
+		user, ssoToken := createTestUser(t, "ssoleaving", "password123")
+		database.DB.Model(&models.User{}).Where("id = ?", user.ID).Update("password_unset", true)
+
+		resp, _ := makeRequest(app, testRequest{
+			Method: "POST",
+			Path:   "/account/deletion/code",
+			Token:  ssoToken,
+		})
+		assertStatus(t, resp, http.StatusForbidden)
+
+		phone := "+14155550170"
+		database.DB.Model(&models.User{}).Where("id = ?", user.ID).
+			Updates(map[string]interface{}{"phone": phone, "phone_verified_at": time.Now()})
+
+		resp, _ = makeRequest(app, testRequest{
+			Method: "POST",
+			Path:   "/account/deletion/code",
+			Token:  ssoToken,
+		})
+		assertStatus(t, resp, http.StatusOK)
+
+		resp, _ = makeRequest(app, testRequest{
+			Method: "DELETE",
+			Path:   "/account",
+			Body:   map[string]interface{}{"code": lastSMSCode(t, sender, phone)},
+			Token:  ssoToken,
+		})
+		assertStatus(t, resp, http.StatusAccepted)
+	})
+
+	t.Run("password accounts can't ask for a code", func(t *testing.T) {
+		resp, _ := makeRequest(app, testRequest{
+			Method: "POST",
+			Path:   "/account/deletion/code",
+			Token:  token,
+		})
+		assertStatus(t, resp, http.StatusBadRequest)
+	})
+}
@@ -1,18 +1,26 @@
 package handlers
 
 import (
+	"errors"
 	"os"
 
 	"github.com/gofiber/fiber/v2"
 	"messenger/internal/api/middleware"
 	"messenger/internal/database"
 	"messenger/internal/models"
+	"messenger/internal/services"
 )
 
-type AdminHandler struct{}
+type AdminHandler struct {
+	quotaService *services.StorageQuotaService
+	authService  *services.AuthService
+}
 
 func NewAdminHandler() *AdminHandler {
-	return &AdminHandler{}
+	return &AdminHandler{
+		quotaService: services.NewStorageQuotaService(),
+		authService:  services.NewAuthService(),
+	}
 }
 
 // GetPendingReview returns media items pending human review
@@ -110,3 +118,221 @@ func (h *AdminHandler) Review(c *fiber.Ctx) error {
 		"status":  media.Status,
 	})
 }
+
+type SetQuotaInput struct {
+	QuotaMB *int64 `json:"quota_mb"` // 0 = unlimited
+	Reason  string `json:"reason,omitempty"`
+}
+
+// GetUserQuota returns a user's storage usage and effective quota
+// Note: AdminRequired middleware handles role verification
+func (h *AdminHandler) GetUserQuota(c *fiber.Ctx) error {
+	targetID := c.Params("id")
+
+	if _, err := services.GetUserByID(targetID); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	usage, err := h.quotaService.GetUsage(targetID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to calculate storage usage",
+		})
+	}
+
+	return c.JSON(usage)
+}
+
+// SetUserQuota overrides the storage quota for a single user
+// Note: AdminRequired middleware handles role verification
+func (h *AdminHandler) SetUserQuota(c *fiber.Ctx) error {
+	adminID := middleware.GetUserID(c)
+	targetID := c.Params("id")
+
+	var input SetQuotaInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if input.QuotaMB == nil || *input.QuotaMB < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "quota_mb is required and must be 0 (unlimited) or greater",
+		})
+	}
+
+	if _, err := services.GetUserByID(targetID); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	quota, err := h.quotaService.SetUserQuota(targetID, *input.QuotaMB*1024*1024, adminID, input.Reason)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to set quota",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Quota updated",
+		"quota":   quota,
+	})
+}
+
+// ResetUserQuota removes a user's quota override so the role default applies
+// Note: AdminRequired middleware handles role verification
+func (h *AdminHandler) ResetUserQuota(c *fiber.Ctx) error {
+	targetID := c.Params("id")
+
+	if err := h.quotaService.ResetUserQuota(targetID); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to reset quota",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Quota reset to role default",
+	})
+}
+
+// GetUserLockout returns a user's failed login count and lockout state
+// Note: AdminRequired middleware handles role verification
+func (h *AdminHandler) GetUserLockout(c *fiber.Ctx) error {
+	targetID := c.Params("id")
+
+	if _, err := services.GetUserByID(targetID); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	lockout := h.authService.GetLoginLockout(targetID)
+	return c.JSON(fiber.Map{
+		"user_id":         targetID,
+		"locked":          lockout.IsLocked(),
+		"locked_until":    lockout.LockedUntil,
+		"failed_attempts": lockout.FailedAttempts,
+		"lockouts":        lockout.Lockouts,
+	})
+}
+
+// UnlockUser clears a user's failed logins so they can log in again right away
+// Note: AdminRequired middleware handles role verification
+func (h *AdminHandler) UnlockUser(c *fiber.Ctx) error {
+	targetID := c.Params("id")
+
+	if _, err := services.GetUserByID(targetID); err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	unlocked, err := h.authService.UnlockAccount(targetID, middleware.GetUserID(c))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to unlock account",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message":  "Account unlocked",
+		"unlocked": unlocked,
+	})
+}
+
+// ListPushDeadLetters returns push notifications that could not be delivered.
+// Filter with ?provider= and page with ?limit= and ?offset=.
+// Note: AdminRequired middleware handles role verification
+func (h *AdminHandler) ListPushDeadLetters(c *fiber.Ctx) error {
+	limit := c.QueryInt("limit", 50)
+	if limit < 1 || limit > 200 {
+		limit = 50
+	}
+	offset := c.QueryInt("offset", 0)
+	if offset < 0 {
+		offset = 0
+	}
+
+	letters, total, err := services.GetPushService().Queue().ListDeadLetters(c.Query("provider"), limit, offset)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch dead letters",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"dead_letters": letters,
+		"total":        total,
+		"limit":        limit,
+		"offset":       offset,
+	})
+}
+
+// GetPushDeadLetter returns one undelivered push notification with its payload
+// Note: AdminRequired middleware handles role verification
+func (h *AdminHandler) GetPushDeadLetter(c *fiber.Ctx) error {
+	letter, err := services.GetPushService().Queue().GetDeadLetter(c.Params("id"))
+	if err != nil {
+		return pushDeadLetterError(c, err)
+	}
+
+	return c.JSON(letter)
+}
+
+// RetryPushDeadLetter puts an undelivered push notification back in the queue
+// Note: AdminRequired middleware handles role verification
+func (h *AdminHandler) RetryPushDeadLetter(c *fiber.Ctx) error {
+	job, err := services.GetPushService().Queue().RetryDeadLetter(c.Params("id"))
+	if err != nil {
+		return pushDeadLetterError(c, err)
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"message": "Push notification queued",
+		"job_id":  job.ID,
+	})
+}
+
+// DeletePushDeadLetter discards an undelivered push notification
+// Note: AdminRequired middleware handles role verification
+func (h *AdminHandler) DeletePushDeadLetter(c *fiber.Ctx) error {
+	if err := services.GetPushService().Queue().DeleteDeadLetter(c.Params("id")); err != nil {
+		return pushDeadLetterError(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Dead letter deleted",
+	})
+}
+
+func pushDeadLetterError(c *fiber.Ctx, err error) error {
+	if errors.Is(err, services.ErrDeadLetterNotFound) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": err.Error(),
+	})
+}
+
+// RotateSigningKey retires the current token signing key and starts a new one,
+// e.g. after a suspected key compromise. Tokens signed with the old key stay
+// valid until its grace period ends.
+func (h *AdminHandler) RotateSigningKey(c *fiber.Ctx) error {
+	kid, err := services.GetTokenKeys().Rotate()
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"kid":       kid,
+		"algorithm": services.GetTokenKeys().Algorithm(),
+	})
+}
@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"messenger/internal/api/middleware"
@@ -19,6 +20,15 @@ func setupAdminTestApp() *fiber.App {
 	admin := protected.Group("/admin", middleware.ModeratorRequired())
 	admin.Get("/review", handler.GetPendingReview)
 	admin.Post("/review/:id", handler.Review)
+	admin.Get("/users/:id/quota", middleware.AdminRequired(), handler.GetUserQuota)
+	admin.Put("/users/:id/quota", middleware.AdminRequired(), handler.SetUserQuota)
+	admin.Delete("/users/:id/quota", middleware.AdminRequired(), handler.ResetUserQuota)
+	admin.Get("/users/:id/lockout", middleware.AdminRequired(), handler.GetUserLockout)
+	admin.Post("/users/:id/unlock", middleware.AdminRequired(), handler.UnlockUser)
+	admin.Get("/push/dead-letters", middleware.AdminRequired(), handler.ListPushDeadLetters)
+	admin.Get("/push/dead-letters/:id", middleware.AdminRequired(), handler.GetPushDeadLetter)
+	admin.Post("/push/dead-letters/:id/retry", middleware.AdminRequired(), handler.RetryPushDeadLetter)
+	admin.Delete("/push/dead-letters/:id", middleware.AdminRequired(), handler.DeletePushDeadLetter)
 
 	return app
 }
@@ -302,3 +312,194 @@ func TestReview_NotFound(t *testing.T) {
 	data := parseResponse(body)
 	assertJSONFieldExists(t, data, "error")
 }
+
+func TestSetUserQuota(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	_, adminToken := createAdminUser(t, "admin", "password123")
+	_, modToken := createModeratorUser(t, "moderator", "password123")
+	user, _ := createTestUser(t, "uploader", "password123")
+	app := setupAdminTestApp()
+
+	// Moderators cannot change quotas
+	resp, _ := makeRequest(app, testRequest{
+		Method: "PUT",
+		Path:   "/admin/users/" + user.ID + "/quota",
+		Token:  modToken,
+		Body:   map[string]interface{}{"quota_mb": 2048},
+	})
+	assertStatus(t, resp, http.StatusForbidden)
+
+	resp, _ = makeRequest(app, testRequest{
+		Method: "PUT",
+		Path:   "/admin/users/" + user.ID + "/quota",
+		Token:  adminToken,
+		Body:   map[string]interface{}{"quota_mb": 2048, "reason": "video producer"},
+	})
+	assertStatus(t, resp, http.StatusOK)
+
+	resp, body := makeRequest(app, testRequest{
+		Method: "GET",
+		Path:   "/admin/users/" + user.ID + "/quota",
+		Token:  adminToken,
+	})
+	assertStatus(t, resp, http.StatusOK)
+
+	data := parseResponse(body)
+	assertJSONField(t, data, "quota_bytes", float64(2048*1024*1024))
+	assertJSONField(t, data, "quota_source", "override")
+
+	resp, _ = makeRequest(app, testRequest{
+		Method: "DELETE",
+		Path:   "/admin/users/" + user.ID + "/quota",
+		Token:  adminToken,
+	})
+	assertStatus(t, resp, http.StatusOK)
+
+	if _, err := models.GetStorageQuota(database.DB, user.ID); err == nil {
+		t.Error("Expected quota override to be removed")
+	}
+}
+
+func TestSetUserQuota_Invalid(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	_, adminToken := createAdminUser(t, "admin", "password123")
+	user, _ := createTestUser(t, "uploader", "password123")
+	app := setupAdminTestApp()
+
+	resp, _ := makeRequest(app, testRequest{
+		Method: "PUT",
+		Path:   "/admin/users/" + user.ID + "/quota",
+		Token:  adminToken,
+		Body:   map[string]interface{}{"quota_mb": -1},
+	})
+	assertStatus(t, resp, http.StatusBadRequest)
+
+	resp, _ = makeRequest(app, testRequest{
+		Method: "PUT",
+		Path:   "/admin/users/nonexistent/quota",
+		Token:  adminToken,
+		Body:   map[string]interface{}{"quota_mb": 10},
+	})
+	assertStatus(t, resp, http.StatusNotFound)
+}
+
+func TestUnlockUser(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	_, adminToken := createAdminUser(t, "admin", "password123")
+	_, modToken := createModeratorUser(t, "moderator", "password123")
+	locked, _ := createTestUser(t, "lockeduser", "password123")
+	app := setupAdminTestApp()
+
+	until := time.Now().Add(time.Hour)
+	database.DB.Create(&models.LoginLockout{UserID: locked.ID, Lockouts: 3, LockedUntil: &until})
+
+	resp, body := makeRequest(app, testRequest{
+		Method: "GET",
+		Path:   "/admin/users/" + locked.ID + "/lockout",
+		Token:  adminToken,
+	})
+	assertStatus(t, resp, http.StatusOK)
+	assertJSONField(t, parseResponse(body), "locked", true)
+
+	resp, _ = makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/admin/users/" + locked.ID + "/unlock",
+		Token:  modToken,
+	})
+	assertStatus(t, resp, http.StatusForbidden)
+
+	resp, body = makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/admin/users/" + locked.ID + "/unlock",
+		Token:  adminToken,
+	})
+	assertStatus(t, resp, http.StatusOK)
+	assertJSONField(t, parseResponse(body), "unlocked", true)
+
+	resp, body = makeRequest(app, testRequest{
+		Method: "GET",
+		Path:   "/admin/users/" + locked.ID + "/lockout",
+		Token:  adminToken,
+	})
+	assertStatus(t, resp, http.StatusOK)
+	assertJSONField(t, parseResponse(body), "locked", false)
+
+	resp, _ = makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/admin/users/nonexistent/unlock",
+		Token:  adminToken,
+	})
+	assertStatus(t, resp, http.StatusNotFound)
+}
+
+func TestPushDeadLetters(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	_, adminToken := createAdminUser(t, "admin", "password123")
+	_, modToken := createModeratorUser(t, "moderator", "password123")
+	app := setupAdminTestApp()
+
+	job := models.PushJob{Provider: "fcm", Tokens: `["token-a","token-b"]`, Payload: `{"Title":"Hi"}`, Attempts: 8}
+	database.DB.Create(&job)
+	models.DeadLetterPushJob(database.DB, &job, "unavailable")
+
+	resp, _ := makeRequest(app, testRequest{
+		Method: "GET",
+		Path:   "/admin/push/dead-letters",
+		Token:  modToken,
+	})
+	assertStatus(t, resp, http.StatusForbidden)
+
+	resp, body := makeRequest(app, testRequest{
+		Method: "GET",
+		Path:   "/admin/push/dead-letters?provider=fcm",
+		Token:  adminToken,
+	})
+	assertStatus(t, resp, http.StatusOK)
+	result := parseResponse(body)
+	assertJSONField(t, result, "total", float64(1))
+	letter := result["dead_letters"].([]interface{})[0].(map[string]interface{})
+	if letter["token_count"] != float64(2) || letter["last_error"] != "unavailable" {
+		t.Errorf("Unexpected dead letter %v", letter)
+	}
+	if _, ok := letter["tokens"]; ok {
+		t.Error("Device tokens should not be exposed")
+	}
+	id := letter["id"].(string)
+
+	resp, body = makeRequest(app, testRequest{
+		Method: "GET",
+		Path:   "/admin/push/dead-letters/" + id,
+		Token:  adminToken,
+	})
+	assertStatus(t, resp, http.StatusOK)
+	assertJSONFieldExists(t, parseResponse(body), "payload")
+
+	resp, body = makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/admin/push/dead-letters/" + id + "/retry",
+		Token:  adminToken,
+	})
+	assertStatus(t, resp, http.StatusAccepted)
+	assertJSONFieldExists(t, parseResponse(body), "job_id")
+
+	var queued int64
+	database.DB.Model(&models.PushJob{}).Count(&queued)
+	if queued != 1 {
+		t.Errorf("Expected the dead letter to be requeued, got %d jobs", queued)
+	}
+
+	resp, _ = makeRequest(app, testRequest{
+		Method: "DELETE",
+		Path:   "/admin/push/dead-letters/" + id,
+		Token:  adminToken,
+	})
+	assertStatus(t, resp, http.StatusNotFound)
+}
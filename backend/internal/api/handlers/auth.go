@@ -1,7 +1,12 @@
 package handlers
 
 import (
+	"errors"
+	"strconv"
+
 	"github.com/gofiber/fiber/v2"
+	"messenger/internal/api/middleware"
+	"messenger/internal/models"
 	"messenger/internal/services"
 )
 
@@ -35,6 +40,7 @@ func (h *AuthHandler) Register(c *fiber.Ctx) error {
 		})
 	}
 
+	input.Meta = sessionMeta(c)
 	response, err := h.authService.Register(input)
 	if err != nil {
 		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
@@ -59,16 +65,33 @@ func (h *AuthHandler) Login(c *fiber.Ctx) error {
 		})
 	}
 
+	input.Meta = sessionMeta(c)
 	response, err := h.authService.Login(input)
 	if err != nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
-			"error": err.Error(),
-		})
+		return loginError(c, err)
 	}
 
 	return c.JSON(response)
 }
 
+// loginError answers a failed login. Locked accounts get 429 with the time
+// until they unlock; everything else is 401.
+func loginError(c *fiber.Ctx, err error) error {
+	var locked *services.AccountLockedError
+	if errors.As(err, &locked) {
+		retryAfter := int(locked.RetryAfter().Seconds())
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+			"error":        err.Error(),
+			"locked_until": locked.Until,
+			"retry_after":  retryAfter,
+		})
+	}
+	return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+		"error": err.Error(),
+	})
+}
+
 func (h *AuthHandler) Refresh(c *fiber.Ctx) error {
 	var input struct {
 		RefreshToken string `json:"refresh_token"`
@@ -95,3 +118,250 @@ func (h *AuthHandler) Refresh(c *fiber.Ctx) error {
 
 	return c.JSON(response)
 }
+
+// sessionMeta captures the client details stored with a new session
+func sessionMeta(c *fiber.Ctx) services.SessionMeta {
+	return services.SessionMeta{
+		UserAgent: c.Get(fiber.HeaderUserAgent),
+		IPAddress: c.IP(),
+	}
+}
+
+// ListSessions returns the current user's active sessions
+func (h *AuthHandler) ListSessions(c *fiber.Ctx) error {
+	userID := middleware.GetUserID(c)
+	currentSessionID := middleware.GetSessionID(c)
+
+	sessions, err := h.authService.ListSessions(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch sessions",
+		})
+	}
+
+	result := make([]fiber.Map, len(sessions))
+	for i, session := range sessions {
+		result[i] = fiber.Map{
+			"id":           session.ID,
+			"device_name":  session.DeviceName,
+			"user_agent":   session.UserAgent,
+			"ip_address":   session.IPAddress,
+			"created_at":   session.CreatedAt,
+			"last_used_at": session.LastUsedAt,
+			"expires_at":   session.ExpiresAt,
+			"current":      session.ID == currentSessionID,
+		}
+	}
+
+	return c.JSON(fiber.Map{
+		"sessions": result,
+	})
+}
+
+// RevokeSession logs out one of the current user's sessions
+func (h *AuthHandler) RevokeSession(c *fiber.Ctx) error {
+	userID := middleware.GetUserID(c)
+	sessionID := c.Params("id")
+
+	if err := h.authService.RevokeSession(userID, sessionID, models.SessionRevokedByUser); err != nil {
+		if errors.Is(err, services.ErrSessionNotFound) {
+			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+				"error": "Session not found",
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to revoke session",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Session revoked",
+	})
+}
+
+// Logout revokes the session used to make this request
+func (h *AuthHandler) Logout(c *fiber.Ctx) error {
+	userID := middleware.GetUserID(c)
+	sessionID := middleware.GetSessionID(c)
+
+	if err := h.authService.RevokeSession(userID, sessionID, models.SessionRevokedLogout); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to log out",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Logged out",
+	})
+}
+
+// LogoutAll revokes every session of the current user ("log out everywhere").
+// Pass ?keep_current=true to stay logged in on this device.
+func (h *AuthHandler) LogoutAll(c *fiber.Ctx) error {
+	userID := middleware.GetUserID(c)
+
+	exceptSessionID := ""
+	if c.QueryBool("keep_current") {
+		exceptSessionID = middleware.GetSessionID(c)
+	}
+
+	count, err := h.authService.RevokeAllSessions(userID, exceptSessionID, models.SessionRevokedLogoutAll)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to revoke sessions",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Logged out of all sessions",
+		"revoked": count,
+	})
+}
+
+// LoginHistory returns the current user's recent login attempts, newest first.
+// Failed attempts show when someone else may be guessing the password.
+func (h *AuthHandler) LoginHistory(c *fiber.Ctx) error {
+	events, err := h.authService.ListLoginHistory(middleware.GetUserID(c), c.QueryInt("limit", 50))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch login history",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"events": events,
+	})
+}
+
+// WebSocketTicket issues a single-use ticket for opening the WebSocket, so the
+// access token doesn't have to go in the URL
+func (h *AuthHandler) WebSocketTicket(c *fiber.Ctx) error {
+	var input struct {
+		DeviceID string `json:"device_id"`
+	}
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&input); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": "Invalid request body",
+			})
+		}
+	}
+
+	ticket, expiresAt, err := services.IssueWebSocketTicket(
+		middleware.GetUserID(c),
+		middleware.GetSessionID(c),
+		middleware.GetTokenExpiry(c),
+		input.DeviceID,
+	)
+	if errors.Is(err, services.ErrUnknownDevice) {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Unknown device",
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to issue ticket",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"ticket":     ticket,
+		"expires_at": expiresAt,
+	})
+}
+
+// ChangePassword sets a new password for the current user. Other sessions are
+// logged out; the session making the request stays logged in.
+func (h *AuthHandler) ChangePassword(c *fiber.Ctx) error {
+	var input struct {
+		CurrentPassword string `json:"current_password"`
+		NewPassword     string `json:"new_password"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if input.CurrentPassword == "" || input.NewPassword == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Current and new password are required",
+		})
+	}
+
+	revoked, err := h.authService.ChangePassword(middleware.GetUserID(c), middleware.GetSessionID(c), input.CurrentPassword, input.NewPassword)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidPassword):
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+				"error": "Current password is incorrect",
+			})
+		case errors.Is(err, services.ErrWeakPassword), errors.Is(err, services.ErrSamePassword):
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to change password",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message":          "Password changed",
+		"revoked_sessions": revoked,
+	})
+}
+
+// ForgotPassword sends a password reset token. The response is the same
+// whether or not the account exists.
+func (h *AuthHandler) ForgotPassword(c *fiber.Ctx) error {
+	var input struct {
+		Identifier string `json:"identifier"` // Username or verified phone number
+	}
+	if err := c.BodyParser(&input); err != nil || input.Identifier == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Username or phone number is required",
+		})
+	}
+
+	h.authService.RequestPasswordReset(input.Identifier, c.IP())
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"message": "If the account exists and has a way to receive it, a reset link has been sent",
+	})
+}
+
+// ResetPassword sets a new password using a reset token and logs out every session
+func (h *AuthHandler) ResetPassword(c *fiber.Ctx) error {
+	var input struct {
+		Token       string `json:"token"`
+		NewPassword string `json:"new_password"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if input.Token == "" || input.NewPassword == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Token and new password are required",
+		})
+	}
+
+	if err := h.authService.ResetPassword(input.Token, input.NewPassword); err != nil {
+		switch {
+		case errors.Is(err, services.ErrInvalidResetToken), errors.Is(err, services.ErrWeakPassword):
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to reset password",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Password reset, please log in again",
+	})
+}
@@ -1,10 +1,16 @@
 package handlers
 
 import (
+	"encoding/json"
 	"net/http"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"messenger/internal/api/middleware"
+	"messenger/internal/models"
+	"messenger/internal/services"
 )
 
 func TestAuthHandler_Register(t *testing.T) {
@@ -311,3 +317,514 @@ func TestAuthHandler_Refresh(t *testing.T) {
 		})
 	}
 }
+
+func setupSessionsTestApp() *fiber.App {
+	app := fiber.New()
+	authHandler := NewAuthHandler()
+
+	app.Post("/auth/login", authHandler.Login)
+	app.Post("/auth/refresh", authHandler.Refresh)
+
+	protected := app.Group("", middleware.AuthRequired())
+	protected.Get("/auth/sessions", authHandler.ListSessions)
+	protected.Delete("/auth/sessions", authHandler.LogoutAll)
+	protected.Delete("/auth/sessions/:id", authHandler.RevokeSession)
+	protected.Post("/auth/logout", authHandler.Logout)
+	protected.Post("/auth/ws-ticket", authHandler.WebSocketTicket)
+	protected.Get("/auth/login-history", authHandler.LoginHistory)
+	protected.Get("/me", func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"user_id": middleware.GetUserID(c)})
+	})
+
+	return app
+}
+
+func loginTestSession(t *testing.T, app *fiber.App, username string) map[string]interface{} {
+	t.Helper()
+	_, body := makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/auth/login",
+		Body: map[string]interface{}{
+			"username":    username,
+			"password":    "password123",
+			"device_name": "Test Device",
+		},
+	})
+	data := parseResponse(body)
+	if data["access_token"] == nil {
+		t.Fatalf("Login failed: %v", data)
+	}
+	return data
+}
+
+func TestAuthHandler_Sessions(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	createTestUser(t, "sessionuser", "password123")
+	app := setupSessionsTestApp()
+
+	phone := loginTestSession(t, app, "sessionuser")
+	laptop := loginTestSession(t, app, "sessionuser")
+	phoneToken := phone["access_token"].(string)
+	laptopToken := laptop["access_token"].(string)
+
+	t.Run("list sessions", func(t *testing.T) {
+		resp, body := makeRequest(app, testRequest{
+			Method: "GET",
+			Path:   "/auth/sessions",
+			Token:  phoneToken,
+		})
+
+		assertStatus(t, resp, http.StatusOK)
+		sessions := parseResponse(body)["sessions"].([]interface{})
+		// createTestUser's registration session plus two logins
+		if len(sessions) != 3 {
+			t.Fatalf("Expected 3 sessions, got %d", len(sessions))
+		}
+
+		current := 0
+		for _, s := range sessions {
+			session := s.(map[string]interface{})
+			if session["current"] == true {
+				current++
+				if session["id"] != phone["session_id"] {
+					t.Error("Current session should be the one making the request")
+				}
+				if session["device_name"] != "Test Device" {
+					t.Errorf("Expected device name to be stored, got %v", session["device_name"])
+				}
+			}
+		}
+		if current != 1 {
+			t.Errorf("Expected exactly one current session, got %d", current)
+		}
+	})
+
+	t.Run("revoke another session", func(t *testing.T) {
+		resp, _ := makeRequest(app, testRequest{
+			Method: "DELETE",
+			Path:   "/auth/sessions/" + laptop["session_id"].(string),
+			Token:  phoneToken,
+		})
+		assertStatus(t, resp, http.StatusOK)
+
+		resp, _ = makeRequest(app, testRequest{Method: "GET", Path: "/me", Token: laptopToken})
+		assertStatus(t, resp, http.StatusUnauthorized)
+
+		resp, _ = makeRequest(app, testRequest{
+			Method: "POST",
+			Path:   "/auth/refresh",
+			Body:   map[string]interface{}{"refresh_token": laptop["refresh_token"]},
+		})
+		assertStatus(t, resp, http.StatusUnauthorized)
+	})
+
+	t.Run("revoke unknown session", func(t *testing.T) {
+		resp, _ := makeRequest(app, testRequest{
+			Method: "DELETE",
+			Path:   "/auth/sessions/nonexistent-id",
+			Token:  phoneToken,
+		})
+		assertStatus(t, resp, http.StatusNotFound)
+	})
+
+	t.Run("logout", func(t *testing.T) {
+		resp, _ := makeRequest(app, testRequest{Method: "POST", Path: "/auth/logout", Token: phoneToken})
+		assertStatus(t, resp, http.StatusOK)
+
+		resp, _ = makeRequest(app, testRequest{Method: "GET", Path: "/me", Token: phoneToken})
+		assertStatus(t, resp, http.StatusUnauthorized)
+	})
+}
+
+func TestAuthHandler_LogoutAll(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	_, registerToken := createTestUser(t, "logoutuser", "password123")
+	app := setupSessionsTestApp()
+
+	current := loginTestSession(t, app, "logoutuser")
+	currentToken := current["access_token"].(string)
+
+	resp, body := makeRequest(app, testRequest{
+		Method: "DELETE",
+		Path:   "/auth/sessions?keep_current=true",
+		Token:  currentToken,
+	})
+	assertStatus(t, resp, http.StatusOK)
+	assertJSONField(t, parseResponse(body), "revoked", float64(1))
+
+	resp, _ = makeRequest(app, testRequest{Method: "GET", Path: "/me", Token: registerToken})
+	assertStatus(t, resp, http.StatusUnauthorized)
+	resp, _ = makeRequest(app, testRequest{Method: "GET", Path: "/me", Token: currentToken})
+	assertStatus(t, resp, http.StatusOK)
+
+	resp, _ = makeRequest(app, testRequest{Method: "DELETE", Path: "/auth/sessions", Token: currentToken})
+	assertStatus(t, resp, http.StatusOK)
+	resp, _ = makeRequest(app, testRequest{Method: "GET", Path: "/me", Token: currentToken})
+	assertStatus(t, resp, http.StatusUnauthorized)
+}
+
+func TestAuthHandler_WebSocketTicket(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	user, token := createTestUser(t, "ticketuser", "password123")
+	app := setupSessionsTestApp()
+
+	resp, _ := makeRequest(app, testRequest{Method: "POST", Path: "/auth/ws-ticket"})
+	assertStatus(t, resp, http.StatusUnauthorized)
+
+	resp, body := makeRequest(app, testRequest{Method: "POST", Path: "/auth/ws-ticket", Token: token})
+	assertStatus(t, resp, http.StatusOK)
+	result := parseResponse(body)
+	assertJSONFieldExists(t, result, "expires_at")
+
+	auth, err := services.RedeemWebSocketTicket(result["ticket"].(string), "")
+	if err != nil {
+		t.Fatalf("Issued ticket should be redeemable: %v", err)
+	}
+	if auth.UserID != user.ID {
+		t.Errorf("Expected ticket for %s, got %s", user.ID, auth.UserID)
+	}
+	if auth.ExpiresAt.IsZero() {
+		t.Error("Ticket should carry the access token's expiry")
+	}
+
+	resp, _ = makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/auth/ws-ticket",
+		Body:   map[string]interface{}{"device_id": "not-registered"},
+		Token:  token,
+	})
+	assertStatus(t, resp, http.StatusBadRequest)
+}
+
+func TestAuthHandler_LoginLockout(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	createTestUser(t, "guessed", "password123")
+	app := setupSessionsTestApp()
+
+	for i := 0; i < services.DefaultLoginLockoutThreshold; i++ {
+		resp, _ := makeRequest(app, testRequest{
+			Method: "POST",
+			Path:   "/auth/login",
+			Body:   map[string]interface{}{"username": "guessed", "password": "wrong-password"},
+		})
+		assertStatus(t, resp, http.StatusUnauthorized)
+	}
+
+	resp, body := makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/auth/login",
+		Body:   map[string]interface{}{"username": "guessed", "password": "password123"},
+	})
+	assertStatus(t, resp, http.StatusTooManyRequests)
+	assertJSONFieldExists(t, parseResponse(body), "locked_until")
+	if resp.Header.Get("Retry-After") == "" {
+		t.Error("Expected Retry-After header")
+	}
+}
+
+func TestAuthHandler_LoginHistory(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	createTestUser(t, "historyuser", "password123")
+	app := setupSessionsTestApp()
+
+	makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/auth/login",
+		Body:   map[string]interface{}{"username": "historyuser", "password": "wrong-password"},
+	})
+	token := loginTestSession(t, app, "historyuser")["access_token"].(string)
+
+	resp, body := makeRequest(app, testRequest{Method: "GET", Path: "/auth/login-history", Token: token})
+	assertStatus(t, resp, http.StatusOK)
+
+	var result struct {
+		Events []models.LoginEvent `json:"events"`
+	}
+	json.Unmarshal(body, &result)
+	// Registration, the failed attempt and the login
+	if len(result.Events) != 3 {
+		t.Fatalf("Expected 3 login events, got %d", len(result.Events))
+	}
+	if !result.Events[0].Success || result.Events[0].DeviceName != "Test Device" {
+		t.Errorf("Expected the successful login first, got %+v", result.Events[0])
+	}
+	if result.Events[1].Success || result.Events[1].FailureReason != models.LoginFailureInvalidPassword {
+		t.Errorf("Expected the failed login second, got %+v", result.Events[1])
+	}
+}
+
+func TestAuthHandler_RefreshReuse(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	createTestUser(t, "reuseuser", "password123")
+	app := setupSessionsTestApp()
+	session := loginTestSession(t, app, "reuseuser")
+
+	resp, body := makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/auth/refresh",
+		Body:   map[string]interface{}{"refresh_token": session["refresh_token"]},
+	})
+	assertStatus(t, resp, http.StatusOK)
+	rotated := parseResponse(body)
+
+	// Replaying the old refresh token revokes the session
+	resp, _ = makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/auth/refresh",
+		Body:   map[string]interface{}{"refresh_token": session["refresh_token"]},
+	})
+	assertStatus(t, resp, http.StatusUnauthorized)
+
+	resp, _ = makeRequest(app, testRequest{Method: "GET", Path: "/me", Token: rotated["access_token"].(string)})
+	assertStatus(t, resp, http.StatusUnauthorized)
+}
+
+func TestTwoFactorHandler_Flow(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	_, token := createTestUser(t, "tfhandler", "password123")
+
+	app := fiber.New()
+	twoFactorHandler := NewTwoFactorHandler()
+	app.Post("/auth/login", NewAuthHandler().Login)
+	app.Post("/auth/login/2fa", twoFactorHandler.Login)
+	protected := app.Group("/auth/2fa", middleware.AuthRequired())
+	protected.Get("/", twoFactorHandler.Status)
+	protected.Post("/setup", twoFactorHandler.Setup)
+	protected.Post("/confirm", twoFactorHandler.Confirm)
+	protected.Post("/disable", twoFactorHandler.Disable)
+	protected.Post("/recovery-codes", twoFactorHandler.RegenerateRecoveryCodes)
+
+	resp, body := makeRequest(app, testRequest{Method: "POST", Path: "/auth/2fa/setup", Token: token})
+	assertStatus(t, resp, http.StatusOK)
+	setup := parseResponse(body)
+	secret, _ := setup["secret"].(string)
+	if secret == "" || !strings.HasPrefix(setup["otpauth_uri"].(string), "otpauth://totp/") {
+		t.Fatalf("Unexpected setup response: %v", setup)
+	}
+
+	code, _ := services.TOTPCode(secret, services.TOTPStep(time.Now())-1)
+	resp, body = makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/auth/2fa/confirm",
+		Token:  token,
+		Body:   map[string]interface{}{"code": code},
+	})
+	assertStatus(t, resp, http.StatusOK)
+	recoveryCodes, _ := parseResponse(body)["recovery_codes"].([]interface{})
+	if len(recoveryCodes) != services.RecoveryCodeCount {
+		t.Fatalf("Expected %d recovery codes, got %d", services.RecoveryCodeCount, len(recoveryCodes))
+	}
+
+	t.Run("login requires second factor", func(t *testing.T) {
+		resp, body := makeRequest(app, testRequest{
+			Method: "POST",
+			Path:   "/auth/login",
+			Body:   map[string]interface{}{"username": "tfhandler", "password": "password123"},
+		})
+		assertStatus(t, resp, http.StatusOK)
+		data := parseResponse(body)
+		assertJSONField(t, data, "two_factor_required", true)
+		if data["access_token"] != nil {
+			t.Error("Access token should not be issued before the second factor")
+		}
+
+		code, _ := services.TOTPCode(secret, services.TOTPStep(time.Now()))
+		resp, body = makeRequest(app, testRequest{
+			Method: "POST",
+			Path:   "/auth/login/2fa",
+			Body: map[string]interface{}{
+				"challenge_token": data["challenge_token"],
+				"code":            code,
+			},
+		})
+		assertStatus(t, resp, http.StatusOK)
+		assertJSONFieldExists(t, parseResponse(body), "access_token")
+	})
+
+	t.Run("login with bad code fails", func(t *testing.T) {
+		_, body := makeRequest(app, testRequest{
+			Method: "POST",
+			Path:   "/auth/login",
+			Body:   map[string]interface{}{"username": "tfhandler", "password": "password123"},
+		})
+		resp, _ := makeRequest(app, testRequest{
+			Method: "POST",
+			Path:   "/auth/login/2fa",
+			Body: map[string]interface{}{
+				"challenge_token": parseResponse(body)["challenge_token"],
+				"code":            "000000",
+			},
+		})
+		assertStatus(t, resp, http.StatusUnauthorized)
+	})
+
+	t.Run("regenerate recovery codes requires re-authentication", func(t *testing.T) {
+		resp, _ := makeRequest(app, testRequest{
+			Method: "POST",
+			Path:   "/auth/2fa/recovery-codes",
+			Token:  token,
+			Body:   map[string]interface{}{"password": "wrong", "code": recoveryCodes[0]},
+		})
+		assertStatus(t, resp, http.StatusUnauthorized)
+
+		resp, body := makeRequest(app, testRequest{
+			Method: "POST",
+			Path:   "/auth/2fa/recovery-codes",
+			Token:  token,
+			Body:   map[string]interface{}{"password": "password123", "code": recoveryCodes[0]},
+		})
+		assertStatus(t, resp, http.StatusOK)
+		recoveryCodes, _ = parseResponse(body)["recovery_codes"].([]interface{})
+	})
+
+	t.Run("disable requires password and code", func(t *testing.T) {
+		resp, _ := makeRequest(app, testRequest{
+			Method: "POST",
+			Path:   "/auth/2fa/disable",
+			Token:  token,
+			Body:   map[string]interface{}{"password": "password123"},
+		})
+		assertStatus(t, resp, http.StatusBadRequest)
+
+		resp, _ = makeRequest(app, testRequest{
+			Method: "POST",
+			Path:   "/auth/2fa/disable",
+			Token:  token,
+			Body:   map[string]interface{}{"password": "password123", "code": recoveryCodes[0]},
+		})
+		assertStatus(t, resp, http.StatusOK)
+
+		resp, body := makeRequest(app, testRequest{Method: "GET", Path: "/auth/2fa/", Token: token})
+		assertStatus(t, resp, http.StatusOK)
+		assertJSONField(t, parseResponse(body), "enabled", false)
+	})
+}
+
+func TestAuthHandler_ChangePassword(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	createTestUser(t, "pwchanger", "password123")
+	app := setupSessionsTestApp()
+	app.Post("/auth/password", NewAuthHandler().ChangePassword)
+
+	current := loginTestSession(t, app, "pwchanger")
+	other := loginTestSession(t, app, "pwchanger")
+	token := current["access_token"].(string)
+
+	resp, _ := makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/auth/password",
+		Token:  token,
+		Body:   map[string]interface{}{"current_password": "wrong", "new_password": "newpassword"},
+	})
+	assertStatus(t, resp, http.StatusUnauthorized)
+
+	resp, _ = makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/auth/password",
+		Token:  token,
+		Body:   map[string]interface{}{"current_password": "password123", "new_password": "abc"},
+	})
+	assertStatus(t, resp, http.StatusBadRequest)
+
+	resp, body := makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/auth/password",
+		Token:  token,
+		Body:   map[string]interface{}{"current_password": "password123", "new_password": "newpassword"},
+	})
+	assertStatus(t, resp, http.StatusOK)
+	// The registration session and the second login are revoked
+	assertJSONField(t, parseResponse(body), "revoked_sessions", float64(2))
+
+	resp, _ = makeRequest(app, testRequest{Method: "GET", Path: "/me", Token: token})
+	assertStatus(t, resp, http.StatusOK)
+
+	resp, _ = makeRequest(app, testRequest{Method: "GET", Path: "/me", Token: other["access_token"].(string)})
+	assertStatus(t, resp, http.StatusUnauthorized)
+}
+
+func TestAuthHandler_PasswordReset(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	notifier := services.NewMemoryResetNotifier()
+	services.SetPasswordResetNotifier(notifier)
+	defer services.SetPasswordResetNotifier(nil)
+
+	user, token := createTestUser(t, "pwreset", "password123")
+	app := fiber.New()
+	handler := NewAuthHandler()
+	app.Post("/auth/login", handler.Login)
+	app.Post("/auth/password/forgot", handler.ForgotPassword)
+	app.Post("/auth/password/reset", handler.ResetPassword)
+	app.Get("/me", middleware.AuthRequired(), func(c *fiber.Ctx) error {
+		return c.JSON(fiber.Map{"user_id": middleware.GetUserID(c)})
+	})
+
+	// Unknown and known accounts get identical responses
+	_, unknownBody := makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/auth/password/forgot",
+		Body:   map[string]interface{}{"identifier": "nobody"},
+	})
+	resp, knownBody := makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/auth/password/forgot",
+		Body:   map[string]interface{}{"identifier": "pwreset"},
+	})
+	assertStatus(t, resp, http.StatusAccepted)
+	if string(unknownBody) != string(knownBody) {
+		t.Errorf("Responses should not reveal whether the account exists: %s vs %s", unknownBody, knownBody)
+	}
+
+	// Delivery happens in the background
+	resetToken, ok := notifier.LastToken(user.ID)
+	for deadline := time.Now().Add(5 * time.Second); !ok && time.Now().Before(deadline); {
+		time.Sleep(20 * time.Millisecond)
+		resetToken, ok = notifier.LastToken(user.ID)
+	}
+	if !ok {
+		t.Fatal("Reset token should be delivered")
+	}
+
+	resp, _ = makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/auth/password/reset",
+		Body:   map[string]interface{}{"token": "bogus", "new_password": "resetpassword"},
+	})
+	assertStatus(t, resp, http.StatusBadRequest)
+
+	resp, _ = makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/auth/password/reset",
+		Body:   map[string]interface{}{"token": resetToken, "new_password": "resetpassword"},
+	})
+	assertStatus(t, resp, http.StatusOK)
+
+	// Every session is logged out, including the one used above
+	resp, _ = makeRequest(app, testRequest{Method: "GET", Path: "/me", Token: token})
+	assertStatus(t, resp, http.StatusUnauthorized)
+
+	resp, _ = makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/auth/login",
+		Body:   map[string]interface{}{"username": "pwreset", "password": "resetpassword"},
+	})
+	assertStatus(t, resp, http.StatusOK)
+}
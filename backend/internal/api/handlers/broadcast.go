@@ -54,8 +54,8 @@ func (h *BroadcastHandler) Create(c *fiber.Ctx) error {
 		if recipientID == userID {
 			continue // Skip self
 		}
-		if models.IsEitherBlocked(database.DB, userID, recipientID) {
-			continue // Skip blocked
+		if models.IsUnreachable(database.DB, userID, recipientID) {
+			continue // Skip blocked and erased accounts
 		}
 		// Verify user exists
 		var user models.User
@@ -215,7 +215,7 @@ func (h *BroadcastHandler) AddRecipient(c *fiber.Ctx) error {
 	}
 
 	// Check if blocked
-	if models.IsEitherBlocked(database.DB, userID, req.RecipientID) {
+	if models.IsUnreachable(database.DB, userID, req.RecipientID) {
 		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
 			"error": "Cannot add blocked user",
 		})
@@ -324,8 +324,8 @@ func (h *BroadcastHandler) Send(c *fiber.Ctx) error {
 	messageIDs := make([]string, 0)
 
 	for _, recipient := range list.Recipients {
-		// Skip if blocked
-		if models.IsEitherBlocked(database.DB, userID, recipient.RecipientID) {
+		// Skip if blocked or erased
+		if models.IsUnreachable(database.DB, userID, recipient.RecipientID) {
 			continue
 		}
 
@@ -359,7 +359,7 @@ func (h *BroadcastHandler) Send(c *fiber.Ctx) error {
 			h.hub.SendToUser(recipient.RecipientID, msgBytes)
 		} else {
 			// Send push notification to offline users
-			services.PushMessageToOfflineUser(database.DB, recipient.RecipientID, userID, req.Content, false, recipient.RecipientID)
+			services.PushMessageToOfflineUser(database.DB, recipient.RecipientID, userID, message.ID, req.Content, false, recipient.RecipientID)
 		}
 	}
 
@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"messenger/internal/api/middleware"
+	"messenger/internal/database"
+	"messenger/internal/models"
+	"messenger/internal/services"
+)
+
+type CallsHandler struct{}
+
+func NewCallsHandler() *CallsHandler {
+	return &CallsHandler{}
+}
+
+// List returns the user's call history, newest first
+func (h *CallsHandler) List(c *fiber.Ctx) error {
+	userID := middleware.GetUserID(c)
+
+	// Pagination
+	limit, _ := strconv.Atoi(c.Query("limit", "50"))
+	offset, _ := strconv.Atoi(c.Query("offset", "0"))
+
+	if limit > 100 {
+		limit = 100
+	}
+
+	calls, err := models.ListUserCalls(database.DB, userID, limit, offset)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch calls",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"calls": calls,
+	})
+}
+
+// ICEServers returns STUN/TURN servers for setting up a call, with TURN
+// credentials that expire. Clients should fetch them before each call.
+func (h *CallsHandler) ICEServers(c *fiber.Ctx) error {
+	userID := middleware.GetUserID(c)
+
+	c.Set("Cache-Control", "no-store")
+	return c.JSON(services.ICEServersForUser(userID, time.Now()))
+}
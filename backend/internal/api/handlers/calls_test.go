@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"messenger/internal/api/middleware"
+	"messenger/internal/database"
+	"messenger/internal/models"
+)
+
+func setupCallsTestApp() *fiber.App {
+	app := fiber.New()
+	handler := NewCallsHandler()
+
+	protected := app.Group("", middleware.AuthRequired())
+	calls := protected.Group("/calls")
+	calls.Get("/", handler.List)
+	calls.Get("/ice-servers", handler.ICEServers)
+
+	return app
+}
+
+func TestICEServers(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	t.Setenv("STUN_URLS", "stun:stun.example.com:3478")
+	t.Setenv("TURN_URLS", "turn:turn.example.com:3478")
+	t.Setenv("TURN_SECRET", "s3cret")
+
+	user, token := createTestUser(t, "testuser", "password123")
+	app := setupCallsTestApp()
+
+	resp, body := makeRequest(app, testRequest{
+		Method: "GET",
+		Path:   "/calls/ice-servers",
+		Token:  token,
+	})
+	assertStatus(t, resp, http.StatusOK)
+
+	data := parseResponse(body)
+	assertJSONFieldExists(t, data, "expires_at")
+	servers, _ := data["ice_servers"].([]interface{})
+	if len(servers) != 2 {
+		t.Fatalf("Expected STUN and TURN servers, got %v", servers)
+	}
+	turn, _ := servers[1].(map[string]interface{})
+	if username, _ := turn["username"].(string); !strings.HasSuffix(username, ":"+user.ID) {
+		t.Errorf("Expected TURN username for the user, got %q", username)
+	}
+	if turn["credential"] == "" {
+		t.Error("Expected TURN credential")
+	}
+}
+
+func TestListCalls(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	user, token := createTestUser(t, "caller", "password123")
+	other, _ := createTestUser(t, "callee", "password123")
+	stranger, _ := createTestUser(t, "stranger", "password123")
+	app := setupCallsTestApp()
+
+	now := time.Now()
+	outgoing := &models.Call{CallerID: user.ID, RecipientID: &other.ID, Media: models.CallMediaAudio, Status: models.CallStatusEnded, EndedAt: &now}
+	incoming := &models.Call{CallerID: other.ID, RecipientID: &user.ID, Media: models.CallMediaVideo, Status: models.CallStatusMissed, EndedAt: &now}
+	unrelated := &models.Call{CallerID: other.ID, RecipientID: &stranger.ID, Media: models.CallMediaAudio, Status: models.CallStatusEnded}
+	database.DB.Create(outgoing)
+	database.DB.Create(incoming)
+	database.DB.Create(unrelated)
+	database.DB.Create(&models.CallParticipant{CallID: incoming.ID, UserID: user.ID, Status: models.CallParticipantMissed})
+	database.DB.Create(&models.CallParticipant{CallID: unrelated.ID, UserID: stranger.ID, Status: models.CallParticipantJoined})
+
+	resp, body := makeRequest(app, testRequest{
+		Method: "GET",
+		Path:   "/calls",
+		Token:  token,
+	})
+	assertStatus(t, resp, http.StatusOK)
+
+	data := parseResponse(body)
+	calls, _ := data["calls"].([]interface{})
+	if len(calls) != 2 {
+		t.Fatalf("Expected 2 calls, got %d", len(calls))
+	}
+	ids := map[string]bool{}
+	for _, call := range calls {
+		ids[call.(map[string]interface{})["id"].(string)] = true
+	}
+	if !ids[outgoing.ID] || !ids[incoming.ID] {
+		t.Errorf("Expected the user's own calls, got %v", calls)
+	}
+}
@@ -7,6 +7,7 @@ import (
 	"messenger/internal/api/middleware"
 	"messenger/internal/database"
 	"messenger/internal/models"
+	"messenger/internal/services"
 	"messenger/internal/websocket"
 )
 
@@ -72,7 +73,7 @@ func (h *ContactsHandler) Add(c *fiber.Ctx) error {
 
 	// Find user to add
 	var contactUser models.User
-	if err := database.DB.Where("username = ?", input.Username).First(&contactUser).Error; err != nil {
+	if err := database.DB.Where("username = ?", input.Username).First(&contactUser).Error; err != nil || contactUser.IsDeleted() {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"error": "User not found",
 		})
@@ -293,8 +294,15 @@ func (h *ContactsHandler) SearchUsers(c *fiber.Ctx) error {
 	// Build query - search username and display_name, exclude self and blocked
 	// Use LOWER() for case-insensitive search (works with both PostgreSQL and SQLite)
 	lowerPattern := "%" + strings.ToLower(query) + "%"
-	db := database.DB.Where("id != ?", userID).
-		Where("(LOWER(username) LIKE ? OR LOWER(COALESCE(display_name, '')) LIKE ?)", lowerPattern, lowerPattern)
+	db := database.DB.Where("id != ? AND deleted_at IS NULL", userID)
+
+	// A full phone number matches accounts that verified it (exact match only, no partial numbers)
+	if phone, err := services.NormalizePhone(query); err == nil {
+		db = db.Where("(LOWER(username) LIKE ? OR LOWER(COALESCE(display_name, '')) LIKE ? OR (phone = ? AND phone_verified_at IS NOT NULL))",
+			lowerPattern, lowerPattern, phone)
+	} else {
+		db = db.Where("(LOWER(username) LIKE ? OR LOWER(COALESCE(display_name, '')) LIKE ?)", lowerPattern, lowerPattern)
+	}
 
 	if len(blockedIDs) > 0 {
 		db = db.Where("id NOT IN ?", blockedIDs)
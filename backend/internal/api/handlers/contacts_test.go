@@ -3,6 +3,7 @@ package handlers
 import (
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"messenger/internal/api/middleware"
@@ -206,6 +207,33 @@ func TestContactsHandler_Add_Self(t *testing.T) {
 	assertJSONFieldExists(t, data, "error")
 }
 
+func TestContactsHandler_Add_Deleted(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	hub := websocket.NewHub()
+	app := fiber.New()
+	handler := NewContactsHandler(hub)
+
+	app.Use(middleware.AuthRequired())
+	app.Post("/contacts", handler.Add)
+
+	_, token := createTestUser(t, "adder", "password123")
+	gone, _ := createTestUser(t, "gone", "password123")
+	database.DB.Model(gone).Update("deleted_at", time.Now())
+
+	resp, _ := makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/contacts",
+		Body: map[string]interface{}{
+			"username": "gone",
+		},
+		Token: token,
+	})
+
+	assertStatus(t, resp, http.StatusNotFound)
+}
+
 func TestContactsHandler_Remove(t *testing.T) {
 	cleanup := setupTestDB(t)
 	defer cleanup()
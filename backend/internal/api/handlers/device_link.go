@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"errors"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"messenger/internal/api/middleware"
+	"messenger/internal/services"
+)
+
+type DeviceLinkHandler struct {
+	authService *services.AuthService
+}
+
+func NewDeviceLinkHandler() *DeviceLinkHandler {
+	return &DeviceLinkHandler{
+		authService: services.NewAuthService(),
+	}
+}
+
+type linkTokenInput struct {
+	LinkToken string `json:"link_token"`
+}
+
+// Start creates a link request for a new device. The client shows the
+// returned link token and its ephemeral public key as a QR code.
+func (h *DeviceLinkHandler) Start(c *fiber.Ctx) error {
+	var input services.StartDeviceLinkInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	input.Meta = sessionMeta(c)
+	start, err := h.authService.StartDeviceLink(input)
+	if err != nil {
+		return deviceLinkError(c, err)
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(start)
+}
+
+// Complete collects the new device's session once the link is approved.
+// Pass ?wait=<seconds> (up to 25) to long-poll instead of polling rapidly.
+func (h *DeviceLinkHandler) Complete(c *fiber.Ctx) error {
+	var input services.CompleteDeviceLinkInput
+	if err := c.BodyParser(&input); err != nil || input.LinkToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Link token is required",
+		})
+	}
+
+	input.Wait = time.Duration(c.QueryInt("wait", 0)) * time.Second
+	input.Meta = sessionMeta(c)
+	result, err := h.authService.CompleteDeviceLink(c.Context(), input)
+	if errors.Is(err, services.ErrDeviceLinkPending) {
+		return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+			"status": "pending",
+		})
+	}
+	if err != nil {
+		return deviceLinkError(c, err)
+	}
+
+	return c.JSON(result)
+}
+
+// Lookup shows the approving device which device it is about to link
+func (h *DeviceLinkHandler) Lookup(c *fiber.Ctx) error {
+	var input linkTokenInput
+	if err := c.BodyParser(&input); err != nil || input.LinkToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Link token is required",
+		})
+	}
+
+	info, err := h.authService.LookupDeviceLink(input.LinkToken)
+	if err != nil {
+		return deviceLinkError(c, err)
+	}
+
+	return c.JSON(info)
+}
+
+// Approve links the scanned device to the current user and relays the
+// encrypted provisioning blob to it
+func (h *DeviceLinkHandler) Approve(c *fiber.Ctx) error {
+	var input services.ApproveDeviceLinkInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if input.LinkToken == "" || input.ApproverDeviceID == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Link token and approver device ID are required",
+		})
+	}
+
+	if err := h.authService.ApproveDeviceLink(middleware.GetUserID(c), input); err != nil {
+		return deviceLinkError(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Device link approved",
+	})
+}
+
+// Reject declines a scanned link request
+func (h *DeviceLinkHandler) Reject(c *fiber.Ctx) error {
+	var input linkTokenInput
+	if err := c.BodyParser(&input); err != nil || input.LinkToken == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Link token is required",
+		})
+	}
+
+	if err := h.authService.RejectDeviceLink(input.LinkToken); err != nil {
+		return deviceLinkError(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"message": "Device link rejected",
+	})
+}
+
+func deviceLinkError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, services.ErrInvalidLinkDeviceInput), errors.Is(err, services.ErrInvalidLinkKey),
+		errors.Is(err, services.ErrInvalidProvisioning), errors.Is(err, services.ErrUnknownDevice):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, services.ErrInvalidLinkToken):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, services.ErrDeviceLinkRejected):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, services.ErrLinkKeyMismatch), errors.Is(err, services.ErrDeviceAlreadyLinked):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": err.Error(),
+	})
+}
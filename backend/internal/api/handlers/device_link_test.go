@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"messenger/internal/api/middleware"
+	"messenger/internal/database"
+	"messenger/internal/models"
+)
+
+func setupDeviceLinkTestApp() *fiber.App {
+	app := fiber.New()
+	handler := NewDeviceLinkHandler()
+	app.Post("/auth/link", handler.Start)
+	app.Post("/auth/link/complete", handler.Complete)
+
+	protected := app.Group("", middleware.AuthRequired())
+	protected.Post("/auth/link/lookup", handler.Lookup)
+	protected.Post("/auth/link/approve", handler.Approve)
+	protected.Post("/auth/link/reject", handler.Reject)
+
+	return app
+}
+
+func TestDeviceLinkHandler_Flow(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	user, token := createTestUser(t, "linkowner", "password123")
+	models.RegisterDevice(database.DB, user.ID, "phone", "Phone", "android")
+	app := setupDeviceLinkTestApp()
+
+	publicKey := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))
+	resp, body := makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/auth/link",
+		Body: map[string]interface{}{
+			"device_id":   "web-1",
+			"device_name": "Firefox on Linux",
+			"platform":    "web",
+			"public_key":  publicKey,
+		},
+	})
+	assertStatus(t, resp, http.StatusCreated)
+	linkToken := parseResponse(body)["link_token"].(string)
+
+	resp, body = makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/auth/link/complete",
+		Body:   map[string]interface{}{"link_token": linkToken},
+	})
+	assertStatus(t, resp, http.StatusAccepted)
+	assertJSONField(t, parseResponse(body), "status", "pending")
+
+	resp, body = makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/auth/link/lookup",
+		Body:   map[string]interface{}{"link_token": linkToken},
+		Token:  token,
+	})
+	assertStatus(t, resp, http.StatusOK)
+	assertJSONField(t, parseResponse(body), "device_name", "Firefox on Linux")
+
+	approval := map[string]interface{}{
+		"link_token":          linkToken,
+		"public_key":          base64.StdEncoding.EncodeToString([]byte(strings.Repeat("x", 32))),
+		"approver_device_id":  "phone",
+		"approver_public_key": base64.StdEncoding.EncodeToString([]byte(strings.Repeat("a", 32))),
+		"provisioning":        base64.StdEncoding.EncodeToString([]byte("ciphertext")),
+	}
+	resp, _ = makeRequest(app, testRequest{Method: "POST", Path: "/auth/link/approve", Body: approval, Token: token})
+	assertStatus(t, resp, http.StatusConflict)
+
+	approval["public_key"] = publicKey
+	resp, _ = makeRequest(app, testRequest{Method: "POST", Path: "/auth/link/approve", Body: approval})
+	assertStatus(t, resp, http.StatusUnauthorized)
+	resp, _ = makeRequest(app, testRequest{Method: "POST", Path: "/auth/link/approve", Body: approval, Token: token})
+	assertStatus(t, resp, http.StatusOK)
+
+	resp, body = makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/auth/link/complete",
+		Body:   map[string]interface{}{"link_token": linkToken},
+	})
+	assertStatus(t, resp, http.StatusOK)
+	result := parseResponse(body)
+	assertJSONFieldExists(t, result, "access_token")
+	assertJSONField(t, result, "device_id", "web-1")
+	assertJSONField(t, result, "provisioning", approval["provisioning"])
+
+	resp, _ = makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/auth/link/complete",
+		Body:   map[string]interface{}{"link_token": linkToken},
+	})
+	assertStatus(t, resp, http.StatusNotFound)
+}
+
+func TestDeviceLinkHandler_Reject(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	_, token := createTestUser(t, "linkrejecter", "password123")
+	app := setupDeviceLinkTestApp()
+
+	resp, _ := makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/auth/link",
+		Body:   map[string]interface{}{"device_id": "web-1"},
+	})
+	assertStatus(t, resp, http.StatusBadRequest)
+
+	_, body := makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/auth/link",
+		Body: map[string]interface{}{
+			"device_id":  "web-1",
+			"public_key": base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32))),
+		},
+	})
+	linkToken := parseResponse(body)["link_token"].(string)
+
+	resp, _ = makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/auth/link/reject",
+		Body:   map[string]interface{}{"link_token": linkToken},
+		Token:  token,
+	})
+	assertStatus(t, resp, http.StatusOK)
+
+	resp, _ = makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/auth/link/complete",
+		Body:   map[string]interface{}{"link_token": linkToken},
+	})
+	assertStatus(t, resp, http.StatusForbidden)
+}
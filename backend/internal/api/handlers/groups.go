@@ -7,6 +7,7 @@ import (
 	"messenger/internal/api/middleware"
 	"messenger/internal/database"
 	"messenger/internal/models"
+	"messenger/internal/services"
 	"messenger/internal/websocket"
 )
 
@@ -63,8 +64,8 @@ func (h *GroupsHandler) Create(c *fiber.Ctx) error {
 
 	// Add initial members
 	for _, memberID := range input.MemberIDs {
-		if memberID == userID {
-			continue // Skip creator
+		if memberID == userID || models.IsUserDeleted(database.DB, memberID) {
+			continue // Skip creator and erased accounts
 		}
 		member := models.GroupMember{
 			GroupID: group.ID,
@@ -219,7 +220,7 @@ func (h *GroupsHandler) AddMember(c *fiber.Ctx) error {
 
 	// Check if user exists
 	var user models.User
-	if err := database.DB.First(&user, "id = ?", input.UserID).Error; err != nil {
+	if err := database.DB.First(&user, "id = ?", input.UserID).Error; err != nil || user.IsDeleted() {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
 			"error": "User not found",
 		})
@@ -387,6 +388,7 @@ func (h *GroupsHandler) GetMessages(c *fiber.Ctx) error {
 	var messages []models.Message
 	database.DB.Preload("Media").
 		Preload("ReplyTo").
+		Preload("Call.Participants").
 		Where("group_id = ?", groupID).
 		Order("created_at DESC").
 		Limit(100).
@@ -407,6 +409,7 @@ func (h *GroupsHandler) notifyGroupEvent(userID, eventType, groupID, groupName s
 		"type":       eventType,
 		"group_id":   groupID,
 		"group_name": groupName,
+		"text":       services.Localize(services.UserLocale(userID), eventType, groupName), // Event types are catalog keys
 	}
 
 	msgBytes, _ := json.Marshal(msg)
@@ -3,6 +3,7 @@ package handlers
 import (
 	"fmt"
 	"runtime"
+	"strconv"
 	"sync/atomic"
 	"time"
 
@@ -147,6 +148,7 @@ func (h *HealthHandler) Metrics(c *fiber.Ctx) error {
 			"api_requests":      AppMetrics.APIRequests.Load(),
 			"errors":            AppMetrics.Errors.Load(),
 		},
+		"push": services.GetPushService().Queue().Stats(),
 	})
 }
 
@@ -201,11 +203,89 @@ func (h *HealthHandler) PrometheusMetrics(c *fiber.Ctx) error {
 
 	metrics += "# HELP messenger_errors_total Total errors\n"
 	metrics += "# TYPE messenger_errors_total counter\n"
-	metrics += "messenger_errors_total " + formatUint(AppMetrics.Errors.Load()) + "\n"
+	metrics += "messenger_errors_total " + formatUint(AppMetrics.Errors.Load()) + "\n\n"
+
+	metrics += pushPrometheusMetrics(services.GetPushService().Queue().Stats())
 
 	return c.SendString(metrics)
 }
 
+// pushPrometheusMetrics renders the push queue gauges, per-provider counters
+// and latency histograms
+func pushPrometheusMetrics(stats services.PushQueueStats) string {
+	metrics := ""
+	metrics += "# HELP messenger_push_queue_depth Queued push jobs\n"
+	metrics += "# TYPE messenger_push_queue_depth gauge\n"
+	for _, name := range stats.ProviderNames {
+		metrics += "messenger_push_queue_depth" + providerLabel(name) + " " + formatInt(stats.Depth[name]) + "\n"
+	}
+	metrics += "\n"
+
+	metrics += "# HELP messenger_push_queue_oldest_job_age_seconds Age of the oldest queued push job\n"
+	metrics += "# TYPE messenger_push_queue_oldest_job_age_seconds gauge\n"
+	metrics += "messenger_push_queue_oldest_job_age_seconds " + formatFloat(stats.OldestJobAge) + "\n\n"
+
+	metrics += "# HELP messenger_push_dead_letters Push jobs in the dead-letter table\n"
+	metrics += "# TYPE messenger_push_dead_letters gauge\n"
+	metrics += "messenger_push_dead_letters " + formatInt(stats.DeadLetters) + "\n"
+
+	counters := []struct {
+		name  string
+		help  string
+		value func(s services.PushProviderStats) uint64
+	}{
+		{"messenger_push_routed_tokens_total", "Device tokens routed to their own provider", func(s services.PushProviderStats) uint64 { return s.Routed }},
+		{"messenger_push_fallback_tokens_total", "Device tokens routed to the fallback provider", func(s services.PushProviderStats) uint64 { return s.FallbackRouted }},
+		{"messenger_push_unroutable_tokens_total", "Device tokens skipped because no provider was available", func(s services.PushProviderStats) uint64 { return s.Unroutable }},
+		{"messenger_push_delivered_total", "Push jobs delivered", func(s services.PushProviderStats) uint64 { return s.Delivered }},
+		{"messenger_push_attempt_failures_total", "Push send attempts that failed", func(s services.PushProviderStats) uint64 { return s.AttemptFailures }},
+		{"messenger_push_retries_total", "Push jobs rescheduled for retry", func(s services.PushProviderStats) uint64 { return s.Retries }},
+		{"messenger_push_dead_lettered_total", "Push jobs moved to the dead-letter table", func(s services.PushProviderStats) uint64 { return s.DeadLettered }},
+		{"messenger_push_invalid_tokens_total", "Device tokens rejected by the provider", func(s services.PushProviderStats) uint64 { return s.InvalidTokens }},
+		{"messenger_push_token_successes_total", "Pushes to one device accepted by the provider", func(s services.PushProviderStats) uint64 { return s.TokenSuccesses }},
+		{"messenger_push_token_failures_total", "Pushes to one device that failed", func(s services.PushProviderStats) uint64 { return s.TokenFailures }},
+	}
+	for _, counter := range counters {
+		metrics += "\n# HELP " + counter.name + " " + counter.help + "\n"
+		metrics += "# TYPE " + counter.name + " counter\n"
+		for _, name := range stats.ProviderNames {
+			metrics += counter.name + providerLabel(name) + " " + formatUint(counter.value(stats.Providers[name])) + "\n"
+		}
+	}
+
+	histograms := []struct {
+		name  string
+		help  string
+		value func(s services.PushProviderStats) services.Histogram
+	}{
+		{"messenger_push_delivery_latency_seconds", "Time from enqueue to delivery", func(s services.PushProviderStats) services.Histogram { return s.Latency }},
+		{"messenger_push_send_duration_seconds", "Duration of one provider send", func(s services.PushProviderStats) services.Histogram { return s.SendDuration }},
+	}
+	for _, histogram := range histograms {
+		metrics += "\n# HELP " + histogram.name + " " + histogram.help + "\n"
+		metrics += "# TYPE " + histogram.name + " histogram\n"
+		for _, name := range stats.ProviderNames {
+			h := histogram.value(stats.Providers[name])
+			for i, bound := range services.PushLatencyBuckets {
+				count := uint64(0)
+				if i < len(h.Buckets) {
+					count = h.Buckets[i]
+				}
+				metrics += histogram.name + "_bucket{provider=\"" + name + "\",le=\"" + strconv.FormatFloat(bound, 'g', -1, 64) + "\"} " + formatUint(count) + "\n"
+			}
+			metrics += histogram.name + "_bucket{provider=\"" + name + "\",le=\"+Inf\"} " + formatUint(h.Count) + "\n"
+			metrics += histogram.name + "_sum" + providerLabel(name) + " " + formatFloat(h.Sum) + "\n"
+			metrics += histogram.name + "_count" + providerLabel(name) + " " + formatUint(h.Count) + "\n"
+		}
+	}
+
+	return metrics
+}
+
+func providerLabel(provider string) string {
+	return "{provider=\"" + provider + "\"}"
+}
+
 func formatFloat(f float64) string {
 	return fmt.Sprintf("%.6f", f)
 }
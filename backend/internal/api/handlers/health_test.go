@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"encoding/json"
+	"io"
 	"net/http/httptest"
 	"strings"
 	"testing"
@@ -11,6 +12,7 @@ import (
 	"gorm.io/gorm"
 	"gorm.io/gorm/logger"
 	"messenger/internal/database"
+	"messenger/internal/models"
 	ws "messenger/internal/websocket"
 )
 
@@ -22,6 +24,7 @@ func setupHealthTestDB(t *testing.T) func() {
 	if err != nil {
 		t.Fatalf("Failed to create test database: %v", err)
 	}
+	database.DB.AutoMigrate(&models.PushJob{}, &models.PushDeadLetter{})
 
 	return func() {
 		sqlDB, _ := database.DB.DB()
@@ -229,9 +232,8 @@ func TestHealthHandler_PrometheusMetrics(t *testing.T) {
 	}
 
 	// Read body
-	buf := make([]byte, 4096)
-	n, _ := resp.Body.Read(buf)
-	body := string(buf[:n])
+	raw, _ := io.ReadAll(resp.Body)
+	body := string(raw)
 
 	// Check for expected metrics
 	expectedMetrics := []string{
@@ -240,6 +242,8 @@ func TestHealthHandler_PrometheusMetrics(t *testing.T) {
 		"messenger_memory_alloc_bytes",
 		"messenger_websocket_connections",
 		"messenger_messages_received_total",
+		"messenger_push_queue_oldest_job_age_seconds",
+		"messenger_push_dead_letters",
 	}
 
 	for _, metric := range expectedMetrics {
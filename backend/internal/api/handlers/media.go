@@ -1,7 +1,10 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"log"
 	"os"
 	"path/filepath"
 	"strings"
@@ -20,6 +23,7 @@ type MediaHandler struct {
 	moderationService *services.ModerationService
 	videoService      *services.VideoService
 	documentService   *services.DocumentService
+	quotaService      *services.StorageQuotaService
 }
 
 func NewMediaHandler(hub *websocket.Hub) *MediaHandler {
@@ -28,6 +32,7 @@ func NewMediaHandler(hub *websocket.Hub) *MediaHandler {
 		moderationService: services.NewModerationService(),
 		videoService:      services.NewVideoService(),
 		documentService:   services.NewDocumentService(),
+		quotaService:      services.NewStorageQuotaService(),
 	}
 }
 
@@ -77,16 +82,9 @@ func (h *MediaHandler) Upload(c *fiber.Ctx) error {
 			"error": "Failed to create upload directory",
 		})
 	}
-
-	// Save to quarantine
 	quarantinePath := filepath.Join(quarantineDir, filename)
-	if err := c.SaveFile(file, quarantinePath); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
-			"error": "Failed to save file",
-		})
-	}
 
-	// Create media record
+	// Create the media record, reserving its size against the storage quota
 	media := models.Media{
 		UploaderID:  userID,
 		Filename:    filename,
@@ -94,16 +92,31 @@ func (h *MediaHandler) Upload(c *fiber.Ctx) error {
 		MediaType:   mediaType,
 		Size:        file.Size,
 		Status:      models.MediaStatusPending,
-		StoragePath: quarantinePath,
 	}
-
-	if err := database.DB.Create(&media).Error; err != nil {
-		os.Remove(quarantinePath)
+	if err := h.quotaService.ReserveUpload(&media, quarantinePath); err != nil {
+		var quotaErr *services.QuotaExceededError
+		if errors.As(err, &quotaErr) {
+			return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{
+				"error":           quotaErr.Error(),
+				"code":            "storage_quota_exceeded",
+				"quota_bytes":     quotaErr.QuotaBytes,
+				"used_bytes":      quotaErr.UsedBytes,
+				"requested_bytes": quotaErr.RequestedBytes,
+			})
+		}
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to create media record",
 		})
 	}
 
+	// Save to quarantine
+	if err := c.SaveFile(file, quarantinePath); err != nil {
+		database.DB.Delete(&media)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to save file",
+		})
+	}
+
 	// Process moderation asynchronously
 	go h.processModeration(&media)
 
@@ -120,33 +133,30 @@ func (h *MediaHandler) processModeration(media *models.Media) {
 	var err error
 
 	switch media.MediaType {
-	case models.MediaTypeImage:
-		// Scan images with GCP Vision
-		result, err = h.moderationService.ScanImage(media.StoragePath)
 	case models.MediaTypeVideo:
 		// Extract video metadata and thumbnail
 		h.processVideoMetadata(media)
-		// For videos, auto-approve (could implement frame scanning later)
-		result = &services.ScanResult{
-			Status:    models.MediaStatusApproved,
-			RawResult: "Video auto-approved",
-		}
 	case models.MediaTypeAudio:
 		// Extract audio metadata
 		h.processAudioMetadata(media)
-		// For audio, auto-approve (could implement speech-to-text moderation later)
-		result = &services.ScanResult{
-			Status:    models.MediaStatusApproved,
-			RawResult: "Audio auto-approved",
-		}
 	case models.MediaTypeDocument:
 		// Extract document metadata
 		h.processDocumentMetadata(media)
-		// For documents, auto-approve (could implement PDF preview scanning later)
-		result = &services.ScanResult{
-			Status:    models.MediaStatusApproved,
-			RawResult: "Document auto-approved",
-		}
+	}
+
+	switch media.MediaType {
+	case models.MediaTypeVideo:
+		// Sample frames and run each through the image provider chain
+		result, err = h.scanVideo(media)
+	case models.MediaTypeImage, models.MediaTypeAudio, models.MediaTypeDocument:
+		// Run the moderation provider chain (signature checks apply to every type,
+		// content classifiers only to the types they support)
+		result, err = h.moderationService.ScanMedia(context.Background(), &services.ModerationInput{
+			FilePath:    media.StoragePath,
+			ContentType: media.ContentType,
+			MediaType:   media.MediaType,
+			Size:        media.Size,
+		})
 	default:
 		result = &services.ScanResult{
 			Status:    models.MediaStatusReview,
@@ -181,6 +191,11 @@ func (h *MediaHandler) processModeration(media *models.Media) {
 			"storage_path": newPath,
 			"url":          fmt.Sprintf("/media/%s", media.ID),
 		})
+		media.StoragePath = newPath
+
+		if media.MediaType == models.MediaTypeVideo && h.videoService.HLSEnabled() {
+			h.processHLS(media)
+		}
 	} else if result.Status == models.MediaStatusRejected {
 		// Delete the file
 		os.Remove(media.StoragePath)
@@ -213,8 +228,97 @@ func (h *MediaHandler) Get(c *fiber.Ctx) error {
 		})
 	}
 
-	// Serve the file
-	return c.SendFile(media.StoragePath)
+	// Stream the file with range and conditional request support
+	return serveMediaFile(c, media.StoragePath, media.ContentType, media.ID, media.Status == models.MediaStatusApproved)
+}
+
+// GetHLS serves the HLS playlists and segments of an approved video
+func (h *MediaHandler) GetHLS(c *fiber.Ctx) error {
+	mediaID := c.Params("id")
+
+	var media models.Media
+	if err := database.DB.First(&media, "id = ?", mediaID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Media not found",
+		})
+	}
+
+	if media.Status != models.MediaStatusApproved || media.HLSPath == "" {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "No HLS stream available",
+		})
+	}
+
+	name := c.Params("*")
+	if name == "" {
+		name = filepath.Base(media.HLSPath)
+	}
+
+	// Only allow files inside this video's HLS directory
+	hlsDir := filepath.Dir(media.HLSPath)
+	path := filepath.Join(hlsDir, filepath.Clean("/"+name))
+	contentType := hlsContentType(path)
+	if contentType == "" || !strings.HasPrefix(path, hlsDir+string(filepath.Separator)) {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "File not found",
+		})
+	}
+
+	return serveMediaFile(c, path, contentType, media.ID, true)
+}
+
+// Usage returns the current user's storage usage and quota
+func (h *MediaHandler) Usage(c *fiber.Ctx) error {
+	userID := middleware.GetUserID(c)
+
+	usage, err := h.quotaService.GetUsage(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to calculate storage usage",
+		})
+	}
+
+	return c.JSON(usage)
+}
+
+// Delete removes an uploaded media item and frees its storage
+func (h *MediaHandler) Delete(c *fiber.Ctx) error {
+	mediaID := c.Params("id")
+	userID := middleware.GetUserID(c)
+
+	var media models.Media
+	if err := database.DB.First(&media, "id = ?", mediaID).Error; err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Media not found",
+		})
+	}
+
+	if media.UploaderID != userID {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": "You can only delete your own media",
+		})
+	}
+
+	if media.StoragePath != "" {
+		os.Remove(media.StoragePath)
+	}
+	if media.ThumbnailPath != "" {
+		os.Remove(media.ThumbnailPath)
+	}
+	if media.HLSPath != "" {
+		os.RemoveAll(filepath.Dir(media.HLSPath))
+	}
+
+	if err := database.DB.Delete(&media).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to delete media",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"message":     "Media deleted",
+		"freed_bytes": media.Size,
+	})
 }
 
 // getMediaType returns the media type category for a given content type
@@ -286,6 +390,50 @@ func getMaxSize(mediaType models.MediaType) int64 {
 	}
 }
 
+// scanVideo moderates a video by scanning frames sampled from it. Videos that
+// cannot be sampled (e.g. ffmpeg is missing) are sent to review rather than approved.
+func (h *MediaHandler) scanVideo(media *models.Media) (*services.ScanResult, error) {
+	input := &services.ModerationInput{
+		FilePath:    media.StoragePath,
+		ContentType: media.ContentType,
+		MediaType:   media.MediaType,
+		Size:        media.Size,
+	}
+
+	frames, frameDir, err := h.videoService.ExtractKeyframes(media.StoragePath, 0)
+	if err != nil {
+		log.Printf("Could not sample frames from video %s: %v", media.ID, err)
+	} else {
+		defer os.RemoveAll(frameDir)
+	}
+
+	return h.moderationService.ScanVideoFrames(context.Background(), input, frames)
+}
+
+// processHLS packages an approved video for adaptive playback
+func (h *MediaHandler) processHLS(media *models.Media) {
+	// Reload to pick up the dimensions stored by processVideoMetadata
+	var width, height int
+	database.DB.First(media, "id = ?", media.ID)
+	if media.Width != nil {
+		width = *media.Width
+	}
+	if media.Height != nil {
+		height = *media.Height
+	}
+
+	masterPath, err := h.videoService.GenerateHLS(media.StoragePath, media.ID, width, height)
+	if err != nil {
+		log.Printf("HLS packaging failed for %s: %v", media.ID, err)
+		return
+	}
+
+	database.DB.Model(media).Updates(map[string]interface{}{
+		"hls_path": masterPath,
+		"hls_url":  fmt.Sprintf("/media/%s/hls/master.m3u8", media.ID),
+	})
+}
+
 // processVideoMetadata extracts and stores video metadata
 func (h *MediaHandler) processVideoMetadata(media *models.Media) {
 	if !h.videoService.IsAvailable() {
@@ -0,0 +1,209 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Cache lifetimes for served media. Media is only served to authenticated
+// users, so responses are always marked private.
+const (
+	approvedMediaMaxAge = 24 * time.Hour
+)
+
+// byteRange is an inclusive byte range within a file
+type byteRange struct {
+	start int64
+	end   int64
+}
+
+func (r byteRange) length() int64 {
+	return r.end - r.start + 1
+}
+
+// sectionReadCloser streams part of a file and closes it once fasthttp is done
+type sectionReadCloser struct {
+	*io.SectionReader
+	file *os.File
+}
+
+func (s *sectionReadCloser) Close() error {
+	return s.file.Close()
+}
+
+// serveMediaFile streams a file with support for conditional and range requests:
+// ETag/If-None-Match, Last-Modified/If-Modified-Since, Range and If-Range.
+// Only single ranges are honoured; multi-range requests get the whole file.
+func serveMediaFile(c *fiber.Ctx, path, contentType, etagSeed string, cacheable bool) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Media file not found",
+		})
+	}
+
+	stat, err := file.Stat()
+	if err != nil || stat.IsDir() {
+		file.Close()
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "Media file not found",
+		})
+	}
+
+	size := stat.Size()
+	modTime := stat.ModTime().UTC().Truncate(time.Second)
+	etag := fmt.Sprintf(`"%s-%x-%x"`, etagSeed, size, stat.ModTime().UnixNano())
+
+	if contentType == "" {
+		contentType = fiber.MIMEOctetStream
+	}
+	c.Set(fiber.HeaderContentType, contentType)
+	c.Set(fiber.HeaderAcceptRanges, "bytes")
+	c.Set(fiber.HeaderETag, etag)
+	c.Set(fiber.HeaderLastModified, modTime.Format(http.TimeFormat))
+	c.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+	if cacheable {
+		c.Set(fiber.HeaderCacheControl, fmt.Sprintf("private, max-age=%d", int(approvedMediaMaxAge.Seconds())))
+	} else {
+		// Pending media may still change status, so always revalidate
+		c.Set(fiber.HeaderCacheControl, "private, no-cache")
+	}
+
+	if notModified(c, etag, modTime) {
+		file.Close()
+		return c.SendStatus(fiber.StatusNotModified)
+	}
+
+	rangeHeader := c.Get(fiber.HeaderRange)
+	if rangeHeader != "" && ifRangeMatches(c.Get(fiber.HeaderIfRange), etag, modTime) {
+		r, ok := parseRange(rangeHeader, size)
+		if !ok {
+			file.Close()
+			c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes */%d", size))
+			return c.Status(fiber.StatusRequestedRangeNotSatisfiable).JSON(fiber.Map{
+				"error": "Requested range not satisfiable",
+			})
+		}
+		if r != nil {
+			c.Status(fiber.StatusPartialContent)
+			c.Set(fiber.HeaderContentRange, fmt.Sprintf("bytes %d-%d/%d", r.start, r.end, size))
+			c.Context().SetBodyStream(&sectionReadCloser{
+				SectionReader: io.NewSectionReader(file, r.start, r.length()),
+				file:          file,
+			}, int(r.length()))
+			return nil
+		}
+	}
+
+	c.Status(fiber.StatusOK)
+	c.Context().SetBodyStream(&sectionReadCloser{
+		SectionReader: io.NewSectionReader(file, 0, size),
+		file:          file,
+	}, int(size))
+	return nil
+}
+
+// notModified evaluates If-None-Match, falling back to If-Modified-Since
+func notModified(c *fiber.Ctx, etag string, modTime time.Time) bool {
+	if inm := c.Get(fiber.HeaderIfNoneMatch); inm != "" {
+		for _, candidate := range strings.Split(inm, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	if ims := c.Get(fiber.HeaderIfModifiedSince); ims != "" {
+		if t, err := http.ParseTime(ims); err == nil && !modTime.After(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// ifRangeMatches reports whether a range may be served. An If-Range that no
+// longer matches the file means the client's partial copy is stale, so the
+// whole file must be sent instead.
+func ifRangeMatches(ifRange, etag string, modTime time.Time) bool {
+	if ifRange == "" {
+		return true
+	}
+	if strings.HasPrefix(ifRange, `"`) {
+		// Weak validators never match for If-Range
+		return ifRange == etag
+	}
+	t, err := http.ParseTime(ifRange)
+	return err == nil && modTime.Equal(t)
+}
+
+// parseRange parses a single "bytes=" range against a file size. It returns
+// (nil, true) when the header should be ignored (unsupported unit or multiple
+// ranges) and (nil, false) when the range cannot be satisfied.
+func parseRange(header string, size int64) (*byteRange, bool) {
+	const prefix = "bytes="
+	if !strings.HasPrefix(header, prefix) {
+		return nil, true
+	}
+	spec := strings.TrimSpace(strings.TrimPrefix(header, prefix))
+	if strings.Contains(spec, ",") {
+		return nil, true
+	}
+
+	dash := strings.IndexByte(spec, '-')
+	if dash < 0 {
+		return nil, false
+	}
+	startStr, endStr := strings.TrimSpace(spec[:dash]), strings.TrimSpace(spec[dash+1:])
+
+	if startStr == "" {
+		// Suffix range: the last N bytes
+		n, err := strconv.ParseInt(endStr, 10, 64)
+		if err != nil || n <= 0 || size == 0 {
+			return nil, false
+		}
+		if n > size {
+			n = size
+		}
+		return &byteRange{start: size - n, end: size - 1}, true
+	}
+
+	start, err := strconv.ParseInt(startStr, 10, 64)
+	if err != nil || start < 0 || start >= size {
+		return nil, false
+	}
+
+	end := size - 1
+	if endStr != "" {
+		end, err = strconv.ParseInt(endStr, 10, 64)
+		if err != nil || end < start {
+			return nil, false
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+
+	return &byteRange{start: start, end: end}, true
+}
+
+// hlsContentType returns the MIME type for files in an HLS package
+func hlsContentType(name string) string {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".m3u8":
+		return "application/vnd.apple.mpegurl"
+	case ".ts":
+		return "video/mp2t"
+	default:
+		return ""
+	}
+}
@@ -24,8 +24,11 @@ func setupMediaTestApp() *fiber.App {
 	protected := app.Group("", middleware.AuthRequired())
 	media := protected.Group("/media")
 	media.Post("/upload", handler.Upload)
+	media.Get("/usage", handler.Usage)
 	media.Get("/:id", handler.Get)
+	media.Delete("/:id", handler.Delete)
 	media.Get("/:id/thumbnail", handler.GetThumbnail)
+	media.Get("/:id/hls/*", handler.GetHLS)
 
 	return app
 }
@@ -372,3 +375,422 @@ func TestGetThumbnail_HasThumbnail(t *testing.T) {
 
 	assertStatus(t, resp, http.StatusOK)
 }
+
+func TestUpload_QuotaExceeded(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	os.MkdirAll("./uploads/quarantine", 0755)
+	defer os.RemoveAll("./uploads")
+
+	user, token := createTestUser(t, "testuser", "password123")
+	app := setupMediaTestApp()
+
+	// 1MB quota, already fully used
+	models.SetStorageQuota(database.DB, user.ID, 1024*1024, "", "")
+	database.DB.Create(&models.Media{
+		UploaderID:  user.ID,
+		Filename:    "existing.jpg",
+		ContentType: "image/jpeg",
+		MediaType:   models.MediaTypeImage,
+		Size:        1024 * 1024,
+		Status:      models.MediaStatusApproved,
+		StoragePath: "./uploads/approved/existing.jpg",
+	})
+
+	imageContent := append([]byte{0xFF, 0xD8, 0xFF, 0xE0}, make([]byte, 1000)...)
+	body, contentType := createMultipartRequest(t, "file", "test.jpg", "image/jpeg", imageContent)
+
+	req := httptest.NewRequest("POST", "/media/upload", body)
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+
+	assertStatus(t, resp, http.StatusRequestEntityTooLarge)
+
+	respBody, _ := io.ReadAll(resp.Body)
+	data := parseResponse(respBody)
+	assertJSONField(t, data, "code", "storage_quota_exceeded")
+	assertJSONField(t, data, "used_bytes", float64(1024*1024))
+}
+
+func TestUpload_QuotaReservedByPendingUploads(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	os.MkdirAll("./uploads/quarantine", 0755)
+	defer os.RemoveAll("./uploads")
+
+	user, token := createTestUser(t, "testuser", "password123")
+	app := setupMediaTestApp()
+
+	// Room for one upload but not two
+	models.SetStorageQuota(database.DB, user.ID, 1500, "", "")
+	imageContent := append([]byte{0xFF, 0xD8, 0xFF, 0xE0}, make([]byte, 1000)...)
+
+	var statuses []int
+	for i := 0; i < 2; i++ {
+		body, contentType := createMultipartRequest(t, "file", "test.jpg", "image/jpeg", imageContent)
+		req := httptest.NewRequest("POST", "/media/upload", body)
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("Authorization", "Bearer "+token)
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		statuses = append(statuses, resp.StatusCode)
+	}
+
+	if statuses[0] != http.StatusAccepted || statuses[1] != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected the pending upload to hold its quota, got statuses %v", statuses)
+	}
+	var count int64
+	database.DB.Model(&models.Media{}).Where("uploader_id = ?", user.ID).Count(&count)
+	if count != 1 {
+		t.Errorf("Rejected upload should leave no media record, got %d", count)
+	}
+}
+
+func TestMediaUsage(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	user, token := createTestUser(t, "testuser", "password123")
+	friend, _ := createTestUser(t, "friend", "password123")
+	app := setupMediaTestApp()
+
+	image := models.Media{
+		UploaderID: user.ID, Filename: "a.jpg", ContentType: "image/jpeg",
+		MediaType: models.MediaTypeImage, Size: 3000,
+		Status: models.MediaStatusApproved, StoragePath: "/tmp/a.jpg",
+	}
+	video := models.Media{
+		UploaderID: user.ID, Filename: "b.mp4", ContentType: "video/mp4",
+		MediaType: models.MediaTypeVideo, Size: 5000,
+		Status: models.MediaStatusPending, StoragePath: "/tmp/b.mp4",
+	}
+	rejected := models.Media{
+		UploaderID: user.ID, Filename: "c.jpg", ContentType: "image/jpeg",
+		MediaType: models.MediaTypeImage, Size: 9000,
+		Status: models.MediaStatusRejected, StoragePath: "",
+	}
+	database.DB.Create(&image)
+	database.DB.Create(&video)
+	database.DB.Create(&rejected)
+
+	// Send the image to a friend; the video stays unsent
+	database.DB.Create(&models.Message{
+		SenderID:    user.ID,
+		RecipientID: &friend.ID,
+		MediaID:     &image.ID,
+	})
+
+	resp, body := makeRequest(app, testRequest{
+		Method: "GET",
+		Path:   "/media/usage",
+		Token:  token,
+	})
+
+	assertStatus(t, resp, http.StatusOK)
+
+	data := parseResponse(body)
+	assertJSONField(t, data, "used_bytes", float64(8000))
+	assertJSONField(t, data, "quota_source", "role")
+
+	byType, ok := data["by_type"].([]interface{})
+	if !ok || len(byType) != 2 {
+		t.Fatalf("Expected 2 media types, got %v", data["by_type"])
+	}
+
+	byConversation, ok := data["by_conversation"].([]interface{})
+	if !ok || len(byConversation) != 2 {
+		t.Fatalf("Expected 2 conversations, got %v", data["by_conversation"])
+	}
+	for _, entry := range byConversation {
+		conv := entry.(map[string]interface{})
+		if conv["conversation_type"] == "dm" && conv["conversation_id"] != friend.ID {
+			t.Errorf("Expected DM usage for %s, got %v", friend.ID, conv["conversation_id"])
+		}
+	}
+}
+
+func TestDeleteMedia(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	os.MkdirAll("./uploads/approved", 0755)
+	defer os.RemoveAll("./uploads")
+
+	testFile := "./uploads/approved/delete-me.txt"
+	os.WriteFile(testFile, []byte("test content"), 0644)
+
+	user, token := createTestUser(t, "testuser", "password123")
+	_, otherToken := createTestUser(t, "other", "password123")
+	app := setupMediaTestApp()
+
+	media := models.Media{
+		UploaderID:  user.ID,
+		Filename:    "delete-me.txt",
+		ContentType: "text/plain",
+		MediaType:   models.MediaTypeDocument,
+		Size:        12,
+		Status:      models.MediaStatusApproved,
+		StoragePath: testFile,
+	}
+	database.DB.Create(&media)
+
+	// Other users cannot delete it
+	resp, _ := makeRequest(app, testRequest{
+		Method: "DELETE",
+		Path:   "/media/" + media.ID,
+		Token:  otherToken,
+	})
+	assertStatus(t, resp, http.StatusForbidden)
+
+	resp, body := makeRequest(app, testRequest{
+		Method: "DELETE",
+		Path:   "/media/" + media.ID,
+		Token:  token,
+	})
+	assertStatus(t, resp, http.StatusOK)
+	assertJSONField(t, parseResponse(body), "freed_bytes", float64(12))
+
+	if _, err := os.Stat(testFile); !os.IsNotExist(err) {
+		t.Error("Expected file to be removed from disk")
+	}
+
+	used, _ := models.GetStorageUsed(database.DB, user.ID)
+	if used != 0 {
+		t.Errorf("Expected 0 bytes used after delete, got %d", used)
+	}
+}
+
+// createStreamableMedia creates an approved media record backed by a 1000 byte file
+func createStreamableMedia(t *testing.T, uploaderID string) (models.Media, []byte) {
+	t.Helper()
+	os.MkdirAll("./uploads/approved", 0755)
+
+	content := make([]byte, 1000)
+	for i := range content {
+		content[i] = byte(i % 256)
+	}
+	testFile := "./uploads/approved/stream-test.mp4"
+	os.WriteFile(testFile, content, 0644)
+
+	media := models.Media{
+		UploaderID:  uploaderID,
+		Filename:    "stream-test.mp4",
+		ContentType: "video/mp4",
+		MediaType:   models.MediaTypeVideo,
+		Size:        int64(len(content)),
+		Status:      models.MediaStatusApproved,
+		StoragePath: testFile,
+	}
+	database.DB.Create(&media)
+	return media, content
+}
+
+func TestGetMedia_Headers(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	defer os.RemoveAll("./uploads")
+
+	user, token := createTestUser(t, "testuser", "password123")
+	app := setupMediaTestApp()
+	media, content := createStreamableMedia(t, user.ID)
+
+	resp, body := makeRequest(app, testRequest{
+		Method: "GET",
+		Path:   "/media/" + media.ID,
+		Token:  token,
+	})
+
+	assertStatus(t, resp, http.StatusOK)
+	if !bytes.Equal(body, content) {
+		t.Errorf("Expected full file body, got %d bytes", len(body))
+	}
+	if got := resp.Header.Get("Content-Type"); got != "video/mp4" {
+		t.Errorf("Expected Content-Type video/mp4, got %s", got)
+	}
+	if got := resp.Header.Get("Accept-Ranges"); got != "bytes" {
+		t.Errorf("Expected Accept-Ranges bytes, got %s", got)
+	}
+	if resp.Header.Get("ETag") == "" || resp.Header.Get("Last-Modified") == "" {
+		t.Error("Expected ETag and Last-Modified headers")
+	}
+	if got := resp.Header.Get("Cache-Control"); got != "private, max-age=86400" {
+		t.Errorf("Unexpected Cache-Control: %s", got)
+	}
+}
+
+func TestGetMedia_Range(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	defer os.RemoveAll("./uploads")
+
+	user, token := createTestUser(t, "testuser", "password123")
+	app := setupMediaTestApp()
+	media, content := createStreamableMedia(t, user.ID)
+
+	tests := []struct {
+		name         string
+		rangeHeader  string
+		contentRange string
+		expected     []byte
+	}{
+		{"bounded", "bytes=100-199", "bytes 100-199/1000", content[100:200]},
+		{"open ended", "bytes=900-", "bytes 900-999/1000", content[900:]},
+		{"suffix", "bytes=-50", "bytes 950-999/1000", content[950:]},
+		{"end past file", "bytes=990-5000", "bytes 990-999/1000", content[990:]},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, body := makeRequest(app, testRequest{
+				Method:  "GET",
+				Path:    "/media/" + media.ID,
+				Token:   token,
+				Headers: map[string]string{"Range": tt.rangeHeader},
+			})
+
+			assertStatus(t, resp, http.StatusPartialContent)
+			if got := resp.Header.Get("Content-Range"); got != tt.contentRange {
+				t.Errorf("Expected Content-Range %s, got %s", tt.contentRange, got)
+			}
+			if !bytes.Equal(body, tt.expected) {
+				t.Errorf("Expected %d bytes of range body, got %d", len(tt.expected), len(body))
+			}
+		})
+	}
+
+	t.Run("unsatisfiable", func(t *testing.T) {
+		resp, _ := makeRequest(app, testRequest{
+			Method:  "GET",
+			Path:    "/media/" + media.ID,
+			Token:   token,
+			Headers: map[string]string{"Range": "bytes=2000-"},
+		})
+
+		assertStatus(t, resp, http.StatusRequestedRangeNotSatisfiable)
+		if got := resp.Header.Get("Content-Range"); got != "bytes */1000" {
+			t.Errorf("Expected Content-Range bytes */1000, got %s", got)
+		}
+	})
+}
+
+func TestGetMedia_Conditional(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	defer os.RemoveAll("./uploads")
+
+	user, token := createTestUser(t, "testuser", "password123")
+	app := setupMediaTestApp()
+	media, content := createStreamableMedia(t, user.ID)
+
+	resp, _ := makeRequest(app, testRequest{Method: "GET", Path: "/media/" + media.ID, Token: token})
+	etag := resp.Header.Get("ETag")
+
+	t.Run("if-none-match", func(t *testing.T) {
+		resp, body := makeRequest(app, testRequest{
+			Method:  "GET",
+			Path:    "/media/" + media.ID,
+			Token:   token,
+			Headers: map[string]string{"If-None-Match": etag},
+		})
+
+		assertStatus(t, resp, http.StatusNotModified)
+		if len(body) != 0 {
+			t.Errorf("Expected empty body, got %d bytes", len(body))
+		}
+	})
+
+	t.Run("if-range matches", func(t *testing.T) {
+		resp, _ := makeRequest(app, testRequest{
+			Method:  "GET",
+			Path:    "/media/" + media.ID,
+			Token:   token,
+			Headers: map[string]string{"Range": "bytes=0-9", "If-Range": etag},
+		})
+
+		assertStatus(t, resp, http.StatusPartialContent)
+	})
+
+	t.Run("if-range stale", func(t *testing.T) {
+		resp, body := makeRequest(app, testRequest{
+			Method:  "GET",
+			Path:    "/media/" + media.ID,
+			Token:   token,
+			Headers: map[string]string{"Range": "bytes=0-9", "If-Range": `"stale-etag"`},
+		})
+
+		assertStatus(t, resp, http.StatusOK)
+		if !bytes.Equal(body, content) {
+			t.Errorf("Expected full file when If-Range is stale, got %d bytes", len(body))
+		}
+	})
+}
+
+func TestGetHLS_NotAvailable(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	defer os.RemoveAll("./uploads")
+
+	user, token := createTestUser(t, "testuser", "password123")
+	app := setupMediaTestApp()
+	media, _ := createStreamableMedia(t, user.ID)
+
+	resp, _ := makeRequest(app, testRequest{
+		Method: "GET",
+		Path:   "/media/" + media.ID + "/hls/master.m3u8",
+		Token:  token,
+	})
+
+	assertStatus(t, resp, http.StatusNotFound)
+}
+
+func TestGetHLS_ServesPlaylist(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+	defer os.RemoveAll("./uploads")
+
+	user, token := createTestUser(t, "testuser", "password123")
+	app := setupMediaTestApp()
+	media, _ := createStreamableMedia(t, user.ID)
+
+	hlsDir := "./uploads/hls/" + media.ID
+	os.MkdirAll(hlsDir+"/360", 0755)
+	os.WriteFile(hlsDir+"/master.m3u8", []byte("#EXTM3U\n360/index.m3u8\n"), 0644)
+	os.WriteFile(hlsDir+"/360/seg_000.ts", []byte("segment"), 0644)
+	database.DB.Model(&media).Update("hls_path", hlsDir+"/master.m3u8")
+
+	resp, _ := makeRequest(app, testRequest{
+		Method: "GET",
+		Path:   "/media/" + media.ID + "/hls/master.m3u8",
+		Token:  token,
+	})
+	assertStatus(t, resp, http.StatusOK)
+	if got := resp.Header.Get("Content-Type"); got != "application/vnd.apple.mpegurl" {
+		t.Errorf("Unexpected playlist Content-Type: %s", got)
+	}
+
+	resp, _ = makeRequest(app, testRequest{
+		Method: "GET",
+		Path:   "/media/" + media.ID + "/hls/360/seg_000.ts",
+		Token:  token,
+	})
+	assertStatus(t, resp, http.StatusOK)
+	if got := resp.Header.Get("Content-Type"); got != "video/mp2t" {
+		t.Errorf("Unexpected segment Content-Type: %s", got)
+	}
+
+	resp, _ = makeRequest(app, testRequest{
+		Method: "GET",
+		Path:   "/media/" + media.ID + "/hls/..%2F..%2Fapproved%2Fstream-test.mp4",
+		Token:  token,
+	})
+	assertStatus(t, resp, http.StatusNotFound)
+}
@@ -37,6 +37,7 @@ func (h *MessagesHandler) GetHistory(c *fiber.Ctx) error {
 	query := database.DB.
 		Preload("Media").
 		Preload("ReplyTo").
+		Preload("Call.Participants").
 		Where(
 			"group_id IS NULL AND ((sender_id = ? AND recipient_id = ?) OR (sender_id = ? AND recipient_id = ?))",
 			userID, otherUserID, otherUserID, userID,
@@ -64,9 +65,12 @@ func (h *MessagesHandler) GetHistory(c *fiber.Ctx) error {
 	}
 
 	// Mark messages as read
-	database.DB.Model(&models.Message{}).
+	marked := database.DB.Model(&models.Message{}).
 		Where("sender_id = ? AND recipient_id = ? AND status != ?", otherUserID, userID, models.MessageStatusRead).
 		Update("status", models.MessageStatusRead)
+	if marked.RowsAffected > 0 {
+		services.GetPushService().SyncBadge(userID)
+	}
 
 	return c.JSON(fiber.Map{
 		"messages": messages,
@@ -303,8 +307,8 @@ func (h *MessagesHandler) Forward(c *fiber.Ctx) error {
 			continue // Skip self
 		}
 
-		// Check blocking
-		if models.IsEitherBlocked(database.DB, userID, targetUserID) {
+		// Check blocking and erased accounts
+		if models.IsUnreachable(database.DB, userID, targetUserID) {
 			forwardErrors = append(forwardErrors, "Cannot forward to blocked user")
 			continue
 		}
@@ -350,7 +354,7 @@ func (h *MessagesHandler) Forward(c *fiber.Ctx) error {
 			database.DB.Model(message).Update("status", models.MessageStatusDelivered)
 		} else {
 			// Push notification for offline user
-			services.PushMessageToOfflineUser(database.DB, targetUserID, userID, message.Content, false, targetUserID)
+			services.PushMessageToOfflineUser(database.DB, targetUserID, userID, message.ID, message.Content, false, targetUserID)
 		}
 
 		forwardedMessages = append(forwardedMessages, fiber.Map{
@@ -407,7 +411,7 @@ func (h *MessagesHandler) Forward(c *fiber.Ctx) error {
 			// Push to offline group members
 			offlineMembers := h.hub.GetOfflineGroupMemberIDs(groupID, userID)
 			for _, memberID := range offlineMembers {
-				services.PushMessageToOfflineUser(database.DB, memberID, userID, message.Content, true, groupID)
+				services.PushMessageToOfflineUser(database.DB, memberID, userID, message.ID, message.Content, true, groupID)
 			}
 		}
 
@@ -436,20 +440,8 @@ func (h *MessagesHandler) Forward(c *fiber.Ctx) error {
 	return c.JSON(response)
 }
 
-// ExportMessage represents a message in the export format
-type ExportMessage struct {
-	ID            string     `json:"id"`
-	SenderID      string     `json:"sender_id"`
-	SenderName    string     `json:"sender_name"`
-	Content       string     `json:"content"`
-	MediaID       *string    `json:"media_id,omitempty"`
-	MediaURL      string     `json:"media_url,omitempty"`
-	MediaType     string     `json:"media_type,omitempty"`
-	ForwardedFrom *string    `json:"forwarded_from,omitempty"`
-	ReplyToID     *string    `json:"reply_to_id,omitempty"`
-	EditedAt      *time.Time `json:"edited_at,omitempty"`
-	CreatedAt     time.Time  `json:"created_at"`
-}
+// ExportMessage represents a message in the export format, shared with account exports
+type ExportMessage = services.ExportMessage
 
 // ExportConversation represents the full export data
 type ExportConversation struct {
@@ -463,11 +455,7 @@ type ExportConversation struct {
 	Messages      []ExportMessage `json:"messages"`
 }
 
-type ExportUser struct {
-	ID          string `json:"id"`
-	Username    string `json:"username"`
-	DisplayName string `json:"display_name,omitempty"`
-}
+type ExportUser = services.ExportUser
 
 type ExportDateRange struct {
 	From *time.Time `json:"from,omitempty"`
@@ -612,83 +600,62 @@ func (h *MessagesHandler) Export(c *fiber.Ctx) error {
 			}
 		}
 
-		exportMsg := ExportMessage{
-			ID:            msg.ID,
-			SenderID:      msg.SenderID,
-			SenderName:    senderName,
-			Content:       msg.Content,
-			MediaID:       msg.MediaID,
-			ForwardedFrom: msg.ForwardedFrom,
-			ReplyToID:     msg.ReplyToID,
-			EditedAt:      msg.EditedAt,
-			CreatedAt:     msg.CreatedAt,
-		}
-
-		// Include media info if present
-		if msg.Media != nil {
-			exportMsg.MediaURL = msg.Media.URL
-			exportMsg.MediaType = msg.Media.ContentType
-		}
-
-		export.Messages = append(export.Messages, exportMsg)
+		export.Messages = append(export.Messages, services.NewExportMessage(msg, senderName))
 	}
 
 	export.MessageCount = len(export.Messages)
 
 	// Return based on format
 	if format == "txt" {
-		return h.exportAsText(c, export)
+		return h.exportAsText(c, export, services.UserLocale(userID))
 	}
 
 	// Default: JSON
 	return c.JSON(export)
 }
 
-// exportAsText returns the conversation as a plain text file
-func (h *MessagesHandler) exportAsText(c *fiber.Ctx, export ExportConversation) error {
+// exportAsText returns the conversation as a plain text file, with headings
+// in the exporting user's locale
+func (h *MessagesHandler) exportAsText(c *fiber.Ctx, export ExportConversation, locale string) error {
 	var text string
 
 	// Header
-	text += "=== Chat Export ===\n"
-	text += "Exported: " + export.ExportedAt.Format("2006-01-02 15:04:05") + "\n"
-	text += "Exported by: " + export.ExportedBy + "\n"
+	text += services.Localize(locale, "export_title") + "\n"
+	text += services.Localize(locale, "export_exported", export.ExportedAt.Format("2006-01-02 15:04:05")) + "\n"
+	text += services.Localize(locale, "export_exported_by", export.ExportedBy) + "\n"
 
 	if export.Type == "group" {
-		text += "Group: " + export.GroupName + "\n"
+		text += services.Localize(locale, "export_group", export.GroupName) + "\n"
 	} else {
-		text += "Conversation with: "
+		var names string
 		for i, p := range export.Participants {
 			if p.Username != export.ExportedBy {
 				if i > 0 {
-					text += ", "
+					names += ", "
 				}
 				name := p.DisplayName
 				if name == "" {
 					name = p.Username
 				}
-				text += name
+				names += name
 			}
 		}
-		text += "\n"
+		text += services.Localize(locale, "export_conversation_with", names) + "\n"
 	}
 
-	text += "Messages: " + strconv.Itoa(export.MessageCount) + "\n"
+	text += services.Localize(locale, "export_message_count", strconv.Itoa(export.MessageCount)) + "\n"
 	if export.DateRange.From != nil || export.DateRange.To != nil {
-		text += "Date range: "
+		from := services.Localize(locale, "export_range_start")
 		if export.DateRange.From != nil {
-			text += export.DateRange.From.Format("2006-01-02")
-		} else {
-			text += "start"
+			from = export.DateRange.From.Format("2006-01-02")
 		}
-		text += " to "
+		to := services.Localize(locale, "export_range_now")
 		if export.DateRange.To != nil {
-			text += export.DateRange.To.Format("2006-01-02")
-		} else {
-			text += "now"
+			to = export.DateRange.To.Format("2006-01-02")
 		}
-		text += "\n"
+		text += services.Localize(locale, "export_date_range", from, to) + "\n"
 	}
-	text += "\n=== Messages ===\n\n"
+	text += "\n" + services.Localize(locale, "export_messages") + "\n\n"
 
 	// Messages
 	for _, msg := range export.Messages {
@@ -697,10 +664,10 @@ func (h *MessagesHandler) exportAsText(c *fiber.Ctx, export ExportConversation)
 		text += msg.SenderName
 
 		if msg.ForwardedFrom != nil {
-			text += " (forwarded from " + *msg.ForwardedFrom + ")"
+			text += " " + services.Localize(locale, "export_forwarded_from", *msg.ForwardedFrom)
 		}
 		if msg.EditedAt != nil {
-			text += " (edited)"
+			text += " " + services.Localize(locale, "export_edited")
 		}
 		text += ":\n"
 
@@ -711,7 +678,7 @@ func (h *MessagesHandler) exportAsText(c *fiber.Ctx, export ExportConversation)
 
 		// Media
 		if msg.MediaID != nil {
-			text += "[Media: " + msg.MediaType + "]\n"
+			text += services.Localize(locale, "export_media", msg.MediaType) + "\n"
 		}
 
 		text += "\n"
@@ -993,7 +960,7 @@ func (h *MessagesHandler) SendLocation(c *fiber.Ctx) error {
 		}
 	} else {
 		// DM
-		if models.IsEitherBlocked(database.DB, userID, req.UserID) {
+		if models.IsUnreachable(database.DB, userID, req.UserID) {
 			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
 				"error": "Cannot send message to this user",
 			})
@@ -1039,7 +1006,7 @@ func (h *MessagesHandler) SendLocation(c *fiber.Ctx) error {
 			if h.hub.SendToUser(req.UserID, msgBytes) {
 				database.DB.Model(message).Update("status", models.MessageStatusDelivered)
 			} else {
-				services.PushMessageToOfflineUser(database.DB, req.UserID, userID, "📍 Shared a location", false, req.UserID)
+				services.PushMessageToOfflineUser(database.DB, req.UserID, userID, message.ID, services.LocationPushBody, false, req.UserID)
 			}
 		}
 	}
@@ -1108,7 +1075,7 @@ func (h *MessagesHandler) ScheduleMessage(c *fiber.Ctx) error {
 			})
 		}
 	} else {
-		if models.IsEitherBlocked(database.DB, userID, req.UserID) {
+		if models.IsUnreachable(database.DB, userID, req.UserID) {
 			return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
 				"error": "Cannot send message to this user",
 			})
@@ -1,6 +1,10 @@
 package handlers
 
 import (
+	"encoding/json"
+	"errors"
+	"strconv"
+
 	"github.com/gofiber/fiber/v2"
 	"messenger/internal/api/middleware"
 	"messenger/internal/database"
@@ -15,10 +19,19 @@ func NewNotificationsHandler() *NotificationsHandler {
 }
 
 type RegisterTokenRequest struct {
-	Token      string `json:"token" validate:"required"`
-	Platform   string `json:"platform" validate:"required,oneof=ios android web"`
-	DeviceID   string `json:"device_id"`
-	AppVersion string `json:"app_version"`
+	Token        string          `json:"token"`
+	Subscription json.RawMessage `json:"subscription"` // Browser PushSubscription, instead of token, for Web Push
+	Platform     string          `json:"platform" validate:"required,oneof=ios android web"`
+	Provider     string          `json:"provider"` // fcm, apns, apns_voip, webpush, unifiedpush or webhook; inferred from the token if omitted
+	DeviceID     string          `json:"device_id"`
+	AppVersion   string          `json:"app_version"`
+	PushKey      *PushKeyRequest `json:"push_key"` // Key private pushes are encrypted to; omit for content-free pushes
+}
+
+// PushKeyRequest is a device push key in the form of a browser PushSubscription's keys
+type PushKeyRequest struct {
+	P256dh string `json:"p256dh"`
+	Auth   string `json:"auth"`
 }
 
 // RegisterToken registers a device token for push notifications
@@ -32,6 +45,19 @@ func (h *NotificationsHandler) RegisterToken(c *fiber.Ctx) error {
 		})
 	}
 
+	if len(req.Subscription) > 0 && string(req.Subscription) != "null" {
+		subscription, err := services.ParseWebPushSubscription(string(req.Subscription))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		req.Token = subscription
+		if req.Provider == "" {
+			req.Provider = services.ProviderWebPush
+		}
+	}
+
 	if req.Token == "" {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "Token is required",
@@ -47,11 +73,29 @@ func (h *NotificationsHandler) RegisterToken(c *fiber.Ctx) error {
 		})
 	}
 
+	provider, err := services.ResolvePushProvider(platform, req.Provider, req.Token)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	var pushKey PushKeyRequest
+	if req.PushKey != nil {
+		if err := services.ValidatePushKey(req.PushKey.P256dh, req.PushKey.Auth); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		pushKey = *req.PushKey
+	}
+
 	token, err := models.RegisterToken(
 		database.DB,
 		userID,
 		req.Token,
 		platform,
+		provider,
 		req.DeviceID,
 		req.AppVersion,
 	)
@@ -61,10 +105,19 @@ func (h *NotificationsHandler) RegisterToken(c *fiber.Ctx) error {
 		})
 	}
 
+	// Re-registering without a key drops the old one, e.g. after a reinstall
+	if err := models.SetTokenPushKey(database.DB, token.ID, pushKey.P256dh, pushKey.Auth); err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to register token",
+		})
+	}
+
 	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
-		"id":       token.ID,
-		"platform": token.Platform,
-		"message":  "Token registered successfully",
+		"id":           token.ID,
+		"platform":     token.Platform,
+		"provider":     token.Provider,
+		"has_push_key": pushKey.P256dh != "",
+		"message":      "Token registered successfully",
 	})
 }
 
@@ -127,6 +180,31 @@ func (h *NotificationsHandler) GetTokens(c *fiber.Ctx) error {
 	return c.JSON(tokens)
 }
 
+// GetDiagnostics runs an end-to-end push check for the current user: server
+// providers, notification settings, and a silent push to every device, with
+// each device's recent delivery history
+func (h *NotificationsHandler) GetDiagnostics(c *fiber.Ctx) error {
+	userID := middleware.GetUserID(c)
+
+	diagnostics, err := services.GetPushService().RunPushDiagnostics(userID)
+	var cooldown *services.PushDiagnosticsCooldownError
+	if errors.As(err, &cooldown) {
+		retryAfter := int(cooldown.RetryAfter().Seconds())
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(retryAfter))
+		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+			"error":       err.Error(),
+			"retry_after": retryAfter,
+		})
+	}
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to run push diagnostics",
+		})
+	}
+
+	return c.JSON(diagnostics)
+}
+
 // TestNotification sends a test push notification to verify setup
 func (h *NotificationsHandler) TestNotification(c *fiber.Ctx) error {
 	userID := middleware.GetUserID(c)
@@ -139,14 +217,21 @@ func (h *NotificationsHandler) TestNotification(c *fiber.Ctx) error {
 	}
 
 	tokens, err := models.GetUserTokens(database.DB, userID)
-	if err != nil || len(tokens) == 0 {
+	var testToken string
+	for _, token := range tokens {
+		if !services.IsVoIPToken(token) { // VoIP tokens only take calls
+			testToken = token.Token
+			break
+		}
+	}
+	if err != nil || testToken == "" {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
 			"error": "No registered devices found",
 		})
 	}
 
 	// Send test to first token
-	if err := pushService.SendTestNotification(tokens[0].Token); err != nil {
+	if err := pushService.SendTestNotification(testToken, services.UserLocale(userID)); err != nil {
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
 			"error": "Failed to send test notification",
 		})
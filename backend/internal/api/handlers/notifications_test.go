@@ -1,7 +1,11 @@
 package handlers
 
 import (
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
 	"net/http"
+	"strings"
 	"testing"
 
 	"github.com/gofiber/fiber/v2"
@@ -21,6 +25,7 @@ func setupNotificationsTestApp() *fiber.App {
 	notifications.Delete("/all", handler.UnregisterAllTokens)
 	notifications.Get("/tokens", handler.GetTokens)
 	notifications.Post("/test", handler.TestNotification)
+	notifications.Get("/diagnostics", handler.GetDiagnostics)
 
 	return app
 }
@@ -107,6 +112,179 @@ func TestRegisterToken_Web(t *testing.T) {
 	assertJSONField(t, data, "platform", "web")
 }
 
+func TestRegisterToken_WebPushSubscription(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	user, token := createTestUser(t, "testuser", "password123")
+	app := setupNotificationsTestApp()
+
+	resp, body := makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/notifications/register",
+		Body: map[string]interface{}{
+			"platform": "web",
+			"subscription": map[string]interface{}{
+				"endpoint": "https://push.example.com/send/abc",
+				"keys":     map[string]string{"p256dh": "BPub", "auth": "secret"},
+			},
+		},
+		Token: token,
+	})
+
+	assertStatus(t, resp, http.StatusCreated)
+	assertJSONField(t, parseResponse(body), "provider", "webpush")
+
+	var deviceToken models.DeviceToken
+	database.DB.Where("user_id = ?", user.ID).First(&deviceToken)
+	if deviceToken.Provider != "webpush" || !strings.Contains(deviceToken.Token, "push.example.com") {
+		t.Errorf("Expected the subscription to be stored as a webpush token, got %+v", deviceToken)
+	}
+}
+
+func TestRegisterToken_Provider(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	_, token := createTestUser(t, "testuser", "password123")
+	app := setupNotificationsTestApp()
+
+	resp, body := makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/notifications/register",
+		Body: map[string]interface{}{
+			"token":    "android-fcm-token-67890",
+			"platform": "android",
+		},
+		Token: token,
+	})
+	assertStatus(t, resp, http.StatusCreated)
+	assertJSONField(t, parseResponse(body), "provider", "fcm")
+
+	resp, _ = makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/notifications/register",
+		Body: map[string]interface{}{
+			"token":    "android-token",
+			"platform": "android",
+			"provider": "apns",
+		},
+		Token: token,
+	})
+	assertStatus(t, resp, http.StatusBadRequest)
+
+	resp, _ = makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/notifications/register",
+		Body: map[string]interface{}{
+			"token":    "web-token",
+			"platform": "web",
+			"provider": "webpush",
+		},
+		Token: token,
+	})
+	assertStatus(t, resp, http.StatusBadRequest)
+	resp, body = makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/notifications/register",
+		Body: map[string]interface{}{
+			"token":    "https://ntfy.example.com/upAbC123",
+			"platform": "android",
+		},
+		Token: token,
+	})
+	assertStatus(t, resp, http.StatusCreated)
+	assertJSONField(t, parseResponse(body), "provider", "unifiedpush")
+
+	resp, _ = makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/notifications/register",
+		Body: map[string]interface{}{
+			"token":    "https://127.0.0.1/up",
+			"platform": "android",
+			"provider": "unifiedpush",
+		},
+		Token: token,
+	})
+	assertStatus(t, resp, http.StatusBadRequest)
+
+	resp, body = makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/notifications/register",
+		Body: map[string]interface{}{
+			"token":     "a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718293a4b5c6d7e8f90",
+			"platform":  "ios",
+			"provider":  "apns_voip",
+			"device_id": "iphone-1",
+		},
+		Token: token,
+	})
+	assertStatus(t, resp, http.StatusCreated)
+	assertJSONField(t, parseResponse(body), "provider", "apns_voip")
+}
+
+func TestRegisterToken_PushKey(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	_, token := createTestUser(t, "testuser", "password123")
+	app := setupNotificationsTestApp()
+
+	deviceKey, _ := ecdh.P256().GenerateKey(rand.Reader)
+	pushKey := map[string]interface{}{
+		"p256dh": base64.RawURLEncoding.EncodeToString(deviceKey.PublicKey().Bytes()),
+		"auth":   base64.RawURLEncoding.EncodeToString([]byte("0123456789abcdef")),
+	}
+
+	resp, body := makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/notifications/register",
+		Body: map[string]interface{}{
+			"token":    "android-fcm-token",
+			"platform": "android",
+			"push_key": pushKey,
+		},
+		Token: token,
+	})
+	assertStatus(t, resp, http.StatusCreated)
+	assertJSONField(t, parseResponse(body), "has_push_key", true)
+
+	var stored models.DeviceToken
+	database.DB.First(&stored, "token = ?", "android-fcm-token")
+	if stored.PushKey != pushKey["p256dh"] || stored.PushAuth != pushKey["auth"] {
+		t.Error("Push key should be stored with the token")
+	}
+
+	// Re-registering without a key drops it
+	resp, body = makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/notifications/register",
+		Body: map[string]interface{}{
+			"token":    "android-fcm-token",
+			"platform": "android",
+		},
+		Token: token,
+	})
+	assertStatus(t, resp, http.StatusCreated)
+	assertJSONField(t, parseResponse(body), "has_push_key", false)
+	database.DB.First(&stored, "token = ?", "android-fcm-token")
+	if stored.PushKey != "" || stored.PushAuth != "" {
+		t.Error("Push key should be removed")
+	}
+
+	resp, _ = makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/notifications/register",
+		Body: map[string]interface{}{
+			"token":    "android-fcm-token",
+			"platform": "android",
+			"push_key": map[string]interface{}{"p256dh": "not-a-key", "auth": "short"},
+		},
+		Token: token,
+	})
+	assertStatus(t, resp, http.StatusBadRequest)
+}
+
 func TestRegisterToken_MissingToken(t *testing.T) {
 	cleanup := setupTestDB(t)
 	defer cleanup()
@@ -364,3 +542,65 @@ func TestTestNotification_PushNotConfigured(t *testing.T) {
 	data := parseResponse(body)
 	assertJSONFieldExists(t, data, "error")
 }
+
+func TestGetDiagnostics_PushNotConfigured(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	user, token := createTestUser(t, "testuser", "password123")
+	app := setupNotificationsTestApp()
+
+	models.RegisterToken(database.DB, user.ID, "fcm-token", models.PlatformAndroid, "fcm", "", "")
+
+	resp, body := makeRequest(app, testRequest{
+		Method: "GET",
+		Path:   "/notifications/diagnostics",
+		Token:  token,
+	})
+	assertStatus(t, resp, http.StatusOK)
+
+	data := parseResponse(body)
+	assertJSONField(t, data, "push_enabled", false)
+	assertJSONFieldExists(t, data, "check_id")
+
+	issues, _ := data["issues"].([]interface{})
+	found := map[string]bool{}
+	for _, issue := range issues {
+		found[issue.(string)] = true
+	}
+	if !found["push_disabled"] || !found["provider_unavailable"] {
+		t.Errorf("Expected push_disabled and provider_unavailable, got %v", issues)
+	}
+
+	devices, _ := data["devices"].([]interface{})
+	if len(devices) != 1 {
+		t.Fatalf("Expected 1 device, got %d", len(devices))
+	}
+	check := devices[0].(map[string]interface{})["check"].(map[string]interface{})
+	if check["outcome"] != "unroutable" {
+		t.Errorf("Expected an unroutable check, got %v", check["outcome"])
+	}
+}
+
+func TestGetDiagnostics_Cooldown(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	_, token := createTestUser(t, "testuser", "password123")
+	app := setupNotificationsTestApp()
+
+	request := testRequest{
+		Method: "GET",
+		Path:   "/notifications/diagnostics",
+		Token:  token,
+	}
+	resp, _ := makeRequest(app, request)
+	assertStatus(t, resp, http.StatusOK)
+
+	resp, body := makeRequest(app, request)
+	assertStatus(t, resp, http.StatusTooManyRequests)
+	if resp.Header.Get("Retry-After") == "" {
+		t.Error("Expected a Retry-After header")
+	}
+	assertJSONFieldExists(t, parseResponse(body), "retry_after")
+}
@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"messenger/internal/api/middleware"
+	"messenger/internal/services"
+)
+
+type OIDCHandler struct {
+	oidcService *services.OIDCService
+}
+
+func NewOIDCHandler() *OIDCHandler {
+	return &OIDCHandler{
+		oidcService: services.NewOIDCService(),
+	}
+}
+
+// NewOIDCHandlerWithService creates a handler with an explicitly configured service
+func NewOIDCHandlerWithService(service *services.OIDCService) *OIDCHandler {
+	return &OIDCHandler{oidcService: service}
+}
+
+// Authorize returns the identity provider URL the client should open to sign in
+func (h *OIDCHandler) Authorize(c *fiber.Ctx) error {
+	auth, err := h.oidcService.AuthorizationURL(c.Context())
+	if err != nil {
+		return oidcError(c, err)
+	}
+	return c.JSON(auth)
+}
+
+// Callback completes a sign-in with the code and state from the provider's redirect
+func (h *OIDCHandler) Callback(c *fiber.Ctx) error {
+	var input services.OIDCCallbackInput
+	if err := c.BodyParser(&input); err != nil || input.Code == "" || input.State == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Code and state are required",
+		})
+	}
+
+	input.Meta = sessionMeta(c)
+	response, err := h.oidcService.Callback(c.Context(), input)
+	if err != nil {
+		return oidcError(c, err)
+	}
+	return c.JSON(response)
+}
+
+// Link returns a provider URL that links the identity to the current user
+func (h *OIDCHandler) Link(c *fiber.Ctx) error {
+	auth, err := h.oidcService.LinkAuthorizationURL(c.Context(), middleware.GetUserID(c), middleware.GetSessionID(c))
+	if err != nil {
+		return oidcError(c, err)
+	}
+	return c.JSON(auth)
+}
+
+// LinkCallback completes a link with the code and state from the provider's
+// redirect. It must be called from the session that started the link.
+func (h *OIDCHandler) LinkCallback(c *fiber.Ctx) error {
+	var input services.OIDCCallbackInput
+	if err := c.BodyParser(&input); err != nil || input.Code == "" || input.State == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Code and state are required",
+		})
+	}
+
+	identities, err := h.oidcService.CompleteLink(c.Context(), input, middleware.GetUserID(c), middleware.GetSessionID(c))
+	if err != nil {
+		return oidcError(c, err)
+	}
+	return c.JSON(fiber.Map{
+		"identities": identities,
+	})
+}
+
+// Identities lists the provider identities linked to the current user
+func (h *OIDCHandler) Identities(c *fiber.Ctx) error {
+	identities, err := h.oidcService.LinkedIdentities(middleware.GetUserID(c))
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to fetch identities",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"enabled":    h.oidcService.Enabled(),
+		"identities": identities,
+	})
+}
+
+func oidcError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, services.ErrOIDCDisabled):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, services.ErrOIDCInvalidState), errors.Is(err, services.ErrOIDCExchangeFailed),
+		errors.Is(err, services.ErrOIDCInvalidIDToken), errors.Is(err, services.ErrOIDCNoAccount):
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, services.ErrOIDCIdentityLinked):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, services.ErrOIDCProviderUnavail):
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": err.Error(),
+	})
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"messenger/internal/api/middleware"
+	"messenger/internal/services"
+)
+
+func TestOIDCHandler_NotConfigured(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	app := fiber.New()
+	handler := NewOIDCHandlerWithService(services.NewOIDCServiceWithConfig(services.OIDCConfig{}))
+	app.Get("/auth/oidc/authorize", handler.Authorize)
+	app.Post("/auth/oidc/callback", handler.Callback)
+	protected := app.Group("", middleware.AuthRequired())
+	protected.Get("/auth/oidc/identities", handler.Identities)
+
+	_, token := createTestUser(t, "ssouser", "password123")
+
+	t.Run("authorize returns not found", func(t *testing.T) {
+		resp, _ := makeRequest(app, testRequest{Method: "GET", Path: "/auth/oidc/authorize"})
+		assertStatus(t, resp, http.StatusNotFound)
+	})
+
+	t.Run("callback requires code and state", func(t *testing.T) {
+		resp, _ := makeRequest(app, testRequest{
+			Method: "POST",
+			Path:   "/auth/oidc/callback",
+			Body:   map[string]interface{}{"code": "abc"},
+		})
+		assertStatus(t, resp, http.StatusBadRequest)
+	})
+
+	t.Run("identities reports disabled", func(t *testing.T) {
+		resp, body := makeRequest(app, testRequest{Method: "GET", Path: "/auth/oidc/identities", Token: token})
+		assertStatus(t, resp, http.StatusOK)
+		data := parseResponse(body)
+		assertJSONField(t, data, "enabled", false)
+		if identities := data["identities"].([]interface{}); len(identities) != 0 {
+			t.Errorf("Expected no identities, got %d", len(identities))
+		}
+	})
+}
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"messenger/internal/api/middleware"
+	"messenger/internal/services"
+)
+
+type PhoneHandler struct {
+	authService  *services.AuthService
+	phoneService *services.PhoneVerificationService
+}
+
+func NewPhoneHandler() *PhoneHandler {
+	return &PhoneHandler{
+		authService:  services.NewAuthService(),
+		phoneService: services.NewPhoneVerificationService(),
+	}
+}
+
+type phoneInput struct {
+	Phone string `json:"phone"`
+	Code  string `json:"code,omitempty"`
+}
+
+// Status returns the current user's phone number and whether it is verified
+func (h *PhoneHandler) Status(c *fiber.Ctx) error {
+	user, err := services.GetUserByID(middleware.GetUserID(c))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"phone":         user.Phone,
+		"verified":      user.HasVerifiedPhone(),
+		"verified_at":   user.PhoneVerifiedAt,
+		"login_enabled": h.phoneService.LoginEnabled(),
+	})
+}
+
+// RequestVerification sends a verification code to a phone number
+func (h *PhoneHandler) RequestVerification(c *fiber.Ctx) error {
+	var input phoneInput
+	if err := c.BodyParser(&input); err != nil || input.Phone == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Phone number is required",
+		})
+	}
+
+	if err := h.phoneService.RequestVerification(c.Context(), middleware.GetUserID(c), input.Phone); err != nil {
+		return phoneError(c, err)
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"message": "Verification code sent",
+	})
+}
+
+// ConfirmVerification checks the code and marks the number as verified
+func (h *PhoneHandler) ConfirmVerification(c *fiber.Ctx) error {
+	var input phoneInput
+	if err := c.BodyParser(&input); err != nil || input.Phone == "" || input.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Phone number and code are required",
+		})
+	}
+
+	user, err := h.phoneService.ConfirmVerification(middleware.GetUserID(c), input.Phone, input.Code)
+	if err != nil {
+		return phoneError(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"phone":       user.Phone,
+		"verified":    true,
+		"verified_at": user.PhoneVerifiedAt,
+	})
+}
+
+// RequestLoginCode sends a login code to a verified number. The response does
+// not reveal whether an account uses the number.
+func (h *PhoneHandler) RequestLoginCode(c *fiber.Ctx) error {
+	var input phoneInput
+	if err := c.BodyParser(&input); err != nil || input.Phone == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Phone number is required",
+		})
+	}
+
+	if err := h.phoneService.RequestLoginCode(input.Phone); err != nil {
+		return phoneError(c, err)
+	}
+
+	return c.Status(fiber.StatusAccepted).JSON(fiber.Map{
+		"message": "If this number belongs to an account, a login code has been sent",
+	})
+}
+
+// Login exchanges a phone number and login code for a session
+func (h *PhoneHandler) Login(c *fiber.Ctx) error {
+	var input services.PhoneLoginInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if input.Phone == "" || input.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Phone number and code are required",
+		})
+	}
+
+	input.Meta = sessionMeta(c)
+	response, err := h.authService.PhoneLogin(input)
+	if errors.Is(err, services.ErrAccountLocked) {
+		return loginError(c, err)
+	}
+	if err != nil {
+		return phoneError(c, err)
+	}
+
+	return c.JSON(response)
+}
+
+func phoneError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, services.ErrInvalidPhone):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, services.ErrPhoneTaken):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, services.ErrInvalidOTP), errors.Is(err, services.ErrOTPTooManyAttempts):
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, services.ErrOTPRateLimited):
+		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, services.ErrPhoneLoginDisabled):
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, services.ErrSMSNotConfigured):
+		return c.Status(fiber.StatusServiceUnavailable).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, services.ErrSMSDeliveryFailed):
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": err.Error(),
+	})
+}
@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"messenger/internal/api/middleware"
+	"messenger/internal/database"
+	"messenger/internal/models"
+	"messenger/internal/services"
+	"messenger/internal/websocket"
+)
+
+var smsCodePattern = regexp.MustCompile(`\b(\d{6})\b`)
+
+func setupPhoneTestApp(t *testing.T) (*fiber.App, *services.LogSMSSender) {
+	t.Helper()
+	t.Setenv("PHONE_LOGIN_ENABLED", "true")
+	sender := services.NewMemorySMSSender()
+	services.SetSMSSender(sender)
+	t.Cleanup(func() { services.SetSMSSender(nil) })
+
+	app := fiber.New()
+	handler := NewPhoneHandler()
+	app.Post("/auth/phone/login/request", handler.RequestLoginCode)
+	app.Post("/auth/phone/login", handler.Login)
+
+	protected := app.Group("", middleware.AuthRequired())
+	protected.Get("/auth/phone", handler.Status)
+	protected.Post("/auth/phone/verify/request", handler.RequestVerification)
+	protected.Post("/auth/phone/verify", handler.ConfirmVerification)
+	protected.Get("/users/search", NewContactsHandler(websocket.NewHub()).SearchUsers)
+
+	return app, sender
+}
+
+// lastSMSCode returns the code in the last SMS to a number, waiting for
+// login codes, which are sent in the background
+func lastSMSCode(t *testing.T, sender *services.LogSMSSender, phone string) string {
+	t.Helper()
+	msg, ok := sender.LastMessage(phone)
+	for deadline := time.Now().Add(5 * time.Second); !ok && time.Now().Before(deadline); {
+		time.Sleep(20 * time.Millisecond)
+		msg, ok = sender.LastMessage(phone)
+	}
+	if !ok {
+		t.Fatalf("No SMS sent to %s", phone)
+	}
+	return smsCodePattern.FindStringSubmatch(msg.Message)[1]
+}
+
+func TestPhoneHandler_Verification(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	app, sender := setupPhoneTestApp(t)
+	_, token := createTestUser(t, "phoneowner", "password123")
+	_, searcherToken := createTestUser(t, "phonesearcher", "password123")
+
+	t.Run("invalid number", func(t *testing.T) {
+		resp, _ := makeRequest(app, testRequest{
+			Method: "POST",
+			Path:   "/auth/phone/verify/request",
+			Token:  token,
+			Body:   map[string]interface{}{"phone": "4155550123"},
+		})
+		assertStatus(t, resp, http.StatusBadRequest)
+	})
+
+	resp, _ := makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/auth/phone/verify/request",
+		Token:  token,
+		Body:   map[string]interface{}{"phone": "+1 415 555 0142"},
+	})
+	assertStatus(t, resp, http.StatusAccepted)
+
+	t.Run("resend too soon is rate limited", func(t *testing.T) {
+		resp, _ := makeRequest(app, testRequest{
+			Method: "POST",
+			Path:   "/auth/phone/verify/request",
+			Token:  token,
+			Body:   map[string]interface{}{"phone": "+14155550142"},
+		})
+		assertStatus(t, resp, http.StatusTooManyRequests)
+	})
+
+	t.Run("wrong code", func(t *testing.T) {
+		resp, _ := makeRequest(app, testRequest{
+			Method: "POST",
+			Path:   "/auth/phone/verify",
+			Token:  token,
+			Body:   map[string]interface{}{"phone": "+14155550142", "code": "abcdef"},
+		})
+		assertStatus(t, resp, http.StatusUnauthorized)
+	})
+
+	t.Run("correct code verifies the number", func(t *testing.T) {
+		resp, body := makeRequest(app, testRequest{
+			Method: "POST",
+			Path:   "/auth/phone/verify",
+			Token:  token,
+			Body:   map[string]interface{}{"phone": "+14155550142", "code": lastSMSCode(t, sender, "+14155550142")},
+		})
+		assertStatus(t, resp, http.StatusOK)
+		assertJSONField(t, parseResponse(body), "verified", true)
+
+		resp, body = makeRequest(app, testRequest{Method: "GET", Path: "/auth/phone", Token: token})
+		assertStatus(t, resp, http.StatusOK)
+		data := parseResponse(body)
+		assertJSONField(t, data, "phone", "+14155550142")
+		assertJSONField(t, data, "verified", true)
+	})
+
+	t.Run("verified number is discoverable", func(t *testing.T) {
+		resp, body := makeRequest(app, testRequest{
+			Method: "GET",
+			Path:   "/users/search?q=" + url.QueryEscape("+14155550142"),
+			Token:  searcherToken,
+		})
+		assertStatus(t, resp, http.StatusOK)
+		users := parseResponse(body)["users"].([]interface{})
+		if len(users) != 1 {
+			t.Fatalf("Expected 1 user, got %d", len(users))
+		}
+	})
+
+	t.Run("unverified number is not discoverable", func(t *testing.T) {
+		phone := "+14155550143"
+		database.DB.Model(&models.User{}).Where("username = ?", "phonesearcher").Update("phone", phone)
+
+		_, body := makeRequest(app, testRequest{
+			Method: "GET",
+			Path:   "/users/search?q=" + url.QueryEscape(phone),
+			Token:  token,
+		})
+		if users := parseResponse(body)["users"].([]interface{}); len(users) != 0 {
+			t.Errorf("Expected no users, got %d", len(users))
+		}
+	})
+}
+
+func TestPhoneHandler_Login(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	app, sender := setupPhoneTestApp(t)
+	user, _ := createTestUser(t, "phoneloginuser", "password123")
+	now := time.Now()
+	database.DB.Model(user).Updates(map[string]interface{}{"phone": "+14155550160", "phone_verified_at": now})
+
+	resp, unknownBody := makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/auth/phone/login/request",
+		Body:   map[string]interface{}{"phone": "+14155550161"},
+	})
+	assertStatus(t, resp, http.StatusAccepted)
+	assertJSONFieldExists(t, parseResponse(unknownBody), "message")
+
+	resp, knownBody := makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/auth/phone/login/request",
+		Body:   map[string]interface{}{"phone": "+14155550160"},
+	})
+	assertStatus(t, resp, http.StatusAccepted)
+	if string(unknownBody) != string(knownBody) {
+		t.Errorf("Responses should not reveal whether the number has an account: %s vs %s", unknownBody, knownBody)
+	}
+
+	t.Run("wrong code", func(t *testing.T) {
+		resp, _ := makeRequest(app, testRequest{
+			Method: "POST",
+			Path:   "/auth/phone/login",
+			Body:   map[string]interface{}{"phone": "+14155550160", "code": "abcdef"},
+		})
+		assertStatus(t, resp, http.StatusUnauthorized)
+	})
+
+	t.Run("code logs in", func(t *testing.T) {
+		resp, body := makeRequest(app, testRequest{
+			Method: "POST",
+			Path:   "/auth/phone/login",
+			Body: map[string]interface{}{
+				"phone":       "+14155550160",
+				"code":        lastSMSCode(t, sender, "+14155550160"),
+				"device_name": "Phone",
+			},
+		})
+		assertStatus(t, resp, http.StatusOK)
+		data := parseResponse(body)
+		assertJSONFieldExists(t, data, "access_token")
+		assertJSONFieldExists(t, data, "refresh_token")
+	})
+}
@@ -5,6 +5,7 @@ import (
 	"messenger/internal/api/middleware"
 	"messenger/internal/database"
 	"messenger/internal/models"
+	"messenger/internal/services"
 	"messenger/internal/websocket"
 )
 
@@ -16,6 +17,20 @@ func NewProfileHandler(hub *websocket.Hub) *ProfileHandler {
 	return &ProfileHandler{hub: hub}
 }
 
+// OwnProfileResponse is the current user's profile, with the settings only
+// they can see
+type OwnProfileResponse struct {
+	models.UserResponse
+	Locale string `json:"locale"`
+}
+
+func ownProfileResponse(user *models.User) OwnProfileResponse {
+	return OwnProfileResponse{
+		UserResponse: user.ToResponse(true),
+		Locale:       services.NormalizeLocale(user.Locale),
+	}
+}
+
 // GetProfile returns the current user's profile
 func (h *ProfileHandler) GetProfile(c *fiber.Ctx) error {
 	userID := middleware.GetUserID(c)
@@ -27,7 +42,7 @@ func (h *ProfileHandler) GetProfile(c *fiber.Ctx) error {
 		})
 	}
 
-	return c.JSON(user.ToResponse(true))
+	return c.JSON(ownProfileResponse(&user))
 }
 
 // GetUserProfile returns another user's profile
@@ -49,6 +64,7 @@ type UpdateProfileRequest struct {
 	DisplayName *string `json:"display_name,omitempty"`
 	About       *string `json:"about,omitempty"`
 	StatusEmoji *string `json:"status_emoji,omitempty"`
+	Locale      *string `json:"locale,omitempty"` // Language of notifications and other server-generated text
 }
 
 // UpdateProfile updates the current user's profile
@@ -77,6 +93,16 @@ func (h *ProfileHandler) UpdateProfile(c *fiber.Ctx) error {
 	if req.StatusEmoji != nil {
 		updates["status_emoji"] = *req.StatusEmoji
 	}
+	if req.Locale != nil {
+		locale, ok := services.ParseLocale(*req.Locale)
+		if !ok {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error":             "Unsupported locale",
+				"supported_locales": services.SupportedLocales,
+			})
+		}
+		updates["locale"] = locale
+	}
 
 	if len(updates) == 0 {
 		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
@@ -97,7 +123,7 @@ func (h *ProfileHandler) UpdateProfile(c *fiber.Ctx) error {
 	// Broadcast profile update to contacts
 	h.broadcastProfileUpdate(&user)
 
-	return c.JSON(user.ToResponse(true))
+	return c.JSON(ownProfileResponse(&user))
 }
 
 func (h *ProfileHandler) broadcastProfileUpdate(user *models.User) {
@@ -214,6 +214,25 @@ func TestProfileHandler_UpdateProfile(t *testing.T) {
 				assertJSONField(t, data, "status_emoji", "🎉")
 			},
 		},
+		{
+			name: "update locale",
+			body: map[string]interface{}{
+				"locale": "de-AT",
+			},
+			token:          token,
+			expectedStatus: http.StatusOK,
+			checkResponse: func(t *testing.T, data map[string]interface{}) {
+				assertJSONField(t, data, "locale", "de")
+			},
+		},
+		{
+			name: "unsupported locale",
+			body: map[string]interface{}{
+				"locale": "tlh",
+			},
+			token:          token,
+			expectedStatus: http.StatusBadRequest,
+		},
 		{
 			name:           "no updates provided",
 			body:           map[string]interface{}{},
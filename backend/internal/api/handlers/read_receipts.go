@@ -7,6 +7,7 @@ import (
 	"messenger/internal/api/middleware"
 	"messenger/internal/database"
 	"messenger/internal/models"
+	"messenger/internal/services"
 	"messenger/internal/websocket"
 )
 
@@ -50,6 +51,9 @@ func (h *ReadReceiptHandler) MarkRead(c *fiber.Ctx) error {
 	// Broadcast read receipts
 	h.broadcastReadReceipts(userID, req.MessageIDs, req.GroupID)
 
+	// Update the badge on the user's other devices
+	services.GetPushService().SyncBadge(userID)
+
 	return c.JSON(fiber.Map{
 		"success": true,
 	})
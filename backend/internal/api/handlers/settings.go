@@ -1,12 +1,14 @@
 package handlers
 
 import (
+	"errors"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"messenger/internal/api/middleware"
 	"messenger/internal/database"
 	"messenger/internal/models"
+	"messenger/internal/services"
 )
 
 type SettingsHandler struct{}
@@ -166,3 +168,96 @@ func (h *SettingsHandler) MuteConversation(c *fiber.Ctx) error {
 		"muted_until": mutedUntil,
 	})
 }
+
+type MentionsOnlyRequest struct {
+	OtherUserID *string `json:"other_user_id,omitempty"`
+	GroupID     *string `json:"group_id,omitempty"`
+	Enabled     bool    `json:"enabled"`
+}
+
+// SetMentionsOnly limits push notifications for a conversation to messages
+// that mention the user
+func (h *SettingsHandler) SetMentionsOnly(c *fiber.Ctx) error {
+	userID := middleware.GetUserID(c)
+
+	var req MentionsOnlyRequest
+	if err := c.BodyParser(&req); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if req.OtherUserID == nil && req.GroupID == nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Either other_user_id or group_id is required",
+		})
+	}
+
+	var settings *models.ConversationSettings
+	var err error
+
+	if req.OtherUserID != nil {
+		settings, err = models.GetOrCreateDMSettings(database.DB, userID, *req.OtherUserID)
+	} else {
+		settings, err = models.GetOrCreateGroupSettings(database.DB, userID, *req.GroupID)
+	}
+
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get settings",
+		})
+	}
+
+	if err := database.DB.Model(settings).Update("mentions_only", req.Enabled).Error; err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update notification settings",
+		})
+	}
+
+	return c.JSON(fiber.Map{
+		"success":       true,
+		"mentions_only": req.Enabled,
+	})
+}
+
+// GetNotificationPreferences returns the user's do-not-disturb schedule,
+// timezone and preview settings
+func (h *SettingsHandler) GetNotificationPreferences(c *fiber.Ctx) error {
+	userID := middleware.GetUserID(c)
+
+	prefs, err := services.GetNotificationPreferences(userID)
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to get notification settings",
+		})
+	}
+
+	return c.JSON(prefs)
+}
+
+// UpdateNotificationPreferences changes the user's do-not-disturb schedule,
+// timezone and preview settings. Fields left out are unchanged.
+func (h *SettingsHandler) UpdateNotificationPreferences(c *fiber.Ctx) error {
+	userID := middleware.GetUserID(c)
+
+	var input services.NotificationPreferencesInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	prefs, err := services.UpdateNotificationPreferences(userID, input)
+	if err != nil {
+		if errors.Is(err, services.ErrInvalidTimezone) || errors.Is(err, services.ErrInvalidDNDTime) {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to update notification settings",
+		})
+	}
+
+	return c.JSON(prefs)
+}
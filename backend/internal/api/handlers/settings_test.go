@@ -365,3 +365,102 @@ func TestSettingsHandler_MuteConversation_Group(t *testing.T) {
 	assertJSONField(t, data, "muted", true)
 	assertJSONFieldExists(t, data, "muted_until")
 }
+
+func TestSettingsHandler_SetMentionsOnly(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	app := fiber.New()
+	handler := NewSettingsHandler()
+
+	app.Use(middleware.AuthRequired())
+	app.Post("/settings/mentions-only", handler.SetMentionsOnly)
+
+	user, token := createTestUser(t, "mentionsuser", "password123")
+	groupID := "group-mentions"
+
+	resp, body := makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/settings/mentions-only",
+		Body: map[string]interface{}{
+			"group_id": groupID,
+			"enabled":  true,
+		},
+		Token: token,
+	})
+	assertStatus(t, resp, http.StatusOK)
+	assertJSONField(t, parseResponse(body), "mentions_only", true)
+
+	settings := models.FindConversationSettings(database.DB, user.ID, nil, &groupID)
+	if settings == nil || !settings.MentionsOnly {
+		t.Error("Expected mentions-only to be saved")
+	}
+
+	resp, _ = makeRequest(app, testRequest{
+		Method: "POST",
+		Path:   "/settings/mentions-only",
+		Body:   map[string]interface{}{"enabled": true},
+		Token:  token,
+	})
+	assertStatus(t, resp, http.StatusBadRequest)
+}
+
+func TestSettingsHandler_NotificationPreferences(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	app := fiber.New()
+	handler := NewSettingsHandler()
+
+	app.Use(middleware.AuthRequired())
+	app.Get("/settings/notifications", handler.GetNotificationPreferences)
+	app.Put("/settings/notifications", handler.UpdateNotificationPreferences)
+
+	_, token := createTestUser(t, "dnduser", "password123")
+
+	resp, body := makeRequest(app, testRequest{
+		Method: "GET",
+		Path:   "/settings/notifications",
+		Token:  token,
+	})
+	assertStatus(t, resp, http.StatusOK)
+	data := parseResponse(body)
+	assertJSONField(t, data, "dnd_enabled", false)
+	assertJSONField(t, data, "dnd_start", models.DefaultDNDStart)
+
+	resp, body = makeRequest(app, testRequest{
+		Method: "PUT",
+		Path:   "/settings/notifications",
+		Body: map[string]interface{}{
+			"dnd_enabled":    true,
+			"dnd_start":      "23:30",
+			"timezone":       "Europe/Berlin",
+			"hide_previews":  true,
+			"private_pushes": true,
+		},
+		Token: token,
+	})
+	assertStatus(t, resp, http.StatusOK)
+	data = parseResponse(body)
+	assertJSONField(t, data, "dnd_start", "23:30")
+	assertJSONField(t, data, "dnd_end", models.DefaultDNDEnd)
+	assertJSONField(t, data, "timezone", "Europe/Berlin")
+	assertJSONField(t, data, "hide_previews", true)
+	assertJSONField(t, data, "private_pushes", true)
+
+	resp, _ = makeRequest(app, testRequest{
+		Method: "PUT",
+		Path:   "/settings/notifications",
+		Body:   map[string]interface{}{"timezone": "Mars/Olympus_Mons"},
+		Token:  token,
+	})
+	assertStatus(t, resp, http.StatusBadRequest)
+
+	resp, _ = makeRequest(app, testRequest{
+		Method: "PUT",
+		Path:   "/settings/notifications",
+		Body:   map[string]interface{}{"dnd_end": "25:00"},
+		Token:  token,
+	})
+	assertStatus(t, resp, http.StatusBadRequest)
+}
@@ -52,6 +52,31 @@ func setupTestDB(t *testing.T) func() {
 		&models.StoryView{},
 		&models.LinkPreview{},
 		&models.DeviceToken{},
+		&models.StorageQuota{},
+		&models.Session{},
+		&models.RefreshToken{},
+		&models.TwoFactor{},
+		&models.RecoveryCode{},
+		&models.TwoFactorChallenge{},
+		&models.PhoneVerification{},
+		&models.PasswordResetToken{},
+		&models.ExternalIdentity{},
+		&models.OIDCAuthRequest{},
+		&models.SigningKey{},
+		&models.WebSocketTicket{},
+		&models.DeviceLinkRequest{},
+		&models.AccountExport{},
+		&models.AccountDeletion{},
+		&models.EncryptionDevice{},
+		&models.LoginEvent{},
+		&models.LoginLockout{},
+		&models.NotificationPreferences{},
+		&models.PushJob{},
+		&models.PushBurst{},
+		&models.PushDeadLetter{},
+		&models.PushAttempt{},
+		&models.Call{},
+		&models.CallParticipant{},
 	)
 	if err != nil {
 		t.Fatalf("Failed to migrate test database: %v", err)
@@ -0,0 +1,181 @@
+package handlers
+
+import (
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+	"messenger/internal/api/middleware"
+	"messenger/internal/services"
+)
+
+type TwoFactorHandler struct {
+	authService      *services.AuthService
+	twoFactorService *services.TwoFactorService
+}
+
+func NewTwoFactorHandler() *TwoFactorHandler {
+	return &TwoFactorHandler{
+		authService:      services.NewAuthService(),
+		twoFactorService: services.NewTwoFactorService(),
+	}
+}
+
+// reauthInput is required for sensitive 2FA changes
+type reauthInput struct {
+	Password string `json:"password"`
+	Code     string `json:"code"` // TOTP or recovery code
+}
+
+// Status returns whether 2FA is enabled for the current user
+func (h *TwoFactorHandler) Status(c *fiber.Ctx) error {
+	user, err := services.GetUserByID(middleware.GetUserID(c))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	return c.JSON(h.twoFactorService.Status(user))
+}
+
+// Setup starts enrolment and returns the secret and otpauth URI to show as a QR code
+func (h *TwoFactorHandler) Setup(c *fiber.Ctx) error {
+	user, err := services.GetUserByID(middleware.GetUserID(c))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	setup, err := h.twoFactorService.BeginEnrolment(user)
+	if err != nil {
+		if errors.Is(err, services.ErrTwoFactorAlreadyEnabled) {
+			return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+				"error": err.Error(),
+			})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+
+	return c.JSON(setup)
+}
+
+// Confirm enables 2FA after the user enters a code from their authenticator.
+// The recovery codes are only returned here.
+func (h *TwoFactorHandler) Confirm(c *fiber.Ctx) error {
+	var input struct {
+		Code string `json:"code"`
+	}
+	if err := c.BodyParser(&input); err != nil || input.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Code is required",
+		})
+	}
+
+	codes, err := h.twoFactorService.ConfirmEnrolment(middleware.GetUserID(c), input.Code)
+	if err != nil {
+		return twoFactorError(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"enabled":        true,
+		"recovery_codes": codes,
+	})
+}
+
+// Disable turns off 2FA; requires the password and a current code
+func (h *TwoFactorHandler) Disable(c *fiber.Ctx) error {
+	var input reauthInput
+	if err := c.BodyParser(&input); err != nil || input.Password == "" || input.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Password and code are required",
+		})
+	}
+
+	user, err := services.GetUserByID(middleware.GetUserID(c))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	if err := h.twoFactorService.Disable(user, input.Password, input.Code); err != nil {
+		return twoFactorError(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"enabled": false,
+	})
+}
+
+// RegenerateRecoveryCodes invalidates all recovery codes and issues new ones
+func (h *TwoFactorHandler) RegenerateRecoveryCodes(c *fiber.Ctx) error {
+	var input reauthInput
+	if err := c.BodyParser(&input); err != nil || input.Password == "" || input.Code == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Password and code are required",
+		})
+	}
+
+	user, err := services.GetUserByID(middleware.GetUserID(c))
+	if err != nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{
+			"error": "User not found",
+		})
+	}
+
+	codes, err := h.twoFactorService.RegenerateRecoveryCodes(user, input.Password, input.Code)
+	if err != nil {
+		return twoFactorError(c, err)
+	}
+
+	return c.JSON(fiber.Map{
+		"recovery_codes": codes,
+	})
+}
+
+// Login completes a password login that returned a 2FA challenge
+func (h *TwoFactorHandler) Login(c *fiber.Ctx) error {
+	var input services.TwoFactorLoginInput
+	if err := c.BodyParser(&input); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Invalid request body",
+		})
+	}
+
+	if input.ChallengeToken == "" || (input.Code == "" && input.RecoveryCode == "") {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": "Challenge token and code are required",
+		})
+	}
+
+	input.Meta = sessionMeta(c)
+	response, err := h.authService.CompleteTwoFactorLogin(input)
+	if err != nil {
+		return loginError(c, err)
+	}
+
+	return c.JSON(response)
+}
+
+func twoFactorError(c *fiber.Ctx, err error) error {
+	switch {
+	case errors.Is(err, services.ErrInvalidTwoFactorCode), errors.Is(err, services.ErrInvalidPassword):
+		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, services.ErrTwoFactorNotEnabled), errors.Is(err, services.ErrTwoFactorNotPending):
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	case errors.Is(err, services.ErrTwoFactorAlreadyEnabled):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{
+			"error": err.Error(),
+		})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+		"error": err.Error(),
+	})
+}
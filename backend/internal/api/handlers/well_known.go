@@ -0,0 +1,29 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"messenger/internal/services"
+)
+
+type WellKnownHandler struct{}
+
+func NewWellKnownHandler() *WellKnownHandler {
+	return &WellKnownHandler{}
+}
+
+// JWKS publishes the public keys that verify our access tokens so other
+// services can validate them without sharing a secret
+func (h *WellKnownHandler) JWKS(c *fiber.Ctx) error {
+	keys, err := services.GetTokenKeys().JWKS()
+	if err != nil {
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{
+			"error": "Failed to load signing keys",
+		})
+	}
+
+	// Short cache so verifiers pick up rotated keys quickly
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	return c.JSON(fiber.Map{
+		"keys": keys,
+	})
+}
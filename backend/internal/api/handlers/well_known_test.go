@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"messenger/internal/services"
+)
+
+func TestWellKnownHandler_JWKS(t *testing.T) {
+	cleanup := setupTestDB(t)
+	defer cleanup()
+
+	app := fiber.New()
+	app.Get("/.well-known/jwks.json", NewWellKnownHandler().JWKS)
+
+	// Issuing a token makes sure a signing key exists
+	_, token := createTestUser(t, "jwksuser", "password123")
+	parsed, err := services.GetTokenKeys().Parse(token, &services.Claims{})
+	if err != nil {
+		t.Fatalf("Failed to parse access token: %v", err)
+	}
+
+	resp, body := makeRequest(app, testRequest{Method: "GET", Path: "/.well-known/jwks.json"})
+	assertStatus(t, resp, http.StatusOK)
+	if resp.Header.Get("Cache-Control") == "" {
+		t.Error("JWKS should be cacheable")
+	}
+
+	found := false
+	for _, key := range parseResponse(body)["keys"].([]interface{}) {
+		jwk := key.(map[string]interface{})
+		if jwk["kid"] == parsed.Header["kid"] {
+			found = true
+			if jwk["use"] != "sig" || jwk["alg"] != parsed.Method.Alg() {
+				t.Errorf("Unexpected JWK %v", jwk)
+			}
+		}
+	}
+	if !found {
+		t.Error("JWKS should contain the key that signed the access token")
+	}
+}
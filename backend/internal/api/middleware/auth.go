@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"messenger/internal/services"
@@ -23,7 +24,7 @@ func AuthRequired() fiber.Handler {
 			})
 		}
 
-		claims, err := services.ValidateToken(parts[1])
+		claims, err := services.AuthenticateToken(parts[1])
 		if err != nil {
 			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{
 				"error": "Invalid or expired token",
@@ -33,6 +34,10 @@ func AuthRequired() fiber.Handler {
 		// Store user info in context
 		c.Locals("userID", claims.UserID)
 		c.Locals("username", claims.Username)
+		c.Locals("sessionID", claims.SessionID)
+		if claims.ExpiresAt != nil {
+			c.Locals("tokenExpiresAt", claims.ExpiresAt.Time)
+		}
 
 		return c.Next()
 	}
@@ -47,3 +52,14 @@ func GetUsername(c *fiber.Ctx) string {
 	username, _ := c.Locals("username").(string)
 	return username
 }
+
+func GetSessionID(c *fiber.Ctx) string {
+	sessionID, _ := c.Locals("sessionID").(string)
+	return sessionID
+}
+
+// GetTokenExpiry returns when the request's access token expires
+func GetTokenExpiry(c *fiber.Ctx) time.Time {
+	expiresAt, _ := c.Locals("tokenExpiresAt").(time.Time)
+	return expiresAt
+}
@@ -2,6 +2,7 @@ package middleware
 
 import (
 	"net/http/httptest"
+	"strings"
 	"testing"
 	"time"
 
@@ -24,7 +25,7 @@ func setupTestDB(t *testing.T) {
 	}
 
 	// Auto-migrate
-	database.DB.AutoMigrate(&models.User{})
+	database.DB.AutoMigrate(&models.User{}, &models.Session{}, &models.RefreshToken{}, &models.TwoFactor{}, &models.RecoveryCode{}, &models.TwoFactorChallenge{}, &models.PhoneVerification{}, &models.PasswordResetToken{}, &models.ExternalIdentity{}, &models.OIDCAuthRequest{}, &models.SigningKey{}, &models.WebSocketTicket{}, &models.DeviceLinkRequest{}, &models.AccountExport{}, &models.AccountDeletion{}, &models.LoginEvent{}, &models.LoginLockout{}, &models.NotificationPreferences{}, &models.PushJob{}, &models.PushBurst{}, &models.PushDeadLetter{}, &models.PushAttempt{}, &models.Call{}, &models.CallParticipant{})
 }
 
 func createTestUser(t *testing.T, username, password string, role models.UserRole) (*models.User, string) {
@@ -168,6 +169,68 @@ func TestRateLimitByUser(t *testing.T) {
 	}
 }
 
+// TestRateLimitByKey tests the per-account limiter used for password resets
+func TestRateLimitByKey(t *testing.T) {
+	app := fiber.New()
+
+	app.Use(RateLimitByKey(1, time.Second, 2, accountIdentifierKey))
+	app.Post("/", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	post := func(identifier string) int {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(`{"identifier":"`+identifier+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		resp, _ := app.Test(req, -1)
+		return resp.StatusCode
+	}
+
+	// Same account, different spelling, shares a bucket
+	if post("alice") != 200 || post(" Alice ") != 200 {
+		t.Error("First 2 requests should succeed")
+	}
+	if status := post("alice"); status != 429 {
+		t.Errorf("3rd request for the same account should be rate limited, got status %d", status)
+	}
+
+	// Other accounts are unaffected
+	if status := post("bob"); status != 200 {
+		t.Errorf("Request for another account should succeed, got status %d", status)
+	}
+}
+
+// TestDeviceLinkPollLimiter tests that polls are limited per linking token
+func TestDeviceLinkPollLimiter(t *testing.T) {
+	app := fiber.New()
+
+	app.Use(RateLimitByKey(1, time.Second, 6, linkTokenKey))
+	app.Post("/", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	post := func(token string) int {
+		req := httptest.NewRequest("POST", "/", strings.NewReader(`{"link_token":"`+token+`"}`))
+		req.Header.Set("Content-Type", "application/json")
+		resp, _ := app.Test(req, -1)
+		return resp.StatusCode
+	}
+
+	// More polls than AuthLimiter allows for one device
+	for i := 0; i < 6; i++ {
+		if status := post("token-a"); status != 200 {
+			t.Fatalf("Poll %d should succeed, got status %d", i+1, status)
+		}
+	}
+	if status := post("token-a"); status != 429 {
+		t.Errorf("7th poll should be rate limited, got status %d", status)
+	}
+
+	// Another device linking from the same IP is unaffected
+	if status := post("token-b"); status != 200 {
+		t.Errorf("Poll for another token should succeed, got status %d", status)
+	}
+}
+
 // TestAuthRequired tests the auth middleware
 func TestAuthRequired(t *testing.T) {
 	setupTestDB(t)
@@ -389,3 +452,34 @@ func TestGetUserRole(t *testing.T) {
 		t.Errorf("Expected 200, got %d", resp.StatusCode)
 	}
 }
+
+// TestModeratorRequiredTwoFactor tests that roles listed in TWO_FACTOR_REQUIRED_ROLES must enrol in 2FA
+func TestModeratorRequiredTwoFactor(t *testing.T) {
+	setupTestDB(t)
+	t.Setenv("TWO_FACTOR_REQUIRED_ROLES", "moderator,admin")
+	app := fiber.New()
+
+	moderator, modToken := createTestUser(t, "tfmoderator", "password123", models.UserRoleModerator)
+
+	app.Use(AuthRequired())
+	app.Use(ModeratorRequired())
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString("OK")
+	})
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+modToken)
+	resp, _ := app.Test(req, -1)
+	if resp.StatusCode != 403 {
+		t.Errorf("Moderator without 2FA should be denied, got status %d", resp.StatusCode)
+	}
+
+	database.DB.Create(&models.TwoFactor{UserID: moderator.ID, Secret: "JBSWY3DPEHPK3PXP", Enabled: true})
+
+	req = httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Authorization", "Bearer "+modToken)
+	resp, _ = app.Test(req, -1)
+	if resp.StatusCode != 200 {
+		t.Errorf("Moderator with 2FA should be allowed, got status %d", resp.StatusCode)
+	}
+}
@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"strings"
 	"sync"
 	"time"
 
@@ -123,6 +124,47 @@ func RateLimitByUser(rate int, interval time.Duration, burst int) fiber.Handler
 	}
 }
 
+// RateLimitByKey creates middleware that rate limits by a key derived from the
+// request. Requests with an empty key are not limited here.
+func RateLimitByKey(rate int, interval time.Duration, burst int, keyFunc func(c *fiber.Ctx) string) fiber.Handler {
+	limiter := NewRateLimiter(rate, interval, burst)
+
+	return func(c *fiber.Ctx) error {
+		key := keyFunc(c)
+		if key != "" && !limiter.Allow(key) {
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{
+				"error": "Rate limit exceeded. Please try again later.",
+			})
+		}
+
+		return c.Next()
+	}
+}
+
+// accountIdentifierKey returns the account a password reset is requested for.
+// The key doesn't depend on whether the account exists, so the limit can't
+// be used to discover accounts.
+func accountIdentifierKey(c *fiber.Ctx) string {
+	var input struct {
+		Identifier string `json:"identifier"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return ""
+	}
+	return strings.ToLower(strings.TrimSpace(input.Identifier))
+}
+
+// linkTokenKey returns the linking token a new device is polling for
+func linkTokenKey(c *fiber.Ctx) string {
+	var input struct {
+		LinkToken string `json:"link_token"`
+	}
+	if err := c.BodyParser(&input); err != nil {
+		return ""
+	}
+	return input.LinkToken
+}
+
 // Preset rate limiters for different endpoints
 var (
 	// AuthLimiter: 5 attempts per minute (login/register)
@@ -136,6 +178,17 @@ var (
 
 	// MessageLimiter: 60 messages per minute
 	MessageLimiter = RateLimitByUser(60, time.Minute, 100)
+
+	// PasswordResetLimiter: 3 reset requests per account, then 1 every 5 minutes (on top of AuthLimiter)
+	PasswordResetLimiter = RateLimitByKey(1, 5*time.Minute, 3, accountIdentifierKey)
+
+	// DeviceLinkPollLimiter: 30 polls per minute per linking token, so a new
+	// device can keep long-polling /auth/link/complete while it waits
+	DeviceLinkPollLimiter = RateLimitByKey(30, time.Minute, 30, linkTokenKey)
+
+	// DeviceLinkPollIPLimiter: 60 polls per minute per IP, to bound lookups
+	// of tokens that don't exist
+	DeviceLinkPollIPLimiter = RateLimitByIP(60, time.Minute, 60)
 )
 
 func min(a, b int) int {
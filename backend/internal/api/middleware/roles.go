@@ -4,10 +4,12 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"messenger/internal/database"
 	"messenger/internal/models"
+	"messenger/internal/services"
 )
 
 // ModeratorRequired ensures the user has moderator or admin role
 func ModeratorRequired() fiber.Handler {
+	twoFactor := services.NewTwoFactorService()
 	return func(c *fiber.Ctx) error {
 		userID := GetUserID(c)
 		if userID == "" {
@@ -29,6 +31,10 @@ func ModeratorRequired() fiber.Handler {
 			})
 		}
 
+		if !twoFactorSatisfied(twoFactor, &user) {
+			return twoFactorEnrolmentRequired(c)
+		}
+
 		// Store role in context for handlers
 		c.Locals("userRole", user.Role)
 
@@ -38,6 +44,7 @@ func ModeratorRequired() fiber.Handler {
 
 // AdminRequired ensures the user has admin role
 func AdminRequired() fiber.Handler {
+	twoFactor := services.NewTwoFactorService()
 	return func(c *fiber.Ctx) error {
 		userID := GetUserID(c)
 		if userID == "" {
@@ -59,6 +66,10 @@ func AdminRequired() fiber.Handler {
 			})
 		}
 
+		if !twoFactorSatisfied(twoFactor, &user) {
+			return twoFactorEnrolmentRequired(c)
+		}
+
 		// Store role in context for handlers
 		c.Locals("userRole", user.Role)
 
@@ -71,3 +82,19 @@ func GetUserRole(c *fiber.Ctx) models.UserRole {
 	role, _ := c.Locals("userRole").(models.UserRole)
 	return role
 }
+
+// twoFactorSatisfied returns false if the user's role requires 2FA
+// (TWO_FACTOR_REQUIRED_ROLES) and they have not enrolled
+func twoFactorSatisfied(twoFactor *services.TwoFactorService, user *models.User) bool {
+	if !twoFactor.IsRequiredForRole(user.Role) {
+		return true
+	}
+	return models.IsTwoFactorEnabled(database.DB, user.ID)
+}
+
+func twoFactorEnrolmentRequired(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusForbidden).JSON(fiber.Map{
+		"error": "Two-factor authentication must be enabled for this role",
+		"code":  "two_factor_enrolment_required",
+	})
+}
@@ -1,6 +1,8 @@
 package api
 
 import (
+	"time"
+
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/contrib/websocket"
 	"messenger/internal/api/handlers"
@@ -18,19 +20,74 @@ func SetupRoutes(app *fiber.App, hub *ws.Hub) {
 	app.Get("/metrics", healthHandler.Metrics)       // JSON metrics
 	app.Get("/metrics/prometheus", healthHandler.PrometheusMetrics) // Prometheus format
 
+	// Public keys for verifying our access tokens
+	wellKnownHandler := handlers.NewWellKnownHandler()
+	app.Get("/.well-known/jwks.json", wellKnownHandler.JWKS)
+
 	// API routes
 	api := app.Group("/api")
 
-	// Auth routes (public) - rate limited to prevent brute force
+	// Auth routes (public) - rate limited to prevent brute force. The limiter
+	// goes on each route rather than the group: group middleware would also
+	// run for the authenticated /auth routes registered below.
 	authHandler := handlers.NewAuthHandler()
-	auth := api.Group("/auth", middleware.AuthLimiter)
-	auth.Post("/register", authHandler.Register)
-	auth.Post("/login", authHandler.Login)
-	auth.Post("/refresh", authHandler.Refresh)
+	auth := api.Group("/auth")
+	auth.Post("/register", middleware.AuthLimiter, authHandler.Register)
+	auth.Post("/login", middleware.AuthLimiter, authHandler.Login)
+	auth.Post("/refresh", middleware.AuthLimiter, authHandler.Refresh)
+	auth.Post("/password/forgot", middleware.AuthLimiter, middleware.PasswordResetLimiter, authHandler.ForgotPassword)
+	auth.Post("/password/reset", middleware.AuthLimiter, authHandler.ResetPassword)
+
+	twoFactorHandler := handlers.NewTwoFactorHandler()
+	auth.Post("/login/2fa", middleware.AuthLimiter, twoFactorHandler.Login)
+
+	phoneHandler := handlers.NewPhoneHandler()
+	auth.Post("/phone/login/request", middleware.AuthLimiter, phoneHandler.RequestLoginCode)
+	auth.Post("/phone/login", middleware.AuthLimiter, phoneHandler.Login)
+
+	oidcHandler := handlers.NewOIDCHandler()
+	auth.Get("/oidc/authorize", middleware.AuthLimiter, oidcHandler.Authorize)
+	auth.Post("/oidc/callback", middleware.AuthLimiter, oidcHandler.Callback)
+
+	deviceLinkHandler := handlers.NewDeviceLinkHandler()
+	auth.Post("/link", middleware.AuthLimiter, deviceLinkHandler.Start)
+	auth.Post("/link/complete", middleware.DeviceLinkPollIPLimiter, middleware.DeviceLinkPollLimiter, deviceLinkHandler.Complete)
 
 	// Protected routes with general API rate limiting
 	protected := api.Group("", middleware.AuthRequired(), middleware.APILimiter)
 
+	// Sessions
+	sessions := protected.Group("/auth")
+	sessions.Get("/sessions", authHandler.ListSessions)
+	sessions.Delete("/sessions", authHandler.LogoutAll)
+	sessions.Delete("/sessions/:id", authHandler.RevokeSession)
+	sessions.Post("/logout", authHandler.Logout)
+	sessions.Post("/password", authHandler.ChangePassword)
+	sessions.Post("/ws-ticket", authHandler.WebSocketTicket)
+	sessions.Get("/login-history", authHandler.LoginHistory)
+
+	// Two-factor authentication
+	sessions.Get("/2fa", twoFactorHandler.Status)
+	sessions.Post("/2fa/setup", twoFactorHandler.Setup)
+	sessions.Post("/2fa/confirm", twoFactorHandler.Confirm)
+	sessions.Post("/2fa/disable", twoFactorHandler.Disable)
+	sessions.Post("/2fa/recovery-codes", twoFactorHandler.RegenerateRecoveryCodes)
+
+	// Phone verification
+	sessions.Get("/phone", phoneHandler.Status)
+	sessions.Post("/phone/verify/request", phoneHandler.RequestVerification)
+	sessions.Post("/phone/verify", phoneHandler.ConfirmVerification)
+
+	// Single sign-on
+	sessions.Get("/oidc/identities", oidcHandler.Identities)
+	sessions.Post("/oidc/link", oidcHandler.Link)
+	sessions.Post("/oidc/link/callback", oidcHandler.LinkCallback)
+
+	// Device linking (approval from a logged-in device)
+	sessions.Post("/link/lookup", deviceLinkHandler.Lookup)
+	sessions.Post("/link/approve", deviceLinkHandler.Approve)
+	sessions.Post("/link/reject", deviceLinkHandler.Reject)
+
 	// Contacts
 	contactsHandler := handlers.NewContactsHandler(hub)
 	contacts := protected.Group("/contacts")
@@ -80,8 +137,11 @@ func SetupRoutes(app *fiber.App, hub *ws.Hub) {
 	mediaHandler := handlers.NewMediaHandler(hub)
 	media := protected.Group("/media")
 	media.Post("/upload", middleware.MediaLimiter, mediaHandler.Upload)
+	media.Get("/usage", mediaHandler.Usage)
 	media.Get("/:id", mediaHandler.Get)
+	media.Delete("/:id", mediaHandler.Delete)
 	media.Get("/:id/thumbnail", mediaHandler.GetThumbnail)
+	media.Get("/:id/hls/*", mediaHandler.GetHLS)
 
 	// Notifications (push)
 	notificationsHandler := handlers.NewNotificationsHandler()
@@ -91,6 +151,13 @@ func SetupRoutes(app *fiber.App, hub *ws.Hub) {
 	notifications.Delete("/all", notificationsHandler.UnregisterAllTokens)
 	notifications.Get("/tokens", notificationsHandler.GetTokens)
 	notifications.Post("/test", notificationsHandler.TestNotification)
+	notifications.Get("/diagnostics", notificationsHandler.GetDiagnostics)
+
+	// Calls (signaling runs over the WebSocket)
+	callsHandler := handlers.NewCallsHandler()
+	calls := protected.Group("/calls")
+	calls.Get("/", callsHandler.List)
+	calls.Get("/ice-servers", callsHandler.ICEServers)
 
 	// Link previews
 	linkPreviewHandler := handlers.NewLinkPreviewHandler()
@@ -106,12 +173,15 @@ func SetupRoutes(app *fiber.App, hub *ws.Hub) {
 	starred.Delete("/:messageId", starredHandler.Unstar)
 	starred.Get("/:messageId", starredHandler.IsStarred)
 
-	// Conversation settings (disappearing messages, mute)
+	// Conversation settings (disappearing messages, mute) and notification preferences
 	settingsHandler := handlers.NewSettingsHandler()
 	settings := protected.Group("/settings")
 	settings.Get("/conversation", settingsHandler.GetConversationSettings)
 	settings.Post("/disappearing", settingsHandler.SetDisappearingMessages)
 	settings.Post("/mute", settingsHandler.MuteConversation)
+	settings.Post("/mentions-only", settingsHandler.SetMentionsOnly)
+	settings.Get("/notifications", settingsHandler.GetNotificationPreferences)
+	settings.Put("/notifications", settingsHandler.UpdateNotificationPreferences)
 
 	// Themes
 	themesHandler := handlers.NewThemesHandler()
@@ -152,6 +222,16 @@ func SetupRoutes(app *fiber.App, hub *ws.Hub) {
 	admin := protected.Group("/admin", middleware.ModeratorRequired())
 	admin.Get("/review", adminHandler.GetPendingReview)
 	admin.Post("/review/:id", adminHandler.Review)
+	admin.Get("/users/:id/quota", middleware.AdminRequired(), adminHandler.GetUserQuota)
+	admin.Put("/users/:id/quota", middleware.AdminRequired(), adminHandler.SetUserQuota)
+	admin.Delete("/users/:id/quota", middleware.AdminRequired(), adminHandler.ResetUserQuota)
+	admin.Get("/users/:id/lockout", middleware.AdminRequired(), adminHandler.GetUserLockout)
+	admin.Post("/users/:id/unlock", middleware.AdminRequired(), adminHandler.UnlockUser)
+	admin.Post("/signing-keys/rotate", middleware.AdminRequired(), adminHandler.RotateSigningKey)
+	admin.Get("/push/dead-letters", middleware.AdminRequired(), adminHandler.ListPushDeadLetters)
+	admin.Get("/push/dead-letters/:id", middleware.AdminRequired(), adminHandler.GetPushDeadLetter)
+	admin.Post("/push/dead-letters/:id/retry", middleware.AdminRequired(), adminHandler.RetryPushDeadLetter)
+	admin.Delete("/push/dead-letters/:id", middleware.AdminRequired(), adminHandler.DeletePushDeadLetter)
 
 	// Profile routes
 	profileHandler := handlers.NewProfileHandler(hub)
@@ -160,6 +240,17 @@ func SetupRoutes(app *fiber.App, hub *ws.Hub) {
 	profile.Put("/", profileHandler.UpdateProfile)
 	profile.Get("/:userId", profileHandler.GetUserProfile)
 
+	// Account data export and deletion
+	accountHandler := handlers.NewAccountHandler()
+	account := protected.Group("/account")
+	account.Post("/export", accountHandler.RequestExport)
+	account.Get("/export/:id", accountHandler.GetExport)
+	account.Get("/export/:id/download", accountHandler.DownloadExport)
+	account.Delete("/", accountHandler.Delete)
+	account.Post("/deletion/code", accountHandler.RequestDeletionCode)
+	account.Get("/deletion", accountHandler.GetDeletion)
+	account.Delete("/deletion", accountHandler.CancelDeletion)
+
 	// Archive routes
 	archiveHandler := handlers.NewArchiveHandler()
 	archive := protected.Group("/archive")
@@ -211,20 +302,31 @@ func SetupRoutes(app *fiber.App, hub *ws.Hub) {
 	})
 
 	app.Get("/ws", websocket.New(func(conn *websocket.Conn) {
-		// Get token from query param
-		token := conn.Query("token")
-		if token == "" {
-			conn.Close()
-			return
+		// Authenticate with a ticket from POST /auth/ws-ticket. Passing the
+		// access token as ?token= is deprecated and off unless
+		// WS_ALLOW_QUERY_TOKEN=true.
+		var auth *services.WebSocketAuth
+		var err error
+		if ticket := conn.Query("ticket"); ticket != "" {
+			auth, err = services.RedeemWebSocketTicket(ticket, conn.Query("device_id"))
+		} else if token := conn.Query("token"); token != "" {
+			auth, err = services.AuthenticateWebSocketQueryToken(token)
+		} else {
+			err = services.ErrInvalidWebSocketTicket
 		}
-
-		claims, err := services.ValidateToken(token)
 		if err != nil {
+			conn.WriteControl(
+				websocket.CloseMessage,
+				websocket.FormatCloseMessage(ws.CloseUnauthorized, "unauthorized"),
+				time.Now().Add(time.Second),
+			)
 			conn.Close()
 			return
 		}
 
-		client := ws.NewClient(hub, conn, claims.UserID, claims.Username)
+		client := ws.NewClient(hub, conn, auth.UserID, auth.Username)
+		client.SetSession(auth.SessionID, auth.DeviceID)
+		client.SetAuthExpiry(auth.ExpiresAt)
 		hub.Register(client)
 
 		go client.WritePump()
@@ -0,0 +1,117 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// AccountExportStatus tracks an export job
+type AccountExportStatus string
+
+const (
+	AccountExportPending   AccountExportStatus = "pending"
+	AccountExportRunning   AccountExportStatus = "running"
+	AccountExportCompleted AccountExportStatus = "completed"
+	AccountExportFailed    AccountExportStatus = "failed"
+)
+
+// AccountExport is a user's request for a copy of their data. The zip is
+// written to FilePath and deleted when the export expires.
+type AccountExport struct {
+	ID          string              `gorm:"primaryKey" json:"id"`
+	UserID      string              `gorm:"not null;index" json:"user_id"`
+	Status      AccountExportStatus `gorm:"not null;default:pending" json:"status"`
+	FilePath    string              `json:"-"`
+	Size        int64               `json:"size,omitempty"`
+	Error       string              `json:"error,omitempty"`
+	CompletedAt *time.Time          `json:"completed_at,omitempty"`
+	ExpiresAt   time.Time           `gorm:"index" json:"expires_at"`
+	CreatedAt   time.Time           `json:"created_at"`
+}
+
+func (e *AccountExport) BeforeCreate(tx *gorm.DB) error {
+	if e.ID == "" {
+		e.ID = uuid.New().String()
+	}
+	if e.Status == "" {
+		e.Status = AccountExportPending
+	}
+	return nil
+}
+
+// GetAccountExport retrieves one of a user's exports
+func GetAccountExport(db *gorm.DB, exportID, userID string) (*AccountExport, error) {
+	var export AccountExport
+	if err := db.Where("id = ? AND user_id = ?", exportID, userID).First(&export).Error; err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+// GetActiveAccountExport returns the user's pending or running export, if any
+func GetActiveAccountExport(db *gorm.DB, userID string) (*AccountExport, error) {
+	var export AccountExport
+	err := db.Where("user_id = ? AND status IN ?", userID, []AccountExportStatus{AccountExportPending, AccountExportRunning}).
+		First(&export).Error
+	if err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+// FailStaleAccountExports marks a user's pending or running exports created
+// before the cutoff as failed. Their job was lost, e.g. to a restart.
+func FailStaleAccountExports(db *gorm.DB, userID string, before time.Time) (int64, error) {
+	result := db.Model(&AccountExport{}).
+		Where("user_id = ? AND status IN ? AND created_at < ?", userID, []AccountExportStatus{AccountExportPending, AccountExportRunning}, before).
+		Updates(map[string]interface{}{"status": AccountExportFailed, "error": "export was interrupted"})
+	return result.RowsAffected, result.Error
+}
+
+// GetExpiredAccountExports returns exports whose files should be removed
+func GetExpiredAccountExports(db *gorm.DB, before time.Time) ([]AccountExport, error) {
+	var exports []AccountExport
+	err := db.Where("expires_at < ?", before).Find(&exports).Error
+	return exports, err
+}
+
+// AccountDeletion is a scheduled account deletion. The account is erased at
+// ScheduledFor unless the user cancels first.
+type AccountDeletion struct {
+	ID           string    `gorm:"primaryKey" json:"id"`
+	UserID       string    `gorm:"not null;uniqueIndex" json:"user_id"`
+	ScheduledFor time.Time `gorm:"not null;index" json:"scheduled_for"`
+	RequestIP    string    `json:"request_ip,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+func (d *AccountDeletion) BeforeCreate(tx *gorm.DB) error {
+	if d.ID == "" {
+		d.ID = uuid.New().String()
+	}
+	return nil
+}
+
+// GetAccountDeletion returns a user's scheduled deletion
+func GetAccountDeletion(db *gorm.DB, userID string) (*AccountDeletion, error) {
+	var deletion AccountDeletion
+	if err := db.Where("user_id = ?", userID).First(&deletion).Error; err != nil {
+		return nil, err
+	}
+	return &deletion, nil
+}
+
+// GetDueAccountDeletions returns deletions whose grace period has ended
+func GetDueAccountDeletions(db *gorm.DB, now time.Time) ([]AccountDeletion, error) {
+	var deletions []AccountDeletion
+	err := db.Where("scheduled_for <= ?", now).Find(&deletions).Error
+	return deletions, err
+}
+
+// CancelAccountDeletion removes a scheduled deletion. Returns false if none was scheduled.
+func CancelAccountDeletion(db *gorm.DB, userID string) (bool, error) {
+	result := db.Where("user_id = ?", userID).Delete(&AccountDeletion{})
+	return result.RowsAffected == 1, result.Error
+}
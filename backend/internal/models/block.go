@@ -31,6 +31,12 @@ func IsBlocked(db *gorm.DB, blockerID, blockedID string) bool {
 	return count > 0
 }
 
+// IsUnreachable checks if userID can't contact otherID: either user has
+// blocked the other, or otherID's account was erased
+func IsUnreachable(db *gorm.DB, userID, otherID string) bool {
+	return IsEitherBlocked(db, userID, otherID) || IsUserDeleted(db, otherID)
+}
+
 // IsEitherBlocked checks if either user has blocked the other
 func IsEitherBlocked(db *gorm.DB, userID1, userID2 string) bool {
 	var count int64
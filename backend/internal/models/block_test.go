@@ -2,6 +2,7 @@ package models
 
 import (
 	"testing"
+	"time"
 
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
@@ -145,6 +146,38 @@ func TestIsEitherBlocked(t *testing.T) {
 	}
 }
 
+func TestIsUnreachable(t *testing.T) {
+	db := setupBlockTestDB(t)
+
+	alice := &User{Username: "alice"}
+	bob := &User{Username: "bob"}
+	deletedAt := time.Now()
+	gone := &User{Username: "deleted-gone", DeletedAt: &deletedAt}
+	db.Create(alice)
+	db.Create(bob)
+	db.Create(gone)
+	db.Create(&Block{BlockerID: bob.ID, BlockedID: alice.ID})
+
+	tests := []struct {
+		name    string
+		userID  string
+		otherID string
+		want    bool
+	}{
+		{"blocked", alice.ID, bob.ID, true},
+		{"erased account", alice.ID, gone.ID, true},
+		{"reachable", gone.ID, alice.ID, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsUnreachable(db, tt.userID, tt.otherID); got != tt.want {
+				t.Errorf("IsUnreachable() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
 func TestBlock_UniqueConstraint(t *testing.T) {
 	db := setupBlockTestDB(t)
 
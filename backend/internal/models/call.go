@@ -0,0 +1,111 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+type CallMedia string
+
+const (
+	CallMediaAudio CallMedia = "audio"
+	CallMediaVideo CallMedia = "video"
+)
+
+type CallStatus string
+
+const (
+	CallStatusRinging   CallStatus = "ringing"
+	CallStatusActive    CallStatus = "active"
+	CallStatusEnded     CallStatus = "ended"     // Answered, then hung up
+	CallStatusMissed    CallStatus = "missed"    // Nobody answered before the ring timeout
+	CallStatusDeclined  CallStatus = "declined"  // The callee rejected the call
+	CallStatusCancelled CallStatus = "cancelled" // The caller hung up before anyone answered
+	CallStatusBusy      CallStatus = "busy"      // The callee was already in another call
+)
+
+// Participant states on CallParticipant
+const (
+	CallParticipantRinging  = "ringing"
+	CallParticipantJoined   = "joined"
+	CallParticipantLeft     = "left"
+	CallParticipantDeclined = "declined"
+	CallParticipantMissed   = "missed"
+	CallParticipantBusy     = "busy"
+)
+
+// Call is the history record of a voice or video call. The call itself is
+// signaled over WebSocket; the record ends up in the conversation as a
+// message with CallID set.
+type Call struct {
+	ID              string     `gorm:"primaryKey" json:"id"`
+	CallerID        string     `gorm:"not null;index" json:"caller_id"`
+	RecipientID     *string    `gorm:"index" json:"recipient_id,omitempty"` // For 1:1 calls
+	GroupID         *string    `gorm:"index" json:"group_id,omitempty"`     // For group calls
+	Media           CallMedia  `gorm:"not null" json:"media"`
+	Status          CallStatus `gorm:"not null;default:ringing" json:"status"`
+	AnsweredAt      *time.Time `json:"answered_at,omitempty"`
+	EndedAt         *time.Time `json:"ended_at,omitempty"`
+	DurationSeconds int        `json:"duration_seconds"` // From answer to hang-up
+	CreatedAt       time.Time  `gorm:"index" json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+
+	Participants []CallParticipant `gorm:"foreignKey:CallID" json:"participants,omitempty"`
+}
+
+// CallParticipant is one invited user's part in a call
+type CallParticipant struct {
+	ID       string     `gorm:"primaryKey" json:"-"`
+	CallID   string     `gorm:"not null;index;uniqueIndex:idx_call_participant" json:"-"`
+	UserID   string     `gorm:"not null;index;uniqueIndex:idx_call_participant" json:"user_id"`
+	Status   string     `gorm:"not null" json:"status"`
+	JoinedAt *time.Time `json:"joined_at,omitempty"`
+	LeftAt   *time.Time `json:"left_at,omitempty"`
+}
+
+func (c *Call) BeforeCreate(tx *gorm.DB) error {
+	if c.ID == "" {
+		c.ID = uuid.New().String()
+	}
+	return nil
+}
+
+func (p *CallParticipant) BeforeCreate(tx *gorm.DB) error {
+	if p.ID == "" {
+		p.ID = uuid.New().String()
+	}
+	return nil
+}
+
+func (c *Call) IsGroupCall() bool {
+	return c.GroupID != nil && *c.GroupID != ""
+}
+
+// ListUserCalls returns the calls a user made or was invited to, newest first
+func ListUserCalls(db *gorm.DB, userID string, limit, offset int) ([]Call, error) {
+	var calls []Call
+	invited := db.Model(&CallParticipant{}).Select("call_id").Where("user_id = ?", userID)
+	err := db.Preload("Participants").
+		Where("caller_id = ? OR id IN (?)", userID, invited).
+		Order("created_at DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&calls).Error
+	return calls, err
+}
+
+// FinishUnendedCalls closes calls left ringing or active, e.g. by a restart,
+// since their signaling state only lived in memory
+func FinishUnendedCalls(db *gorm.DB) (int64, error) {
+	now := time.Now()
+	missed := db.Model(&Call{}).Where("status = ?", CallStatusRinging).
+		Updates(map[string]interface{}{"status": CallStatusMissed, "ended_at": now})
+	if missed.Error != nil {
+		return 0, missed.Error
+	}
+	ended := db.Model(&Call{}).Where("status = ?", CallStatusActive).
+		Updates(map[string]interface{}{"status": CallStatusEnded, "ended_at": now})
+	return missed.RowsAffected + ended.RowsAffected, ended.Error
+}
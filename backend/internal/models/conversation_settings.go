@@ -25,6 +25,7 @@ type ConversationSettings struct {
 	GroupID               *string   `gorm:"index;uniqueIndex:idx_user_conversation" json:"group_id,omitempty"`      // For groups
 	DisappearingSeconds   int       `gorm:"default:0" json:"disappearing_seconds"`                                   // 0 = off
 	MutedUntil            *time.Time `json:"muted_until,omitempty"`
+	MentionsOnly          bool      `gorm:"default:false" json:"mentions_only"` // Only push messages that @mention the user
 	CreatedAt             time.Time `json:"created_at"`
 	UpdatedAt             time.Time `json:"updated_at"`
 }
@@ -47,6 +48,26 @@ func (cs *ConversationSettings) IsMuted() bool {
 	return cs.MutedUntil.After(time.Now())
 }
 
+// FindConversationSettings returns a user's settings for a DM or group
+// conversation, or nil if they never changed any
+func FindConversationSettings(db *gorm.DB, userID string, otherUserID *string, groupID *string) *ConversationSettings {
+	var settings ConversationSettings
+	var err error
+
+	if otherUserID != nil {
+		err = db.Where("user_id = ? AND other_user_id = ?", userID, *otherUserID).First(&settings).Error
+	} else if groupID != nil {
+		err = db.Where("user_id = ? AND group_id = ?", userID, *groupID).First(&settings).Error
+	} else {
+		return nil
+	}
+
+	if err != nil {
+		return nil
+	}
+	return &settings
+}
+
 // GetOrCreateDMSettings gets or creates settings for a DM conversation
 func GetOrCreateDMSettings(db *gorm.DB, userID, otherUserID string) (*ConversationSettings, error) {
 	var settings ConversationSettings
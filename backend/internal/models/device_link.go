@@ -0,0 +1,109 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// DeviceLinkStatus is where a device link request is in the linking flow
+type DeviceLinkStatus string
+
+const (
+	DeviceLinkPending   DeviceLinkStatus = "pending"   // Waiting for an existing device to scan the QR code
+	DeviceLinkApproved  DeviceLinkStatus = "approved"  // Approved, waiting for the new device to collect its session
+	DeviceLinkCompleted DeviceLinkStatus = "completed" // Session issued to the new device
+	DeviceLinkRejected  DeviceLinkStatus = "rejected"
+)
+
+// DeviceLinkRequest links a new device to an account without a password. The
+// new device shows a QR code with the linking token and its ephemeral public
+// key; an already logged-in device approves it and leaves a provisioning blob
+// encrypted to that key. Only a hash of the linking token is stored.
+type DeviceLinkRequest struct {
+	ID                 string           `gorm:"primaryKey" json:"id"`
+	TokenHash          string           `gorm:"not null;uniqueIndex" json:"-"`
+	Status             DeviceLinkStatus `gorm:"not null;default:pending" json:"status"`
+	DeviceID           string           `gorm:"not null" json:"device_id"` // E2EE device ID chosen by the new device
+	DeviceName         string           `json:"device_name,omitempty"`
+	Platform           string           `json:"platform,omitempty"`
+	EphemeralPublicKey string           `gorm:"not null" json:"ephemeral_public_key"` // New device's key, also shown in the QR code
+	UserID             *string          `gorm:"index" json:"user_id,omitempty"`       // Set on approval
+	ApproverDeviceID   string           `json:"approver_device_id,omitempty"`
+	ApproverPublicKey  string           `json:"-"` // Approver's ephemeral key for deriving the shared secret
+	ProvisioningBlob   string           `json:"-"` // Encrypted by the approver, opaque to the server
+	RequestIP          string           `json:"request_ip,omitempty"`
+	ExpiresAt          time.Time        `gorm:"index" json:"expires_at"`
+	ApprovedAt         *time.Time       `json:"approved_at,omitempty"`
+	CompletedAt        *time.Time       `json:"completed_at,omitempty"`
+	CreatedAt          time.Time        `json:"created_at"`
+}
+
+func (r *DeviceLinkRequest) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == "" {
+		r.ID = uuid.New().String()
+	}
+	if r.Status == "" {
+		r.Status = DeviceLinkPending
+	}
+	return nil
+}
+
+// GetDeviceLinkRequestByHash looks up a link request by the hash of its token
+func GetDeviceLinkRequestByHash(db *gorm.DB, tokenHash string) (*DeviceLinkRequest, error) {
+	var request DeviceLinkRequest
+	if err := db.Where("token_hash = ?", tokenHash).First(&request).Error; err != nil {
+		return nil, err
+	}
+	return &request, nil
+}
+
+// ApproveDeviceLinkRequest atomically approves a pending, unexpired request and
+// stores the provisioning blob. expiresAt is the new deadline for the new
+// device to collect its session. Returns false if the request was no longer pending.
+func ApproveDeviceLinkRequest(db *gorm.DB, requestID, userID, approverDeviceID, approverPublicKey, blob string, expiresAt time.Time) (bool, error) {
+	now := time.Now()
+	result := db.Model(&DeviceLinkRequest{}).
+		Where("id = ? AND status = ? AND expires_at > ?", requestID, DeviceLinkPending, now).
+		Updates(map[string]interface{}{
+			"status":              DeviceLinkApproved,
+			"user_id":             userID,
+			"approver_device_id":  approverDeviceID,
+			"approver_public_key": approverPublicKey,
+			"provisioning_blob":   blob,
+			"approved_at":         now,
+			"expires_at":          expiresAt,
+		})
+	return result.RowsAffected == 1, result.Error
+}
+
+// RejectDeviceLinkRequest atomically rejects a pending request.
+// Returns false if the request was no longer pending.
+func RejectDeviceLinkRequest(db *gorm.DB, requestID string) (bool, error) {
+	result := db.Model(&DeviceLinkRequest{}).
+		Where("id = ? AND status = ?", requestID, DeviceLinkPending).
+		Update("status", DeviceLinkRejected)
+	return result.RowsAffected == 1, result.Error
+}
+
+// CompleteDeviceLinkRequest atomically marks an approved, unexpired request as
+// completed and clears the provisioning blob. Returns false if it was not
+// approved, already completed or has expired.
+func CompleteDeviceLinkRequest(db *gorm.DB, requestID string) (bool, error) {
+	now := time.Now()
+	result := db.Model(&DeviceLinkRequest{}).
+		Where("id = ? AND status = ? AND expires_at > ?", requestID, DeviceLinkApproved, now).
+		Updates(map[string]interface{}{
+			"status":            DeviceLinkCompleted,
+			"provisioning_blob": "",
+			"completed_at":      now,
+		})
+	return result.RowsAffected == 1, result.Error
+}
+
+// DeleteExpiredDeviceLinkRequests removes link requests that expired before the cutoff
+func DeleteExpiredDeviceLinkRequests(db *gorm.DB, before time.Time) (int64, error) {
+	result := db.Where("expires_at < ?", before).Delete(&DeviceLinkRequest{})
+	return result.RowsAffected, result.Error
+}
@@ -20,8 +20,11 @@ type DeviceToken struct {
 	UserID    string         `gorm:"not null;index" json:"user_id"`
 	Token     string         `gorm:"not null;uniqueIndex" json:"token"`
 	Platform  DevicePlatform `gorm:"not null" json:"platform"`
+	Provider  string         `gorm:"index" json:"provider"` // Push provider that delivers to this token; empty for tokens registered before providers were stored
 	DeviceID  string         `json:"device_id,omitempty"` // Optional device identifier
 	AppVersion string        `json:"app_version,omitempty"`
+	PushKey   string         `json:"push_key,omitempty"` // P-256 public key (base64url) private pushes are encrypted to
+	PushAuth  string         `json:"-"`                  // 16-byte auth secret (base64url) paired with PushKey
 	CreatedAt time.Time      `json:"created_at"`
 	UpdatedAt time.Time      `json:"updated_at"`
 
@@ -42,8 +45,15 @@ func GetUserTokens(db *gorm.DB, userID string) ([]DeviceToken, error) {
 	return tokens, err
 }
 
+// GetTokensByValue retrieves the device tokens matching the given token strings
+func GetTokensByValue(db *gorm.DB, tokens []string) ([]DeviceToken, error) {
+	var deviceTokens []DeviceToken
+	err := db.Where("token IN ?", tokens).Find(&deviceTokens).Error
+	return deviceTokens, err
+}
+
 // RegisterToken creates or updates a device token for a user
-func RegisterToken(db *gorm.DB, userID, token string, platform DevicePlatform, deviceID, appVersion string) (*DeviceToken, error) {
+func RegisterToken(db *gorm.DB, userID, token string, platform DevicePlatform, provider, deviceID, appVersion string) (*DeviceToken, error) {
 	var existingToken DeviceToken
 
 	// Check if token already exists
@@ -52,6 +62,7 @@ func RegisterToken(db *gorm.DB, userID, token string, platform DevicePlatform, d
 		// Token exists, update it
 		existingToken.UserID = userID
 		existingToken.Platform = platform
+		existingToken.Provider = provider
 		existingToken.DeviceID = deviceID
 		existingToken.AppVersion = appVersion
 		if err := db.Save(&existingToken).Error; err != nil {
@@ -65,6 +76,7 @@ func RegisterToken(db *gorm.DB, userID, token string, platform DevicePlatform, d
 		UserID:     userID,
 		Token:      token,
 		Platform:   platform,
+		Provider:   provider,
 		DeviceID:   deviceID,
 		AppVersion: appVersion,
 	}
@@ -74,6 +86,15 @@ func RegisterToken(db *gorm.DB, userID, token string, platform DevicePlatform, d
 	return &newToken, nil
 }
 
+// SetTokenPushKey stores the key a device's private pushes are encrypted to.
+// Empty values remove it, so the device gets content-free pushes instead.
+func SetTokenPushKey(db *gorm.DB, tokenID, pushKey, pushAuth string) error {
+	return db.Model(&DeviceToken{}).Where("id = ?", tokenID).Updates(map[string]interface{}{
+		"push_key":  pushKey,
+		"push_auth": pushAuth,
+	}).Error
+}
+
 // UnregisterToken removes a device token
 func UnregisterToken(db *gorm.DB, token string) error {
 	return db.Where("token = ?", token).Delete(&DeviceToken{}).Error
@@ -0,0 +1,79 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// ExternalIdentity links a user to an account at an external identity provider (SSO)
+type ExternalIdentity struct {
+	ID          string     `gorm:"primaryKey" json:"id"`
+	UserID      string     `gorm:"not null;index" json:"user_id"`
+	Issuer      string     `gorm:"not null;uniqueIndex:idx_external_identity_subject" json:"issuer"`
+	Subject     string     `gorm:"not null;uniqueIndex:idx_external_identity_subject" json:"subject"`
+	Email       string     `json:"email,omitempty"`
+	LastLoginAt *time.Time `json:"last_login_at,omitempty"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+
+	User User `gorm:"foreignKey:UserID" json:"-"`
+}
+
+func (e *ExternalIdentity) BeforeCreate(tx *gorm.DB) error {
+	if e.ID == "" {
+		e.ID = uuid.New().String()
+	}
+	return nil
+}
+
+// OIDCAuthRequest holds the state of an SSO login between the redirect to the
+// identity provider and the callback. Each request can be completed once.
+type OIDCAuthRequest struct {
+	State         string    `gorm:"primaryKey" json:"-"`
+	Nonce         string    `gorm:"not null" json:"-"`
+	CodeVerifier  string    `gorm:"not null" json:"-"` // PKCE verifier
+	LinkUserID    string    `json:"-"`                 // Set when a logged-in user is linking their account
+	LinkSessionID string    `json:"-"`                 // The session that started the link; only it can complete it
+	ExpiresAt     time.Time `gorm:"index" json:"expires_at"`
+	CreatedAt     time.Time `json:"created_at"`
+}
+
+// GetExternalIdentity looks up a linked identity by issuer and subject
+func GetExternalIdentity(db *gorm.DB, issuer, subject string) (*ExternalIdentity, error) {
+	var identity ExternalIdentity
+	if err := db.Where("issuer = ? AND subject = ?", issuer, subject).First(&identity).Error; err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+// GetUserExternalIdentities returns all identities linked to a user
+func GetUserExternalIdentities(db *gorm.DB, userID string) ([]ExternalIdentity, error) {
+	var identities []ExternalIdentity
+	err := db.Where("user_id = ?", userID).Order("created_at ASC").Find(&identities).Error
+	return identities, err
+}
+
+// TakeOIDCAuthRequest fetches and deletes an auth request so it can't be used twice
+func TakeOIDCAuthRequest(db *gorm.DB, state string) (*OIDCAuthRequest, error) {
+	var req OIDCAuthRequest
+	if err := db.Where("state = ?", state).First(&req).Error; err != nil {
+		return nil, err
+	}
+	result := db.Where("state = ?", state).Delete(&OIDCAuthRequest{})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return nil, gorm.ErrRecordNotFound
+	}
+	return &req, nil
+}
+
+// DeleteExpiredOIDCAuthRequests removes abandoned SSO logins
+func DeleteExpiredOIDCAuthRequests(db *gorm.DB, before time.Time) (int64, error) {
+	result := db.Where("expires_at < ?", before).Delete(&OIDCAuthRequest{})
+	return result.RowsAffected, result.Error
+}
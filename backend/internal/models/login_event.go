@@ -0,0 +1,121 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Login failure reasons recorded on LoginEvent
+const (
+	LoginFailureInvalidPassword  = "invalid_password"
+	LoginFailureInvalidTwoFactor = "invalid_2fa_code"
+	LoginFailureInvalidSMSCode   = "invalid_sms_code"
+	LoginFailureLocked           = "account_locked"
+)
+
+// LoginEvent records a sign-in attempt against an account, successful or not
+type LoginEvent struct {
+	ID            string    `gorm:"primaryKey" json:"id"`
+	UserID        string    `gorm:"not null;index" json:"-"`
+	Success       bool      `gorm:"not null" json:"success"`
+	FailureReason string    `json:"failure_reason,omitempty"`
+	IPAddress     string    `json:"ip_address,omitempty"`
+	UserAgent     string    `json:"user_agent,omitempty"`
+	DeviceName    string    `json:"device_name,omitempty"`
+	DeviceHash    string    `gorm:"index" json:"-"`       // Identifies the client (user agent and device name) for new-device detection
+	NewDevice     bool      `json:"new_device,omitempty"` // First successful login from this client
+	SessionID     string    `json:"session_id,omitempty"` // Session started by a successful login
+	CreatedAt     time.Time `gorm:"index" json:"created_at"`
+}
+
+func (e *LoginEvent) BeforeCreate(tx *gorm.DB) error {
+	if e.ID == "" {
+		e.ID = uuid.New().String()
+	}
+	return nil
+}
+
+// ListLoginEvents returns a user's most recent login events, newest first
+func ListLoginEvents(db *gorm.DB, userID string, limit int) ([]LoginEvent, error) {
+	var events []LoginEvent
+	err := db.Where("user_id = ?", userID).Order("created_at DESC").Limit(limit).Find(&events).Error
+	return events, err
+}
+
+// HasSuccessfulLogin reports whether the user has logged in before, optionally
+// only counting logins from the client identified by deviceHash
+func HasSuccessfulLogin(db *gorm.DB, userID, deviceHash string) bool {
+	query := db.Model(&LoginEvent{}).Where("user_id = ? AND success = ?", userID, true)
+	if deviceHash != "" {
+		query = query.Where("device_hash = ?", deviceHash)
+	}
+	var count int64
+	query.Count(&count)
+	return count > 0
+}
+
+// DeleteLoginEventsBefore removes login history older than the retention period
+func DeleteLoginEventsBefore(db *gorm.DB, before time.Time) (int64, error) {
+	result := db.Where("created_at < ?", before).Delete(&LoginEvent{})
+	return result.RowsAffected, result.Error
+}
+
+// LoginLockout tracks consecutive failed logins for an account. Each time
+// FailedAttempts reaches the threshold the account is locked, and each
+// further lockout lasts twice as long as the previous one.
+type LoginLockout struct {
+	UserID         string     `gorm:"primaryKey" json:"user_id"`
+	FailedAttempts int        `gorm:"not null;default:0" json:"failed_attempts"`
+	Lockouts       int        `gorm:"not null;default:0" json:"lockouts"`
+	LockedUntil    *time.Time `json:"locked_until,omitempty"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// IsLocked returns true if the account is currently locked
+func (l *LoginLockout) IsLocked() bool {
+	return l.LockedUntil != nil && time.Now().Before(*l.LockedUntil)
+}
+
+// GetLoginLockout returns the lockout state for a user, or a zero state if
+// there have been no recent failures
+func GetLoginLockout(db *gorm.DB, userID string) *LoginLockout {
+	lockout := LoginLockout{UserID: userID}
+	db.Where("user_id = ?", userID).First(&lockout)
+	return &lockout
+}
+
+// ClearLoginLockout resets a user's failed attempts and unlocks the account.
+// Returns false if the account had no lockout state.
+func ClearLoginLockout(db *gorm.DB, userID string) (bool, error) {
+	result := db.Where("user_id = ?", userID).Delete(&LoginLockout{})
+	return result.RowsAffected == 1, result.Error
+}
+
+// IncrementLoginFailures records a failed attempt and returns the updated state
+func IncrementLoginFailures(db *gorm.DB, userID string) (*LoginLockout, error) {
+	if err := db.Where(LoginLockout{UserID: userID}).FirstOrCreate(&LoginLockout{}).Error; err != nil {
+		return nil, err
+	}
+	err := db.Model(&LoginLockout{}).Where("user_id = ?", userID).
+		Update("failed_attempts", gorm.Expr("failed_attempts + 1")).Error
+	if err != nil {
+		return nil, err
+	}
+	return GetLoginLockout(db, userID), nil
+}
+
+// LockLoginAccount locks the account until the given time if it has at least
+// threshold failed attempts, and starts counting attempts again. Returns false
+// if another request already locked it.
+func LockLoginAccount(db *gorm.DB, userID string, threshold int, until time.Time) bool {
+	result := db.Model(&LoginLockout{}).
+		Where("user_id = ? AND failed_attempts >= ?", userID, threshold).
+		Updates(map[string]interface{}{
+			"failed_attempts": 0,
+			"lockouts":        gorm.Expr("lockouts + 1"),
+			"locked_until":    until,
+		})
+	return result.RowsAffected == 1
+}
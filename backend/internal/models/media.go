@@ -42,6 +42,8 @@ type Media struct {
 	Width         *int        `json:"width,omitempty"`          // For images/videos
 	Height        *int        `json:"height,omitempty"`         // For images/videos
 	PageCount     *int        `json:"page_count,omitempty"`     // For documents
+	HLSPath       string      `json:"-"`                        // Master playlist for adaptive video playback
+	HLSURL        string      `json:"hls_url,omitempty"`
 	CreatedAt     time.Time   `json:"created_at"`
 	UpdatedAt     time.Time   `json:"updated_at"`
 
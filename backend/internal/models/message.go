@@ -33,6 +33,7 @@ type Message struct {
 	DeletedAt     *time.Time    `json:"deleted_at,omitempty"`                // Soft delete for "delete for everyone"
 	ExpiresAt     *time.Time    `gorm:"index" json:"expires_at,omitempty"`   // For disappearing messages
 	IsEncrypted   bool          `gorm:"default:false" json:"is_encrypted"`   // E2EE encrypted message
+	CallID        *string       `gorm:"index" json:"call_id,omitempty"`      // Call history record
 	CreatedAt     time.Time     `json:"created_at"`
 	UpdatedAt     time.Time     `json:"updated_at"`
 
@@ -41,6 +42,7 @@ type Message struct {
 	Group     *Group   `gorm:"foreignKey:GroupID" json:"-"`
 	Media     *Media   `gorm:"foreignKey:MediaID" json:"media,omitempty"`
 	ReplyTo   *Message `gorm:"foreignKey:ReplyToID" json:"reply_to,omitempty"`
+	Call      *Call    `gorm:"foreignKey:CallID" json:"call,omitempty"`
 }
 
 // MessageDeletion tracks "delete for me" operations
@@ -0,0 +1,87 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Defaults for users who never changed their notification preferences
+const (
+	DefaultDNDStart = "22:00"
+	DefaultDNDEnd   = "07:00"
+)
+
+// NotificationPreferences are a user's account-wide push notification
+// settings. Per-conversation mute and mentions-only live in ConversationSettings.
+type NotificationPreferences struct {
+	UserID        string    `gorm:"primaryKey" json:"-"`
+	DNDEnabled    bool      `gorm:"default:false" json:"dnd_enabled"`
+	DNDStart      string    `json:"dnd_start"`                           // HH:MM in Timezone
+	DNDEnd        string    `json:"dnd_end"`                             // HH:MM in Timezone; before DNDStart means the window spans midnight
+	Timezone      string    `json:"timezone"`                            // IANA name, e.g. Europe/Berlin
+	HidePreviews  bool      `gorm:"default:false" json:"hide_previews"`  // Push "New message" instead of the message text
+	PrivatePushes bool      `gorm:"default:false" json:"private_pushes"` // Keep message content away from push providers
+	UpdatedAt     time.Time `json:"updated_at"`
+}
+
+// ParseClock parses an HH:MM time of day into minutes after midnight
+func ParseClock(clock string) (int, error) {
+	var hour, minute int
+	if _, err := fmt.Sscanf(clock, "%d:%d", &hour, &minute); err != nil || len(clock) != 5 {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", clock)
+	}
+	if hour < 0 || hour > 23 || minute < 0 || minute > 59 {
+		return 0, fmt.Errorf("invalid time %q, expected HH:MM", clock)
+	}
+	return hour*60 + minute, nil
+}
+
+// InDoNotDisturb returns true if now falls in the user's do-not-disturb window
+func (p *NotificationPreferences) InDoNotDisturb(now time.Time) bool {
+	if !p.DNDEnabled {
+		return false
+	}
+	start, err := ParseClock(p.DNDStart)
+	if err != nil {
+		return false
+	}
+	end, err := ParseClock(p.DNDEnd)
+	if err != nil || start == end {
+		return false
+	}
+
+	location, err := time.LoadLocation(p.Timezone)
+	if err != nil {
+		location = time.UTC
+	}
+	local := now.In(location)
+	minute := local.Hour()*60 + local.Minute()
+
+	if start < end {
+		return minute >= start && minute < end
+	}
+	return minute >= start || minute < end // Spans midnight
+}
+
+// GetNotificationPreferences returns a user's preferences, or the defaults if
+// they never saved any
+func GetNotificationPreferences(db *gorm.DB, userID string) (*NotificationPreferences, error) {
+	prefs := NotificationPreferences{
+		UserID:   userID,
+		DNDStart: DefaultDNDStart,
+		DNDEnd:   DefaultDNDEnd,
+		Timezone: "UTC",
+	}
+	err := db.Where("user_id = ?", userID).First(&prefs).Error
+	if err == gorm.ErrRecordNotFound {
+		return &prefs, nil
+	}
+	return &prefs, err
+}
+
+// SaveNotificationPreferences creates or replaces a user's preferences
+func SaveNotificationPreferences(db *gorm.DB, prefs *NotificationPreferences) error {
+	return db.Save(prefs).Error
+}
@@ -0,0 +1,71 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// PasswordResetToken is a single-use password reset token. Only a hash of the token is stored.
+type PasswordResetToken struct {
+	ID        string     `gorm:"primaryKey" json:"id"`
+	UserID    string     `gorm:"not null;index" json:"user_id"`
+	TokenHash string     `gorm:"not null;uniqueIndex" json:"-"`
+	ExpiresAt time.Time  `gorm:"index" json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	RequestIP string     `json:"request_ip,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+
+	User User `gorm:"foreignKey:UserID" json:"-"`
+}
+
+func (t *PasswordResetToken) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == "" {
+		t.ID = uuid.New().String()
+	}
+	return nil
+}
+
+// IsValid returns true if the token has not been used or expired
+func (t *PasswordResetToken) IsValid() bool {
+	return t.UsedAt == nil && time.Now().Before(t.ExpiresAt)
+}
+
+// GetPasswordResetTokenByHash looks up a reset token by its hash
+func GetPasswordResetTokenByHash(db *gorm.DB, tokenHash string) (*PasswordResetToken, error) {
+	var token PasswordResetToken
+	if err := db.Where("token_hash = ?", tokenHash).First(&token).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// MarkPasswordResetTokenUsed atomically marks a token as used.
+// Returns false if it had already been used.
+func MarkPasswordResetTokenUsed(db *gorm.DB, tokenID string) (bool, error) {
+	result := db.Model(&PasswordResetToken{}).
+		Where("id = ? AND used_at IS NULL", tokenID).
+		Update("used_at", time.Now())
+	return result.RowsAffected == 1, result.Error
+}
+
+// InvalidatePasswordResetTokens marks all of a user's outstanding reset tokens as used
+func InvalidatePasswordResetTokens(db *gorm.DB, userID string) error {
+	return db.Model(&PasswordResetToken{}).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Update("used_at", time.Now()).Error
+}
+
+// CountPasswordResetTokensSince returns how many reset tokens were issued to a user since a time
+func CountPasswordResetTokensSince(db *gorm.DB, userID string, since time.Time) int64 {
+	var count int64
+	db.Model(&PasswordResetToken{}).Where("user_id = ? AND created_at > ?", userID, since).Count(&count)
+	return count
+}
+
+// DeleteExpiredPasswordResetTokens removes reset tokens that expired before the cutoff
+func DeleteExpiredPasswordResetTokens(db *gorm.DB, before time.Time) (int64, error) {
+	result := db.Where("expires_at < ?", before).Delete(&PasswordResetToken{})
+	return result.RowsAffected, result.Error
+}
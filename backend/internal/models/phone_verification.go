@@ -0,0 +1,87 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// PhoneVerificationPurpose is what an OTP code was sent for
+type PhoneVerificationPurpose string
+
+const (
+	PhoneVerificationVerify PhoneVerificationPurpose = "verify" // Prove ownership of a number on an account
+	PhoneVerificationLogin  PhoneVerificationPurpose = "login"  // Passwordless login
+
+	PhoneVerificationAccountDeletion PhoneVerificationPurpose = "account_deletion" // Confirm deleting an account without a password
+)
+
+// PhoneVerification is a one-time code sent by SMS. Only a keyed hash of the code is stored.
+type PhoneVerification struct {
+	ID         string                   `gorm:"primaryKey" json:"id"`
+	Phone      string                   `gorm:"not null;index" json:"phone"`
+	UserID     string                   `gorm:"index" json:"user_id,omitempty"`
+	Purpose    PhoneVerificationPurpose `gorm:"not null" json:"purpose"`
+	CodeHash   string                   `gorm:"not null" json:"-"`
+	Attempts   int                      `gorm:"default:0" json:"attempts"`
+	ExpiresAt  time.Time                `gorm:"index" json:"expires_at"`
+	ConsumedAt *time.Time               `json:"consumed_at,omitempty"`
+	CreatedAt  time.Time                `json:"created_at"`
+}
+
+func (v *PhoneVerification) BeforeCreate(tx *gorm.DB) error {
+	if v.ID == "" {
+		v.ID = uuid.New().String()
+	}
+	return nil
+}
+
+// GetPendingPhoneVerification returns the newest unconsumed, unexpired code for a number and purpose
+func GetPendingPhoneVerification(db *gorm.DB, phone string, purpose PhoneVerificationPurpose) (*PhoneVerification, error) {
+	var v PhoneVerification
+	err := db.Where("phone = ? AND purpose = ? AND consumed_at IS NULL AND expires_at > ?", phone, purpose, time.Now()).
+		Order("created_at DESC").
+		First(&v).Error
+	if err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// CountPhoneVerificationsSince returns how many codes were sent to a number since a time
+func CountPhoneVerificationsSince(db *gorm.DB, phone string, since time.Time) int64 {
+	var count int64
+	db.Model(&PhoneVerification{}).Where("phone = ? AND created_at > ?", phone, since).Count(&count)
+	return count
+}
+
+// IncrementPhoneVerificationAttempts atomically records a guess. Returns false
+// if the code has already used up its attempts.
+func IncrementPhoneVerificationAttempts(db *gorm.DB, id string, maxAttempts int) (bool, error) {
+	result := db.Model(&PhoneVerification{}).
+		Where("id = ? AND attempts < ?", id, maxAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	return result.RowsAffected == 1, result.Error
+}
+
+// ConsumePhoneVerification marks a code as used. Returns false if it was already consumed.
+func ConsumePhoneVerification(db *gorm.DB, id string) (bool, error) {
+	result := db.Model(&PhoneVerification{}).
+		Where("id = ? AND consumed_at IS NULL", id).
+		Update("consumed_at", time.Now())
+	return result.RowsAffected == 1, result.Error
+}
+
+// InvalidatePhoneVerifications consumes all pending codes for a number and purpose
+func InvalidatePhoneVerifications(db *gorm.DB, phone string, purpose PhoneVerificationPurpose) error {
+	return db.Model(&PhoneVerification{}).
+		Where("phone = ? AND purpose = ? AND consumed_at IS NULL", phone, purpose).
+		Update("consumed_at", time.Now()).Error
+}
+
+// DeleteExpiredPhoneVerifications removes codes that expired before the cutoff
+func DeleteExpiredPhoneVerifications(db *gorm.DB, before time.Time) (int64, error) {
+	result := db.Where("expires_at < ?", before).Delete(&PhoneVerification{})
+	return result.RowsAffected, result.Error
+}
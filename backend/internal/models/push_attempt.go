@@ -0,0 +1,72 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Outcomes recorded on PushAttempt
+const (
+	PushAttemptDelivered    = "delivered"     // Accepted by the provider
+	PushAttemptRetry        = "retry"         // Failed for now; the queue tries again
+	PushAttemptFailed       = "failed"        // Failed for good, e.g. a rejected payload
+	PushAttemptInvalidToken = "invalid_token" // The provider no longer knows the token
+	PushAttemptUnroutable   = "unroutable"    // Neither the token's provider nor the fallback is configured
+)
+
+// PushAttempt records one push send to one device, so delivery problems can
+// be diagnosed per device
+type PushAttempt struct {
+	ID            string    `gorm:"primaryKey" json:"id"`
+	UserID        string    `gorm:"not null;index" json:"-"`
+	DeviceTokenID string    `gorm:"index" json:"device_token_id"`
+	Provider      string    `gorm:"not null" json:"provider"`
+	Type          string    `json:"type,omitempty"` // Notification type, e.g. new_message
+	Outcome       string    `gorm:"not null" json:"outcome"`
+	Error         string    `json:"error,omitempty"`
+	LatencyMs     int64     `json:"latency_ms"`             // Duration of the provider call
+	TokenPruned   bool      `json:"token_pruned,omitempty"` // The token was unregistered after this attempt
+	Diagnostic    bool      `json:"diagnostic,omitempty"`   // Sent by a diagnostics check
+	CreatedAt     time.Time `gorm:"index" json:"created_at"`
+}
+
+func (a *PushAttempt) BeforeCreate(tx *gorm.DB) error {
+	if a.ID == "" {
+		a.ID = uuid.New().String()
+	}
+	return nil
+}
+
+// RecordPushAttempts stores the outcomes of one send
+func RecordPushAttempts(db *gorm.DB, attempts []PushAttempt) error {
+	if len(attempts) == 0 {
+		return nil
+	}
+	return db.Create(&attempts).Error
+}
+
+// ListPushAttempts returns a user's most recent push attempts, newest first
+func ListPushAttempts(db *gorm.DB, userID string, limit int) ([]PushAttempt, error) {
+	var attempts []PushAttempt
+	err := db.Where("user_id = ?", userID).Order("created_at DESC").Limit(limit).Find(&attempts).Error
+	return attempts, err
+}
+
+// LastDeliveredPushAttempt returns when a push last reached a device, or zero
+func LastDeliveredPushAttempt(db *gorm.DB, deviceTokenID string) time.Time {
+	var attempt PushAttempt
+	err := db.Where("device_token_id = ? AND outcome = ?", deviceTokenID, PushAttemptDelivered).
+		Order("created_at DESC").First(&attempt).Error
+	if err != nil {
+		return time.Time{}
+	}
+	return attempt.CreatedAt
+}
+
+// DeletePushAttemptsBefore removes push attempts older than the retention period
+func DeletePushAttemptsBefore(db *gorm.DB, before time.Time) (int64, error) {
+	result := db.Where("created_at < ?", before).Delete(&PushAttempt{})
+	return result.RowsAffected, result.Error
+}
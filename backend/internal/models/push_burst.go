@@ -0,0 +1,103 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// PushBurst is an open burst window for message pushes to one user in one
+// conversation. Messages arriving while it is open are counted in Held and
+// summarised when WindowEndsAt passes. Kept in the database so a restart
+// doesn't lose held-back messages.
+type PushBurst struct {
+	ID             string    `gorm:"primaryKey" json:"id"`
+	RecipientID    string    `gorm:"not null;uniqueIndex:idx_push_burst_thread" json:"recipient_id"`
+	ThreadID       string    `gorm:"not null;uniqueIndex:idx_push_burst_thread" json:"thread_id"`
+	Title          string    `json:"title"` // Group name, or the sender's name for DMs
+	ConversationID string    `gorm:"not null" json:"conversation_id"`
+	GroupID        *string   `json:"group_id,omitempty"`
+	SenderID       string    `gorm:"not null;index" json:"sender_id"`
+	Held           int       `gorm:"not null;default:0" json:"held"` // Messages held back since the last push
+	WindowEndsAt   time.Time `gorm:"not null;index" json:"window_ends_at"`
+	CreatedAt      time.Time `json:"created_at"`
+	UpdatedAt      time.Time `json:"updated_at"`
+}
+
+func (b *PushBurst) BeforeCreate(tx *gorm.DB) error {
+	if b.ID == "" {
+		b.ID = uuid.New().String()
+	}
+	return nil
+}
+
+// AdmitPushBurst opens a burst window, or counts a held-back message if one
+// is already open for the same recipient and thread. Returns true if a new
+// window was opened and the message should be pushed right away.
+func AdmitPushBurst(db *gorm.DB, burst *PushBurst) (bool, error) {
+	held, err := holdPushBurstMessage(db, burst.RecipientID, burst.ThreadID)
+	if err != nil || held {
+		return false, err
+	}
+
+	if err := db.Create(burst).Error; err != nil {
+		// Another message opened the window first
+		if held, holdErr := holdPushBurstMessage(db, burst.RecipientID, burst.ThreadID); holdErr == nil && held {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func holdPushBurstMessage(db *gorm.DB, recipientID, threadID string) (bool, error) {
+	result := db.Model(&PushBurst{}).
+		Where("recipient_id = ? AND thread_id = ?", recipientID, threadID).
+		Update("held", gorm.Expr("held + 1"))
+	return result.RowsAffected == 1, result.Error
+}
+
+// ClosePushBurstWindow ends a burst window. If messages were held back a new
+// window is opened until nextWindowEnd and the burst is returned with Held
+// set to the number to summarise; otherwise the burst is removed and nil is
+// returned.
+func ClosePushBurstWindow(db *gorm.DB, recipientID, threadID string, nextWindowEnd time.Time) (*PushBurst, error) {
+	var burst PushBurst
+	err := db.Where("recipient_id = ? AND thread_id = ?", recipientID, threadID).First(&burst).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if burst.Held == 0 {
+		result := db.Where("id = ? AND held = 0", burst.ID).Delete(&PushBurst{})
+		if result.Error != nil || result.RowsAffected == 1 {
+			return nil, result.Error
+		}
+		// A message was held back while closing; summarise it below
+		if err := db.First(&burst, "id = ?", burst.ID).Error; err != nil {
+			return nil, err
+		}
+	}
+
+	// Subtract rather than reset so messages held back meanwhile are kept for the next window
+	err = db.Model(&PushBurst{}).Where("id = ?", burst.ID).Updates(map[string]interface{}{
+		"held":           gorm.Expr("held - ?", burst.Held),
+		"window_ends_at": nextWindowEnd,
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+	burst.WindowEndsAt = nextWindowEnd
+	return &burst, nil
+}
+
+// ListPushBursts returns all open burst windows
+func ListPushBursts(db *gorm.DB) ([]PushBurst, error) {
+	var bursts []PushBurst
+	err := db.Find(&bursts).Error
+	return bursts, err
+}
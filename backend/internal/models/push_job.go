@@ -0,0 +1,205 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// PushJob is a queued push notification for one provider. Tokens and Payload
+// are JSON so the job survives restarts exactly as enqueued.
+type PushJob struct {
+	ID            string     `gorm:"primaryKey" json:"id"`
+	UserID        string     `gorm:"index" json:"user_id,omitempty"`
+	Provider      string     `gorm:"not null;index" json:"provider"`
+	Tokens        string     `gorm:"type:text;not null" json:"-"`
+	Payload       string     `gorm:"type:text;not null" json:"-"`
+	Attempts      int        `gorm:"not null;default:0" json:"attempts"`
+	LastError     string     `json:"last_error,omitempty"`
+	NextAttemptAt time.Time  `gorm:"not null;index" json:"next_attempt_at"`
+	LockedUntil   *time.Time `gorm:"index" json:"locked_until,omitempty"` // Set while a worker is sending; the job is retried if the worker dies
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+}
+
+func (j *PushJob) BeforeCreate(tx *gorm.DB) error {
+	if j.ID == "" {
+		j.ID = uuid.New().String()
+	}
+	if j.NextAttemptAt.IsZero() {
+		j.NextAttemptAt = time.Now()
+	}
+	return nil
+}
+
+// ClaimPushJob leases the next due job for a provider until leaseUntil.
+// Returns nil if no job is due or another worker claimed it first.
+func ClaimPushJob(db *gorm.DB, provider string, now, leaseUntil time.Time) (*PushJob, error) {
+	var job PushJob
+	err := db.Where("provider = ? AND next_attempt_at <= ? AND (locked_until IS NULL OR locked_until < ?)", provider, now, now).
+		Order("next_attempt_at ASC").First(&job).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := db.Model(&PushJob{}).
+		Where("id = ? AND (locked_until IS NULL OR locked_until < ?)", job.ID, now).
+		Updates(map[string]interface{}{"locked_until": leaseUntil, "attempts": gorm.Expr("attempts + 1")})
+	if result.Error != nil || result.RowsAffected != 1 {
+		return nil, result.Error
+	}
+
+	job.LockedUntil = &leaseUntil
+	job.Attempts++
+	return &job, nil
+}
+
+// ReschedulePushJob releases a job for another attempt at nextAttempt,
+// narrowing it to the tokens still worth retrying
+func ReschedulePushJob(db *gorm.DB, jobID, tokens, lastError string, nextAttempt time.Time) error {
+	return db.Model(&PushJob{}).Where("id = ?", jobID).Updates(map[string]interface{}{
+		"tokens":          tokens,
+		"last_error":      lastError,
+		"next_attempt_at": nextAttempt,
+		"locked_until":    nil,
+	}).Error
+}
+
+// DeletePushJob removes a finished job
+func DeletePushJob(db *gorm.DB, jobID string) error {
+	return db.Where("id = ?", jobID).Delete(&PushJob{}).Error
+}
+
+// PushQueueDepth returns the number of queued jobs per provider
+func PushQueueDepth(db *gorm.DB) (map[string]int64, error) {
+	var rows []struct {
+		Provider string
+		Count    int64
+	}
+	err := db.Model(&PushJob{}).Select("provider, COUNT(*) AS count").Group("provider").Scan(&rows).Error
+	depth := make(map[string]int64, len(rows))
+	for _, row := range rows {
+		depth[row.Provider] = row.Count
+	}
+	return depth, err
+}
+
+// OldestPushJob returns when the oldest queued job was enqueued, or zero if the queue is empty
+func OldestPushJob(db *gorm.DB) time.Time {
+	var job PushJob
+	if err := db.Order("created_at ASC").First(&job).Error; err != nil {
+		return time.Time{}
+	}
+	return job.CreatedAt
+}
+
+// PushDeadLetter is a push job that failed permanently or ran out of
+// attempts, kept for inspection and manual retry
+type PushDeadLetter struct {
+	ID         string    `gorm:"primaryKey" json:"id"`
+	JobID      string    `gorm:"index" json:"job_id"`
+	UserID     string    `gorm:"index" json:"user_id,omitempty"`
+	Provider   string    `gorm:"not null;index" json:"provider"`
+	Tokens     string    `gorm:"type:text;not null" json:"-"`
+	Payload    string    `gorm:"type:text;not null" json:"-"`
+	Attempts   int       `json:"attempts"`
+	LastError  string    `json:"last_error"`
+	EnqueuedAt time.Time `json:"enqueued_at"`
+	CreatedAt  time.Time `gorm:"index" json:"failed_at"`
+}
+
+func (d *PushDeadLetter) BeforeCreate(tx *gorm.DB) error {
+	if d.ID == "" {
+		d.ID = uuid.New().String()
+	}
+	return nil
+}
+
+// DeadLetterPushJob moves a job to the dead-letter table
+func DeadLetterPushJob(db *gorm.DB, job *PushJob, lastError string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		letter := PushDeadLetter{
+			JobID:      job.ID,
+			UserID:     job.UserID,
+			Provider:   job.Provider,
+			Tokens:     job.Tokens,
+			Payload:    job.Payload,
+			Attempts:   job.Attempts,
+			LastError:  lastError,
+			EnqueuedAt: job.CreatedAt,
+		}
+		if err := tx.Create(&letter).Error; err != nil {
+			return err
+		}
+		return tx.Where("id = ?", job.ID).Delete(&PushJob{}).Error
+	})
+}
+
+// ListPushDeadLetters returns dead letters, newest first, optionally for one provider
+func ListPushDeadLetters(db *gorm.DB, provider string, limit, offset int) ([]PushDeadLetter, int64, error) {
+	query := db.Model(&PushDeadLetter{})
+	if provider != "" {
+		query = query.Where("provider = ?", provider)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var letters []PushDeadLetter
+	err := query.Order("created_at DESC").Limit(limit).Offset(offset).Find(&letters).Error
+	return letters, total, err
+}
+
+// GetPushDeadLetter retrieves a dead letter by ID
+func GetPushDeadLetter(db *gorm.DB, id string) (*PushDeadLetter, error) {
+	var letter PushDeadLetter
+	if err := db.Where("id = ?", id).First(&letter).Error; err != nil {
+		return nil, err
+	}
+	return &letter, nil
+}
+
+// RequeuePushDeadLetter moves a dead letter back into the queue with a fresh
+// attempt count. Returns false if the dead letter no longer exists.
+func RequeuePushDeadLetter(db *gorm.DB, id string) (*PushJob, bool, error) {
+	var job *PushJob
+	err := db.Transaction(func(tx *gorm.DB) error {
+		letter, err := GetPushDeadLetter(tx, id)
+		if err != nil {
+			return err
+		}
+		result := tx.Where("id = ?", id).Delete(&PushDeadLetter{})
+		if result.Error != nil || result.RowsAffected != 1 {
+			return gorm.ErrRecordNotFound
+		}
+		job = &PushJob{
+			UserID:   letter.UserID,
+			Provider: letter.Provider,
+			Tokens:   letter.Tokens,
+			Payload:  letter.Payload,
+		}
+		return tx.Create(job).Error
+	})
+	if err == gorm.ErrRecordNotFound {
+		return nil, false, nil
+	}
+	return job, err == nil, err
+}
+
+// DeletePushDeadLetter discards a dead letter. Returns false if it didn't exist.
+func DeletePushDeadLetter(db *gorm.DB, id string) (bool, error) {
+	result := db.Where("id = ?", id).Delete(&PushDeadLetter{})
+	return result.RowsAffected == 1, result.Error
+}
+
+// DeletePushDeadLettersBefore removes dead letters past their retention period
+func DeletePushDeadLettersBefore(db *gorm.DB, before time.Time) (int64, error) {
+	result := db.Where("created_at < ?", before).Delete(&PushDeadLetter{})
+	return result.RowsAffected, result.Error
+}
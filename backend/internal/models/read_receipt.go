@@ -110,6 +110,48 @@ func GetUnreadCount(db *gorm.DB, userID string, groupID *string, otherUserID *st
 	return count, err
 }
 
+// GetTotalUnreadCount returns the number of unread messages across all of a
+// user's conversations, for the app icon badge. A DM counts as read once its
+// status is read or the user has a receipt for it; group messages need a
+// receipt and only count from when the user joined the group. Scheduled
+// messages that haven't gone out yet are left out.
+func GetTotalUnreadCount(db *gorm.DB, userID string) (int64, error) {
+	var dms, groups int64
+	if err := unreadDMs(db, userID).Count(&dms).Error; err != nil {
+		return 0, err
+	}
+	err := unreadGroupMessages(db, userID).Count(&groups).Error
+	return dms + groups, err
+}
+
+// GetConversationUnreadCount returns the number of unread messages in one DM
+// or group, counted the same way as GetTotalUnreadCount
+func GetConversationUnreadCount(db *gorm.DB, userID string, groupID *string, otherUserID *string) (int64, error) {
+	var count int64
+	var err error
+	if groupID != nil {
+		err = unreadGroupMessages(db, userID).Where("messages.group_id = ?", *groupID).Count(&count).Error
+	} else if otherUserID != nil {
+		err = unreadDMs(db, userID).Where("sender_id = ?", *otherUserID).Count(&count).Error
+	}
+	return count, err
+}
+
+func unreadDMs(db *gorm.DB, userID string) *gorm.DB {
+	read := db.Model(&MessageReadReceipt{}).Select("message_id").Where("user_id = ?", userID)
+	return db.Model(&Message{}).
+		Where("recipient_id = ? AND group_id IS NULL AND deleted_at IS NULL AND scheduled_at IS NULL AND status != ?", userID, MessageStatusRead).
+		Where("id NOT IN (?)", read)
+}
+
+func unreadGroupMessages(db *gorm.DB, userID string) *gorm.DB {
+	read := db.Model(&MessageReadReceipt{}).Select("message_id").Where("user_id = ?", userID)
+	return db.Model(&Message{}).
+		Joins("JOIN group_members ON group_members.group_id = messages.group_id AND group_members.user_id = ?", userID).
+		Where("messages.sender_id != ? AND messages.deleted_at IS NULL AND messages.scheduled_at IS NULL AND messages.created_at >= group_members.joined_at", userID).
+		Where("messages.id NOT IN (?)", read)
+}
+
 type ReadReceiptResponse struct {
 	UserID      string    `json:"user_id"`
 	Username    string    `json:"username"`
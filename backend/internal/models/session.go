@@ -0,0 +1,131 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Session reasons recorded when a session is revoked
+const (
+	SessionRevokedLogout         = "logout"
+	SessionRevokedLogoutAll      = "logout_all"
+	SessionRevokedByUser         = "revoked"
+	SessionRevokedTokenReuse     = "refresh_token_reuse"
+	SessionRevokedPasswordChange = "password_change"
+	SessionRevokedPasswordReset  = "password_reset"
+	SessionRevokedAccountDeleted = "account_deleted"
+)
+
+// Session is a logged-in device. All refresh tokens issued by rotating the
+// session's original refresh token belong to the same session (token family).
+type Session struct {
+	ID            string     `gorm:"primaryKey" json:"id"`
+	UserID        string     `gorm:"not null;index" json:"-"`
+	DeviceName    string     `json:"device_name,omitempty"`
+	UserAgent     string     `json:"user_agent,omitempty"`
+	IPAddress     string     `json:"ip_address,omitempty"`
+	LastUsedAt    time.Time  `json:"last_used_at"`
+	ExpiresAt     time.Time  `gorm:"index" json:"expires_at"`
+	RevokedAt     *time.Time `json:"revoked_at,omitempty"`
+	RevokedReason string     `json:"revoked_reason,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+	UpdatedAt     time.Time  `json:"updated_at"`
+
+	User User `gorm:"foreignKey:UserID" json:"-"`
+}
+
+func (s *Session) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == "" {
+		s.ID = uuid.New().String()
+	}
+	return nil
+}
+
+// IsActive returns true if the session has not been revoked or expired
+func (s *Session) IsActive() bool {
+	return s.RevokedAt == nil && time.Now().Before(s.ExpiresAt)
+}
+
+// RefreshToken is a single-use refresh token. Only a hash of the token is stored.
+type RefreshToken struct {
+	ID        string     `gorm:"primaryKey" json:"id"`
+	SessionID string     `gorm:"not null;index" json:"session_id"`
+	TokenHash string     `gorm:"not null;uniqueIndex" json:"-"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	UsedAt    *time.Time `json:"used_at,omitempty"` // Set when rotated; reuse after this revokes the session
+	CreatedAt time.Time  `json:"created_at"`
+
+	Session Session `gorm:"foreignKey:SessionID" json:"-"`
+}
+
+func (t *RefreshToken) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == "" {
+		t.ID = uuid.New().String()
+	}
+	return nil
+}
+
+// GetSession returns a session by ID
+func GetSession(db *gorm.DB, sessionID string) (*Session, error) {
+	var session Session
+	if err := db.First(&session, "id = ?", sessionID).Error; err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// GetActiveSessions returns a user's sessions that are neither revoked nor expired
+func GetActiveSessions(db *gorm.DB, userID string) ([]Session, error) {
+	var sessions []Session
+	err := db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, time.Now()).
+		Order("last_used_at DESC").
+		Find(&sessions).Error
+	return sessions, err
+}
+
+// GetRefreshTokenByHash looks up a refresh token by its hash
+func GetRefreshTokenByHash(db *gorm.DB, tokenHash string) (*RefreshToken, error) {
+	var token RefreshToken
+	if err := db.Where("token_hash = ?", tokenHash).First(&token).Error; err != nil {
+		return nil, err
+	}
+	return &token, nil
+}
+
+// MarkRefreshTokenUsed atomically marks a refresh token as used.
+// Returns false if it had already been used.
+func MarkRefreshTokenUsed(db *gorm.DB, tokenID string) (bool, error) {
+	result := db.Model(&RefreshToken{}).
+		Where("id = ? AND used_at IS NULL", tokenID).
+		Update("used_at", time.Now())
+	return result.RowsAffected == 1, result.Error
+}
+
+// RevokeSession marks a session as revoked. Returns false if it was already revoked.
+func RevokeSession(db *gorm.DB, sessionID, reason string) (bool, error) {
+	result := db.Model(&Session{}).
+		Where("id = ? AND revoked_at IS NULL", sessionID).
+		Updates(map[string]interface{}{
+			"revoked_at":     time.Now(),
+			"revoked_reason": reason,
+		})
+	return result.RowsAffected == 1, result.Error
+}
+
+// DeleteExpiredSessions removes sessions (and their refresh tokens) that expired before the cutoff
+func DeleteExpiredSessions(db *gorm.DB, before time.Time) (int64, error) {
+	var ids []string
+	if err := db.Model(&Session{}).Where("expires_at < ?", before).Pluck("id", &ids).Error; err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+	if err := db.Where("session_id IN ?", ids).Delete(&RefreshToken{}).Error; err != nil {
+		return 0, err
+	}
+	result := db.Where("id IN ?", ids).Delete(&Session{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// SigningKey is a key pair used to sign access tokens. A key signs until
+// SignUntil and stays published in the JWKS until VerifyUntil so tokens it
+// signed keep validating after rotation.
+type SigningKey struct {
+	ID          string    `gorm:"primaryKey" json:"kid"`
+	Algorithm   string    `gorm:"not null" json:"alg"`
+	PrivateKey  []byte    `gorm:"not null" json:"-"` // PKCS#8, encrypted with a key derived from JWT_SECRET
+	PublicKey   []byte    `gorm:"not null" json:"-"` // PKIX
+	SignUntil   time.Time `gorm:"not null" json:"sign_until"`
+	VerifyUntil time.Time `gorm:"not null;index" json:"verify_until"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// GetVerifyingSigningKeys returns keys still valid for verification, newest first
+func GetVerifyingSigningKeys(db *gorm.DB, now time.Time) ([]SigningKey, error) {
+	var keys []SigningKey
+	err := db.Where("verify_until > ?", now).Order("created_at DESC").Find(&keys).Error
+	return keys, err
+}
+
+// DeleteExpiredSigningKeys removes keys that no longer verify any token
+func DeleteExpiredSigningKeys(db *gorm.DB, before time.Time) (int64, error) {
+	result := db.Where("verify_until < ?", before).Delete(&SigningKey{})
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,202 @@
+package models
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// StorageQuota overrides the role-based storage quota for a single user
+type StorageQuota struct {
+	ID         string    `gorm:"primaryKey" json:"id"`
+	UserID     string    `gorm:"not null;uniqueIndex" json:"user_id"`
+	QuotaBytes int64     `gorm:"not null" json:"quota_bytes"` // 0 = unlimited
+	SetBy      string    `json:"set_by,omitempty"`            // Admin who set the override
+	Reason     string    `json:"reason,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	UpdatedAt  time.Time `json:"updated_at"`
+
+	User User `gorm:"foreignKey:UserID" json:"-"`
+}
+
+func (q *StorageQuota) BeforeCreate(tx *gorm.DB) error {
+	if q.ID == "" {
+		q.ID = uuid.New().String()
+	}
+	return nil
+}
+
+// MediaTypeUsage is the storage used by a single media type
+type MediaTypeUsage struct {
+	MediaType MediaType `json:"media_type"`
+	Bytes     int64     `json:"bytes"`
+	Count     int64     `json:"count"`
+}
+
+// ConversationUsage is the storage used by media sent to a single conversation
+type ConversationUsage struct {
+	ConversationID   string `json:"conversation_id,omitempty"`
+	ConversationType string `json:"conversation_type"` // "dm", "group" or "unsent"
+	Bytes            int64  `json:"bytes"`
+	Count            int64  `json:"count"`
+}
+
+// GetStorageQuota returns the quota override for a user, if any
+func GetStorageQuota(db *gorm.DB, userID string) (*StorageQuota, error) {
+	var quota StorageQuota
+	err := db.Where("user_id = ?", userID).First(&quota).Error
+	if err != nil {
+		return nil, err
+	}
+	return &quota, nil
+}
+
+// SetStorageQuota creates or updates the quota override for a user
+func SetStorageQuota(db *gorm.DB, userID string, quotaBytes int64, setBy, reason string) (*StorageQuota, error) {
+	var existing StorageQuota
+	if err := db.Where("user_id = ?", userID).First(&existing).Error; err == nil {
+		existing.QuotaBytes = quotaBytes
+		existing.SetBy = setBy
+		existing.Reason = reason
+		if err := db.Save(&existing).Error; err != nil {
+			return nil, err
+		}
+		return &existing, nil
+	}
+
+	quota := StorageQuota{
+		UserID:     userID,
+		QuotaBytes: quotaBytes,
+		SetBy:      setBy,
+		Reason:     reason,
+	}
+	if err := db.Create(&quota).Error; err != nil {
+		return nil, err
+	}
+	return &quota, nil
+}
+
+// DeleteStorageQuota removes a user's quota override, reverting to the role default
+func DeleteStorageQuota(db *gorm.DB, userID string) error {
+	return db.Where("user_id = ?", userID).Delete(&StorageQuota{}).Error
+}
+
+// storedMedia scopes a query to media that still occupies disk space.
+// Rejected and deleted media have their storage path cleared.
+func storedMedia(db *gorm.DB, userID string) *gorm.DB {
+	return db.Model(&Media{}).Where("uploader_id = ? AND storage_path != ''", userID)
+}
+
+// GetStorageUsed returns the total bytes of stored media uploaded by a user
+func GetStorageUsed(db *gorm.DB, userID string) (int64, error) {
+	var total int64
+	err := storedMedia(db, userID).Select("COALESCE(SUM(size), 0)").Scan(&total).Error
+	return total, err
+}
+
+// ClaimMediaStorage gives a media record its storage path, which makes its
+// size count towards the uploader's usage, if that keeps them within
+// quotaBytes (0 = unlimited). The check and the claim are one UPDATE, so
+// concurrent uploads can't both squeeze into the same remaining space.
+// Returns false if the quota would be exceeded.
+func ClaimMediaStorage(db *gorm.DB, media *Media, storagePath string, quotaBytes int64) (bool, error) {
+	query := db.Model(&Media{}).Where("id = ?", media.ID)
+	if quotaBytes > 0 {
+		query = query.Where(
+			"(SELECT COALESCE(SUM(size), 0) FROM media WHERE uploader_id = ? AND storage_path != '') + size <= ?",
+			media.UploaderID, quotaBytes,
+		)
+	}
+	result := query.Update("storage_path", storagePath)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	media.StoragePath = storagePath
+	return true, nil
+}
+
+// GetStorageUsageByType returns stored media usage grouped by media type
+func GetStorageUsageByType(db *gorm.DB, userID string) ([]MediaTypeUsage, error) {
+	var usage []MediaTypeUsage
+	err := storedMedia(db, userID).
+		Select("media_type, COALESCE(SUM(size), 0) AS bytes, COUNT(*) AS count").
+		Group("media_type").
+		Order("bytes DESC").
+		Scan(&usage).Error
+	return usage, err
+}
+
+// GetStorageUsageByConversation returns stored media usage grouped by the
+// conversation the media was sent to. Media that was never attached to a
+// message is reported under the "unsent" conversation type.
+func GetStorageUsageByConversation(db *gorm.DB, userID string) ([]ConversationUsage, error) {
+	var media []Media
+	if err := storedMedia(db, userID).Select("id, size").Find(&media).Error; err != nil {
+		return nil, err
+	}
+	if len(media) == 0 {
+		return []ConversationUsage{}, nil
+	}
+
+	mediaIDs := make([]string, len(media))
+	for i, m := range media {
+		mediaIDs[i] = m.ID
+	}
+
+	var messages []Message
+	if err := db.Select("media_id, recipient_id, group_id").
+		Where("sender_id = ? AND media_id IN ?", userID, mediaIDs).
+		Order("created_at ASC").
+		Find(&messages).Error; err != nil {
+		return nil, err
+	}
+
+	// A media item can be forwarded into several conversations; attribute it
+	// to the first one it was sent to so totals add up to the overall usage.
+	conversationOf := make(map[string]ConversationUsage)
+	for _, msg := range messages {
+		if msg.MediaID == nil {
+			continue
+		}
+		if _, seen := conversationOf[*msg.MediaID]; seen {
+			continue
+		}
+		if msg.IsGroupMessage() {
+			conversationOf[*msg.MediaID] = ConversationUsage{ConversationID: *msg.GroupID, ConversationType: "group"}
+		} else if msg.RecipientID != nil {
+			conversationOf[*msg.MediaID] = ConversationUsage{ConversationID: *msg.RecipientID, ConversationType: "dm"}
+		}
+	}
+
+	totals := make(map[string]*ConversationUsage)
+	var order []string
+	for _, m := range media {
+		conv, ok := conversationOf[m.ID]
+		if !ok {
+			conv = ConversationUsage{ConversationType: "unsent"}
+		}
+		key := conv.ConversationType + ":" + conv.ConversationID
+		entry, exists := totals[key]
+		if !exists {
+			entry = &ConversationUsage{ConversationID: conv.ConversationID, ConversationType: conv.ConversationType}
+			totals[key] = entry
+			order = append(order, key)
+		}
+		entry.Bytes += m.Size
+		entry.Count++
+	}
+
+	usage := make([]ConversationUsage, 0, len(order))
+	for _, key := range order {
+		usage = append(usage, *totals[key])
+	}
+	sort.SliceStable(usage, func(i, j int) bool {
+		return usage[i].Bytes > usage[j].Bytes
+	})
+	return usage, nil
+}
@@ -0,0 +1,161 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// TwoFactor holds a user's TOTP configuration. A record with Enabled=false
+// is a pending enrolment waiting for the user to confirm a code.
+type TwoFactor struct {
+	ID           string     `gorm:"primaryKey" json:"id"`
+	UserID       string     `gorm:"not null;uniqueIndex" json:"user_id"`
+	Secret       string     `gorm:"not null" json:"-"` // Base32 TOTP secret
+	Enabled      bool       `gorm:"default:false" json:"enabled"`
+	ConfirmedAt  *time.Time `json:"confirmed_at,omitempty"`
+	LastUsedStep int64      `json:"-"` // Last accepted TOTP time step, prevents code replay
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+
+	User User `gorm:"foreignKey:UserID" json:"-"`
+}
+
+func (t *TwoFactor) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == "" {
+		t.ID = uuid.New().String()
+	}
+	return nil
+}
+
+// RecoveryCode is a single-use 2FA backup code. Only a hash of the code is stored.
+type RecoveryCode struct {
+	ID        string     `gorm:"primaryKey" json:"id"`
+	UserID    string     `gorm:"not null;index" json:"user_id"`
+	CodeHash  string     `gorm:"not null;index" json:"-"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func (r *RecoveryCode) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == "" {
+		r.ID = uuid.New().String()
+	}
+	return nil
+}
+
+// GetTwoFactor returns a user's 2FA record, enabled or pending
+func GetTwoFactor(db *gorm.DB, userID string) (*TwoFactor, error) {
+	var tf TwoFactor
+	if err := db.Where("user_id = ?", userID).First(&tf).Error; err != nil {
+		return nil, err
+	}
+	return &tf, nil
+}
+
+// IsTwoFactorEnabled returns true if the user has confirmed 2FA enrolment
+func IsTwoFactorEnabled(db *gorm.DB, userID string) bool {
+	var count int64
+	db.Model(&TwoFactor{}).Where("user_id = ? AND enabled = ?", userID, true).Count(&count)
+	return count > 0
+}
+
+// AdvanceTwoFactorStep records a used TOTP step. Returns false if the step
+// (or a later one) was already used.
+func AdvanceTwoFactorStep(db *gorm.DB, userID string, step int64) (bool, error) {
+	result := db.Model(&TwoFactor{}).
+		Where("user_id = ? AND last_used_step < ?", userID, step).
+		Update("last_used_step", step)
+	return result.RowsAffected == 1, result.Error
+}
+
+// DeleteTwoFactor removes a user's 2FA configuration and recovery codes
+func DeleteTwoFactor(db *gorm.DB, userID string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", userID).Delete(&TwoFactor{}).Error
+	})
+}
+
+// ReplaceRecoveryCodes deletes a user's recovery codes and stores new hashes
+func ReplaceRecoveryCodes(db *gorm.DB, userID string, hashes []string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		for _, hash := range hashes {
+			if err := tx.Create(&RecoveryCode{UserID: userID, CodeHash: hash}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// UseRecoveryCode marks an unused recovery code as used. Returns false if no
+// unused code matches.
+func UseRecoveryCode(db *gorm.DB, userID, codeHash string) (bool, error) {
+	result := db.Model(&RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", time.Now())
+	return result.RowsAffected > 0, result.Error
+}
+
+// CountUnusedRecoveryCodes returns how many recovery codes a user has left
+func CountUnusedRecoveryCodes(db *gorm.DB, userID string) int64 {
+	var count int64
+	db.Model(&RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count)
+	return count
+}
+
+// TwoFactorChallenge is the server-side record of a 2FA challenge token,
+// keyed by the token's jti. It makes the token single-use and counts the
+// codes tried against it.
+type TwoFactorChallenge struct {
+	ID        string     `gorm:"primaryKey" json:"id"`
+	UserID    string     `gorm:"not null;index" json:"user_id"`
+	Attempts  int        `gorm:"default:0" json:"attempts"`
+	UsedAt    *time.Time `json:"used_at,omitempty"`
+	ExpiresAt time.Time  `gorm:"not null;index" json:"expires_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+func (c *TwoFactorChallenge) BeforeCreate(tx *gorm.DB) error {
+	if c.ID == "" {
+		c.ID = uuid.New().String()
+	}
+	return nil
+}
+
+// CreateTwoFactorChallenge stores a new challenge, clearing the user's
+// expired ones
+func CreateTwoFactorChallenge(db *gorm.DB, userID string, expiresAt time.Time) (*TwoFactorChallenge, error) {
+	db.Where("user_id = ? AND expires_at < ?", userID, time.Now()).Delete(&TwoFactorChallenge{})
+
+	challenge := TwoFactorChallenge{UserID: userID, ExpiresAt: expiresAt}
+	if err := db.Create(&challenge).Error; err != nil {
+		return nil, err
+	}
+	return &challenge, nil
+}
+
+// ClaimTwoFactorChallengeAttempt counts a code attempt against a challenge.
+// Returns false if the challenge is unknown, used, expired or out of attempts.
+func ClaimTwoFactorChallengeAttempt(db *gorm.DB, id, userID string, maxAttempts int) (bool, error) {
+	result := db.Model(&TwoFactorChallenge{}).
+		Where("id = ? AND user_id = ? AND used_at IS NULL AND expires_at > ? AND attempts < ?", id, userID, time.Now(), maxAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	return result.RowsAffected == 1, result.Error
+}
+
+// UseTwoFactorChallenge marks a challenge as used. Returns false if it
+// already was.
+func UseTwoFactorChallenge(db *gorm.DB, id string) (bool, error) {
+	result := db.Model(&TwoFactorChallenge{}).
+		Where("id = ? AND used_at IS NULL", id).
+		Update("used_at", time.Now())
+	return result.RowsAffected == 1, result.Error
+}
@@ -16,18 +16,43 @@ const (
 )
 
 type User struct {
-	ID           string    `gorm:"primaryKey" json:"id"`
-	Username     string    `gorm:"uniqueIndex;not null" json:"username"`
-	Phone        *string   `gorm:"uniqueIndex" json:"phone,omitempty"`
-	PasswordHash string    `gorm:"not null" json:"-"`
-	DisplayName  string    `json:"display_name,omitempty"`
-	AvatarURL    string    `json:"avatar_url,omitempty"`
-	About        string    `json:"about,omitempty"`         // Status/bio text
-	StatusEmoji  string    `json:"status_emoji,omitempty"`  // Optional status emoji
-	Role         UserRole  `gorm:"default:user" json:"role,omitempty"`
-	LastSeen     time.Time `json:"last_seen,omitempty"`
-	CreatedAt    time.Time `json:"created_at"`
-	UpdatedAt    time.Time `json:"updated_at"`
+	ID              string     `gorm:"primaryKey" json:"id"`
+	Username        string     `gorm:"uniqueIndex;not null" json:"username"`
+	Phone           *string    `gorm:"uniqueIndex" json:"phone,omitempty"`
+	PhoneVerifiedAt *time.Time `json:"phone_verified_at,omitempty"` // Set once the user proves they own Phone via OTP
+	Email           *string    `gorm:"uniqueIndex" json:"email,omitempty"`
+	EmailVerifiedAt *time.Time `json:"email_verified_at,omitempty"` // Set when an identity provider vouches for Email
+	PasswordHash    string     `gorm:"not null" json:"-"`
+	PasswordUnset   bool       `gorm:"not null;default:false" json:"-"` // Created by single sign-on with an unusable password; cleared once one is set
+	DisplayName     string     `json:"display_name,omitempty"`
+	AvatarURL       string     `json:"avatar_url,omitempty"`
+	About           string     `json:"about,omitempty"`        // Status/bio text
+	StatusEmoji     string     `json:"status_emoji,omitempty"` // Optional status emoji
+	Role            UserRole   `gorm:"default:user" json:"role,omitempty"`
+	Locale          string     `gorm:"default:en" json:"locale,omitempty"` // Language of server-generated text, e.g. push notifications
+	LastSeen        time.Time  `json:"last_seen,omitempty"`
+	DeletedAt       *time.Time `gorm:"index" json:"-"` // Set when the account is erased; the row stays as an anonymous placeholder
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+}
+
+// HasVerifiedPhone returns true if the user's phone number was verified by OTP
+func (u *User) HasVerifiedPhone() bool {
+	return u.Phone != nil && u.PhoneVerifiedAt != nil
+}
+
+// IsDeleted returns true if the account was erased
+func (u *User) IsDeleted() bool {
+	return u.DeletedAt != nil
+}
+
+// IsUserDeleted checks if a user's account was erased
+func IsUserDeleted(db *gorm.DB, userID string) bool {
+	var user User
+	if err := db.Select("id", "deleted_at").First(&user, "id = ?", userID).Error; err != nil {
+		return false
+	}
+	return user.IsDeleted()
 }
 
 // IsAdmin returns true if the user has admin role
@@ -0,0 +1,57 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// WebSocketTicket is a short-lived, single-use credential for opening a
+// WebSocket, so access tokens never appear in URLs. Only a hash is stored.
+type WebSocketTicket struct {
+	ID            string     `gorm:"primaryKey" json:"id"`
+	TicketHash    string     `gorm:"not null;uniqueIndex" json:"-"`
+	UserID        string     `gorm:"not null;index" json:"user_id"`
+	SessionID     string     `gorm:"not null" json:"session_id"`
+	DeviceID      string     `json:"device_id,omitempty"` // E2EE device the ticket is bound to, if any
+	AuthExpiresAt time.Time  `json:"auth_expires_at"`     // Expiry of the access token the ticket was issued for
+	ExpiresAt     time.Time  `gorm:"index" json:"expires_at"`
+	UsedAt        *time.Time `json:"used_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+func (t *WebSocketTicket) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == "" {
+		t.ID = uuid.New().String()
+	}
+	return nil
+}
+
+// GetWebSocketTicketByHash looks up a ticket by the hash of its value
+func GetWebSocketTicketByHash(db *gorm.DB, hash string) (*WebSocketTicket, error) {
+	var ticket WebSocketTicket
+	if err := db.Where("ticket_hash = ?", hash).First(&ticket).Error; err != nil {
+		return nil, err
+	}
+	return &ticket, nil
+}
+
+// UseWebSocketTicket atomically marks an unexpired ticket as used.
+// Returns false if it was already used or has expired.
+func UseWebSocketTicket(db *gorm.DB, ticketID string) (bool, error) {
+	now := time.Now()
+	result := db.Model(&WebSocketTicket{}).
+		Where("id = ? AND used_at IS NULL AND expires_at > ?", ticketID, now).
+		Update("used_at", now)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// DeleteExpiredWebSocketTickets removes tickets that expired before the given time
+func DeleteExpiredWebSocketTickets(db *gorm.DB, before time.Time) (int64, error) {
+	result := db.Where("expires_at < ?", before).Delete(&WebSocketTicket{})
+	return result.RowsAffected, result.Error
+}
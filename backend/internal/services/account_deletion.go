@@ -0,0 +1,380 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"messenger/internal/database"
+	"messenger/internal/models"
+)
+
+// DefaultAccountDeletionGrace is how long a deletion can be cancelled
+const DefaultAccountDeletionGrace = 14 * 24 * time.Hour
+
+// DeletedAccountName is shown in place of an erased user's name
+const DeletedAccountName = "Deleted Account"
+
+var (
+	ErrDeletionNotScheduled  = errors.New("account deletion is not scheduled")
+	ErrTwoFactorCodeRequired = errors.New("two-factor code is required")
+	ErrDeletionCodeRequired  = errors.New("a code sent to your phone is required")
+	ErrDeletionCodeNotNeeded = errors.New("confirm deletion with your password or two-factor code")
+	ErrNoDeletionProof       = errors.New("set a password, enable two-factor authentication or verify a phone number to delete this account")
+)
+
+// AccountDeletedFunc is called after an account is erased with the users who
+// had it as a contact or were in its contacts, e.g. to notify them
+type AccountDeletedFunc func(userID string, contactIDs []string)
+
+var (
+	accountDeletedHandler AccountDeletedFunc
+	accountDeletedMu      sync.RWMutex
+)
+
+// SetAccountDeletedHandler registers the callback invoked when an account is erased
+func SetAccountDeletedHandler(fn AccountDeletedFunc) {
+	accountDeletedMu.Lock()
+	defer accountDeletedMu.Unlock()
+	accountDeletedHandler = fn
+}
+
+func notifyAccountDeleted(userID string, contactIDs []string) {
+	accountDeletedMu.RLock()
+	fn := accountDeletedHandler
+	accountDeletedMu.RUnlock()
+	if fn != nil {
+		fn(userID, contactIDs)
+	}
+}
+
+// ScheduleAccountDeletion schedules the user's account for erasure after the
+// grace period (ACCOUNT_DELETION_GRACE_DAYS). Requires the password, and a
+// TOTP or recovery code if 2FA is enabled. Accounts created by single sign-on
+// have no password, so they confirm with a TOTP or recovery code, or without
+// 2FA a code from RequestAccountDeletionCode. Scheduling again returns the
+// existing deletion.
+func (s *AuthService) ScheduleAccountDeletion(userID, password, code, requestIP string) (*models.AccountDeletion, error) {
+	user, err := GetUserByID(userID)
+	if err != nil {
+		return nil, errors.New("user not found")
+	}
+
+	twoFactorEnabled := models.IsTwoFactorEnabled(database.DB, userID)
+	switch {
+	case !user.PasswordUnset:
+		if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+			return nil, ErrInvalidPassword
+		}
+	case !twoFactorEnabled:
+		if !user.HasVerifiedPhone() {
+			return nil, ErrNoDeletionProof
+		}
+		if code == "" {
+			return nil, ErrDeletionCodeRequired
+		}
+		if _, err := s.phone.checkCode(*user.Phone, userID, models.PhoneVerificationAccountDeletion, code); err != nil {
+			return nil, err
+		}
+	}
+	if twoFactorEnabled {
+		if code == "" {
+			return nil, ErrTwoFactorCodeRequired
+		}
+		if err := s.twoFactor.Verify(userID, code); err != nil {
+			return nil, err
+		}
+	}
+
+	if existing, err := models.GetAccountDeletion(database.DB, userID); err == nil {
+		return existing, nil
+	}
+
+	grace := time.Duration(intFromEnv("ACCOUNT_DELETION_GRACE_DAYS", int(DefaultAccountDeletionGrace.Hours()/24))) * 24 * time.Hour
+	deletion := models.AccountDeletion{
+		UserID:       userID,
+		ScheduledFor: time.Now().Add(grace),
+		RequestIP:    requestIP,
+	}
+	if err := database.DB.Create(&deletion).Error; err != nil {
+		return nil, errors.New("failed to schedule deletion")
+	}
+
+	log.Printf("Account %s scheduled for deletion at %s", userID, deletion.ScheduledFor.Format(time.RFC3339))
+	return &deletion, nil
+}
+
+// RequestAccountDeletionCode texts a code to the verified number of an account
+// without a password, to confirm its deletion when 2FA isn't enabled
+func (s *AuthService) RequestAccountDeletionCode(ctx context.Context, userID string) error {
+	user, err := GetUserByID(userID)
+	if err != nil {
+		return errors.New("user not found")
+	}
+	if !user.PasswordUnset || models.IsTwoFactorEnabled(database.DB, userID) {
+		return ErrDeletionCodeNotNeeded
+	}
+	if !user.HasVerifiedPhone() {
+		return ErrNoDeletionProof
+	}
+	return s.phone.sendCode(ctx, *user.Phone, userID, models.PhoneVerificationAccountDeletion)
+}
+
+// GetAccountDeletion returns the user's scheduled deletion
+func (s *AuthService) GetAccountDeletion(userID string) (*models.AccountDeletion, error) {
+	deletion, err := models.GetAccountDeletion(database.DB, userID)
+	if err != nil {
+		return nil, ErrDeletionNotScheduled
+	}
+	return deletion, nil
+}
+
+// CancelAccountDeletion cancels a scheduled deletion during the grace period
+func (s *AuthService) CancelAccountDeletion(userID string) error {
+	cancelled, err := models.CancelAccountDeletion(database.DB, userID)
+	if err != nil {
+		return errors.New("failed to cancel deletion")
+	}
+	if !cancelled {
+		return ErrDeletionNotScheduled
+	}
+	return nil
+}
+
+// ProcessDueAccountDeletions erases accounts whose grace period has ended
+func (s *AuthService) ProcessDueAccountDeletions() (int, error) {
+	due, err := models.GetDueAccountDeletions(database.DB, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	erased := 0
+	for _, deletion := range due {
+		if err := s.EraseAccount(deletion.UserID); err != nil {
+			log.Printf("Failed to erase account %s: %v", deletion.UserID, err)
+			continue
+		}
+		erased++
+	}
+	return erased, nil
+}
+
+// EraseAccount deletes a user's personal data. Direct messages, media, keys,
+// push tokens and everything else owned by the user are deleted. Messages
+// sent to groups stay for the other members but are attributed to an
+// anonymous placeholder that replaces the user's profile.
+func (s *AuthService) EraseAccount(userID string) error {
+	if _, err := s.RevokeAllSessions(userID, "", models.SessionRevokedAccountDeleted); err != nil {
+		return err
+	}
+
+	var contactIDs []string
+	database.DB.Model(&models.Contact{}).Where("user_id = ?", userID).Pluck("contact_id", &contactIDs)
+	var contactOf []string
+	database.DB.Model(&models.Contact{}).Where("contact_id = ?", userID).Pluck("user_id", &contactOf)
+	contactIDs = appendUnique(contactIDs, contactOf...)
+
+	var media []models.Media
+	database.DB.Where("uploader_id = ?", userID).Find(&media)
+	var exports []models.AccountExport
+	database.DB.Where("user_id = ?", userID).Find(&exports)
+
+	err := database.DB.Transaction(func(tx *gorm.DB) error {
+		return eraseAccountData(tx, userID)
+	})
+	if err != nil {
+		return err
+	}
+
+	// Files go only after the rows are gone, so nothing points at them
+	for _, m := range media {
+		if m.StoragePath != "" {
+			os.Remove(m.StoragePath)
+		}
+		if m.ThumbnailPath != "" {
+			os.Remove(m.ThumbnailPath)
+		}
+		if m.HLSPath != "" {
+			os.RemoveAll(filepath.Dir(m.HLSPath))
+		}
+	}
+	for _, export := range exports {
+		if export.FilePath != "" {
+			os.Remove(export.FilePath)
+		}
+	}
+
+	log.Printf("Account %s erased", userID)
+	notifyAccountDeleted(userID, contactIDs)
+	return nil
+}
+
+func eraseAccountData(tx *gorm.DB, userID string) error {
+	dms := tx.Model(&models.Message{}).Select("id").
+		Where("group_id IS NULL AND (sender_id = ? OR recipient_id = ?)", userID, userID)
+	dmPolls := tx.Model(&models.Poll{}).Select("id").
+		Where("group_id IS NULL AND (creator_id = ? OR recipient_id = ?)", userID, userID)
+	ownMedia := tx.Model(&models.Media{}).Select("id").Where("uploader_id = ?", userID)
+	ownStories := tx.Model(&models.Story{}).Select("id").Where("user_id = ?", userID)
+	ownLists := tx.Model(&models.BroadcastList{}).Select("id").Where("owner_id = ?", userID)
+	dmCalls := tx.Model(&models.Call{}).Select("id").
+		Where("group_id IS NULL AND (caller_id = ? OR recipient_id = ?)", userID, userID)
+
+	var ownedGroupIDs []string
+	if err := tx.Model(&models.GroupMember{}).Where("user_id = ? AND role = ?", userID, models.GroupRoleOwner).
+		Pluck("group_id", &ownedGroupIDs).Error; err != nil {
+		return err
+	}
+
+	steps := []struct {
+		model interface{}
+		query string
+		args  []interface{}
+	}{
+		// Scheduled messages that haven't gone out yet, in groups too. The
+		// scheduler clears scheduled_at once it delivers a message.
+		{&models.Message{}, "sender_id = ? AND scheduled_at IS NOT NULL", []interface{}{userID}},
+
+		// Direct messages and everything attached to them
+		{&models.Reaction{}, "message_id IN (?)", []interface{}{dms}},
+		{&models.MessageReadReceipt{}, "message_id IN (?)", []interface{}{dms}},
+		{&models.StarredMessage{}, "message_id IN (?)", []interface{}{dms}},
+		{&models.PinnedMessage{}, "message_id IN (?)", []interface{}{dms}},
+		{&models.MessageDeletion{}, "message_id IN (?)", []interface{}{dms}},
+		{&models.PollVote{}, "poll_id IN (?)", []interface{}{dmPolls}},
+		{&models.PollOption{}, "poll_id IN (?)", []interface{}{dmPolls}},
+		{&models.Poll{}, "id IN (?)", []interface{}{dmPolls}},
+		{&models.Message{}, "group_id IS NULL AND (sender_id = ? OR recipient_id = ?)", []interface{}{userID, userID}},
+		{&models.CallParticipant{}, "call_id IN (?)", []interface{}{dmCalls}},
+		{&models.Call{}, "group_id IS NULL AND (caller_id = ? OR recipient_id = ?)", []interface{}{userID, userID}},
+
+		// The user's own activity
+		{&models.Reaction{}, "user_id = ?", []interface{}{userID}},
+		{&models.MessageReadReceipt{}, "user_id = ?", []interface{}{userID}},
+		{&models.StarredMessage{}, "user_id = ?", []interface{}{userID}},
+		{&models.MessageDeletion{}, "user_id = ?", []interface{}{userID}},
+		{&models.PollVote{}, "user_id = ?", []interface{}{userID}},
+		{&models.CallParticipant{}, "user_id = ?", []interface{}{userID}},
+		{&models.StoryView{}, "story_id IN (?) OR viewer_id = ?", []interface{}{ownStories, userID}},
+		{&models.Story{}, "user_id = ?", []interface{}{userID}},
+
+		// Relationships and settings
+		{&models.Contact{}, "user_id = ? OR contact_id = ?", []interface{}{userID, userID}},
+		{&models.Block{}, "blocker_id = ? OR blocked_id = ?", []interface{}{userID, userID}},
+		{&models.GroupMember{}, "user_id = ?", []interface{}{userID}},
+		{&models.BroadcastListRecipient{}, "broadcast_list_id IN (?) OR recipient_id = ?", []interface{}{ownLists, userID}},
+		{&models.BroadcastList{}, "owner_id = ?", []interface{}{userID}},
+		{&models.ArchivedConversation{}, "user_id = ? OR other_user_id = ?", []interface{}{userID, userID}},
+		{&models.ConversationSettings{}, "user_id = ? OR other_user_id = ?", []interface{}{userID, userID}},
+		{&models.ChatTheme{}, "user_id = ?", []interface{}{userID}},
+		{&models.StorageQuota{}, "user_id = ?", []interface{}{userID}},
+
+		// Keys, devices and push tokens
+		{&models.PreKey{}, "user_id = ?", []interface{}{userID}},
+		{&models.SignedPreKey{}, "user_id = ?", []interface{}{userID}},
+		{&models.IdentityKey{}, "user_id = ?", []interface{}{userID}},
+		{&models.SenderKey{}, "user_id = ?", []interface{}{userID}},
+		{&models.EncryptionDevice{}, "user_id = ?", []interface{}{userID}},
+		{&models.DeviceToken{}, "user_id = ?", []interface{}{userID}},
+		{&models.PushAttempt{}, "user_id = ?", []interface{}{userID}},
+		{&models.PushJob{}, "user_id = ?", []interface{}{userID}},
+		{&models.PushBurst{}, "recipient_id = ? OR sender_id = ?", []interface{}{userID, userID}},
+		{&models.PushDeadLetter{}, "user_id = ?", []interface{}{userID}},
+
+		// Credentials and sessions
+		{&models.RefreshToken{}, "session_id IN (?)", []interface{}{tx.Model(&models.Session{}).Select("id").Where("user_id = ?", userID)}},
+		{&models.Session{}, "user_id = ?", []interface{}{userID}},
+		{&models.TwoFactor{}, "user_id = ?", []interface{}{userID}},
+		{&models.RecoveryCode{}, "user_id = ?", []interface{}{userID}},
+		{&models.TwoFactorChallenge{}, "user_id = ?", []interface{}{userID}},
+		{&models.PhoneVerification{}, "user_id = ?", []interface{}{userID}},
+		{&models.PasswordResetToken{}, "user_id = ?", []interface{}{userID}},
+		{&models.ExternalIdentity{}, "user_id = ?", []interface{}{userID}},
+		{&models.OIDCAuthRequest{}, "link_user_id = ?", []interface{}{userID}},
+		{&models.DeviceLinkRequest{}, "user_id = ?", []interface{}{userID}},
+		{&models.WebSocketTicket{}, "user_id = ?", []interface{}{userID}},
+		{&models.LoginEvent{}, "user_id = ?", []interface{}{userID}},
+		{&models.LoginLockout{}, "user_id = ?", []interface{}{userID}},
+		{&models.NotificationPreferences{}, "user_id = ?", []interface{}{userID}},
+		{&models.AccountExport{}, "user_id = ?", []interface{}{userID}},
+		{&models.AccountDeletion{}, "user_id = ?", []interface{}{userID}},
+	}
+
+	// Group messages keep their text but lose attachments and locations
+	if err := tx.Model(&models.Message{}).Where("media_id IN (?)", ownMedia).
+		Update("media_id", nil).Error; err != nil {
+		return err
+	}
+	if err := tx.Model(&models.Message{}).Where("sender_id = ?", userID).
+		Updates(map[string]interface{}{"latitude": nil, "longitude": nil, "location_name": nil}).Error; err != nil {
+		return err
+	}
+
+	for _, step := range steps {
+		if err := tx.Where(step.query, step.args...).Delete(step.model).Error; err != nil {
+			return err
+		}
+	}
+	if err := tx.Where("uploader_id = ?", userID).Delete(&models.Media{}).Error; err != nil {
+		return err
+	}
+	for _, groupID := range ownedGroupIDs {
+		if err := handOverGroup(tx, groupID); err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	return tx.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"username":          "deleted-" + userID,
+		"display_name":      DeletedAccountName,
+		"phone":             nil,
+		"phone_verified_at": nil,
+		"email":             nil,
+		"email_verified_at": nil,
+		"password_hash":     "",
+		"avatar_url":        "",
+		"about":             "",
+		"status_emoji":      "",
+		"role":              models.UserRoleUser,
+		"deleted_at":        now,
+	}).Error
+}
+
+// handOverGroup passes an erased owner's group to the longest-standing admin,
+// or failing that the longest-standing member. A group nobody is left in is
+// deleted, as when its last member leaves.
+func handOverGroup(tx *gorm.DB, groupID string) error {
+	var successor models.GroupMember
+	err := tx.Where("group_id = ?", groupID).
+		Order(fmt.Sprintf("CASE role WHEN '%s' THEN 0 ELSE 1 END, joined_at ASC", models.GroupRoleAdmin)).
+		First(&successor).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return tx.Delete(&models.Group{}, "id = ?", groupID).Error
+	}
+	if err != nil {
+		return err
+	}
+	return tx.Model(&successor).Update("role", models.GroupRoleOwner).Error
+}
+
+func appendUnique(list []string, values ...string) []string {
+	seen := make(map[string]bool, len(list))
+	for _, v := range list {
+		seen[v] = true
+	}
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			list = append(list, v)
+		}
+	}
+	return list
+}
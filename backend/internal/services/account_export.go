@@ -0,0 +1,417 @@
+package services
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"messenger/internal/database"
+	"messenger/internal/models"
+)
+
+// DefaultAccountExportTTL is how long a finished export can be downloaded
+const DefaultAccountExportTTL = 7 * 24 * time.Hour
+
+// AccountExportTimeout is how long an export may stay pending or running
+// before it is presumed lost and a new one can be requested
+const AccountExportTimeout = time.Hour
+
+var (
+	ErrExportNotFound = errors.New("export not found")
+	ErrExportNotReady = errors.New("export is not ready")
+)
+
+// ExportMessage is the format messages are exported in, both for single
+// conversations and full account exports
+type ExportMessage struct {
+	ID            string     `json:"id"`
+	SenderID      string     `json:"sender_id"`
+	SenderName    string     `json:"sender_name"`
+	Content       string     `json:"content"`
+	MediaID       *string    `json:"media_id,omitempty"`
+	MediaURL      string     `json:"media_url,omitempty"`
+	MediaType     string     `json:"media_type,omitempty"`
+	ForwardedFrom *string    `json:"forwarded_from,omitempty"`
+	ReplyToID     *string    `json:"reply_to_id,omitempty"`
+	EditedAt      *time.Time `json:"edited_at,omitempty"`
+	CreatedAt     time.Time  `json:"created_at"`
+}
+
+type ExportUser struct {
+	ID          string `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name,omitempty"`
+}
+
+// NewExportMessage converts a message (with Media preloaded) to the export format
+func NewExportMessage(msg models.Message, senderName string) ExportMessage {
+	exportMsg := ExportMessage{
+		ID:            msg.ID,
+		SenderID:      msg.SenderID,
+		SenderName:    senderName,
+		Content:       msg.Content,
+		MediaID:       msg.MediaID,
+		ForwardedFrom: msg.ForwardedFrom,
+		ReplyToID:     msg.ReplyToID,
+		EditedAt:      msg.EditedAt,
+		CreatedAt:     msg.CreatedAt,
+	}
+
+	// Include media info if present
+	if msg.Media != nil {
+		exportMsg.MediaURL = msg.Media.URL
+		exportMsg.MediaType = msg.Media.ContentType
+	}
+	return exportMsg
+}
+
+func exportUser(user *models.User) ExportUser {
+	return ExportUser{ID: user.ID, Username: user.Username, DisplayName: user.DisplayName}
+}
+
+// accountExportConversation is one conversation in messages.json
+type accountExportConversation struct {
+	Type      string          `json:"type"` // "dm" or "group"
+	With      *ExportUser     `json:"with,omitempty"`
+	GroupID   string          `json:"group_id,omitempty"`
+	GroupName string          `json:"group_name,omitempty"`
+	Messages  []ExportMessage `json:"messages"`
+}
+
+// AccountExportService builds zip archives of everything stored about a user
+type AccountExportService struct {
+	dir string
+	ttl time.Duration
+}
+
+// NewAccountExportService creates a service writing to ACCOUNT_EXPORT_DIR
+// (default ./uploads/exports) with downloads kept for ACCOUNT_EXPORT_TTL_HOURS
+func NewAccountExportService() *AccountExportService {
+	return NewAccountExportServiceWithDir(getEnvOrDefault("ACCOUNT_EXPORT_DIR", "./uploads/exports"))
+}
+
+// NewAccountExportServiceWithDir creates a service writing archives to dir
+func NewAccountExportServiceWithDir(dir string) *AccountExportService {
+	return &AccountExportService{
+		dir: dir,
+		ttl: time.Duration(intFromEnv("ACCOUNT_EXPORT_TTL_HOURS", int(DefaultAccountExportTTL.Hours()))) * time.Hour,
+	}
+}
+
+// Request starts an export job for the user, or returns the one already in progress
+func (s *AccountExportService) Request(userID string) (*models.AccountExport, error) {
+	if _, err := models.FailStaleAccountExports(database.DB, userID, time.Now().Add(-AccountExportTimeout)); err != nil {
+		return nil, errors.New("failed to check exports")
+	}
+	if active, err := models.GetActiveAccountExport(database.DB, userID); err == nil {
+		return active, nil
+	}
+
+	export := models.AccountExport{
+		UserID:    userID,
+		ExpiresAt: time.Now().Add(s.ttl),
+	}
+	if err := database.DB.Create(&export).Error; err != nil {
+		return nil, errors.New("failed to create export")
+	}
+
+	go s.run(export)
+	return &export, nil
+}
+
+// Get returns one of the user's exports
+func (s *AccountExportService) Get(userID, exportID string) (*models.AccountExport, error) {
+	export, err := models.GetAccountExport(database.DB, exportID, userID)
+	if err != nil {
+		return nil, ErrExportNotFound
+	}
+	return export, nil
+}
+
+// File returns the path of a finished export's archive
+func (s *AccountExportService) File(userID, exportID string) (string, error) {
+	export, err := s.Get(userID, exportID)
+	if err != nil {
+		return "", err
+	}
+	if export.Status != models.AccountExportCompleted || !time.Now().Before(export.ExpiresAt) {
+		return "", ErrExportNotReady
+	}
+	return export.FilePath, nil
+}
+
+func (s *AccountExportService) run(export models.AccountExport) {
+	database.DB.Model(&export).Update("status", models.AccountExportRunning)
+
+	path, size, err := s.build(export)
+	if err != nil {
+		log.Printf("Account export %s for user %s failed: %v", export.ID, export.UserID, err)
+		database.DB.Model(&export).Updates(map[string]interface{}{
+			"status": models.AccountExportFailed,
+			"error":  "failed to build export",
+		})
+		return
+	}
+
+	now := time.Now()
+	database.DB.Model(&export).Updates(map[string]interface{}{
+		"status":       models.AccountExportCompleted,
+		"file_path":    path,
+		"size":         size,
+		"completed_at": now,
+		"expires_at":   now.Add(s.ttl),
+	})
+}
+
+func (s *AccountExportService) build(export models.AccountExport) (string, int64, error) {
+	if err := os.MkdirAll(s.dir, 0700); err != nil {
+		return "", 0, err
+	}
+
+	path := filepath.Join(s.dir, export.ID+".zip")
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return "", 0, err
+	}
+
+	archive := zip.NewWriter(file)
+	err = writeAccountArchive(archive, export.UserID)
+	if closeErr := archive.Close(); err == nil {
+		err = closeErr
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return "", 0, err
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", 0, err
+	}
+	return path, info.Size(), nil
+}
+
+func writeJSONEntry(archive *zip.Writer, name string, data interface{}) error {
+	w, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(data)
+}
+
+// writeAccountArchive writes profile, contacts, messages, media, stories,
+// polls, reactions and settings of a user to the archive
+func writeAccountArchive(archive *zip.Writer, userID string) error {
+	db := database.DB
+
+	var user models.User
+	if err := db.First(&user, "id = ?", userID).Error; err != nil {
+		return err
+	}
+
+	var sessions []models.Session
+	db.Where("user_id = ?", userID).Order("created_at ASC").Find(&sessions)
+	devices, _ := models.GetUserDevices(db, userID)
+	identities, _ := models.GetUserExternalIdentities(db, userID)
+	profile := map[string]interface{}{
+		"exported_at":         time.Now(),
+		"user":                user,
+		"sessions":            sessions,
+		"encryption_devices":  devices,
+		"external_identities": identities,
+	}
+	if err := writeJSONEntry(archive, "profile.json", profile); err != nil {
+		return err
+	}
+
+	var contacts []models.Contact
+	db.Preload("ContactUser").Where("user_id = ?", userID).Find(&contacts)
+	var blocks []models.Block
+	db.Where("blocker_id = ?", userID).Find(&blocks)
+	if err := writeJSONEntry(archive, "contacts.json", map[string]interface{}{
+		"contacts": contacts,
+		"blocked":  blocks,
+	}); err != nil {
+		return err
+	}
+
+	conversations, err := exportConversations(userID)
+	if err != nil {
+		return err
+	}
+	if err := writeJSONEntry(archive, "messages.json", conversations); err != nil {
+		return err
+	}
+
+	var media []models.Media
+	db.Where("uploader_id = ?", userID).Order("created_at ASC").Find(&media)
+	if err := writeJSONEntry(archive, "media.json", media); err != nil {
+		return err
+	}
+	for _, m := range media {
+		if m.StoragePath == "" {
+			continue
+		}
+		if err := copyFileToArchive(archive, m.StoragePath, fmt.Sprintf("media/%s-%s", m.ID, filepath.Base(m.Filename))); err != nil {
+			log.Printf("Account export: skipping media %s: %v", m.ID, err)
+		}
+	}
+
+	var stories []models.Story
+	db.Where("user_id = ?", userID).Order("created_at ASC").Find(&stories)
+	if err := writeJSONEntry(archive, "stories.json", stories); err != nil {
+		return err
+	}
+
+	var polls []models.Poll
+	db.Preload("Options").Where("creator_id = ?", userID).Order("created_at ASC").Find(&polls)
+	var votes []models.PollVote
+	db.Where("user_id = ?", userID).Find(&votes)
+	if err := writeJSONEntry(archive, "polls.json", map[string]interface{}{
+		"created": polls,
+		"votes":   votes,
+	}); err != nil {
+		return err
+	}
+
+	var reactions []models.Reaction
+	db.Where("user_id = ?", userID).Order("created_at ASC").Find(&reactions)
+	if err := writeJSONEntry(archive, "reactions.json", reactions); err != nil {
+		return err
+	}
+
+	var conversationSettings []models.ConversationSettings
+	db.Where("user_id = ?", userID).Find(&conversationSettings)
+	notificationPrefs, _ := models.GetNotificationPreferences(db, userID)
+	var themes []models.ChatTheme
+	db.Where("user_id = ?", userID).Find(&themes)
+	archived, _ := models.GetArchivedConversations(db, userID)
+	var starred []models.StarredMessage
+	db.Where("user_id = ?", userID).Find(&starred)
+	broadcastLists, _ := models.GetBroadcastLists(db, userID)
+	return writeJSONEntry(archive, "settings.json", map[string]interface{}{
+		"conversations":   conversationSettings,
+		"notifications":   notificationPrefs,
+		"themes":          themes,
+		"archived":        archived,
+		"starred":         starred,
+		"broadcast_lists": broadcastLists,
+	})
+}
+
+// exportConversations collects the user's DMs and the history of groups they belong to
+func exportConversations(userID string) ([]accountExportConversation, error) {
+	db := database.DB
+	names := map[string]string{}
+	senderName := func(id string) string {
+		if name, ok := names[id]; ok {
+			return name
+		}
+		var sender models.User
+		name := ""
+		if db.First(&sender, "id = ?", id).Error == nil {
+			name = sender.DisplayName
+			if name == "" {
+				name = sender.Username
+			}
+		}
+		names[id] = name
+		return name
+	}
+
+	var dms []models.Message
+	if err := db.Preload("Media").
+		Where("group_id IS NULL AND deleted_at IS NULL AND (sender_id = ? OR recipient_id = ?)", userID, userID).
+		Order("created_at ASC").Find(&dms).Error; err != nil {
+		return nil, err
+	}
+
+	conversations := []accountExportConversation{}
+	byPartner := map[string]int{}
+	for _, msg := range dms {
+		partnerID := msg.SenderID
+		if partnerID == userID && msg.RecipientID != nil {
+			partnerID = *msg.RecipientID
+		}
+		idx, ok := byPartner[partnerID]
+		if !ok {
+			conversation := accountExportConversation{Type: "dm"}
+			var partner models.User
+			if db.First(&partner, "id = ?", partnerID).Error == nil {
+				with := exportUser(&partner)
+				conversation.With = &with
+			}
+			conversations = append(conversations, conversation)
+			idx = len(conversations) - 1
+			byPartner[partnerID] = idx
+		}
+		conversations[idx].Messages = append(conversations[idx].Messages, NewExportMessage(msg, senderName(msg.SenderID)))
+	}
+
+	var memberships []models.GroupMember
+	db.Preload("Group").Where("user_id = ?", userID).Find(&memberships)
+	for _, membership := range memberships {
+		var messages []models.Message
+		// Only what the user could see: messages from before they joined are left out
+		if err := db.Preload("Media").
+			Where("group_id = ? AND deleted_at IS NULL AND created_at >= ?", membership.GroupID, membership.JoinedAt).
+			Order("created_at ASC").Find(&messages).Error; err != nil {
+			return nil, err
+		}
+
+		conversation := accountExportConversation{
+			Type:      "group",
+			GroupID:   membership.GroupID,
+			GroupName: membership.Group.Name,
+			Messages:  []ExportMessage{},
+		}
+		for _, msg := range messages {
+			conversation.Messages = append(conversation.Messages, NewExportMessage(msg, senderName(msg.SenderID)))
+		}
+		conversations = append(conversations, conversation)
+	}
+
+	return conversations, nil
+}
+
+func copyFileToArchive(archive *zip.Writer, src, name string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	w, err := archive.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, in)
+	return err
+}
+
+// DeleteExpiredAccountExports removes expired exports and their archives
+func DeleteExpiredAccountExports(before time.Time) (int, error) {
+	exports, err := models.GetExpiredAccountExports(database.DB, before)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, export := range exports {
+		if export.FilePath != "" {
+			os.Remove(export.FilePath)
+		}
+		database.DB.Delete(&export)
+	}
+	return len(exports), nil
+}
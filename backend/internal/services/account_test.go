@@ -0,0 +1,450 @@
+package services
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"messenger/internal/database"
+	"messenger/internal/models"
+)
+
+func setupAccountTestDB(t *testing.T) func() {
+	cleanup := setupAuthTestDB(t)
+	database.DB.AutoMigrate(
+		&models.Contact{}, &models.Block{}, &models.Group{}, &models.GroupMember{},
+		&models.Message{}, &models.MessageDeletion{}, &models.Media{}, &models.Reaction{},
+		&models.MessageReadReceipt{}, &models.StarredMessage{}, &models.PinnedMessage{},
+		&models.Poll{}, &models.PollOption{}, &models.PollVote{}, &models.Story{}, &models.StoryView{},
+		&models.ConversationSettings{}, &models.ChatTheme{}, &models.ArchivedConversation{},
+		&models.BroadcastList{}, &models.BroadcastListRecipient{}, &models.StorageQuota{},
+		&models.DeviceToken{}, &models.EncryptionDevice{}, &models.IdentityKey{},
+		&models.PreKey{}, &models.SignedPreKey{}, &models.SenderKey{}, &models.PushBurst{},
+	)
+	return cleanup
+}
+
+// accountFixture creates a user with a contact, a DM, a group message, an
+// uploaded file, a push token and E2EE keys
+type accountFixture struct {
+	user, friend *AuthResponse
+	groupID      string
+	dmID         string
+	groupMsgID   string
+	mediaPath    string
+}
+
+func newAccountFixture(t *testing.T) accountFixture {
+	t.Helper()
+	svc := NewAuthService()
+	user, _ := svc.Register(RegisterInput{Username: "leaver", Password: "password123", DisplayName: "Leaver"})
+	friend, _ := svc.Register(RegisterInput{Username: "friend", Password: "password123"})
+	db := database.DB
+
+	db.Create(&models.Contact{UserID: user.User.ID, ContactID: friend.User.ID})
+	db.Create(&models.Contact{UserID: friend.User.ID, ContactID: user.User.ID})
+
+	group := models.Group{Name: "Hikers", CreatedBy: friend.User.ID}
+	db.Create(&group)
+	db.Create(&models.GroupMember{GroupID: group.ID, UserID: user.User.ID})
+	db.Create(&models.GroupMember{GroupID: group.ID, UserID: friend.User.ID})
+
+	mediaPath := filepath.Join(t.TempDir(), "photo.jpg")
+	os.WriteFile(mediaPath, []byte("jpeg"), 0600)
+	media := models.Media{UploaderID: user.User.ID, Filename: "photo.jpg", ContentType: "image/jpeg", StoragePath: mediaPath}
+	db.Create(&media)
+
+	recipient := friend.User.ID
+	dm := models.Message{SenderID: user.User.ID, RecipientID: &recipient, Content: "private hello"}
+	db.Create(&dm)
+	db.Create(&models.Reaction{MessageID: dm.ID, UserID: friend.User.ID, Emoji: "👍"})
+
+	place := "Summit"
+	groupMsg := models.Message{SenderID: user.User.ID, GroupID: &group.ID, Content: "see you up there", MediaID: &media.ID, LocationName: &place}
+	db.Create(&groupMsg)
+
+	db.Create(&models.DeviceToken{UserID: user.User.ID, Token: "push-token", Platform: models.PlatformIOS})
+	models.RegisterDevice(db, user.User.ID, "phone", "Phone", "ios")
+	models.SaveIdentityKey(db, user.User.ID, "phone", 1, []byte("identity"))
+
+	return accountFixture{
+		user:       user,
+		friend:     friend,
+		groupID:    group.ID,
+		dmID:       dm.ID,
+		groupMsgID: groupMsg.ID,
+		mediaPath:  mediaPath,
+	}
+}
+
+func TestAccountExport(t *testing.T) {
+	cleanup := setupAccountTestDB(t)
+	defer cleanup()
+	fx := newAccountFixture(t)
+
+	// Sent before the user joined the group, so not theirs to export
+	var membership models.GroupMember
+	database.DB.First(&membership, "group_id = ? AND user_id = ?", fx.groupID, fx.user.User.ID)
+	database.DB.Create(&models.Message{SenderID: fx.friend.User.ID, GroupID: &fx.groupID, Content: "before your time",
+		CreatedAt: membership.JoinedAt.Add(-time.Hour)})
+
+	svc := NewAccountExportServiceWithDir(t.TempDir())
+	export, err := svc.Request(fx.user.User.ID)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for export.Status != models.AccountExportCompleted && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+		export, _ = svc.Get(fx.user.User.ID, export.ID)
+	}
+	if export.Status != models.AccountExportCompleted {
+		t.Fatalf("Export did not complete: %+v", export)
+	}
+
+	if _, err := svc.Get(fx.friend.User.ID, export.ID); !errors.Is(err, ErrExportNotFound) {
+		t.Error("Other users should not see the export")
+	}
+
+	path, err := svc.File(fx.user.User.ID, export.ID)
+	if err != nil {
+		t.Fatalf("File failed: %v", err)
+	}
+	archive, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatalf("Export is not a zip: %v", err)
+	}
+	defer archive.Close()
+
+	files := map[string]*zip.File{}
+	for _, f := range archive.File {
+		files[f.Name] = f
+	}
+	for _, name := range []string{"profile.json", "contacts.json", "messages.json", "media.json", "stories.json", "polls.json", "reactions.json", "settings.json"} {
+		if files[name] == nil {
+			t.Errorf("Export is missing %s", name)
+		}
+	}
+
+	var conversations []accountExportConversation
+	r, _ := files["messages.json"].Open()
+	json.NewDecoder(r).Decode(&conversations)
+	r.Close()
+	if len(conversations) != 2 {
+		t.Fatalf("Expected the DM and the group, got %+v", conversations)
+	}
+	if conversations[0].Type != "dm" || conversations[0].Messages[0].Content != "private hello" {
+		t.Errorf("Unexpected DM export %+v", conversations[0])
+	}
+	if conversations[1].GroupName != "Hikers" || conversations[1].Messages[0].SenderName != "Leaver" {
+		t.Errorf("Unexpected group export %+v", conversations[1])
+	}
+	if len(conversations[1].Messages) != 1 {
+		t.Errorf("Group messages from before the user joined shouldn't be exported, got %+v", conversations[1].Messages)
+	}
+
+	mediaFiles := 0
+	for name := range files {
+		if filepath.Dir(name) == "media" {
+			mediaFiles++
+		}
+	}
+	if mediaFiles != 1 {
+		t.Errorf("Expected the uploaded file in the export, got %d media files", mediaFiles)
+	}
+}
+
+func TestAccountDeletion_Schedule(t *testing.T) {
+	cleanup := setupAccountTestDB(t)
+	defer cleanup()
+
+	svc := NewAuthService()
+	resp, _ := svc.Register(RegisterInput{Username: "scheduler", Password: "password123"})
+
+	if _, err := svc.ScheduleAccountDeletion(resp.User.ID, "wrong", "", ""); !errors.Is(err, ErrInvalidPassword) {
+		t.Errorf("Expected ErrInvalidPassword, got %v", err)
+	}
+
+	deletion, err := svc.ScheduleAccountDeletion(resp.User.ID, "password123", "", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("ScheduleAccountDeletion failed: %v", err)
+	}
+	if time.Until(deletion.ScheduledFor) < DefaultAccountDeletionGrace-time.Minute {
+		t.Errorf("Deletion should wait for the grace period, scheduled for %v", deletion.ScheduledFor)
+	}
+
+	if erased, _ := svc.ProcessDueAccountDeletions(); erased != 0 {
+		t.Error("Accounts should not be erased during the grace period")
+	}
+
+	if err := svc.CancelAccountDeletion(resp.User.ID); err != nil {
+		t.Fatalf("CancelAccountDeletion failed: %v", err)
+	}
+	if _, err := svc.GetAccountDeletion(resp.User.ID); !errors.Is(err, ErrDeletionNotScheduled) {
+		t.Errorf("Expected ErrDeletionNotScheduled after cancelling, got %v", err)
+	}
+
+	t.Run("erased when due", func(t *testing.T) {
+		svc.ScheduleAccountDeletion(resp.User.ID, "password123", "", "")
+		database.DB.Model(&models.AccountDeletion{}).Where("user_id = ?", resp.User.ID).
+			Update("scheduled_for", time.Now().Add(-time.Minute))
+
+		if erased, err := svc.ProcessDueAccountDeletions(); err != nil || erased != 1 {
+			t.Fatalf("Expected 1 account erased, got %d (%v)", erased, err)
+		}
+		user, _ := GetUserByID(resp.User.ID)
+		if !user.IsDeleted() {
+			t.Error("Account should be erased")
+		}
+	})
+}
+
+func TestAccountDeletion_WithoutPassword(t *testing.T) {
+	cleanup := setupAccountTestDB(t)
+	defer cleanup()
+
+	sender := NewMemorySMSSender()
+	SetSMSSender(sender)
+	defer SetSMSSender(nil)
+
+	svc := NewAuthService()
+	newSSOUser := func(username string) *models.User {
+		resp, _ := svc.Register(RegisterInput{Username: username, Password: "password123"})
+		database.DB.Model(&models.User{}).Where("id = ?", resp.User.ID).Update("password_unset", true)
+		user, _ := GetUserByID(resp.User.ID)
+		return user
+	}
+
+	t.Run("password accounts don't get a code", func(t *testing.T) {
+		resp, _ := svc.Register(RegisterInput{Username: "haspassword", Password: "password123"})
+		if err := svc.RequestAccountDeletionCode(context.Background(), resp.User.ID); !errors.Is(err, ErrDeletionCodeNotNeeded) {
+			t.Errorf("Expected ErrDeletionCodeNotNeeded, got %v", err)
+		}
+	})
+
+	t.Run("no way to confirm", func(t *testing.T) {
+		user := newSSOUser("nophone")
+		if _, err := svc.ScheduleAccountDeletion(user.ID, "password123", "", ""); !errors.Is(err, ErrNoDeletionProof) {
+			t.Errorf("Expected ErrNoDeletionProof, got %v", err)
+		}
+		if err := svc.RequestAccountDeletionCode(context.Background(), user.ID); !errors.Is(err, ErrNoDeletionProof) {
+			t.Errorf("Expected ErrNoDeletionProof, got %v", err)
+		}
+	})
+
+	t.Run("SMS code", func(t *testing.T) {
+		user := newSSOUser("smsdelete")
+		phone := "+14155550160"
+		database.DB.Model(&models.User{}).Where("id = ?", user.ID).
+			Updates(map[string]interface{}{"phone": phone, "phone_verified_at": time.Now()})
+
+		if _, err := svc.ScheduleAccountDeletion(user.ID, "", "", ""); !errors.Is(err, ErrDeletionCodeRequired) {
+			t.Errorf("Expected ErrDeletionCodeRequired, got %v", err)
+		}
+		if err := svc.RequestAccountDeletionCode(context.Background(), user.ID); err != nil {
+			t.Fatalf("RequestAccountDeletionCode failed: %v", err)
+		}
+		code := lastOTP(t, sender, phone)
+		wrong := "000000"
+		if code == wrong {
+			wrong = "111111"
+		}
+		if _, err := svc.ScheduleAccountDeletion(user.ID, "", wrong, ""); !errors.Is(err, ErrInvalidOTP) {
+			t.Errorf("Expected ErrInvalidOTP for a wrong code, got %v", err)
+		}
+		if _, err := svc.ScheduleAccountDeletion(user.ID, "", code, ""); err != nil {
+			t.Fatalf("ScheduleAccountDeletion with the SMS code failed: %v", err)
+		}
+	})
+
+	t.Run("two-factor code", func(t *testing.T) {
+		user := newSSOUser("totpdelete")
+		secret, _ := enableTestTwoFactor(t, user)
+
+		if err := svc.RequestAccountDeletionCode(context.Background(), user.ID); !errors.Is(err, ErrDeletionCodeNotNeeded) {
+			t.Errorf("Expected ErrDeletionCodeNotNeeded with 2FA, got %v", err)
+		}
+		if _, err := svc.ScheduleAccountDeletion(user.ID, "", "", ""); !errors.Is(err, ErrTwoFactorCodeRequired) {
+			t.Errorf("Expected ErrTwoFactorCodeRequired, got %v", err)
+		}
+		code, _ := TOTPCode(secret, TOTPStep(time.Now()))
+		if _, err := svc.ScheduleAccountDeletion(user.ID, "", code, ""); err != nil {
+			t.Fatalf("ScheduleAccountDeletion with the 2FA code failed: %v", err)
+		}
+	})
+
+	t.Run("setting a password requires it", func(t *testing.T) {
+		user := newSSOUser("resetdelete")
+		if err := setPassword(user, "newpassword123"); err != nil {
+			t.Fatalf("setPassword failed: %v", err)
+		}
+		if _, err := svc.ScheduleAccountDeletion(user.ID, "", "123456", ""); !errors.Is(err, ErrInvalidPassword) {
+			t.Errorf("Expected ErrInvalidPassword, got %v", err)
+		}
+		if _, err := svc.ScheduleAccountDeletion(user.ID, "newpassword123", "", ""); err != nil {
+			t.Fatalf("ScheduleAccountDeletion with the new password failed: %v", err)
+		}
+	})
+}
+
+func TestAccountExport_Stale(t *testing.T) {
+	cleanup := setupAccountTestDB(t)
+	defer cleanup()
+	fx := newAccountFixture(t)
+
+	// Left running by a server that restarted mid-export
+	stale := models.AccountExport{UserID: fx.user.User.ID, Status: models.AccountExportRunning,
+		ExpiresAt: time.Now().Add(time.Hour), CreatedAt: time.Now().Add(-2 * AccountExportTimeout)}
+	database.DB.Create(&stale)
+
+	svc := NewAccountExportServiceWithDir(t.TempDir())
+	export, err := svc.Request(fx.user.User.ID)
+	if err != nil {
+		t.Fatalf("Request failed: %v", err)
+	}
+	if export.ID == stale.ID {
+		t.Fatal("A stale export shouldn't block a new one")
+	}
+	if old, _ := svc.Get(fx.user.User.ID, stale.ID); old.Status != models.AccountExportFailed {
+		t.Errorf("Stale export should be marked failed, got %s", old.Status)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for export.Status != models.AccountExportCompleted && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+		export, _ = svc.Get(fx.user.User.ID, export.ID)
+	}
+}
+
+func TestEraseAccount_GroupOwnership(t *testing.T) {
+	cleanup := setupAccountTestDB(t)
+	defer cleanup()
+	fx := newAccountFixture(t)
+	db := database.DB
+	userID := fx.user.User.ID
+
+	other, _ := NewAuthService().Register(RegisterInput{Username: "other", Password: "password123"})
+	shared := models.Group{Name: "Shared", CreatedBy: userID}
+	db.Create(&shared)
+	db.Create(&models.GroupMember{GroupID: shared.ID, UserID: userID, Role: models.GroupRoleOwner})
+	db.Create(&models.GroupMember{GroupID: shared.ID, UserID: fx.friend.User.ID, Role: models.GroupRoleMember})
+	db.Create(&models.GroupMember{GroupID: shared.ID, UserID: other.User.ID, Role: models.GroupRoleAdmin,
+		JoinedAt: time.Now().Add(time.Minute)})
+	solo := models.Group{Name: "Solo", CreatedBy: userID}
+	db.Create(&solo)
+	db.Create(&models.GroupMember{GroupID: solo.ID, UserID: userID, Role: models.GroupRoleOwner})
+
+	if err := NewAuthService().EraseAccount(userID); err != nil {
+		t.Fatalf("EraseAccount failed: %v", err)
+	}
+
+	var owner models.GroupMember
+	if err := db.First(&owner, "group_id = ? AND role = ?", shared.ID, models.GroupRoleOwner).Error; err != nil || owner.UserID != other.User.ID {
+		t.Errorf("The admin should become owner, got %+v", owner)
+	}
+	var count int64
+	db.Model(&models.Group{}).Where("id = ?", solo.ID).Count(&count)
+	if count != 0 {
+		t.Error("A group with no members left should be deleted")
+	}
+}
+
+func TestEraseAccount(t *testing.T) {
+	cleanup := setupAccountTestDB(t)
+	defer cleanup()
+	fx := newAccountFixture(t)
+	db := database.DB
+	userID := fx.user.User.ID
+
+	db.Create(&models.PushJob{UserID: userID, Provider: ProviderAPNs, Tokens: `["push-token"]`, Payload: "{}"})
+	db.Create(&models.PushDeadLetter{UserID: userID, Provider: ProviderAPNs, Tokens: `["push-token"]`, Payload: "{}"})
+	sendAt := time.Now().Add(time.Hour)
+	scheduled := models.Message{SenderID: userID, GroupID: &fx.groupID, Content: "see you tomorrow", ScheduledAt: &sendAt}
+	db.Create(&scheduled)
+
+	var notified []string
+	SetAccountDeletedHandler(func(deletedID string, contactIDs []string) {
+		notified = contactIDs
+	})
+	defer SetAccountDeletedHandler(nil)
+
+	if err := NewAuthService().EraseAccount(userID); err != nil {
+		t.Fatalf("EraseAccount failed: %v", err)
+	}
+
+	user, _ := GetUserByID(userID)
+	if !user.IsDeleted() || user.DisplayName != DeletedAccountName || user.Username == "leaver" {
+		t.Errorf("Profile should be anonymised, got %+v", user)
+	}
+	if _, err := NewAuthService().Login(LoginInput{Username: "leaver", Password: "password123"}); err == nil {
+		t.Error("Erased account should not be able to log in")
+	}
+	if _, err := AuthenticateToken(fx.user.AccessToken); err == nil {
+		t.Error("Erased account's sessions should be revoked")
+	}
+
+	var count int64
+	db.Model(&models.Message{}).Where("id = ?", fx.dmID).Count(&count)
+	if count != 0 {
+		t.Error("Direct messages should be deleted")
+	}
+	db.Model(&models.Reaction{}).Where("message_id = ?", fx.dmID).Count(&count)
+	if count != 0 {
+		t.Error("Reactions on deleted DMs should be deleted")
+	}
+
+	var groupMsg models.Message
+	if err := db.First(&groupMsg, "id = ?", fx.groupMsgID).Error; err != nil {
+		t.Fatal("Group messages should stay for the other members")
+	}
+	if groupMsg.MediaID != nil || groupMsg.LocationName != nil {
+		t.Error("Group messages should lose the erased user's media and location")
+	}
+	db.Model(&models.Message{}).Where("id = ?", scheduled.ID).Count(&count)
+	if count != 0 {
+		t.Error("Scheduled group messages that haven't been sent should be deleted")
+	}
+
+	db.Model(&models.Media{}).Where("uploader_id = ?", userID).Count(&count)
+	if count != 0 {
+		t.Error("Media should be deleted")
+	}
+	if _, err := os.Stat(fx.mediaPath); !os.IsNotExist(err) {
+		t.Error("Media files should be removed from disk")
+	}
+
+	db.Model(&models.DeviceToken{}).Where("user_id = ?", userID).Count(&count)
+	if count != 0 {
+		t.Error("Push tokens should be deleted")
+	}
+	db.Model(&models.PushJob{}).Where("user_id = ?", userID).Count(&count)
+	if count != 0 {
+		t.Error("Queued pushes should be deleted")
+	}
+	db.Model(&models.PushDeadLetter{}).Where("user_id = ?", userID).Count(&count)
+	if count != 0 {
+		t.Error("Dead-lettered pushes should be deleted")
+	}
+	db.Model(&models.IdentityKey{}).Where("user_id = ?", userID).Count(&count)
+	if count != 0 {
+		t.Error("Encryption keys should be deleted")
+	}
+	db.Model(&models.Contact{}).Where("user_id = ? OR contact_id = ?", userID, userID).Count(&count)
+	if count != 0 {
+		t.Error("Contacts should be deleted in both directions")
+	}
+	db.Model(&models.GroupMember{}).Where("user_id = ?", userID).Count(&count)
+	if count != 0 {
+		t.Error("Erased user should leave their groups")
+	}
+
+	if len(notified) != 1 || notified[0] != fx.friend.User.ID {
+		t.Errorf("Contacts should be notified, got %v", notified)
+	}
+}
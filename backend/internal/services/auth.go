@@ -11,6 +11,8 @@ import (
 	"messenger/internal/models"
 )
 
+// jwtSecret keys HMACs (OTP hashes, signing key encryption) and HS256 tokens.
+// Outside development the server refuses to start with the default (see CheckJWTSecret).
 var jwtSecret = []byte(getEnvOrDefault("JWT_SECRET", "your-secret-key-change-in-production"))
 
 func getEnvOrDefault(key, defaultValue string) string {
@@ -20,16 +22,33 @@ func getEnvOrDefault(key, defaultValue string) string {
 	return defaultValue
 }
 
+// Token types carried in the "typ" claim
+const (
+	TokenTypeAccess             = "access"
+	TokenTypeTwoFactorChallenge = "2fa_challenge"
+)
+
+// AccessTokenTTL is the lifetime of an access token
+const AccessTokenTTL = 15 * time.Minute
+
 type Claims struct {
-	UserID   string `json:"user_id"`
-	Username string `json:"username"`
+	UserID    string `json:"user_id"`
+	Username  string `json:"username"`
+	Type      string `json:"typ"`
+	SessionID string `json:"sid,omitempty"`
 	jwt.RegisteredClaims
 }
 
-type AuthService struct{}
+type AuthService struct {
+	twoFactor *TwoFactorService
+	phone     *PhoneVerificationService
+}
 
 func NewAuthService() *AuthService {
-	return &AuthService{}
+	return &AuthService{
+		twoFactor: NewTwoFactorService(),
+		phone:     NewPhoneVerificationService(),
+	}
 }
 
 type RegisterInput struct {
@@ -37,17 +56,42 @@ type RegisterInput struct {
 	Password    string `json:"password"`
 	Phone       string `json:"phone,omitempty"`
 	DisplayName string `json:"display_name,omitempty"`
+	DeviceName  string `json:"device_name,omitempty"`
+	Locale      string `json:"locale,omitempty"` // Unsupported locales fall back to English
+
+	Meta SessionMeta `json:"-"` // Filled in by the handler
 }
 
 type LoginInput struct {
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Username   string `json:"username"`
+	Password   string `json:"password"`
+	DeviceName string `json:"device_name,omitempty"`
+
+	Meta SessionMeta `json:"-"` // Filled in by the handler
+}
+
+// TwoFactorLoginInput completes a login that was answered with a 2FA challenge.
+// Either Code (from the authenticator app) or RecoveryCode must be set.
+type TwoFactorLoginInput struct {
+	ChallengeToken string `json:"challenge_token"`
+	Code           string `json:"code,omitempty"`
+	RecoveryCode   string `json:"recovery_code,omitempty"`
+	DeviceName     string `json:"device_name,omitempty"`
+
+	Meta SessionMeta `json:"-"` // Filled in by the handler
 }
 
+// AuthResponse is returned by register, login and refresh. When the user has
+// 2FA enabled, login only returns TwoFactorRequired and a ChallengeToken.
 type AuthResponse struct {
-	User         models.UserResponse `json:"user"`
-	AccessToken  string              `json:"access_token"`
-	RefreshToken string              `json:"refresh_token"`
+	User         *models.UserResponse `json:"user,omitempty"`
+	AccessToken  string               `json:"access_token,omitempty"`
+	RefreshToken string               `json:"refresh_token,omitempty"`
+	SessionID    string               `json:"session_id,omitempty"`
+
+	TwoFactorRequired      bool   `json:"two_factor_required,omitempty"`
+	ChallengeToken         string `json:"challenge_token,omitempty"`
+	TwoFactorSetupRequired bool   `json:"two_factor_setup_required,omitempty"` // Role requires 2FA but the user hasn't enrolled
 }
 
 func (s *AuthService) Register(input RegisterInput) (*AuthResponse, error) {
@@ -74,6 +118,7 @@ func (s *AuthService) Register(input RegisterInput) (*AuthResponse, error) {
 		Username:     input.Username,
 		PasswordHash: string(hashedPassword),
 		DisplayName:  input.DisplayName,
+		Locale:       NormalizeLocale(input.Locale),
 	}
 	if input.Phone != "" {
 		user.Phone = &input.Phone
@@ -83,127 +128,195 @@ func (s *AuthService) Register(input RegisterInput) (*AuthResponse, error) {
 		return nil, errors.New("failed to create user")
 	}
 
-	// Generate tokens
-	accessToken, err := s.generateAccessToken(&user)
-	if err != nil {
-		return nil, err
-	}
-
-	refreshToken, err := s.generateRefreshToken(&user)
-	if err != nil {
-		return nil, err
-	}
-
-	return &AuthResponse{
-		User:         user.ToResponse(true),
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-	}, nil
+	// Start a session and generate tokens
+	input.Meta.DeviceName = input.DeviceName
+	return s.startSession(&user, input.Meta)
 }
 
 func (s *AuthService) Login(input LoginInput) (*AuthResponse, error) {
 	var user models.User
 	if err := database.DB.Where("username = ?", input.Username).First(&user).Error; err != nil {
+		// Unknown usernames fail and lock out like accounts, so neither the
+		// response nor its timing reveals which usernames exist
+		if err := checkUnknownLoginLockout(input.Username); err != nil {
+			return nil, err
+		}
+		compareUnknownUserPassword(input.Password)
+		countLoginFailure(unknownLoginKey(input.Username))
 		return nil, errors.New("invalid credentials")
 	}
 
+	input.Meta.DeviceName = input.DeviceName
+	if err := checkLoginLockout(&user, input.Meta); err != nil {
+		return nil, err
+	}
+
 	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(input.Password)); err != nil {
+		recordLoginFailure(&user, input.Meta, models.LoginFailureInvalidPassword)
 		return nil, errors.New("invalid credentials")
 	}
 
+	return s.completeLogin(&user, input.Meta)
+}
+
+// completeLogin starts a session for a user who passed the first factor, or
+// returns a 2FA challenge if a second factor is still needed
+func (s *AuthService) completeLogin(user *models.User, meta SessionMeta) (*AuthResponse, error) {
+	if models.IsTwoFactorEnabled(database.DB, user.ID) {
+		challenge, err := generateChallengeToken(user)
+		if err != nil {
+			return nil, errors.New("failed to generate challenge token")
+		}
+		return &AuthResponse{
+			TwoFactorRequired: true,
+			ChallengeToken:    challenge,
+		}, nil
+	}
+
 	// Update last seen
-	database.DB.Model(&user).Update("last_seen", time.Now())
+	database.DB.Model(user).Update("last_seen", time.Now())
+
+	// Start a session and generate tokens
+	return s.startSession(user, meta)
+}
 
-	// Generate tokens
-	accessToken, err := s.generateAccessToken(&user)
+// CompleteTwoFactorLogin exchanges a challenge token and a TOTP or recovery
+// code for a session
+func (s *AuthService) CompleteTwoFactorLogin(input TwoFactorLoginInput) (*AuthResponse, error) {
+	claims, err := validateChallengeToken(input.ChallengeToken)
 	if err != nil {
 		return nil, err
 	}
 
-	refreshToken, err := s.generateRefreshToken(&user)
+	user, err := GetUserByID(claims.UserID)
 	if err != nil {
+		return nil, ErrInvalidChallenge
+	}
+
+	input.Meta.DeviceName = input.DeviceName
+	if err := checkLoginLockout(user, input.Meta); err != nil {
 		return nil, err
 	}
 
-	return &AuthResponse{
-		User:         user.ToResponse(true),
-		AccessToken:  accessToken,
-		RefreshToken: refreshToken,
-	}, nil
+	code := input.Code
+	if code == "" {
+		code = input.RecoveryCode
+	}
+	if err := s.twoFactor.Verify(user.ID, code); err != nil {
+		if errors.Is(err, ErrInvalidTwoFactorCode) {
+			recordLoginFailure(user, input.Meta, models.LoginFailureInvalidTwoFactor)
+		}
+		return nil, err
+	}
+
+	// A challenge gets one session, even if two correct codes race
+	if used, err := models.UseTwoFactorChallenge(database.DB, claims.ID); err != nil || !used {
+		return nil, ErrInvalidChallenge
+	}
+
+	database.DB.Model(user).Update("last_seen", time.Now())
+
+	return s.startSession(user, input.Meta)
 }
 
+// RefreshToken rotates a refresh token and issues a new access token.
+// Each refresh token can only be used once.
 func (s *AuthService) RefreshToken(refreshToken string) (*AuthResponse, error) {
-	claims, err := ValidateToken(refreshToken)
+	session, newRefreshToken, err := rotateRefreshToken(refreshToken)
 	if err != nil {
-		return nil, errors.New("invalid refresh token")
+		return nil, err
 	}
 
 	var user models.User
-	if err := database.DB.First(&user, "id = ?", claims.UserID).Error; err != nil {
+	if err := database.DB.First(&user, "id = ?", session.UserID).Error; err != nil {
 		return nil, errors.New("user not found")
 	}
 
-	accessToken, err := s.generateAccessToken(&user)
-	if err != nil {
-		return nil, err
-	}
-
-	newRefreshToken, err := s.generateRefreshToken(&user)
+	accessToken, err := s.generateAccessToken(&user, session.ID)
 	if err != nil {
 		return nil, err
 	}
 
+	userResponse := user.ToResponse(true)
 	return &AuthResponse{
-		User:         user.ToResponse(true),
+		User:         &userResponse,
 		AccessToken:  accessToken,
 		RefreshToken: newRefreshToken,
+		SessionID:    session.ID,
 	}, nil
 }
 
-func (s *AuthService) generateAccessToken(user *models.User) (string, error) {
-	claims := Claims{
-		UserID:   user.ID,
-		Username: user.Username,
-		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(15 * time.Minute)),
-			IssuedAt:  jwt.NewNumericDate(time.Now()),
-		},
+// startSession creates a session for a freshly authenticated user and issues its tokens
+func (s *AuthService) startSession(user *models.User, meta SessionMeta) (*AuthResponse, error) {
+	session, refreshToken, err := createSession(user.ID, meta)
+	if err != nil {
+		return nil, err
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	accessToken, err := s.generateAccessToken(user, session.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	s.recordLoginSuccess(user, meta, session.ID)
+
+	userResponse := user.ToResponse(true)
+	return &AuthResponse{
+		User:                   &userResponse,
+		AccessToken:            accessToken,
+		RefreshToken:           refreshToken,
+		SessionID:              session.ID,
+		TwoFactorSetupRequired: s.twoFactor.IsRequiredForRole(user.Role) && !models.IsTwoFactorEnabled(database.DB, user.ID),
+	}, nil
 }
 
-func (s *AuthService) generateRefreshToken(user *models.User) (string, error) {
+func (s *AuthService) generateAccessToken(user *models.User, sessionID string) (string, error) {
 	claims := Claims{
-		UserID:   user.ID,
-		Username: user.Username,
+		UserID:    user.ID,
+		Username:  user.Username,
+		Type:      TokenTypeAccess,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
-			ExpiresAt: jwt.NewNumericDate(time.Now().Add(7 * 24 * time.Hour)),
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(AccessTokenTTL)),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 		},
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtSecret)
+	return GetTokenKeys().Sign(claims)
 }
 
+// ValidateToken verifies an access token's signature, expiry and type.
+// It does not check whether the token's session is still active; use
+// AuthenticateToken for that.
 func ValidateToken(tokenString string) (*Claims, error) {
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		return jwtSecret, nil
-	})
-
+	token, err := GetTokenKeys().Parse(tokenString, &Claims{})
 	if err != nil {
 		return nil, err
 	}
 
 	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
+		if claims.Type != TokenTypeAccess {
+			return nil, errors.New("invalid token type")
+		}
 		return claims, nil
 	}
 
 	return nil, errors.New("invalid token")
 }
 
+// AuthenticateToken validates an access token and checks that its session
+// has not been revoked
+func AuthenticateToken(tokenString string) (*Claims, error) {
+	claims, err := ValidateToken(tokenString)
+	if err != nil {
+		return nil, err
+	}
+	if !IsSessionActive(claims.SessionID) {
+		return nil, ErrSessionRevoked
+	}
+	return claims, nil
+}
+
 func GetUserByID(userID string) (*models.User, error) {
 	var user models.User
 	if err := database.DB.First(&user, "id = ?", userID).Error; err != nil {
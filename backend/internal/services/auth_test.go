@@ -1,6 +1,8 @@
 package services
 
 import (
+	"errors"
+	"strings"
 	"testing"
 	"time"
 
@@ -21,7 +23,7 @@ func setupAuthTestDB(t *testing.T) func() {
 		t.Fatalf("Failed to create test database: %v", err)
 	}
 
-	database.DB.AutoMigrate(&models.User{})
+	database.DB.AutoMigrate(&models.User{}, &models.Session{}, &models.RefreshToken{}, &models.TwoFactor{}, &models.RecoveryCode{}, &models.TwoFactorChallenge{}, &models.PhoneVerification{}, &models.PasswordResetToken{}, &models.ExternalIdentity{}, &models.OIDCAuthRequest{}, &models.SigningKey{}, &models.WebSocketTicket{}, &models.DeviceLinkRequest{}, &models.AccountExport{}, &models.AccountDeletion{}, &models.LoginEvent{}, &models.LoginLockout{}, &models.NotificationPreferences{}, &models.PushJob{}, &models.PushBurst{}, &models.PushDeadLetter{}, &models.PushAttempt{}, &models.Call{}, &models.CallParticipant{})
 
 	return func() {
 		sqlDB, _ := database.DB.DB()
@@ -240,8 +242,7 @@ func TestAuthService_RefreshToken(t *testing.T) {
 				IssuedAt:  jwt.NewNumericDate(time.Now().Add(-2 * time.Hour)),
 			},
 		}
-		token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-		expiredToken, _ := token.SignedString(jwtSecret)
+		expiredToken, _ := GetTokenKeys().Sign(claims)
 
 		_, err := svc.RefreshToken(expiredToken)
 		if err == nil {
@@ -356,3 +357,434 @@ func TestGetEnvOrDefault(t *testing.T) {
 		}
 	})
 }
+
+func TestAuthService_RefreshTokenRotation(t *testing.T) {
+	cleanup := setupAuthTestDB(t)
+	defer cleanup()
+
+	svc := NewAuthService()
+
+	resp, err := svc.Register(RegisterInput{
+		Username: "rotateuser",
+		Password: "password123",
+	})
+	if err != nil {
+		t.Fatalf("Failed to create test user: %v", err)
+	}
+
+	t.Run("access token is not a refresh token", func(t *testing.T) {
+		if _, err := svc.RefreshToken(resp.AccessToken); err == nil {
+			t.Error("Access token should not be accepted as refresh token")
+		}
+	})
+
+	t.Run("refresh token is stored hashed", func(t *testing.T) {
+		var count int64
+		database.DB.Model(&models.RefreshToken{}).Where("token_hash = ?", resp.RefreshToken).Count(&count)
+		if count != 0 {
+			t.Error("Refresh token should not be stored in plain text")
+		}
+	})
+
+	rotated, err := svc.RefreshToken(resp.RefreshToken)
+	if err != nil {
+		t.Fatalf("RefreshToken failed: %v", err)
+	}
+	if rotated.SessionID != resp.SessionID {
+		t.Error("Rotation should keep the same session")
+	}
+	if rotated.RefreshToken == resp.RefreshToken {
+		t.Error("Rotation should issue a new refresh token")
+	}
+
+	t.Run("reuse revokes the token family", func(t *testing.T) {
+		var revokedUser, revokedSession string
+		SetSessionRevokedHandler(func(userID, sessionID string) {
+			revokedUser, revokedSession = userID, sessionID
+		})
+		defer SetSessionRevokedHandler(nil)
+
+		_, err := svc.RefreshToken(resp.RefreshToken)
+		if !errors.Is(err, ErrRefreshTokenReuse) {
+			t.Fatalf("Expected reuse error, got %v", err)
+		}
+		if revokedUser != resp.User.ID || revokedSession != resp.SessionID {
+			t.Error("Revocation handler should be called for the session")
+		}
+
+		// The latest token in the family no longer works either
+		if _, err := svc.RefreshToken(rotated.RefreshToken); err == nil {
+			t.Error("Tokens from a revoked family should be rejected")
+		}
+
+		// Nor do access tokens issued for the session
+		if _, err := AuthenticateToken(rotated.AccessToken); err == nil {
+			t.Error("Access tokens of a revoked session should be rejected")
+		}
+	})
+}
+
+func TestAccessTokenType(t *testing.T) {
+	cleanup := setupAuthTestDB(t)
+	defer cleanup()
+
+	svc := NewAuthService()
+	resp, _ := svc.Register(RegisterInput{Username: "typuser", Password: "password123"})
+
+	claims, err := ValidateToken(resp.AccessToken)
+	if err != nil {
+		t.Fatalf("ValidateToken failed: %v", err)
+	}
+	if claims.Type != TokenTypeAccess {
+		t.Errorf("Expected typ '%s', got '%s'", TokenTypeAccess, claims.Type)
+	}
+	if claims.SessionID != resp.SessionID {
+		t.Error("Access token should carry the session ID")
+	}
+
+	// Tokens without the access type are rejected
+	tokenString, _ := GetTokenKeys().Sign(Claims{
+		UserID: resp.User.ID,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour)),
+		},
+	})
+	if _, err := ValidateToken(tokenString); err == nil {
+		t.Error("Token without typ claim should be rejected")
+	}
+}
+
+func TestAuthService_Sessions(t *testing.T) {
+	cleanup := setupAuthTestDB(t)
+	defer cleanup()
+
+	svc := NewAuthService()
+	first, _ := svc.Register(RegisterInput{Username: "sessionuser", Password: "password123"})
+	second, _ := svc.Login(LoginInput{Username: "sessionuser", Password: "password123", DeviceName: "Tablet"})
+	third, _ := svc.Login(LoginInput{Username: "sessionuser", Password: "password123"})
+	other, _ := svc.Register(RegisterInput{Username: "otheruser", Password: "password123"})
+
+	sessions, err := svc.ListSessions(first.User.ID)
+	if err != nil {
+		t.Fatalf("ListSessions failed: %v", err)
+	}
+	if len(sessions) != 3 {
+		t.Fatalf("Expected 3 sessions, got %d", len(sessions))
+	}
+
+	t.Run("cannot revoke another user's session", func(t *testing.T) {
+		err := svc.RevokeSession(first.User.ID, other.SessionID, models.SessionRevokedByUser)
+		if !errors.Is(err, ErrSessionNotFound) {
+			t.Errorf("Expected ErrSessionNotFound, got %v", err)
+		}
+	})
+
+	t.Run("revoke single session", func(t *testing.T) {
+		if err := svc.RevokeSession(first.User.ID, second.SessionID, models.SessionRevokedByUser); err != nil {
+			t.Fatalf("RevokeSession failed: %v", err)
+		}
+		if _, err := AuthenticateToken(second.AccessToken); err == nil {
+			t.Error("Revoked session's access token should be rejected")
+		}
+		if _, err := svc.RefreshToken(second.RefreshToken); err == nil {
+			t.Error("Revoked session's refresh token should be rejected")
+		}
+	})
+
+	t.Run("log out everywhere else", func(t *testing.T) {
+		count, err := svc.RevokeAllSessions(first.User.ID, first.SessionID, models.SessionRevokedLogoutAll)
+		if err != nil {
+			t.Fatalf("RevokeAllSessions failed: %v", err)
+		}
+		if count != 1 {
+			t.Errorf("Expected 1 session revoked, got %d", count)
+		}
+		if _, err := AuthenticateToken(third.AccessToken); err == nil {
+			t.Error("Other sessions should be revoked")
+		}
+		if _, err := AuthenticateToken(first.AccessToken); err != nil {
+			t.Error("Current session should stay active")
+		}
+		if _, err := AuthenticateToken(other.AccessToken); err != nil {
+			t.Error("Other users' sessions should not be affected")
+		}
+	})
+}
+
+func TestTOTPCode_RFC6238(t *testing.T) {
+	// RFC 6238 appendix B test vector (SHA1), truncated to 6 digits
+	secret := base32NoPadding.EncodeToString([]byte("12345678901234567890"))
+	code, err := TOTPCode(secret, TOTPStep(time.Unix(59, 0)))
+	if err != nil {
+		t.Fatalf("TOTPCode failed: %v", err)
+	}
+	if code != "287082" {
+		t.Errorf("Expected 287082, got %s", code)
+	}
+
+	code, _ = TOTPCode(secret, TOTPStep(time.Unix(1111111109, 0)))
+	if code != "081804" {
+		t.Errorf("Expected 081804, got %s", code)
+	}
+}
+
+func TestValidateTOTP(t *testing.T) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		t.Fatalf("GenerateTOTPSecret failed: %v", err)
+	}
+	now := time.Now()
+
+	current, _ := TOTPCode(secret, TOTPStep(now))
+	if step, ok := ValidateTOTP(secret, current, now); !ok || step != TOTPStep(now) {
+		t.Error("Current code should validate")
+	}
+
+	previous, _ := TOTPCode(secret, TOTPStep(now)-1)
+	if _, ok := ValidateTOTP(secret, previous, now); !ok {
+		t.Error("Code from the previous step should be accepted for clock skew")
+	}
+
+	stale, _ := TOTPCode(secret, TOTPStep(now)-3)
+	if _, ok := ValidateTOTP(secret, stale, now); ok {
+		t.Error("Code outside the skew window should be rejected")
+	}
+
+	if _, ok := ValidateTOTP(secret, "12345", now); ok {
+		t.Error("Short codes should be rejected")
+	}
+}
+
+func TestOTPAuthURI(t *testing.T) {
+	uri := OTPAuthURI("Messenger", "alice", "JBSWY3DPEHPK3PXP")
+	want := "otpauth://totp/Messenger:alice?algorithm=SHA1&digits=6&issuer=Messenger&period=30&secret=JBSWY3DPEHPK3PXP"
+	if uri != want {
+		t.Errorf("Expected %s, got %s", want, uri)
+	}
+}
+
+// enableTestTwoFactor enrols a user in 2FA and returns the secret and recovery codes
+func enableTestTwoFactor(t *testing.T, user *models.User) (string, []string) {
+	t.Helper()
+	tfs := NewTwoFactorService()
+
+	setup, err := tfs.BeginEnrolment(user)
+	if err != nil {
+		t.Fatalf("BeginEnrolment failed: %v", err)
+	}
+
+	// Confirm with the previous step so the current step is still usable by the test
+	code, _ := TOTPCode(setup.Secret, TOTPStep(time.Now())-1)
+	codes, err := tfs.ConfirmEnrolment(user.ID, code)
+	if err != nil {
+		t.Fatalf("ConfirmEnrolment failed: %v", err)
+	}
+	return setup.Secret, codes
+}
+
+func TestTwoFactorService_Enrolment(t *testing.T) {
+	cleanup := setupAuthTestDB(t)
+	defer cleanup()
+
+	svc := NewAuthService()
+	resp, _ := svc.Register(RegisterInput{Username: "tfuser", Password: "password123"})
+	user, _ := GetUserByID(resp.User.ID)
+	tfs := NewTwoFactorService()
+
+	setup, err := tfs.BeginEnrolment(user)
+	if err != nil {
+		t.Fatalf("BeginEnrolment failed: %v", err)
+	}
+	if setup.Secret == "" || setup.OTPAuthURI == "" {
+		t.Fatal("Setup should return a secret and otpauth URI")
+	}
+
+	t.Run("pending enrolment is not enabled", func(t *testing.T) {
+		if models.IsTwoFactorEnabled(database.DB, user.ID) {
+			t.Error("2FA should not be enabled before confirmation")
+		}
+		if !tfs.Status(user).Pending {
+			t.Error("Status should report a pending enrolment")
+		}
+	})
+
+	t.Run("wrong code is rejected", func(t *testing.T) {
+		if _, err := tfs.ConfirmEnrolment(user.ID, "000000"); !errors.Is(err, ErrInvalidTwoFactorCode) {
+			t.Errorf("Expected ErrInvalidTwoFactorCode, got %v", err)
+		}
+	})
+
+	t.Run("confirmation enables 2FA and returns recovery codes", func(t *testing.T) {
+		code, _ := TOTPCode(setup.Secret, TOTPStep(time.Now()))
+		codes, err := tfs.ConfirmEnrolment(user.ID, code)
+		if err != nil {
+			t.Fatalf("ConfirmEnrolment failed: %v", err)
+		}
+		if len(codes) != RecoveryCodeCount {
+			t.Errorf("Expected %d recovery codes, got %d", RecoveryCodeCount, len(codes))
+		}
+
+		var stored models.RecoveryCode
+		database.DB.Where("user_id = ?", user.ID).First(&stored)
+		if stored.CodeHash == codes[0] || stored.CodeHash == normalizeRecoveryCode(codes[0]) {
+			t.Error("Recovery codes should be stored hashed")
+		}
+
+		status := tfs.Status(user)
+		if !status.Enabled || status.RecoveryCodesRemaining != int64(RecoveryCodeCount) {
+			t.Errorf("Unexpected status: %+v", status)
+		}
+
+		// The confirmation code can't be replayed
+		if err := tfs.Verify(user.ID, code); !errors.Is(err, ErrInvalidTwoFactorCode) {
+			t.Error("A used TOTP code should not be accepted again")
+		}
+	})
+
+	t.Run("setup is refused once enabled", func(t *testing.T) {
+		if _, err := tfs.BeginEnrolment(user); !errors.Is(err, ErrTwoFactorAlreadyEnabled) {
+			t.Errorf("Expected ErrTwoFactorAlreadyEnabled, got %v", err)
+		}
+	})
+}
+
+func TestAuthService_TwoFactorLogin(t *testing.T) {
+	cleanup := setupAuthTestDB(t)
+	defer cleanup()
+
+	svc := NewAuthService()
+	resp, _ := svc.Register(RegisterInput{Username: "tflogin", Password: "password123"})
+	user, _ := GetUserByID(resp.User.ID)
+	secret, recoveryCodes := enableTestTwoFactor(t, user)
+
+	login, err := svc.Login(LoginInput{Username: "tflogin", Password: "password123"})
+	if err != nil {
+		t.Fatalf("Login failed: %v", err)
+	}
+
+	t.Run("password login returns a challenge", func(t *testing.T) {
+		if !login.TwoFactorRequired || login.ChallengeToken == "" {
+			t.Fatal("Expected a 2FA challenge")
+		}
+		if login.AccessToken != "" || login.RefreshToken != "" || login.User != nil {
+			t.Error("No session should be issued before the second factor")
+		}
+		if _, err := ValidateToken(login.ChallengeToken); err == nil {
+			t.Error("Challenge token should not be usable as an access token")
+		}
+	})
+
+	t.Run("access token is rejected as challenge", func(t *testing.T) {
+		_, err := svc.CompleteTwoFactorLogin(TwoFactorLoginInput{ChallengeToken: resp.AccessToken, Code: "123456"})
+		if !errors.Is(err, ErrInvalidChallenge) {
+			t.Errorf("Expected ErrInvalidChallenge, got %v", err)
+		}
+	})
+
+	t.Run("wrong code is rejected", func(t *testing.T) {
+		_, err := svc.CompleteTwoFactorLogin(TwoFactorLoginInput{ChallengeToken: login.ChallengeToken, Code: "000000"})
+		if !errors.Is(err, ErrInvalidTwoFactorCode) {
+			t.Errorf("Expected ErrInvalidTwoFactorCode, got %v", err)
+		}
+	})
+
+	t.Run("TOTP code completes login", func(t *testing.T) {
+		code, _ := TOTPCode(secret, TOTPStep(time.Now()))
+		done, err := svc.CompleteTwoFactorLogin(TwoFactorLoginInput{ChallengeToken: login.ChallengeToken, Code: code})
+		if err != nil {
+			t.Fatalf("CompleteTwoFactorLogin failed: %v", err)
+		}
+		if done.AccessToken == "" || done.SessionID == "" {
+			t.Error("Expected a session after 2FA")
+		}
+	})
+
+	t.Run("challenge is single use", func(t *testing.T) {
+		code, _ := TOTPCode(secret, TOTPStep(time.Now()))
+		_, err := svc.CompleteTwoFactorLogin(TwoFactorLoginInput{ChallengeToken: login.ChallengeToken, Code: code})
+		if !errors.Is(err, ErrInvalidChallenge) {
+			t.Errorf("Expected a used challenge to be rejected, got %v", err)
+		}
+	})
+
+	t.Run("recovery code is single use", func(t *testing.T) {
+		first, _ := svc.Login(LoginInput{Username: "tflogin", Password: "password123"})
+		input := TwoFactorLoginInput{ChallengeToken: first.ChallengeToken, RecoveryCode: strings.ToUpper(recoveryCodes[0])}
+		if _, err := svc.CompleteTwoFactorLogin(input); err != nil {
+			t.Fatalf("Recovery code login failed: %v", err)
+		}
+		second, _ := svc.Login(LoginInput{Username: "tflogin", Password: "password123"})
+		input.ChallengeToken = second.ChallengeToken
+		if _, err := svc.CompleteTwoFactorLogin(input); !errors.Is(err, ErrInvalidTwoFactorCode) {
+			t.Errorf("Expected used recovery code to be rejected, got %v", err)
+		}
+	})
+
+	t.Run("challenge attempts are capped", func(t *testing.T) {
+		capped, _ := svc.Login(LoginInput{Username: "tflogin", Password: "password123"})
+		for i := 0; i < TwoFactorChallengeMaxAttempts; i++ {
+			svc.CompleteTwoFactorLogin(TwoFactorLoginInput{ChallengeToken: capped.ChallengeToken, Code: "000000"})
+		}
+		code, _ := TOTPCode(secret, TOTPStep(time.Now()))
+		_, err := svc.CompleteTwoFactorLogin(TwoFactorLoginInput{ChallengeToken: capped.ChallengeToken, Code: code})
+		if !errors.Is(err, ErrInvalidChallenge) {
+			t.Errorf("Expected ErrInvalidChallenge after %d attempts, got %v", TwoFactorChallengeMaxAttempts, err)
+		}
+	})
+
+	t.Run("expired challenge is rejected", func(t *testing.T) {
+		claims := Claims{
+			UserID: user.ID,
+			Type:   TokenTypeTwoFactorChallenge,
+			RegisteredClaims: jwt.RegisteredClaims{
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+			},
+		}
+		expired, _ := GetTokenKeys().Sign(claims)
+		_, err := svc.CompleteTwoFactorLogin(TwoFactorLoginInput{ChallengeToken: expired, RecoveryCode: recoveryCodes[1]})
+		if !errors.Is(err, ErrInvalidChallenge) {
+			t.Errorf("Expected ErrInvalidChallenge, got %v", err)
+		}
+	})
+}
+
+func TestTwoFactorService_Disable(t *testing.T) {
+	cleanup := setupAuthTestDB(t)
+	defer cleanup()
+
+	svc := NewAuthService()
+	resp, _ := svc.Register(RegisterInput{Username: "tfdisable", Password: "password123"})
+	user, _ := GetUserByID(resp.User.ID)
+	_, recoveryCodes := enableTestTwoFactor(t, user)
+	tfs := NewTwoFactorService()
+
+	if err := tfs.Disable(user, "wrongpassword", recoveryCodes[0]); !errors.Is(err, ErrInvalidPassword) {
+		t.Errorf("Expected ErrInvalidPassword, got %v", err)
+	}
+	if err := tfs.Disable(user, "password123", "000000"); !errors.Is(err, ErrInvalidTwoFactorCode) {
+		t.Errorf("Expected ErrInvalidTwoFactorCode, got %v", err)
+	}
+	if err := tfs.Disable(user, "password123", recoveryCodes[0]); err != nil {
+		t.Fatalf("Disable failed: %v", err)
+	}
+	if models.IsTwoFactorEnabled(database.DB, user.ID) || models.CountUnusedRecoveryCodes(database.DB, user.ID) != 0 {
+		t.Error("2FA and recovery codes should be removed")
+	}
+
+	login, _ := svc.Login(LoginInput{Username: "tfdisable", Password: "password123"})
+	if login.TwoFactorRequired || login.AccessToken == "" {
+		t.Error("Login should not require 2FA after disabling")
+	}
+}
+
+func TestTwoFactorService_RequiredRoles(t *testing.T) {
+	t.Setenv("TWO_FACTOR_REQUIRED_ROLES", "moderator, Admin")
+	tfs := NewTwoFactorService()
+
+	if !tfs.IsRequiredForRole(models.UserRoleModerator) || !tfs.IsRequiredForRole(models.UserRoleAdmin) {
+		t.Error("Moderators and admins should require 2FA")
+	}
+	if tfs.IsRequiredForRole(models.UserRoleUser) || tfs.IsRequiredForRole("") {
+		t.Error("Regular users should not require 2FA")
+	}
+}
@@ -42,10 +42,11 @@ func (s *BotService) IsEnabled() bool {
 	return s.apiKey != ""
 }
 
-// GenerateResponse generates a bot response to a user message
-func (s *BotService) GenerateResponse(userMessage string, conversationHistory []Message) (*BotResponse, error) {
+// GenerateResponse generates a bot response to a user message. Fallback
+// replies are in the given locale.
+func (s *BotService) GenerateResponse(userMessage string, conversationHistory []Message, locale string) (*BotResponse, error) {
 	if !s.IsEnabled() {
-		return s.generateFallbackResponse(userMessage), nil
+		return s.generateFallbackResponse(userMessage, locale), nil
 	}
 
 	// Build messages for API
@@ -55,7 +56,7 @@ func (s *BotService) GenerateResponse(userMessage string, conversationHistory []
 	response, err := s.callClaudeAPI(messages)
 	if err != nil {
 		// Fall back to simple responses if API fails
-		return s.generateFallbackResponse(userMessage), nil
+		return s.generateFallbackResponse(userMessage, locale), nil
 	}
 
 	return &BotResponse{Content: response}, nil
@@ -141,79 +142,80 @@ func (s *BotService) callClaudeAPI(messages []Message) (string, error) {
 	return apiResponse.Content[0].Text, nil
 }
 
+// Number of bot_joke_N and bot_default_N entries in the message catalog
+const (
+	botJokeCount         = 4
+	botDefaultReplyCount = 5
+)
+
 // generateFallbackResponse provides simple pattern-based responses when API is unavailable
-func (s *BotService) generateFallbackResponse(userMessage string) *BotResponse {
+func (s *BotService) generateFallbackResponse(userMessage, locale string) *BotResponse {
 	msg := strings.ToLower(strings.TrimSpace(userMessage))
 
 	// Greeting patterns
 	greetings := []string{"hi", "hello", "hey", "howdy", "hola", "greetings"}
 	for _, g := range greetings {
 		if strings.HasPrefix(msg, g) {
-			return &BotResponse{Content: "Hey there! How can I help you today?"}
+			return &BotResponse{Content: Localize(locale, "bot_greeting")}
 		}
 	}
 
 	// Question patterns
 	if strings.HasPrefix(msg, "how are") || strings.HasPrefix(msg, "how're") {
-		return &BotResponse{Content: "I'm doing great, thanks for asking! What's on your mind?"}
+		return &BotResponse{Content: Localize(locale, "bot_how_are_you")}
 	}
 
 	if strings.HasPrefix(msg, "what is") || strings.HasPrefix(msg, "what's") {
-		return &BotResponse{Content: "That's an interesting question! I'd need more context to give you a proper answer. Could you tell me more?"}
+		return &BotResponse{Content: Localize(locale, "bot_what_is")}
 	}
 
 	if strings.HasPrefix(msg, "who is") || strings.HasPrefix(msg, "who's") {
-		return &BotResponse{Content: "I'm not sure who you're asking about. Could you give me more details?"}
+		return &BotResponse{Content: Localize(locale, "bot_who_is")}
 	}
 
 	if strings.HasPrefix(msg, "why") {
-		return &BotResponse{Content: "Good question! The answer often depends on context. What specifically would you like to know?"}
+		return &BotResponse{Content: Localize(locale, "bot_why")}
 	}
 
 	if strings.HasPrefix(msg, "when") {
-		return &BotResponse{Content: "Timing can be tricky! What event or deadline are you asking about?"}
+		return &BotResponse{Content: Localize(locale, "bot_when")}
 	}
 
 	if strings.HasPrefix(msg, "where") {
-		return &BotResponse{Content: "Location, location, location! What place are you trying to find?"}
+		return &BotResponse{Content: Localize(locale, "bot_where")}
 	}
 
 	if strings.Contains(msg, "help") {
-		return &BotResponse{Content: "I'm here to help! Just tell me what you need assistance with."}
+		return &BotResponse{Content: Localize(locale, "bot_help")}
 	}
 
 	if strings.Contains(msg, "thank") {
-		return &BotResponse{Content: "You're welcome! Let me know if there's anything else."}
+		return &BotResponse{Content: Localize(locale, "bot_thanks")}
 	}
 
 	if strings.Contains(msg, "bye") || strings.Contains(msg, "goodbye") {
-		return &BotResponse{Content: "Goodbye! Feel free to message me anytime."}
+		return &BotResponse{Content: Localize(locale, "bot_goodbye")}
 	}
 
 	// Weather
 	if strings.Contains(msg, "weather") {
-		return &BotResponse{Content: "I don't have access to real-time weather data, but you can check your local weather app or website!"}
+		return &BotResponse{Content: Localize(locale, "bot_weather")}
 	}
 
 	// Time
 	if strings.Contains(msg, "time") && (strings.Contains(msg, "what") || strings.Contains(msg, "current")) {
-		return &BotResponse{Content: fmt.Sprintf("The current server time is %s", time.Now().Format("3:04 PM"))}
+		return &BotResponse{Content: Localize(locale, "bot_time", time.Now().Format(Localize(locale, "bot_time_format")))}
 	}
 
 	// Date
 	if strings.Contains(msg, "date") && strings.Contains(msg, "what") {
-		return &BotResponse{Content: fmt.Sprintf("Today is %s", time.Now().Format("Monday, January 2, 2006"))}
+		return &BotResponse{Content: Localize(locale, "bot_date", time.Now().Format(Localize(locale, "bot_date_format")))}
 	}
 
 	// Jokes
 	if strings.Contains(msg, "joke") || strings.Contains(msg, "funny") {
-		jokes := []string{
-			"Why do programmers prefer dark mode? Because light attracts bugs!",
-			"Why did the developer go broke? Because he used up all his cache!",
-			"There are only 10 types of people: those who understand binary and those who don't.",
-			"A SQL query walks into a bar, walks up to two tables and asks... 'Can I join you?'",
-		}
-		return &BotResponse{Content: jokes[time.Now().UnixNano()%int64(len(jokes))]}
+		joke := time.Now().UnixNano()%botJokeCount + 1
+		return &BotResponse{Content: Localize(locale, fmt.Sprintf("bot_joke_%d", joke))}
 	}
 
 	// Math - simple calculations
@@ -234,21 +236,15 @@ func (s *BotService) generateFallbackResponse(userMessage string) *BotResponse {
 			if b != 0 {
 				result = a / b
 			} else {
-				return &BotResponse{Content: "Can't divide by zero!"}
+				return &BotResponse{Content: Localize(locale, "bot_divide_by_zero")}
 			}
 		}
 		return &BotResponse{Content: fmt.Sprintf("%d %s %d = %d", a, matches[2], b, result)}
 	}
 
 	// Default response
-	defaultResponses := []string{
-		"Interesting! Tell me more about that.",
-		"I see what you mean. What else is on your mind?",
-		"That's a thought! Anything else you'd like to discuss?",
-		"Got it! Feel free to ask me anything.",
-		"I'm listening. What would you like to talk about?",
-	}
-	return &BotResponse{Content: defaultResponses[time.Now().UnixNano()%int64(len(defaultResponses))]}
+	reply := time.Now().UnixNano()%botDefaultReplyCount + 1
+	return &BotResponse{Content: Localize(locale, fmt.Sprintf("bot_default_%d", reply))}
 }
 
 // BotUserID is the constant ID for the bot user
@@ -0,0 +1,154 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"messenger/internal/database"
+	"messenger/internal/models"
+)
+
+const (
+	// DefaultCallRingTimeout is how long a call rings before it counts as missed
+	DefaultCallRingTimeout = 45 * time.Second
+	// DefaultCallMaxParticipants caps group calls, which are a full mesh of
+	// peer connections and get heavy beyond a handful of people
+	DefaultCallMaxParticipants = 8
+	// DefaultTURNCredentialTTL is how long issued TURN credentials stay valid
+	DefaultTURNCredentialTTL = 24 * time.Hour
+)
+
+// CallRingTimeout returns CALL_RING_TIMEOUT_SECONDS
+func CallRingTimeout() time.Duration {
+	return time.Duration(intFromEnv("CALL_RING_TIMEOUT_SECONDS", int(DefaultCallRingTimeout.Seconds()))) * time.Second
+}
+
+// CallMaxParticipants returns CALL_MAX_PARTICIPANTS, including the caller
+func CallMaxParticipants() int {
+	return intFromEnv("CALL_MAX_PARTICIPANTS", DefaultCallMaxParticipants)
+}
+
+// ICEServer is a STUN or TURN server in the form RTCPeerConnection takes
+type ICEServer struct {
+	URLs       []string `json:"urls"`
+	Username   string   `json:"username,omitempty"`
+	Credential string   `json:"credential,omitempty"`
+}
+
+// ICEServerConfig is what a client needs to set up a call's peer connections
+type ICEServerConfig struct {
+	ICEServers []ICEServer `json:"ice_servers"`
+	TTL        int         `json:"ttl,omitempty"`        // Seconds the TURN credentials are valid for
+	ExpiresAt  *time.Time  `json:"expires_at,omitempty"` // When the TURN credentials stop working
+}
+
+// ICEServersForUser returns the configured STUN servers and, with
+// TURN_SECRET set, TURN servers with fresh time-limited credentials. The
+// credentials follow the TURN REST API scheme (coturn's use-auth-secret):
+// the username is "expiry:userID" and the password its HMAC-SHA1 under the
+// shared secret, so the TURN server can check them without calling us.
+func ICEServersForUser(userID string, now time.Time) ICEServerConfig {
+	config := ICEServerConfig{ICEServers: []ICEServer{}}
+
+	if urls := envList("STUN_URLS"); len(urls) > 0 {
+		config.ICEServers = append(config.ICEServers, ICEServer{URLs: urls})
+	}
+
+	turnURLs := envList("TURN_URLS")
+	secret := os.Getenv("TURN_SECRET")
+	if len(turnURLs) == 0 || secret == "" {
+		return config
+	}
+
+	ttl := time.Duration(intFromEnv("TURN_CREDENTIAL_TTL_SECONDS", int(DefaultTURNCredentialTTL.Seconds()))) * time.Second
+	expiresAt := now.Add(ttl)
+	username, credential := turnCredentials(secret, userID, expiresAt)
+	config.ICEServers = append(config.ICEServers, ICEServer{
+		URLs:       turnURLs,
+		Username:   username,
+		Credential: credential,
+	})
+	config.TTL = int(ttl.Seconds())
+	config.ExpiresAt = &expiresAt
+	return config
+}
+
+// turnCredentials derives a TURN REST API username and password
+func turnCredentials(secret, userID string, expiresAt time.Time) (string, string) {
+	username := strconv.FormatInt(expiresAt.Unix(), 10) + ":" + userID
+	mac := hmac.New(sha1.New, []byte(secret))
+	mac.Write([]byte(username))
+	return username, base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// envList reads a comma-separated list from the environment
+func envList(key string) []string {
+	var values []string
+	for _, value := range strings.Split(os.Getenv(key), ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// PushMissedCall notifies a user of a call they didn't pick up, subject to
+// the same mute and do-not-disturb rules as messages
+func PushMissedCall(call *models.Call, recipientID string) {
+	GetPushService().pushMissedCall(call, recipientID)
+}
+
+func (ps *PushService) pushMissedCall(call *models.Call, recipientID string) {
+	if !ps.IsEnabled() {
+		return
+	}
+
+	push := MessagePush{RecipientID: recipientID, SenderID: call.CallerID}
+	conversationID := call.CallerID
+	if call.IsGroupCall() {
+		push.GroupID = *call.GroupID
+		conversationID = *call.GroupID
+	}
+	decision := EvaluateMessagePush(push, time.Now())
+	if !decision.Send {
+		return
+	}
+
+	var caller models.User
+	if err := database.DB.First(&caller, "id = ?", call.CallerID).Error; err != nil {
+		log.Printf("Failed to get caller for missed call push: %v", err)
+		return
+	}
+	callerName := caller.DisplayName
+	if callerName == "" {
+		callerName = caller.Username
+	}
+
+	notification := NewMissedCallNotification(callerName, call, conversationID)
+	notification.Badge = unreadBadge(recipientID)
+	if err := ps.sendMessagePush(recipientID, notification, decision.Private); err != nil {
+		log.Printf("Failed to send missed call push: %v", err)
+	}
+}
+
+// NewMissedCallNotification creates the notification for a missed call
+func NewMissedCallNotification(callerName string, call *models.Call, conversationID string) *Notification {
+	bodyLocKey := "push_missed_call"
+	if call.Media == models.CallMediaVideo {
+		bodyLocKey = "push_missed_video_call"
+	}
+
+	notification := NewMessageNotification(callerName, Localize(DefaultLocale, bodyLocKey), conversationID, call.IsGroupCall())
+	notification.BodyLocKey = bodyLocKey
+	notification.Data["type"] = "missed_call"
+	notification.Data["call_id"] = call.ID
+	notification.Data["media"] = string(call.Media)
+	notification.ThreadID = conversationThreadID(call.CallerID, call.IsGroupCall(), conversationID)
+	return notification
+}
@@ -0,0 +1,77 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"strconv"
+	"testing"
+	"time"
+
+	"messenger/internal/models"
+)
+
+func TestICEServersForUser_TURN(t *testing.T) {
+	t.Setenv("STUN_URLS", "stun:stun.example.com:3478")
+	t.Setenv("TURN_URLS", "turn:turn.example.com:3478?transport=udp, turns:turn.example.com:5349")
+	t.Setenv("TURN_SECRET", "s3cret")
+	t.Setenv("TURN_CREDENTIAL_TTL_SECONDS", "600")
+
+	now := time.Unix(1700000000, 0)
+	config := ICEServersForUser("user-1", now)
+
+	if len(config.ICEServers) != 2 {
+		t.Fatalf("Expected STUN and TURN servers, got %+v", config.ICEServers)
+	}
+	stun, turn := config.ICEServers[0], config.ICEServers[1]
+	if len(stun.URLs) != 1 || stun.Username != "" {
+		t.Errorf("Unexpected STUN server: %+v", stun)
+	}
+	if len(turn.URLs) != 2 || turn.URLs[1] != "turns:turn.example.com:5349" {
+		t.Errorf("Unexpected TURN URLs: %v", turn.URLs)
+	}
+
+	// Username is "expiry:userID", the password its HMAC-SHA1 under the secret
+	expiry := now.Add(600 * time.Second).Unix()
+	if turn.Username != strconv.FormatInt(expiry, 10)+":user-1" {
+		t.Errorf("Unexpected TURN username %q", turn.Username)
+	}
+	mac := hmac.New(sha1.New, []byte("s3cret"))
+	mac.Write([]byte(turn.Username))
+	if want := base64.StdEncoding.EncodeToString(mac.Sum(nil)); turn.Credential != want {
+		t.Errorf("Expected credential %q, got %q", want, turn.Credential)
+	}
+	if config.TTL != 600 || config.ExpiresAt == nil || config.ExpiresAt.Unix() != expiry {
+		t.Errorf("Unexpected expiry: ttl %d, expires %v", config.TTL, config.ExpiresAt)
+	}
+}
+
+func TestICEServersForUser_NoTURNSecret(t *testing.T) {
+	t.Setenv("STUN_URLS", "")
+	t.Setenv("TURN_URLS", "turn:turn.example.com:3478")
+	t.Setenv("TURN_SECRET", "")
+
+	config := ICEServersForUser("user-1", time.Now())
+	if len(config.ICEServers) != 0 || config.ExpiresAt != nil {
+		t.Errorf("Expected no servers without a TURN secret, got %+v", config)
+	}
+}
+
+func TestNewMissedCallNotification(t *testing.T) {
+	groupID := "group-1"
+	call := &models.Call{ID: "call-1", CallerID: "user-1", GroupID: &groupID, Media: models.CallMediaVideo}
+
+	n := NewMissedCallNotification("Alice", call, groupID)
+	if n.Title != "Alice" || n.Body != "Missed video call" || n.BodyLocKey != "push_missed_video_call" {
+		t.Errorf("Unexpected notification text: %q / %q (%s)", n.Title, n.Body, n.BodyLocKey)
+	}
+	if n.Data["type"] != "missed_call" || n.Data["call_id"] != "call-1" || n.Data["is_group"] != "true" {
+		t.Errorf("Unexpected data: %v", n.Data)
+	}
+	if n.ThreadID != "group:group-1" {
+		t.Errorf("Expected group thread, got %q", n.ThreadID)
+	}
+	if de := n.localized("de"); de.Body != "Verpasster Videoanruf" {
+		t.Errorf("Expected German body, got %q", de.Body)
+	}
+}
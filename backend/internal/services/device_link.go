@@ -0,0 +1,279 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"messenger/internal/database"
+	"messenger/internal/models"
+)
+
+// Device linking limits
+const (
+	DeviceLinkTTL          = 5 * time.Minute // How long the QR code can be scanned
+	DeviceLinkCompleteTTL  = 2 * time.Minute // How long the new device has to collect an approved link
+	MaxDeviceLinkWait      = 25 * time.Second
+	MaxProvisioningBlobLen = 64 * 1024
+
+	deviceLinkPollInterval = 500 * time.Millisecond
+)
+
+var (
+	ErrInvalidLinkToken       = errors.New("invalid or expired linking token")
+	ErrDeviceLinkPending      = errors.New("link request has not been approved yet")
+	ErrDeviceLinkRejected     = errors.New("link request was rejected")
+	ErrInvalidLinkKey         = errors.New("invalid public key")
+	ErrLinkKeyMismatch        = errors.New("public key does not match the QR code")
+	ErrDeviceAlreadyLinked    = errors.New("device is already registered on this account")
+	ErrInvalidProvisioning    = errors.New("provisioning data must be base64, at most 64 KiB")
+	ErrInvalidLinkDeviceInput = errors.New("device_id and public_key are required")
+)
+
+// StartDeviceLinkInput is sent by the new device before it shows the QR code
+type StartDeviceLinkInput struct {
+	DeviceID   string `json:"device_id"`
+	DeviceName string `json:"device_name,omitempty"`
+	Platform   string `json:"platform,omitempty"`
+	PublicKey  string `json:"public_key"` // Ephemeral key, base64
+
+	Meta SessionMeta `json:"-"` // Filled in by the handler
+}
+
+// DeviceLinkStart is returned to the new device. The QR code carries the link
+// token and the device's own ephemeral public key.
+type DeviceLinkStart struct {
+	LinkToken string    `json:"link_token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// DeviceLinkInfo describes a pending request to the approving device
+type DeviceLinkInfo struct {
+	DeviceID   string    `json:"device_id"`
+	DeviceName string    `json:"device_name,omitempty"`
+	Platform   string    `json:"platform,omitempty"`
+	RequestIP  string    `json:"request_ip,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// ApproveDeviceLinkInput is sent by the existing device after scanning the QR code
+type ApproveDeviceLinkInput struct {
+	LinkToken         string `json:"link_token"`
+	PublicKey         string `json:"public_key"`          // From the QR code, must match the request
+	ApproverDeviceID  string `json:"approver_device_id"`  // The approving device's E2EE device ID
+	ApproverPublicKey string `json:"approver_public_key"` // Approver's ephemeral key, base64
+	Provisioning      string `json:"provisioning"`        // Encrypted to the shared secret, base64
+}
+
+// CompleteDeviceLinkInput is sent by the new device to collect its session
+type CompleteDeviceLinkInput struct {
+	LinkToken string `json:"link_token"`
+
+	Wait time.Duration `json:"-"` // Long-poll for approval up to this long
+	Meta SessionMeta   `json:"-"` // Filled in by the handler
+}
+
+// DeviceLinkResult is the new device's session plus what it needs to decrypt
+// the provisioning blob
+type DeviceLinkResult struct {
+	*AuthResponse
+	DeviceID          string `json:"device_id"`
+	ApproverDeviceID  string `json:"approver_device_id"`
+	ApproverPublicKey string `json:"approver_public_key"`
+	Provisioning      string `json:"provisioning"`
+}
+
+func decodeBase64(value string) ([]byte, error) {
+	value = strings.TrimRight(value, "=")
+	if decoded, err := base64.RawStdEncoding.DecodeString(value); err == nil {
+		return decoded, nil
+	}
+	return base64.RawURLEncoding.DecodeString(value)
+}
+
+// validateLinkKey accepts raw X25519/Ed25519 keys and SEC1 P-256 points
+func validateLinkKey(key string) error {
+	decoded, err := decodeBase64(key)
+	if err != nil || len(decoded) < 32 || len(decoded) > 133 {
+		return ErrInvalidLinkKey
+	}
+	return nil
+}
+
+// StartDeviceLink registers a new device's link request. The returned token is
+// only shown in the QR code; anyone holding it can collect the session once
+// the request is approved.
+func (s *AuthService) StartDeviceLink(input StartDeviceLinkInput) (*DeviceLinkStart, error) {
+	if input.DeviceID == "" || input.PublicKey == "" {
+		return nil, ErrInvalidLinkDeviceInput
+	}
+	if err := validateLinkKey(input.PublicKey); err != nil {
+		return nil, err
+	}
+
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return nil, errors.New("failed to generate linking token")
+	}
+
+	request := models.DeviceLinkRequest{
+		TokenHash:          hashToken(token),
+		DeviceID:           input.DeviceID,
+		DeviceName:         input.DeviceName,
+		Platform:           input.Platform,
+		EphemeralPublicKey: input.PublicKey,
+		RequestIP:          input.Meta.IPAddress,
+		ExpiresAt:          time.Now().Add(DeviceLinkTTL),
+	}
+	if err := database.DB.Create(&request).Error; err != nil {
+		return nil, errors.New("failed to create link request")
+	}
+
+	return &DeviceLinkStart{LinkToken: token, ExpiresAt: request.ExpiresAt}, nil
+}
+
+func pendingDeviceLink(token string) (*models.DeviceLinkRequest, error) {
+	request, err := models.GetDeviceLinkRequestByHash(database.DB, hashToken(token))
+	if err != nil || request.Status != models.DeviceLinkPending || !time.Now().Before(request.ExpiresAt) {
+		return nil, ErrInvalidLinkToken
+	}
+	return request, nil
+}
+
+// LookupDeviceLink shows the approving device what it is about to link
+func (s *AuthService) LookupDeviceLink(token string) (*DeviceLinkInfo, error) {
+	request, err := pendingDeviceLink(token)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DeviceLinkInfo{
+		DeviceID:   request.DeviceID,
+		DeviceName: request.DeviceName,
+		Platform:   request.Platform,
+		RequestIP:  request.RequestIP,
+		CreatedAt:  request.CreatedAt,
+		ExpiresAt:  request.ExpiresAt,
+	}, nil
+}
+
+// ApproveDeviceLink links a pending request to the user and stores the
+// provisioning blob for the new device. The public key from the QR code must
+// match the one the new device registered, so the server can't swap in its own.
+func (s *AuthService) ApproveDeviceLink(userID string, input ApproveDeviceLinkInput) error {
+	request, err := pendingDeviceLink(input.LinkToken)
+	if err != nil {
+		return err
+	}
+	if input.PublicKey != request.EphemeralPublicKey {
+		return ErrLinkKeyMismatch
+	}
+	if err := validateLinkKey(input.ApproverPublicKey); err != nil {
+		return err
+	}
+	if blob, err := decodeBase64(input.Provisioning); err != nil || len(blob) == 0 || len(blob) > MaxProvisioningBlobLen {
+		return ErrInvalidProvisioning
+	}
+
+	if _, err := models.GetDevice(database.DB, userID, input.ApproverDeviceID); err != nil {
+		return ErrUnknownDevice
+	}
+	if _, err := models.GetDevice(database.DB, userID, request.DeviceID); err == nil {
+		return ErrDeviceAlreadyLinked
+	}
+
+	approved, err := models.ApproveDeviceLinkRequest(database.DB, request.ID, userID,
+		input.ApproverDeviceID, input.ApproverPublicKey, input.Provisioning, time.Now().Add(DeviceLinkCompleteTTL))
+	if err != nil {
+		return errors.New("failed to approve link request")
+	}
+	if !approved {
+		return ErrInvalidLinkToken
+	}
+	return nil
+}
+
+// RejectDeviceLink declines a pending request
+func (s *AuthService) RejectDeviceLink(token string) error {
+	request, err := pendingDeviceLink(token)
+	if err != nil {
+		return err
+	}
+
+	rejected, err := models.RejectDeviceLinkRequest(database.DB, request.ID)
+	if err != nil || !rejected {
+		return ErrInvalidLinkToken
+	}
+	return nil
+}
+
+// CompleteDeviceLink hands the new device its session and provisioning blob
+// once the request is approved, registering it as an encryption device. The
+// approval by an already logged-in device stands in for the password and
+// second factor. Returns ErrDeviceLinkPending if still not approved after
+// input.Wait.
+func (s *AuthService) CompleteDeviceLink(ctx context.Context, input CompleteDeviceLinkInput) (*DeviceLinkResult, error) {
+	tokenHash := hashToken(input.LinkToken)
+	deadline := time.Now().Add(min(input.Wait, MaxDeviceLinkWait))
+
+	var request *models.DeviceLinkRequest
+	for {
+		var err error
+		request, err = models.GetDeviceLinkRequestByHash(database.DB, tokenHash)
+		if err != nil || !time.Now().Before(request.ExpiresAt) {
+			return nil, ErrInvalidLinkToken
+		}
+
+		switch request.Status {
+		case models.DeviceLinkRejected:
+			return nil, ErrDeviceLinkRejected
+		case models.DeviceLinkCompleted:
+			return nil, ErrInvalidLinkToken
+		}
+		if request.Status == models.DeviceLinkApproved {
+			break
+		}
+
+		if !time.Now().Before(deadline) {
+			return nil, ErrDeviceLinkPending
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ErrDeviceLinkPending
+		case <-time.After(deviceLinkPollInterval):
+		}
+	}
+
+	completed, err := models.CompleteDeviceLinkRequest(database.DB, request.ID)
+	if err != nil || !completed || request.UserID == nil {
+		return nil, ErrInvalidLinkToken
+	}
+
+	user, err := GetUserByID(*request.UserID)
+	if err != nil {
+		return nil, ErrInvalidLinkToken
+	}
+
+	if _, err := models.RegisterDevice(database.DB, user.ID, request.DeviceID, request.DeviceName, request.Platform); err != nil {
+		return nil, errors.New("failed to register device")
+	}
+
+	database.DB.Model(user).Update("last_seen", time.Now())
+
+	input.Meta.DeviceName = request.DeviceName
+	response, err := s.startSession(user, input.Meta)
+	if err != nil {
+		return nil, err
+	}
+
+	return &DeviceLinkResult{
+		AuthResponse:      response,
+		DeviceID:          request.DeviceID,
+		ApproverDeviceID:  request.ApproverDeviceID,
+		ApproverPublicKey: request.ApproverPublicKey,
+		Provisioning:      request.ProvisioningBlob,
+	}, nil
+}
@@ -0,0 +1,176 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"messenger/internal/database"
+	"messenger/internal/models"
+)
+
+func testLinkKey() string {
+	key := make([]byte, 32)
+	rand.Read(key)
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func setupDeviceLinkTest(t *testing.T) (*AuthService, *AuthResponse) {
+	t.Helper()
+	database.DB.AutoMigrate(&models.EncryptionDevice{})
+
+	svc := NewAuthService()
+	resp, _ := svc.Register(RegisterInput{Username: "linkuser", Password: "password123"})
+	models.RegisterDevice(database.DB, resp.User.ID, "phone", "Phone", "ios")
+	return svc, resp
+}
+
+func TestDeviceLink_Flow(t *testing.T) {
+	cleanup := setupAuthTestDB(t)
+	defer cleanup()
+	svc, owner := setupDeviceLinkTest(t)
+
+	newKey := testLinkKey()
+	start, err := svc.StartDeviceLink(StartDeviceLinkInput{
+		DeviceID:   "laptop",
+		DeviceName: "Chrome on Mac",
+		Platform:   "web",
+		PublicKey:  newKey,
+	})
+	if err != nil {
+		t.Fatalf("StartDeviceLink failed: %v", err)
+	}
+
+	if _, err := svc.CompleteDeviceLink(context.Background(), CompleteDeviceLinkInput{LinkToken: start.LinkToken}); !errors.Is(err, ErrDeviceLinkPending) {
+		t.Fatalf("Expected ErrDeviceLinkPending before approval, got %v", err)
+	}
+
+	info, err := svc.LookupDeviceLink(start.LinkToken)
+	if err != nil {
+		t.Fatalf("LookupDeviceLink failed: %v", err)
+	}
+	if info.DeviceName != "Chrome on Mac" || info.DeviceID != "laptop" {
+		t.Errorf("Unexpected link info %+v", info)
+	}
+
+	approval := ApproveDeviceLinkInput{
+		LinkToken:         start.LinkToken,
+		PublicKey:         newKey,
+		ApproverDeviceID:  "phone",
+		ApproverPublicKey: testLinkKey(),
+		Provisioning:      base64.StdEncoding.EncodeToString([]byte("encrypted keys")),
+	}
+
+	t.Run("swapped public key", func(t *testing.T) {
+		swapped := approval
+		swapped.PublicKey = testLinkKey()
+		if err := svc.ApproveDeviceLink(owner.User.ID, swapped); !errors.Is(err, ErrLinkKeyMismatch) {
+			t.Errorf("Expected ErrLinkKeyMismatch, got %v", err)
+		}
+	})
+
+	t.Run("unregistered approver device", func(t *testing.T) {
+		unknown := approval
+		unknown.ApproverDeviceID = "tablet"
+		if err := svc.ApproveDeviceLink(owner.User.ID, unknown); !errors.Is(err, ErrUnknownDevice) {
+			t.Errorf("Expected ErrUnknownDevice, got %v", err)
+		}
+	})
+
+	if err := svc.ApproveDeviceLink(owner.User.ID, approval); err != nil {
+		t.Fatalf("ApproveDeviceLink failed: %v", err)
+	}
+	if err := svc.ApproveDeviceLink(owner.User.ID, approval); !errors.Is(err, ErrInvalidLinkToken) {
+		t.Errorf("Approving twice should fail, got %v", err)
+	}
+
+	result, err := svc.CompleteDeviceLink(context.Background(), CompleteDeviceLinkInput{LinkToken: start.LinkToken})
+	if err != nil {
+		t.Fatalf("CompleteDeviceLink failed: %v", err)
+	}
+	if result.User.ID != owner.User.ID || result.AccessToken == "" || result.RefreshToken == "" {
+		t.Errorf("Expected a session for the approving user, got %+v", result.AuthResponse)
+	}
+	if result.Provisioning != approval.Provisioning || result.ApproverPublicKey != approval.ApproverPublicKey {
+		t.Error("Provisioning blob and approver key should be relayed unchanged")
+	}
+	if _, err := AuthenticateToken(result.AccessToken); err != nil {
+		t.Errorf("Linked device's token should be valid: %v", err)
+	}
+	if _, err := models.GetDevice(database.DB, owner.User.ID, "laptop"); err != nil {
+		t.Error("Linked device should be registered for E2EE")
+	}
+
+	t.Run("single use", func(t *testing.T) {
+		if _, err := svc.CompleteDeviceLink(context.Background(), CompleteDeviceLinkInput{LinkToken: start.LinkToken}); !errors.Is(err, ErrInvalidLinkToken) {
+			t.Errorf("Expected ErrInvalidLinkToken, got %v", err)
+		}
+		stored, _ := models.GetDeviceLinkRequestByHash(database.DB, hashToken(start.LinkToken))
+		if stored.ProvisioningBlob != "" {
+			t.Error("Provisioning blob should be deleted once delivered")
+		}
+	})
+
+	t.Run("device already linked", func(t *testing.T) {
+		again, _ := svc.StartDeviceLink(StartDeviceLinkInput{DeviceID: "laptop", PublicKey: newKey})
+		approval.LinkToken = again.LinkToken
+		if err := svc.ApproveDeviceLink(owner.User.ID, approval); !errors.Is(err, ErrDeviceAlreadyLinked) {
+			t.Errorf("Expected ErrDeviceAlreadyLinked, got %v", err)
+		}
+	})
+}
+
+func TestDeviceLink_LongPoll(t *testing.T) {
+	cleanup := setupAuthTestDB(t)
+	defer cleanup()
+	svc, owner := setupDeviceLinkTest(t)
+
+	key := testLinkKey()
+	start, _ := svc.StartDeviceLink(StartDeviceLinkInput{DeviceID: "desktop", PublicKey: key})
+
+	go func() {
+		time.Sleep(200 * time.Millisecond)
+		svc.ApproveDeviceLink(owner.User.ID, ApproveDeviceLinkInput{
+			LinkToken:         start.LinkToken,
+			PublicKey:         key,
+			ApproverDeviceID:  "phone",
+			ApproverPublicKey: testLinkKey(),
+			Provisioning:      base64.StdEncoding.EncodeToString([]byte("blob")),
+		})
+	}()
+
+	result, err := svc.CompleteDeviceLink(context.Background(), CompleteDeviceLinkInput{
+		LinkToken: start.LinkToken,
+		Wait:      5 * time.Second,
+	})
+	if err != nil {
+		t.Fatalf("Long-poll should return once approved: %v", err)
+	}
+	if result.DeviceID != "desktop" {
+		t.Errorf("Expected device 'desktop', got %q", result.DeviceID)
+	}
+}
+
+func TestDeviceLink_Rejected(t *testing.T) {
+	cleanup := setupAuthTestDB(t)
+	defer cleanup()
+	svc, _ := setupDeviceLinkTest(t)
+
+	if _, err := svc.StartDeviceLink(StartDeviceLinkInput{DeviceID: "x", PublicKey: "short"}); !errors.Is(err, ErrInvalidLinkKey) {
+		t.Errorf("Expected ErrInvalidLinkKey, got %v", err)
+	}
+
+	start, _ := svc.StartDeviceLink(StartDeviceLinkInput{DeviceID: "desktop", PublicKey: testLinkKey()})
+	if err := svc.RejectDeviceLink(start.LinkToken); err != nil {
+		t.Fatalf("RejectDeviceLink failed: %v", err)
+	}
+	if _, err := svc.CompleteDeviceLink(context.Background(), CompleteDeviceLinkInput{LinkToken: start.LinkToken}); !errors.Is(err, ErrDeviceLinkRejected) {
+		t.Errorf("Expected ErrDeviceLinkRejected, got %v", err)
+	}
+	if _, err := svc.LookupDeviceLink(start.LinkToken); !errors.Is(err, ErrInvalidLinkToken) {
+		t.Errorf("Rejected requests can't be looked up, got %v", err)
+	}
+}
@@ -0,0 +1,322 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"messenger/internal/database"
+	"messenger/internal/models"
+)
+
+// DefaultLocale is used for users without a locale and for catalog entries
+// missing from a locale
+const DefaultLocale = "en"
+
+// SupportedLocales lists the languages of the message catalog
+var SupportedLocales = []string{"en", "de", "es", "fr"}
+
+// messageCatalog holds all text the server generates for users, by locale
+// and key. Arguments use explicit indexes (%[1]s) so translations can
+// reorder them. Push keys double as APNs loc-keys and FCM *_loc_keys, so
+// apps that localize pushes themselves must ship strings under the same
+// names with arguments in the same order.
+var messageCatalog = map[string]map[string]string{
+	"en": {
+		// Push notifications
+		"push_new_message":         "New message",
+		"push_attachment":          "Sent an attachment",
+		"push_location":            "📍 Shared a location",
+		"push_summary_dm":          "%[1]s new messages from %[2]s",
+		"push_summary_group":       "%[1]s new messages in %[2]s",
+		"push_login_alert_title":   "New login",
+		"push_login_alert_body":    "Your account was just used to log in on %[1]s. If this wasn't you, review your devices.",
+		"push_test_title":          "Test Notification",
+		"push_test_body":           "Push notifications are working!",
+		"push_missed_call":         "Missed voice call",
+		"push_missed_video_call":   "Missed video call",
+		"push_incoming_call":       "Incoming voice call",
+		"push_incoming_video_call": "Incoming video call",
+
+		// Group events
+		"group_added":   "You were added to %[1]s",
+		"group_removed": "You were removed from %[1]s",
+
+		// New device login alert from the assistant
+		"login_alert_message": "New login to your account from %[1]s",
+		"login_alert_ip":      "(IP %[1]s)",
+		"login_alert_footer":  "at %[1]s. If this wasn't you, log out that session under Devices and change your password.",
+		"unknown_device":      "an unknown device",
+
+		// Assistant replies when the AI API is unavailable
+		"bot_greeting":       "Hey there! How can I help you today?",
+		"bot_how_are_you":    "I'm doing great, thanks for asking! What's on your mind?",
+		"bot_what_is":        "That's an interesting question! I'd need more context to give you a proper answer. Could you tell me more?",
+		"bot_who_is":         "I'm not sure who you're asking about. Could you give me more details?",
+		"bot_why":            "Good question! The answer often depends on context. What specifically would you like to know?",
+		"bot_when":           "Timing can be tricky! What event or deadline are you asking about?",
+		"bot_where":          "Location, location, location! What place are you trying to find?",
+		"bot_help":           "I'm here to help! Just tell me what you need assistance with.",
+		"bot_thanks":         "You're welcome! Let me know if there's anything else.",
+		"bot_goodbye":        "Goodbye! Feel free to message me anytime.",
+		"bot_weather":        "I don't have access to real-time weather data, but you can check your local weather app or website!",
+		"bot_time":           "The current server time is %[1]s",
+		"bot_time_format":    "3:04 PM",
+		"bot_date":           "Today is %[1]s",
+		"bot_date_format":    "Monday, January 2, 2006",
+		"bot_divide_by_zero": "Can't divide by zero!",
+		// Puns don't translate, so the jokes are English only
+		"bot_joke_1":    "Why do programmers prefer dark mode? Because light attracts bugs!",
+		"bot_joke_2":    "Why did the developer go broke? Because he used up all his cache!",
+		"bot_joke_3":    "There are only 10 types of people: those who understand binary and those who don't.",
+		"bot_joke_4":    "A SQL query walks into a bar, walks up to two tables and asks... 'Can I join you?'",
+		"bot_default_1": "Interesting! Tell me more about that.",
+		"bot_default_2": "I see what you mean. What else is on your mind?",
+		"bot_default_3": "That's a thought! Anything else you'd like to discuss?",
+		"bot_default_4": "Got it! Feel free to ask me anything.",
+		"bot_default_5": "I'm listening. What would you like to talk about?",
+
+		// Chat export (txt)
+		"export_title":             "=== Chat Export ===",
+		"export_exported":          "Exported: %[1]s",
+		"export_exported_by":       "Exported by: %[1]s",
+		"export_group":             "Group: %[1]s",
+		"export_conversation_with": "Conversation with: %[1]s",
+		"export_message_count":     "Messages: %[1]s",
+		"export_date_range":        "Date range: %[1]s to %[2]s",
+		"export_range_start":       "start",
+		"export_range_now":         "now",
+		"export_messages":          "=== Messages ===",
+		"export_forwarded_from":    "(forwarded from %[1]s)",
+		"export_edited":            "(edited)",
+		"export_media":             "[Media: %[1]s]",
+	},
+	"de": {
+		"push_new_message":         "Neue Nachricht",
+		"push_attachment":          "Hat einen Anhang gesendet",
+		"push_location":            "📍 Hat einen Standort geteilt",
+		"push_summary_dm":          "%[1]s neue Nachrichten von %[2]s",
+		"push_summary_group":       "%[1]s neue Nachrichten in %[2]s",
+		"push_login_alert_title":   "Neue Anmeldung",
+		"push_login_alert_body":    "Dein Konto wurde gerade zur Anmeldung auf %[1]s verwendet. Falls du das nicht warst, überprüfe deine Geräte.",
+		"push_test_title":          "Testbenachrichtigung",
+		"push_test_body":           "Push-Benachrichtigungen funktionieren!",
+		"push_missed_call":         "Verpasster Sprachanruf",
+		"push_missed_video_call":   "Verpasster Videoanruf",
+		"push_incoming_call":       "Eingehender Sprachanruf",
+		"push_incoming_video_call": "Eingehender Videoanruf",
+
+		"group_added":   "Du wurdest zu %[1]s hinzugefügt",
+		"group_removed": "Du wurdest aus %[1]s entfernt",
+
+		"login_alert_message": "Neue Anmeldung bei deinem Konto von %[1]s",
+		"login_alert_ip":      "(IP %[1]s)",
+		"login_alert_footer":  "um %[1]s. Falls du das nicht warst, melde diese Sitzung unter Geräte ab und ändere dein Passwort.",
+		"unknown_device":      "einem unbekannten Gerät",
+
+		"bot_greeting":       "Hallo! Wie kann ich dir heute helfen?",
+		"bot_how_are_you":    "Mir geht's super, danke der Nachfrage! Was liegt dir auf dem Herzen?",
+		"bot_what_is":        "Interessante Frage! Für eine richtige Antwort bräuchte ich mehr Kontext. Kannst du mir mehr erzählen?",
+		"bot_who_is":         "Ich bin nicht sicher, nach wem du fragst. Kannst du mir mehr Details geben?",
+		"bot_why":            "Gute Frage! Die Antwort hängt oft vom Kontext ab. Was genau möchtest du wissen?",
+		"bot_when":           "Timing kann knifflig sein! Um welches Ereignis oder welche Frist geht es?",
+		"bot_where":          "Lage, Lage, Lage! Welchen Ort suchst du?",
+		"bot_help":           "Ich bin hier, um zu helfen! Sag mir einfach, wobei du Unterstützung brauchst.",
+		"bot_thanks":         "Gern geschehen! Sag Bescheid, wenn du noch etwas brauchst.",
+		"bot_goodbye":        "Tschüss! Schreib mir jederzeit gern.",
+		"bot_weather":        "Ich habe keinen Zugriff auf aktuelle Wetterdaten, aber du kannst in deiner Wetter-App oder auf einer Wetter-Website nachsehen!",
+		"bot_time":           "Die aktuelle Serverzeit ist %[1]s",
+		"bot_time_format":    "15:04",
+		"bot_date":           "Heute ist der %[1]s",
+		"bot_date_format":    "02.01.2006",
+		"bot_divide_by_zero": "Durch null kann man nicht teilen!",
+		"bot_default_1":      "Interessant! Erzähl mir mehr darüber.",
+		"bot_default_2":      "Ich verstehe, was du meinst. Was beschäftigt dich sonst noch?",
+		"bot_default_3":      "Guter Gedanke! Möchtest du noch über etwas anderes sprechen?",
+		"bot_default_4":      "Verstanden! Frag mich ruhig alles.",
+		"bot_default_5":      "Ich höre zu. Worüber möchtest du sprechen?",
+
+		"export_title":             "=== Chat-Export ===",
+		"export_exported":          "Exportiert: %[1]s",
+		"export_exported_by":       "Exportiert von: %[1]s",
+		"export_group":             "Gruppe: %[1]s",
+		"export_conversation_with": "Unterhaltung mit: %[1]s",
+		"export_message_count":     "Nachrichten: %[1]s",
+		"export_date_range":        "Zeitraum: %[1]s bis %[2]s",
+		"export_range_start":       "Anfang",
+		"export_range_now":         "heute",
+		"export_messages":          "=== Nachrichten ===",
+		"export_forwarded_from":    "(weitergeleitet von %[1]s)",
+		"export_edited":            "(bearbeitet)",
+		"export_media":             "[Medien: %[1]s]",
+	},
+	"es": {
+		"push_new_message":         "Nuevo mensaje",
+		"push_attachment":          "Envió un archivo adjunto",
+		"push_location":            "📍 Compartió una ubicación",
+		"push_summary_dm":          "%[1]s mensajes nuevos de %[2]s",
+		"push_summary_group":       "%[1]s mensajes nuevos en %[2]s",
+		"push_login_alert_title":   "Nuevo inicio de sesión",
+		"push_login_alert_body":    "Se acaba de iniciar sesión en tu cuenta desde %[1]s. Si no fuiste tú, revisa tus dispositivos.",
+		"push_test_title":          "Notificación de prueba",
+		"push_test_body":           "¡Las notificaciones push funcionan!",
+		"push_missed_call":         "Llamada de voz perdida",
+		"push_missed_video_call":   "Videollamada perdida",
+		"push_incoming_call":       "Llamada de voz entrante",
+		"push_incoming_video_call": "Videollamada entrante",
+
+		"group_added":   "Te añadieron a %[1]s",
+		"group_removed": "Te eliminaron de %[1]s",
+
+		"login_alert_message": "Nuevo inicio de sesión en tu cuenta desde %[1]s",
+		"login_alert_ip":      "(IP %[1]s)",
+		"login_alert_footer":  "a las %[1]s. Si no fuiste tú, cierra esa sesión en Dispositivos y cambia tu contraseña.",
+		"unknown_device":      "un dispositivo desconocido",
+
+		"bot_greeting":       "¡Hola! ¿En qué puedo ayudarte hoy?",
+		"bot_how_are_you":    "¡Muy bien, gracias por preguntar! ¿Qué tienes en mente?",
+		"bot_what_is":        "¡Qué pregunta tan interesante! Necesitaría más contexto para darte una buena respuesta. ¿Puedes contarme más?",
+		"bot_who_is":         "No estoy seguro de por quién preguntas. ¿Puedes darme más detalles?",
+		"bot_why":            "¡Buena pregunta! La respuesta suele depender del contexto. ¿Qué te gustaría saber exactamente?",
+		"bot_when":           "¡El momento puede ser complicado! ¿Sobre qué evento o fecha límite preguntas?",
+		"bot_where":          "¡Ubicación, ubicación, ubicación! ¿Qué lugar estás buscando?",
+		"bot_help":           "¡Estoy aquí para ayudar! Dime en qué necesitas ayuda.",
+		"bot_thanks":         "¡De nada! Avísame si necesitas algo más.",
+		"bot_goodbye":        "¡Adiós! Escríbeme cuando quieras.",
+		"bot_weather":        "No tengo acceso a datos meteorológicos en tiempo real, ¡pero puedes consultar tu app o web del tiempo!",
+		"bot_time":           "La hora actual del servidor es %[1]s",
+		"bot_time_format":    "15:04",
+		"bot_date":           "Hoy es %[1]s",
+		"bot_date_format":    "02/01/2006",
+		"bot_divide_by_zero": "¡No se puede dividir entre cero!",
+		"bot_default_1":      "¡Interesante! Cuéntame más.",
+		"bot_default_2":      "Entiendo lo que quieres decir. ¿Qué más tienes en mente?",
+		"bot_default_3":      "¡Buena idea! ¿Hay algo más de lo que quieras hablar?",
+		"bot_default_4":      "¡Entendido! Pregúntame lo que quieras.",
+		"bot_default_5":      "Te escucho. ¿De qué te gustaría hablar?",
+
+		"export_title":             "=== Exportación del chat ===",
+		"export_exported":          "Exportado: %[1]s",
+		"export_exported_by":       "Exportado por: %[1]s",
+		"export_group":             "Grupo: %[1]s",
+		"export_conversation_with": "Conversación con: %[1]s",
+		"export_message_count":     "Mensajes: %[1]s",
+		"export_date_range":        "Período: del %[1]s al %[2]s",
+		"export_range_start":       "inicio",
+		"export_range_now":         "hoy",
+		"export_messages":          "=== Mensajes ===",
+		"export_forwarded_from":    "(reenviado de %[1]s)",
+		"export_edited":            "(editado)",
+		"export_media":             "[Multimedia: %[1]s]",
+	},
+	"fr": {
+		"push_new_message":         "Nouveau message",
+		"push_attachment":          "A envoyé une pièce jointe",
+		"push_location":            "📍 A partagé une position",
+		"push_summary_dm":          "%[1]s nouveaux messages de %[2]s",
+		"push_summary_group":       "%[1]s nouveaux messages dans %[2]s",
+		"push_login_alert_title":   "Nouvelle connexion",
+		"push_login_alert_body":    "Votre compte vient d'être utilisé pour se connecter sur %[1]s. Si ce n'était pas vous, vérifiez vos appareils.",
+		"push_test_title":          "Notification de test",
+		"push_test_body":           "Les notifications push fonctionnent !",
+		"push_missed_call":         "Appel vocal manqué",
+		"push_missed_video_call":   "Appel vidéo manqué",
+		"push_incoming_call":       "Appel vocal entrant",
+		"push_incoming_video_call": "Appel vidéo entrant",
+
+		"group_added":   "Vous avez été ajouté à %[1]s",
+		"group_removed": "Vous avez été retiré de %[1]s",
+
+		"login_alert_message": "Nouvelle connexion à votre compte depuis %[1]s",
+		"login_alert_ip":      "(IP %[1]s)",
+		"login_alert_footer":  "à %[1]s. Si ce n'était pas vous, déconnectez cette session dans Appareils et changez votre mot de passe.",
+		"unknown_device":      "un appareil inconnu",
+
+		"bot_greeting":       "Salut ! Comment puis-je t'aider aujourd'hui ?",
+		"bot_how_are_you":    "Je vais très bien, merci de demander ! Qu'est-ce qui te préoccupe ?",
+		"bot_what_is":        "C'est une question intéressante ! Il me faudrait plus de contexte pour te répondre correctement. Peux-tu m'en dire plus ?",
+		"bot_who_is":         "Je ne suis pas sûr de savoir de qui tu parles. Peux-tu me donner plus de détails ?",
+		"bot_why":            "Bonne question ! La réponse dépend souvent du contexte. Que veux-tu savoir exactement ?",
+		"bot_when":           "Le timing peut être délicat ! De quel événement ou de quelle échéance parles-tu ?",
+		"bot_where":          "L'emplacement avant tout ! Quel endroit cherches-tu ?",
+		"bot_help":           "Je suis là pour aider ! Dis-moi simplement de quoi tu as besoin.",
+		"bot_thanks":         "De rien ! Dis-moi s'il y a autre chose.",
+		"bot_goodbye":        "Au revoir ! N'hésite pas à m'écrire quand tu veux.",
+		"bot_weather":        "Je n'ai pas accès à la météo en temps réel, mais tu peux consulter ton application ou ton site météo !",
+		"bot_time":           "L'heure actuelle du serveur est %[1]s",
+		"bot_time_format":    "15:04",
+		"bot_date":           "Nous sommes le %[1]s",
+		"bot_date_format":    "02/01/2006",
+		"bot_divide_by_zero": "Impossible de diviser par zéro !",
+		"bot_default_1":      "Intéressant ! Dis-m'en plus.",
+		"bot_default_2":      "Je vois ce que tu veux dire. À quoi d'autre penses-tu ?",
+		"bot_default_3":      "C'est une idée ! Veux-tu parler d'autre chose ?",
+		"bot_default_4":      "Compris ! N'hésite pas à me poser tes questions.",
+		"bot_default_5":      "Je t'écoute. De quoi aimerais-tu parler ?",
+
+		"export_title":             "=== Export de la discussion ===",
+		"export_exported":          "Exporté : %[1]s",
+		"export_exported_by":       "Exporté par : %[1]s",
+		"export_group":             "Groupe : %[1]s",
+		"export_conversation_with": "Conversation avec : %[1]s",
+		"export_message_count":     "Messages : %[1]s",
+		"export_date_range":        "Période : du %[1]s au %[2]s",
+		"export_range_start":       "début",
+		"export_range_now":         "aujourd'hui",
+		"export_messages":          "=== Messages ===",
+		"export_forwarded_from":    "(transféré de %[1]s)",
+		"export_edited":            "(modifié)",
+		"export_media":             "[Média : %[1]s]",
+	},
+}
+
+// Localize returns the catalog text for key in locale, falling back to the
+// default locale and then to the key itself
+func Localize(locale, key string, args ...string) string {
+	text, ok := messageCatalog[NormalizeLocale(locale)][key]
+	if !ok {
+		text, ok = messageCatalog[DefaultLocale][key]
+	}
+	if !ok {
+		return key
+	}
+	if len(args) == 0 {
+		return text
+	}
+
+	values := make([]interface{}, len(args))
+	for i, arg := range args {
+		values[i] = arg
+	}
+	return fmt.Sprintf(text, values...)
+}
+
+// NormalizeLocale reduces a language tag such as "de-AT" or "pt_BR" to a
+// supported catalog locale, or the default locale if it isn't supported
+func NormalizeLocale(tag string) string {
+	if locale, ok := ParseLocale(tag); ok {
+		return locale
+	}
+	return DefaultLocale
+}
+
+// ParseLocale reduces a language tag to a supported catalog locale, and
+// reports whether the language is supported
+func ParseLocale(tag string) (string, bool) {
+	language := strings.ToLower(strings.TrimSpace(tag))
+	if i := strings.IndexAny(language, "-_"); i >= 0 {
+		language = language[:i]
+	}
+	if _, ok := messageCatalog[language]; ok {
+		return language, true
+	}
+	return "", false
+}
+
+// UserLocale returns the locale of a user's server-generated text
+func UserLocale(userID string) string {
+	var user models.User
+	if err := database.DB.Select("locale").First(&user, "id = ?", userID).Error; err != nil {
+		return DefaultLocale
+	}
+	return NormalizeLocale(user.Locale)
+}
@@ -0,0 +1,142 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"messenger/internal/database"
+	"messenger/internal/models"
+)
+
+func TestMessageCatalog(t *testing.T) {
+	// Every translation must exist in English, with the same arguments
+	for locale, messages := range messageCatalog {
+		for key, text := range messages {
+			english, ok := messageCatalog[DefaultLocale][key]
+			if !ok {
+				t.Errorf("%s: key %q has no English text", locale, key)
+				continue
+			}
+			for _, arg := range []string{"%[1]s", "%[2]s"} {
+				if strings.Contains(english, arg) != strings.Contains(text, arg) {
+					t.Errorf("%s: key %q doesn't use %s like the English text", locale, key, arg)
+				}
+			}
+		}
+	}
+}
+
+func TestLocalize(t *testing.T) {
+	tests := []struct {
+		locale string
+		key    string
+		args   []string
+		want   string
+	}{
+		{"en", "push_attachment", nil, "Sent an attachment"},
+		{"de", "push_summary_group", []string{"3", "Team"}, "3 neue Nachrichten in Team"},
+		{"fr-CA", "group_added", []string{"Team"}, "Vous avez été ajouté à Team"},
+		{"", "push_test_title", nil, "Test Notification"},
+		{"ja", "push_test_title", nil, "Test Notification"},
+		{"de", "bot_joke_1", nil, messageCatalog["en"]["bot_joke_1"]}, // Missing translations fall back to English
+		{"de", "no_such_key", nil, "no_such_key"},
+	}
+
+	for _, tt := range tests {
+		if got := Localize(tt.locale, tt.key, tt.args...); got != tt.want {
+			t.Errorf("Localize(%q, %q) = %q, want %q", tt.locale, tt.key, got, tt.want)
+		}
+	}
+}
+
+func TestParseLocale(t *testing.T) {
+	tests := []struct {
+		tag  string
+		want string
+		ok   bool
+	}{
+		{"de", "de", true},
+		{"es-MX", "es", true},
+		{"FR_fr", "fr", true},
+		{" en ", "en", true},
+		{"pt-BR", "", false},
+		{"", "", false},
+	}
+
+	for _, tt := range tests {
+		got, ok := ParseLocale(tt.tag)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("ParseLocale(%q) = %q, %v, want %q, %v", tt.tag, got, ok, tt.want, tt.ok)
+		}
+	}
+	if got := NormalizeLocale("pt-BR"); got != DefaultLocale {
+		t.Errorf("Expected unsupported locales to normalize to %q, got %q", DefaultLocale, got)
+	}
+}
+
+func TestLocalizedPush(t *testing.T) {
+	cleanup := setupAccountTestDB(t)
+	defer cleanup()
+
+	ps := newPushService()
+	ps.RegisterProvider(&MockPushProvider{name: ProviderFCM, enabled: true})
+
+	svc := NewAuthService()
+	alice, _ := svc.Register(RegisterInput{Username: "alice", Password: "password123", Locale: "de-DE"})
+	aliceID := alice.User.ID
+	database.DB.Create(&models.DeviceToken{UserID: aliceID, Token: "alice-phone", Platform: models.PlatformAndroid, Provider: ProviderFCM})
+
+	if locale := UserLocale(aliceID); locale != "de" {
+		t.Fatalf("Expected locale de, got %q", locale)
+	}
+
+	ps.SendToUser(aliceID, NewMessageSummaryNotification("bob", 3, "conv", false))
+	ps.SendToUser(aliceID, NewMessageNotification("bob", "Hallo", "conv", false))
+
+	notifications := queuedNotifications(t)
+	if len(notifications) != 2 {
+		t.Fatalf("Expected 2 pushes, got %d", len(notifications))
+	}
+	summary := notifications[0]
+	if summary.Body != "3 neue Nachrichten von bob" {
+		t.Errorf("Expected a German summary, got %q", summary.Body)
+	}
+	if summary.BodyLocKey != "" || summary.BodyLocArgs != nil {
+		t.Errorf("Loc keys should only be sent with PUSH_LOC_KEYS_ENABLED, got %q", summary.BodyLocKey)
+	}
+	if notifications[1].Body != "Hallo" {
+		t.Errorf("Message text must not be translated, got %q", notifications[1].Body)
+	}
+
+	t.Run("loc keys enabled", func(t *testing.T) {
+		t.Setenv("PUSH_LOC_KEYS_ENABLED", "true")
+		n := NewLoginAlertNotification("Pixel 8", "session").localized("fr")
+		if n.Title != "Nouvelle connexion" || !strings.Contains(n.Body, "Pixel 8") {
+			t.Errorf("Expected French login alert, got %q / %q", n.Title, n.Body)
+		}
+		if n.TitleLocKey != "push_login_alert_title" || n.BodyLocKey != "push_login_alert_body" || len(n.BodyLocArgs) != 1 {
+			t.Errorf("Expected loc keys to be kept, got %+v", n)
+		}
+
+		payload := string(NewAPNsPushProvider().buildPayload(n))
+		if !strings.Contains(payload, `"loc-key":"push_login_alert_body"`) || !strings.Contains(payload, `"loc-args":["Pixel 8"]`) {
+			t.Errorf("Expected APNs loc keys in payload, got %s", payload)
+		}
+		android := NewFirebasePushProvider().buildMessage([]string{"token"}, n).Android.Notification
+		if android.TitleLocKey != "push_login_alert_title" || android.BodyLocKey != "push_login_alert_body" {
+			t.Errorf("Expected FCM loc keys, got %+v", android)
+		}
+	})
+}
+
+func TestBotFallbackLocale(t *testing.T) {
+	bot := &BotService{}
+	response, _ := bot.GenerateResponse("hello", nil, "es")
+	if response.Content != messageCatalog["es"]["bot_greeting"] {
+		t.Errorf("Expected a Spanish greeting, got %q", response.Content)
+	}
+	response, _ = bot.GenerateResponse("6 * 7", nil, "de")
+	if response.Content != "6 * 7 = 42" {
+		t.Errorf("Expected the calculation, got %q", response.Content)
+	}
+}
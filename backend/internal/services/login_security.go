@@ -0,0 +1,281 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"messenger/internal/database"
+	"messenger/internal/models"
+)
+
+// Login lockout defaults, each overridable via environment
+const (
+	DefaultLoginLockoutThreshold = 5                   // Failed attempts before the account is locked
+	DefaultLoginLockoutBase      = time.Minute         // First lockout; each further lockout doubles
+	DefaultLoginLockoutMax       = 24 * time.Hour      // Longest lockout
+	DefaultLoginFailureWindow    = 15 * time.Minute    // Failures older than this no longer count
+	DefaultLoginHistoryRetention = 90 * 24 * time.Hour // How long login events are kept
+)
+
+// MaxLoginHistory is the most login events returned at once
+const MaxLoginHistory = 200
+
+var ErrAccountLocked = errors.New("account temporarily locked after too many failed login attempts")
+
+var (
+	unknownUserHash     []byte
+	unknownUserHashOnce sync.Once
+)
+
+// compareUnknownUserPassword spends as long as checking a real password, so
+// a login for a username no account uses takes as long as a wrong password
+func compareUnknownUserPassword(password string) {
+	unknownUserHashOnce.Do(func() {
+		unknownUserHash, _ = bcrypt.GenerateFromPassword([]byte("unknown user"), bcrypt.DefaultCost)
+	})
+	bcrypt.CompareHashAndPassword(unknownUserHash, []byte(password))
+}
+
+// AccountLockedError is returned while an account is locked. It matches
+// ErrAccountLocked with errors.Is.
+type AccountLockedError struct {
+	Until time.Time
+}
+
+func (e *AccountLockedError) Error() string {
+	return ErrAccountLocked.Error()
+}
+
+func (e *AccountLockedError) Unwrap() error {
+	return ErrAccountLocked
+}
+
+// RetryAfter returns how long until the account unlocks
+func (e *AccountLockedError) RetryAfter() time.Duration {
+	return time.Until(e.Until).Round(time.Second)
+}
+
+// NewDeviceLoginFunc is called after a login from a device the user has not
+// logged in from before, with the system message saved to the user's chat
+// with the bot, e.g. to deliver it over WebSocket
+type NewDeviceLoginFunc func(userID string, message *models.Message)
+
+var (
+	newDeviceLoginHandler NewDeviceLoginFunc
+	newDeviceLoginMu      sync.RWMutex
+)
+
+// SetNewDeviceLoginHandler registers the callback invoked on a login from a new device
+func SetNewDeviceLoginHandler(fn NewDeviceLoginFunc) {
+	newDeviceLoginMu.Lock()
+	defer newDeviceLoginMu.Unlock()
+	newDeviceLoginHandler = fn
+}
+
+func notifyNewDeviceLogin(userID string, message *models.Message) {
+	newDeviceLoginMu.RLock()
+	fn := newDeviceLoginHandler
+	newDeviceLoginMu.RUnlock()
+	if fn != nil {
+		fn(userID, message)
+	}
+}
+
+// checkLoginLockout returns an AccountLockedError if the user may not log in yet
+func checkLoginLockout(user *models.User, meta SessionMeta) error {
+	lockout := models.GetLoginLockout(database.DB, user.ID)
+	if !lockout.IsLocked() {
+		return nil
+	}
+	recordLoginEvent(user.ID, meta, false, models.LoginFailureLocked)
+	return &AccountLockedError{Until: *lockout.LockedUntil}
+}
+
+// recordLoginFailure counts a wrong password or code against the account
+// and locks it once the threshold is reached
+func recordLoginFailure(user *models.User, meta SessionMeta, reason string) {
+	recordLoginEvent(user.ID, meta, false, reason)
+	countLoginFailure(user.ID)
+}
+
+// unknownLoginKey is the lockout key for a username or phone number that no
+// account uses. Failures against it are counted and locked out like an
+// account's, so a lockout doesn't reveal whether the account exists.
+func unknownLoginKey(identifier string) string {
+	return "unknown:" + hashToken(identifier)
+}
+
+// checkUnknownLoginLockout returns an AccountLockedError if logins with an
+// identifier no account uses are locked
+func checkUnknownLoginLockout(identifier string) error {
+	lockout := models.GetLoginLockout(database.DB, unknownLoginKey(identifier))
+	if !lockout.IsLocked() {
+		return nil
+	}
+	return &AccountLockedError{Until: *lockout.LockedUntil}
+}
+
+// countLoginFailure counts a failed login against a lockout key and locks it
+// once the threshold is reached. Lockouts grow exponentially until the user
+// logs in successfully or stops failing for a day.
+func countLoginFailure(key string) {
+	threshold := intFromEnv("LOGIN_LOCKOUT_THRESHOLD", DefaultLoginLockoutThreshold)
+	if threshold <= 0 {
+		return
+	}
+
+	maxLockout := time.Duration(intFromEnv("LOGIN_LOCKOUT_MAX_MINUTES", int(DefaultLoginLockoutMax.Minutes()))) * time.Minute
+	previous := models.GetLoginLockout(database.DB, key)
+	if !previous.UpdatedAt.IsZero() && time.Since(previous.UpdatedAt) > DefaultLoginFailureWindow {
+		// Forget stale failures, and earlier lockouts once they are long over
+		if previous.LockedUntil == nil || time.Since(*previous.LockedUntil) > maxLockout {
+			models.ClearLoginLockout(database.DB, key)
+		} else if previous.FailedAttempts > 0 {
+			database.DB.Model(previous).Update("failed_attempts", 0)
+		}
+	}
+
+	lockout, err := models.IncrementLoginFailures(database.DB, key)
+	if err != nil {
+		log.Printf("Failed to record login failure for %s: %v", key, err)
+		return
+	}
+	if lockout.FailedAttempts < threshold {
+		return
+	}
+
+	duration := time.Duration(intFromEnv("LOGIN_LOCKOUT_BASE_MINUTES", int(DefaultLoginLockoutBase.Minutes()))) * time.Minute
+	for i := 0; i < lockout.Lockouts && duration < maxLockout; i++ {
+		duration *= 2
+	}
+	if duration > maxLockout {
+		duration = maxLockout
+	}
+
+	if models.LockLoginAccount(database.DB, key, threshold, time.Now().Add(duration)) {
+		log.Printf("Locked %s for %v after %d failed logins", key, duration, lockout.FailedAttempts)
+	}
+}
+
+// recordLoginSuccess clears the failure count, adds the login to the user's
+// history and alerts the user if it came from a new device
+func (s *AuthService) recordLoginSuccess(user *models.User, meta SessionMeta, sessionID string) {
+	models.ClearLoginLockout(database.DB, user.ID)
+
+	deviceHash := loginDeviceHash(meta)
+	// The first login ever (usually registration) sets the baseline, so it is never "new"
+	newDevice := models.HasSuccessfulLogin(database.DB, user.ID, "") &&
+		!models.HasSuccessfulLogin(database.DB, user.ID, deviceHash)
+
+	event := models.LoginEvent{
+		UserID:     user.ID,
+		Success:    true,
+		IPAddress:  meta.IPAddress,
+		UserAgent:  meta.UserAgent,
+		DeviceName: meta.DeviceName,
+		DeviceHash: deviceHash,
+		NewDevice:  newDevice,
+		SessionID:  sessionID,
+	}
+	if err := database.DB.Create(&event).Error; err != nil {
+		log.Printf("Failed to record login for user %s: %v", user.ID, err)
+	}
+
+	if newDevice {
+		alertNewDeviceLogin(user, &event)
+	}
+}
+
+func recordLoginEvent(userID string, meta SessionMeta, success bool, reason string) {
+	event := models.LoginEvent{
+		UserID:        userID,
+		Success:       success,
+		FailureReason: reason,
+		IPAddress:     meta.IPAddress,
+		UserAgent:     meta.UserAgent,
+		DeviceName:    meta.DeviceName,
+		DeviceHash:    loginDeviceHash(meta),
+	}
+	if err := database.DB.Create(&event).Error; err != nil {
+		log.Printf("Failed to record login event for user %s: %v", userID, err)
+	}
+}
+
+// loginDeviceHash identifies the client a login came from. IP addresses are
+// left out because they change too often to tell devices apart.
+func loginDeviceHash(meta SessionMeta) string {
+	return hashToken(meta.UserAgent + "\x00" + meta.DeviceName)
+}
+
+// alertNewDeviceLogin posts a system message from the bot into the user's
+// chat and sends a push notification to their other devices
+func alertNewDeviceLogin(user *models.User, event *models.LoginEvent) {
+	locale := NormalizeLocale(user.Locale)
+	device := event.DeviceName
+	if device == "" {
+		device = event.UserAgent
+	}
+	if device == "" {
+		device = Localize(locale, "unknown_device")
+	}
+
+	content := Localize(locale, "login_alert_message", device)
+	if event.IPAddress != "" {
+		content += " " + Localize(locale, "login_alert_ip", event.IPAddress)
+	}
+	content += " " + Localize(locale, "login_alert_footer", event.CreatedAt.UTC().Format("2006-01-02 15:04 MST"))
+
+	message := models.Message{
+		SenderID:    BotUserID,
+		RecipientID: &user.ID,
+		Content:     content,
+		Status:      models.MessageStatusDelivered,
+	}
+	if err := database.DB.Create(&message).Error; err != nil {
+		log.Printf("Failed to save new device alert for user %s: %v", user.ID, err)
+		return
+	}
+	notifyNewDeviceLogin(user.ID, &message)
+
+	go func() {
+		pushSvc := GetPushService()
+		if !pushSvc.IsEnabled() {
+			return
+		}
+		if err := pushSvc.SendToUser(user.ID, NewLoginAlertNotification(device, event.SessionID)); err != nil {
+			log.Printf("Failed to send new device alert push: %v", err)
+		}
+	}()
+}
+
+// ListLoginHistory returns the user's most recent login attempts
+func (s *AuthService) ListLoginHistory(userID string, limit int) ([]models.LoginEvent, error) {
+	if limit <= 0 || limit > MaxLoginHistory {
+		limit = MaxLoginHistory
+	}
+	return models.ListLoginEvents(database.DB, userID, limit)
+}
+
+// UnlockAccount lets a locked-out user log in again. Returns false if the
+// account had no failed attempts or lockout.
+func (s *AuthService) UnlockAccount(userID, unlockedBy string) (bool, error) {
+	unlocked, err := models.ClearLoginLockout(database.DB, userID)
+	if err == nil && unlocked {
+		log.Printf("User %s unlocked login for user %s", unlockedBy, userID)
+	}
+	return unlocked, err
+}
+
+// GetLoginLockout returns a user's failed attempts and lockout state
+func (s *AuthService) GetLoginLockout(userID string) *models.LoginLockout {
+	return models.GetLoginLockout(database.DB, userID)
+}
+
+// DeleteOldLoginEvents removes login history past LOGIN_HISTORY_RETENTION_DAYS
+func DeleteOldLoginEvents() (int64, error) {
+	retention := time.Duration(intFromEnv("LOGIN_HISTORY_RETENTION_DAYS", int(DefaultLoginHistoryRetention.Hours()/24))) * 24 * time.Hour
+	return models.DeleteLoginEventsBefore(database.DB, time.Now().Add(-retention))
+}
@@ -0,0 +1,181 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"messenger/internal/database"
+	"messenger/internal/models"
+)
+
+func failLogins(t *testing.T, svc *AuthService, username string, n int) error {
+	t.Helper()
+	var err error
+	for i := 0; i < n; i++ {
+		_, err = svc.Login(LoginInput{Username: username, Password: "wrong-password"})
+	}
+	return err
+}
+
+func TestLoginLockout(t *testing.T) {
+	cleanup := setupAuthTestDB(t)
+	defer cleanup()
+
+	svc := NewAuthService()
+	resp, _ := svc.Register(RegisterInput{Username: "target", Password: "password123"})
+	userID := resp.User.ID
+
+	if err := failLogins(t, svc, "target", DefaultLoginLockoutThreshold-1); errors.Is(err, ErrAccountLocked) {
+		t.Fatal("Account should not lock before the threshold")
+	}
+	failLogins(t, svc, "target", 1)
+
+	_, err := svc.Login(LoginInput{Username: "target", Password: "password123"})
+	var locked *AccountLockedError
+	if !errors.As(err, &locked) {
+		t.Fatalf("Expected AccountLockedError even with the right password, got %v", err)
+	}
+	first := locked.RetryAfter()
+	if first <= 0 || first > DefaultLoginLockoutBase {
+		t.Errorf("First lockout should last about %v, got %v", DefaultLoginLockoutBase, first)
+	}
+
+	t.Run("lockouts grow", func(t *testing.T) {
+		database.DB.Model(&models.LoginLockout{}).Where("user_id = ?", userID).
+			Update("locked_until", time.Now().Add(-time.Second))
+
+		failLogins(t, svc, "target", DefaultLoginLockoutThreshold)
+		_, err := svc.Login(LoginInput{Username: "target", Password: "password123"})
+		if !errors.As(err, &locked) {
+			t.Fatalf("Expected a second lockout, got %v", err)
+		}
+		if locked.RetryAfter() <= first {
+			t.Errorf("Second lockout (%v) should be longer than the first (%v)", locked.RetryAfter(), first)
+		}
+	})
+
+	t.Run("unlock", func(t *testing.T) {
+		if unlocked, err := svc.UnlockAccount(userID, "admin"); err != nil || !unlocked {
+			t.Fatalf("UnlockAccount failed: %v", err)
+		}
+		if _, err := svc.Login(LoginInput{Username: "target", Password: "password123"}); err != nil {
+			t.Fatalf("Login after unlock failed: %v", err)
+		}
+		if svc.GetLoginLockout(userID).Lockouts != 0 {
+			t.Error("Successful login should reset the lockout state")
+		}
+	})
+}
+
+func TestLoginLockout_UnknownUsername(t *testing.T) {
+	cleanup := setupAuthTestDB(t)
+	defer cleanup()
+
+	svc := NewAuthService()
+	svc.Register(RegisterInput{Username: "exists", Password: "password123"})
+
+	// An unknown username fails and locks exactly like an account, so
+	// lockouts don't tell an attacker which usernames exist
+	for _, username := range []string{"exists", "nobody"} {
+		if err := failLogins(t, svc, username, DefaultLoginLockoutThreshold-1); err == nil || errors.Is(err, ErrAccountLocked) {
+			t.Fatalf("%s: expected invalid credentials before the threshold, got %v", username, err)
+		}
+		failLogins(t, svc, username, 1)
+		if err := failLogins(t, svc, username, 1); !errors.Is(err, ErrAccountLocked) {
+			t.Errorf("%s: expected ErrAccountLocked, got %v", username, err)
+		}
+	}
+}
+
+func TestLoginLockout_StaleFailuresExpire(t *testing.T) {
+	cleanup := setupAuthTestDB(t)
+	defer cleanup()
+
+	svc := NewAuthService()
+	svc.Register(RegisterInput{Username: "typo", Password: "password123"})
+
+	failLogins(t, svc, "typo", DefaultLoginLockoutThreshold-1)
+	database.DB.Exec("UPDATE login_lockouts SET updated_at = ?", time.Now().Add(-time.Hour))
+
+	failLogins(t, svc, "typo", 1)
+	if _, err := svc.Login(LoginInput{Username: "typo", Password: "password123"}); err != nil {
+		t.Errorf("Old failures should not count towards a lockout, got %v", err)
+	}
+}
+
+func TestLoginHistory(t *testing.T) {
+	cleanup := setupAuthTestDB(t)
+	defer cleanup()
+
+	svc := NewAuthService()
+	resp, _ := svc.Register(RegisterInput{
+		Username: "historian",
+		Password: "password123",
+		Meta:     SessionMeta{UserAgent: "Phone/1.0", IPAddress: "10.0.0.1"},
+	})
+	svc.Login(LoginInput{
+		Username: "historian",
+		Password: "wrong-password",
+		Meta:     SessionMeta{UserAgent: "curl/8.0", IPAddress: "203.0.113.9"},
+	})
+
+	events, err := svc.ListLoginHistory(resp.User.ID, 10)
+	if err != nil {
+		t.Fatalf("ListLoginHistory failed: %v", err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(events))
+	}
+	failed := events[0]
+	if failed.Success || failed.FailureReason != models.LoginFailureInvalidPassword || failed.IPAddress != "203.0.113.9" || failed.UserAgent != "curl/8.0" {
+		t.Errorf("Unexpected failed login event %+v", failed)
+	}
+	if !events[1].Success || events[1].NewDevice {
+		t.Errorf("Registration should be a successful login that is not flagged as new, got %+v", events[1])
+	}
+}
+
+func TestNewDeviceLoginAlert(t *testing.T) {
+	cleanup := setupAuthTestDB(t)
+	defer cleanup()
+	database.DB.AutoMigrate(&models.Message{})
+
+	var alerts []*models.Message
+	SetNewDeviceLoginHandler(func(userID string, message *models.Message) {
+		alerts = append(alerts, message)
+	})
+	defer SetNewDeviceLoginHandler(nil)
+
+	svc := NewAuthService()
+	phone := SessionMeta{UserAgent: "Phone/1.0", IPAddress: "10.0.0.1"}
+	resp, _ := svc.Register(RegisterInput{Username: "alerted", Password: "password123", Meta: phone})
+
+	svc.Login(LoginInput{Username: "alerted", Password: "password123", Meta: phone})
+	if len(alerts) != 0 {
+		t.Fatal("Logging in again from a known device should not alert")
+	}
+
+	svc.Login(LoginInput{
+		Username:   "alerted",
+		Password:   "password123",
+		DeviceName: "Firefox on Linux",
+		Meta:       SessionMeta{UserAgent: "Mozilla/5.0", IPAddress: "198.51.100.7"},
+	})
+	if len(alerts) != 1 {
+		t.Fatalf("Expected 1 new device alert, got %d", len(alerts))
+	}
+
+	var message models.Message
+	if err := database.DB.First(&message, "id = ?", alerts[0].ID).Error; err != nil {
+		t.Fatalf("Alert should be saved as a message: %v", err)
+	}
+	if message.SenderID != BotUserID || message.RecipientID == nil || *message.RecipientID != resp.User.ID {
+		t.Errorf("Alert should come from the bot to the user, got %+v", message)
+	}
+
+	events, _ := svc.ListLoginHistory(resp.User.ID, 1)
+	if len(events) != 1 || !events[0].NewDevice {
+		t.Error("Login should be recorded as from a new device")
+	}
+}
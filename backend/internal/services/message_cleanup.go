@@ -32,11 +32,35 @@ func (s *MessageCleanupService) Start() {
 
 		// Run once at startup
 		s.cleanupExpiredMessages()
+		s.cleanupExpiredSessions()
+		s.cleanupExpiredPhoneVerifications()
+		s.cleanupExpiredPasswordResetTokens()
+		s.cleanupExpiredOIDCAuthRequests()
+		s.cleanupExpiredSigningKeys()
+		s.cleanupExpiredWebSocketTickets()
+		s.cleanupExpiredDeviceLinkRequests()
+		s.cleanupExpiredAccountExports()
+		s.processAccountDeletions()
+		s.cleanupOldLoginEvents()
+		s.cleanupOldPushDeadLetters()
+		s.cleanupOldPushAttempts()
 
 		for {
 			select {
 			case <-ticker.C:
 				s.cleanupExpiredMessages()
+				s.cleanupExpiredSessions()
+				s.cleanupExpiredPhoneVerifications()
+				s.cleanupExpiredPasswordResetTokens()
+				s.cleanupExpiredOIDCAuthRequests()
+				s.cleanupExpiredSigningKeys()
+				s.cleanupExpiredWebSocketTickets()
+				s.cleanupExpiredDeviceLinkRequests()
+				s.cleanupExpiredAccountExports()
+				s.processAccountDeletions()
+				s.cleanupOldLoginEvents()
+				s.cleanupOldPushDeadLetters()
+				s.cleanupOldPushAttempts()
 			case <-s.stopChan:
 				return
 			}
@@ -70,6 +94,171 @@ func (s *MessageCleanupService) cleanupExpiredMessages() {
 	}
 }
 
+// expiredSessionRetention is how long expired sessions are kept for the session history
+const expiredSessionRetention = 30 * 24 * time.Hour
+
+// cleanupExpiredSessions deletes sessions and refresh tokens long past their expiry
+func (s *MessageCleanupService) cleanupExpiredSessions() {
+	deleted, err := models.DeleteExpiredSessions(s.db, time.Now().Add(-expiredSessionRetention))
+	if err != nil {
+		log.Printf("Error cleaning up expired sessions: %v", err)
+		return
+	}
+
+	if deleted > 0 {
+		log.Printf("Cleaned up %d expired sessions", deleted)
+	}
+}
+
+// expiredPhoneVerificationRetention keeps old OTP codes around long enough for the hourly send limit
+const expiredPhoneVerificationRetention = 24 * time.Hour
+
+// cleanupExpiredPhoneVerifications deletes old SMS verification codes
+func (s *MessageCleanupService) cleanupExpiredPhoneVerifications() {
+	deleted, err := models.DeleteExpiredPhoneVerifications(s.db, time.Now().Add(-expiredPhoneVerificationRetention))
+	if err != nil {
+		log.Printf("Error cleaning up phone verification codes: %v", err)
+		return
+	}
+
+	if deleted > 0 {
+		log.Printf("Cleaned up %d expired phone verification codes", deleted)
+	}
+}
+
+// expiredPasswordResetRetention keeps old reset tokens around long enough for the hourly request limit
+const expiredPasswordResetRetention = 24 * time.Hour
+
+// cleanupExpiredPasswordResetTokens deletes old password reset tokens
+func (s *MessageCleanupService) cleanupExpiredPasswordResetTokens() {
+	deleted, err := models.DeleteExpiredPasswordResetTokens(s.db, time.Now().Add(-expiredPasswordResetRetention))
+	if err != nil {
+		log.Printf("Error cleaning up password reset tokens: %v", err)
+		return
+	}
+
+	if deleted > 0 {
+		log.Printf("Cleaned up %d expired password reset tokens", deleted)
+	}
+}
+
+// cleanupExpiredOIDCAuthRequests deletes abandoned single sign-on requests
+func (s *MessageCleanupService) cleanupExpiredOIDCAuthRequests() {
+	deleted, err := models.DeleteExpiredOIDCAuthRequests(s.db, time.Now())
+	if err != nil {
+		log.Printf("Error cleaning up SSO requests: %v", err)
+		return
+	}
+
+	if deleted > 0 {
+		log.Printf("Cleaned up %d expired SSO requests", deleted)
+	}
+}
+
+// cleanupExpiredSigningKeys deletes token signing keys past their verification window
+func (s *MessageCleanupService) cleanupExpiredSigningKeys() {
+	deleted, err := models.DeleteExpiredSigningKeys(s.db, time.Now())
+	if err != nil {
+		log.Printf("Error cleaning up signing keys: %v", err)
+		return
+	}
+
+	if deleted > 0 {
+		log.Printf("Cleaned up %d expired signing keys", deleted)
+	}
+}
+
+// cleanupExpiredWebSocketTickets deletes WebSocket tickets that can no longer be redeemed
+func (s *MessageCleanupService) cleanupExpiredWebSocketTickets() {
+	deleted, err := models.DeleteExpiredWebSocketTickets(s.db, time.Now())
+	if err != nil {
+		log.Printf("Error cleaning up WebSocket tickets: %v", err)
+		return
+	}
+
+	if deleted > 0 {
+		log.Printf("Cleaned up %d expired WebSocket tickets", deleted)
+	}
+}
+
+// cleanupExpiredDeviceLinkRequests deletes device link requests that can no longer be approved or completed
+func (s *MessageCleanupService) cleanupExpiredDeviceLinkRequests() {
+	deleted, err := models.DeleteExpiredDeviceLinkRequests(s.db, time.Now())
+	if err != nil {
+		log.Printf("Error cleaning up device link requests: %v", err)
+		return
+	}
+
+	if deleted > 0 {
+		log.Printf("Cleaned up %d expired device link requests", deleted)
+	}
+}
+
+// cleanupExpiredAccountExports deletes account exports past their download window
+func (s *MessageCleanupService) cleanupExpiredAccountExports() {
+	deleted, err := DeleteExpiredAccountExports(time.Now())
+	if err != nil {
+		log.Printf("Error cleaning up account exports: %v", err)
+		return
+	}
+
+	if deleted > 0 {
+		log.Printf("Cleaned up %d expired account exports", deleted)
+	}
+}
+
+// processAccountDeletions erases accounts whose deletion grace period has ended
+func (s *MessageCleanupService) processAccountDeletions() {
+	erased, err := NewAuthService().ProcessDueAccountDeletions()
+	if err != nil {
+		log.Printf("Error processing account deletions: %v", err)
+		return
+	}
+
+	if erased > 0 {
+		log.Printf("Erased %d accounts after their deletion grace period", erased)
+	}
+}
+
+// cleanupOldLoginEvents deletes login history past its retention period
+func (s *MessageCleanupService) cleanupOldLoginEvents() {
+	deleted, err := DeleteOldLoginEvents()
+	if err != nil {
+		log.Printf("Error cleaning up login history: %v", err)
+		return
+	}
+
+	if deleted > 0 {
+		log.Printf("Cleaned up %d old login events", deleted)
+	}
+}
+
+// cleanupOldPushDeadLetters deletes dead-lettered push jobs past their retention period
+func (s *MessageCleanupService) cleanupOldPushDeadLetters() {
+	deleted, err := DeleteOldPushDeadLetters()
+	if err != nil {
+		log.Printf("Error cleaning up push dead letters: %v", err)
+		return
+	}
+
+	if deleted > 0 {
+		log.Printf("Cleaned up %d old push dead letters", deleted)
+	}
+}
+
+// cleanupOldPushAttempts deletes per-device push attempts past their retention period
+func (s *MessageCleanupService) cleanupOldPushAttempts() {
+	deleted, err := DeleteOldPushAttempts()
+	if err != nil {
+		log.Printf("Error cleaning up push attempts: %v", err)
+		return
+	}
+
+	if deleted > 0 {
+		log.Printf("Cleaned up %d old push attempts", deleted)
+	}
+}
+
 // CleanupNow triggers an immediate cleanup (useful for testing)
 func (s *MessageCleanupService) CleanupNow() {
 	s.cleanupExpiredMessages()
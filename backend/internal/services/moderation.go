@@ -6,16 +6,22 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
-	vision "cloud.google.com/go/vision/apiv1"
-	"cloud.google.com/go/vision/v2/apiv1/visionpb"
 	"messenger/internal/models"
 )
 
+// configuredProvider pairs a provider with the thresholds used to judge its scores
+type configuredProvider struct {
+	provider   ModerationProvider
+	thresholds ModerationThresholds
+}
+
+// ModerationService runs uploads through a chain of moderation providers
 type ModerationService struct {
-	client *vision.ImageAnnotatorClient
-	// Set to true to use mock moderation (for development without GCP)
-	useMock bool
+	providers []configuredProvider
+	// Number of rejected frames needed to reject a video outright
+	videoRejectFrames int
 }
 
 type ScanResult struct {
@@ -24,120 +30,212 @@ type ScanResult struct {
 	Adult     string
 	Violence  string
 	Racy      string
+	// Provider that decided the final status, and its score
+	Provider string
+	Score    float64
+	Reasons  []string
+	// Frames that were flagged when scanning a video (empty for other media)
+	FlaggedFrames []FrameFlag
+}
+
+// providerScan is the per-provider entry stored in ScanResult.RawResult
+type providerScan struct {
+	Provider string             `json:"provider"`
+	Status   models.MediaStatus `json:"status"`
+	Score    float64            `json:"score"`
+	Reasons  []string           `json:"reasons,omitempty"`
+	Details  map[string]string  `json:"details,omitempty"`
+	Error    string             `json:"error,omitempty"`
 }
 
+// NewModerationService builds the provider chain from MODERATION_PROVIDERS
+// (comma-separated, e.g. "local,vision,clamav"). By default the local scanner
+// runs first, followed by Google Cloud Vision when credentials are available
+// and ClamAV when CLAMAV_ADDRESS is set.
 func NewModerationService() *ModerationService {
 	// Check if we should use mock (no GCP credentials)
-	useMock := os.Getenv("USE_MOCK_MODERATION") == "true"
-
-	if useMock {
+	if os.Getenv("USE_MOCK_MODERATION") == "true" {
 		log.Println("Using mock moderation service")
-		return &ModerationService{useMock: true}
+		return NewModerationServiceWithProviders(&MockModerationProvider{})
 	}
 
-	// Try to initialize Google Cloud Vision client
-	ctx := context.Background()
-	client, err := vision.NewImageAnnotatorClient(ctx)
-	if err != nil {
-		log.Printf("Warning: Could not create Vision client: %v. Using mock moderation.", err)
-		return &ModerationService{useMock: true}
+	names := []string{"local", "vision"}
+	if os.Getenv("CLAMAV_ADDRESS") != "" {
+		names = append(names, "clamav")
+	}
+	explicit := false
+	if value := os.Getenv("MODERATION_PROVIDERS"); value != "" {
+		names = strings.Split(value, ",")
+		explicit = true
 	}
 
-	return &ModerationService{
-		client:  client,
-		useMock: false,
+	ctx := context.Background()
+	var providers []ModerationProvider
+	for _, name := range names {
+		switch strings.TrimSpace(strings.ToLower(name)) {
+		case "local":
+			providers = append(providers, NewLocalModerationProvider())
+		case "vision":
+			provider, err := NewVisionModerationProvider(ctx)
+			if err != nil {
+				if explicit {
+					log.Printf("Warning: Could not create Vision client: %v", err)
+				} else {
+					log.Printf("Vision moderation unavailable (%v), using local moderation only", err)
+				}
+				continue
+			}
+			providers = append(providers, provider)
+		case "clamav":
+			provider := NewClamAVModerationProvider()
+			if !provider.IsEnabled() {
+				log.Println("Warning: clamav moderation requested but CLAMAV_ADDRESS is not set")
+				continue
+			}
+			if err := provider.Ping(ctx); err != nil {
+				// Keep the provider: scans fail and go to review until clamd is back
+				log.Printf("Warning: clamd is not reachable (%v), documents will be queued for review", err)
+			}
+			providers = append(providers, provider)
+		case "mock":
+			providers = append(providers, &MockModerationProvider{})
+		case "":
+		default:
+			log.Printf("Warning: Unknown moderation provider %q", name)
+		}
 	}
-}
 
-func (s *ModerationService) ScanImage(filePath string) (*ScanResult, error) {
-	if s.useMock {
-		return s.mockScan(filePath)
+	if len(providers) == 0 {
+		log.Println("Warning: No moderation providers configured, falling back to local moderation")
+		providers = append(providers, NewLocalModerationProvider())
 	}
 
-	return s.gcpScan(filePath)
+	return NewModerationServiceWithProviders(providers...)
 }
 
-func (s *ModerationService) mockScan(filePath string) (*ScanResult, error) {
-	// Mock implementation - approve everything in dev
-	log.Printf("Mock scanning file: %s", filePath)
-
-	return &ScanResult{
-		Status:    models.MediaStatusApproved,
-		RawResult: `{"mock": true, "result": "approved"}`,
-		Adult:     "VERY_UNLIKELY",
-		Violence:  "VERY_UNLIKELY",
-		Racy:      "VERY_UNLIKELY",
-	}, nil
+// NewModerationServiceWithProviders creates a service that runs the given
+// providers in order, each using thresholds loaded from the environment
+func NewModerationServiceWithProviders(providers ...ModerationProvider) *ModerationService {
+	s := &ModerationService{
+		videoRejectFrames: intFromEnv("MODERATION_VIDEO_REJECT_FRAMES", DefaultVideoRejectFrames),
+	}
+	for _, p := range providers {
+		s.AddProvider(p, LoadModerationThresholds(p.Name()))
+	}
+	return s
 }
 
-func (s *ModerationService) gcpScan(filePath string) (*ScanResult, error) {
-	ctx := context.Background()
+// AddProvider appends a provider to the chain with explicit thresholds
+func (s *ModerationService) AddProvider(provider ModerationProvider, thresholds ModerationThresholds) {
+	s.providers = append(s.providers, configuredProvider{provider: provider, thresholds: thresholds})
+	log.Printf("Moderation provider registered: %s (review >= %.2f, reject >= %.2f)",
+		provider.Name(), thresholds.Review, thresholds.Reject)
+}
 
-	file, err := os.Open(filePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open file: %w", err)
+// Providers returns the names of the providers in the chain
+func (s *ModerationService) Providers() []string {
+	names := make([]string, len(s.providers))
+	for i, cp := range s.providers {
+		names[i] = cp.provider.Name()
 	}
-	defer file.Close()
+	return names
+}
 
-	image, err := vision.NewImageFromReader(file)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create image: %w", err)
+// ScanImage scans an image file. Kept for callers that only deal with images.
+func (s *ModerationService) ScanImage(filePath string) (*ScanResult, error) {
+	return s.ScanMedia(context.Background(), &ModerationInput{
+		FilePath:  filePath,
+		MediaType: models.MediaTypeImage,
+	})
+}
+
+// ScanMedia runs every enabled provider that supports the media type, in order.
+// The most severe decision wins and a rejection stops the chain. A provider
+// error sends the file to review unless another provider rejected it.
+func (s *ModerationService) ScanMedia(ctx context.Context, input *ModerationInput) (*ScanResult, error) {
+	result := &ScanResult{Status: models.MediaStatusApproved}
+	var scans []providerScan
+
+	for _, cp := range s.providers {
+		if !cp.provider.IsEnabled() || !cp.provider.Supports(input.MediaType) {
+			continue
+		}
+
+		name := cp.provider.Name()
+		verdict, err := cp.provider.Scan(ctx, input)
+		if err != nil {
+			log.Printf("Moderation provider %s failed on %s: %v", name, input.FilePath, err)
+			scans = append(scans, providerScan{Provider: name, Status: models.MediaStatusReview, Error: err.Error()})
+			if statusSeverity(models.MediaStatusReview) > statusSeverity(result.Status) {
+				result.Status = models.MediaStatusReview
+				result.Provider = name
+				result.Reasons = []string{fmt.Sprintf("%s scan failed", name)}
+			}
+			continue
+		}
+
+		status := cp.thresholds.Decide(verdict.Score)
+		scans = append(scans, providerScan{
+			Provider: name,
+			Status:   status,
+			Score:    verdict.Score,
+			Reasons:  verdict.Reasons,
+			Details:  verdict.Details,
+		})
+
+		if verdict.Adult != "" {
+			result.Adult = verdict.Adult
+			result.Violence = verdict.Violence
+			result.Racy = verdict.Racy
+		}
+
+		if statusSeverity(status) > statusSeverity(result.Status) ||
+			(status == result.Status && verdict.Score > result.Score) {
+			result.Status = status
+			result.Provider = name
+			result.Score = verdict.Score
+			result.Reasons = verdict.Reasons
+		}
+
+		if status == models.MediaStatusRejected {
+			log.Printf("Blocking media: %s rejected %s (score %.2f): %v", name, input.FilePath, verdict.Score, verdict.Reasons)
+			break
+		}
 	}
 
-	props, err := s.client.DetectSafeSearch(ctx, image, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to detect safe search: %w", err)
+	if len(scans) == 0 {
+		return nil, fmt.Errorf("no moderation provider supports %s", input.MediaType)
 	}
 
-	result := &ScanResult{
-		Adult:    props.Adult.String(),
-		Violence: props.Violence.String(),
-		Racy:     props.Racy.String(),
+	if result.Status == models.MediaStatusReview {
+		log.Printf("Sending to review: %s (%s)", input.FilePath, strings.Join(result.Reasons, "; "))
 	}
 
-	// Serialize raw result
-	rawBytes, _ := json.Marshal(map[string]string{
-		"adult":    result.Adult,
-		"violence": result.Violence,
-		"racy":     result.Racy,
-		"spoof":    props.Spoof.String(),
-		"medical":  props.Medical.String(),
+	rawBytes, _ := json.Marshal(map[string]interface{}{
+		"status":    result.Status,
+		"providers": scans,
 	})
 	result.RawResult = string(rawBytes)
 
-	// Decision logic
-	result.Status = s.makeDecision(props)
-
 	return result, nil
 }
 
-func (s *ModerationService) makeDecision(props *visionpb.SafeSearchAnnotation) models.MediaStatus {
-	// Likelihood levels: UNKNOWN, VERY_UNLIKELY, UNLIKELY, POSSIBLE, LIKELY, VERY_LIKELY
-
-	// Block if VERY_LIKELY or LIKELY explicit content
-	if props.Adult >= visionpb.Likelihood_LIKELY {
-		log.Printf("Blocking media: Adult content detected (%s)", props.Adult.String())
-		return models.MediaStatusRejected
-	}
-
-	if props.Violence >= visionpb.Likelihood_LIKELY {
-		log.Printf("Blocking media: Violence detected (%s)", props.Violence.String())
-		return models.MediaStatusRejected
+// statusSeverity orders moderation outcomes so the strictest one wins
+func statusSeverity(status models.MediaStatus) int {
+	switch status {
+	case models.MediaStatusRejected:
+		return 2
+	case models.MediaStatusReview:
+		return 1
+	default:
+		return 0
 	}
-
-	// Send to review if POSSIBLE
-	if props.Adult >= visionpb.Likelihood_POSSIBLE ||
-		props.Violence >= visionpb.Likelihood_POSSIBLE {
-		log.Printf("Sending to review: Possible explicit content")
-		return models.MediaStatusReview
-	}
-
-	// Approve otherwise
-	return models.MediaStatusApproved
 }
 
 func (s *ModerationService) Close() {
-	if s.client != nil {
-		s.client.Close()
+	for _, cp := range s.providers {
+		if closer, ok := cp.provider.(interface{ Close() }); ok {
+			closer.Close()
+		}
 	}
 }
@@ -0,0 +1,221 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"messenger/internal/models"
+)
+
+const (
+	// clamdChunkSize is the size of each INSTREAM chunk sent to clamd
+	clamdChunkSize = 64 * 1024
+	// DefaultClamAVTimeout bounds a whole scan, including connecting
+	DefaultClamAVTimeout = 60 * time.Second
+)
+
+// ClamAVModerationProvider scans files for malware using the clamd INSTREAM
+// protocol. Infected files score 1.0. Connection failures are returned as
+// errors so the moderation chain sends the file to review instead of approving it.
+type ClamAVModerationProvider struct {
+	network    string // "tcp" or "unix"
+	address    string
+	timeout    time.Duration
+	mediaTypes map[models.MediaType]bool
+}
+
+// NewClamAVModerationProvider creates a provider from CLAMAV_ADDRESS, which
+// accepts "tcp://host:port", "unix:///path/to/clamd.sock" or a bare "host:port".
+// CLAMAV_MEDIA_TYPES selects which media types are scanned (default: document).
+func NewClamAVModerationProvider() *ClamAVModerationProvider {
+	network, address := parseClamAVAddress(os.Getenv("CLAMAV_ADDRESS"))
+
+	mediaTypes := make(map[models.MediaType]bool)
+	for _, t := range strings.Split(getEnvOrDefault("CLAMAV_MEDIA_TYPES", string(models.MediaTypeDocument)), ",") {
+		if t = strings.TrimSpace(strings.ToLower(t)); t != "" {
+			mediaTypes[models.MediaType(t)] = true
+		}
+	}
+
+	return &ClamAVModerationProvider{
+		network:    network,
+		address:    address,
+		timeout:    time.Duration(intFromEnv("CLAMAV_TIMEOUT_SECONDS", int(DefaultClamAVTimeout/time.Second))) * time.Second,
+		mediaTypes: mediaTypes,
+	}
+}
+
+// NewClamAVModerationProviderWithAddress creates a provider for an explicit
+// clamd address that scans the given media types
+func NewClamAVModerationProviderWithAddress(network, address string, timeout time.Duration, mediaTypes ...models.MediaType) *ClamAVModerationProvider {
+	p := &ClamAVModerationProvider{
+		network:    network,
+		address:    address,
+		timeout:    timeout,
+		mediaTypes: make(map[models.MediaType]bool),
+	}
+	for _, t := range mediaTypes {
+		p.mediaTypes[t] = true
+	}
+	return p
+}
+
+func parseClamAVAddress(value string) (string, string) {
+	switch {
+	case value == "":
+		return "", ""
+	case strings.HasPrefix(value, "unix://"):
+		return "unix", strings.TrimPrefix(value, "unix://")
+	case strings.HasPrefix(value, "tcp://"):
+		return "tcp", strings.TrimPrefix(value, "tcp://")
+	case strings.HasPrefix(value, "/"):
+		return "unix", value
+	default:
+		return "tcp", value
+	}
+}
+
+func (p *ClamAVModerationProvider) Name() string {
+	return "clamav"
+}
+
+func (p *ClamAVModerationProvider) IsEnabled() bool {
+	return p.address != ""
+}
+
+func (p *ClamAVModerationProvider) Supports(mediaType models.MediaType) bool {
+	return p.mediaTypes[mediaType]
+}
+
+func (p *ClamAVModerationProvider) Scan(ctx context.Context, input *ModerationInput) (*ProviderVerdict, error) {
+	file, err := os.Open(input.FilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	reply, err := p.instream(ctx, file)
+	if err != nil {
+		return nil, err
+	}
+
+	verdict := &ProviderVerdict{Details: map[string]string{"clamd": reply}}
+
+	// Replies look like "stream: OK", "stream: Eicar-Signature FOUND"
+	// or "INSTREAM size limit exceeded. ERROR"
+	switch {
+	case strings.HasSuffix(reply, " FOUND"):
+		signature := strings.TrimSuffix(strings.TrimPrefix(reply, "stream: "), " FOUND")
+		verdict.Score = 1.0
+		verdict.Reasons = []string{"malware detected: " + signature}
+		verdict.Details["signature"] = signature
+	case strings.HasSuffix(reply, " OK"):
+		verdict.Score = 0
+	default:
+		return nil, fmt.Errorf("clamd error: %s", reply)
+	}
+
+	return verdict, nil
+}
+
+// Ping checks that clamd is reachable
+func (p *ClamAVModerationProvider) Ping(ctx context.Context) error {
+	conn, err := p.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("zPING\x00")); err != nil {
+		return fmt.Errorf("failed to send PING: %w", err)
+	}
+	reply, err := readClamdReply(conn)
+	if err != nil {
+		return err
+	}
+	if reply != "PONG" {
+		return fmt.Errorf("unexpected clamd reply: %s", reply)
+	}
+	return nil
+}
+
+func (p *ClamAVModerationProvider) dial(ctx context.Context) (net.Conn, error) {
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, p.network, p.address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to clamd: %w", err)
+	}
+	if p.timeout > 0 {
+		conn.SetDeadline(time.Now().Add(p.timeout))
+	}
+	return conn, nil
+}
+
+// instream streams r to clamd as length-prefixed chunks and returns its reply
+func (p *ClamAVModerationProvider) instream(ctx context.Context, r io.Reader) (string, error) {
+	conn, err := p.dial(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return "", fmt.Errorf("failed to start INSTREAM: %w", err)
+	}
+
+	buf := make([]byte, clamdChunkSize)
+	size := make([]byte, 4)
+	for {
+		n, readErr := r.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, err := conn.Write(size); err != nil {
+				return "", fmt.Errorf("failed to send chunk: %w", err)
+			}
+			if _, err := conn.Write(buf[:n]); err != nil {
+				// clamd closes the connection once the stream limit is hit;
+				// its reply explains why
+				if reply, replyErr := readClamdReply(conn); replyErr == nil {
+					return reply, nil
+				}
+				return "", fmt.Errorf("failed to send chunk: %w", err)
+			}
+		}
+		if readErr == io.EOF {
+			break
+		}
+		if readErr != nil {
+			return "", fmt.Errorf("failed to read file: %w", readErr)
+		}
+	}
+
+	// A zero-length chunk ends the stream
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return "", fmt.Errorf("failed to end stream: %w", err)
+	}
+
+	return readClamdReply(conn)
+}
+
+// readClamdReply reads a NUL-terminated reply (z-prefixed commands)
+func readClamdReply(conn net.Conn) (string, error) {
+	reply, err := bufio.NewReader(conn).ReadBytes(0)
+	if err != nil && !(err == io.EOF && len(reply) > 0) {
+		return "", fmt.Errorf("failed to read clamd reply: %w", err)
+	}
+	return string(bytes.TrimRight(reply, "\x00\n")), nil
+}
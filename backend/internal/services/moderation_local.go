@@ -0,0 +1,336 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"log"
+	"math/bits"
+	"os"
+	"strconv"
+	"strings"
+
+	"messenger/internal/models"
+)
+
+// Default local moderation policy
+const (
+	DefaultHashMaxDistance = 10
+	DefaultMaxImageWidth   = 10000
+	DefaultMaxImageHeight  = 10000
+	DefaultMaxImagePixels  = 50_000_000
+)
+
+// signatureHeaderSize is how much of a file is read to validate its signature
+const signatureHeaderSize = 512
+
+// LocalModerationPolicy configures the local rule-based scanner
+type LocalModerationPolicy struct {
+	MaxImageWidth   int
+	MaxImageHeight  int
+	MaxImagePixels  int
+	MaxFileBytes    int64 // 0 = no extra limit beyond the upload limits
+	HashMaxDistance int   // Maximum Hamming distance for a blocklist match
+}
+
+// LocalModerationProvider is a rule-based scanner that needs no cloud service.
+// It validates file signatures against the declared content type, matches
+// images against a perceptual-hash blocklist and enforces size/dimension limits.
+type LocalModerationProvider struct {
+	policy    LocalModerationPolicy
+	blocklist []uint64
+}
+
+// NewLocalModerationProvider creates a local provider configured from the environment.
+// The hash blocklist is read from MODERATION_HASH_BLOCKLIST (one hex hash per line).
+func NewLocalModerationProvider() *LocalModerationProvider {
+	policy := LocalModerationPolicy{
+		MaxImageWidth:   intFromEnv("MODERATION_MAX_IMAGE_WIDTH", DefaultMaxImageWidth),
+		MaxImageHeight:  intFromEnv("MODERATION_MAX_IMAGE_HEIGHT", DefaultMaxImageHeight),
+		MaxImagePixels:  intFromEnv("MODERATION_MAX_IMAGE_PIXELS", DefaultMaxImagePixels),
+		MaxFileBytes:    int64(intFromEnv("MODERATION_MAX_FILE_MB", 0)) * 1024 * 1024,
+		HashMaxDistance: intFromEnv("MODERATION_HASH_MAX_DISTANCE", DefaultHashMaxDistance),
+	}
+
+	p := &LocalModerationProvider{policy: policy}
+
+	if path := os.Getenv("MODERATION_HASH_BLOCKLIST"); path != "" {
+		blocklist, err := LoadHashBlocklist(path)
+		if err != nil {
+			log.Printf("Warning: Could not load hash blocklist %s: %v", path, err)
+		} else {
+			p.blocklist = blocklist
+			log.Printf("Loaded %d perceptual hashes from blocklist", len(blocklist))
+		}
+	}
+
+	return p
+}
+
+// NewLocalModerationProviderWithPolicy creates a local provider with an explicit policy and blocklist
+func NewLocalModerationProviderWithPolicy(policy LocalModerationPolicy, blocklist []uint64) *LocalModerationProvider {
+	return &LocalModerationProvider{policy: policy, blocklist: blocklist}
+}
+
+func (p *LocalModerationProvider) Name() string {
+	return "local"
+}
+
+func (p *LocalModerationProvider) IsEnabled() bool {
+	return true
+}
+
+func (p *LocalModerationProvider) Supports(mediaType models.MediaType) bool {
+	return true
+}
+
+func (p *LocalModerationProvider) Scan(ctx context.Context, input *ModerationInput) (*ProviderVerdict, error) {
+	file, err := os.Open(input.FilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	verdict := &ProviderVerdict{Details: map[string]string{}}
+	flag := func(score float64, reason string) {
+		if score > verdict.Score {
+			verdict.Score = score
+		}
+		verdict.Reasons = append(verdict.Reasons, reason)
+	}
+
+	// File size policy
+	size := input.Size
+	if stat, err := file.Stat(); err == nil {
+		size = stat.Size()
+	}
+	if p.policy.MaxFileBytes > 0 && size > p.policy.MaxFileBytes {
+		flag(1.0, fmt.Sprintf("file size %d exceeds limit of %d bytes", size, p.policy.MaxFileBytes))
+	}
+
+	// Signature check
+	header := make([]byte, signatureHeaderSize)
+	n, err := io.ReadFull(file, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	header = header[:n]
+
+	if ok, detail := MatchesSignature(input.ContentType, header); !ok {
+		flag(1.0, "file signature does not match declared type "+input.ContentType)
+		verdict.Details["signature"] = detail
+	} else {
+		verdict.Details["signature"] = "ok"
+	}
+
+	if input.MediaType != models.MediaTypeImage {
+		return verdict, nil
+	}
+
+	// Dimension policy
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	config, format, err := image.DecodeConfig(file)
+	if err != nil {
+		// WebP has no standard library decoder; only the signature can be checked
+		if strings.EqualFold(input.ContentType, "image/webp") {
+			verdict.Details["dimensions"] = "unsupported format"
+			return verdict, nil
+		}
+		flag(0.5, "image could not be decoded")
+		verdict.Details["dimensions"] = "undecodable"
+		return verdict, nil
+	}
+	verdict.Details["dimensions"] = fmt.Sprintf("%dx%d", config.Width, config.Height)
+	verdict.Details["format"] = format
+
+	if config.Width > p.policy.MaxImageWidth || config.Height > p.policy.MaxImageHeight {
+		flag(1.0, fmt.Sprintf("image dimensions %dx%d exceed limit of %dx%d",
+			config.Width, config.Height, p.policy.MaxImageWidth, p.policy.MaxImageHeight))
+		return verdict, nil
+	}
+	if config.Width*config.Height > p.policy.MaxImagePixels {
+		flag(1.0, fmt.Sprintf("image has %d pixels, limit is %d", config.Width*config.Height, p.policy.MaxImagePixels))
+		return verdict, nil
+	}
+
+	// Perceptual hash blocklist
+	if len(p.blocklist) == 0 {
+		return verdict, nil
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to read file: %w", err)
+	}
+	img, _, err := image.Decode(file)
+	if err != nil {
+		flag(0.5, "image could not be decoded")
+		return verdict, nil
+	}
+	hash := DHash(img)
+	verdict.Details["dhash"] = fmt.Sprintf("%016x", hash)
+
+	if distance, matched := p.matchBlocklist(hash); matched {
+		flag(1.0, fmt.Sprintf("image matches blocklisted hash (distance %d)", distance))
+	}
+
+	return verdict, nil
+}
+
+// matchBlocklist returns the closest blocklist distance and whether it is within the policy
+func (p *LocalModerationProvider) matchBlocklist(hash uint64) (int, bool) {
+	best := 65
+	for _, blocked := range p.blocklist {
+		if d := HammingDistance(hash, blocked); d < best {
+			best = d
+		}
+	}
+	return best, best <= p.policy.HashMaxDistance
+}
+
+// LoadHashBlocklist reads perceptual hashes from a file, one 64-bit hex hash per line.
+// Blank lines and lines starting with # are ignored.
+func LoadHashBlocklist(path string) ([]uint64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var hashes []uint64
+	scanner := bufio.NewScanner(file)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		hash, err := strconv.ParseUint(strings.TrimPrefix(line, "0x"), 16, 64)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid hash %q", lineNo, line)
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes, scanner.Err()
+}
+
+// DHash computes a 64-bit difference hash of an image. The image is reduced
+// to 9x8 grayscale and each bit records whether a pixel is brighter than its
+// right-hand neighbour, so near-identical images produce near-identical hashes.
+func DHash(img image.Image) uint64 {
+	const width, height = 9, 8
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+
+	var gray [height][width]float64
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			// Average the block of source pixels covered by this cell
+			x0 := bounds.Min.X + x*w/width
+			x1 := bounds.Min.X + (x+1)*w/width
+			y0 := bounds.Min.Y + y*h/height
+			y1 := bounds.Min.Y + (y+1)*h/height
+			if x1 <= x0 {
+				x1 = x0 + 1
+			}
+			if y1 <= y0 {
+				y1 = y0 + 1
+			}
+
+			var sum float64
+			var count int
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					r, g, b, _ := img.At(sx, sy).RGBA()
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+					count++
+				}
+			}
+			gray[y][x] = sum / float64(count)
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < height; y++ {
+		for x := 0; x < width-1; x++ {
+			hash <<= 1
+			if gray[y][x] > gray[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// HammingDistance returns the number of differing bits between two hashes
+func HammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// MatchesSignature checks a file header against the magic bytes expected for
+// the declared content type. Returns false and a description on mismatch.
+// Unknown content types are accepted.
+func MatchesSignature(contentType string, header []byte) (bool, string) {
+	ct := strings.ToLower(contentType)
+	hasPrefix := func(sig string) bool { return bytes.HasPrefix(header, []byte(sig)) }
+	riff := func(form string) bool {
+		return len(header) >= 12 && hasPrefix("RIFF") && string(header[8:12]) == form
+	}
+	ftyp := func() bool { return len(header) >= 8 && string(header[4:8]) == "ftyp" }
+
+	var ok bool
+	switch ct {
+	case "image/jpeg":
+		ok = hasPrefix("\xFF\xD8\xFF")
+	case "image/png":
+		ok = hasPrefix("\x89PNG\r\n\x1a\n")
+	case "image/gif":
+		ok = hasPrefix("GIF87a") || hasPrefix("GIF89a")
+	case "image/webp":
+		ok = riff("WEBP")
+	case "video/mp4", "video/quicktime", "audio/mp4":
+		ok = ftyp() || (ct == "video/quicktime" && len(header) >= 8 &&
+			(string(header[4:8]) == "moov" || string(header[4:8]) == "mdat" || string(header[4:8]) == "wide"))
+	case "video/webm", "audio/webm":
+		ok = hasPrefix("\x1A\x45\xDF\xA3")
+	case "video/x-msvideo":
+		ok = riff("AVI ")
+	case "audio/mpeg":
+		ok = hasPrefix("ID3") || (len(header) >= 2 && header[0] == 0xFF && header[1]&0xE0 == 0xE0)
+	case "audio/aac":
+		ok = hasPrefix("ID3") || (len(header) >= 2 && header[0] == 0xFF && header[1]&0xF6 == 0xF0)
+	case "audio/ogg":
+		ok = hasPrefix("OggS")
+	case "audio/wav", "audio/x-wav":
+		ok = riff("WAVE")
+	case "application/pdf":
+		ok = hasPrefix("%PDF-")
+	case "application/msword", "application/vnd.ms-excel":
+		ok = hasPrefix("\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1")
+	case "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+		"application/vnd.openxmlformats-officedocument.spreadsheetml.sheet":
+		ok = hasPrefix("PK\x03\x04")
+	case "text/plain":
+		if hasPrefix("MZ") || hasPrefix("\x7FELF") {
+			return false, "executable disguised as text"
+		}
+		if bytes.IndexByte(header, 0) >= 0 {
+			return false, "binary content in text file"
+		}
+		return true, ""
+	default:
+		return true, ""
+	}
+
+	if !ok {
+		return false, fmt.Sprintf("header %x does not match %s", header[:min(len(header), 8)], ct)
+	}
+	return true, ""
+}
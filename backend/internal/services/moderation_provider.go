@@ -0,0 +1,129 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"messenger/internal/models"
+)
+
+// ModerationProvider defines the interface for content moderation backends
+// Implement this interface to add support for different scanners
+type ModerationProvider interface {
+	// Name returns the provider name (e.g., "vision", "local")
+	Name() string
+
+	// IsEnabled returns whether this provider is properly configured
+	IsEnabled() bool
+
+	// Supports returns whether this provider can scan the given media type
+	Supports(mediaType models.MediaType) bool
+
+	// Scan inspects a file and returns a risk score between 0 (safe) and 1 (certainly violating)
+	// The moderation pipeline turns the score into a decision using the provider's thresholds
+	Scan(ctx context.Context, input *ModerationInput) (*ProviderVerdict, error)
+}
+
+// ModerationInput describes a file to be scanned
+type ModerationInput struct {
+	FilePath    string
+	ContentType string // Declared by the uploader
+	MediaType   models.MediaType
+	Size        int64
+}
+
+// ProviderVerdict is the result of a single provider's scan
+type ProviderVerdict struct {
+	Score   float64           // 0.0 = safe, 1.0 = certainly violating
+	Reasons []string          // Human readable explanation of the score
+	Details map[string]string // Provider specific data stored with the scan result
+	// Likelihood labels (only set by providers that classify content)
+	Adult    string
+	Violence string
+	Racy     string
+}
+
+// ModerationThresholds turns a provider score into a moderation decision
+type ModerationThresholds struct {
+	Review float64 // Scores at or above this go to human review
+	Reject float64 // Scores at or above this are rejected outright
+}
+
+// DefaultModerationThresholds matches the original Vision decision logic:
+// POSSIBLE goes to review, LIKELY and above are rejected
+var DefaultModerationThresholds = ModerationThresholds{
+	Review: 0.5,
+	Reject: 0.75,
+}
+
+// Decide maps a score to a media status
+func (t ModerationThresholds) Decide(score float64) models.MediaStatus {
+	switch {
+	case score >= t.Reject:
+		return models.MediaStatusRejected
+	case score >= t.Review:
+		return models.MediaStatusReview
+	default:
+		return models.MediaStatusApproved
+	}
+}
+
+// Validate checks that the thresholds are in range and ordered
+func (t ModerationThresholds) Validate() error {
+	if t.Review < 0 || t.Review > 1 || t.Reject < 0 || t.Reject > 1 {
+		return fmt.Errorf("thresholds must be between 0 and 1")
+	}
+	if t.Review > t.Reject {
+		return fmt.Errorf("review threshold (%.2f) must not exceed reject threshold (%.2f)", t.Review, t.Reject)
+	}
+	return nil
+}
+
+// LoadModerationThresholds reads thresholds for a provider from the environment.
+// MODERATION_<NAME>_REVIEW_THRESHOLD and MODERATION_<NAME>_REJECT_THRESHOLD override
+// the global MODERATION_REVIEW_THRESHOLD and MODERATION_REJECT_THRESHOLD.
+func LoadModerationThresholds(providerName string) ModerationThresholds {
+	prefix := "MODERATION_" + strings.ToUpper(providerName) + "_"
+
+	t := DefaultModerationThresholds
+	t.Review = floatFromEnv("MODERATION_REVIEW_THRESHOLD", t.Review)
+	t.Reject = floatFromEnv("MODERATION_REJECT_THRESHOLD", t.Reject)
+	t.Review = floatFromEnv(prefix+"REVIEW_THRESHOLD", t.Review)
+	t.Reject = floatFromEnv(prefix+"REJECT_THRESHOLD", t.Reject)
+
+	if err := t.Validate(); err != nil {
+		log.Printf("Warning: invalid moderation thresholds for %s (%v), using defaults", providerName, err)
+		return DefaultModerationThresholds
+	}
+	return t
+}
+
+func floatFromEnv(key string, defaultValue float64) float64 {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	f, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		log.Printf("Warning: invalid %s=%q, using %.2f", key, value, defaultValue)
+		return defaultValue
+	}
+	return f
+}
+
+func intFromEnv(key string, defaultValue int) int {
+	value := os.Getenv(key)
+	if value == "" {
+		return defaultValue
+	}
+	i, err := strconv.Atoi(value)
+	if err != nil {
+		log.Printf("Warning: invalid %s=%q, using %d", key, value, defaultValue)
+		return defaultValue
+	}
+	return i
+}
@@ -0,0 +1,647 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"messenger/internal/models"
+)
+
+// StubModerationProvider implements ModerationProvider for testing
+type StubModerationProvider struct {
+	name       string
+	score      float64
+	pathScores map[string]float64 // Overrides score for specific files
+	scanError  error
+	supports   []models.MediaType
+	scanned    int
+}
+
+func (s *StubModerationProvider) Name() string {
+	return s.name
+}
+
+func (s *StubModerationProvider) IsEnabled() bool {
+	return true
+}
+
+func (s *StubModerationProvider) Supports(mediaType models.MediaType) bool {
+	if len(s.supports) == 0 {
+		return true
+	}
+	for _, t := range s.supports {
+		if t == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *StubModerationProvider) Scan(ctx context.Context, input *ModerationInput) (*ProviderVerdict, error) {
+	s.scanned++
+	if s.scanError != nil {
+		return nil, s.scanError
+	}
+	score := s.score
+	if override, ok := s.pathScores[input.FilePath]; ok {
+		score = override
+	}
+	return &ProviderVerdict{Score: score, Reasons: []string{s.name + " reason"}}, nil
+}
+
+func newTestModerationService(providers ...*StubModerationProvider) *ModerationService {
+	s := &ModerationService{}
+	for _, p := range providers {
+		s.AddProvider(p, DefaultModerationThresholds)
+	}
+	return s
+}
+
+func TestModerationThresholds_Decide(t *testing.T) {
+	thresholds := ModerationThresholds{Review: 0.4, Reject: 0.8}
+
+	tests := []struct {
+		score    float64
+		expected models.MediaStatus
+	}{
+		{0, models.MediaStatusApproved},
+		{0.39, models.MediaStatusApproved},
+		{0.4, models.MediaStatusReview},
+		{0.79, models.MediaStatusReview},
+		{0.8, models.MediaStatusRejected},
+		{1.0, models.MediaStatusRejected},
+	}
+
+	for _, tt := range tests {
+		if got := thresholds.Decide(tt.score); got != tt.expected {
+			t.Errorf("Decide(%.2f) = %s, expected %s", tt.score, got, tt.expected)
+		}
+	}
+}
+
+func TestModerationThresholds_Validate(t *testing.T) {
+	if err := DefaultModerationThresholds.Validate(); err != nil {
+		t.Errorf("Default thresholds should be valid: %v", err)
+	}
+	if err := (ModerationThresholds{Review: 0.9, Reject: 0.5}).Validate(); err == nil {
+		t.Error("Review above reject should be invalid")
+	}
+	if err := (ModerationThresholds{Review: -0.1, Reject: 0.5}).Validate(); err == nil {
+		t.Error("Negative threshold should be invalid")
+	}
+}
+
+func TestLoadModerationThresholds(t *testing.T) {
+	t.Setenv("MODERATION_REVIEW_THRESHOLD", "0.3")
+	t.Setenv("MODERATION_REJECT_THRESHOLD", "0.9")
+	t.Setenv("MODERATION_LOCAL_REJECT_THRESHOLD", "0.6")
+
+	global := LoadModerationThresholds("vision")
+	if global.Review != 0.3 || global.Reject != 0.9 {
+		t.Errorf("Expected global thresholds 0.3/0.9, got %.2f/%.2f", global.Review, global.Reject)
+	}
+
+	local := LoadModerationThresholds("local")
+	if local.Review != 0.3 || local.Reject != 0.6 {
+		t.Errorf("Expected local thresholds 0.3/0.6, got %.2f/%.2f", local.Review, local.Reject)
+	}
+
+	t.Setenv("MODERATION_LOCAL_REVIEW_THRESHOLD", "0.95")
+	if got := LoadModerationThresholds("local"); got != DefaultModerationThresholds {
+		t.Errorf("Invalid thresholds should fall back to defaults, got %+v", got)
+	}
+}
+
+func TestScanMedia_Chain(t *testing.T) {
+	input := &ModerationInput{FilePath: "test.jpg", MediaType: models.MediaTypeImage}
+
+	t.Run("all approve", func(t *testing.T) {
+		s := newTestModerationService(
+			&StubModerationProvider{name: "a", score: 0.1},
+			&StubModerationProvider{name: "b", score: 0.2},
+		)
+		result, err := s.ScanMedia(context.Background(), input)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if result.Status != models.MediaStatusApproved {
+			t.Errorf("Expected approved, got %s", result.Status)
+		}
+	})
+
+	t.Run("most severe wins", func(t *testing.T) {
+		s := newTestModerationService(
+			&StubModerationProvider{name: "a", score: 0.6},
+			&StubModerationProvider{name: "b", score: 0.1},
+		)
+		result, _ := s.ScanMedia(context.Background(), input)
+		if result.Status != models.MediaStatusReview {
+			t.Errorf("Expected review, got %s", result.Status)
+		}
+		if result.Provider != "a" {
+			t.Errorf("Expected deciding provider 'a', got '%s'", result.Provider)
+		}
+	})
+
+	t.Run("reject stops chain", func(t *testing.T) {
+		second := &StubModerationProvider{name: "b", score: 0}
+		s := newTestModerationService(&StubModerationProvider{name: "a", score: 1.0}, second)
+		result, _ := s.ScanMedia(context.Background(), input)
+		if result.Status != models.MediaStatusRejected {
+			t.Errorf("Expected rejected, got %s", result.Status)
+		}
+		if second.scanned != 0 {
+			t.Error("Providers after a rejection should not run")
+		}
+	})
+
+	t.Run("error goes to review", func(t *testing.T) {
+		s := newTestModerationService(
+			&StubModerationProvider{name: "a", scanError: errors.New("timeout")},
+			&StubModerationProvider{name: "b", score: 0},
+		)
+		result, _ := s.ScanMedia(context.Background(), input)
+		if result.Status != models.MediaStatusReview {
+			t.Errorf("Expected review, got %s", result.Status)
+		}
+	})
+
+	t.Run("error does not override rejection", func(t *testing.T) {
+		s := newTestModerationService(
+			&StubModerationProvider{name: "a", scanError: errors.New("timeout")},
+			&StubModerationProvider{name: "b", score: 0.9},
+		)
+		result, _ := s.ScanMedia(context.Background(), input)
+		if result.Status != models.MediaStatusRejected {
+			t.Errorf("Expected rejected, got %s", result.Status)
+		}
+	})
+
+	t.Run("skips unsupported providers", func(t *testing.T) {
+		videoOnly := &StubModerationProvider{name: "video", score: 1.0, supports: []models.MediaType{models.MediaTypeVideo}}
+		s := newTestModerationService(videoOnly, &StubModerationProvider{name: "a", score: 0})
+		result, _ := s.ScanMedia(context.Background(), input)
+		if result.Status != models.MediaStatusApproved || videoOnly.scanned != 0 {
+			t.Errorf("Unsupported provider should be skipped, got %s", result.Status)
+		}
+	})
+
+	t.Run("no supporting provider", func(t *testing.T) {
+		s := newTestModerationService(&StubModerationProvider{name: "video", supports: []models.MediaType{models.MediaTypeVideo}})
+		if _, err := s.ScanMedia(context.Background(), input); err == nil {
+			t.Error("Expected error when no provider supports the media type")
+		}
+	})
+
+	t.Run("raw result lists providers", func(t *testing.T) {
+		s := newTestModerationService(
+			&StubModerationProvider{name: "a", score: 0.1},
+			&StubModerationProvider{name: "b", score: 0.2},
+		)
+		result, _ := s.ScanMedia(context.Background(), input)
+
+		var raw struct {
+			Providers []providerScan `json:"providers"`
+		}
+		if err := json.Unmarshal([]byte(result.RawResult), &raw); err != nil {
+			t.Fatalf("RawResult should be JSON: %v", err)
+		}
+		if len(raw.Providers) != 2 {
+			t.Errorf("Expected 2 provider entries, got %d", len(raw.Providers))
+		}
+	})
+}
+
+func TestScanVideoFrames(t *testing.T) {
+	input := &ModerationInput{FilePath: "video.mp4", ContentType: "video/mp4", MediaType: models.MediaTypeVideo}
+	frames := []VideoFrame{
+		{Path: "f0.jpg", Timestamp: 1.5},
+		{Path: "f1.jpg", Timestamp: 4.5},
+		{Path: "f2.jpg", Timestamp: 7.5},
+		{Path: "f3.jpg", Timestamp: 10.5},
+	}
+
+	scan := func(pathScores map[string]float64) *ScanResult {
+		t.Helper()
+		s := newTestModerationService(&StubModerationProvider{name: "a", pathScores: pathScores})
+		result, err := s.ScanVideoFrames(context.Background(), input, frames)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return result
+	}
+
+	t.Run("all frames clean", func(t *testing.T) {
+		result := scan(nil)
+		if result.Status != models.MediaStatusApproved {
+			t.Errorf("Expected approved, got %s", result.Status)
+		}
+		if len(result.FlaggedFrames) != 0 {
+			t.Errorf("Expected no flagged frames, got %d", len(result.FlaggedFrames))
+		}
+	})
+
+	t.Run("ambiguous frame goes to review", func(t *testing.T) {
+		result := scan(map[string]float64{"f2.jpg": 0.6})
+		if result.Status != models.MediaStatusReview {
+			t.Errorf("Expected review, got %s", result.Status)
+		}
+		if len(result.FlaggedFrames) != 1 || result.FlaggedFrames[0].Timestamp != 7.5 {
+			t.Errorf("Expected frame at 7.5s to be flagged, got %+v", result.FlaggedFrames)
+		}
+	})
+
+	t.Run("single rejected frame goes to review", func(t *testing.T) {
+		result := scan(map[string]float64{"f1.jpg": 1.0})
+		if result.Status != models.MediaStatusReview {
+			t.Errorf("Expected review, got %s", result.Status)
+		}
+	})
+
+	t.Run("multiple rejected frames reject video", func(t *testing.T) {
+		result := scan(map[string]float64{"f1.jpg": 1.0, "f3.jpg": 0.9})
+		if result.Status != models.MediaStatusRejected {
+			t.Errorf("Expected rejected, got %s", result.Status)
+		}
+		if len(result.FlaggedFrames) != 2 {
+			t.Errorf("Expected 2 flagged frames, got %d", len(result.FlaggedFrames))
+		}
+	})
+
+	t.Run("rejected video file skips frames", func(t *testing.T) {
+		result := scan(map[string]float64{"video.mp4": 1.0})
+		if result.Status != models.MediaStatusRejected {
+			t.Errorf("Expected rejected, got %s", result.Status)
+		}
+	})
+
+	t.Run("no frames goes to review", func(t *testing.T) {
+		s := newTestModerationService(&StubModerationProvider{name: "a"})
+		result, err := s.ScanVideoFrames(context.Background(), input, nil)
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if result.Status != models.MediaStatusReview {
+			t.Errorf("Expected review, got %s", result.Status)
+		}
+	})
+}
+
+func writeTestFile(t *testing.T, name string, data []byte) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatalf("Failed to write test file: %v", err)
+	}
+	return path
+}
+
+// gradientImage returns an image whose dHash is stable and non-trivial
+func gradientImage(w, h int, invert bool) *image.Gray {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := uint8((x * 255) / w)
+			if invert {
+				v = 255 - v
+			}
+			img.SetGray(x, y, color.Gray{Y: v})
+		}
+	}
+	return img
+}
+
+func writeTestPNG(t *testing.T, img image.Image) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "test.png")
+	file, err := os.Create(path)
+	if err != nil {
+		t.Fatalf("Failed to create test file: %v", err)
+	}
+	defer file.Close()
+	if err := png.Encode(file, img); err != nil {
+		t.Fatalf("Failed to encode PNG: %v", err)
+	}
+	return path
+}
+
+func defaultLocalPolicy() LocalModerationPolicy {
+	return LocalModerationPolicy{
+		MaxImageWidth:   DefaultMaxImageWidth,
+		MaxImageHeight:  DefaultMaxImageHeight,
+		MaxImagePixels:  DefaultMaxImagePixels,
+		HashMaxDistance: DefaultHashMaxDistance,
+	}
+}
+
+func TestMatchesSignature(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		header      string
+		expected    bool
+	}{
+		{"jpeg", "image/jpeg", "\xFF\xD8\xFF\xE0rest", true},
+		{"png as jpeg", "image/jpeg", "\x89PNG\r\n\x1a\n", false},
+		{"gif", "image/gif", "GIF89a....", true},
+		{"webp", "image/webp", "RIFF\x00\x00\x00\x00WEBPVP8 ", true},
+		{"wav as webp", "image/webp", "RIFF\x00\x00\x00\x00WAVEfmt ", false},
+		{"mp4", "video/mp4", "\x00\x00\x00\x18ftypmp42", true},
+		{"pdf", "application/pdf", "%PDF-1.7", true},
+		{"exe as pdf", "application/pdf", "MZ\x90\x00", false},
+		{"docx", "application/vnd.openxmlformats-officedocument.wordprocessingml.document", "PK\x03\x04", true},
+		{"doc", "application/msword", "\xD0\xCF\x11\xE0\xA1\xB1\x1A\xE1", true},
+		{"mp3 id3", "audio/mpeg", "ID3\x04", true},
+		{"mp3 frame", "audio/mpeg", "\xFF\xFB\x90", true},
+		{"text", "text/plain", "hello world", true},
+		{"exe as text", "text/plain", "MZ\x90\x00", false},
+		{"binary as text", "text/plain", "abc\x00def", false},
+		{"unknown type", "application/octet-stream", "anything", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := MatchesSignature(tt.contentType, []byte(tt.header))
+			if got != tt.expected {
+				t.Errorf("MatchesSignature(%s) = %v, expected %v", tt.contentType, got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestDHash(t *testing.T) {
+	a := DHash(gradientImage(64, 64, false))
+	b := DHash(gradientImage(128, 96, false))
+	c := DHash(gradientImage(64, 64, true))
+
+	if d := HammingDistance(a, b); d > 4 {
+		t.Errorf("Resized image should hash similarly, distance %d", d)
+	}
+	if d := HammingDistance(a, c); d < 32 {
+		t.Errorf("Inverted image should hash differently, distance %d", d)
+	}
+}
+
+func TestLoadHashBlocklist(t *testing.T) {
+	path := writeTestFile(t, "blocklist.txt", []byte("# known bad\n00ff00ff00ff00ff\n\n0x0123456789abcdef\n"))
+
+	hashes, err := LoadHashBlocklist(path)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if len(hashes) != 2 || hashes[0] != 0x00ff00ff00ff00ff || hashes[1] != 0x0123456789abcdef {
+		t.Errorf("Unexpected hashes: %x", hashes)
+	}
+
+	bad := writeTestFile(t, "bad.txt", []byte("not-a-hash\n"))
+	if _, err := LoadHashBlocklist(bad); err == nil {
+		t.Error("Expected error for invalid hash")
+	}
+}
+
+func TestLocalModerationProvider_Scan(t *testing.T) {
+	ctx := context.Background()
+	img := gradientImage(64, 64, false)
+	pngPath := writeTestPNG(t, img)
+
+	t.Run("clean image", func(t *testing.T) {
+		p := NewLocalModerationProviderWithPolicy(defaultLocalPolicy(), nil)
+		verdict, err := p.Scan(ctx, &ModerationInput{FilePath: pngPath, ContentType: "image/png", MediaType: models.MediaTypeImage})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if verdict.Score != 0 {
+			t.Errorf("Expected score 0, got %.2f (%v)", verdict.Score, verdict.Reasons)
+		}
+	})
+
+	t.Run("signature mismatch", func(t *testing.T) {
+		p := NewLocalModerationProviderWithPolicy(defaultLocalPolicy(), nil)
+		verdict, _ := p.Scan(ctx, &ModerationInput{FilePath: pngPath, ContentType: "image/jpeg", MediaType: models.MediaTypeImage})
+		if verdict.Score != 1.0 {
+			t.Errorf("Expected score 1.0, got %.2f", verdict.Score)
+		}
+	})
+
+	t.Run("blocklisted hash", func(t *testing.T) {
+		p := NewLocalModerationProviderWithPolicy(defaultLocalPolicy(), []uint64{DHash(img) ^ 0x7})
+		verdict, _ := p.Scan(ctx, &ModerationInput{FilePath: pngPath, ContentType: "image/png", MediaType: models.MediaTypeImage})
+		if verdict.Score != 1.0 {
+			t.Errorf("Expected near-duplicate to be blocked, got %.2f", verdict.Score)
+		}
+	})
+
+	t.Run("hash outside distance", func(t *testing.T) {
+		p := NewLocalModerationProviderWithPolicy(defaultLocalPolicy(), []uint64{^DHash(img)})
+		verdict, _ := p.Scan(ctx, &ModerationInput{FilePath: pngPath, ContentType: "image/png", MediaType: models.MediaTypeImage})
+		if verdict.Score != 0 {
+			t.Errorf("Expected unrelated hash not to match, got %.2f", verdict.Score)
+		}
+	})
+
+	t.Run("dimension policy", func(t *testing.T) {
+		policy := defaultLocalPolicy()
+		policy.MaxImageWidth = 32
+		p := NewLocalModerationProviderWithPolicy(policy, nil)
+		verdict, _ := p.Scan(ctx, &ModerationInput{FilePath: pngPath, ContentType: "image/png", MediaType: models.MediaTypeImage})
+		if verdict.Score != 1.0 {
+			t.Errorf("Expected oversized image to be rejected, got %.2f", verdict.Score)
+		}
+	})
+
+	t.Run("undecodable image", func(t *testing.T) {
+		path := writeTestFile(t, "broken.png", []byte("\x89PNG\r\n\x1a\ngarbage"))
+		p := NewLocalModerationProviderWithPolicy(defaultLocalPolicy(), nil)
+		verdict, _ := p.Scan(ctx, &ModerationInput{FilePath: path, ContentType: "image/png", MediaType: models.MediaTypeImage})
+		if verdict.Score != 0.5 {
+			t.Errorf("Expected undecodable image to go to review, got %.2f", verdict.Score)
+		}
+	})
+
+	t.Run("document signature", func(t *testing.T) {
+		path := writeTestFile(t, "report.pdf", []byte("MZ\x90\x00this is an exe"))
+		p := NewLocalModerationProviderWithPolicy(defaultLocalPolicy(), nil)
+		verdict, _ := p.Scan(ctx, &ModerationInput{FilePath: path, ContentType: "application/pdf", MediaType: models.MediaTypeDocument})
+		if verdict.Score != 1.0 {
+			t.Errorf("Expected disguised executable to be rejected, got %.2f", verdict.Score)
+		}
+	})
+}
+
+// startFakeClamd runs a minimal clamd stand-in that answers PING and
+// INSTREAM, reporting any stream containing "EICAR" as infected
+func startFakeClamd(t *testing.T, network, address string) string {
+	t.Helper()
+	listener, err := net.Listen(network, address)
+	if err != nil {
+		t.Fatalf("Failed to start fake clamd: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go handleFakeClamd(conn)
+		}
+	}()
+
+	return listener.Addr().String()
+}
+
+func handleFakeClamd(conn net.Conn) {
+	defer conn.Close()
+	reader := bufio.NewReader(conn)
+
+	command, err := reader.ReadString(0)
+	if err != nil {
+		return
+	}
+
+	switch strings.TrimSuffix(command, "\x00") {
+	case "zPING":
+		conn.Write([]byte("PONG\x00"))
+	case "zINSTREAM":
+		var data bytes.Buffer
+		size := make([]byte, 4)
+		for {
+			if _, err := io.ReadFull(reader, size); err != nil {
+				return
+			}
+			n := binary.BigEndian.Uint32(size)
+			if n == 0 {
+				break
+			}
+			if _, err := io.CopyN(&data, reader, int64(n)); err != nil {
+				return
+			}
+		}
+		if bytes.Contains(data.Bytes(), []byte("EICAR")) {
+			conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+		} else {
+			conn.Write([]byte("stream: OK\x00"))
+		}
+	default:
+		conn.Write([]byte("UNKNOWN COMMAND\x00"))
+	}
+}
+
+func TestClamAVModerationProvider(t *testing.T) {
+	ctx := context.Background()
+	clean := writeTestFile(t, "clean.pdf", append([]byte("%PDF-1.7\n"), bytes.Repeat([]byte("a"), 200*1024)...))
+	infected := writeTestFile(t, "infected.pdf", []byte("%PDF-1.7\nX5O!P%@AP[4\\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*"))
+
+	t.Run("tcp", func(t *testing.T) {
+		address := startFakeClamd(t, "tcp", "127.0.0.1:0")
+		p := NewClamAVModerationProviderWithAddress("tcp", address, 5*time.Second, models.MediaTypeDocument)
+
+		if err := p.Ping(ctx); err != nil {
+			t.Fatalf("Ping failed: %v", err)
+		}
+
+		verdict, err := p.Scan(ctx, &ModerationInput{FilePath: clean, MediaType: models.MediaTypeDocument})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if verdict.Score != 0 {
+			t.Errorf("Expected clean file to score 0, got %.2f", verdict.Score)
+		}
+
+		verdict, err = p.Scan(ctx, &ModerationInput{FilePath: infected, MediaType: models.MediaTypeDocument})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if verdict.Score != 1.0 || verdict.Details["signature"] != "Eicar-Test-Signature" {
+			t.Errorf("Expected infected file to be flagged, got %.2f %v", verdict.Score, verdict.Details)
+		}
+	})
+
+	t.Run("unix socket", func(t *testing.T) {
+		socket := filepath.Join(t.TempDir(), "clamd.sock")
+		startFakeClamd(t, "unix", socket)
+		p := NewClamAVModerationProviderWithAddress("unix", socket, 5*time.Second, models.MediaTypeDocument)
+
+		verdict, err := p.Scan(ctx, &ModerationInput{FilePath: infected, MediaType: models.MediaTypeDocument})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if verdict.Score != 1.0 {
+			t.Errorf("Expected infected file to be flagged, got %.2f", verdict.Score)
+		}
+	})
+
+	t.Run("infected document rejected by chain", func(t *testing.T) {
+		address := startFakeClamd(t, "tcp", "127.0.0.1:0")
+		s := &ModerationService{}
+		s.AddProvider(NewClamAVModerationProviderWithAddress("tcp", address, 5*time.Second, models.MediaTypeDocument), DefaultModerationThresholds)
+
+		result, err := s.ScanMedia(ctx, &ModerationInput{FilePath: infected, MediaType: models.MediaTypeDocument})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if result.Status != models.MediaStatusRejected {
+			t.Errorf("Expected rejected, got %s", result.Status)
+		}
+	})
+
+	t.Run("outage goes to review", func(t *testing.T) {
+		listener, _ := net.Listen("tcp", "127.0.0.1:0")
+		address := listener.Addr().String()
+		listener.Close()
+
+		s := &ModerationService{}
+		s.AddProvider(NewClamAVModerationProviderWithAddress("tcp", address, time.Second, models.MediaTypeDocument), DefaultModerationThresholds)
+
+		result, err := s.ScanMedia(ctx, &ModerationInput{FilePath: clean, MediaType: models.MediaTypeDocument})
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if result.Status != models.MediaStatusReview {
+			t.Errorf("Expected review when clamd is down, got %s", result.Status)
+		}
+	})
+
+	t.Run("only configured media types", func(t *testing.T) {
+		p := NewClamAVModerationProviderWithAddress("tcp", "127.0.0.1:3310", time.Second, models.MediaTypeDocument)
+		if p.Supports(models.MediaTypeImage) || !p.Supports(models.MediaTypeDocument) {
+			t.Error("Provider should only support configured media types")
+		}
+	})
+}
+
+func TestParseClamAVAddress(t *testing.T) {
+	tests := []struct {
+		value   string
+		network string
+		address string
+	}{
+		{"tcp://clamav:3310", "tcp", "clamav:3310"},
+		{"unix:///var/run/clamd.sock", "unix", "/var/run/clamd.sock"},
+		{"/var/run/clamd.sock", "unix", "/var/run/clamd.sock"},
+		{"localhost:3310", "tcp", "localhost:3310"},
+		{"", "", ""},
+	}
+
+	for _, tt := range tests {
+		network, address := parseClamAVAddress(tt.value)
+		if network != tt.network || address != tt.address {
+			t.Errorf("parseClamAVAddress(%q) = %s %s, expected %s %s", tt.value, network, address, tt.network, tt.address)
+		}
+	}
+}
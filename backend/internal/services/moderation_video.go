@@ -0,0 +1,127 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+
+	"messenger/internal/models"
+)
+
+// DefaultVideoRejectFrames is how many frames must be rejected before a
+// video is rejected outright. Fewer rejected frames send it to review.
+const DefaultVideoRejectFrames = 2
+
+// FrameFlag records a sampled video frame that was not approved
+type FrameFlag struct {
+	Timestamp float64            `json:"timestamp"` // Seconds into the video
+	Status    models.MediaStatus `json:"status"`
+	Provider  string             `json:"provider,omitempty"`
+	Score     float64            `json:"score"`
+	Reasons   []string           `json:"reasons,omitempty"`
+}
+
+// ScanVideoFrames moderates a video from frames sampled out of it. The video
+// file itself is scanned first (e.g. signature checks), then every frame is
+// run through the image provider chain and the results are aggregated:
+//   - the video is rejected when enough frames are rejected
+//   - any other flagged or unscannable frame sends it to review
+//   - it is approved only when every frame is approved
+func (s *ModerationService) ScanVideoFrames(ctx context.Context, input *ModerationInput, frames []VideoFrame) (*ScanResult, error) {
+	fileResult, err := s.ScanMedia(ctx, input)
+	if err != nil {
+		return nil, err
+	}
+	if fileResult.Status == models.MediaStatusRejected {
+		return fileResult, nil
+	}
+
+	result := &ScanResult{
+		Status:   fileResult.Status,
+		Provider: fileResult.Provider,
+		Score:    fileResult.Score,
+		Reasons:  fileResult.Reasons,
+	}
+
+	if len(frames) == 0 {
+		result.Status = models.MediaStatusReview
+		result.Reasons = append(result.Reasons, "no frames could be sampled from video")
+	}
+
+	rejectedFrames := 0
+	for _, frame := range frames {
+		frameResult, err := s.ScanMedia(ctx, &ModerationInput{
+			FilePath:    frame.Path,
+			ContentType: "image/jpeg",
+			MediaType:   models.MediaTypeImage,
+		})
+
+		flag := FrameFlag{Timestamp: frame.Timestamp}
+		if err != nil {
+			flag.Status = models.MediaStatusReview
+			flag.Reasons = []string{err.Error()}
+		} else {
+			if frameResult.Status == models.MediaStatusApproved {
+				continue
+			}
+			flag.Status = frameResult.Status
+			flag.Provider = frameResult.Provider
+			flag.Score = frameResult.Score
+			flag.Reasons = frameResult.Reasons
+			if frameResult.Adult != "" {
+				result.Adult = frameResult.Adult
+				result.Violence = frameResult.Violence
+				result.Racy = frameResult.Racy
+			}
+		}
+
+		result.FlaggedFrames = append(result.FlaggedFrames, flag)
+		if flag.Status == models.MediaStatusRejected {
+			rejectedFrames++
+		}
+		if flag.Score > result.Score {
+			result.Score = flag.Score
+			result.Provider = flag.Provider
+		}
+	}
+
+	required := s.videoRejectFrames
+	if required <= 0 {
+		required = DefaultVideoRejectFrames
+	}
+	if required > len(frames) {
+		required = len(frames)
+	}
+
+	switch {
+	case len(frames) > 0 && rejectedFrames >= required:
+		result.Status = models.MediaStatusRejected
+		result.Reasons = append(result.Reasons, fmt.Sprintf("%d of %d sampled frames rejected", rejectedFrames, len(frames)))
+		log.Printf("Blocking video %s: %d of %d frames rejected", input.FilePath, rejectedFrames, len(frames))
+	case len(result.FlaggedFrames) > 0:
+		result.Status = models.MediaStatusReview
+		result.Reasons = append(result.Reasons, fmt.Sprintf("%d of %d sampled frames flagged at %s",
+			len(result.FlaggedFrames), len(frames), formatFrameTimestamps(result.FlaggedFrames)))
+		log.Printf("Sending video to review: %s (%s)", input.FilePath, strings.Join(result.Reasons, "; "))
+	}
+
+	rawBytes, _ := json.Marshal(map[string]interface{}{
+		"status":         result.Status,
+		"file":           json.RawMessage(fileResult.RawResult),
+		"frames_scanned": len(frames),
+		"flagged_frames": result.FlaggedFrames,
+	})
+	result.RawResult = string(rawBytes)
+
+	return result, nil
+}
+
+func formatFrameTimestamps(flags []FrameFlag) string {
+	parts := make([]string, len(flags))
+	for i, f := range flags {
+		parts[i] = fmt.Sprintf("%.1fs", f.Timestamp)
+	}
+	return strings.Join(parts, ", ")
+}
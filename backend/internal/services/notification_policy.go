@@ -0,0 +1,172 @@
+package services
+
+import (
+	"errors"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"messenger/internal/database"
+	"messenger/internal/models"
+)
+
+// Reasons the notification policy suppresses a message push
+const (
+	PushSuppressedBlocked      = "blocked"
+	PushSuppressedMuted        = "muted"
+	PushSuppressedNotMentioned = "not_mentioned"
+	PushSuppressedDoNotDisturb = "do_not_disturb"
+)
+
+// HiddenPreviewBody replaces the message text for users who hide previews
+const HiddenPreviewBody = "New message"
+
+// LocationPushBody is the push text for a shared location
+const LocationPushBody = "📍 Shared a location"
+
+var (
+	ErrInvalidTimezone = errors.New("invalid timezone")
+	ErrInvalidDNDTime  = errors.New("do-not-disturb start and end must be HH:MM")
+)
+
+// MessagePush describes a message a recipient could be notified about
+type MessagePush struct {
+	RecipientID string
+	SenderID    string
+	GroupID     string // Empty for DMs
+	Content     string
+	Mentioned   bool // Already known to mention the recipient
+}
+
+// PushDecision is the notification policy's verdict on one message push
+type PushDecision struct {
+	Send        bool
+	Reason      string // Why the push was suppressed
+	HidePreview bool   // Send without the message text
+	Private     bool   // Send content-free, see SendPrivateToUser
+}
+
+// EvaluateMessagePush decides whether a message push should reach the
+// recipient. Checks run in order: blocked sender, muted conversation,
+// mentions-only conversation, then the recipient's do-not-disturb window.
+func EvaluateMessagePush(push MessagePush, now time.Time) PushDecision {
+	if models.IsBlocked(database.DB, push.RecipientID, push.SenderID) {
+		return PushDecision{Reason: PushSuppressedBlocked}
+	}
+
+	var settings *models.ConversationSettings
+	if push.GroupID != "" {
+		settings = models.FindConversationSettings(database.DB, push.RecipientID, nil, &push.GroupID)
+	} else {
+		settings = models.FindConversationSettings(database.DB, push.RecipientID, &push.SenderID, nil)
+	}
+	if settings != nil && settings.MutedUntil != nil && settings.MutedUntil.After(now) {
+		return PushDecision{Reason: PushSuppressedMuted}
+	}
+	if settings != nil && settings.MentionsOnly && !mentionsRecipient(push) {
+		return PushDecision{Reason: PushSuppressedNotMentioned}
+	}
+
+	prefs, err := models.GetNotificationPreferences(database.DB, push.RecipientID)
+	if err != nil {
+		return PushDecision{Send: true} // Fail open; a lost preference shouldn't lose messages
+	}
+	if prefs.InDoNotDisturb(now) {
+		return PushDecision{Reason: PushSuppressedDoNotDisturb}
+	}
+
+	return PushDecision{Send: true, HidePreview: prefs.HidePreviews, Private: prefs.PrivatePushes}
+}
+
+func mentionsRecipient(push MessagePush) bool {
+	if push.Mentioned {
+		return true
+	}
+	var recipient models.User
+	if err := database.DB.Select("username").First(&recipient, "id = ?", push.RecipientID).Error; err != nil {
+		return false
+	}
+	return ContainsMention(push.Content, recipient.Username)
+}
+
+// ContainsMention returns true if content @mentions username. Matching is
+// case-insensitive and the mention must not run on into a longer name.
+func ContainsMention(content, username string) bool {
+	if username == "" {
+		return false
+	}
+	content = strings.ToLower(content)
+	mention := "@" + strings.ToLower(username)
+
+	for offset := 0; ; {
+		i := strings.Index(content[offset:], mention)
+		if i < 0 {
+			return false
+		}
+		end := offset + i + len(mention)
+		next, _ := utf8.DecodeRuneInString(content[end:])
+		if end == len(content) || !(unicode.IsLetter(next) || unicode.IsDigit(next) || next == '_') {
+			return true
+		}
+		offset = end
+	}
+}
+
+// NotificationPreferencesInput holds account-wide notification settings to
+// change. Nil fields are left as they are.
+type NotificationPreferencesInput struct {
+	DNDEnabled    *bool   `json:"dnd_enabled"`
+	DNDStart      *string `json:"dnd_start"`
+	DNDEnd        *string `json:"dnd_end"`
+	Timezone      *string `json:"timezone"`
+	HidePreviews  *bool   `json:"hide_previews"`
+	PrivatePushes *bool   `json:"private_pushes"`
+}
+
+// GetNotificationPreferences returns a user's account-wide notification settings
+func GetNotificationPreferences(userID string) (*models.NotificationPreferences, error) {
+	return models.GetNotificationPreferences(database.DB, userID)
+}
+
+// UpdateNotificationPreferences validates and saves a user's account-wide
+// notification settings
+func UpdateNotificationPreferences(userID string, input NotificationPreferencesInput) (*models.NotificationPreferences, error) {
+	prefs, err := models.GetNotificationPreferences(database.DB, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	if input.Timezone != nil {
+		if _, err := time.LoadLocation(*input.Timezone); err != nil || *input.Timezone == "" || *input.Timezone == "Local" {
+			return nil, ErrInvalidTimezone
+		}
+		prefs.Timezone = *input.Timezone
+	}
+	if input.DNDStart != nil {
+		if _, err := models.ParseClock(*input.DNDStart); err != nil {
+			return nil, ErrInvalidDNDTime
+		}
+		prefs.DNDStart = *input.DNDStart
+	}
+	if input.DNDEnd != nil {
+		if _, err := models.ParseClock(*input.DNDEnd); err != nil {
+			return nil, ErrInvalidDNDTime
+		}
+		prefs.DNDEnd = *input.DNDEnd
+	}
+	if input.DNDEnabled != nil {
+		prefs.DNDEnabled = *input.DNDEnabled
+	}
+	if input.HidePreviews != nil {
+		prefs.HidePreviews = *input.HidePreviews
+	}
+	if input.PrivatePushes != nil {
+		prefs.PrivatePushes = *input.PrivatePushes
+	}
+
+	if err := models.SaveNotificationPreferences(database.DB, prefs); err != nil {
+		return nil, err
+	}
+	return prefs, nil
+}
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"messenger/internal/database"
+	"messenger/internal/models"
+)
+
+func TestContainsMention(t *testing.T) {
+	tests := []struct {
+		content string
+		want    bool
+	}{
+		{"@alice can you look?", true},
+		{"thanks @Alice.", true},
+		{"ping @alice", true},
+		{"@alice_2 not you", false},
+		{"@alicebob neither", false},
+		{"alice without the at", false},
+		{"@bob and then @alice", true},
+	}
+
+	for _, tt := range tests {
+		if got := ContainsMention(tt.content, "alice"); got != tt.want {
+			t.Errorf("ContainsMention(%q) = %v, want %v", tt.content, got, tt.want)
+		}
+	}
+}
+
+func TestNotificationPreferences_InDoNotDisturb(t *testing.T) {
+	prefs := models.NotificationPreferences{DNDEnabled: true, DNDStart: "22:00", DNDEnd: "07:00", Timezone: "America/New_York"}
+
+	// 03:00 UTC is 23:00 the evening before in New York (EDT)
+	if !prefs.InDoNotDisturb(time.Date(2026, 7, 1, 3, 0, 0, 0, time.UTC)) {
+		t.Error("23:00 local should be inside a window spanning midnight")
+	}
+	// 15:00 UTC is 11:00 in New York
+	if prefs.InDoNotDisturb(time.Date(2026, 7, 1, 15, 0, 0, 0, time.UTC)) {
+		t.Error("11:00 local should be outside the window")
+	}
+
+	prefs.DNDStart, prefs.DNDEnd = "09:00", "17:00"
+	if !prefs.InDoNotDisturb(time.Date(2026, 7, 1, 15, 0, 0, 0, time.UTC)) {
+		t.Error("11:00 local should be inside a daytime window")
+	}
+
+	prefs.DNDEnabled = false
+	if prefs.InDoNotDisturb(time.Date(2026, 7, 1, 15, 0, 0, 0, time.UTC)) {
+		t.Error("Disabled do-not-disturb should never apply")
+	}
+}
+
+func TestEvaluateMessagePush(t *testing.T) {
+	cleanup := setupAuthTestDB(t)
+	defer cleanup()
+	database.DB.AutoMigrate(&models.Block{}, &models.ConversationSettings{})
+
+	svc := NewAuthService()
+	recipient, _ := svc.Register(RegisterInput{Username: "alice", Password: "password123"})
+	sender, _ := svc.Register(RegisterInput{Username: "bob", Password: "password123"})
+	recipientID, senderID := recipient.User.ID, sender.User.ID
+	now := time.Now()
+
+	dm := MessagePush{RecipientID: recipientID, SenderID: senderID, Content: "hello"}
+	if decision := EvaluateMessagePush(dm, now); !decision.Send || decision.HidePreview {
+		t.Fatalf("Expected a plain push by default, got %+v", decision)
+	}
+
+	t.Run("muted", func(t *testing.T) {
+		settings, _ := models.GetOrCreateDMSettings(database.DB, recipientID, senderID)
+		database.DB.Model(settings).Update("muted_until", now.Add(time.Hour))
+		defer database.DB.Model(settings).Update("muted_until", nil)
+
+		if decision := EvaluateMessagePush(dm, now); decision.Send || decision.Reason != PushSuppressedMuted {
+			t.Errorf("Expected muted, got %+v", decision)
+		}
+		if decision := EvaluateMessagePush(dm, now.Add(2*time.Hour)); !decision.Send {
+			t.Error("Expired mute should not suppress pushes")
+		}
+	})
+
+	t.Run("mentions only", func(t *testing.T) {
+		groupID := "group-1"
+		settings, _ := models.GetOrCreateGroupSettings(database.DB, recipientID, groupID)
+		database.DB.Model(settings).Update("mentions_only", true)
+
+		push := MessagePush{RecipientID: recipientID, SenderID: senderID, GroupID: groupID, Content: "lunch?"}
+		if decision := EvaluateMessagePush(push, now); decision.Reason != PushSuppressedNotMentioned {
+			t.Errorf("Expected not_mentioned, got %+v", decision)
+		}
+		push.Content = "lunch @alice?"
+		if decision := EvaluateMessagePush(push, now); !decision.Send {
+			t.Errorf("Mention should be pushed, got %+v", decision)
+		}
+	})
+
+	t.Run("do not disturb and previews", func(t *testing.T) {
+		enabled, hide := true, true
+		start, end := now.UTC().Add(-time.Hour).Format("15:04"), now.UTC().Add(time.Hour).Format("15:04")
+		utc := "UTC"
+		if _, err := UpdateNotificationPreferences(recipientID, NotificationPreferencesInput{
+			DNDEnabled: &enabled, DNDStart: &start, DNDEnd: &end, Timezone: &utc, HidePreviews: &hide,
+		}); err != nil {
+			t.Fatalf("UpdateNotificationPreferences failed: %v", err)
+		}
+
+		if decision := EvaluateMessagePush(dm, now); decision.Reason != PushSuppressedDoNotDisturb {
+			t.Errorf("Expected do_not_disturb, got %+v", decision)
+		}
+		if decision := EvaluateMessagePush(dm, now.Add(3*time.Hour)); !decision.Send || !decision.HidePreview {
+			t.Errorf("Expected a push without preview after the window, got %+v", decision)
+		}
+	})
+
+	t.Run("blocked", func(t *testing.T) {
+		database.DB.Create(&models.Block{BlockerID: recipientID, BlockedID: senderID})
+		if decision := EvaluateMessagePush(dm, now); decision.Reason != PushSuppressedBlocked {
+			t.Errorf("Expected blocked, got %+v", decision)
+		}
+	})
+}
@@ -0,0 +1,664 @@
+package services
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+	"messenger/internal/database"
+	"messenger/internal/models"
+)
+
+// OIDC defaults
+const (
+	OIDCAuthRequestTTL = 10 * time.Minute
+	oidcClockSkew      = time.Minute
+	oidcHTTPTimeout    = 10 * time.Second
+)
+
+// oidcSigningMethods are the ID token algorithms we accept. HMAC and "none" are
+// rejected so a token can't be forged with the client secret or without a signature.
+var oidcSigningMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+var (
+	ErrOIDCDisabled        = errors.New("single sign-on is not configured")
+	ErrOIDCInvalidState    = errors.New("invalid or expired sign-on request")
+	ErrOIDCExchangeFailed  = errors.New("identity provider rejected the authorization code")
+	ErrOIDCInvalidIDToken  = errors.New("invalid ID token")
+	ErrOIDCIdentityLinked  = errors.New("this identity is already linked to another account")
+	ErrOIDCNoAccount       = errors.New("no account is linked to this identity")
+	ErrOIDCProviderUnavail = errors.New("identity provider is unavailable")
+)
+
+// OIDCConfig configures the OpenID Connect relying party
+type OIDCConfig struct {
+	Issuer        string
+	ClientID      string
+	ClientSecret  string
+	RedirectURL   string // Our callback URL registered with the provider
+	Scopes        []string
+	RoleClaim     string                     // Claim holding the user's groups/roles; dots address nested claims
+	RoleMapping   map[string]models.UserRole // IdP role/group -> local role
+	AutoProvision bool                       // Create users on first sign-in
+	LinkByEmail   bool                       // Link to an existing user when both sides have verified the same email
+}
+
+// OIDCConfigFromEnv reads OIDC_ISSUER, OIDC_CLIENT_ID, OIDC_CLIENT_SECRET,
+// OIDC_REDIRECT_URL, OIDC_SCOPES, OIDC_ROLE_CLAIM, OIDC_ROLE_MAPPING,
+// OIDC_AUTO_PROVISION and OIDC_LINK_BY_EMAIL
+func OIDCConfigFromEnv() OIDCConfig {
+	return OIDCConfig{
+		Issuer:        getEnvOrDefault("OIDC_ISSUER", ""),
+		ClientID:      getEnvOrDefault("OIDC_CLIENT_ID", ""),
+		ClientSecret:  getEnvOrDefault("OIDC_CLIENT_SECRET", ""),
+		RedirectURL:   getEnvOrDefault("OIDC_REDIRECT_URL", ""),
+		Scopes:        strings.Fields(getEnvOrDefault("OIDC_SCOPES", "openid email profile")),
+		RoleClaim:     getEnvOrDefault("OIDC_ROLE_CLAIM", "roles"),
+		RoleMapping:   ParseOIDCRoleMapping(getEnvOrDefault("OIDC_ROLE_MAPPING", "")),
+		AutoProvision: getEnvOrDefault("OIDC_AUTO_PROVISION", "true") == "true",
+		LinkByEmail:   getEnvOrDefault("OIDC_LINK_BY_EMAIL", "false") == "true",
+	}
+}
+
+// ParseOIDCRoleMapping parses "idp-group:role,other-group:role" into a mapping.
+// Entries with an unknown local role are ignored.
+func ParseOIDCRoleMapping(value string) map[string]models.UserRole {
+	mapping := make(map[string]models.UserRole)
+	for _, entry := range strings.Split(value, ",") {
+		idx := strings.LastIndex(entry, ":")
+		if idx <= 0 {
+			continue
+		}
+		claim := strings.TrimSpace(entry[:idx])
+		role := models.UserRole(strings.TrimSpace(entry[idx+1:]))
+		if roleRank(role) < 0 {
+			log.Printf("Warning: ignoring OIDC role mapping %q - unknown role %q", entry, role)
+			continue
+		}
+		mapping[claim] = role
+	}
+	return mapping
+}
+
+// roleRank orders roles so the highest mapped role wins
+func roleRank(role models.UserRole) int {
+	switch role {
+	case models.UserRoleUser:
+		return 0
+	case models.UserRoleModerator:
+		return 1
+	case models.UserRoleAdmin:
+		return 2
+	}
+	return -1
+}
+
+// OIDCClaims are the ID token claims we use
+type OIDCClaims struct {
+	Nonce             string   `json:"nonce"`
+	AuthorizedParty   string   `json:"azp,omitempty"`
+	Email             string   `json:"email,omitempty"`
+	EmailVerified     flexBool `json:"email_verified,omitempty"`
+	Name              string   `json:"name,omitempty"`
+	PreferredUsername string   `json:"preferred_username,omitempty"`
+	jwt.RegisteredClaims
+
+	raw map[string]interface{}
+}
+
+// flexBool accepts both JSON booleans and the "true"/"false" strings some providers send
+type flexBool bool
+
+func (b *flexBool) UnmarshalJSON(data []byte) error {
+	switch strings.Trim(string(data), `"`) {
+	case "true":
+		*b = true
+	default:
+		*b = false
+	}
+	return nil
+}
+
+// OIDCAuthorization is the provider URL a client should open to sign in
+type OIDCAuthorization struct {
+	URL       string    `json:"authorization_url"`
+	State     string    `json:"state"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// OIDCCallbackInput is the authorization response relayed by the client
+type OIDCCallbackInput struct {
+	Code       string `json:"code"`
+	State      string `json:"state"`
+	DeviceName string `json:"device_name,omitempty"`
+
+	Meta SessionMeta `json:"-"` // Filled in by the handler
+}
+
+// OIDCService signs users in with an OpenID Connect identity provider using
+// the authorization code flow with PKCE
+type OIDCService struct {
+	config OIDCConfig
+	keys   *oidcKeySet
+	client *http.Client
+	auth   *AuthService
+}
+
+// NewOIDCService creates a service configured from the environment
+func NewOIDCService() *OIDCService {
+	return NewOIDCServiceWithConfig(OIDCConfigFromEnv())
+}
+
+// NewOIDCServiceWithConfig creates a service with explicit settings
+func NewOIDCServiceWithConfig(config OIDCConfig) *OIDCService {
+	if len(config.Scopes) == 0 {
+		config.Scopes = []string{"openid"}
+	}
+	hasOpenID := false
+	for _, scope := range config.Scopes {
+		if scope == "openid" {
+			hasOpenID = true
+		}
+	}
+	if !hasOpenID {
+		config.Scopes = append([]string{"openid"}, config.Scopes...)
+	}
+
+	client := &http.Client{Timeout: oidcHTTPTimeout}
+	return &OIDCService{
+		config: config,
+		keys:   newOIDCKeySet(config.Issuer, client),
+		client: client,
+		auth:   NewAuthService(),
+	}
+}
+
+// Enabled reports whether an identity provider is configured
+func (s *OIDCService) Enabled() bool {
+	return s.config.Issuer != "" && s.config.ClientID != "" && s.config.RedirectURL != ""
+}
+
+// AuthorizationURL starts a sign-in. The state, nonce and PKCE verifier are
+// stored server-side and checked when the client returns with the code.
+func (s *OIDCService) AuthorizationURL(ctx context.Context) (*OIDCAuthorization, error) {
+	return s.authorize(ctx, "", "")
+}
+
+// LinkAuthorizationURL starts linking a provider identity to a signed-in
+// user. The request is bound to the session that started it, and only that
+// session can complete it with CompleteLink.
+func (s *OIDCService) LinkAuthorizationURL(ctx context.Context, userID, sessionID string) (*OIDCAuthorization, error) {
+	if userID == "" || sessionID == "" {
+		return nil, ErrOIDCInvalidState
+	}
+	return s.authorize(ctx, userID, sessionID)
+}
+
+func (s *OIDCService) authorize(ctx context.Context, linkUserID, linkSessionID string) (*OIDCAuthorization, error) {
+	if !s.Enabled() {
+		return nil, ErrOIDCDisabled
+	}
+
+	discovery, err := s.keys.Discovery(ctx)
+	if err != nil {
+		log.Printf("OIDC: %v", err)
+		return nil, ErrOIDCProviderUnavail
+	}
+
+	state, err := generateOpaqueToken()
+	if err != nil {
+		return nil, errors.New("failed to generate state")
+	}
+	nonce, err := generateOpaqueToken()
+	if err != nil {
+		return nil, errors.New("failed to generate nonce")
+	}
+	verifier, err := generateOpaqueToken()
+	if err != nil {
+		return nil, errors.New("failed to generate code verifier")
+	}
+
+	request := models.OIDCAuthRequest{
+		State:         state,
+		Nonce:         nonce,
+		CodeVerifier:  verifier,
+		LinkUserID:    linkUserID,
+		LinkSessionID: linkSessionID,
+		ExpiresAt:     time.Now().Add(OIDCAuthRequestTTL),
+	}
+	if err := database.DB.Create(&request).Error; err != nil {
+		return nil, errors.New("failed to store sign-on request")
+	}
+
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {s.config.ClientID},
+		"redirect_uri":          {s.config.RedirectURL},
+		"scope":                 {strings.Join(s.config.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {pkceChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(discovery.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return &OIDCAuthorization{
+		URL:       discovery.AuthorizationEndpoint + separator + query.Encode(),
+		State:     state,
+		ExpiresAt: request.ExpiresAt,
+	}, nil
+}
+
+// pkceChallenge derives the S256 code challenge from a verifier (RFC 7636)
+func pkceChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Callback completes a sign-in: the code is exchanged for an ID token, the
+// token is validated and the identity is resolved to a local user, who is
+// then logged in (subject to 2FA like any other login). Link requests are
+// rejected here; they complete through CompleteLink.
+func (s *OIDCService) Callback(ctx context.Context, input OIDCCallbackInput) (*AuthResponse, error) {
+	if !s.Enabled() {
+		return nil, ErrOIDCDisabled
+	}
+
+	request, err := models.TakeOIDCAuthRequest(database.DB, input.State)
+	if err != nil || time.Now().After(request.ExpiresAt) || request.LinkUserID != "" {
+		return nil, ErrOIDCInvalidState
+	}
+
+	claims, err := s.redeem(ctx, input.Code, request)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := s.resolveUser(claims, "")
+	if err != nil {
+		return nil, err
+	}
+
+	input.Meta.DeviceName = input.DeviceName
+	return s.auth.completeLogin(user, input.Meta)
+}
+
+// CompleteLink links the provider identity to the signed-in user. The state
+// must come from LinkAuthorizationURL for the same user and session, so a
+// link URL started by someone else can't attach their account to the caller's
+// identity. Returns the user's linked identities.
+func (s *OIDCService) CompleteLink(ctx context.Context, input OIDCCallbackInput, userID, sessionID string) ([]models.ExternalIdentity, error) {
+	if !s.Enabled() {
+		return nil, ErrOIDCDisabled
+	}
+
+	request, err := models.TakeOIDCAuthRequest(database.DB, input.State)
+	if err != nil || time.Now().After(request.ExpiresAt) ||
+		request.LinkUserID == "" || request.LinkUserID != userID || request.LinkSessionID != sessionID {
+		return nil, ErrOIDCInvalidState
+	}
+
+	claims, err := s.redeem(ctx, input.Code, request)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.resolveUser(claims, userID); err != nil {
+		return nil, err
+	}
+	return s.LinkedIdentities(userID)
+}
+
+// redeem exchanges the code and validates the ID token for an auth request
+func (s *OIDCService) redeem(ctx context.Context, code string, request *models.OIDCAuthRequest) (*OIDCClaims, error) {
+	rawIDToken, err := s.exchangeCode(ctx, code, request.CodeVerifier)
+	if err != nil {
+		return nil, err
+	}
+	return s.ValidateIDToken(ctx, rawIDToken, request.Nonce)
+}
+
+// exchangeCode redeems an authorization code at the token endpoint
+func (s *OIDCService) exchangeCode(ctx context.Context, code, verifier string) (string, error) {
+	discovery, err := s.keys.Discovery(ctx)
+	if err != nil {
+		log.Printf("OIDC: %v", err)
+		return "", ErrOIDCProviderUnavail
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {s.config.RedirectURL},
+		"code_verifier": {verifier},
+	}
+
+	usePost := supportsOnly(discovery.TokenEndpointAuthMethodsSupported, "client_secret_post")
+	if usePost || s.config.ClientSecret == "" {
+		form.Set("client_id", s.config.ClientID)
+		if s.config.ClientSecret != "" {
+			form.Set("client_secret", s.config.ClientSecret)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, discovery.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if !usePost && s.config.ClientSecret != "" {
+		req.SetBasicAuth(url.QueryEscape(s.config.ClientID), url.QueryEscape(s.config.ClientSecret))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		log.Printf("OIDC token request failed: %v", err)
+		return "", ErrOIDCProviderUnavail
+	}
+	defer resp.Body.Close()
+
+	var token struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, oidcMaxResponseBytes)).Decode(&token); err != nil {
+		return "", ErrOIDCExchangeFailed
+	}
+	if resp.StatusCode != http.StatusOK {
+		log.Printf("OIDC token endpoint returned %d: %s %s", resp.StatusCode, token.Error, token.ErrorDescription)
+		return "", ErrOIDCExchangeFailed
+	}
+	if token.IDToken == "" {
+		return "", ErrOIDCExchangeFailed
+	}
+	return token.IDToken, nil
+}
+
+// supportsOnly reports whether a provider lists method but not client_secret_basic,
+// the default when token_endpoint_auth_methods_supported is absent
+func supportsOnly(methods []string, method string) bool {
+	found := false
+	for _, m := range methods {
+		if m == "client_secret_basic" {
+			return false
+		}
+		if m == method {
+			found = true
+		}
+	}
+	return found
+}
+
+// ValidateIDToken checks an ID token's signature against the provider's JWKS
+// and validates iss, aud, azp, exp, iat and nonce (OIDC Core 3.1.3.7)
+func (s *OIDCService) ValidateIDToken(ctx context.Context, rawIDToken, nonce string) (*OIDCClaims, error) {
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(oidcSigningMethods),
+		jwt.WithIssuer(s.keys.issuer),
+		jwt.WithAudience(s.config.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(oidcClockSkew),
+	)
+
+	raw := jwt.MapClaims{}
+	_, err := parser.ParseWithClaims(rawIDToken, raw, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		return s.keys.Key(ctx, kid)
+	})
+	if err != nil {
+		log.Printf("OIDC ID token rejected: %v", err)
+		return nil, ErrOIDCInvalidIDToken
+	}
+
+	encoded, err := json.Marshal(raw)
+	if err != nil {
+		return nil, ErrOIDCInvalidIDToken
+	}
+	claims := &OIDCClaims{raw: raw}
+	if err := json.Unmarshal(encoded, claims); err != nil {
+		return nil, ErrOIDCInvalidIDToken
+	}
+
+	if claims.Subject == "" || claims.IssuedAt == nil {
+		return nil, ErrOIDCInvalidIDToken
+	}
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != s.config.ClientID {
+		return nil, ErrOIDCInvalidIDToken
+	}
+	if claims.AuthorizedParty != "" && claims.AuthorizedParty != s.config.ClientID {
+		return nil, ErrOIDCInvalidIDToken
+	}
+	if nonce == "" || claims.Nonce != nonce {
+		return nil, ErrOIDCInvalidIDToken
+	}
+	return claims, nil
+}
+
+// resolveUser finds or creates the local user for a validated identity:
+// an existing link, then the user who started a link request, then (with
+// LinkByEmail) the user with the same verified email, then a newly
+// provisioned user
+func (s *OIDCService) resolveUser(claims *OIDCClaims, linkUserID string) (*models.User, error) {
+	issuer := s.keys.issuer
+	now := time.Now()
+
+	identity, err := models.GetExternalIdentity(database.DB, issuer, claims.Subject)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, errors.New("failed to look up identity")
+	}
+
+	var user *models.User
+	if identity == nil && linkUserID == "" {
+		user = s.userByVerifiedEmail(claims)
+	}
+	switch {
+	case user != nil:
+		log.Printf("OIDC: linking subject %s to user %s by verified email", claims.Subject, user.ID)
+
+	case identity != nil:
+		if linkUserID != "" && identity.UserID != linkUserID {
+			return nil, ErrOIDCIdentityLinked
+		}
+		if user, err = GetUserByID(identity.UserID); err != nil {
+			return nil, ErrOIDCNoAccount
+		}
+
+	case linkUserID != "":
+		if user, err = GetUserByID(linkUserID); err != nil {
+			return nil, ErrOIDCNoAccount
+		}
+
+	default:
+		if !s.config.AutoProvision {
+			return nil, ErrOIDCNoAccount
+		}
+		if user, err = s.provisionUser(claims); err != nil {
+			return nil, err
+		}
+	}
+
+	if identity == nil {
+		identity = &models.ExternalIdentity{
+			UserID:  user.ID,
+			Issuer:  issuer,
+			Subject: claims.Subject,
+		}
+	}
+	identity.Email = claims.Email
+	identity.LastLoginAt = &now
+	if err := database.DB.Save(identity).Error; err != nil {
+		return nil, ErrOIDCIdentityLinked
+	}
+
+	s.applyRoleMapping(user, claims)
+	return user, nil
+}
+
+// userByVerifiedEmail returns the user to link a new identity to by email,
+// or nil. Both the provider and the local account must have verified the
+// address, and a user who already has an identity from this provider is
+// never matched, as that identity is the one that signs them in.
+func (s *OIDCService) userByVerifiedEmail(claims *OIDCClaims) *models.User {
+	if !s.config.LinkByEmail || claims.Email == "" || !bool(claims.EmailVerified) {
+		return nil
+	}
+
+	var user models.User
+	err := database.DB.Where("email = ? AND email_verified_at IS NOT NULL", strings.ToLower(claims.Email)).First(&user).Error
+	if err != nil {
+		return nil
+	}
+
+	var linked int64
+	database.DB.Model(&models.ExternalIdentity{}).Where("user_id = ? AND issuer = ?", user.ID, s.keys.issuer).Count(&linked)
+	if linked > 0 {
+		return nil
+	}
+	return &user
+}
+
+var usernameInvalidChars = regexp.MustCompile(`[^a-z0-9_.]+`)
+
+// provisionUser creates a user for a first-time sign-in. The password is an
+// unusable random hash, marked with PasswordUnset; the user can set one later
+// via password reset.
+func (s *OIDCService) provisionUser(claims *OIDCClaims) (*models.User, error) {
+	secret, err := generateOpaqueToken()
+	if err != nil {
+		return nil, errors.New("failed to create user")
+	}
+	hashed, err := bcrypt.GenerateFromPassword([]byte(secret), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, errors.New("failed to create user")
+	}
+
+	user := models.User{
+		Username:      s.uniqueUsername(claims),
+		PasswordHash:  string(hashed),
+		PasswordUnset: true,
+		DisplayName:   claims.Name,
+		Role:          models.UserRoleUser,
+	}
+
+	// Only take the email if it's verified and nobody else has it
+	if claims.Email != "" && bool(claims.EmailVerified) {
+		email := strings.ToLower(claims.Email)
+		var count int64
+		database.DB.Model(&models.User{}).Where("email = ?", email).Count(&count)
+		if count == 0 {
+			now := time.Now()
+			user.Email = &email
+			user.EmailVerifiedAt = &now
+		}
+	}
+
+	if err := database.DB.Create(&user).Error; err != nil {
+		return nil, errors.New("failed to create user")
+	}
+	log.Printf("OIDC: provisioned user %s (%s) for subject %s", user.ID, user.Username, claims.Subject)
+	return &user, nil
+}
+
+// uniqueUsername derives a free username from preferred_username, the email
+// local part or the name
+func (s *OIDCService) uniqueUsername(claims *OIDCClaims) string {
+	base := claims.PreferredUsername
+	if base == "" && claims.Email != "" {
+		base = strings.SplitN(claims.Email, "@", 2)[0]
+	}
+	if base == "" {
+		base = claims.Name
+	}
+
+	base = usernameInvalidChars.ReplaceAllString(strings.ToLower(base), "_")
+	base = strings.Trim(base, "_.")
+	if len(base) > 24 {
+		base = base[:24]
+	}
+	if len(base) < 3 {
+		base = "user"
+	}
+
+	candidate := base
+	for i := 2; ; i++ {
+		var count int64
+		database.DB.Model(&models.User{}).Where("username = ?", candidate).Count(&count)
+		if count == 0 {
+			return candidate
+		}
+		candidate = fmt.Sprintf("%s%d", base, i)
+	}
+}
+
+// applyRoleMapping sets the user's role to the highest role mapped from the
+// role claim. When a mapping is configured the provider is the source of
+// truth, so users whose groups no longer map to a role are reset to "user".
+func (s *OIDCService) applyRoleMapping(user *models.User, claims *OIDCClaims) {
+	if len(s.config.RoleMapping) == 0 {
+		return
+	}
+
+	role := models.UserRoleUser
+	for _, value := range claimStrings(claims.raw, s.config.RoleClaim) {
+		if mapped, ok := s.config.RoleMapping[value]; ok && roleRank(mapped) > roleRank(role) {
+			role = mapped
+		}
+	}
+
+	if user.Role != role {
+		log.Printf("OIDC: role of user %s changed from %q to %q", user.ID, user.Role, role)
+		database.DB.Model(user).Update("role", role)
+		user.Role = role
+	}
+}
+
+// claimStrings reads a string or string array claim. Dots address nested
+// objects, e.g. "realm_access.roles".
+func claimStrings(claims map[string]interface{}, path string) []string {
+	var value interface{} = claims
+	for _, part := range strings.Split(path, ".") {
+		obj, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = obj[part]
+	}
+
+	switch v := value.(type) {
+	case string:
+		return strings.Fields(strings.ReplaceAll(v, ",", " "))
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// LinkedIdentities returns the provider identities linked to a user
+func (s *OIDCService) LinkedIdentities(userID string) ([]models.ExternalIdentity, error) {
+	return models.GetUserExternalIdentities(database.DB, userID)
+}
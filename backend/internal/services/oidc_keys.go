@@ -0,0 +1,233 @@
+package services
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// OIDC metadata and key caching
+const (
+	oidcDiscoveryTTL     = time.Hour
+	oidcJWKSTTL          = time.Hour
+	oidcJWKSMinRefresh   = time.Minute // Unknown kids trigger a refresh at most this often
+	oidcMaxResponseBytes = 1 << 20
+)
+
+var ErrUnknownSigningKey = errors.New("unknown signing key")
+
+// OIDCDiscovery is the subset of the provider metadata document we use
+type OIDCDiscovery struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	UserinfoEndpoint                  string   `json:"userinfo_endpoint,omitempty"`
+	JWKSURI                           string   `json:"jwks_uri"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported,omitempty"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported,omitempty"`
+}
+
+// jsonWebKey is a single key from a JWKS document
+type jsonWebKey struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use,omitempty"`
+	Alg string `json:"alg,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// publicKey converts a JWK to an RSA or ECDSA public key
+func (k jsonWebKey) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64.RawURLEncoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA modulus: %w", err)
+		}
+		e, err := base64.RawURLEncoding.DecodeString(k.E)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RSA exponent: %w", err)
+		}
+		exponent := new(big.Int).SetBytes(e)
+		if !exponent.IsInt64() || exponent.Int64() < 3 {
+			return nil, errors.New("invalid RSA exponent")
+		}
+		return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(exponent.Int64())}, nil
+
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC x: %w", err)
+		}
+		y, err := base64.RawURLEncoding.DecodeString(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid EC y: %w", err)
+		}
+		key := &ecdsa.PublicKey{Curve: curve, X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !curve.IsOnCurve(key.X, key.Y) {
+			return nil, errors.New("EC point is not on curve")
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+}
+
+// oidcKeySet caches a provider's discovery document and signing keys
+type oidcKeySet struct {
+	issuer string
+	client *http.Client
+
+	mu           sync.Mutex
+	discovery    *OIDCDiscovery
+	discoveredAt time.Time
+	keys         map[string]interface{}
+	keysFetched  time.Time
+}
+
+func newOIDCKeySet(issuer string, client *http.Client) *oidcKeySet {
+	return &oidcKeySet{
+		issuer: issuer,
+		client: client,
+	}
+}
+
+// Discovery returns the provider metadata, fetching it if the cache is stale
+func (ks *oidcKeySet) Discovery(ctx context.Context) (*OIDCDiscovery, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	return ks.discoveryLocked(ctx)
+}
+
+func (ks *oidcKeySet) discoveryLocked(ctx context.Context) (*OIDCDiscovery, error) {
+	if ks.discovery != nil && time.Since(ks.discoveredAt) < oidcDiscoveryTTL {
+		return ks.discovery, nil
+	}
+
+	var doc OIDCDiscovery
+	if err := ks.getJSON(ctx, strings.TrimRight(ks.issuer, "/")+"/.well-known/openid-configuration", &doc); err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed: %w", err)
+	}
+
+	// The issuer in the document must match the configured one exactly (OIDC Discovery 4.3)
+	if doc.Issuer != ks.issuer {
+		return nil, fmt.Errorf("OIDC discovery issuer mismatch: got %q, want %q", doc.Issuer, ks.issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, errors.New("OIDC discovery document is missing endpoints")
+	}
+
+	ks.discovery = &doc
+	ks.discoveredAt = time.Now()
+	return ks.discovery, nil
+}
+
+// Key returns the public key with the given kid. An unknown kid triggers a
+// refresh (rate limited) so provider key rotation is picked up.
+func (ks *oidcKeySet) Key(ctx context.Context, kid string) (interface{}, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+
+	stale := ks.keys == nil || time.Since(ks.keysFetched) > oidcJWKSTTL
+	if !stale {
+		if key, ok := ks.lookupLocked(kid); ok {
+			return key, nil
+		}
+		if time.Since(ks.keysFetched) < oidcJWKSMinRefresh {
+			return nil, ErrUnknownSigningKey
+		}
+	}
+
+	if err := ks.refreshKeysLocked(ctx); err != nil {
+		return nil, err
+	}
+	if key, ok := ks.lookupLocked(kid); ok {
+		return key, nil
+	}
+	return nil, ErrUnknownSigningKey
+}
+
+func (ks *oidcKeySet) lookupLocked(kid string) (interface{}, bool) {
+	if kid == "" && len(ks.keys) == 1 {
+		// Providers with a single key sometimes omit kid from the token header
+		for _, key := range ks.keys {
+			return key, true
+		}
+	}
+	key, ok := ks.keys[kid]
+	return key, ok
+}
+
+func (ks *oidcKeySet) refreshKeysLocked(ctx context.Context) error {
+	discovery, err := ks.discoveryLocked(ctx)
+	if err != nil {
+		return err
+	}
+
+	var doc struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := ks.getJSON(ctx, discovery.JWKSURI, &doc); err != nil {
+		return fmt.Errorf("failed to fetch JWKS: %w", err)
+	}
+
+	keys := make(map[string]interface{}, len(doc.Keys))
+	for _, jwk := range doc.Keys {
+		if jwk.Use != "" && jwk.Use != "sig" {
+			continue
+		}
+		key, err := jwk.publicKey()
+		if err != nil {
+			continue // Skip keys we can't use rather than failing the whole set
+		}
+		keys[jwk.Kid] = key
+	}
+
+	ks.keys = keys
+	ks.keysFetched = time.Now()
+	return nil
+}
+
+func (ks *oidcKeySet) getJSON(ctx context.Context, url string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := ks.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, oidcMaxResponseBytes)).Decode(v)
+}
@@ -0,0 +1,538 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"messenger/internal/database"
+	"messenger/internal/models"
+)
+
+// mockIdP is a minimal OpenID provider: discovery, JWKS and a token endpoint
+// that checks PKCE and returns an RS256 ID token
+type mockIdP struct {
+	server   *httptest.Server
+	key      *rsa.PrivateKey
+	kid      string
+	clientID string
+
+	mu    sync.Mutex
+	codes map[string]mockGrant
+}
+
+type mockGrant struct {
+	challenge string
+	claims    jwt.MapClaims
+}
+
+func newMockIdP(t *testing.T, clientID string) *mockIdP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	idp := &mockIdP{key: key, kid: "test-key", clientID: clientID, codes: make(map[string]mockGrant)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(OIDCDiscovery{
+			Issuer:                idp.server.URL,
+			AuthorizationEndpoint: idp.server.URL + "/authorize",
+			TokenEndpoint:         idp.server.URL + "/token",
+			JWKSURI:               idp.server.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kty": "RSA",
+				"kid": idp.kid,
+				"use": "sig",
+				"alg": "RS256",
+				"n":   base64.RawURLEncoding.EncodeToString(idp.key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(idp.key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		if user, _, ok := r.BasicAuth(); !ok || user != idp.clientID {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+			return
+		}
+
+		idp.mu.Lock()
+		grant, ok := idp.codes[r.Form.Get("code")]
+		delete(idp.codes, r.Form.Get("code"))
+		idp.mu.Unlock()
+
+		sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+		if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != grant.challenge {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{
+			"access_token": "at",
+			"token_type":   "Bearer",
+			"id_token":     idp.sign(t, grant.claims),
+		})
+	})
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+	return idp
+}
+
+// authorize simulates the user signing in at the provider and returns the code
+// the provider would redirect back with. Claims override the defaults.
+func (idp *mockIdP) authorize(t *testing.T, authURL string, claims jwt.MapClaims) string {
+	t.Helper()
+	u, err := url.Parse(authURL)
+	if err != nil {
+		t.Fatalf("Invalid authorization URL: %v", err)
+	}
+	query := u.Query()
+
+	full := idp.claims(query.Get("nonce"))
+	for k, v := range claims {
+		full[k] = v
+	}
+
+	code := fmt.Sprintf("code-%d", time.Now().UnixNano())
+	idp.mu.Lock()
+	idp.codes[code] = mockGrant{challenge: query.Get("code_challenge"), claims: full}
+	idp.mu.Unlock()
+	return code
+}
+
+func (idp *mockIdP) claims(nonce string) jwt.MapClaims {
+	now := time.Now()
+	return jwt.MapClaims{
+		"iss":   idp.server.URL,
+		"aud":   idp.clientID,
+		"sub":   "subject-1",
+		"nonce": nonce,
+		"iat":   now.Unix(),
+		"exp":   now.Add(5 * time.Minute).Unix(),
+	}
+}
+
+func (idp *mockIdP) sign(t *testing.T, claims jwt.MapClaims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = idp.kid
+	signed, err := token.SignedString(idp.key)
+	if err != nil {
+		t.Fatalf("Failed to sign ID token: %v", err)
+	}
+	return signed
+}
+
+func newTestOIDCService(idp *mockIdP, mapping string) *OIDCService {
+	return NewOIDCServiceWithConfig(OIDCConfig{
+		Issuer:        idp.server.URL,
+		ClientID:      idp.clientID,
+		ClientSecret:  "secret",
+		RedirectURL:   "http://localhost/callback",
+		RoleClaim:     "groups",
+		RoleMapping:   ParseOIDCRoleMapping(mapping),
+		AutoProvision: true,
+	})
+}
+
+// oidcLogin runs a full authorization code flow against the mock provider
+func oidcLogin(t *testing.T, svc *OIDCService, idp *mockIdP, claims jwt.MapClaims) (*AuthResponse, error) {
+	t.Helper()
+	ctx := context.Background()
+	auth, err := svc.AuthorizationURL(ctx)
+	if err != nil {
+		t.Fatalf("AuthorizationURL failed: %v", err)
+	}
+	code := idp.authorize(t, auth.URL, claims)
+	return svc.Callback(ctx, OIDCCallbackInput{Code: code, State: auth.State})
+}
+
+// oidcLink runs a link flow for a signed-in session against the mock provider
+func oidcLink(t *testing.T, svc *OIDCService, idp *mockIdP, user *AuthResponse, claims jwt.MapClaims) ([]models.ExternalIdentity, error) {
+	t.Helper()
+	ctx := context.Background()
+	auth, err := svc.LinkAuthorizationURL(ctx, user.User.ID, user.SessionID)
+	if err != nil {
+		t.Fatalf("LinkAuthorizationURL failed: %v", err)
+	}
+	code := idp.authorize(t, auth.URL, claims)
+	return svc.CompleteLink(ctx, OIDCCallbackInput{Code: code, State: auth.State}, user.User.ID, user.SessionID)
+}
+
+func TestOIDCService_Disabled(t *testing.T) {
+	svc := NewOIDCServiceWithConfig(OIDCConfig{})
+	if svc.Enabled() {
+		t.Error("Service without issuer should be disabled")
+	}
+	if _, err := svc.AuthorizationURL(context.Background()); !errors.Is(err, ErrOIDCDisabled) {
+		t.Errorf("Expected ErrOIDCDisabled, got %v", err)
+	}
+}
+
+func TestOIDCService_AuthorizationURL(t *testing.T) {
+	cleanup := setupAuthTestDB(t)
+	defer cleanup()
+
+	idp := newMockIdP(t, "messenger")
+	svc := newTestOIDCService(idp, "")
+
+	auth, err := svc.AuthorizationURL(context.Background())
+	if err != nil {
+		t.Fatalf("AuthorizationURL failed: %v", err)
+	}
+
+	u, _ := url.Parse(auth.URL)
+	query := u.Query()
+	if u.Path != "/authorize" || query.Get("client_id") != "messenger" || query.Get("state") != auth.State {
+		t.Errorf("Unexpected authorization URL %s", auth.URL)
+	}
+	if query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "" || query.Get("nonce") == "" {
+		t.Error("Authorization URL should carry a PKCE challenge and nonce")
+	}
+
+	var stored models.OIDCAuthRequest
+	database.DB.First(&stored, "state = ?", auth.State)
+	if stored.Nonce != query.Get("nonce") || pkceChallenge(stored.CodeVerifier) != query.Get("code_challenge") {
+		t.Error("Stored request should match the URL")
+	}
+}
+
+func TestOIDCService_Callback(t *testing.T) {
+	cleanup := setupAuthTestDB(t)
+	defer cleanup()
+
+	idp := newMockIdP(t, "messenger")
+	svc := newTestOIDCService(idp, "")
+
+	t.Run("provisions a new user", func(t *testing.T) {
+		resp, err := oidcLogin(t, svc, idp, jwt.MapClaims{
+			"sub":                "alice-sub",
+			"email":              "Alice@Example.com",
+			"email_verified":     true,
+			"preferred_username": "Alice Smith",
+			"name":               "Alice Smith",
+		})
+		if err != nil {
+			t.Fatalf("Callback failed: %v", err)
+		}
+		if resp.AccessToken == "" || resp.User.Username != "alice_smith" || resp.User.DisplayName != "Alice Smith" {
+			t.Errorf("Unexpected response: %+v", resp.User)
+		}
+
+		user, _ := GetUserByID(resp.User.ID)
+		if user.Email == nil || *user.Email != "alice@example.com" || user.EmailVerifiedAt == nil {
+			t.Error("Verified email should be stored")
+		}
+		if !user.PasswordUnset {
+			t.Error("Provisioned users should be marked as having no password")
+		}
+	})
+
+	t.Run("same subject signs into the same user", func(t *testing.T) {
+		first, _ := oidcLogin(t, svc, idp, jwt.MapClaims{"sub": "alice-sub", "preferred_username": "alice smith"})
+		var count int64
+		database.DB.Model(&models.User{}).Count(&count)
+		if count != 1 || first.User.Username != "alice_smith" {
+			t.Errorf("Expected login to existing user, got %d users", count)
+		}
+	})
+
+	t.Run("username collisions get a suffix", func(t *testing.T) {
+		resp, err := oidcLogin(t, svc, idp, jwt.MapClaims{"sub": "other-alice", "preferred_username": "alice_smith"})
+		if err != nil {
+			t.Fatalf("Callback failed: %v", err)
+		}
+		if resp.User.Username != "alice_smith2" {
+			t.Errorf("Expected alice_smith2, got %s", resp.User.Username)
+		}
+	})
+
+	t.Run("state is single use", func(t *testing.T) {
+		ctx := context.Background()
+		auth, _ := svc.AuthorizationURL(ctx)
+		code := idp.authorize(t, auth.URL, jwt.MapClaims{"sub": "alice-sub"})
+		if _, err := svc.Callback(ctx, OIDCCallbackInput{Code: code, State: auth.State}); err != nil {
+			t.Fatalf("Callback failed: %v", err)
+		}
+		if _, err := svc.Callback(ctx, OIDCCallbackInput{Code: code, State: auth.State}); !errors.Is(err, ErrOIDCInvalidState) {
+			t.Errorf("Expected ErrOIDCInvalidState, got %v", err)
+		}
+	})
+
+	t.Run("wrong PKCE verifier is rejected", func(t *testing.T) {
+		ctx := context.Background()
+		auth, _ := svc.AuthorizationURL(ctx)
+		code := idp.authorize(t, auth.URL, nil)
+		database.DB.Model(&models.OIDCAuthRequest{}).Where("state = ?", auth.State).Update("code_verifier", "tampered")
+		if _, err := svc.Callback(ctx, OIDCCallbackInput{Code: code, State: auth.State}); !errors.Is(err, ErrOIDCExchangeFailed) {
+			t.Errorf("Expected ErrOIDCExchangeFailed, got %v", err)
+		}
+	})
+}
+
+func TestOIDCService_ValidateIDToken(t *testing.T) {
+	idp := newMockIdP(t, "messenger")
+	svc := newTestOIDCService(idp, "")
+	ctx := context.Background()
+
+	valid := idp.claims("nonce-1")
+	if _, err := svc.ValidateIDToken(ctx, idp.sign(t, valid), "nonce-1"); err != nil {
+		t.Fatalf("Valid token rejected: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(jwt.MapClaims)
+	}{
+		{"wrong nonce", func(c jwt.MapClaims) { c["nonce"] = "other" }},
+		{"wrong audience", func(c jwt.MapClaims) { c["aud"] = "someone-else" }},
+		{"wrong issuer", func(c jwt.MapClaims) { c["iss"] = "https://evil.example.com" }},
+		{"expired", func(c jwt.MapClaims) { c["exp"] = time.Now().Add(-time.Hour).Unix() }},
+		{"missing subject", func(c jwt.MapClaims) { delete(c, "sub") }},
+		{"foreign azp", func(c jwt.MapClaims) {
+			c["aud"] = []string{"messenger", "other"}
+			c["azp"] = "other"
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := idp.claims("nonce-1")
+			tt.modify(claims)
+			if _, err := svc.ValidateIDToken(ctx, idp.sign(t, claims), "nonce-1"); !errors.Is(err, ErrOIDCInvalidIDToken) {
+				t.Errorf("Expected ErrOIDCInvalidIDToken, got %v", err)
+			}
+		})
+	}
+
+	t.Run("HMAC token signed with the client secret", func(t *testing.T) {
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, idp.claims("nonce-1"))
+		signed, _ := token.SignedString([]byte("secret"))
+		if _, err := svc.ValidateIDToken(ctx, signed, "nonce-1"); !errors.Is(err, ErrOIDCInvalidIDToken) {
+			t.Errorf("Expected ErrOIDCInvalidIDToken, got %v", err)
+		}
+	})
+
+	t.Run("unknown signing key", func(t *testing.T) {
+		other, _ := rsa.GenerateKey(rand.Reader, 2048)
+		token := jwt.NewWithClaims(jwt.SigningMethodRS256, idp.claims("nonce-1"))
+		token.Header["kid"] = "rotated"
+		signed, _ := token.SignedString(other)
+		if _, err := svc.ValidateIDToken(ctx, signed, "nonce-1"); !errors.Is(err, ErrOIDCInvalidIDToken) {
+			t.Errorf("Expected ErrOIDCInvalidIDToken, got %v", err)
+		}
+	})
+}
+
+func TestOIDCService_Linking(t *testing.T) {
+	cleanup := setupAuthTestDB(t)
+	defer cleanup()
+
+	idp := newMockIdP(t, "messenger")
+	svc := newTestOIDCService(idp, "")
+	authSvc := NewAuthService()
+
+	alice, _ := authSvc.Register(RegisterInput{Username: "alice", Password: "password123"})
+	bob, _ := authSvc.Register(RegisterInput{Username: "bob", Password: "password123"})
+	now := time.Now()
+	database.DB.Model(&models.User{}).Where("id = ?", alice.User.ID).
+		Updates(map[string]interface{}{"email": "alice@example.com", "email_verified_at": now})
+
+	t.Run("does not link by email by default", func(t *testing.T) {
+		resp, err := oidcLogin(t, svc, idp, jwt.MapClaims{"sub": "a-sub", "email": "alice@example.com", "email_verified": true})
+		if err != nil {
+			t.Fatalf("Callback failed: %v", err)
+		}
+		if resp.User.ID == alice.User.ID {
+			t.Error("A provider account must not sign into a user by email")
+		}
+	})
+
+	t.Run("explicit link", func(t *testing.T) {
+		identities, err := oidcLink(t, svc, idp, bob, jwt.MapClaims{"sub": "link-sub"})
+		if err != nil {
+			t.Fatalf("CompleteLink failed: %v", err)
+		}
+		if len(identities) != 1 || identities[0].Subject != "link-sub" {
+			t.Errorf("Expected linked identity, got %+v", identities)
+		}
+
+		resp, err := oidcLogin(t, svc, idp, jwt.MapClaims{"sub": "link-sub"})
+		if err != nil || resp.User.ID != bob.User.ID {
+			t.Errorf("Linked identity should sign into the linking user, got %v", err)
+		}
+	})
+
+	t.Run("identity linked elsewhere can't be linked again", func(t *testing.T) {
+		_, err := oidcLink(t, svc, idp, alice, jwt.MapClaims{"sub": "link-sub"})
+		if !errors.Is(err, ErrOIDCIdentityLinked) {
+			t.Errorf("Expected ErrOIDCIdentityLinked, got %v", err)
+		}
+	})
+
+	t.Run("link state only completes for the session that started it", func(t *testing.T) {
+		ctx := context.Background()
+		auth, _ := svc.LinkAuthorizationURL(ctx, alice.User.ID, alice.SessionID)
+		code := idp.authorize(t, auth.URL, jwt.MapClaims{"sub": "victim-sub"})
+
+		// Bob was sent Alice's link URL and signs in at the provider
+		if _, err := svc.CompleteLink(ctx, OIDCCallbackInput{Code: code, State: auth.State}, bob.User.ID, bob.SessionID); !errors.Is(err, ErrOIDCInvalidState) {
+			t.Errorf("Expected ErrOIDCInvalidState, got %v", err)
+		}
+		identity, _ := models.GetExternalIdentity(database.DB, idp.server.URL, "victim-sub")
+		if identity != nil {
+			t.Error("Identity must not be linked from another session")
+		}
+	})
+
+	t.Run("link state can't sign in", func(t *testing.T) {
+		ctx := context.Background()
+		auth, _ := svc.LinkAuthorizationURL(ctx, alice.User.ID, alice.SessionID)
+		code := idp.authorize(t, auth.URL, jwt.MapClaims{"sub": "victim-sub"})
+		if _, err := svc.Callback(ctx, OIDCCallbackInput{Code: code, State: auth.State}); !errors.Is(err, ErrOIDCInvalidState) {
+			t.Errorf("Expected ErrOIDCInvalidState, got %v", err)
+		}
+	})
+
+	t.Run("sign-in state can't link", func(t *testing.T) {
+		ctx := context.Background()
+		auth, _ := svc.AuthorizationURL(ctx)
+		code := idp.authorize(t, auth.URL, jwt.MapClaims{"sub": "other-sub"})
+		if _, err := svc.CompleteLink(ctx, OIDCCallbackInput{Code: code, State: auth.State}, alice.User.ID, alice.SessionID); !errors.Is(err, ErrOIDCInvalidState) {
+			t.Errorf("Expected ErrOIDCInvalidState, got %v", err)
+		}
+	})
+
+	t.Run("no provisioning when disabled", func(t *testing.T) {
+		strict := newTestOIDCService(idp, "")
+		strict.config.AutoProvision = false
+		if _, err := oidcLogin(t, strict, idp, jwt.MapClaims{"sub": "stranger"}); !errors.Is(err, ErrOIDCNoAccount) {
+			t.Errorf("Expected ErrOIDCNoAccount, got %v", err)
+		}
+	})
+}
+
+func TestOIDCService_LinkByEmail(t *testing.T) {
+	cleanup := setupAuthTestDB(t)
+	defer cleanup()
+
+	idp := newMockIdP(t, "messenger")
+	svc := newTestOIDCService(idp, "")
+	svc.config.LinkByEmail = true
+	authSvc := NewAuthService()
+
+	alice, _ := authSvc.Register(RegisterInput{Username: "alice", Password: "password123"})
+	bob, _ := authSvc.Register(RegisterInput{Username: "bob", Password: "password123"})
+	now := time.Now()
+	database.DB.Model(&models.User{}).Where("id = ?", alice.User.ID).
+		Updates(map[string]interface{}{"email": "alice@example.com", "email_verified_at": now})
+	database.DB.Model(&models.User{}).Where("id = ?", bob.User.ID).Update("email", "bob@example.com")
+
+	t.Run("unverified claim is not linked", func(t *testing.T) {
+		resp, err := oidcLogin(t, svc, idp, jwt.MapClaims{"sub": "unverified-sub", "email": "alice@example.com", "email_verified": false})
+		if err != nil {
+			t.Fatalf("Callback failed: %v", err)
+		}
+		if resp.User.ID == alice.User.ID {
+			t.Error("An email the provider hasn't verified must not link")
+		}
+	})
+
+	t.Run("unverified local email is not linked", func(t *testing.T) {
+		resp, err := oidcLogin(t, svc, idp, jwt.MapClaims{"sub": "bob-sub", "email": "bob@example.com", "email_verified": true})
+		if err != nil {
+			t.Fatalf("Callback failed: %v", err)
+		}
+		if resp.User.ID == bob.User.ID {
+			t.Error("A local email nobody verified must not link")
+		}
+	})
+
+	t.Run("verified on both sides links", func(t *testing.T) {
+		resp, err := oidcLogin(t, svc, idp, jwt.MapClaims{"sub": "alice-sub", "email": "Alice@Example.com", "email_verified": true})
+		if err != nil {
+			t.Fatalf("Callback failed: %v", err)
+		}
+		if resp.User.ID != alice.User.ID {
+			t.Fatalf("Expected sign-in as alice, got %s", resp.User.Username)
+		}
+		if identity, _ := models.GetExternalIdentity(database.DB, idp.server.URL, "alice-sub"); identity == nil || identity.UserID != alice.User.ID {
+			t.Error("Identity should be linked to alice")
+		}
+	})
+
+	t.Run("a second subject is not linked to a linked user", func(t *testing.T) {
+		resp, err := oidcLogin(t, svc, idp, jwt.MapClaims{"sub": "other-alice-sub", "email": "alice@example.com", "email_verified": true})
+		if err != nil {
+			t.Fatalf("Callback failed: %v", err)
+		}
+		if resp.User.ID == alice.User.ID {
+			t.Error("A user already linked to this provider must not gain another identity by email")
+		}
+	})
+}
+
+func TestOIDCService_RoleMapping(t *testing.T) {
+	cleanup := setupAuthTestDB(t)
+	defer cleanup()
+
+	idp := newMockIdP(t, "messenger")
+	svc := newTestOIDCService(idp, "staff:moderator,it-admins:admin,bogus:superuser")
+
+	resp, err := oidcLogin(t, svc, idp, jwt.MapClaims{"sub": "staffer", "groups": []string{"staff", "it-admins"}})
+	if err != nil {
+		t.Fatalf("Callback failed: %v", err)
+	}
+	user, _ := GetUserByID(resp.User.ID)
+	if user.Role != models.UserRoleAdmin {
+		t.Errorf("Expected highest mapped role admin, got %s", user.Role)
+	}
+
+	oidcLogin(t, svc, idp, jwt.MapClaims{"sub": "staffer", "groups": []string{"staff"}})
+	user, _ = GetUserByID(resp.User.ID)
+	if user.Role != models.UserRoleModerator {
+		t.Errorf("Expected role to follow the provider, got %s", user.Role)
+	}
+
+	oidcLogin(t, svc, idp, jwt.MapClaims{"sub": "staffer"})
+	user, _ = GetUserByID(resp.User.ID)
+	if user.Role != models.UserRoleUser {
+		t.Errorf("Expected role reset to user, got %s", user.Role)
+	}
+}
+
+func TestClaimStrings(t *testing.T) {
+	claims := map[string]interface{}{
+		"roles":        "a b,c",
+		"realm_access": map[string]interface{}{"roles": []interface{}{"x", "y", 3}},
+	}
+	if got := claimStrings(claims, "roles"); len(got) != 3 {
+		t.Errorf("Expected 3 roles, got %v", got)
+	}
+	if got := claimStrings(claims, "realm_access.roles"); len(got) != 2 || got[1] != "y" {
+		t.Errorf("Expected nested roles, got %v", got)
+	}
+	if got := claimStrings(claims, "missing.path"); got != nil {
+		t.Errorf("Expected nil, got %v", got)
+	}
+}
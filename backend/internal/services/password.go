@@ -0,0 +1,373 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+	"messenger/internal/database"
+	"messenger/internal/models"
+)
+
+// MinPasswordLength is the shortest password accepted
+const MinPasswordLength = 6
+
+// Password reset defaults, each overridable via environment
+const (
+	DefaultPasswordResetTTL        = 30 * time.Minute
+	DefaultPasswordResetMaxPerHour = 3
+)
+
+// passwordResetDeliveryTimeout bounds a background reset delivery
+const passwordResetDeliveryTimeout = 30 * time.Second
+
+var (
+	ErrWeakPassword      = fmt.Errorf("password must be at least %d characters", MinPasswordLength)
+	ErrInvalidResetToken = errors.New("invalid or expired reset token")
+	ErrNoResetChannel    = errors.New("no way to deliver a reset token to this account")
+	ErrSamePassword      = errors.New("new password must be different from the current password")
+
+	ErrPasswordResetNotConfigured = errors.New("password reset delivery is not configured")
+)
+
+// ValidatePassword checks a new password against the password policy
+func ValidatePassword(password string) error {
+	if len(password) < MinPasswordLength {
+		return ErrWeakPassword
+	}
+	return nil
+}
+
+// PasswordResetNotifier delivers password reset tokens to users.
+// Implement this interface to send resets by email or another channel.
+type PasswordResetNotifier interface {
+	// Name returns the notifier name (e.g., "log", "sms")
+	Name() string
+
+	// SendPasswordReset delivers a reset token to the user
+	SendPasswordReset(ctx context.Context, user *models.User, token string, expiresAt time.Time) error
+}
+
+var (
+	resetNotifier     PasswordResetNotifier
+	resetNotifierOnce sync.Once
+	resetNotifierMu   sync.RWMutex
+)
+
+// GetPasswordResetNotifier returns the configured notifier. PASSWORD_RESET_NOTIFIER
+// selects the implementation: "log" (default, development only) or "sms".
+func GetPasswordResetNotifier() PasswordResetNotifier {
+	resetNotifierOnce.Do(func() {
+		notifier := newPasswordResetNotifierFromEnv()
+		resetNotifierMu.Lock()
+		if resetNotifier == nil {
+			resetNotifier = notifier
+		}
+		resetNotifierMu.Unlock()
+	})
+
+	resetNotifierMu.RLock()
+	defer resetNotifierMu.RUnlock()
+	return resetNotifier
+}
+
+// SetPasswordResetNotifier replaces the notifier, e.g. with an email sender or in tests.
+// Passing nil restores the notifier configured by the environment.
+func SetPasswordResetNotifier(notifier PasswordResetNotifier) {
+	resetNotifierOnce.Do(func() {})
+	if notifier == nil {
+		notifier = newPasswordResetNotifierFromEnv()
+	}
+	resetNotifierMu.Lock()
+	defer resetNotifierMu.Unlock()
+	resetNotifier = notifier
+}
+
+// newPasswordResetNotifierFromEnv reads PASSWORD_RESET_NOTIFIER. The log
+// notifier writes reset tokens to the server log, so outside development
+// password resets are disabled instead.
+func newPasswordResetNotifierFromEnv() PasswordResetNotifier {
+	switch strings.ToLower(getEnvOrDefault("PASSWORD_RESET_NOTIFIER", "log")) {
+	case "sms":
+		return NewSMSResetNotifier(nil)
+	default:
+		if !IsDevelopment() {
+			log.Println("Warning: No password reset notifier configured - password resets are disabled. Set PASSWORD_RESET_NOTIFIER=sms, or APP_ENV=development to log tokens")
+			return disabledResetNotifier{}
+		}
+		log.Println("Warning: Using log password reset notifier - reset tokens are written to the server log")
+		return NewLogResetNotifier()
+	}
+}
+
+// disabledResetNotifier refuses every reset
+type disabledResetNotifier struct{}
+
+func (disabledResetNotifier) Name() string {
+	return "disabled"
+}
+
+func (disabledResetNotifier) SendPasswordReset(ctx context.Context, user *models.User, token string, expiresAt time.Time) error {
+	return ErrPasswordResetNotConfigured
+}
+
+// resetLink formats the token as a link if PASSWORD_RESET_URL is set
+func resetLink(token string) string {
+	base := getEnvOrDefault("PASSWORD_RESET_URL", "")
+	if base == "" {
+		return token
+	}
+	separator := "?"
+	if strings.Contains(base, "?") {
+		separator = "&"
+	}
+	return base + separator + "token=" + token
+}
+
+// PasswordResetNotice is a reset captured by LogResetNotifier
+type PasswordResetNotice struct {
+	UserID    string
+	Token     string
+	ExpiresAt time.Time
+}
+
+// LogResetNotifier writes reset tokens to the log and keeps them in memory.
+// Use for development and tests only.
+type LogResetNotifier struct {
+	mu      sync.Mutex
+	notices []PasswordResetNotice
+	quiet   bool
+}
+
+// NewLogResetNotifier creates a logging reset notifier
+func NewLogResetNotifier() *LogResetNotifier {
+	return &LogResetNotifier{}
+}
+
+// NewMemoryResetNotifier creates a notifier that only records resets in memory
+func NewMemoryResetNotifier() *LogResetNotifier {
+	return &LogResetNotifier{quiet: true}
+}
+
+func (n *LogResetNotifier) Name() string {
+	return "log"
+}
+
+func (n *LogResetNotifier) SendPasswordReset(ctx context.Context, user *models.User, token string, expiresAt time.Time) error {
+	n.mu.Lock()
+	n.notices = append(n.notices, PasswordResetNotice{UserID: user.ID, Token: token, ExpiresAt: expiresAt})
+	n.mu.Unlock()
+
+	if !n.quiet {
+		log.Printf("[Password reset] user=%s link=%s expires=%s", user.Username, resetLink(token), expiresAt.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// LastToken returns the most recent reset token sent to a user
+func (n *LogResetNotifier) LastToken(userID string) (string, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	for i := len(n.notices) - 1; i >= 0; i-- {
+		if n.notices[i].UserID == userID {
+			return n.notices[i].Token, true
+		}
+	}
+	return "", false
+}
+
+// Count returns how many resets were sent
+func (n *LogResetNotifier) Count() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return len(n.notices)
+}
+
+// SMSResetNotifier texts the reset token to the user's verified phone number
+type SMSResetNotifier struct {
+	sender SMSSender // nil uses GetSMSSender()
+}
+
+// NewSMSResetNotifier creates an SMS reset notifier
+func NewSMSResetNotifier(sender SMSSender) *SMSResetNotifier {
+	return &SMSResetNotifier{sender: sender}
+}
+
+func (n *SMSResetNotifier) Name() string {
+	return "sms"
+}
+
+func (n *SMSResetNotifier) SendPasswordReset(ctx context.Context, user *models.User, token string, expiresAt time.Time) error {
+	if !user.HasVerifiedPhone() {
+		return ErrNoResetChannel
+	}
+
+	sender := n.sender
+	if sender == nil {
+		sender = GetSMSSender()
+	}
+
+	message := fmt.Sprintf("Reset your password: %s (valid for %d minutes). If you didn't ask for this, ignore this message.",
+		resetLink(token), int(time.Until(expiresAt).Round(time.Minute).Minutes()))
+	return sender.Send(ctx, *user.Phone, message)
+}
+
+// ChangePassword sets a new password after checking the current one. Every
+// other session is revoked so a stolen session can't outlive the change.
+// Returns the number of sessions revoked.
+func (s *AuthService) ChangePassword(userID, currentSessionID, currentPassword, newPassword string) (int, error) {
+	user, err := GetUserByID(userID)
+	if err != nil {
+		return 0, errors.New("user not found")
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(currentPassword)); err != nil {
+		return 0, ErrInvalidPassword
+	}
+	if err := ValidatePassword(newPassword); err != nil {
+		return 0, err
+	}
+	if currentPassword == newPassword {
+		return 0, ErrSamePassword
+	}
+
+	if err := setPassword(user, newPassword); err != nil {
+		return 0, err
+	}
+
+	return s.RevokeAllSessions(userID, currentSessionID, models.SessionRevokedPasswordChange)
+}
+
+// RequestPasswordReset issues a reset token for the account identified by
+// username or verified phone number. The lookup and delivery run in the
+// background, so the caller returns at once whether or not the account exists.
+func (s *AuthService) RequestPasswordReset(identifier, requestIP string) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), passwordResetDeliveryTimeout)
+		defer cancel()
+		s.issuePasswordReset(ctx, identifier, requestIP)
+	}()
+}
+
+// issuePasswordReset does the work behind RequestPasswordReset. Unknown
+// accounts go through the same token generation and rate limit query as
+// known ones, and unknown accounts, rate-limited accounts and delivery
+// failures are only logged.
+func (s *AuthService) issuePasswordReset(ctx context.Context, identifier, requestIP string) {
+	token, err := generateOpaqueToken()
+	if err != nil {
+		log.Printf("Failed to generate password reset token: %v", err)
+		return
+	}
+
+	user := findResetAccount(identifier)
+	userID := ""
+	if user != nil {
+		userID = user.ID
+	}
+
+	maxPerHour := intFromEnv("PASSWORD_RESET_MAX_PER_HOUR", DefaultPasswordResetMaxPerHour)
+	recent := models.CountPasswordResetTokensSince(database.DB, userID, time.Now().Add(-time.Hour))
+	if user == nil {
+		return
+	}
+	if maxPerHour > 0 && recent >= int64(maxPerHour) {
+		log.Printf("Password reset rate limit reached for user %s", user.ID)
+		return
+	}
+
+	ttl := time.Duration(intFromEnv("PASSWORD_RESET_TTL_MINUTES", int(DefaultPasswordResetTTL.Minutes()))) * time.Minute
+	record := models.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(ttl),
+		RequestIP: requestIP,
+	}
+	if err := database.DB.Create(&record).Error; err != nil {
+		log.Printf("Failed to store password reset token for user %s: %v", user.ID, err)
+		return
+	}
+
+	if err := GetPasswordResetNotifier().SendPasswordReset(ctx, user, token, record.ExpiresAt); err != nil {
+		log.Printf("Password reset delivery for user %s failed: %v", user.ID, err)
+	}
+}
+
+// ResetPassword sets a new password using a reset token. The token is consumed,
+// any other outstanding tokens are invalidated and every session is revoked.
+func (s *AuthService) ResetPassword(token, newPassword string) error {
+	if err := ValidatePassword(newPassword); err != nil {
+		return err
+	}
+
+	record, err := models.GetPasswordResetTokenByHash(database.DB, hashToken(token))
+	if err != nil || !record.IsValid() {
+		return ErrInvalidResetToken
+	}
+
+	used, err := models.MarkPasswordResetTokenUsed(database.DB, record.ID)
+	if err != nil || !used {
+		return ErrInvalidResetToken
+	}
+
+	user, err := GetUserByID(record.UserID)
+	if err != nil {
+		return ErrInvalidResetToken
+	}
+
+	if err := setPassword(user, newPassword); err != nil {
+		return err
+	}
+
+	// Whoever was guessing the old password no longer keeps the owner locked out
+	models.ClearLoginLockout(database.DB, user.ID)
+
+	_, err = s.RevokeAllSessions(user.ID, "", models.SessionRevokedPasswordReset)
+	return err
+}
+
+// findResetAccount looks up an account by username or verified phone number
+func findResetAccount(identifier string) *models.User {
+	identifier = strings.TrimSpace(identifier)
+	if identifier == "" {
+		return nil
+	}
+
+	var user models.User
+	if phone, err := NormalizePhone(identifier); err == nil {
+		if database.DB.Where("phone = ? AND phone_verified_at IS NOT NULL", phone).First(&user).Error == nil {
+			return &user
+		}
+		return nil
+	}
+
+	if database.DB.Where("username = ?", identifier).First(&user).Error == nil {
+		return &user
+	}
+	return nil
+}
+
+// setPassword hashes and stores a new password and invalidates outstanding reset tokens
+func setPassword(user *models.User, password string) error {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return errors.New("failed to hash password")
+	}
+
+	err = database.DB.Model(user).Updates(map[string]interface{}{
+		"password_hash":  string(hashed),
+		"password_unset": false,
+	}).Error
+	if err != nil {
+		return errors.New("failed to update password")
+	}
+	user.PasswordHash = string(hashed)
+	user.PasswordUnset = false
+
+	return models.InvalidatePasswordResetTokens(database.DB, user.ID)
+}
@@ -0,0 +1,214 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"messenger/internal/database"
+	"messenger/internal/models"
+)
+
+func TestAuthService_ChangePassword(t *testing.T) {
+	cleanup := setupAuthTestDB(t)
+	defer cleanup()
+
+	svc := NewAuthService()
+	current, _ := svc.Register(RegisterInput{Username: "changer", Password: "password123"})
+	other, _ := svc.Login(LoginInput{Username: "changer", Password: "password123"})
+
+	t.Run("wrong current password", func(t *testing.T) {
+		_, err := svc.ChangePassword(current.User.ID, current.SessionID, "wrong", "newpassword")
+		if !errors.Is(err, ErrInvalidPassword) {
+			t.Errorf("Expected ErrInvalidPassword, got %v", err)
+		}
+	})
+
+	t.Run("weak new password", func(t *testing.T) {
+		_, err := svc.ChangePassword(current.User.ID, current.SessionID, "password123", "abc")
+		if !errors.Is(err, ErrWeakPassword) {
+			t.Errorf("Expected ErrWeakPassword, got %v", err)
+		}
+	})
+
+	t.Run("change revokes other sessions", func(t *testing.T) {
+		revoked, err := svc.ChangePassword(current.User.ID, current.SessionID, "password123", "newpassword")
+		if err != nil {
+			t.Fatalf("ChangePassword failed: %v", err)
+		}
+		if revoked != 1 {
+			t.Errorf("Expected 1 revoked session, got %d", revoked)
+		}
+
+		if _, err := AuthenticateToken(current.AccessToken); err != nil {
+			t.Error("Current session should stay logged in")
+		}
+		if _, err := AuthenticateToken(other.AccessToken); err == nil {
+			t.Error("Other sessions should be revoked")
+		}
+
+		if _, err := svc.Login(LoginInput{Username: "changer", Password: "password123"}); err == nil {
+			t.Error("Old password should no longer work")
+		}
+		if _, err := svc.Login(LoginInput{Username: "changer", Password: "newpassword"}); err != nil {
+			t.Errorf("New password should work: %v", err)
+		}
+	})
+}
+
+func TestAuthService_PasswordReset(t *testing.T) {
+	cleanup := setupAuthTestDB(t)
+	defer cleanup()
+
+	notifier := NewMemoryResetNotifier()
+	SetPasswordResetNotifier(notifier)
+	defer SetPasswordResetNotifier(nil)
+
+	svc := NewAuthService()
+	resp, _ := svc.Register(RegisterInput{Username: "forgetful", Password: "password123"})
+	ctx := context.Background()
+
+	t.Run("unknown account succeeds silently", func(t *testing.T) {
+		svc.issuePasswordReset(ctx, "nobody", "127.0.0.1")
+		if notifier.Count() != 0 {
+			t.Error("Nothing should be sent for an unknown account")
+		}
+	})
+
+	svc.issuePasswordReset(ctx, "forgetful", "127.0.0.1")
+	token, ok := notifier.LastToken(resp.User.ID)
+	if !ok {
+		t.Fatal("Reset token should be delivered")
+	}
+
+	t.Run("token is stored hashed", func(t *testing.T) {
+		var record models.PasswordResetToken
+		database.DB.Where("user_id = ?", resp.User.ID).First(&record)
+		if record.TokenHash == token || record.TokenHash != hashToken(token) {
+			t.Error("Token should be stored as a SHA-256 hash")
+		}
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		if err := svc.ResetPassword("bogus", "newpassword"); !errors.Is(err, ErrInvalidResetToken) {
+			t.Errorf("Expected ErrInvalidResetToken, got %v", err)
+		}
+	})
+
+	t.Run("reset sets password and revokes all sessions", func(t *testing.T) {
+		if err := svc.ResetPassword(token, "resetpassword"); err != nil {
+			t.Fatalf("ResetPassword failed: %v", err)
+		}
+		if _, err := AuthenticateToken(resp.AccessToken); err == nil {
+			t.Error("All sessions should be revoked")
+		}
+		if _, err := svc.Login(LoginInput{Username: "forgetful", Password: "resetpassword"}); err != nil {
+			t.Errorf("New password should work: %v", err)
+		}
+	})
+
+	t.Run("token is single use", func(t *testing.T) {
+		if err := svc.ResetPassword(token, "anotherpassword"); !errors.Is(err, ErrInvalidResetToken) {
+			t.Errorf("Expected ErrInvalidResetToken, got %v", err)
+		}
+	})
+
+	t.Run("expired token", func(t *testing.T) {
+		database.DB.Where("user_id = ?", resp.User.ID).Delete(&models.PasswordResetToken{})
+		svc.issuePasswordReset(ctx, "forgetful", "127.0.0.1")
+		expired, _ := notifier.LastToken(resp.User.ID)
+		database.DB.Model(&models.PasswordResetToken{}).Where("user_id = ?", resp.User.ID).
+			Update("expires_at", time.Now().Add(-time.Minute))
+
+		if err := svc.ResetPassword(expired, "anotherpassword"); !errors.Is(err, ErrInvalidResetToken) {
+			t.Errorf("Expected ErrInvalidResetToken, got %v", err)
+		}
+	})
+}
+
+func TestAuthService_RequestPasswordResetIsAsync(t *testing.T) {
+	cleanup := setupAuthTestDB(t)
+	defer cleanup()
+
+	notifier := NewMemoryResetNotifier()
+	SetPasswordResetNotifier(notifier)
+	defer SetPasswordResetNotifier(nil)
+
+	svc := NewAuthService()
+	resp, _ := svc.Register(RegisterInput{Username: "background", Password: "password123"})
+
+	svc.RequestPasswordReset("background", "127.0.0.1")
+
+	deadline := time.Now().Add(5 * time.Second)
+	_, ok := notifier.LastToken(resp.User.ID)
+	for !ok && time.Now().Before(deadline) {
+		time.Sleep(20 * time.Millisecond)
+		_, ok = notifier.LastToken(resp.User.ID)
+	}
+	if !ok {
+		t.Error("Reset token should be delivered in the background")
+	}
+}
+
+func TestAuthService_PasswordResetRateLimit(t *testing.T) {
+	cleanup := setupAuthTestDB(t)
+	defer cleanup()
+
+	notifier := NewMemoryResetNotifier()
+	SetPasswordResetNotifier(notifier)
+	defer SetPasswordResetNotifier(nil)
+
+	svc := NewAuthService()
+	svc.Register(RegisterInput{Username: "spammed", Password: "password123"})
+
+	for i := 0; i < DefaultPasswordResetMaxPerHour+2; i++ {
+		svc.issuePasswordReset(context.Background(), "spammed", "127.0.0.1")
+	}
+
+	if notifier.Count() != DefaultPasswordResetMaxPerHour {
+		t.Errorf("Expected %d resets delivered, got %d", DefaultPasswordResetMaxPerHour, notifier.Count())
+	}
+}
+
+func TestSMSResetNotifier(t *testing.T) {
+	sender := NewMemorySMSSender()
+	notifier := NewSMSResetNotifier(sender)
+
+	phone := "+14155550188"
+	now := time.Now()
+	verified := &models.User{ID: "u1", Username: "verified", Phone: &phone, PhoneVerifiedAt: &now}
+	unverified := &models.User{ID: "u2", Username: "unverified", Phone: &phone}
+
+	if err := notifier.SendPasswordReset(context.Background(), unverified, "token", time.Now().Add(time.Hour)); !errors.Is(err, ErrNoResetChannel) {
+		t.Errorf("Expected ErrNoResetChannel for unverified phone, got %v", err)
+	}
+
+	if err := notifier.SendPasswordReset(context.Background(), verified, "token123", time.Now().Add(30*time.Minute)); err != nil {
+		t.Fatalf("SendPasswordReset failed: %v", err)
+	}
+	msg, ok := sender.LastMessage(phone)
+	if !ok || !strings.Contains(msg.Message, "token123") {
+		t.Errorf("Expected SMS containing the token, got %q", msg.Message)
+	}
+}
+
+func TestNewPasswordResetNotifierFromEnv(t *testing.T) {
+	t.Setenv("PASSWORD_RESET_NOTIFIER", "log")
+
+	t.Setenv("APP_ENV", "development")
+	if _, ok := newPasswordResetNotifierFromEnv().(*LogResetNotifier); !ok {
+		t.Error("Expected the log notifier in development")
+	}
+
+	t.Setenv("APP_ENV", "production")
+	notifier := newPasswordResetNotifierFromEnv()
+	if _, ok := notifier.(*LogResetNotifier); ok {
+		t.Fatal("Reset tokens must not be logged outside development")
+	}
+	err := notifier.SendPasswordReset(context.Background(), &models.User{ID: "user-1"}, "token", time.Now().Add(time.Hour))
+	if !errors.Is(err, ErrPasswordResetNotConfigured) {
+		t.Errorf("Expected ErrPasswordResetNotConfigured, got %v", err)
+	}
+}
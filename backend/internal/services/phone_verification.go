@@ -0,0 +1,365 @@
+package services
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"messenger/internal/database"
+	"messenger/internal/models"
+)
+
+// OTP defaults, each overridable via environment
+const (
+	DefaultOTPCodeTTL        = 5 * time.Minute
+	DefaultOTPMaxAttempts    = 5
+	DefaultOTPResendInterval = time.Minute
+	DefaultOTPMaxPerHour     = 5
+
+	otpDigits = 6
+
+	// phoneLoginDeliveryTimeout bounds a background login code delivery
+	phoneLoginDeliveryTimeout = 30 * time.Second
+)
+
+var (
+	ErrInvalidPhone       = errors.New("invalid phone number, use international format (e.g. +14155550123)")
+	ErrPhoneTaken         = errors.New("phone number is already verified by another account")
+	ErrInvalidOTP         = errors.New("invalid or expired code")
+	ErrOTPTooManyAttempts = errors.New("too many attempts, request a new code")
+	ErrOTPRateLimited     = errors.New("too many codes requested, try again later")
+	ErrPhoneLoginDisabled = errors.New("phone login is disabled")
+	ErrSMSDeliveryFailed  = errors.New("failed to send SMS")
+)
+
+// NormalizePhone converts a phone number to E.164 (+ followed by 8-15 digits),
+// stripping common formatting characters
+func NormalizePhone(phone string) (string, error) {
+	phone = strings.TrimSpace(phone)
+	if !strings.HasPrefix(phone, "+") {
+		return "", ErrInvalidPhone
+	}
+
+	var digits strings.Builder
+	for _, r := range phone[1:] {
+		switch {
+		case r >= '0' && r <= '9':
+			digits.WriteRune(r)
+		case r == ' ' || r == '-' || r == '(' || r == ')' || r == '.':
+			continue
+		default:
+			return "", ErrInvalidPhone
+		}
+	}
+
+	if digits.Len() < 8 || digits.Len() > 15 || strings.HasPrefix(digits.String(), "0") {
+		return "", ErrInvalidPhone
+	}
+	return "+" + digits.String(), nil
+}
+
+// PhoneVerificationService sends and checks SMS one-time codes
+type PhoneVerificationService struct {
+	sender         SMSSender // nil uses GetSMSSender()
+	codeTTL        time.Duration
+	maxAttempts    int
+	resendInterval time.Duration
+	maxPerHour     int
+	loginEnabled   bool
+}
+
+// NewPhoneVerificationService creates a service configured from OTP_CODE_TTL_SECONDS,
+// OTP_MAX_ATTEMPTS, OTP_RESEND_INTERVAL_SECONDS, OTP_MAX_PER_HOUR and PHONE_LOGIN_ENABLED
+func NewPhoneVerificationService() *PhoneVerificationService {
+	return &PhoneVerificationService{
+		codeTTL:        time.Duration(intFromEnv("OTP_CODE_TTL_SECONDS", int(DefaultOTPCodeTTL.Seconds()))) * time.Second,
+		maxAttempts:    intFromEnv("OTP_MAX_ATTEMPTS", DefaultOTPMaxAttempts),
+		resendInterval: time.Duration(intFromEnv("OTP_RESEND_INTERVAL_SECONDS", int(DefaultOTPResendInterval.Seconds()))) * time.Second,
+		maxPerHour:     intFromEnv("OTP_MAX_PER_HOUR", DefaultOTPMaxPerHour),
+		loginEnabled:   getEnvOrDefault("PHONE_LOGIN_ENABLED", "false") == "true",
+	}
+}
+
+// NewPhoneVerificationServiceWithSender creates a service that delivers through a specific sender
+func NewPhoneVerificationServiceWithSender(sender SMSSender) *PhoneVerificationService {
+	s := NewPhoneVerificationService()
+	s.sender = sender
+	return s
+}
+
+// LoginEnabled returns whether passwordless phone login is allowed
+func (s *PhoneVerificationService) LoginEnabled() bool {
+	return s.loginEnabled
+}
+
+func (s *PhoneVerificationService) smsSender() SMSSender {
+	if s.sender != nil {
+		return s.sender
+	}
+	return GetSMSSender()
+}
+
+// otpHash keys the code hash with the server secret and binds it to the number
+// and purpose, so a leaked table can't be brute-forced offline
+func otpHash(phone string, purpose models.PhoneVerificationPurpose, code string) string {
+	mac := hmac.New(sha256.New, jwtSecret)
+	mac.Write([]byte(phone + "|" + string(purpose) + "|" + code))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func generateOTPCode() (string, error) {
+	n, err := rand.Int(rand.Reader, big.NewInt(1000000))
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", otpDigits, n.Int64()), nil
+}
+
+// sendCode issues a new code for a number and sends it
+func (s *PhoneVerificationService) sendCode(ctx context.Context, phone, userID string, purpose models.PhoneVerificationPurpose) error {
+	verification, code, err := s.issueCode(phone, userID, purpose)
+	if err != nil {
+		return err
+	}
+	return s.deliverCode(ctx, verification, code)
+}
+
+// issueCode records a new code for a number, replacing any pending one.
+// Codes for numbers no account uses are recorded but never sent, so unknown
+// numbers are rate limited exactly like known ones.
+func (s *PhoneVerificationService) issueCode(phone, userID string, purpose models.PhoneVerificationPurpose) (*models.PhoneVerification, string, error) {
+	if pending, err := models.GetPendingPhoneVerification(database.DB, phone, purpose); err == nil {
+		if time.Since(pending.CreatedAt) < s.resendInterval {
+			return nil, "", ErrOTPRateLimited
+		}
+	}
+	if s.maxPerHour > 0 && models.CountPhoneVerificationsSince(database.DB, phone, time.Now().Add(-time.Hour)) >= int64(s.maxPerHour) {
+		return nil, "", ErrOTPRateLimited
+	}
+
+	code, err := generateOTPCode()
+	if err != nil {
+		return nil, "", errors.New("failed to generate code")
+	}
+
+	if err := models.InvalidatePhoneVerifications(database.DB, phone, purpose); err != nil {
+		return nil, "", errors.New("failed to store code")
+	}
+
+	verification := models.PhoneVerification{
+		Phone:     phone,
+		UserID:    userID,
+		Purpose:   purpose,
+		CodeHash:  otpHash(phone, purpose, code),
+		ExpiresAt: time.Now().Add(s.codeTTL),
+	}
+	if err := database.DB.Create(&verification).Error; err != nil {
+		return nil, "", errors.New("failed to store code")
+	}
+	return &verification, code, nil
+}
+
+// deliverCode texts a code to its number. A code that couldn't be sent is
+// withdrawn so the user can ask for a new one straight away.
+func (s *PhoneVerificationService) deliverCode(ctx context.Context, verification *models.PhoneVerification, code string) error {
+	message := fmt.Sprintf("Your verification code is %s. It expires in %d minutes. Don't share it with anyone.",
+		code, int(s.codeTTL.Minutes()))
+	if err := s.smsSender().Send(ctx, verification.Phone, message); err != nil {
+		log.Printf("SMS delivery to %s failed: %v", verification.Phone, err)
+		models.ConsumePhoneVerification(database.DB, verification.ID)
+		if errors.Is(err, ErrSMSNotConfigured) {
+			return err
+		}
+		return ErrSMSDeliveryFailed
+	}
+	return nil
+}
+
+// checkCode verifies a code and consumes it. Each code allows a limited number
+// of guesses. If userID is set, only a code issued to that user is accepted.
+func (s *PhoneVerificationService) checkCode(phone, userID string, purpose models.PhoneVerificationPurpose, code string) (*models.PhoneVerification, error) {
+	verification, err := models.GetPendingPhoneVerification(database.DB, phone, purpose)
+	if err != nil {
+		return nil, ErrInvalidOTP
+	}
+	if userID != "" && verification.UserID != userID {
+		return nil, ErrInvalidOTP
+	}
+
+	ok, err := models.IncrementPhoneVerificationAttempts(database.DB, verification.ID, s.maxAttempts)
+	if err != nil {
+		return nil, ErrInvalidOTP
+	}
+	if !ok {
+		return nil, ErrOTPTooManyAttempts
+	}
+
+	expected := otpHash(phone, purpose, strings.TrimSpace(code))
+	if subtle.ConstantTimeCompare([]byte(expected), []byte(verification.CodeHash)) != 1 {
+		return nil, ErrInvalidOTP
+	}
+
+	consumed, err := models.ConsumePhoneVerification(database.DB, verification.ID)
+	if err != nil || !consumed {
+		return nil, ErrInvalidOTP
+	}
+	return verification, nil
+}
+
+// RequestVerification sends a code proving the user owns a phone number
+func (s *PhoneVerificationService) RequestVerification(ctx context.Context, userID, phone string) error {
+	phone, err := NormalizePhone(phone)
+	if err != nil {
+		return err
+	}
+
+	var owner models.User
+	if err := database.DB.Where("phone = ? AND phone_verified_at IS NOT NULL", phone).First(&owner).Error; err == nil && owner.ID != userID {
+		return ErrPhoneTaken
+	}
+
+	return s.sendCode(ctx, phone, userID, models.PhoneVerificationVerify)
+}
+
+// ConfirmVerification checks the code and marks the number as verified on the
+// user's account. An unverified claim on the same number by another account is removed.
+func (s *PhoneVerificationService) ConfirmVerification(userID, phone, code string) (*models.User, error) {
+	phone, err := NormalizePhone(phone)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := s.checkCode(phone, userID, models.PhoneVerificationVerify, code); err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	err = database.DB.Transaction(func(tx *gorm.DB) error {
+		var owner models.User
+		if err := tx.Where("phone = ? AND id != ?", phone, userID).First(&owner).Error; err == nil {
+			if owner.PhoneVerifiedAt != nil {
+				return ErrPhoneTaken
+			}
+			if err := tx.Model(&owner).Update("phone", nil).Error; err != nil {
+				return err
+			}
+		}
+
+		if err := tx.First(&user, "id = ?", userID).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		user.Phone = &phone
+		user.PhoneVerifiedAt = &now
+		return tx.Model(&user).Updates(map[string]interface{}{
+			"phone":             phone,
+			"phone_verified_at": now,
+		}).Error
+	})
+	if err != nil {
+		if errors.Is(err, ErrPhoneTaken) {
+			return nil, err
+		}
+		return nil, errors.New("failed to verify phone number")
+	}
+	return &user, nil
+}
+
+// RequestLoginCode sends a login code to a verified number. The response is the
+// same whether or not an account uses the number: the SMS is sent in the
+// background and delivery failures are only logged.
+func (s *PhoneVerificationService) RequestLoginCode(phone string) error {
+	if !s.loginEnabled {
+		return ErrPhoneLoginDisabled
+	}
+
+	phone, err := NormalizePhone(phone)
+	if err != nil {
+		return err
+	}
+
+	var user models.User
+	found := database.DB.Where("phone = ? AND phone_verified_at IS NOT NULL", phone).First(&user).Error == nil
+
+	verification, code, err := s.issueCode(phone, user.ID, models.PhoneVerificationLogin)
+	if err != nil || !found {
+		return err
+	}
+
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), phoneLoginDeliveryTimeout)
+		defer cancel()
+		s.deliverCode(ctx, verification, code)
+	}()
+	return nil
+}
+
+// verifyLoginCode checks a login code and returns the account that owns the
+// number. Wrong codes count towards the account's login lockout, and numbers
+// no account uses are locked out the same way.
+func (s *PhoneVerificationService) verifyLoginCode(phone, code string, meta SessionMeta) (*models.User, error) {
+	if !s.loginEnabled {
+		return nil, ErrPhoneLoginDisabled
+	}
+
+	phone, err := NormalizePhone(phone)
+	if err != nil {
+		return nil, err
+	}
+
+	var user models.User
+	found := database.DB.Where("phone = ? AND phone_verified_at IS NOT NULL", phone).First(&user).Error == nil
+	if found {
+		err = checkLoginLockout(&user, meta)
+	} else {
+		err = checkUnknownLoginLockout(phone)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	verification, err := s.checkCode(phone, "", models.PhoneVerificationLogin, code)
+	if err == nil && (!found || verification.UserID != user.ID) {
+		err = ErrInvalidOTP
+	}
+	if err != nil {
+		if found {
+			recordLoginFailure(&user, meta, models.LoginFailureInvalidSMSCode)
+		} else {
+			countLoginFailure(unknownLoginKey(phone))
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// PhoneLoginInput logs in with an SMS code instead of a password
+type PhoneLoginInput struct {
+	Phone      string `json:"phone"`
+	Code       string `json:"code"`
+	DeviceName string `json:"device_name,omitempty"`
+
+	Meta SessionMeta `json:"-"` // Filled in by the handler
+}
+
+// PhoneLogin exchanges a login code for a session. Accounts with 2FA enabled
+// still get a challenge, as for password logins.
+func (s *AuthService) PhoneLogin(input PhoneLoginInput) (*AuthResponse, error) {
+	input.Meta.DeviceName = input.DeviceName
+	user, err := s.phone.verifyLoginCode(input.Phone, input.Code, input.Meta)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.completeLogin(user, input.Meta)
+}
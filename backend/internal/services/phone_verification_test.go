@@ -0,0 +1,356 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+	"time"
+
+	"messenger/internal/database"
+	"messenger/internal/models"
+)
+
+var otpPattern = regexp.MustCompile(`\b(\d{6})\b`)
+
+// awaitSMS waits for more than sent messages to have been sent, for codes
+// delivered in the background
+func awaitSMS(t *testing.T, sender *LogSMSSender, sent int) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); len(sender.Messages()) <= sent; {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected an SMS to be sent")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// lastOTP extracts the code from the last SMS sent to a number
+func lastOTP(t *testing.T, sender *LogSMSSender, phone string) string {
+	t.Helper()
+	msg, ok := sender.LastMessage(phone)
+	if !ok {
+		t.Fatalf("No SMS sent to %s", phone)
+	}
+	match := otpPattern.FindStringSubmatch(msg.Message)
+	if match == nil {
+		t.Fatalf("No code in SMS %q", msg.Message)
+	}
+	return match[1]
+}
+
+func TestNormalizePhone(t *testing.T) {
+	tests := []struct {
+		input string
+		want  string
+		valid bool
+	}{
+		{"+14155550123", "+14155550123", true},
+		{" +1 (415) 555-0123 ", "+14155550123", true},
+		{"+44 20 7946 0958", "+442079460958", true},
+		{"4155550123", "", false},
+		{"+1415555abc", "", false},
+		{"+123", "", false},
+		{"+0123456789", "", false},
+	}
+
+	for _, tt := range tests {
+		got, err := NormalizePhone(tt.input)
+		if tt.valid && (err != nil || got != tt.want) {
+			t.Errorf("NormalizePhone(%q) = %q, %v; want %q", tt.input, got, err, tt.want)
+		}
+		if !tt.valid && !errors.Is(err, ErrInvalidPhone) {
+			t.Errorf("NormalizePhone(%q) should fail, got %q", tt.input, got)
+		}
+	}
+}
+
+func TestNewSMSSenderFromEnv(t *testing.T) {
+	t.Setenv("SMS_PROVIDER", "log")
+
+	t.Run("log sender only in development", func(t *testing.T) {
+		t.Setenv("APP_ENV", "development")
+		if _, ok := newSMSSenderFromEnv().(*LogSMSSender); !ok {
+			t.Error("Expected the log sender in development")
+		}
+	})
+
+	t.Run("disabled in production", func(t *testing.T) {
+		t.Setenv("APP_ENV", "production")
+		sender := newSMSSenderFromEnv()
+		if _, ok := sender.(*LogSMSSender); ok {
+			t.Fatal("Codes must not be logged outside development")
+		}
+		if err := sender.Send(context.Background(), "+14155550123", "code"); !errors.Is(err, ErrSMSNotConfigured) {
+			t.Errorf("Expected ErrSMSNotConfigured, got %v", err)
+		}
+	})
+
+	t.Run("misconfigured webhook is not logged either", func(t *testing.T) {
+		t.Setenv("APP_ENV", "")
+		t.Setenv("SMS_PROVIDER", "webhook")
+		t.Setenv("SMS_WEBHOOK_URL", "")
+		if _, ok := newSMSSenderFromEnv().(*LogSMSSender); ok {
+			t.Error("Codes must not be logged outside development")
+		}
+	})
+}
+
+func TestPhoneVerification_SMSNotConfigured(t *testing.T) {
+	cleanup := setupAuthTestDB(t)
+	defer cleanup()
+
+	SetSMSSender(disabledSMSSender{})
+	defer SetSMSSender(nil)
+
+	auth := NewAuthService()
+	resp, _ := auth.Register(RegisterInput{Username: "nosms", Password: "password123"})
+	err := NewPhoneVerificationService().RequestVerification(context.Background(), resp.User.ID, "+14155550170")
+	if !errors.Is(err, ErrSMSNotConfigured) {
+		t.Errorf("Expected ErrSMSNotConfigured, got %v", err)
+	}
+}
+
+func TestWebhookSMSSender(t *testing.T) {
+	var received webhookSMSPayload
+	var auth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		json.NewDecoder(r.Body).Decode(&received)
+		if received.To == "+15550000000" {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("unroutable"))
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	sender := NewWebhookSMSSenderWithURL(server.URL, "secret", "Messenger", time.Second)
+
+	if err := sender.Send(context.Background(), "+14155550123", "hello"); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if received.To != "+14155550123" || received.Message != "hello" || received.From != "Messenger" {
+		t.Errorf("Unexpected payload: %+v", received)
+	}
+	if auth != "Bearer secret" {
+		t.Errorf("Expected bearer token, got %q", auth)
+	}
+
+	if err := sender.Send(context.Background(), "+15550000000", "hello"); err == nil {
+		t.Error("Non-2xx responses should return an error")
+	}
+}
+
+func TestPhoneVerificationService_Verify(t *testing.T) {
+	cleanup := setupAuthTestDB(t)
+	defer cleanup()
+
+	sender := NewMemorySMSSender()
+	svc := NewPhoneVerificationServiceWithSender(sender)
+	auth := NewAuthService()
+	alice, _ := auth.Register(RegisterInput{Username: "alice", Password: "password123"})
+	bob, _ := auth.Register(RegisterInput{Username: "bob", Password: "password123", Phone: "+14155550123"})
+
+	t.Run("invalid number is rejected", func(t *testing.T) {
+		if err := svc.RequestVerification(context.Background(), alice.User.ID, "555-0123"); !errors.Is(err, ErrInvalidPhone) {
+			t.Errorf("Expected ErrInvalidPhone, got %v", err)
+		}
+	})
+
+	if err := svc.RequestVerification(context.Background(), alice.User.ID, "+1 415 555 0123"); err != nil {
+		t.Fatalf("RequestVerification failed: %v", err)
+	}
+	code := lastOTP(t, sender, "+14155550123")
+
+	t.Run("code is stored hashed", func(t *testing.T) {
+		var v models.PhoneVerification
+		database.DB.Where("phone = ?", "+14155550123").First(&v)
+		if v.CodeHash == code || v.CodeHash == "" {
+			t.Error("Code should be stored as a hash")
+		}
+	})
+
+	t.Run("resend is rate limited", func(t *testing.T) {
+		if err := svc.RequestVerification(context.Background(), alice.User.ID, "+14155550123"); !errors.Is(err, ErrOTPRateLimited) {
+			t.Errorf("Expected ErrOTPRateLimited, got %v", err)
+		}
+	})
+
+	t.Run("another user can't use the code", func(t *testing.T) {
+		if _, err := svc.ConfirmVerification(bob.User.ID, "+14155550123", code); !errors.Is(err, ErrInvalidOTP) {
+			t.Errorf("Expected ErrInvalidOTP, got %v", err)
+		}
+	})
+
+	t.Run("correct code verifies and takes over unverified claim", func(t *testing.T) {
+		user, err := svc.ConfirmVerification(alice.User.ID, "+14155550123", code)
+		if err != nil {
+			t.Fatalf("ConfirmVerification failed: %v", err)
+		}
+		if !user.HasVerifiedPhone() || *user.Phone != "+14155550123" {
+			t.Error("Phone should be verified")
+		}
+
+		previous, _ := GetUserByID(bob.User.ID)
+		if previous.Phone != nil {
+			t.Error("Unverified claim on the number should be removed")
+		}
+	})
+
+	t.Run("code can't be reused", func(t *testing.T) {
+		if _, err := svc.ConfirmVerification(alice.User.ID, "+14155550123", code); !errors.Is(err, ErrInvalidOTP) {
+			t.Errorf("Expected ErrInvalidOTP, got %v", err)
+		}
+	})
+
+	t.Run("verified number can't be claimed by another account", func(t *testing.T) {
+		if err := svc.RequestVerification(context.Background(), bob.User.ID, "+14155550123"); !errors.Is(err, ErrPhoneTaken) {
+			t.Errorf("Expected ErrPhoneTaken, got %v", err)
+		}
+	})
+}
+
+func TestPhoneVerificationService_AttemptLimit(t *testing.T) {
+	cleanup := setupAuthTestDB(t)
+	defer cleanup()
+
+	sender := NewMemorySMSSender()
+	svc := NewPhoneVerificationServiceWithSender(sender)
+	resp, _ := NewAuthService().Register(RegisterInput{Username: "guesser", Password: "password123"})
+
+	svc.RequestVerification(context.Background(), resp.User.ID, "+14155550199")
+	code := lastOTP(t, sender, "+14155550199")
+
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	for i := 0; i < DefaultOTPMaxAttempts; i++ {
+		if _, err := svc.ConfirmVerification(resp.User.ID, "+14155550199", wrong); !errors.Is(err, ErrInvalidOTP) {
+			t.Fatalf("Attempt %d: expected ErrInvalidOTP, got %v", i+1, err)
+		}
+	}
+
+	if _, err := svc.ConfirmVerification(resp.User.ID, "+14155550199", code); !errors.Is(err, ErrOTPTooManyAttempts) {
+		t.Errorf("Expected ErrOTPTooManyAttempts after exhausting attempts, got %v", err)
+	}
+}
+
+func TestPhoneVerificationService_Expiry(t *testing.T) {
+	cleanup := setupAuthTestDB(t)
+	defer cleanup()
+
+	sender := NewMemorySMSSender()
+	svc := NewPhoneVerificationServiceWithSender(sender)
+	resp, _ := NewAuthService().Register(RegisterInput{Username: "slowpoke", Password: "password123"})
+
+	svc.RequestVerification(context.Background(), resp.User.ID, "+14155550177")
+	code := lastOTP(t, sender, "+14155550177")
+	database.DB.Model(&models.PhoneVerification{}).Where("phone = ?", "+14155550177").
+		Update("expires_at", time.Now().Add(-time.Second))
+
+	if _, err := svc.ConfirmVerification(resp.User.ID, "+14155550177", code); !errors.Is(err, ErrInvalidOTP) {
+		t.Errorf("Expected expired code to be rejected, got %v", err)
+	}
+}
+
+func TestAuthService_PhoneLogin(t *testing.T) {
+	cleanup := setupAuthTestDB(t)
+	defer cleanup()
+
+	t.Setenv("PHONE_LOGIN_ENABLED", "true")
+	sender := NewMemorySMSSender()
+	SetSMSSender(sender)
+	defer SetSMSSender(nil)
+
+	auth := NewAuthService()
+	phoneService := NewPhoneVerificationService()
+	resp, _ := auth.Register(RegisterInput{Username: "phonelogin", Password: "password123"})
+
+	phoneService.RequestVerification(context.Background(), resp.User.ID, "+14155550150")
+	if _, err := phoneService.ConfirmVerification(resp.User.ID, "+14155550150", lastOTP(t, sender, "+14155550150")); err != nil {
+		t.Fatalf("ConfirmVerification failed: %v", err)
+	}
+
+	t.Run("unknown number gets the same response but no SMS", func(t *testing.T) {
+		if err := phoneService.RequestLoginCode("+14155550999"); err != nil {
+			t.Errorf("Expected no error for unknown number, got %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+		if _, ok := sender.LastMessage("+14155550999"); ok {
+			t.Error("No SMS should be sent to an unknown number")
+		}
+		if _, err := auth.PhoneLogin(PhoneLoginInput{Phone: "+14155550999", Code: "123456"}); !errors.Is(err, ErrInvalidOTP) {
+			t.Errorf("Expected ErrInvalidOTP, got %v", err)
+		}
+	})
+
+	t.Run("login code starts a session", func(t *testing.T) {
+		sent := len(sender.Messages())
+		if err := phoneService.RequestLoginCode("+14155550150"); err != nil {
+			t.Fatalf("RequestLoginCode failed: %v", err)
+		}
+		awaitSMS(t, sender, sent)
+
+		login, err := auth.PhoneLogin(PhoneLoginInput{Phone: "+14155550150", Code: lastOTP(t, sender, "+14155550150")})
+		if err != nil {
+			t.Fatalf("PhoneLogin failed: %v", err)
+		}
+		if login.AccessToken == "" || login.User.ID != resp.User.ID {
+			t.Error("Expected a session for the phone's owner")
+		}
+	})
+
+	t.Run("wrong codes lock the account", func(t *testing.T) {
+		for i := 0; i < DefaultLoginLockoutThreshold; i++ {
+			auth.PhoneLogin(PhoneLoginInput{Phone: "+14155550150", Code: "000000"})
+		}
+		sent := len(sender.Messages())
+		phoneService.RequestLoginCode("+14155550150")
+		awaitSMS(t, sender, sent)
+		_, err := auth.PhoneLogin(PhoneLoginInput{Phone: "+14155550150", Code: lastOTP(t, sender, "+14155550150")})
+		if !errors.Is(err, ErrAccountLocked) {
+			t.Errorf("Expected ErrAccountLocked even with the right code, got %v", err)
+		}
+	})
+
+	t.Run("unknown numbers lock the same way", func(t *testing.T) {
+		for i := 0; i < DefaultLoginLockoutThreshold; i++ {
+			auth.PhoneLogin(PhoneLoginInput{Phone: "+14155550998", Code: "000000"})
+		}
+		if _, err := auth.PhoneLogin(PhoneLoginInput{Phone: "+14155550998", Code: "000000"}); !errors.Is(err, ErrAccountLocked) {
+			t.Errorf("Expected ErrAccountLocked, got %v", err)
+		}
+	})
+
+	t.Run("delivery failures look the same", func(t *testing.T) {
+		SetSMSSender(failingSMSSender{})
+		defer SetSMSSender(sender)
+		database.DB.Where("phone = ?", "+14155550150").Delete(&models.PhoneVerification{})
+
+		if err := phoneService.RequestLoginCode("+14155550150"); err != nil {
+			t.Errorf("Expected no error when the SMS can't be sent, got %v", err)
+		}
+	})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		t.Setenv("PHONE_LOGIN_ENABLED", "")
+		if err := NewPhoneVerificationService().RequestLoginCode("+14155550150"); !errors.Is(err, ErrPhoneLoginDisabled) {
+			t.Errorf("Expected ErrPhoneLoginDisabled, got %v", err)
+		}
+	})
+}
+
+// failingSMSSender is an SMS gateway that is down
+type failingSMSSender struct{}
+
+func (failingSMSSender) Name() string { return "failing" }
+
+func (failingSMSSender) Send(ctx context.Context, to, message string) error {
+	return errors.New("gateway unavailable")
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"sync"
+	"time"
 
 	"gorm.io/gorm"
 	"messenger/internal/database"
@@ -13,10 +14,13 @@ import (
 )
 
 // PushService coordinates push notifications across multiple providers
-// It abstracts away the specific push provider (FCM, APNs, Web Push, etc.)
+// It abstracts away the specific push provider (FCM, APNs, Web Push, UnifiedPush, etc.)
 type PushService struct {
-	registry *ProviderRegistry
-	mu       sync.RWMutex
+	registry    *ProviderRegistry
+	queue       *PushQueue
+	batcher     *pushBatcher
+	diagnostics *diagnosticsCooldown
+	mu          sync.RWMutex
 }
 
 var (
@@ -27,14 +31,27 @@ var (
 // GetPushService returns the singleton push service instance
 func GetPushService() *PushService {
 	pushServiceOnce.Do(func() {
-		pushService = &PushService{
-			registry: NewProviderRegistry(),
-		}
+		pushService = newPushService()
 		pushService.initializeProviders()
 	})
 	return pushService
 }
 
+func newPushService() *PushService {
+	ps := &PushService{
+		registry: NewProviderRegistry(),
+	}
+	ps.queue = NewPushQueue(ps)
+	ps.batcher = newPushBatcher(ps)
+	ps.diagnostics = newDiagnosticsCooldown()
+	return ps
+}
+
+// Queue returns the queue that delivers this service's notifications
+func (ps *PushService) Queue() *PushQueue {
+	return ps.queue
+}
+
 // initializeProviders sets up all configured push providers
 func (ps *PushService) initializeProviders() {
 	ctx := context.Background()
@@ -59,6 +76,16 @@ func (ps *PushService) initializeProviders() {
 		}
 	}
 
+	// Initialize APNs VoIP provider for PushKit tokens, which ring incoming calls
+	if os.Getenv("APNS_BUNDLE_ID") != "" && os.Getenv("APNS_VOIP_ENABLED") != "false" {
+		voip := NewAPNsVoIPPushProvider()
+		if err := voip.Initialize(ctx); err != nil {
+			log.Printf("Warning: APNs VoIP push provider failed to initialize - %v", err)
+		} else {
+			ps.registry.Register(voip)
+		}
+	}
+
 	// Initialize Web Push provider if configured (browsers without Firebase)
 	if os.Getenv("VAPID_PUBLIC_KEY") != "" && os.Getenv("VAPID_PRIVATE_KEY") != "" {
 		webpush := NewWebPushProvider()
@@ -69,6 +96,26 @@ func (ps *PushService) initializeProviders() {
 		}
 	}
 
+	// Initialize UnifiedPush provider if enabled (Android without Google services)
+	if os.Getenv("UNIFIEDPUSH_ENABLED") != "" {
+		unifiedPush := NewUnifiedPushProvider()
+		if err := unifiedPush.Initialize(ctx); err != nil {
+			log.Printf("Warning: UnifiedPush provider failed to initialize - %v", err)
+		} else {
+			ps.registry.Register(unifiedPush)
+		}
+	}
+
+	// Initialize webhook provider if configured (self-hosted push gateways)
+	if os.Getenv("WEBHOOK_PUSH_URL") != "" {
+		webhook := NewWebhookPushProvider()
+		if err := webhook.Initialize(ctx); err != nil {
+			log.Printf("Warning: Webhook push provider failed to initialize - %v", err)
+		} else {
+			ps.registry.Register(webhook)
+		}
+	}
+
 	enabledCount := len(ps.registry.GetEnabled())
 	if enabledCount == 0 {
 		log.Println("Warning: No push providers configured - push notifications disabled")
@@ -91,6 +138,17 @@ func (ps *PushService) GetProvider(name string) (PushProvider, bool) {
 	return ps.registry.Get(name)
 }
 
+// enabledProviderNames returns the names of the providers that can send
+func (ps *PushService) enabledProviderNames() []string {
+	ps.mu.RLock()
+	defer ps.mu.RUnlock()
+	var names []string
+	for _, p := range ps.registry.GetEnabled() {
+		names = append(names, p.Name())
+	}
+	return names
+}
+
 // IsEnabled returns whether any push provider is available
 func (ps *PushService) IsEnabled() bool {
 	ps.mu.RLock()
@@ -98,7 +156,8 @@ func (ps *PushService) IsEnabled() bool {
 	return len(ps.registry.GetEnabled()) > 0
 }
 
-// SendToUser sends a push notification to all devices of a user
+// SendToUser queues a push notification for all devices of a user. Delivery
+// happens in the background through the push queue, with retries.
 func (ps *PushService) SendToUser(userID string, notification *Notification) error {
 	if !ps.IsEnabled() {
 		return nil // Silently skip if push is not configured
@@ -109,48 +168,30 @@ func (ps *PushService) SendToUser(userID string, notification *Notification) err
 		return fmt.Errorf("failed to get user tokens: %w", err)
 	}
 
+	tokens = messageTokens(tokens)
 	if len(tokens) == 0 {
 		return nil // User has no registered devices
 	}
 
-	// Group tokens by platform/provider
-	tokensByPlatform := make(map[models.DevicePlatform][]string)
-	for _, t := range tokens {
-		platform := t.Platform
-		if platform == "" {
-			platform = models.PlatformAndroid // Default
-		}
-		tokensByPlatform[platform] = append(tokensByPlatform[platform], t.Token)
-	}
+	return ps.enqueueForTokens(userID, tokens, notification.localized(UserLocale(userID)))
+}
 
-	// Send to each platform's provider
+// enqueueForTokens queues a job for each provider the tokens route to
+func (ps *PushService) enqueueForTokens(userID string, tokens []models.DeviceToken, notification *Notification) error {
 	var lastErr error
-	for platform, platformTokens := range tokensByPlatform {
-		provider, ok := ps.registry.Get(mapPlatformToProvider(string(platform)))
-		if !ok || !provider.IsEnabled() {
-			// Fall back to FCM for unknown platforms
-			provider, ok = ps.registry.Get("fcm")
-			if !ok || !provider.IsEnabled() {
-				continue
-			}
-		}
-
-		failedTokens, err := provider.Send(context.Background(), platformTokens, notification)
-		if err != nil {
-			log.Printf("Push provider %s error: %v", provider.Name(), err)
+	for name, providerTokens := range ps.routeTokens(tokens) {
+		if err := ps.queue.Enqueue(userID, name, providerTokens, notification); err != nil {
+			log.Printf("Push provider %s error: %v", name, err)
 			lastErr = err
 		}
-
-		// Clean up invalid tokens
-		for _, token := range failedTokens {
-			models.UnregisterToken(database.DB, token)
-		}
 	}
 
 	return lastErr
 }
 
-// SendToTokens sends a push notification to specific device tokens
+// SendToTokens sends a push notification to specific device tokens right
+// away, without queueing or retries. Each token goes to the provider it was
+// registered with.
 func (ps *PushService) SendToTokens(tokens []string, notification *Notification) error {
 	if !ps.IsEnabled() {
 		return nil
@@ -160,61 +201,97 @@ func (ps *PushService) SendToTokens(tokens []string, notification *Notification)
 		return nil
 	}
 
-	// Use the first available provider (typically FCM)
-	providers := ps.registry.GetEnabled()
-	if len(providers) == 0 {
+	deviceTokens, err := models.GetTokensByValue(database.DB, tokens)
+	if err != nil {
+		return fmt.Errorf("failed to get device tokens: %w", err)
+	}
+	routes := ps.routeTokens(messageTokens(deviceTokens))
+	if len(routes) == 0 {
 		return ErrNoProvidersAvailable
 	}
 
-	provider := providers[0]
-	failedTokens, err := provider.Send(context.Background(), tokens, notification)
-	if err != nil {
-		return err
-	}
+	_, err = ps.sendRoutes(routes, notification, false)
+	return err
+}
+
+// sendRoutes sends a notification to routed tokens right away and records an
+// attempt per device. Returns the attempts by token and the last send error.
+func (ps *PushService) sendRoutes(routes map[string][]string, notification *Notification, diagnostic bool) (map[string]models.PushAttempt, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), DefaultPushSendTimeout)
+	defer cancel()
+
+	attempts := make(map[string]models.PushAttempt)
+	var lastErr error
+	for name, providerTokens := range routes {
+		provider, _ := ps.GetProvider(name)
+		start := time.Now()
+		failedTokens, err := provider.Send(ctx, providerTokens, notification)
+		duration := time.Since(start)
+		ps.queue.metrics.sent(name, duration, err)
+		for token, attempt := range ps.recordAttempts(pushSend{
+			provider:     name,
+			tokens:       providerTokens,
+			notification: notification,
+			invalid:      failedTokens,
+			err:          err,
+			latency:      duration,
+			final:        true, // Nothing is retried
+			diagnostic:   diagnostic,
+		}) {
+			attempts[token] = attempt
+		}
+
+		// Clean up invalid tokens
+		for _, token := range failedTokens {
+			models.UnregisterToken(database.DB, token)
+		}
+		if len(failedTokens) > 0 {
+			ps.queue.metrics.invalidTokens(name, len(failedTokens))
+		}
 
-	// Clean up invalid tokens
-	for _, token := range failedTokens {
-		models.UnregisterToken(database.DB, token)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		ps.queue.metrics.delivered(name, duration)
 	}
 
-	return nil
+	return attempts, lastErr
 }
 
-// SendTestNotification sends a test notification to a specific token
-func (ps *PushService) SendTestNotification(token string) error {
+// SendTestNotification sends a test notification to a specific token, in
+// the given locale
+func (ps *PushService) SendTestNotification(token, locale string) error {
 	if !ps.IsEnabled() {
 		return fmt.Errorf("push notifications not enabled")
 	}
 
-	return ps.SendToTokens([]string{token}, &Notification{
-		Title: "Test Notification",
-		Body:  "Push notifications are working!",
-		Data:  map[string]string{"type": "test"},
-	})
+	return ps.SendToTokens([]string{token}, NewTestNotification().localized(locale))
 }
 
-// mapPlatformToProvider maps device platform to push provider name
-func mapPlatformToProvider(platform string) string {
-	switch platform {
-	case "ios":
-		return "fcm" // FCM handles APNs routing
-	case "android":
-		return "fcm"
-	case "web":
-		return "fcm" // Could be "webpush" if that provider is implemented
-	default:
-		return "fcm"
-	}
+// PushMessageToOfflineUser is a helper to queue a push notification for a
+// message when the recipient is not connected via WebSocket
+func PushMessageToOfflineUser(db *gorm.DB, recipientID string, senderID string, messageID string, content string, isGroup bool, conversationID string) {
+	GetPushService().pushMessage(db, recipientID, senderID, messageID, content, isGroup, conversationID)
 }
 
-// PushMessageToOfflineUser is a helper to send push notification for a message
-// when the recipient is not connected via WebSocket
-func PushMessageToOfflineUser(db *gorm.DB, recipientID string, senderID string, content string, isGroup bool, conversationID string) {
-	pushSvc := GetPushService()
-	if !pushSvc.IsEnabled() {
+func (ps *PushService) pushMessage(db *gorm.DB, recipientID string, senderID string, messageID string, content string, isGroup bool, conversationID string) {
+	if !ps.IsEnabled() {
 		return
 	}
 
+	push := MessagePush{RecipientID: recipientID, SenderID: senderID, Content: content}
+	if isGroup {
+		push.GroupID = conversationID
+	}
+	decision := EvaluateMessagePush(push, time.Now())
+	if !decision.Send {
+		return
+	}
+	if decision.HidePreview {
+		content = HiddenPreviewBody
+	}
+
 	// Get sender info
 	var sender models.User
 	if err := db.First(&sender, "id = ?", senderID).Error; err != nil {
@@ -227,8 +304,46 @@ func PushMessageToOfflineUser(db *gorm.DB, recipientID string, senderID string,
 		senderName = sender.Username
 	}
 
+	// Later messages in a burst are held back and summarised
+	threadID := conversationThreadID(senderID, isGroup, conversationID)
+	burst := &models.PushBurst{
+		RecipientID:    recipientID,
+		ThreadID:       threadID,
+		Title:          senderName,
+		ConversationID: conversationID,
+		SenderID:       senderID,
+	}
+	if isGroup {
+		var group models.Group
+		if err := db.Select("name").First(&group, "id = ?", conversationID).Error; err == nil {
+			burst.Title = group.Name
+		}
+		burst.GroupID = &conversationID
+	}
+	if !ps.batcher.admit(burst) {
+		return
+	}
+
 	notification := NewMessageNotification(senderName, content, conversationID, isGroup)
-	if err := pushSvc.SendToUser(recipientID, notification); err != nil {
+	if decision.HidePreview {
+		notification.BodyLocKey = "push_new_message"
+	}
+	notification.ThreadID = threadID
+	notification.CollapseID = threadID
+	notification.Badge = unreadBadge(recipientID)
+	if messageID != "" {
+		notification.Data["message_id"] = messageID // Lets the app fetch and decrypt the message itself
+	}
+	if err := ps.sendMessagePush(recipientID, notification, decision.Private); err != nil {
 		log.Printf("Failed to send push notification: %v", err)
 	}
 }
+
+// sendMessagePush queues a message notification, content-free if the
+// recipient turned on private pushes
+func (ps *PushService) sendMessagePush(userID string, notification *Notification, private bool) error {
+	if private {
+		return ps.SendPrivateToUser(userID, notification)
+	}
+	return ps.SendToUser(userID, notification)
+}
@@ -5,8 +5,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
 	"os"
 	"sync"
+	"time"
 
 	"github.com/sideshow/apns2"
 	"github.com/sideshow/apns2/certificate"
@@ -18,6 +20,7 @@ import (
 type APNsPushProvider struct {
 	client   *apns2.Client
 	bundleID string
+	voip     bool // Sends PushKit VoIP pushes to the app's ".voip" topic
 	mu       sync.RWMutex
 }
 
@@ -26,8 +29,19 @@ func NewAPNsPushProvider() *APNsPushProvider {
 	return &APNsPushProvider{}
 }
 
+// NewAPNsVoIPPushProvider creates an APNs provider for PushKit VoIP tokens,
+// which wake the app straight into CallKit for incoming calls. It uses the
+// same credentials as the regular APNs provider; a token key works for both
+// topics, a certificate must be a VoIP Services certificate.
+func NewAPNsVoIPPushProvider() *APNsPushProvider {
+	return &APNsPushProvider{voip: true}
+}
+
 func (p *APNsPushProvider) Name() string {
-	return "apns"
+	if p.voip {
+		return ProviderAPNsVoIP
+	}
+	return ProviderAPNs
 }
 
 func (p *APNsPushProvider) Initialize(ctx context.Context) error {
@@ -36,6 +50,9 @@ func (p *APNsPushProvider) Initialize(ctx context.Context) error {
 		return fmt.Errorf("APNS_BUNDLE_ID not set")
 	}
 	p.bundleID = bundleID
+	if p.voip {
+		p.bundleID = bundleID + ".voip"
+	}
 
 	// Try token-based auth first (recommended)
 	keyPath := os.Getenv("APNS_KEY_PATH")
@@ -81,7 +98,7 @@ func (p *APNsPushProvider) initializeWithToken(keyPath, keyID, teamID string) er
 	p.client = client
 	p.mu.Unlock()
 
-	log.Println("APNs push provider initialized with token auth")
+	log.Printf("%s push provider initialized with token auth", p.Name())
 	return nil
 }
 
@@ -103,7 +120,7 @@ func (p *APNsPushProvider) initializeWithCertificate(certPath, password string)
 	p.client = client
 	p.mu.Unlock()
 
-	log.Println("APNs push provider initialized with certificate auth")
+	log.Printf("%s push provider initialized with certificate auth", p.Name())
 	return nil
 }
 
@@ -130,7 +147,8 @@ func (p *APNsPushProvider) Send(ctx context.Context, tokens []string, notificati
 	client := p.client
 	p.mu.RUnlock()
 
-	var failedTokens []string
+	var failedTokens, retryTokens []string
+	var retryErr error
 	var successCount, failureCount int
 
 	for _, deviceToken := range tokens {
@@ -140,15 +158,31 @@ func (p *APNsPushProvider) Send(ctx context.Context, tokens []string, notificati
 			DeviceToken: deviceToken,
 			Topic:       p.bundleID,
 			Payload:     payload,
+			CollapseID:  notification.CollapseID,
 		}
 
 		// Set push type for iOS 13+
 		apnsNotification.PushType = apns2.PushTypeAlert
+		switch {
+		case p.voip:
+			apnsNotification.PushType = apns2.PushTypeVOIP
+			apnsNotification.Priority = apns2.PriorityHigh
+		case notification.Silent:
+			apnsNotification.PushType = apns2.PushTypeBackground
+			apnsNotification.Priority = apns2.PriorityLow
+		case notification.Call:
+			apnsNotification.Priority = apns2.PriorityHigh
+		}
+		if notification.TTL > 0 {
+			apnsNotification.Expiration = time.Now().Add(notification.TTL)
+		}
 
-		resp, err := client.Push(apnsNotification)
+		resp, err := client.PushWithContext(ctx, apnsNotification)
 		if err != nil {
 			log.Printf("APNs: Failed to send to device: %v", err)
 			failureCount++
+			retryTokens = append(retryTokens, deviceToken)
+			retryErr = err
 			continue
 		}
 
@@ -161,44 +195,72 @@ func (p *APNsPushProvider) Send(ctx context.Context, tokens []string, notificati
 			// Check if token is invalid
 			if p.isInvalidTokenReason(resp.Reason) {
 				failedTokens = append(failedTokens, deviceToken)
+			} else if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+				retryTokens = append(retryTokens, deviceToken)
+				retryErr = fmt.Errorf("apns status %d: %s", resp.StatusCode, resp.Reason)
 			}
 		}
 	}
 
 	log.Printf("APNs: %d success, %d failures", successCount, failureCount)
+	if len(retryTokens) > 0 {
+		return failedTokens, &RetryableTokensError{Tokens: retryTokens, Err: retryErr}
+	}
 	return failedTokens, nil
 }
 
 func (p *APNsPushProvider) buildPayload(notification *Notification) []byte {
+	if p.voip {
+		return voipPayload(notification)
+	}
+
+	alert := map[string]interface{}{
+		"title": notification.Title,
+		"body":  notification.Body,
+	}
+	if notification.TitleLocKey != "" {
+		alert["title-loc-key"] = notification.TitleLocKey
+	}
+	if len(notification.TitleLocArgs) > 0 {
+		alert["title-loc-args"] = notification.TitleLocArgs
+	}
+	if notification.BodyLocKey != "" {
+		alert["loc-key"] = notification.BodyLocKey
+	}
+	if len(notification.BodyLocArgs) > 0 {
+		alert["loc-args"] = notification.BodyLocArgs
+	}
 	aps := map[string]interface{}{
-		"alert": map[string]string{
-			"title": notification.Title,
-			"body":  notification.Body,
-		},
+		"alert": alert,
 		"sound": "default",
 	}
 
-	if notification.IOS != nil {
+	if notification.Silent {
+		aps = map[string]interface{}{
+			"content-available": 1,
+		}
+	} else if notification.IOS != nil {
 		if notification.IOS.Sound != "" {
 			aps["sound"] = notification.IOS.Sound
 		}
-		if notification.IOS.Badge != nil {
-			aps["badge"] = *notification.IOS.Badge
-		}
 		if notification.IOS.Category != "" {
 			aps["category"] = notification.IOS.Category
 		}
-		if notification.IOS.ThreadID != "" {
-			aps["thread-id"] = notification.IOS.ThreadID
-		}
 		if notification.IOS.ContentAvailable {
 			aps["content-available"] = 1
 		}
 		if notification.IOS.MutableContent {
 			aps["mutable-content"] = 1
 		}
-	} else if notification.Badge != nil {
-		aps["badge"] = *notification.Badge
+	}
+	if notification.Call && !notification.Silent {
+		aps["interruption-level"] = "time-sensitive" // Rings through Focus modes that allow it
+	}
+	if badge := notification.badgeCount(); badge != nil {
+		aps["badge"] = *badge
+	}
+	if threadID := notification.threadID(); threadID != "" && !notification.Silent {
+		aps["thread-id"] = threadID
 	}
 
 	payload := map[string]interface{}{
@@ -214,6 +276,21 @@ func (p *APNsPushProvider) buildPayload(notification *Notification) []byte {
 	return data
 }
 
+// voipPayload builds a PushKit payload: just the data, which the app hands
+// to CallKit. There is no alert; iOS requires the app to report a call for
+// every VoIP push, so cancellations are reported and ended straight away.
+func voipPayload(notification *Notification) []byte {
+	payload := map[string]interface{}{
+		"aps": map[string]interface{}{},
+	}
+	for key, value := range notification.Data {
+		payload[key] = value
+	}
+
+	data, _ := json.Marshal(payload)
+	return data
+}
+
 func (p *APNsPushProvider) isInvalidTokenReason(reason string) bool {
 	return reason == apns2.ReasonBadDeviceToken ||
 		reason == apns2.ReasonUnregistered ||
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"messenger/internal/database"
+	"messenger/internal/models"
+)
+
+// DefaultPushAttemptRetention is how long per-device push attempts are kept
+const DefaultPushAttemptRetention = 7 * 24 * time.Hour
+
+// pushSend is the result of one provider send, recorded per device
+type pushSend struct {
+	provider     string
+	tokens       []string
+	notification *Notification
+	invalid      []string // Tokens the provider reported as unregistered
+	err          error
+	latency      time.Duration
+	final        bool // Failures won't be retried
+	diagnostic   bool
+}
+
+// recordAttempts stores the outcome of a send for each device it went to and
+// updates the provider's per-token counters. Must run before invalid tokens
+// are unregistered. Returns the attempts by token.
+func (ps *PushService) recordAttempts(send pushSend) map[string]models.PushAttempt {
+	devices, err := models.GetTokensByValue(database.DB, send.tokens)
+	if err != nil {
+		log.Printf("Failed to look up devices for push attempts: %v", err)
+	}
+
+	invalid := make(map[string]bool, len(send.invalid))
+	for _, token := range send.invalid {
+		invalid[token] = true
+	}
+	var partial *RetryableTokensError
+	retry := make(map[string]bool)
+	if errors.As(send.err, &partial) {
+		for _, token := range partial.Tokens {
+			retry[token] = true
+		}
+	}
+	var permanent *PermanentPushError
+	final := send.final || errors.As(send.err, &permanent)
+
+	attempts := make(map[string]models.PushAttempt, len(devices))
+	records := make([]models.PushAttempt, 0, len(devices))
+	var successes, failures int
+	for _, device := range devices {
+		attempt := models.PushAttempt{
+			UserID:        device.UserID,
+			DeviceTokenID: device.ID,
+			Provider:      send.provider,
+			Type:          send.notification.Data["type"],
+			LatencyMs:     send.latency.Milliseconds(),
+			Diagnostic:    send.diagnostic,
+		}
+		switch {
+		case invalid[device.Token]:
+			attempt.Outcome = models.PushAttemptInvalidToken
+			attempt.TokenPruned = true
+		case send.err == nil || (partial != nil && !retry[device.Token]):
+			attempt.Outcome = models.PushAttemptDelivered
+		case final:
+			attempt.Outcome = models.PushAttemptFailed
+			attempt.Error = send.err.Error()
+		default:
+			attempt.Outcome = models.PushAttemptRetry
+			attempt.Error = send.err.Error()
+		}
+
+		if attempt.Outcome == models.PushAttemptDelivered {
+			successes++
+		} else {
+			failures++
+		}
+		records = append(records, attempt)
+	}
+
+	if err := models.RecordPushAttempts(database.DB, records); err != nil {
+		log.Printf("Failed to record push attempts: %v", err)
+	}
+	for i, device := range devices {
+		attempts[device.Token] = records[i]
+	}
+	ps.queue.metrics.tokenOutcomes(send.provider, successes, failures)
+	return attempts
+}
+
+// DeleteOldPushAttempts removes push attempts past PUSH_ATTEMPT_RETENTION_DAYS
+func DeleteOldPushAttempts() (int64, error) {
+	retention := time.Duration(intFromEnv("PUSH_ATTEMPT_RETENTION_DAYS", int(DefaultPushAttemptRetention.Hours()/24))) * 24 * time.Hour
+	return models.DeletePushAttemptsBefore(database.DB, time.Now().Add(-retention))
+}
@@ -0,0 +1,187 @@
+package services
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"messenger/internal/database"
+	"messenger/internal/models"
+)
+
+const (
+	// DefaultPushBurstWindow is how long after a message push further
+	// messages in the same conversation are held back for a summary
+	DefaultPushBurstWindow = 30 * time.Second
+	// DefaultBadgeSyncDelay coalesces read events into one silent badge push
+	DefaultBadgeSyncDelay = 2 * time.Second
+)
+
+// pushBatcher turns bursts of messages into summary notifications and
+// coalesces badge updates. The first message in a conversation is pushed
+// right away; messages arriving within the burst window are held back and
+// sent as one summary when the window closes. Open windows are stored as
+// models.PushBurst so they are closed after a restart, see ResumePushBursts.
+type pushBatcher struct {
+	ps         *PushService
+	window     time.Duration
+	badgeDelay time.Duration
+
+	mu         sync.Mutex
+	badgeSyncs map[string]*time.Timer
+}
+
+// newPushBatcher reads PUSH_BURST_WINDOW_SECONDS; 0 sends every message
+func newPushBatcher(ps *PushService) *pushBatcher {
+	return &pushBatcher{
+		ps:         ps,
+		window:     time.Duration(intFromEnv("PUSH_BURST_WINDOW_SECONDS", int(DefaultPushBurstWindow.Seconds()))) * time.Second,
+		badgeDelay: DefaultBadgeSyncDelay,
+		badgeSyncs: make(map[string]*time.Timer),
+	}
+}
+
+// conversationThreadID names a conversation from the recipient's point of
+// view, for grouping and collapsing its notifications
+func conversationThreadID(senderID string, isGroup bool, groupID string) string {
+	if isGroup {
+		return "group:" + groupID
+	}
+	return "dm:" + senderID
+}
+
+// admit returns true if a message push should be sent now, or false if it
+// was held back to be summarised when the burst window closes
+func (b *pushBatcher) admit(burst *models.PushBurst) bool {
+	if b.window <= 0 {
+		return true
+	}
+
+	burst.WindowEndsAt = time.Now().Add(b.window)
+	opened, err := models.AdmitPushBurst(database.DB, burst)
+	if err != nil {
+		log.Printf("Failed to track push burst: %v", err)
+		return true // Fail open; a duplicate push beats a lost one
+	}
+	if opened {
+		b.schedule(burst.RecipientID, burst.ThreadID, b.window)
+	}
+	return opened
+}
+
+func (b *pushBatcher) schedule(recipientID, threadID string, after time.Duration) {
+	time.AfterFunc(after, func() { b.flush(recipientID, threadID) })
+}
+
+// flush closes a burst window. If messages were held back a summary is sent
+// and a new window starts; otherwise the burst is forgotten.
+func (b *pushBatcher) flush(recipientID, threadID string) {
+	burst, err := models.ClosePushBurstWindow(database.DB, recipientID, threadID, time.Now().Add(b.window))
+	if err != nil {
+		log.Printf("Failed to close push burst: %v", err)
+		return
+	}
+	if burst == nil {
+		return
+	}
+	b.schedule(recipientID, threadID, b.window)
+	b.ps.sendMessageSummary(burst)
+}
+
+// ResumePushBursts schedules the closing of burst windows left open by the
+// last run, so messages held back before a restart are still summarised
+func (ps *PushService) ResumePushBursts() {
+	bursts, err := models.ListPushBursts(database.DB)
+	if err != nil {
+		log.Printf("Failed to load push bursts: %v", err)
+		return
+	}
+	for _, burst := range bursts {
+		ps.batcher.schedule(burst.RecipientID, burst.ThreadID, time.Until(burst.WindowEndsAt))
+	}
+}
+
+// sendMessageSummary pushes "N new messages" for the messages held back in a
+// burst. The count is capped at what is still unread in the conversation, so
+// messages read in the meantime are left out. The
+// notification policy is checked again, as the recipient may have muted the
+// conversation or entered do-not-disturb since the burst started.
+func (ps *PushService) sendMessageSummary(burst *models.PushBurst) {
+	// Held messages already passed the mentions-only check when they arrived
+	push := MessagePush{RecipientID: burst.RecipientID, SenderID: burst.SenderID, Mentioned: true}
+	if burst.GroupID != nil {
+		push.GroupID = *burst.GroupID
+	}
+	decision := EvaluateMessagePush(push, time.Now())
+	if !decision.Send {
+		return
+	}
+
+	var unread int64
+	var err error
+	if burst.GroupID != nil {
+		unread, err = models.GetConversationUnreadCount(database.DB, burst.RecipientID, burst.GroupID, nil)
+	} else {
+		unread, err = models.GetConversationUnreadCount(database.DB, burst.RecipientID, nil, &burst.SenderID)
+	}
+	if err != nil {
+		log.Printf("Failed to count unread messages for push summary: %v", err)
+		return
+	}
+	count := min(int64(burst.Held), unread)
+	if count == 0 {
+		return // Read on another device before the window closed
+	}
+
+	notification := NewMessageSummaryNotification(burst.Title, count, burst.ConversationID, burst.GroupID != nil)
+	notification.ThreadID = burst.ThreadID
+	notification.CollapseID = burst.ThreadID
+	notification.Badge = unreadBadge(burst.RecipientID)
+	if err := ps.sendMessagePush(burst.RecipientID, notification, decision.Private); err != nil {
+		log.Printf("Failed to send push summary: %v", err)
+	}
+}
+
+// SyncBadge sends the user's devices a silent push with their unread count,
+// e.g. after they read messages on another device. Calls in quick succession
+// are coalesced into one push.
+func (ps *PushService) SyncBadge(userID string) {
+	if !ps.IsEnabled() {
+		return
+	}
+
+	b := ps.batcher
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if _, pending := b.badgeSyncs[userID]; pending {
+		return
+	}
+	b.badgeSyncs[userID] = time.AfterFunc(b.badgeDelay, func() {
+		b.mu.Lock()
+		delete(b.badgeSyncs, userID)
+		b.mu.Unlock()
+		ps.sendBadgeUpdate(userID)
+	})
+}
+
+func (ps *PushService) sendBadgeUpdate(userID string) {
+	badge := unreadBadge(userID)
+	if badge == nil {
+		return
+	}
+	if err := ps.SendToUser(userID, NewBadgeUpdateNotification(*badge)); err != nil {
+		log.Printf("Failed to send badge update: %v", err)
+	}
+}
+
+// unreadBadge returns the user's total unread count, or nil if it couldn't
+// be worked out
+func unreadBadge(userID string) *int {
+	count, err := models.GetTotalUnreadCount(database.DB, userID)
+	if err != nil {
+		log.Printf("Failed to count unread messages for badge: %v", err)
+		return nil
+	}
+	badge := int(count)
+	return &badge
+}
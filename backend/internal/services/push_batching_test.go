@@ -0,0 +1,217 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"messenger/internal/database"
+	"messenger/internal/models"
+)
+
+func queuedNotifications(t *testing.T) []Notification {
+	t.Helper()
+	var jobs []models.PushJob
+	database.DB.Order("created_at ASC").Find(&jobs)
+	notifications := make([]Notification, len(jobs))
+	for i, job := range jobs {
+		if err := json.Unmarshal([]byte(job.Payload), &notifications[i]); err != nil {
+			t.Fatalf("Invalid job payload: %v", err)
+		}
+	}
+	return notifications
+}
+
+func TestGetTotalUnreadCount(t *testing.T) {
+	cleanup := setupAccountTestDB(t)
+	defer cleanup()
+
+	svc := NewAuthService()
+	alice, _ := svc.Register(RegisterInput{Username: "alice", Password: "password123"})
+	bob, _ := svc.Register(RegisterInput{Username: "bob", Password: "password123"})
+	aliceID, bobID := alice.User.ID, bob.User.ID
+
+	group := models.Group{Name: "Team", CreatedBy: bobID}
+	database.DB.Create(&group)
+	database.DB.Create(&models.Message{SenderID: bobID, GroupID: &group.ID, Content: "before alice joined"})
+	database.DB.Create(&models.GroupMember{GroupID: group.ID, UserID: aliceID, JoinedAt: time.Now().Add(time.Second)})
+	time.Sleep(1100 * time.Millisecond)
+	groupMessage := models.Message{SenderID: bobID, GroupID: &group.ID, Content: "welcome"}
+	database.DB.Create(&groupMessage)
+
+	dms := []models.Message{
+		{SenderID: bobID, RecipientID: &aliceID, Content: "one"},
+		{SenderID: bobID, RecipientID: &aliceID, Content: "two", Status: models.MessageStatusRead},
+		{SenderID: bobID, RecipientID: &aliceID, Content: "three"},
+		{SenderID: aliceID, RecipientID: &bobID, Content: "from alice"},
+	}
+	for i := range dms {
+		database.DB.Create(&dms[i])
+	}
+
+	if count, _ := models.GetTotalUnreadCount(database.DB, aliceID); count != 3 {
+		t.Errorf("Expected 2 unread DMs and 1 group message, got %d", count)
+	}
+
+	models.MarkMessagesAsRead(database.DB, aliceID, []string{dms[0].ID, groupMessage.ID})
+	if count, _ := models.GetTotalUnreadCount(database.DB, aliceID); count != 1 {
+		t.Errorf("Expected 1 unread after reading, got %d", count)
+	}
+	if count, _ := models.GetConversationUnreadCount(database.DB, aliceID, nil, &bobID); count != 1 {
+		t.Errorf("Expected 1 unread DM from bob, got %d", count)
+	}
+}
+
+func TestPushBatching(t *testing.T) {
+	cleanup := setupAccountTestDB(t)
+	defer cleanup()
+
+	ps := newPushService()
+	ps.RegisterProvider(&MockPushProvider{name: ProviderFCM, enabled: true})
+	ps.batcher.window = time.Hour // Windows are closed by hand
+
+	svc := NewAuthService()
+	alice, _ := svc.Register(RegisterInput{Username: "alice", Password: "password123"})
+	bob, _ := svc.Register(RegisterInput{Username: "bob", Password: "password123"})
+	aliceID, bobID := alice.User.ID, bob.User.ID
+	database.DB.Create(&models.DeviceToken{UserID: aliceID, Token: "alice-phone", Platform: models.PlatformAndroid, Provider: ProviderFCM})
+
+	send := func(content string) {
+		message := models.Message{SenderID: bobID, RecipientID: &aliceID, Content: content}
+		database.DB.Create(&message)
+		ps.pushMessage(database.DB, aliceID, bobID, message.ID, content, false, aliceID)
+	}
+	threadID := conversationThreadID(bobID, false, "")
+
+	send("first")
+	send("second")
+	send("third")
+
+	notifications := queuedNotifications(t)
+	if len(notifications) != 1 {
+		t.Fatalf("Expected only the first message to be pushed, got %d pushes", len(notifications))
+	}
+	first := notifications[0]
+	if first.Body != "first" || first.ThreadID != "dm:"+bobID || first.CollapseID != first.ThreadID {
+		t.Errorf("Unexpected first push %+v", first)
+	}
+	if first.Badge == nil || *first.Badge != 1 {
+		t.Errorf("Expected badge 1, got %v", first.Badge)
+	}
+
+	ps.batcher.flush(aliceID, threadID)
+	notifications = queuedNotifications(t)
+	if len(notifications) != 2 {
+		t.Fatalf("Expected a summary when the window closes, got %d pushes", len(notifications))
+	}
+	summary := notifications[1]
+	// The first message was already pushed, so only the held ones are counted
+	if summary.Body != "2 new messages from bob" || summary.Data["type"] != "message_summary" || summary.ThreadID != first.ThreadID {
+		t.Errorf("Unexpected summary %+v", summary)
+	}
+	if summary.Badge == nil || *summary.Badge != 3 {
+		t.Errorf("Expected badge 3, got %v", summary.Badge)
+	}
+
+	t.Run("read before the window closes", func(t *testing.T) {
+		send("fourth")
+		database.DB.Model(&models.Message{}).Where("recipient_id = ?", aliceID).Update("status", models.MessageStatusRead)
+		ps.batcher.flush(aliceID, threadID)
+		if n := len(queuedNotifications(t)); n != 2 {
+			t.Errorf("No summary should be sent once everything is read, got %d pushes", n)
+		}
+	})
+
+	t.Run("quiet window ends the burst", func(t *testing.T) {
+		ps.batcher.flush(aliceID, threadID)
+		send("fifth")
+		if n := len(queuedNotifications(t)); n != 3 {
+			t.Errorf("A message after a quiet window should be pushed right away, got %d pushes", n)
+		}
+	})
+
+	t.Run("held messages survive a restart", func(t *testing.T) {
+		send("sixth")
+		restarted := newPushService()
+		restarted.RegisterProvider(&MockPushProvider{name: ProviderFCM, enabled: true})
+		restarted.batcher.window = time.Hour
+		restarted.batcher.flush(aliceID, threadID)
+		if n := len(queuedNotifications(t)); n != 4 {
+			t.Errorf("Expected a summary from the restarted service, got %d pushes", n)
+		}
+	})
+
+	t.Run("summary respects policy changes", func(t *testing.T) {
+		send("seventh")
+		mutedUntil := time.Now().Add(time.Hour)
+		database.DB.Create(&models.ConversationSettings{UserID: aliceID, OtherUserID: &bobID, MutedUntil: &mutedUntil})
+		ps.batcher.flush(aliceID, threadID)
+		if n := len(queuedNotifications(t)); n != 4 {
+			t.Errorf("No summary should be sent once the conversation is muted, got %d pushes", n)
+		}
+	})
+}
+
+func TestResumePushBursts(t *testing.T) {
+	cleanup := setupAccountTestDB(t)
+	defer cleanup()
+
+	ps := newPushService()
+	ps.RegisterProvider(&MockPushProvider{name: ProviderFCM, enabled: true})
+
+	svc := NewAuthService()
+	alice, _ := svc.Register(RegisterInput{Username: "alice", Password: "password123"})
+	bob, _ := svc.Register(RegisterInput{Username: "bob", Password: "password123"})
+	aliceID, bobID := alice.User.ID, bob.User.ID
+	database.DB.Create(&models.DeviceToken{UserID: aliceID, Token: "alice-phone", Platform: models.PlatformAndroid, Provider: ProviderFCM})
+	database.DB.Create(&models.Message{SenderID: bobID, RecipientID: &aliceID, Content: "held"})
+
+	// A window left open by the last run, already past its end
+	database.DB.Create(&models.PushBurst{
+		RecipientID:    aliceID,
+		ThreadID:       conversationThreadID(bobID, false, ""),
+		Title:          "bob",
+		ConversationID: aliceID,
+		SenderID:       bobID,
+		Held:           1,
+		WindowEndsAt:   time.Now().Add(-time.Minute),
+	})
+
+	ps.ResumePushBursts()
+	time.Sleep(200 * time.Millisecond)
+
+	notifications := queuedNotifications(t)
+	if len(notifications) != 1 || notifications[0].Data["type"] != "message_summary" {
+		t.Fatalf("Expected the open window to be summarised after a restart, got %+v", notifications)
+	}
+}
+
+func TestSyncBadge(t *testing.T) {
+	cleanup := setupAccountTestDB(t)
+	defer cleanup()
+
+	ps := newPushService()
+	ps.RegisterProvider(&MockPushProvider{name: ProviderFCM, enabled: true})
+	ps.batcher.badgeDelay = 20 * time.Millisecond
+
+	svc := NewAuthService()
+	alice, _ := svc.Register(RegisterInput{Username: "alice", Password: "password123"})
+	bob, _ := svc.Register(RegisterInput{Username: "bob", Password: "password123"})
+	aliceID := alice.User.ID
+	database.DB.Create(&models.DeviceToken{UserID: aliceID, Token: "alice-phone", Platform: models.PlatformAndroid, Provider: ProviderFCM})
+	database.DB.Create(&models.Message{SenderID: bob.User.ID, RecipientID: &aliceID, Content: "unread"})
+
+	ps.SyncBadge(aliceID)
+	ps.SyncBadge(aliceID)
+	ps.SyncBadge(aliceID)
+	time.Sleep(200 * time.Millisecond)
+
+	notifications := queuedNotifications(t)
+	if len(notifications) != 1 {
+		t.Fatalf("Expected badge syncs to be coalesced into 1 push, got %d", len(notifications))
+	}
+	badge := notifications[0]
+	if !badge.Silent || badge.Badge == nil || *badge.Badge != 1 || badge.Data["type"] != "badge_update" {
+		t.Errorf("Unexpected badge update %+v", badge)
+	}
+}
@@ -0,0 +1,202 @@
+package services
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"messenger/internal/database"
+	"messenger/internal/models"
+)
+
+// Why a callee's phone stops ringing, sent with call_cancelled pushes
+const (
+	CallCancelReasonCancelled = "cancelled" // The caller hung up
+	CallCancelReasonAnswered  = "answered"  // Picked up on another device
+	CallCancelReasonDeclined  = "declined"  // Declined on another device
+	CallCancelReasonMissed    = "missed"    // Rang out
+)
+
+// callPushes tracks the incoming call pushes sent per call and callee, so a
+// cancellation goes only where a call rang, and never overtakes it
+type callPushes struct {
+	mu      sync.Mutex
+	pending map[string]*callPush
+}
+
+// callPush is one callee's incoming call push. done is closed once it was
+// sent or skipped, with sent telling which.
+type callPush struct {
+	done chan struct{}
+	sent bool
+}
+
+var incomingCallPushes = &callPushes{pending: make(map[string]*callPush)}
+
+// PushIncomingCall rings a callee's devices: VoIP pushes to PushKit tokens,
+// which open CallKit, and high-priority pushes to everything else. It is
+// sent even if the callee is online, as the app may be in the background;
+// apps drop a push for a call_id they already show. Incoming calls follow
+// the same block, mute and do-not-disturb rules as missed call pushes.
+func PushIncomingCall(call *models.Call, calleeID string) {
+	GetPushService().pushIncomingCall(call, calleeID)
+}
+
+func (ps *PushService) pushIncomingCall(call *models.Call, calleeID string) {
+	if !ps.IsEnabled() {
+		return
+	}
+
+	push := &callPush{done: make(chan struct{})}
+	key := call.ID + ":" + calleeID
+	incomingCallPushes.mu.Lock()
+	incomingCallPushes.pending[key] = push
+	incomingCallPushes.mu.Unlock()
+
+	go func() {
+		push.sent = ps.ringCallee(call, calleeID)
+		close(push.done)
+	}()
+}
+
+// ringCallee sends the incoming call push, returning whether it went out
+func (ps *PushService) ringCallee(call *models.Call, calleeID string) bool {
+	decision := EvaluateMessagePush(callPushPolicy(call, calleeID), time.Now())
+	if !decision.Send {
+		return false
+	}
+
+	var caller models.User
+	if err := database.DB.First(&caller, "id = ?", call.CallerID).Error; err != nil {
+		log.Printf("Failed to get caller for incoming call push: %v", err)
+		return false
+	}
+	callerName := caller.DisplayName
+	if callerName == "" {
+		callerName = caller.Username
+	}
+	if decision.Private {
+		callerName = "" // The app looks the caller up by caller_id
+	}
+
+	if err := ps.sendCallPush(calleeID, NewIncomingCallNotification(callerName, call)); err != nil {
+		log.Printf("Failed to send incoming call push: %v", err)
+	}
+	return true
+}
+
+// PushCallCancelled stops a callee's devices ringing for a call they got an
+// incoming call push for. It waits for that push to go out first, so the
+// cancellation can't arrive before the call.
+func PushCallCancelled(call *models.Call, calleeID, reason string) {
+	GetPushService().pushCallCancelled(call, calleeID, reason)
+}
+
+func (ps *PushService) pushCallCancelled(call *models.Call, calleeID, reason string) {
+	key := call.ID + ":" + calleeID
+	incomingCallPushes.mu.Lock()
+	push, ok := incomingCallPushes.pending[key]
+	delete(incomingCallPushes.pending, key)
+	incomingCallPushes.mu.Unlock()
+	if !ok {
+		return
+	}
+
+	go func() {
+		<-push.done
+		if !push.sent {
+			return
+		}
+		if err := ps.sendCallPush(calleeID, NewCallCancelledNotification(call, reason)); err != nil {
+			log.Printf("Failed to send call cancelled push: %v", err)
+		}
+	}()
+}
+
+// sendCallPush sends a call push right away, skipping the queue: retrying
+// is pointless once the call stopped ringing
+func (ps *PushService) sendCallPush(userID string, notification *Notification) error {
+	tokens, err := models.GetUserTokens(database.DB, userID)
+	if err != nil {
+		return err
+	}
+	routes := ps.routeTokens(callTokens(tokens))
+	if len(routes) == 0 {
+		return nil
+	}
+
+	_, err = ps.sendRoutes(routes, notification.localized(UserLocale(userID)), false)
+	return err
+}
+
+// callPushPolicy describes a call for the notification policy
+func callPushPolicy(call *models.Call, calleeID string) MessagePush {
+	push := MessagePush{RecipientID: calleeID, SenderID: call.CallerID}
+	if call.IsGroupCall() {
+		push.GroupID = *call.GroupID
+	}
+	return push
+}
+
+// NewIncomingCallNotification creates the push that rings a callee. An empty
+// caller name leaves the caller out, for private pushes.
+func NewIncomingCallNotification(callerName string, call *models.Call) *Notification {
+	bodyLocKey := "push_incoming_call"
+	if call.Media == models.CallMediaVideo {
+		bodyLocKey = "push_incoming_video_call"
+	}
+
+	data := map[string]string{
+		"type":      "incoming_call",
+		"call_id":   call.ID,
+		"caller_id": call.CallerID,
+		"media":     string(call.Media),
+	}
+	conversationID := call.CallerID
+	if callerName != "" {
+		data["caller_name"] = callerName
+	}
+	if call.IsGroupCall() {
+		conversationID = *call.GroupID
+		data["group_id"] = *call.GroupID
+		data["is_group"] = "true"
+	}
+	data["conversation_id"] = conversationID
+
+	return &Notification{
+		Title:      callerName,
+		Body:       Localize(DefaultLocale, bodyLocKey),
+		BodyLocKey: bodyLocKey,
+		Data:       data,
+		Sound:      "default",
+		ThreadID:   conversationThreadID(call.CallerID, call.IsGroupCall(), conversationID),
+		CollapseID: "call:" + call.ID,
+		Call:       true,
+		TTL:        CallRingTimeout(),
+		Android: &AndroidConfig{
+			ChannelID: "calls",
+			Priority:  "high",
+		},
+		IOS: &IOSConfig{
+			Sound:    "default",
+			Category: "INCOMING_CALL",
+		},
+	}
+}
+
+// NewCallCancelledNotification creates the silent push that stops a callee's
+// devices ringing, replacing the incoming call push where it's still queued
+func NewCallCancelledNotification(call *models.Call, reason string) *Notification {
+	return &Notification{
+		Data: map[string]string{
+			"type":      "call_cancelled",
+			"call_id":   call.ID,
+			"caller_id": call.CallerID,
+			"reason":    reason,
+		},
+		CollapseID: "call:" + call.ID,
+		Call:       true,
+		Silent:     true,
+		TTL:        CallRingTimeout(),
+	}
+}
@@ -0,0 +1,131 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"messenger/internal/database"
+	"messenger/internal/models"
+)
+
+func TestCallTokens(t *testing.T) {
+	tokens := []models.DeviceToken{
+		{Token: "iphone-voip", Platform: models.PlatformIOS, Provider: ProviderAPNsVoIP, DeviceID: "iphone"},
+		{Token: "iphone-apns", Platform: models.PlatformIOS, Provider: ProviderAPNs, DeviceID: "iphone"},
+		{Token: "ipad-apns", Platform: models.PlatformIOS, Provider: ProviderAPNs, DeviceID: "ipad"},
+		{Token: "android-fcm", Platform: models.PlatformAndroid, Provider: ProviderFCM, DeviceID: "pixel"},
+	}
+
+	selected := map[string]bool{}
+	for _, t := range callTokens(tokens) {
+		selected[t.Token] = true
+	}
+	if !selected["iphone-voip"] || !selected["ipad-apns"] || !selected["android-fcm"] {
+		t.Errorf("Expected the VoIP token and the devices without one, got %v", selected)
+	}
+	if selected["iphone-apns"] {
+		t.Error("An iPhone with a VoIP token shouldn't also get an alert")
+	}
+
+	for _, token := range messageTokens(tokens) {
+		if token.Token == "iphone-voip" {
+			t.Error("VoIP tokens shouldn't get message pushes")
+		}
+	}
+}
+
+func TestPushIncomingCall(t *testing.T) {
+	cleanup := setupAccountTestDB(t)
+	defer cleanup()
+
+	ps := newPushService()
+	fcm := &MockPushProvider{name: ProviderFCM, enabled: true}
+	voip := &MockPushProvider{name: ProviderAPNsVoIP, enabled: true}
+	ps.RegisterProvider(fcm)
+	ps.RegisterProvider(voip)
+
+	svc := NewAuthService()
+	alice, _ := svc.Register(RegisterInput{Username: "alice", Password: "password123"})
+	bob, _ := svc.Register(RegisterInput{Username: "bob", Password: "password123"})
+	bobID := bob.User.ID
+	database.DB.Create(&models.DeviceToken{UserID: bobID, Token: "bob-voip", Platform: models.PlatformIOS, Provider: ProviderAPNsVoIP, DeviceID: "iphone"})
+	database.DB.Create(&models.DeviceToken{UserID: bobID, Token: "bob-android", Platform: models.PlatformAndroid, Provider: ProviderFCM})
+
+	call := &models.Call{ID: "call-1", CallerID: alice.User.ID, RecipientID: &bobID, Media: models.CallMediaVideo}
+	if !ps.ringCallee(call, bobID) {
+		t.Fatal("Expected the incoming call push to be sent")
+	}
+
+	if len(voip.sentTokens) != 1 || voip.sentTokens[0] != "bob-voip" {
+		t.Errorf("Expected the VoIP token to ring, got %v", voip.sentTokens)
+	}
+	if len(fcm.sentTokens) != 1 || fcm.sentTokens[0] != "bob-android" {
+		t.Errorf("Expected the Android token to ring, got %v", fcm.sentTokens)
+	}
+	n := voip.lastSent
+	if !n.Call || n.Silent || n.TTL != CallRingTimeout() {
+		t.Errorf("Expected a high-priority call push, got %+v", n)
+	}
+	if n.Data["type"] != "incoming_call" || n.Data["call_id"] != "call-1" || n.Data["caller_id"] != alice.User.ID ||
+		n.Data["caller_name"] != "alice" || n.Data["media"] != "video" {
+		t.Errorf("Unexpected call data: %v", n.Data)
+	}
+	if n.Body != "Incoming video call" {
+		t.Errorf("Unexpected body %q", n.Body)
+	}
+
+	if err := ps.sendCallPush(bobID, NewCallCancelledNotification(call, CallCancelReasonCancelled)); err != nil {
+		t.Fatalf("sendCallPush failed: %v", err)
+	}
+	if n := voip.lastSent; !n.Call || !n.Silent || n.Data["type"] != "call_cancelled" || n.Data["reason"] != "cancelled" {
+		t.Errorf("Unexpected cancellation %+v", n)
+	}
+	if n := voip.lastSent; n.CollapseID != "call:call-1" {
+		t.Errorf("Cancellation should replace the incoming call push, got collapse ID %q", n.CollapseID)
+	}
+}
+
+func TestPushIncomingCall_DoNotDisturb(t *testing.T) {
+	cleanup := setupAccountTestDB(t)
+	defer cleanup()
+
+	ps := newPushService()
+	voip := &MockPushProvider{name: ProviderAPNsVoIP, enabled: true}
+	ps.RegisterProvider(voip)
+
+	svc := NewAuthService()
+	alice, _ := svc.Register(RegisterInput{Username: "alice", Password: "password123"})
+	bob, _ := svc.Register(RegisterInput{Username: "bob", Password: "password123"})
+	bobID := bob.User.ID
+	database.DB.Create(&models.DeviceToken{UserID: bobID, Token: "bob-voip", Platform: models.PlatformIOS, Provider: ProviderAPNsVoIP})
+
+	now := time.Now().UTC()
+	enabled := true
+	start := now.Add(-time.Hour).Format("15:04")
+	end := now.Add(time.Hour).Format("15:04")
+	utc := "UTC"
+	if _, err := UpdateNotificationPreferences(bobID, NotificationPreferencesInput{DNDEnabled: &enabled, DNDStart: &start, DNDEnd: &end, Timezone: &utc}); err != nil {
+		t.Fatalf("UpdateNotificationPreferences failed: %v", err)
+	}
+
+	call := &models.Call{ID: "call-1", CallerID: alice.User.ID, RecipientID: &bobID, Media: models.CallMediaAudio}
+	if ps.ringCallee(call, bobID) || voip.sentCount != 0 {
+		t.Error("Calls shouldn't ring during do-not-disturb")
+	}
+}
+
+func TestNewIncomingCallNotification_Private(t *testing.T) {
+	groupID := "group-1"
+	call := &models.Call{ID: "call-1", CallerID: "user-1", GroupID: &groupID, Media: models.CallMediaAudio}
+
+	n := NewIncomingCallNotification("", call)
+	if _, ok := n.Data["caller_name"]; ok || n.Title != "" {
+		t.Errorf("Private call push shouldn't name the caller: %+v", n)
+	}
+	if n.Data["group_id"] != groupID || n.Data["conversation_id"] != groupID || n.ThreadID != "group:group-1" {
+		t.Errorf("Unexpected group call data: %v", n.Data)
+	}
+	if de := n.localized("de"); de.Body != "Eingehender Sprachanruf" {
+		t.Errorf("Expected German body, got %q", de.Body)
+	}
+}
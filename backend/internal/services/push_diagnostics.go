@@ -0,0 +1,273 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"messenger/internal/database"
+	"messenger/internal/models"
+)
+
+// Problems a push diagnostics check can report
+const (
+	PushIssueDisabled            = "push_disabled"            // No push provider is configured on the server
+	PushIssueNoDevices           = "no_devices"               // The user has no registered device tokens
+	PushIssueProviderUnavailable = "provider_unavailable"     // A device's provider isn't configured and there is no fallback
+	PushIssueDeliveryFailed      = "delivery_failed"          // The check push was not accepted for a device
+	PushIssueTokenPruned         = "token_pruned"             // A device token was invalid and has been removed
+	PushIssueDoNotDisturb        = "do_not_disturb"           // The user is inside their do-not-disturb window
+	PushIssueMutedConversations  = "muted_conversations"      // Some conversations are muted
+	PushIssueRecentFailures      = "recent_delivery_failures" // A device's last pushes all failed
+)
+
+// pushDiagnosticsHistory is how many recent attempts are shown per device
+const pushDiagnosticsHistory = 10
+
+// DefaultPushDiagnosticsCooldown is how often a user may run a check, as
+// each one sends a live push to all their devices
+const DefaultPushDiagnosticsCooldown = time.Minute
+
+var ErrPushDiagnosticsCooldown = errors.New("push diagnostics were run recently, try again later")
+
+// PushDiagnosticsCooldownError is returned when a user runs push diagnostics
+// again too soon. It matches ErrPushDiagnosticsCooldown with errors.Is.
+type PushDiagnosticsCooldownError struct {
+	Until time.Time
+}
+
+func (e *PushDiagnosticsCooldownError) Error() string {
+	return ErrPushDiagnosticsCooldown.Error()
+}
+
+func (e *PushDiagnosticsCooldownError) Unwrap() error {
+	return ErrPushDiagnosticsCooldown
+}
+
+// RetryAfter returns how long until the user may run another check
+func (e *PushDiagnosticsCooldownError) RetryAfter() time.Duration {
+	return time.Until(e.Until).Round(time.Second)
+}
+
+// diagnosticsCooldown remembers when each user last ran push diagnostics
+type diagnosticsCooldown struct {
+	period time.Duration
+	mu     sync.Mutex
+	last   map[string]time.Time
+}
+
+// newDiagnosticsCooldown reads PUSH_DIAGNOSTICS_COOLDOWN_SECONDS; 0 disables it
+func newDiagnosticsCooldown() *diagnosticsCooldown {
+	return &diagnosticsCooldown{
+		period: time.Duration(intFromEnv("PUSH_DIAGNOSTICS_COOLDOWN_SECONDS", int(DefaultPushDiagnosticsCooldown.Seconds()))) * time.Second,
+		last:   make(map[string]time.Time),
+	}
+}
+
+// claim records a check for the user at now, or returns an error if the
+// previous one was too recent
+func (d *diagnosticsCooldown) claim(userID string, now time.Time) error {
+	if d.period <= 0 {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if last, ok := d.last[userID]; ok && now.Sub(last) < d.period {
+		return &PushDiagnosticsCooldownError{Until: last.Add(d.period)}
+	}
+	for id, last := range d.last {
+		if now.Sub(last) >= d.period {
+			delete(d.last, id)
+		}
+	}
+	d.last[userID] = now
+	return nil
+}
+
+// PushDiagnostics is the result of a user's end-to-end push check. A silent
+// push with CheckID is sent to every device, so the app can also confirm
+// that it arrived.
+type PushDiagnostics struct {
+	CheckID   string                  `json:"check_id"`
+	CheckedAt time.Time               `json:"checked_at"`
+	Enabled   bool                    `json:"push_enabled"`
+	Providers []string                `json:"providers"` // Enabled on the server
+	Policy    PushPolicyDiagnostics   `json:"policy"`
+	Devices   []PushDeviceDiagnostics `json:"devices"`
+	Issues    []string                `json:"issues"`
+}
+
+// PushPolicyDiagnostics are the user's settings that hold back or change pushes
+type PushPolicyDiagnostics struct {
+	DoNotDisturb       bool  `json:"do_not_disturb"` // Inside the window right now
+	HidePreviews       bool  `json:"hide_previews"`
+	PrivatePushes      bool  `json:"private_pushes"`
+	MutedConversations int64 `json:"muted_conversations"`
+}
+
+// PushDeviceDiagnostics is the push setup and history of one device
+type PushDeviceDiagnostics struct {
+	ID              string               `json:"id"`
+	Platform        string               `json:"platform"`
+	Provider        string               `json:"provider"`                  // Provider the token was registered with
+	RoutedProvider  string               `json:"routed_provider,omitempty"` // Provider pushes actually go through; empty if none can
+	Fallback        bool                 `json:"fallback,omitempty"`        // RoutedProvider is the fallback provider
+	HasPushKey      bool                 `json:"has_push_key"`
+	AppVersion      string               `json:"app_version,omitempty"`
+	RegisteredAt    time.Time            `json:"registered_at"`
+	LastDeliveredAt *time.Time           `json:"last_delivered_at,omitempty"` // Before this check
+	Check           models.PushAttempt   `json:"check"`
+	RecentAttempts  []models.PushAttempt `json:"recent_attempts"`
+}
+
+// RunPushDiagnostics checks a user's push setup end to end: server providers,
+// notification settings, and a live send to each registered device. Returns
+// a *PushDiagnosticsCooldownError if the user ran a check too recently.
+func (ps *PushService) RunPushDiagnostics(userID string) (*PushDiagnostics, error) {
+	now := time.Now()
+	if err := ps.diagnostics.claim(userID, now); err != nil {
+		return nil, err
+	}
+
+	result := &PushDiagnostics{
+		CheckID:   uuid.New().String(),
+		CheckedAt: now,
+		Enabled:   ps.IsEnabled(),
+		Providers: ps.enabledProviderNames(),
+		Devices:   []PushDeviceDiagnostics{},
+		Issues:    []string{},
+	}
+	if result.Providers == nil {
+		result.Providers = []string{}
+	}
+	if !result.Enabled {
+		result.Issues = append(result.Issues, PushIssueDisabled)
+	}
+
+	if prefs, err := models.GetNotificationPreferences(database.DB, userID); err == nil {
+		result.Policy.DoNotDisturb = prefs.InDoNotDisturb(now)
+		result.Policy.HidePreviews = prefs.HidePreviews
+		result.Policy.PrivatePushes = prefs.PrivatePushes
+	}
+	database.DB.Model(&models.ConversationSettings{}).
+		Where("user_id = ? AND muted_until > ?", userID, now).
+		Count(&result.Policy.MutedConversations)
+	if result.Policy.DoNotDisturb {
+		result.Issues = append(result.Issues, PushIssueDoNotDisturb)
+	}
+	if result.Policy.MutedConversations > 0 {
+		result.Issues = append(result.Issues, PushIssueMutedConversations)
+	}
+
+	tokens, err := models.GetUserTokens(database.DB, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get user tokens: %w", err)
+	}
+	tokens = messageTokens(tokens) // A check push to a VoIP token would have to ring
+	if len(tokens) == 0 {
+		result.Issues = append(result.Issues, PushIssueNoDevices)
+		return result, nil
+	}
+
+	// History from before the check
+	history, err := models.ListPushAttempts(database.DB, userID, len(tokens)*pushDiagnosticsHistory*2)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get push attempts: %w", err)
+	}
+
+	routes := make(map[string][]string)
+	for _, token := range tokens {
+		device := PushDeviceDiagnostics{
+			ID:             token.ID,
+			Platform:       string(token.Platform),
+			Provider:       tokenProvider(token),
+			HasPushKey:     token.PushKey != "",
+			AppVersion:     token.AppVersion,
+			RegisteredAt:   token.CreatedAt,
+			RecentAttempts: []models.PushAttempt{},
+		}
+		for _, attempt := range history {
+			if attempt.DeviceTokenID == token.ID && len(device.RecentAttempts) < pushDiagnosticsHistory {
+				device.RecentAttempts = append(device.RecentAttempts, attempt)
+			}
+		}
+		if delivered := models.LastDeliveredPushAttempt(database.DB, token.ID); !delivered.IsZero() {
+			device.LastDeliveredAt = &delivered
+		}
+		if name, fellBack, ok := ps.tokenRoute(token); ok {
+			device.RoutedProvider = name
+			device.Fallback = fellBack
+			routes[name] = append(routes[name], token.Token)
+		} else {
+			device.Check = models.PushAttempt{
+				UserID:        userID,
+				DeviceTokenID: token.ID,
+				Provider:      name,
+				Type:          "push_diagnostic",
+				Outcome:       models.PushAttemptUnroutable,
+				Error:         ErrProviderNotConfigured.Error(),
+				Diagnostic:    true,
+			}
+			models.RecordPushAttempts(database.DB, []models.PushAttempt{device.Check})
+		}
+		result.Devices = append(result.Devices, device)
+	}
+
+	attempts, _ := ps.sendRoutes(routes, NewPushDiagnosticNotification(result.CheckID), true)
+
+	issues := make(map[string]bool)
+	for i, token := range tokens {
+		device := &result.Devices[i]
+		if device.RoutedProvider == "" {
+			issues[PushIssueProviderUnavailable] = true
+			continue
+		}
+		device.Check = attempts[token.Token]
+		switch device.Check.Outcome {
+		case models.PushAttemptDelivered:
+		case models.PushAttemptInvalidToken:
+			issues[PushIssueTokenPruned] = true
+		default:
+			issues[PushIssueDeliveryFailed] = true
+		}
+		if recentlyFailing(device.RecentAttempts) {
+			issues[PushIssueRecentFailures] = true
+		}
+	}
+	for _, issue := range []string{PushIssueProviderUnavailable, PushIssueDeliveryFailed, PushIssueTokenPruned, PushIssueRecentFailures} {
+		if issues[issue] {
+			result.Issues = append(result.Issues, issue)
+		}
+	}
+
+	return result, nil
+}
+
+// NewPushDiagnosticNotification creates the silent push sent by a diagnostics check
+func NewPushDiagnosticNotification(checkID string) *Notification {
+	return &Notification{
+		Silent:     true,
+		CollapseID: "push_diagnostic",
+		Data: map[string]string{
+			"type":     "push_diagnostic",
+			"check_id": checkID,
+		},
+	}
+}
+
+// recentlyFailing reports whether a device's last few pushes all failed
+func recentlyFailing(recent []models.PushAttempt) bool {
+	const window = 3
+	if len(recent) < window {
+		return false
+	}
+	for _, attempt := range recent[:window] {
+		if attempt.Outcome == models.PushAttemptDelivered {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,190 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"messenger/internal/database"
+	"messenger/internal/models"
+)
+
+func TestPushAttempts_Recorded(t *testing.T) {
+	provider := &MockPushProvider{
+		name:         "mock",
+		enabled:      true,
+		failedTokens: []string{"token-gone"},
+		sendError:    &RetryableTokensError{Tokens: []string{"token-busy"}, Err: errors.New("unavailable")},
+	}
+	q, cleanup := setupPushQueueTest(t, provider)
+	defer cleanup()
+
+	devices := map[string]*models.DeviceToken{}
+	for _, token := range []string{"token-ok", "token-busy", "token-gone"} {
+		device := &models.DeviceToken{UserID: "user-1", Token: token, Platform: models.PlatformAndroid, Provider: "mock"}
+		database.DB.Create(device)
+		devices[token] = device
+	}
+
+	enqueueTestPush(t, q, "mock", "token-ok", "token-busy", "token-gone")
+	q.ProcessDue()
+
+	attempts, _ := models.ListPushAttempts(database.DB, "user-1", 10)
+	if len(attempts) != 3 {
+		t.Fatalf("Expected an attempt per device, got %d", len(attempts))
+	}
+	outcomes := map[string]models.PushAttempt{}
+	for _, attempt := range attempts {
+		outcomes[attempt.DeviceTokenID] = attempt
+	}
+
+	if ok := outcomes[devices["token-ok"].ID]; ok.Outcome != models.PushAttemptDelivered || ok.Error != "" || ok.Provider != "mock" {
+		t.Errorf("Unexpected attempt for delivered token: %+v", ok)
+	}
+	if busy := outcomes[devices["token-busy"].ID]; busy.Outcome != models.PushAttemptRetry || busy.Error == "" {
+		t.Errorf("Unexpected attempt for retried token: %+v", busy)
+	}
+	if gone := outcomes[devices["token-gone"].ID]; gone.Outcome != models.PushAttemptInvalidToken || !gone.TokenPruned {
+		t.Errorf("Unexpected attempt for invalid token: %+v", gone)
+	}
+
+	stats := q.Stats().Providers["mock"]
+	if stats.TokenSuccesses != 1 || stats.TokenFailures != 2 {
+		t.Errorf("Expected 1 token success and 2 failures, got %d and %d", stats.TokenSuccesses, stats.TokenFailures)
+	}
+
+	t.Run("last attempt fails for good", func(t *testing.T) {
+		provider.failedTokens = nil
+		for i := 1; i < q.maxAttempts; i++ {
+			database.DB.Model(queuedPushJob(t)).Update("next_attempt_at", time.Now().Add(-time.Second))
+			q.ProcessDue()
+		}
+
+		latest, _ := models.ListPushAttempts(database.DB, "user-1", 1)
+		if len(latest) != 1 || latest[0].Outcome != models.PushAttemptFailed {
+			t.Errorf("Expected the dead-lettered attempt to be recorded as failed, got %+v", latest)
+		}
+	})
+}
+
+func TestRunPushDiagnostics(t *testing.T) {
+	cleanup := setupAccountTestDB(t)
+	defer cleanup()
+
+	ps := newPushService()
+	provider := &MockPushProvider{name: ProviderFCM, enabled: true}
+	ps.RegisterProvider(provider)
+	ps.diagnostics.period = 0 // Checks are run back to back
+
+	svc := NewAuthService()
+	alice, _ := svc.Register(RegisterInput{Username: "alice", Password: "password123"})
+	aliceID := alice.User.ID
+
+	t.Run("no devices", func(t *testing.T) {
+		result, err := ps.RunPushDiagnostics(aliceID)
+		if err != nil {
+			t.Fatalf("RunPushDiagnostics failed: %v", err)
+		}
+		if !result.Enabled || len(result.Devices) != 0 || !containsString(result.Issues, PushIssueNoDevices) {
+			t.Errorf("Expected the missing devices to be reported, got %+v", result)
+		}
+	})
+
+	phone := models.DeviceToken{UserID: aliceID, Token: "alice-phone", Platform: models.PlatformAndroid, Provider: ProviderFCM}
+	tablet := models.DeviceToken{UserID: aliceID, Token: "alice-tablet", Platform: models.PlatformIOS, Provider: ProviderAPNs}
+	database.DB.Create(&phone)
+	database.DB.Create(&tablet)
+
+	result, err := ps.RunPushDiagnostics(aliceID)
+	if err != nil {
+		t.Fatalf("RunPushDiagnostics failed: %v", err)
+	}
+	if result.CheckID == "" || len(result.Devices) != 2 {
+		t.Fatalf("Expected a check of 2 devices, got %+v", result)
+	}
+	if provider.sentCount != 1 {
+		t.Errorf("Expected the check push to reach the routable device, got %d sends", provider.sentCount)
+	}
+
+	for _, device := range result.Devices {
+		switch device.ID {
+		case phone.ID:
+			if device.RoutedProvider != ProviderFCM || device.Check.Outcome != models.PushAttemptDelivered || !device.Check.Diagnostic {
+				t.Errorf("Unexpected phone diagnostics %+v", device)
+			}
+		case tablet.ID:
+			if device.RoutedProvider != "" || device.Check.Outcome != models.PushAttemptUnroutable {
+				t.Errorf("Unexpected tablet diagnostics %+v", device)
+			}
+		}
+	}
+	if !containsString(result.Issues, PushIssueProviderUnavailable) || containsString(result.Issues, PushIssueDeliveryFailed) {
+		t.Errorf("Expected only the unroutable tablet to be reported, got %v", result.Issues)
+	}
+
+	t.Run("history and failures", func(t *testing.T) {
+		provider.sendError = &PermanentPushError{Err: errors.New("sender id mismatch")}
+		result, _ := ps.RunPushDiagnostics(aliceID)
+
+		var phoneResult PushDeviceDiagnostics
+		for _, device := range result.Devices {
+			if device.ID == phone.ID {
+				phoneResult = device
+			}
+		}
+		if phoneResult.Check.Outcome != models.PushAttemptFailed || phoneResult.Check.Error != "sender id mismatch" {
+			t.Errorf("Expected the failed check to be reported, got %+v", phoneResult.Check)
+		}
+		if len(phoneResult.RecentAttempts) != 1 || phoneResult.LastDeliveredAt == nil {
+			t.Errorf("Expected the earlier check in the history, got %+v", phoneResult.RecentAttempts)
+		}
+		if !containsString(result.Issues, PushIssueDeliveryFailed) {
+			t.Errorf("Expected delivery_failed, got %v", result.Issues)
+		}
+	})
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPushDiagnosticsCooldown(t *testing.T) {
+	cleanup := setupAccountTestDB(t)
+	defer cleanup()
+
+	ps := newPushService()
+	provider := &MockPushProvider{name: ProviderFCM, enabled: true}
+	ps.RegisterProvider(provider)
+	ps.diagnostics.period = time.Minute
+
+	database.DB.Create(&models.DeviceToken{UserID: "user-1", Token: "phone", Platform: models.PlatformAndroid, Provider: ProviderFCM})
+
+	if _, err := ps.RunPushDiagnostics("user-1"); err != nil {
+		t.Fatalf("First check failed: %v", err)
+	}
+	_, err := ps.RunPushDiagnostics("user-1")
+	var cooldown *PushDiagnosticsCooldownError
+	if !errors.As(err, &cooldown) || !errors.Is(err, ErrPushDiagnosticsCooldown) {
+		t.Fatalf("Expected a cooldown error, got %v", err)
+	}
+	if retry := cooldown.RetryAfter(); retry <= 0 || retry > time.Minute {
+		t.Errorf("Unexpected retry after %v", retry)
+	}
+	if provider.sentCount != 1 {
+		t.Errorf("Expected only the first check to send a push, got %d sends", provider.sentCount)
+	}
+
+	if _, err := ps.RunPushDiagnostics("user-2"); err != nil {
+		t.Errorf("Cooldown should be per user, got %v", err)
+	}
+
+	ps.diagnostics.last["user-1"] = time.Now().Add(-time.Minute)
+	if _, err := ps.RunPushDiagnostics("user-1"); err != nil {
+		t.Errorf("Check should be allowed once the cooldown passed, got %v", err)
+	}
+}
@@ -0,0 +1,122 @@
+package services
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"strings"
+)
+
+// Private pushes are encrypted to a key the device registered, with the same
+// scheme browsers use for Web Push: RFC 8291 key derivation and the RFC 8188
+// aes128gcm content encoding. Apps can decrypt them with any Web Push library.
+const (
+	pushRecordSize   = 4096
+	pushPaddingBlock = 64 // Plaintext is padded to a multiple of this to hide its length
+)
+
+var ErrInvalidPushKey = errors.New("push key must be a base64url P-256 public key and a 16-byte auth secret")
+
+// ValidatePushKey checks a device push key before it is stored
+func ValidatePushKey(p256dh, auth string) error {
+	public, err := decodePushKey(p256dh)
+	if err != nil {
+		return ErrInvalidPushKey
+	}
+	if _, err := ecdh.P256().NewPublicKey(public); err != nil {
+		return ErrInvalidPushKey
+	}
+	secret, err := decodePushKey(auth)
+	if err != nil || len(secret) != 16 {
+		return ErrInvalidPushKey
+	}
+	return nil
+}
+
+// EncryptPushPayload encrypts plaintext to a device push key and returns the
+// aes128gcm body (salt, record size, sender key and ciphertext), base64url
+// encoded without padding
+func EncryptPushPayload(plaintext []byte, p256dh, auth string) (string, error) {
+	if err := ValidatePushKey(p256dh, auth); err != nil {
+		return "", err
+	}
+	receiverBytes, _ := decodePushKey(p256dh)
+	authSecret, _ := decodePushKey(auth)
+	receiver, _ := ecdh.P256().NewPublicKey(receiverBytes)
+
+	// A fresh sender key per message
+	sender, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		return "", err
+	}
+	shared, err := sender.ECDH(receiver)
+	if err != nil {
+		return "", err
+	}
+	senderBytes := sender.PublicKey().Bytes()
+
+	info := "WebPush: info\x00" + string(receiverBytes) + string(senderBytes)
+	ikm, err := hkdf.Key(sha256.New, shared, authSecret, info, 32)
+	if err != nil {
+		return "", err
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	contentKey, err := hkdf.Key(sha256.New, ikm, salt, "Content-Encoding: aes128gcm\x00", 16)
+	if err != nil {
+		return "", err
+	}
+	nonce, err := hkdf.Key(sha256.New, ikm, salt, "Content-Encoding: nonce\x00", 12)
+	if err != nil {
+		return "", err
+	}
+
+	block, err := aes.NewCipher(contentKey)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	// One record: the plaintext, the last-record delimiter, then zero padding
+	padded := make([]byte, 0, len(plaintext)+pushPaddingBlock)
+	padded = append(padded, plaintext...)
+	padded = append(padded, 0x02)
+	if rem := len(padded) % pushPaddingBlock; rem != 0 {
+		padded = append(padded, make([]byte, pushPaddingBlock-rem)...)
+	}
+	if len(padded)+gcm.Overhead() > pushRecordSize {
+		return "", errors.New("push payload too large to encrypt")
+	}
+
+	var body bytes.Buffer
+	body.Write(salt)
+	binary.Write(&body, binary.BigEndian, uint32(pushRecordSize))
+	body.WriteByte(byte(len(senderBytes)))
+	body.Write(senderBytes)
+	body.Write(gcm.Seal(nil, nonce, padded, nil))
+
+	return base64.RawURLEncoding.EncodeToString(body.Bytes()), nil
+}
+
+// decodePushKey accepts the base64url keys browsers and most libraries
+// produce, with or without padding, as well as standard base64
+func decodePushKey(key string) ([]byte, error) {
+	key = strings.TrimRight(key, "=")
+	if decoded, err := base64.RawURLEncoding.DecodeString(key); err == nil {
+		return decoded, nil
+	}
+	return base64.RawStdEncoding.DecodeString(key)
+}
@@ -0,0 +1,131 @@
+package services
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"testing"
+)
+
+// testPushKey is a device push key pair, as an app would generate it
+type testPushKey struct {
+	private *ecdh.PrivateKey
+	auth    []byte
+}
+
+func newTestPushKey(t *testing.T) *testPushKey {
+	t.Helper()
+	private, err := ecdh.P256().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth := make([]byte, 16)
+	rand.Read(auth)
+	return &testPushKey{private: private, auth: auth}
+}
+
+func (k *testPushKey) p256dh() string {
+	return base64.RawURLEncoding.EncodeToString(k.private.PublicKey().Bytes())
+}
+
+func (k *testPushKey) authSecret() string {
+	return base64.RawURLEncoding.EncodeToString(k.auth)
+}
+
+// decrypt reverses EncryptPushPayload the way a device would (RFC 8291)
+func (k *testPushKey) decrypt(t *testing.T, sealed string) []byte {
+	t.Helper()
+	body, err := base64.RawURLEncoding.DecodeString(sealed)
+	if err != nil {
+		t.Fatalf("Payload is not base64url: %v", err)
+	}
+	salt := body[:16]
+	if rs := binary.BigEndian.Uint32(body[16:20]); rs != pushRecordSize {
+		t.Fatalf("Unexpected record size %d", rs)
+	}
+	keyLen := int(body[20])
+	senderBytes := body[21 : 21+keyLen]
+	ciphertext := body[21+keyLen:]
+
+	sender, err := ecdh.P256().NewPublicKey(senderBytes)
+	if err != nil {
+		t.Fatalf("Invalid sender key: %v", err)
+	}
+	shared, _ := k.private.ECDH(sender)
+	info := "WebPush: info\x00" + string(k.private.PublicKey().Bytes()) + string(senderBytes)
+	ikm, _ := hkdf.Key(sha256.New, shared, k.auth, info, 32)
+	contentKey, _ := hkdf.Key(sha256.New, ikm, salt, "Content-Encoding: aes128gcm\x00", 16)
+	nonce, _ := hkdf.Key(sha256.New, ikm, salt, "Content-Encoding: nonce\x00", 12)
+
+	block, _ := aes.NewCipher(contentKey)
+	gcm, _ := cipher.NewGCM(block)
+	padded, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		t.Fatalf("Decryption failed: %v", err)
+	}
+	end := bytes.LastIndexByte(padded, 0x02)
+	if end < 0 {
+		t.Fatal("Missing padding delimiter")
+	}
+	return padded[:end]
+}
+
+func TestEncryptPushPayload(t *testing.T) {
+	key := newTestPushKey(t)
+	plaintext := []byte(`{"title":"alice","body":"See you at 8"}`)
+
+	sealed, err := EncryptPushPayload(plaintext, key.p256dh(), key.authSecret())
+	if err != nil {
+		t.Fatalf("EncryptPushPayload failed: %v", err)
+	}
+	if got := key.decrypt(t, sealed); !bytes.Equal(got, plaintext) {
+		t.Errorf("Expected %q, got %q", plaintext, got)
+	}
+
+	again, _ := EncryptPushPayload(plaintext, key.p256dh(), key.authSecret())
+	if again == sealed {
+		t.Error("Each push should use a fresh key and salt")
+	}
+
+	short, _ := EncryptPushPayload([]byte("hi"), key.p256dh(), key.authSecret())
+	if len(short) != len(sealed) {
+		t.Error("Payloads of similar length should be padded to the same size")
+	}
+}
+
+func TestValidatePushKey(t *testing.T) {
+	key := newTestPushKey(t)
+	padded := base64.URLEncoding.EncodeToString(key.private.PublicKey().Bytes())
+
+	tests := []struct {
+		name   string
+		p256dh string
+		auth   string
+		valid  bool
+	}{
+		{"valid", key.p256dh(), key.authSecret(), true},
+		{"padded base64url", padded, key.authSecret(), true},
+		{"not a point", base64.RawURLEncoding.EncodeToString(make([]byte, 65)), key.authSecret(), false},
+		{"short auth", key.p256dh(), base64.RawURLEncoding.EncodeToString([]byte("short")), false},
+		{"not base64", "!!!", key.authSecret(), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidatePushKey(tt.p256dh, tt.auth)
+			if tt.valid && err != nil {
+				t.Errorf("Expected key to be valid, got %v", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidPushKey) {
+				t.Errorf("Expected ErrInvalidPushKey, got %v", err)
+			}
+		})
+	}
+}
@@ -5,7 +5,9 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strconv"
 	"sync"
+	"time"
 
 	firebase "firebase.google.com/go/v4"
 	"firebase.google.com/go/v4/messaging"
@@ -24,7 +26,7 @@ func NewFirebasePushProvider() *FirebasePushProvider {
 }
 
 func (p *FirebasePushProvider) Name() string {
-	return "fcm"
+	return ProviderFCM
 }
 
 func (p *FirebasePushProvider) Initialize(ctx context.Context) error {
@@ -101,23 +103,40 @@ func (p *FirebasePushProvider) Send(ctx context.Context, tokens []string, notifi
 	}
 
 	// Collect failed tokens
-	var failedTokens []string
+	var failedTokens, retryTokens []string
+	var retryErr error
 	if response.FailureCount > 0 {
 		for i, resp := range response.Responses {
 			if !resp.Success {
 				log.Printf("FCM: Failed to send to token: %v", resp.Error)
 				if p.isInvalidTokenError(resp.Error) {
 					failedTokens = append(failedTokens, tokens[i])
+				} else if p.isRetryableError(resp.Error) {
+					retryTokens = append(retryTokens, tokens[i])
+					retryErr = resp.Error
 				}
 			}
 		}
 	}
 
 	log.Printf("FCM: %d success, %d failures", response.SuccessCount, response.FailureCount)
+	if len(retryTokens) > 0 {
+		return failedTokens, &RetryableTokensError{Tokens: retryTokens, Err: retryErr}
+	}
 	return failedTokens, nil
 }
 
 func (p *FirebasePushProvider) buildMessage(tokens []string, notification *Notification) *messaging.MulticastMessage {
+	if notification.Call {
+		return p.buildCallMessage(tokens, notification)
+	}
+	if notification.Silent {
+		return p.buildSilentMessage(tokens, notification)
+	}
+	if notification.DataOnly {
+		return p.buildDataMessage(tokens, notification)
+	}
+
 	msg := &messaging.MulticastMessage{
 		Tokens: tokens,
 		Notification: &messaging.Notification{
@@ -148,16 +167,23 @@ func (p *FirebasePushProvider) buildMessage(tokens []string, notification *Notif
 			},
 		}
 	}
+	msg.Android.CollapseKey = notification.CollapseID
+	msg.Android.Notification.Tag = notification.ThreadID
+	msg.Android.Notification.NotificationCount = notification.badgeCount()
+	msg.Android.Notification.TitleLocKey = notification.TitleLocKey
+	msg.Android.Notification.TitleLocArgs = notification.TitleLocArgs
+	msg.Android.Notification.BodyLocKey = notification.BodyLocKey
+	msg.Android.Notification.BodyLocArgs = notification.BodyLocArgs
 
 	// iOS/APNs config
 	if notification.IOS != nil {
 		msg.APNS = &messaging.APNSConfig{
 			Payload: &messaging.APNSPayload{
 				Aps: &messaging.Aps{
-					Badge:            notification.IOS.Badge,
+					Badge:            notification.badgeCount(),
 					Sound:            notification.IOS.Sound,
 					Category:         notification.IOS.Category,
-					ThreadID:         notification.IOS.ThreadID,
+					ThreadID:         notification.threadID(),
 					ContentAvailable: notification.IOS.ContentAvailable,
 					MutableContent:   notification.IOS.MutableContent,
 				},
@@ -169,16 +195,148 @@ func (p *FirebasePushProvider) buildMessage(tokens []string, notification *Notif
 				Aps: &messaging.Aps{
 					Badge:            notification.Badge,
 					Sound:            "default",
+					ThreadID:         notification.ThreadID,
 					ContentAvailable: true,
 					MutableContent:   true,
 				},
 			},
 		}
 	}
+	if notification.TitleLocKey != "" || notification.BodyLocKey != "" {
+		msg.APNS.Payload.Aps.Alert = apsAlert(notification)
+	}
+	if notification.CollapseID != "" {
+		msg.APNS.Headers = map[string]string{"apns-collapse-id": notification.CollapseID}
+	}
+
+	return msg
+}
+
+// apsAlert builds the iOS alert, with loc keys for apps that localize the
+// text themselves
+func apsAlert(notification *Notification) *messaging.ApsAlert {
+	return &messaging.ApsAlert{
+		Title:        notification.Title,
+		Body:         notification.Body,
+		TitleLocKey:  notification.TitleLocKey,
+		TitleLocArgs: notification.TitleLocArgs,
+		LocKey:       notification.BodyLocKey,
+		LocArgs:      notification.BodyLocArgs,
+	}
+}
+
+// buildSilentMessage builds a data-only message that wakes the app without
+// showing anything, carrying the badge count for iOS
+func (p *FirebasePushProvider) buildSilentMessage(tokens []string, notification *Notification) *messaging.MulticastMessage {
+	return &messaging.MulticastMessage{
+		Tokens: tokens,
+		Data:   notification.Data,
+		Android: &messaging.AndroidConfig{
+			Priority:    "normal",
+			CollapseKey: notification.CollapseID,
+		},
+		APNS: &messaging.APNSConfig{
+			Headers: map[string]string{
+				"apns-push-type": "background",
+				"apns-priority":  "5",
+			},
+			Payload: &messaging.APNSPayload{
+				Aps: &messaging.Aps{
+					Badge:            notification.badgeCount(),
+					ContentAvailable: true,
+				},
+			},
+		},
+	}
+}
+
+// buildDataMessage builds a message the app displays itself. Android gets
+// only data, at high priority so the app wakes at once; iOS gets a mutable
+// alert for the notification service extension to fill in.
+func (p *FirebasePushProvider) buildDataMessage(tokens []string, notification *Notification) *messaging.MulticastMessage {
+	return &messaging.MulticastMessage{
+		Tokens: tokens,
+		Data:   notification.Data,
+		Android: &messaging.AndroidConfig{
+			Priority:    "high",
+			CollapseKey: notification.CollapseID,
+		},
+		APNS: &messaging.APNSConfig{
+			Payload: &messaging.APNSPayload{
+				Aps: &messaging.Aps{
+					Alert:          apsAlert(notification),
+					Badge:          notification.badgeCount(),
+					Sound:          "default",
+					ThreadID:       notification.threadID(),
+					MutableContent: true,
+				},
+			},
+		},
+	}
+}
+
+// buildCallMessage builds an incoming call or cancellation. Android gets a
+// high-priority data message, so the app can show its full-screen call UI
+// even in Doze; iOS gets a time-sensitive alert, or for a cancellation a
+// background push the app clears the ringing notification on.
+func (p *FirebasePushProvider) buildCallMessage(tokens []string, notification *Notification) *messaging.MulticastMessage {
+	msg := &messaging.MulticastMessage{
+		Tokens: tokens,
+		Data:   notification.Data,
+		Android: &messaging.AndroidConfig{
+			Priority:    "high",
+			CollapseKey: notification.CollapseID,
+		},
+	}
+	headers := map[string]string{}
+	if notification.TTL > 0 {
+		ttl := notification.TTL
+		msg.Android.TTL = &ttl
+		headers["apns-expiration"] = strconv.FormatInt(time.Now().Add(ttl).Unix(), 10)
+	}
+
+	if notification.Silent {
+		headers["apns-push-type"] = "background"
+		headers["apns-priority"] = "5"
+		msg.APNS = &messaging.APNSConfig{
+			Headers: headers,
+			Payload: &messaging.APNSPayload{
+				Aps: &messaging.Aps{ContentAvailable: true},
+			},
+		}
+		return msg
+	}
 
+	headers["apns-priority"] = "10"
+	if notification.CollapseID != "" {
+		headers["apns-collapse-id"] = notification.CollapseID
+	}
+	aps := &messaging.Aps{
+		Alert:    apsAlert(notification),
+		Sound:    "default",
+		ThreadID: notification.threadID(),
+		CustomData: map[string]interface{}{
+			"interruption-level": "time-sensitive",
+		},
+	}
+	if notification.IOS != nil {
+		if notification.IOS.Sound != "" {
+			aps.Sound = notification.IOS.Sound
+		}
+		aps.Category = notification.IOS.Category
+	}
+	msg.APNS = &messaging.APNSConfig{
+		Headers: headers,
+		Payload: &messaging.APNSPayload{Aps: aps},
+	}
 	return msg
 }
 
+// isRetryableError reports per-token failures that may succeed later
+func (p *FirebasePushProvider) isRetryableError(err error) bool {
+	return messaging.IsUnavailable(err) || messaging.IsInternal(err) || messaging.IsQuotaExceeded(err)
+}
+
 func (p *FirebasePushProvider) isInvalidTokenError(err error) bool {
 	if err == nil {
 		return false
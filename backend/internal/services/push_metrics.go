@@ -0,0 +1,161 @@
+package services
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// PushLatencyBuckets are the histogram upper bounds, in seconds, for push
+// delivery latency and provider send duration
+var PushLatencyBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 300, 1800}
+
+// Histogram is a cumulative histogram in the Prometheus format
+type Histogram struct {
+	Buckets []uint64 // Cumulative counts, one per PushLatencyBuckets bound
+	Sum     float64
+	Count   uint64
+}
+
+func (h *Histogram) observe(seconds float64) {
+	if h.Buckets == nil {
+		h.Buckets = make([]uint64, len(PushLatencyBuckets))
+	}
+	for i, bound := range PushLatencyBuckets {
+		if seconds <= bound {
+			h.Buckets[i]++
+		}
+	}
+	h.Sum += seconds
+	h.Count++
+}
+
+func (h Histogram) clone() Histogram {
+	h.Buckets = append([]uint64(nil), h.Buckets...)
+	if h.Buckets == nil {
+		h.Buckets = make([]uint64, len(PushLatencyBuckets))
+	}
+	return h
+}
+
+// PushProviderStats are the push counters for one provider
+type PushProviderStats struct {
+	Routed          uint64    `json:"routed"`           // Tokens routed to the provider they were registered with
+	FallbackRouted  uint64    `json:"fallback_routed"`  // Tokens routed here because their own provider was unavailable
+	Unroutable      uint64    `json:"unroutable"`       // Tokens for this provider skipped because it and the fallback were unavailable
+	Delivered       uint64    `json:"delivered"`        // Jobs delivered to all their tokens
+	AttemptFailures uint64    `json:"attempt_failures"` // Send attempts that returned an error
+	Retries         uint64    `json:"retries"`          // Jobs rescheduled after a retryable error
+	DeadLettered    uint64    `json:"dead_lettered"`    // Jobs moved to the dead-letter table
+	InvalidTokens   uint64    `json:"invalid_tokens"`   // Tokens the provider rejected as unregistered
+	TokenSuccesses  uint64    `json:"token_successes"`  // Sends to one device the provider accepted
+	TokenFailures   uint64    `json:"token_failures"`   // Sends to one device that failed for any reason
+	Latency         Histogram `json:"-"`                // Enqueue to delivery
+	SendDuration    Histogram `json:"-"`                // One call to the provider
+}
+
+// pushMetrics collects per-provider push counters for /metrics
+type pushMetrics struct {
+	mu        sync.Mutex
+	providers map[string]*PushProviderStats
+}
+
+func newPushMetrics() *pushMetrics {
+	return &pushMetrics{providers: make(map[string]*PushProviderStats)}
+}
+
+func (m *pushMetrics) update(provider string, fn func(s *PushProviderStats)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	stats, ok := m.providers[provider]
+	if !ok {
+		stats = &PushProviderStats{}
+		m.providers[provider] = stats
+	}
+	fn(stats)
+}
+
+func (m *pushMetrics) routed(provider string) {
+	m.update(provider, func(s *PushProviderStats) { s.Routed++ })
+}
+
+func (m *pushMetrics) fellBack(provider string) {
+	m.update(provider, func(s *PushProviderStats) { s.FallbackRouted++ })
+}
+
+func (m *pushMetrics) unroutable(provider string) {
+	m.update(provider, func(s *PushProviderStats) { s.Unroutable++ })
+}
+
+func (m *pushMetrics) sent(provider string, duration time.Duration, err error) {
+	m.update(provider, func(s *PushProviderStats) {
+		s.SendDuration.observe(duration.Seconds())
+		if err != nil {
+			s.AttemptFailures++
+		}
+	})
+}
+
+func (m *pushMetrics) delivered(provider string, latency time.Duration) {
+	m.update(provider, func(s *PushProviderStats) {
+		s.Delivered++
+		s.Latency.observe(latency.Seconds())
+	})
+}
+
+func (m *pushMetrics) retried(provider string) {
+	m.update(provider, func(s *PushProviderStats) { s.Retries++ })
+}
+
+func (m *pushMetrics) deadLettered(provider string) {
+	m.update(provider, func(s *PushProviderStats) { s.DeadLettered++ })
+}
+
+func (m *pushMetrics) invalidTokens(provider string, n int) {
+	m.update(provider, func(s *PushProviderStats) { s.InvalidTokens += uint64(n) })
+}
+
+func (m *pushMetrics) tokenOutcomes(provider string, successes, failures int) {
+	m.update(provider, func(s *PushProviderStats) {
+		s.TokenSuccesses += uint64(successes)
+		s.TokenFailures += uint64(failures)
+	})
+}
+
+// snapshot copies the counters so they can be read without holding the lock
+func (m *pushMetrics) snapshot() map[string]PushProviderStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make(map[string]PushProviderStats, len(m.providers))
+	for name, stats := range m.providers {
+		copied := *stats
+		copied.Latency = stats.Latency.clone()
+		copied.SendDuration = stats.SendDuration.clone()
+		out[name] = copied
+	}
+	return out
+}
+
+// PushQueueStats is a point-in-time view of the push queue for /metrics
+type PushQueueStats struct {
+	Depth         map[string]int64             `json:"depth"`                  // Queued jobs per provider
+	OldestJobAge  float64                      `json:"oldest_job_age_seconds"` // 0 when the queue is empty
+	DeadLetters   int64                        `json:"dead_letters"`
+	Providers     map[string]PushProviderStats `json:"providers"`
+	ProviderNames []string                     `json:"-"` // Sorted union of the providers in Depth and Providers
+}
+
+func (s *PushQueueStats) collectProviderNames() {
+	seen := make(map[string]bool)
+	for name := range s.Depth {
+		seen[name] = true
+	}
+	for name := range s.Providers {
+		seen[name] = true
+	}
+	s.ProviderNames = make([]string, 0, len(seen))
+	for name := range seen {
+		s.ProviderNames = append(s.ProviderNames, name)
+	}
+	sort.Strings(s.ProviderNames)
+}
@@ -0,0 +1,124 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"messenger/internal/database"
+	"messenger/internal/models"
+)
+
+// privatePushContent is what an encrypted private push carries, so the app
+// can show the notification without fetching the message first
+type privatePushContent struct {
+	Title    string            `json:"title"`
+	Body     string            `json:"body"`
+	Data     map[string]string `json:"data,omitempty"`
+	ThreadID string            `json:"thread_id,omitempty"`
+}
+
+// contentFreeNotification returns the wake-up sent in place of notification
+// in privacy mode. It keeps the notification type, message ID and badge, but
+// no names, text, conversation IDs or thread IDs for the provider to see.
+func contentFreeNotification(notification *Notification) *Notification {
+	data := map[string]string{"type": notification.Data["type"]}
+	if messageID := notification.Data["message_id"]; messageID != "" {
+		data["message_id"] = messageID
+	}
+
+	return &Notification{
+		Body:       HiddenPreviewBody,
+		BodyLocKey: "push_new_message",
+		Data:       data,
+		Badge:      notification.Badge,
+		Sound:      "default",
+		DataOnly:   true,
+		Android: &AndroidConfig{
+			ChannelID: "messages",
+			Priority:  "high",
+		},
+		IOS: &IOSConfig{
+			Sound:          "default",
+			MutableContent: true,
+		},
+	}
+}
+
+// SendPrivateToUser queues a notification for all devices of a user without
+// letting its content reach the push providers in the clear:
+//   - Web Push devices get it as is; Web Push payloads are always encrypted
+//     to the browser
+//   - devices that registered a push key get a content-free wake-up with the
+//     notification encrypted to that key in Data["enc"]
+//   - every other device gets the wake-up alone and fetches the message
+func (ps *PushService) SendPrivateToUser(userID string, notification *Notification) error {
+	if !ps.IsEnabled() {
+		return nil
+	}
+
+	tokens, err := models.GetUserTokens(database.DB, userID)
+	if err != nil {
+		return fmt.Errorf("failed to get user tokens: %w", err)
+	}
+
+	tokens = messageTokens(tokens)
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	locale := UserLocale(userID)
+	notification = notification.localized(locale)
+	wakeup := contentFreeNotification(notification).localized(locale)
+	content, err := json.Marshal(privatePushContent{
+		Title:    notification.Title,
+		Body:     notification.Body,
+		Data:     notification.Data,
+		ThreadID: notification.ThreadID,
+	})
+	if err != nil {
+		return err
+	}
+
+	webPushProvider, ok := ps.GetProvider(ProviderWebPush)
+	webPushEnabled := ok && webPushProvider.IsEnabled()
+	var webPush, plain []models.DeviceToken
+	var lastErr error
+	for _, token := range tokens {
+		switch {
+		case tokenProvider(token) == ProviderWebPush && webPushEnabled:
+			webPush = append(webPush, token)
+		case token.PushKey != "":
+			// Each device needs its own ciphertext, so its own job
+			sealed, err := EncryptPushPayload(content, token.PushKey, token.PushAuth)
+			if err != nil {
+				log.Printf("Failed to encrypt private push for device %s: %v", token.ID, err)
+				plain = append(plain, token)
+				continue
+			}
+			encrypted := *wakeup
+			encrypted.Data = map[string]string{"enc": sealed}
+			for key, value := range wakeup.Data {
+				encrypted.Data[key] = value
+			}
+			if err := ps.enqueueForTokens(userID, []models.DeviceToken{token}, &encrypted); err != nil {
+				lastErr = err
+			}
+		default:
+			plain = append(plain, token)
+		}
+	}
+
+	if len(webPush) > 0 {
+		if err := ps.enqueueForTokens(userID, webPush, notification); err != nil {
+			lastErr = err
+		}
+	}
+	if len(plain) > 0 {
+		if err := ps.enqueueForTokens(userID, plain, wakeup); err != nil {
+			lastErr = err
+		}
+	}
+
+	return lastErr
+}
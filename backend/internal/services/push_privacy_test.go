@@ -0,0 +1,105 @@
+package services
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"messenger/internal/database"
+	"messenger/internal/models"
+)
+
+func TestSendPrivateToUser(t *testing.T) {
+	cleanup := setupAccountTestDB(t)
+	defer cleanup()
+
+	ps := newPushService()
+	ps.RegisterProvider(&MockPushProvider{name: ProviderFCM, enabled: true})
+	ps.RegisterProvider(&MockPushProvider{name: ProviderWebPush, enabled: true})
+
+	key := newTestPushKey(t)
+	database.DB.Create(&models.DeviceToken{UserID: "user-1", Token: "keyed-phone", Platform: models.PlatformAndroid, Provider: ProviderFCM, PushKey: key.p256dh(), PushAuth: key.authSecret()})
+	database.DB.Create(&models.DeviceToken{UserID: "user-1", Token: "plain-phone", Platform: models.PlatformAndroid, Provider: ProviderFCM})
+	database.DB.Create(&models.DeviceToken{UserID: "user-1", Token: testSubscription, Platform: models.PlatformWeb, Provider: ProviderWebPush})
+
+	notification := NewMessageNotification("alice", "See you at 8", "alice-id", false)
+	notification.Data["message_id"] = "message-1"
+	notification.ThreadID = "dm:alice-id"
+	if err := ps.SendPrivateToUser("user-1", notification); err != nil {
+		t.Fatalf("SendPrivateToUser failed: %v", err)
+	}
+
+	var jobs []models.PushJob
+	database.DB.Find(&jobs)
+	if len(jobs) != 3 {
+		t.Fatalf("Expected a job for the keyed device, the plain device and the browser, got %d", len(jobs))
+	}
+
+	for _, job := range jobs {
+		var tokens []string
+		json.Unmarshal([]byte(job.Tokens), &tokens)
+		var queued Notification
+		json.Unmarshal([]byte(job.Payload), &queued)
+
+		switch tokens[0] {
+		case testSubscription:
+			if queued.Body != "See you at 8" {
+				t.Errorf("Web Push is encrypted to the browser and should keep the content, got %+v", queued)
+			}
+			continue
+		case "keyed-phone":
+			var content privatePushContent
+			if err := json.Unmarshal(key.decrypt(t, queued.Data["enc"]), &content); err != nil {
+				t.Fatalf("Encrypted content is not JSON: %v", err)
+			}
+			if content.Title != "alice" || content.Body != "See you at 8" || content.Data["conversation_id"] != "alice-id" || content.ThreadID != "dm:alice-id" {
+				t.Errorf("Unexpected decrypted content %+v", content)
+			}
+		case "plain-phone":
+			if queued.Data["enc"] != "" {
+				t.Error("Device without a push key can't get an encrypted payload")
+			}
+		}
+
+		if strings.Contains(job.Payload, "See you at 8") || strings.Contains(job.Payload, "alice") {
+			t.Errorf("Content-free push leaks content: %s", job.Payload)
+		}
+		if !queued.DataOnly || queued.IOS == nil || !queued.IOS.MutableContent {
+			t.Errorf("Content-free push should be data-only with mutable content, got %+v", queued)
+		}
+		if queued.Data["message_id"] != "message-1" || queued.Data["type"] != "new_message" {
+			t.Errorf("Content-free push should carry the message ID, got %v", queued.Data)
+		}
+	}
+}
+
+func TestPushMessage_PrivatePushes(t *testing.T) {
+	cleanup := setupAccountTestDB(t)
+	defer cleanup()
+
+	ps := newPushService()
+	ps.RegisterProvider(&MockPushProvider{name: ProviderFCM, enabled: true})
+
+	svc := NewAuthService()
+	alice, _ := svc.Register(RegisterInput{Username: "alice", Password: "password123"})
+	bob, _ := svc.Register(RegisterInput{Username: "bob", Password: "password123"})
+	aliceID, bobID := alice.User.ID, bob.User.ID
+	database.DB.Create(&models.DeviceToken{UserID: aliceID, Token: "alice-phone", Platform: models.PlatformAndroid, Provider: ProviderFCM})
+
+	private := true
+	if _, err := UpdateNotificationPreferences(aliceID, NotificationPreferencesInput{PrivatePushes: &private}); err != nil {
+		t.Fatalf("UpdateNotificationPreferences failed: %v", err)
+	}
+
+	message := models.Message{SenderID: bobID, RecipientID: &aliceID, Content: "secret plans"}
+	database.DB.Create(&message)
+	ps.pushMessage(database.DB, aliceID, bobID, message.ID, message.Content, false, aliceID)
+
+	notifications := queuedNotifications(t)
+	if len(notifications) != 1 {
+		t.Fatalf("Expected 1 push, got %d", len(notifications))
+	}
+	if got := notifications[0]; got.Body != HiddenPreviewBody || got.Title != "" || got.Data["message_id"] != message.ID || got.ThreadID != "" {
+		t.Errorf("Expected a content-free push, got %+v", got)
+	}
+}
@@ -3,6 +3,19 @@ package services
 import (
 	"context"
 	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Names of the built-in push providers
+const (
+	ProviderFCM         = "fcm"
+	ProviderAPNs        = "apns"
+	ProviderAPNsVoIP    = "apns_voip"
+	ProviderWebPush     = "webpush"
+	ProviderUnifiedPush = "unifiedpush"
+	ProviderWebhook     = "webhook"
 )
 
 // PushProvider defines the interface for push notification providers
@@ -15,7 +28,8 @@ type PushProvider interface {
 	Initialize(ctx context.Context) error
 
 	// Send delivers a notification to the specified tokens
-	// Returns a list of failed tokens that should be removed
+	// Returns a list of failed tokens that should be removed, and a
+	// RetryableTokensError for tokens worth trying again later
 	Send(ctx context.Context, tokens []string, notification *Notification) (failedTokens []string, err error)
 
 	// IsEnabled returns whether this provider is properly configured
@@ -42,12 +56,136 @@ type Notification struct {
 	Badge    *int
 	Sound    string
 	ImageURL string
+	// ThreadID groups notifications per conversation: APNs thread-id and
+	// the Android and web tag
+	ThreadID string
+	// CollapseID makes a newer notification replace an older one with the same ID
+	CollapseID string
+	// Silent sends a background push with no alert, e.g. to update the badge
+	Silent bool
+	// DataOnly leaves drawing the notification to the app: Android gets a
+	// data message, and iOS an alert its notification service extension
+	// rewrites (set IOS.MutableContent)
+	DataOnly bool
+	// Call marks an incoming call or call cancellation: sent at the highest
+	// priority, as a VoIP push to PushKit tokens and a high-priority data
+	// message through FCM. With Silent it's a cancellation that shows nothing.
+	Call bool
+	// TTL is how long the push service keeps trying to deliver; zero means
+	// the provider default. A call push is useless once the call stopped ringing.
+	TTL time.Duration
+	// TitleLocKey and BodyLocKey name message catalog entries for server-
+	// generated text, with their arguments. Title and Body hold the English
+	// text until the notification is localized for its recipient.
+	TitleLocKey  string
+	TitleLocArgs []string
+	BodyLocKey   string
+	BodyLocArgs  []string
 	// Platform-specific overrides (optional)
 	Android *AndroidConfig
 	IOS     *IOSConfig
 	Web     *WebConfig
 }
 
+// badgeCount returns the iOS badge override, or the shared badge count
+func (n *Notification) badgeCount() *int {
+	if n.IOS != nil && n.IOS.Badge != nil {
+		return n.IOS.Badge
+	}
+	return n.Badge
+}
+
+// threadID returns the iOS thread override, or the shared thread ID
+func (n *Notification) threadID() string {
+	if n.IOS != nil && n.IOS.ThreadID != "" {
+		return n.IOS.ThreadID
+	}
+	return n.ThreadID
+}
+
+// ttlSeconds returns the notification's TTL in seconds, or def when unset
+func (n *Notification) ttlSeconds(def int) int {
+	if n.TTL > 0 {
+		return int(n.TTL.Seconds())
+	}
+	return def
+}
+
+// localized returns a copy of the notification with its catalog text in the
+// recipient's locale. The loc keys are kept for apps that localize pushes
+// themselves only with PUSH_LOC_KEYS_ENABLED: iOS shows the bare key when
+// the app doesn't have it.
+func (n *Notification) localized(locale string) *Notification {
+	if n.TitleLocKey == "" && n.BodyLocKey == "" {
+		return n
+	}
+
+	localized := *n
+	if n.TitleLocKey != "" {
+		localized.Title = Localize(locale, n.TitleLocKey, n.TitleLocArgs...)
+	}
+	if n.BodyLocKey != "" {
+		localized.Body = Localize(locale, n.BodyLocKey, n.BodyLocArgs...)
+	}
+	if keep, _ := strconv.ParseBool(os.Getenv("PUSH_LOC_KEYS_ENABLED")); !keep {
+		localized.TitleLocKey, localized.TitleLocArgs = "", nil
+		localized.BodyLocKey, localized.BodyLocArgs = "", nil
+	}
+	return &localized
+}
+
+// jsonPayload is the provider-neutral JSON form of a notification, sent by
+// the providers that deliver plain HTTP requests (UnifiedPush and webhooks)
+func (n *Notification) jsonPayload() map[string]interface{} {
+	payload := map[string]interface{}{
+		"title": n.Title,
+		"body":  n.Body,
+	}
+	if len(n.Data) > 0 {
+		payload["data"] = n.Data
+	}
+	if n.Badge != nil {
+		payload["badge"] = *n.Badge
+	}
+	if n.Sound != "" {
+		payload["sound"] = n.Sound
+	}
+	if n.ImageURL != "" {
+		payload["image"] = n.ImageURL
+	}
+	if n.ThreadID != "" {
+		payload["thread_id"] = n.ThreadID
+	}
+	if n.CollapseID != "" {
+		payload["collapse_id"] = n.CollapseID
+	}
+	if n.Silent {
+		payload["silent"] = true
+	}
+	if n.DataOnly {
+		payload["data_only"] = true
+	}
+	if n.Call {
+		payload["call"] = true
+	}
+	if n.TTL > 0 {
+		payload["ttl"] = n.ttlSeconds(0)
+	}
+	if n.TitleLocKey != "" {
+		payload["title_loc_key"] = n.TitleLocKey
+	}
+	if len(n.TitleLocArgs) > 0 {
+		payload["title_loc_args"] = n.TitleLocArgs
+	}
+	if n.BodyLocKey != "" {
+		payload["body_loc_key"] = n.BodyLocKey
+	}
+	if len(n.BodyLocArgs) > 0 {
+		payload["body_loc_args"] = n.BodyLocArgs
+	}
+	return payload
+}
+
 // AndroidConfig contains Android-specific notification options
 type AndroidConfig struct {
 	ChannelID   string
@@ -123,8 +261,15 @@ func NewMessageNotification(senderName, content, conversationID string, isGroup
 	if len(body) > 100 {
 		body = body[:97] + "..."
 	}
-	if body == "" {
-		body = "Sent an attachment"
+	var bodyLocKey string
+	switch body {
+	case "":
+		bodyLocKey = "push_attachment"
+	case LocationPushBody:
+		bodyLocKey = "push_location"
+	}
+	if bodyLocKey != "" {
+		body = Localize(DefaultLocale, bodyLocKey)
 	}
 
 	data := map[string]string{
@@ -136,10 +281,11 @@ func NewMessageNotification(senderName, content, conversationID string, isGroup
 	}
 
 	return &Notification{
-		Title: title,
-		Body:  body,
-		Data:  data,
-		Sound: "default",
+		Title:      title,
+		Body:       body,
+		BodyLocKey: bodyLocKey,
+		Data:       data,
+		Sound:      "default",
 		Android: &AndroidConfig{
 			ChannelID:   "messages",
 			Priority:    "high",
@@ -153,8 +299,105 @@ func NewMessageNotification(senderName, content, conversationID string, isGroup
 	}
 }
 
+// NewMessageSummaryNotification creates a notification that stands in for a
+// burst of messages in one conversation, e.g. "5 new messages in Team"
+func NewMessageSummaryNotification(conversationName string, count int64, conversationID string, isGroup bool) *Notification {
+	bodyLocKey := "push_summary_dm"
+	if isGroup {
+		bodyLocKey = "push_summary_group"
+	}
+	args := []string{strconv.FormatInt(count, 10), conversationName}
+
+	notification := NewMessageNotification(conversationName, Localize(DefaultLocale, bodyLocKey, args...), conversationID, isGroup)
+	notification.BodyLocKey = bodyLocKey
+	notification.BodyLocArgs = args
+	notification.Data["type"] = "message_summary"
+	notification.Data["count"] = fmt.Sprintf("%d", count)
+	return notification
+}
+
+// NewTestNotification creates the notification sent by the "test push" endpoint
+func NewTestNotification() *Notification {
+	return &Notification{
+		Title:       Localize(DefaultLocale, "push_test_title"),
+		TitleLocKey: "push_test_title",
+		Body:        Localize(DefaultLocale, "push_test_body"),
+		BodyLocKey:  "push_test_body",
+		Data:        map[string]string{"type": "test"},
+	}
+}
+
+// NewBadgeUpdateNotification creates a silent push that sets the app badge,
+// e.g. after the user read messages on another device
+func NewBadgeUpdateNotification(unread int) *Notification {
+	return &Notification{
+		Badge:      &unread,
+		Silent:     true,
+		CollapseID: "badge",
+		Data: map[string]string{
+			"type":  "badge_update",
+			"badge": fmt.Sprintf("%d", unread),
+		},
+	}
+}
+
+// NewLoginAlertNotification creates a security notification for a login from a new device
+func NewLoginAlertNotification(device, sessionID string) *Notification {
+	return &Notification{
+		Title:       Localize(DefaultLocale, "push_login_alert_title"),
+		TitleLocKey: "push_login_alert_title",
+		Body:        Localize(DefaultLocale, "push_login_alert_body", device),
+		BodyLocKey:  "push_login_alert_body",
+		BodyLocArgs: []string{device},
+		Data: map[string]string{
+			"type":       "new_device_login",
+			"session_id": sessionID,
+		},
+		Sound: "default",
+		Android: &AndroidConfig{
+			ChannelID:   "security",
+			Priority:    "high",
+			ClickAction: "FLUTTER_NOTIFICATION_CLICK",
+		},
+		IOS: &IOSConfig{
+			Sound: "default",
+		},
+	}
+}
+
 // ErrProviderNotConfigured is returned when a provider is not properly configured
 var ErrProviderNotConfigured = fmt.Errorf("push provider not configured")
 
 // ErrNoProvidersAvailable is returned when no push providers are enabled
 var ErrNoProvidersAvailable = fmt.Errorf("no push providers available")
+
+// RetryableTokensError is returned by Send when delivery to some tokens failed
+// for a reason that may go away, such as a network error, a 5xx or throttling.
+// The push queue retries only those tokens. Any other error from Send is
+// retried for all tokens unless it is a PermanentPushError.
+type RetryableTokensError struct {
+	Tokens []string
+	Err    error
+}
+
+func (e *RetryableTokensError) Error() string {
+	return fmt.Sprintf("%d token(s) failed: %v", len(e.Tokens), e.Err)
+}
+
+func (e *RetryableTokensError) Unwrap() error {
+	return e.Err
+}
+
+// PermanentPushError marks a send failure that retrying won't fix, such as a
+// rejected payload or bad credentials
+type PermanentPushError struct {
+	Err error
+}
+
+func (e *PermanentPushError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentPushError) Unwrap() error {
+	return e.Err
+}
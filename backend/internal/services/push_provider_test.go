@@ -14,6 +14,8 @@ type MockPushProvider struct {
 	failedTokens   []string
 	sentCount      int
 	initializeErr  error
+	sentTokens     []string
+	lastSent       *Notification
 }
 
 func (m *MockPushProvider) Name() string {
@@ -26,6 +28,8 @@ func (m *MockPushProvider) Initialize(ctx context.Context) error {
 
 func (m *MockPushProvider) Send(ctx context.Context, tokens []string, notification *Notification) ([]string, error) {
 	m.sentCount += len(tokens)
+	m.sentTokens = append(m.sentTokens, tokens...)
+	m.lastSent = notification
 	return m.failedTokens, m.sendError
 }
 
@@ -0,0 +1,464 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand/v2"
+	"strings"
+	"sync"
+	"time"
+
+	"messenger/internal/database"
+	"messenger/internal/models"
+)
+
+// Push queue defaults, each overridable via environment
+const (
+	DefaultPushWorkers             = 4
+	DefaultPushProviderConcurrency = 2 // Sends in flight per provider
+	DefaultPushMaxAttempts         = 8
+	DefaultPushRetryBase           = 5 * time.Second
+	DefaultPushRetryMax            = time.Hour
+	DefaultPushJobTTL              = 24 * time.Hour // Undelivered notifications are stale after this
+	DefaultPushSendTimeout         = 30 * time.Second
+	DefaultPushDeadLetterRetention = 30 * 24 * time.Hour
+)
+
+// pushPollInterval is how often idle workers look for jobs that became due
+const pushPollInterval = time.Second
+
+var ErrDeadLetterNotFound = errors.New("dead letter not found")
+
+// PushQueue delivers push notifications from a persistent job table. Jobs
+// survive restarts, retryable failures back off exponentially, and jobs that
+// fail permanently or run out of attempts move to the dead-letter table.
+type PushQueue struct {
+	push        *PushService
+	workers     int
+	concurrency int
+	maxAttempts int
+	retryBase   time.Duration
+	retryMax    time.Duration
+	jobTTL      time.Duration
+	sendTimeout time.Duration
+
+	mu       sync.Mutex
+	limits   map[string]chan struct{} // Per-provider semaphores
+	wake     chan struct{}
+	stopChan chan struct{}
+	wg       sync.WaitGroup
+	running  bool
+
+	metrics *pushMetrics
+}
+
+// NewPushQueue creates a queue that sends through the providers of ps,
+// configured from PUSH_WORKERS, PUSH_PROVIDER_CONCURRENCY, PUSH_MAX_ATTEMPTS,
+// PUSH_RETRY_BASE_SECONDS, PUSH_RETRY_MAX_SECONDS and PUSH_JOB_TTL_HOURS
+func NewPushQueue(ps *PushService) *PushQueue {
+	workers := intFromEnv("PUSH_WORKERS", DefaultPushWorkers)
+	if workers < 1 {
+		workers = 1
+	}
+	concurrency := intFromEnv("PUSH_PROVIDER_CONCURRENCY", DefaultPushProviderConcurrency)
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	return &PushQueue{
+		push:        ps,
+		workers:     workers,
+		concurrency: concurrency,
+		maxAttempts: intFromEnv("PUSH_MAX_ATTEMPTS", DefaultPushMaxAttempts),
+		retryBase:   time.Duration(intFromEnv("PUSH_RETRY_BASE_SECONDS", int(DefaultPushRetryBase.Seconds()))) * time.Second,
+		retryMax:    time.Duration(intFromEnv("PUSH_RETRY_MAX_SECONDS", int(DefaultPushRetryMax.Seconds()))) * time.Second,
+		jobTTL:      time.Duration(intFromEnv("PUSH_JOB_TTL_HOURS", int(DefaultPushJobTTL.Hours()))) * time.Hour,
+		sendTimeout: DefaultPushSendTimeout,
+		limits:      make(map[string]chan struct{}),
+		wake:        make(chan struct{}, workers),
+		stopChan:    make(chan struct{}),
+		metrics:     newPushMetrics(),
+	}
+}
+
+// Start launches the worker pool
+func (q *PushQueue) Start() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.running {
+		return
+	}
+	q.running = true
+
+	for i := 0; i < q.workers; i++ {
+		q.wg.Add(1)
+		go q.worker()
+	}
+	log.Printf("Push queue started with %d worker(s), %d send(s) per provider", q.workers, q.concurrency)
+}
+
+// Stop waits for in-flight sends to finish and stops the workers. Queued
+// jobs stay in the database for the next start.
+func (q *PushQueue) Stop() {
+	q.mu.Lock()
+	if !q.running {
+		q.mu.Unlock()
+		return
+	}
+	q.running = false
+	close(q.stopChan)
+	q.mu.Unlock()
+
+	q.wg.Wait()
+}
+
+// Enqueue stores a notification for delivery to tokens through a provider
+func (q *PushQueue) Enqueue(userID, provider string, tokens []string, notification *Notification) error {
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	tokensJSON, err := json.Marshal(tokens)
+	if err != nil {
+		return err
+	}
+	payload, err := json.Marshal(notification)
+	if err != nil {
+		return fmt.Errorf("failed to encode notification: %w", err)
+	}
+
+	job := models.PushJob{
+		UserID:   userID,
+		Provider: provider,
+		Tokens:   string(tokensJSON),
+		Payload:  string(payload),
+	}
+	if err := database.DB.Create(&job).Error; err != nil {
+		return fmt.Errorf("failed to queue push notification: %w", err)
+	}
+
+	q.signal()
+	return nil
+}
+
+// signal wakes an idle worker without blocking
+func (q *PushQueue) signal() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (q *PushQueue) worker() {
+	defer q.wg.Done()
+
+	ticker := time.NewTicker(pushPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-q.stopChan:
+			return
+		default:
+		}
+
+		if q.processNext() {
+			continue
+		}
+
+		select {
+		case <-q.stopChan:
+			return
+		case <-q.wake:
+		case <-ticker.C:
+		}
+	}
+}
+
+// ProcessDue sends every job that is currently due and returns how many were
+// processed. Workers do this continuously; it is exported for tests and tools.
+func (q *PushQueue) ProcessDue() int {
+	processed := 0
+	for q.processNext() {
+		processed++
+	}
+	return processed
+}
+
+// processNext claims and sends one due job for a provider that has a free
+// send slot. Returns false if there was nothing to do.
+func (q *PushQueue) processNext() bool {
+	providers := q.push.enabledProviderNames()
+	rand.Shuffle(len(providers), func(i, j int) { providers[i], providers[j] = providers[j], providers[i] })
+
+	for _, provider := range providers {
+		slot := q.limit(provider)
+		select {
+		case slot <- struct{}{}:
+		default:
+			continue // Provider is at its concurrency limit
+		}
+
+		now := time.Now()
+		job, err := models.ClaimPushJob(database.DB, provider, now, now.Add(2*q.sendTimeout))
+		if err != nil {
+			log.Printf("Push queue: failed to claim job: %v", err)
+		}
+		if job == nil {
+			<-slot
+			continue
+		}
+
+		q.process(job)
+		<-slot
+		return true
+	}
+	return false
+}
+
+func (q *PushQueue) limit(provider string) chan struct{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	slot, ok := q.limits[provider]
+	if !ok {
+		slot = make(chan struct{}, intFromEnv("PUSH_"+envName(provider)+"_CONCURRENCY", q.concurrency))
+		q.limits[provider] = slot
+	}
+	return slot
+}
+
+// process sends one claimed job and records the outcome
+func (q *PushQueue) process(job *models.PushJob) {
+	if time.Since(job.CreatedAt) > q.jobTTL {
+		q.deadLetter(job, "expired before delivery")
+		return
+	}
+
+	var tokens []string
+	var notification Notification
+	if err := json.Unmarshal([]byte(job.Tokens), &tokens); err != nil {
+		q.deadLetter(job, "invalid tokens: "+err.Error())
+		return
+	}
+	if err := json.Unmarshal([]byte(job.Payload), &notification); err != nil {
+		q.deadLetter(job, "invalid payload: "+err.Error())
+		return
+	}
+
+	provider, ok := q.push.GetProvider(job.Provider)
+	if !ok || !provider.IsEnabled() {
+		q.retry(job, tokens, ErrProviderNotConfigured)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), q.sendTimeout)
+	start := time.Now()
+	invalidTokens, err := provider.Send(ctx, tokens, &notification)
+	cancel()
+	duration := time.Since(start)
+	q.metrics.sent(job.Provider, duration, err)
+	q.push.recordAttempts(pushSend{
+		provider:     job.Provider,
+		tokens:       tokens,
+		notification: &notification,
+		invalid:      invalidTokens,
+		err:          err,
+		latency:      duration,
+		final:        job.Attempts >= q.maxAttempts,
+	})
+
+	// Unregistered tokens are never retried
+	for _, token := range invalidTokens {
+		models.UnregisterToken(database.DB, token)
+	}
+	if len(invalidTokens) > 0 {
+		q.metrics.invalidTokens(job.Provider, len(invalidTokens))
+	}
+
+	if err == nil {
+		if err := models.DeletePushJob(database.DB, job.ID); err != nil {
+			log.Printf("Push queue: failed to delete delivered job %s: %v", job.ID, err)
+		}
+		q.metrics.delivered(job.Provider, time.Since(job.CreatedAt))
+		return
+	}
+
+	var permanent *PermanentPushError
+	if errors.As(err, &permanent) {
+		q.deadLetter(job, err.Error())
+		return
+	}
+
+	var partial *RetryableTokensError
+	if errors.As(err, &partial) {
+		tokens = partial.Tokens
+	}
+	q.retry(job, withoutTokens(tokens, invalidTokens), err)
+}
+
+// retry reschedules a job with exponential backoff, or dead-letters it once
+// it has used up its attempts
+func (q *PushQueue) retry(job *models.PushJob, tokens []string, cause error) {
+	if len(tokens) == 0 {
+		models.DeletePushJob(database.DB, job.ID)
+		return
+	}
+
+	tokensJSON, _ := json.Marshal(tokens)
+	job.Tokens = string(tokensJSON)
+
+	if job.Attempts >= q.maxAttempts {
+		q.deadLetter(job, cause.Error())
+		return
+	}
+
+	next := time.Now().Add(q.backoff(job.Attempts))
+	if err := models.ReschedulePushJob(database.DB, job.ID, job.Tokens, cause.Error(), next); err != nil {
+		log.Printf("Push queue: failed to reschedule job %s: %v", job.ID, err)
+		return
+	}
+	q.metrics.retried(job.Provider)
+}
+
+// backoff returns the delay before the next attempt: the base delay doubled
+// for each attempt so far, capped at the maximum, with jitter so retries
+// from one outage don't all land at once
+func (q *PushQueue) backoff(attempts int) time.Duration {
+	delay := q.retryBase
+	for i := 1; i < attempts && delay < q.retryMax; i++ {
+		delay *= 2
+	}
+	if delay > q.retryMax {
+		delay = q.retryMax
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay/2 + rand.N(delay/2+1)
+}
+
+func (q *PushQueue) deadLetter(job *models.PushJob, reason string) {
+	if err := models.DeadLetterPushJob(database.DB, job, reason); err != nil {
+		log.Printf("Push queue: failed to dead-letter job %s: %v", job.ID, err)
+		return
+	}
+	q.metrics.deadLettered(job.Provider)
+	log.Printf("Push queue: job %s for %s dead-lettered after %d attempt(s): %s", job.ID, job.Provider, job.Attempts, reason)
+}
+
+// Stats returns queue depth, dead letters and per-provider counters
+func (q *PushQueue) Stats() PushQueueStats {
+	depth, err := models.PushQueueDepth(database.DB)
+	if err != nil {
+		log.Printf("Push queue: failed to read queue depth: %v", err)
+	}
+
+	stats := PushQueueStats{
+		Depth:     depth,
+		Providers: q.metrics.snapshot(),
+	}
+	if oldest := models.OldestPushJob(database.DB); !oldest.IsZero() {
+		stats.OldestJobAge = time.Since(oldest).Seconds()
+	}
+	database.DB.Model(&models.PushDeadLetter{}).Count(&stats.DeadLetters)
+	stats.collectProviderNames()
+	return stats
+}
+
+// PushDeadLetterInfo is a dead letter as shown to admins. Device tokens are
+// left out; only their number is shown.
+type PushDeadLetterInfo struct {
+	*models.PushDeadLetter
+	Payload    json.RawMessage `json:"payload"`
+	TokenCount int             `json:"token_count"`
+}
+
+func newPushDeadLetterInfo(letter *models.PushDeadLetter) PushDeadLetterInfo {
+	var tokens []string
+	json.Unmarshal([]byte(letter.Tokens), &tokens)
+	return PushDeadLetterInfo{
+		PushDeadLetter: letter,
+		Payload:        json.RawMessage(letter.Payload),
+		TokenCount:     len(tokens),
+	}
+}
+
+// ListDeadLetters returns dead letters, newest first, optionally for one provider
+func (q *PushQueue) ListDeadLetters(provider string, limit, offset int) ([]PushDeadLetterInfo, int64, error) {
+	letters, total, err := models.ListPushDeadLetters(database.DB, provider, limit, offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	infos := make([]PushDeadLetterInfo, len(letters))
+	for i := range letters {
+		infos[i] = newPushDeadLetterInfo(&letters[i])
+	}
+	return infos, total, nil
+}
+
+// GetDeadLetter returns one dead letter
+func (q *PushQueue) GetDeadLetter(id string) (*PushDeadLetterInfo, error) {
+	letter, err := models.GetPushDeadLetter(database.DB, id)
+	if err != nil {
+		return nil, ErrDeadLetterNotFound
+	}
+	info := newPushDeadLetterInfo(letter)
+	return &info, nil
+}
+
+// RetryDeadLetter puts a dead letter back in the queue with fresh attempts
+func (q *PushQueue) RetryDeadLetter(id string) (*models.PushJob, error) {
+	job, ok, err := models.RequeuePushDeadLetter(database.DB, id)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrDeadLetterNotFound
+	}
+	q.signal()
+	return job, nil
+}
+
+// DeleteDeadLetter discards a dead letter
+func (q *PushQueue) DeleteDeadLetter(id string) error {
+	deleted, err := models.DeletePushDeadLetter(database.DB, id)
+	if err != nil {
+		return err
+	}
+	if !deleted {
+		return ErrDeadLetterNotFound
+	}
+	return nil
+}
+
+// DeleteOldPushDeadLetters removes dead letters past PUSH_DEAD_LETTER_RETENTION_DAYS
+func DeleteOldPushDeadLetters() (int64, error) {
+	retention := time.Duration(intFromEnv("PUSH_DEAD_LETTER_RETENTION_DAYS", int(DefaultPushDeadLetterRetention.Hours()/24))) * 24 * time.Hour
+	return models.DeletePushDeadLettersBefore(database.DB, time.Now().Add(-retention))
+}
+
+// withoutTokens returns tokens minus the ones in remove
+func withoutTokens(tokens, remove []string) []string {
+	if len(remove) == 0 {
+		return tokens
+	}
+	removed := make(map[string]bool, len(remove))
+	for _, token := range remove {
+		removed[token] = true
+	}
+	kept := tokens[:0:0]
+	for _, token := range tokens {
+		if !removed[token] {
+			kept = append(kept, token)
+		}
+	}
+	return kept
+}
+
+// envName turns a provider name into the form used in environment variables
+func envName(name string) string {
+	return strings.ToUpper(strings.ReplaceAll(name, "-", "_"))
+}
@@ -0,0 +1,165 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"messenger/internal/database"
+	"messenger/internal/models"
+)
+
+func setupPushQueueTest(t *testing.T, provider PushProvider) (*PushQueue, func()) {
+	t.Helper()
+	cleanup := setupAuthTestDB(t)
+	database.DB.AutoMigrate(&models.DeviceToken{})
+
+	ps := newPushService()
+	ps.RegisterProvider(provider)
+	q := ps.Queue()
+	q.retryBase = time.Minute
+	q.maxAttempts = 3
+	return q, cleanup
+}
+
+func enqueueTestPush(t *testing.T, q *PushQueue, provider string, tokens ...string) {
+	t.Helper()
+	if err := q.Enqueue("user-1", provider, tokens, &Notification{Title: "Hi", Body: "Hello"}); err != nil {
+		t.Fatalf("Enqueue failed: %v", err)
+	}
+}
+
+func queuedPushJob(t *testing.T) *models.PushJob {
+	t.Helper()
+	var jobs []models.PushJob
+	database.DB.Find(&jobs)
+	if len(jobs) != 1 {
+		t.Fatalf("Expected 1 queued job, got %d", len(jobs))
+	}
+	return &jobs[0]
+}
+
+func TestPushQueue_Delivers(t *testing.T) {
+	provider := &MockPushProvider{name: "mock", enabled: true}
+	q, cleanup := setupPushQueueTest(t, provider)
+	defer cleanup()
+
+	enqueueTestPush(t, q, "mock", "token-a", "token-b")
+	if processed := q.ProcessDue(); processed != 1 {
+		t.Fatalf("Expected 1 job processed, got %d", processed)
+	}
+	if provider.sentCount != 2 {
+		t.Errorf("Expected 2 tokens sent, got %d", provider.sentCount)
+	}
+
+	stats := q.Stats()
+	if stats.Depth["mock"] != 0 {
+		t.Error("Delivered job should leave the queue")
+	}
+	if stats.Providers["mock"].Delivered != 1 || stats.Providers["mock"].Latency.Count != 1 {
+		t.Errorf("Expected one delivery to be recorded, got %+v", stats.Providers["mock"])
+	}
+}
+
+func TestPushQueue_RetriesThenDeadLetters(t *testing.T) {
+	provider := &MockPushProvider{name: "mock", enabled: true, sendError: errors.New("connection reset")}
+	q, cleanup := setupPushQueueTest(t, provider)
+	defer cleanup()
+
+	enqueueTestPush(t, q, "mock", "token-a")
+	for i := 1; i < q.maxAttempts; i++ {
+		q.ProcessDue()
+		job := queuedPushJob(t)
+		if job.Attempts != i || job.LastError != "connection reset" {
+			t.Fatalf("Attempt %d: unexpected job state %+v", i, job)
+		}
+		database.DB.Model(job).Update("next_attempt_at", time.Now().Add(-time.Second))
+	}
+	q.ProcessDue()
+
+	stats := q.Stats()
+	if stats.Depth["mock"] != 0 || stats.DeadLetters != 1 {
+		t.Fatalf("Job should be dead-lettered after %d attempts, got %+v", q.maxAttempts, stats)
+	}
+	if stats.Providers["mock"].Retries != uint64(q.maxAttempts-1) || stats.Providers["mock"].DeadLettered != 1 {
+		t.Errorf("Unexpected counters %+v", stats.Providers["mock"])
+	}
+
+	letters, total, _ := q.ListDeadLetters("", 10, 0)
+	if total != 1 || letters[0].Attempts != q.maxAttempts || letters[0].TokenCount != 1 {
+		t.Fatalf("Unexpected dead letters %+v", letters)
+	}
+
+	t.Run("retry", func(t *testing.T) {
+		provider.sendError = nil
+		if _, err := q.RetryDeadLetter(letters[0].ID); err != nil {
+			t.Fatalf("RetryDeadLetter failed: %v", err)
+		}
+		if job := queuedPushJob(t); job.Attempts != 0 {
+			t.Errorf("Requeued job should start with no attempts, got %d", job.Attempts)
+		}
+		q.ProcessDue()
+		if q.Stats().Providers["mock"].Delivered != 1 {
+			t.Error("Requeued job should be delivered")
+		}
+		if _, err := q.RetryDeadLetter(letters[0].ID); !errors.Is(err, ErrDeadLetterNotFound) {
+			t.Errorf("Expected ErrDeadLetterNotFound, got %v", err)
+		}
+	})
+}
+
+func TestPushQueue_RetriesOnlyFailedTokens(t *testing.T) {
+	provider := &MockPushProvider{
+		name:         "mock",
+		enabled:      true,
+		failedTokens: []string{"token-gone"},
+		sendError:    &RetryableTokensError{Tokens: []string{"token-busy"}, Err: errors.New("unavailable")},
+	}
+	q, cleanup := setupPushQueueTest(t, provider)
+	defer cleanup()
+
+	enqueueTestPush(t, q, "mock", "token-ok", "token-busy", "token-gone")
+	q.ProcessDue()
+
+	job := queuedPushJob(t)
+	if job.Tokens != `["token-busy"]` {
+		t.Errorf("Only the retryable token should be retried, got %s", job.Tokens)
+	}
+	if job.NextAttemptAt.IsZero() || job.LockedUntil != nil {
+		t.Errorf("Job should be released for another attempt, got %+v", job)
+	}
+	if q.Stats().Providers["mock"].InvalidTokens != 1 {
+		t.Error("Invalid token should be counted")
+	}
+}
+
+func TestPushQueue_PermanentErrorDeadLetters(t *testing.T) {
+	provider := &MockPushProvider{name: "mock", enabled: true, sendError: &PermanentPushError{Err: errors.New("payload too large")}}
+	q, cleanup := setupPushQueueTest(t, provider)
+	defer cleanup()
+
+	enqueueTestPush(t, q, "mock", "token-a")
+	q.ProcessDue()
+
+	letters, total, _ := q.ListDeadLetters("mock", 10, 0)
+	if total != 1 || letters[0].Attempts != 1 || letters[0].LastError != "payload too large" {
+		t.Fatalf("Permanent error should dead-letter on the first attempt, got %+v", letters)
+	}
+	if err := q.DeleteDeadLetter(letters[0].ID); err != nil {
+		t.Fatalf("DeleteDeadLetter failed: %v", err)
+	}
+	if err := q.DeleteDeadLetter(letters[0].ID); !errors.Is(err, ErrDeadLetterNotFound) {
+		t.Errorf("Expected ErrDeadLetterNotFound, got %v", err)
+	}
+}
+
+func TestPushQueue_Backoff(t *testing.T) {
+	q := &PushQueue{retryBase: time.Second, retryMax: 10 * time.Second}
+
+	for attempts, max := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 10: 10 * time.Second} {
+		delay := q.backoff(attempts)
+		if delay < max/2 || delay > max {
+			t.Errorf("backoff(%d) = %v, want between %v and %v", attempts, delay, max/2, max)
+		}
+	}
+}
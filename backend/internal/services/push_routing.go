@@ -0,0 +1,249 @@
+package services
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+
+	webpush "github.com/SherClockHolmes/webpush-go"
+	"messenger/internal/models"
+)
+
+var (
+	ErrUnknownPushProvider        = errors.New("unknown push provider")
+	ErrPushProviderPlatform       = errors.New("push provider does not support this platform")
+	ErrInvalidWebPushSubscription = errors.New("web push subscription must have an https endpoint and p256dh and auth keys")
+	ErrInvalidUnifiedPushEndpoint = errors.New("unifiedpush endpoint must be a public https URL")
+	ErrInvalidVoIPToken           = errors.New("apns_voip token must be a hex PushKit device token")
+)
+
+// pushProviderPlatforms lists the platforms each known provider can deliver to.
+// A nil list means any platform.
+var pushProviderPlatforms = map[string][]models.DevicePlatform{
+	ProviderFCM:         nil,
+	ProviderAPNs:        {models.PlatformIOS},
+	ProviderAPNsVoIP:    {models.PlatformIOS},
+	ProviderWebPush:     {models.PlatformWeb},
+	ProviderUnifiedPush: {models.PlatformAndroid},
+	ProviderWebhook:     nil,
+}
+
+// ResolvePushProvider works out which provider delivers to a device token at
+// registration time. An explicit provider is checked against the platform and
+// token; without one the provider is inferred from the token's format.
+func ResolvePushProvider(platform models.DevicePlatform, provider, token string) (string, error) {
+	if provider == "" {
+		return inferPushProvider(platform, token), nil
+	}
+
+	platforms, ok := pushProviderPlatforms[provider]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrUnknownPushProvider, provider)
+	}
+	if platforms != nil && !containsPlatform(platforms, platform) {
+		return "", fmt.Errorf("%w: %s on %s", ErrPushProviderPlatform, provider, platform)
+	}
+	switch provider {
+	case ProviderWebPush:
+		if _, err := ParseWebPushSubscription(token); err != nil {
+			return "", err
+		}
+	case ProviderUnifiedPush:
+		if _, err := ParseUnifiedPushEndpoint(token); err != nil {
+			return "", err
+		}
+	case ProviderAPNsVoIP:
+		if decoded, err := hex.DecodeString(token); err != nil || len(decoded) != 32 {
+			return "", ErrInvalidVoIPToken
+		}
+	}
+	return provider, nil
+}
+
+// ParseWebPushSubscription validates a browser PushSubscription and returns it
+// in the compact JSON form stored as the device token
+func ParseWebPushSubscription(raw string) (string, error) {
+	var subscription webpush.Subscription
+	if err := json.Unmarshal([]byte(raw), &subscription); err != nil {
+		return "", ErrInvalidWebPushSubscription
+	}
+	endpoint, err := url.Parse(subscription.Endpoint)
+	if err != nil || endpoint.Scheme != "https" || endpoint.Host == "" {
+		return "", ErrInvalidWebPushSubscription
+	}
+	if subscription.Keys.P256dh == "" || subscription.Keys.Auth == "" {
+		return "", ErrInvalidWebPushSubscription
+	}
+
+	normalized, _ := json.Marshal(subscription)
+	return string(normalized), nil
+}
+
+// ParseUnifiedPushEndpoint validates a UnifiedPush distributor endpoint. The
+// server POSTs to it, so only https URLs on public hosts are accepted.
+func ParseUnifiedPushEndpoint(raw string) (*url.URL, error) {
+	endpoint, err := url.Parse(raw)
+	if err != nil || endpoint.Scheme != "https" || endpoint.Hostname() == "" || endpoint.User != nil {
+		return nil, ErrInvalidUnifiedPushEndpoint
+	}
+
+	host := strings.ToLower(endpoint.Hostname())
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return nil, ErrInvalidUnifiedPushEndpoint
+	}
+	if ip := net.ParseIP(host); ip != nil && !isPublicIP(ip) {
+		return nil, ErrInvalidUnifiedPushEndpoint
+	}
+	return endpoint, nil
+}
+
+// sharedAddressSpace is the carrier-grade NAT range (RFC 6598), which
+// net.IP.IsPrivate doesn't cover
+var sharedAddressSpace = &net.IPNet{IP: net.IPv4(100, 64, 0, 0), Mask: net.CIDRMask(10, 32)}
+
+// isPublicIP returns false for loopback, private, link-local, carrier-grade
+// NAT, unspecified and multicast addresses
+func isPublicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsUnspecified() || ip.IsMulticast() || sharedAddressSpace.Contains(ip))
+}
+
+// inferPushProvider guesses the provider for a token registered without one:
+// raw 32-byte APNs device tokens go to APNs, Web Push subscriptions to Web
+// Push, Android endpoint URLs to UnifiedPush, and everything else to FCM,
+// which issues tokens on every platform
+func inferPushProvider(platform models.DevicePlatform, token string) string {
+	switch platform {
+	case models.PlatformIOS:
+		if decoded, err := hex.DecodeString(token); err == nil && len(decoded) == 32 {
+			return ProviderAPNs
+		}
+	case models.PlatformAndroid:
+		if _, err := ParseUnifiedPushEndpoint(token); err == nil {
+			return ProviderUnifiedPush
+		}
+	case models.PlatformWeb:
+		if _, err := ParseWebPushSubscription(token); err == nil {
+			return ProviderWebPush
+		}
+	}
+	return ProviderFCM
+}
+
+// tokenProvider returns the provider a stored token belongs to
+func tokenProvider(token models.DeviceToken) string {
+	if token.Provider != "" {
+		return token.Provider
+	}
+	platform := token.Platform
+	if platform == "" {
+		platform = models.PlatformAndroid // Default
+	}
+	return inferPushProvider(platform, token.Token)
+}
+
+// IsVoIPToken reports whether a token is a PushKit VoIP token. Those only
+// take call pushes: iOS stops delivering VoIP pushes to an app that doesn't
+// report a call for each one.
+func IsVoIPToken(token models.DeviceToken) bool {
+	return tokenProvider(token) == ProviderAPNsVoIP
+}
+
+// messageTokens drops VoIP tokens, for every push that isn't a call
+func messageTokens(tokens []models.DeviceToken) []models.DeviceToken {
+	var filtered []models.DeviceToken
+	for _, t := range tokens {
+		if !IsVoIPToken(t) {
+			filtered = append(filtered, t)
+		}
+	}
+	return filtered
+}
+
+// callTokens picks the tokens a call push goes to: VoIP tokens, and the
+// regular tokens of devices without one. An iPhone with a VoIP token rings
+// through CallKit, so its regular token is left out rather than also
+// showing an alert; tokens are matched to devices by device_id.
+func callTokens(tokens []models.DeviceToken) []models.DeviceToken {
+	voipDevices := make(map[string]bool)
+	for _, t := range tokens {
+		if IsVoIPToken(t) {
+			voipDevices[t.DeviceID] = true
+		}
+	}
+
+	var selected []models.DeviceToken
+	for _, t := range tokens {
+		if IsVoIPToken(t) || t.Platform != models.PlatformIOS || !voipDevices[t.DeviceID] {
+			selected = append(selected, t)
+		}
+	}
+	return selected
+}
+
+// pushFallbackProvider returns the provider named by PUSH_FALLBACK_PROVIDER,
+// or "" when there is no fallback
+func pushFallbackProvider() string {
+	fallback := strings.ToLower(strings.TrimSpace(os.Getenv("PUSH_FALLBACK_PROVIDER")))
+	if fallback == "none" {
+		return ""
+	}
+	return fallback
+}
+
+// routeTokens groups device tokens by the enabled provider that delivers to
+// them. A token whose provider is unavailable goes to the fallback provider if
+// one is configured and enabled, and is otherwise skipped.
+func (ps *PushService) routeTokens(tokens []models.DeviceToken) map[string][]string {
+	routes := make(map[string][]string)
+
+	for _, t := range tokens {
+		name, fellBack, ok := ps.tokenRoute(t)
+		switch {
+		case !ok:
+			ps.queue.metrics.unroutable(name)
+		case fellBack:
+			routes[name] = append(routes[name], t.Token)
+			ps.queue.metrics.fellBack(name)
+		default:
+			routes[name] = append(routes[name], t.Token)
+			ps.queue.metrics.routed(name)
+		}
+	}
+
+	return routes
+}
+
+// tokenRoute returns the enabled provider a token is sent through and
+// whether that is the fallback provider. When ok is false no provider can
+// deliver to it and name is the token's own provider. VoIP tokens never
+// fall back, as only APNs can deliver to them.
+func (ps *PushService) tokenRoute(t models.DeviceToken) (name string, fellBack, ok bool) {
+	name = tokenProvider(t)
+	if provider, found := ps.GetProvider(name); found && provider.IsEnabled() {
+		return name, false, true
+	}
+	if name == ProviderAPNsVoIP {
+		return name, false, false
+	}
+
+	fallback := pushFallbackProvider()
+	if provider, found := ps.GetProvider(fallback); found && provider.IsEnabled() && fallback != name {
+		return fallback, true, true
+	}
+	return name, false, false
+}
+
+func containsPlatform(platforms []models.DevicePlatform, platform models.DevicePlatform) bool {
+	for _, p := range platforms {
+		if p == platform {
+			return true
+		}
+	}
+	return false
+}
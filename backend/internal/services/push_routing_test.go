@@ -0,0 +1,132 @@
+package services
+
+import (
+	"errors"
+	"testing"
+
+	"messenger/internal/database"
+	"messenger/internal/models"
+)
+
+const testSubscription = `{"endpoint":"https://push.example.com/send/abc","keys":{"p256dh":"BPub","auth":"secret"}}`
+
+func TestResolvePushProvider(t *testing.T) {
+	apnsToken := "a1b2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718293a4b5c6d7e8f90"
+
+	tests := []struct {
+		name     string
+		platform models.DevicePlatform
+		provider string
+		token    string
+		want     string
+		wantErr  error
+	}{
+		{"android infers fcm", models.PlatformAndroid, "", "fcm-token", ProviderFCM, nil},
+		{"ios fcm token", models.PlatformIOS, "", "fcm-token:APA91b", ProviderFCM, nil},
+		{"ios apns token", models.PlatformIOS, "", apnsToken, ProviderAPNs, nil},
+		{"web subscription", models.PlatformWeb, "", testSubscription, ProviderWebPush, nil},
+		{"web fcm token", models.PlatformWeb, "", "web-fcm-token", ProviderFCM, nil},
+		{"explicit apns", models.PlatformIOS, ProviderAPNs, apnsToken, ProviderAPNs, nil},
+		{"apns on android", models.PlatformAndroid, ProviderAPNs, apnsToken, "", ErrPushProviderPlatform},
+		{"webpush without subscription", models.PlatformWeb, ProviderWebPush, "plain-token", "", ErrInvalidWebPushSubscription},
+		{"android unifiedpush endpoint", models.PlatformAndroid, "", "https://ntfy.example.com/upAbC123", ProviderUnifiedPush, nil},
+		{"unifiedpush on ios", models.PlatformIOS, ProviderUnifiedPush, "https://ntfy.example.com/upAbC123", "", ErrPushProviderPlatform},
+		{"unifiedpush to a private host", models.PlatformAndroid, ProviderUnifiedPush, "https://10.0.0.5/up", "", ErrInvalidUnifiedPushEndpoint},
+		{"unifiedpush to a carrier-grade NAT host", models.PlatformAndroid, ProviderUnifiedPush, "https://100.64.1.2/up", "", ErrInvalidUnifiedPushEndpoint},
+		{"unifiedpush over http", models.PlatformAndroid, ProviderUnifiedPush, "http://ntfy.example.com/up", "", ErrInvalidUnifiedPushEndpoint},
+		{"explicit apns_voip", models.PlatformIOS, ProviderAPNsVoIP, apnsToken, ProviderAPNsVoIP, nil},
+		{"apns_voip on android", models.PlatformAndroid, ProviderAPNsVoIP, apnsToken, "", ErrPushProviderPlatform},
+		{"apns_voip with a non-hex token", models.PlatformIOS, ProviderAPNsVoIP, "fcm-token:APA91b", "", ErrInvalidVoIPToken},
+		{"webhook on any platform", models.PlatformIOS, ProviderWebhook, "gateway-device-id", ProviderWebhook, nil},
+		{"unknown provider", models.PlatformAndroid, "pigeon", "token", "", ErrUnknownPushProvider},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ResolvePushProvider(tt.platform, tt.provider, tt.token)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if got != tt.want {
+				t.Errorf("Expected provider %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestParseWebPushSubscription(t *testing.T) {
+	if _, err := ParseWebPushSubscription(testSubscription); err != nil {
+		t.Errorf("Valid subscription rejected: %v", err)
+	}
+	insecure := `{"endpoint":"http://push.example.com/send/abc","keys":{"p256dh":"BPub","auth":"secret"}}`
+	if _, err := ParseWebPushSubscription(insecure); err == nil {
+		t.Error("Subscription with an http endpoint should be rejected")
+	}
+	noKeys := `{"endpoint":"https://push.example.com/send/abc"}`
+	if _, err := ParseWebPushSubscription(noKeys); err == nil {
+		t.Error("Subscription without keys should be rejected")
+	}
+}
+
+func TestPushService_RoutesTokensToTheirProvider(t *testing.T) {
+	fcm := &MockPushProvider{name: ProviderFCM, enabled: true}
+	q, cleanup := setupPushQueueTest(t, fcm)
+	defer cleanup()
+	ps := q.push
+	webPush := &MockPushProvider{name: ProviderWebPush, enabled: true}
+	ps.RegisterProvider(webPush)
+	ps.RegisterProvider(&MockPushProvider{name: ProviderAPNs, enabled: false})
+
+	database.DB.Create(&models.DeviceToken{UserID: "user-1", Token: "android-token", Platform: models.PlatformAndroid, Provider: ProviderFCM})
+	database.DB.Create(&models.DeviceToken{UserID: "user-1", Token: testSubscription, Platform: models.PlatformWeb, Provider: ProviderWebPush})
+	database.DB.Create(&models.DeviceToken{UserID: "user-1", Token: "apns-token", Platform: models.PlatformIOS, Provider: ProviderAPNs})
+
+	t.Run("no fallback", func(t *testing.T) {
+		t.Setenv("PUSH_FALLBACK_PROVIDER", "")
+		if err := ps.SendToUser("user-1", &Notification{Title: "Hi"}); err != nil {
+			t.Fatalf("SendToUser failed: %v", err)
+		}
+		q.ProcessDue()
+
+		if fcm.sentCount != 1 || webPush.sentCount != 1 {
+			t.Errorf("Expected one token per provider, got fcm=%d webpush=%d", fcm.sentCount, webPush.sentCount)
+		}
+		stats := q.Stats()
+		if stats.Providers[ProviderAPNs].Unroutable != 1 {
+			t.Errorf("APNs token should be unroutable while APNs is disabled, got %+v", stats.Providers[ProviderAPNs])
+		}
+		if stats.Providers[ProviderFCM].Routed != 1 || stats.Providers[ProviderWebPush].Routed != 1 {
+			t.Errorf("Unexpected routing counters %+v", stats.Providers)
+		}
+	})
+
+	t.Run("voip tokens only take calls", func(t *testing.T) {
+		t.Setenv("PUSH_FALLBACK_PROVIDER", ProviderFCM)
+		database.DB.Create(&models.DeviceToken{UserID: "user-2", Token: "voip-token", Platform: models.PlatformIOS, Provider: ProviderAPNsVoIP})
+		fcm.sentCount = 0
+		if err := ps.SendToUser("user-2", &Notification{Title: "Hi"}); err != nil {
+			t.Fatalf("SendToUser failed: %v", err)
+		}
+		q.ProcessDue()
+		if fcm.sentCount != 0 {
+			t.Error("A VoIP token must not get message pushes, nor fall back to FCM")
+		}
+		if _, _, ok := ps.tokenRoute(models.DeviceToken{Token: "voip-token", Provider: ProviderAPNsVoIP}); ok {
+			t.Error("A VoIP token can't be routed without the VoIP provider")
+		}
+	})
+
+	t.Run("fallback", func(t *testing.T) {
+		t.Setenv("PUSH_FALLBACK_PROVIDER", ProviderFCM)
+		fcm.sentCount = 0
+		if err := ps.SendToTokens([]string{"apns-token"}, &Notification{Title: "Hi"}); err != nil {
+			t.Fatalf("SendToTokens failed: %v", err)
+		}
+		if fcm.sentCount != 1 {
+			t.Errorf("APNs token should fall back to FCM, got %d sends", fcm.sentCount)
+		}
+		if q.Stats().Providers[ProviderFCM].FallbackRouted != 1 {
+			t.Error("Fallback should be counted")
+		}
+	})
+}
@@ -0,0 +1,217 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// MaxUnifiedPushPayload is the largest message distributors must accept
+const MaxUnifiedPushPayload = 4096
+
+// ErrBlockedPushAddress is returned when an endpoint resolves to an address
+// the server must not connect to, e.g. after a DNS change
+var ErrBlockedPushAddress = errors.New("push endpoint resolves to a non-public address")
+
+// UnifiedPushProvider implements PushProvider for UnifiedPush, which lets
+// Android devices without Google Play Services receive pushes through a
+// distributor of the user's choice (ntfy, NextPush, ...). Device tokens are
+// the distributor endpoint URLs; a push is an HTTP POST to the endpoint.
+type UnifiedPushProvider struct {
+	client  *http.Client
+	mu      sync.RWMutex
+	enabled bool
+}
+
+// NewUnifiedPushProvider creates a new UnifiedPush provider
+func NewUnifiedPushProvider() *UnifiedPushProvider {
+	return &UnifiedPushProvider{}
+}
+
+func (p *UnifiedPushProvider) Name() string {
+	return ProviderUnifiedPush
+}
+
+// Initialize enables the provider. UnifiedPush needs no credentials, so it is
+// switched on with UNIFIEDPUSH_ENABLED.
+func (p *UnifiedPushProvider) Initialize(ctx context.Context) error {
+	if enabled, _ := strconv.ParseBool(os.Getenv("UNIFIEDPUSH_ENABLED")); !enabled {
+		return fmt.Errorf("UNIFIEDPUSH_ENABLED not set")
+	}
+
+	p.mu.Lock()
+	if p.client == nil {
+		p.client = newUnifiedPushClient(time.Duration(intFromEnv("UNIFIEDPUSH_TIMEOUT_SECONDS", 10)) * time.Second)
+	}
+	p.enabled = true
+	p.mu.Unlock()
+
+	log.Println("UnifiedPush provider initialized")
+	return nil
+}
+
+// newUnifiedPushClient returns a client for user-supplied endpoints. Endpoints
+// are checked when registered, but a hostname can resolve elsewhere later, so
+// the address is checked again on every connection. Redirects aren't
+// followed, as they could point anywhere.
+func newUnifiedPushClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !isPublicIP(ip) {
+				return ErrBlockedPushAddress
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil // A proxy would make the connection checks meaningless
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Timeout:       timeout,
+		Transport:     transport,
+		CheckRedirect: refuseRedirect,
+	}
+}
+
+// refuseRedirect makes a client return redirects as responses
+func refuseRedirect(*http.Request, []*http.Request) error {
+	return http.ErrUseLastResponse
+}
+
+func (p *UnifiedPushProvider) IsEnabled() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.enabled
+}
+
+func (p *UnifiedPushProvider) SupportsMulticast() bool {
+	return false // Every device has its own endpoint
+}
+
+func (p *UnifiedPushProvider) Send(ctx context.Context, tokens []string, notification *Notification) ([]string, error) {
+	if !p.IsEnabled() {
+		return nil, ErrProviderNotConfigured
+	}
+
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+
+	payload, err := p.buildPayload(notification)
+	if err != nil {
+		return nil, &PermanentPushError{Err: err}
+	}
+
+	p.mu.RLock()
+	client := p.client
+	p.mu.RUnlock()
+
+	var failedTokens, retryTokens []string
+	var retryErr error
+	var successCount, failureCount int
+
+	for _, endpoint := range tokens {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(payload))
+		if err != nil || req.URL.Scheme != "https" {
+			log.Printf("UnifiedPush: Invalid endpoint")
+			failedTokens = append(failedTokens, endpoint)
+			failureCount++
+			continue
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("TTL", strconv.Itoa(notification.ttlSeconds(86400))) // 24 hours by default
+		if notification.Silent && !notification.Call {
+			req.Header.Set("Urgency", "normal")
+		} else {
+			req.Header.Set("Urgency", "high")
+		}
+		if notification.CollapseID != "" {
+			req.Header.Set("Topic", unifiedPushTopic(notification.CollapseID))
+		}
+
+		resp, err := client.Do(req)
+		if errors.Is(err, ErrBlockedPushAddress) {
+			log.Printf("UnifiedPush: Endpoint resolves to a non-public address")
+			failedTokens = append(failedTokens, endpoint)
+			failureCount++
+			continue
+		}
+		if err != nil {
+			log.Printf("UnifiedPush: Failed to send: %v", err)
+			failureCount++
+			retryTokens = append(retryTokens, endpoint)
+			retryErr = err
+			continue
+		}
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+
+		switch {
+		case resp.StatusCode >= 200 && resp.StatusCode < 300:
+			successCount++
+		case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+			// The app was uninstalled or unregistered from the distributor
+			log.Printf("UnifiedPush: Endpoint gone (status %d)", resp.StatusCode)
+			failedTokens = append(failedTokens, endpoint)
+			failureCount++
+		case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+			log.Printf("UnifiedPush: Distributor unavailable (status %d)", resp.StatusCode)
+			retryTokens = append(retryTokens, endpoint)
+			retryErr = fmt.Errorf("distributor status %d", resp.StatusCode)
+			failureCount++
+		default:
+			log.Printf("UnifiedPush: Unexpected status %d", resp.StatusCode)
+			failureCount++
+		}
+	}
+
+	log.Printf("UnifiedPush: %d success, %d failures", successCount, failureCount)
+	if len(retryTokens) > 0 {
+		return failedTokens, &RetryableTokensError{Tokens: retryTokens, Err: retryErr}
+	}
+	return failedTokens, nil
+}
+
+// buildPayload encodes the notification, leaving out the body and then the
+// image if it doesn't fit in a UnifiedPush message. The app fetches the
+// message itself when it wakes up.
+func (p *UnifiedPushProvider) buildPayload(notification *Notification) ([]byte, error) {
+	payload := notification.jsonPayload()
+	for _, optional := range []string{"", "body", "image"} {
+		delete(payload, optional)
+		data, err := json.Marshal(payload)
+		if err != nil {
+			return nil, err
+		}
+		if len(data) <= MaxUnifiedPushPayload {
+			return data, nil
+		}
+	}
+	return nil, fmt.Errorf("notification exceeds the %d byte UnifiedPush limit", MaxUnifiedPushPayload)
+}
+
+// unifiedPushTopic turns a collapse ID into a Web Push Topic header, which
+// must be at most 32 URL-safe base64 characters
+func unifiedPushTopic(collapseID string) string {
+	sum := sha256.Sum256([]byte(collapseID))
+	return base64.RawURLEncoding.EncodeToString(sum[:24])
+}
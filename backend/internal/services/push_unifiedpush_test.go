@@ -0,0 +1,205 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"messenger/internal/database"
+	"messenger/internal/models"
+)
+
+// receivedPush is a push captured by a test HTTP server
+type receivedPush struct {
+	header http.Header
+	body   []byte
+}
+
+// newTestDistributor stands in for a UnifiedPush distributor. /gone answers
+// like an unregistered endpoint, /busy like an overloaded one and /moved
+// redirects to /up.
+func newTestDistributor(t *testing.T, received chan<- receivedPush) (*httptest.Server, *UnifiedPushProvider) {
+	t.Helper()
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/gone":
+			w.WriteHeader(http.StatusGone)
+		case "/busy":
+			w.WriteHeader(http.StatusServiceUnavailable)
+		case "/moved":
+			http.Redirect(w, r, "/up", http.StatusTemporaryRedirect)
+		default:
+			if received != nil {
+				body, _ := io.ReadAll(r.Body)
+				received <- receivedPush{header: r.Header, body: body}
+			}
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	t.Setenv("UNIFIEDPUSH_ENABLED", "true")
+	provider := NewUnifiedPushProvider()
+	provider.client = server.Client()
+	provider.client.CheckRedirect = refuseRedirect
+	if err := provider.Initialize(context.Background()); err != nil {
+		t.Fatalf("Initialize failed: %v", err)
+	}
+	return server, provider
+}
+
+func TestUnifiedPushProvider_Initialize(t *testing.T) {
+	t.Setenv("UNIFIEDPUSH_ENABLED", "")
+	provider := NewUnifiedPushProvider()
+	if err := provider.Initialize(context.Background()); err == nil || provider.IsEnabled() {
+		t.Error("Provider should stay disabled without UNIFIEDPUSH_ENABLED")
+	}
+}
+
+func TestUnifiedPushProvider_Send(t *testing.T) {
+	received := make(chan receivedPush, 1)
+	server, provider := newTestDistributor(t, received)
+
+	badge := 4
+	notification := &Notification{Title: "alice", Body: "Hello", Badge: &badge, ThreadID: "dm:alice", CollapseID: "dm:alice"}
+	failed, err := provider.Send(context.Background(), []string{server.URL + "/up/abc"}, notification)
+	if err != nil || len(failed) != 0 {
+		t.Fatalf("Send failed: %v %v", failed, err)
+	}
+
+	push := <-received
+	if push.header.Get("Urgency") != "high" || push.header.Get("TTL") == "" {
+		t.Errorf("Unexpected headers %v", push.header)
+	}
+	if topic := push.header.Get("Topic"); len(topic) != 32 || topic != unifiedPushTopic("dm:alice") {
+		t.Errorf("Expected a 32 character topic, got %q", topic)
+	}
+	var payload map[string]interface{}
+	json.Unmarshal(push.body, &payload)
+	if payload["title"] != "alice" || payload["body"] != "Hello" || payload["badge"] != float64(4) || payload["thread_id"] != "dm:alice" {
+		t.Errorf("Unexpected payload %v", payload)
+	}
+}
+
+func TestUnifiedPushProvider_SendFailures(t *testing.T) {
+	server, provider := newTestDistributor(t, nil)
+
+	tokens := []string{server.URL + "/ok", server.URL + "/gone", server.URL + "/busy", "http://insecure.example.com/up"}
+	failed, err := provider.Send(context.Background(), tokens, &Notification{Title: "Hi"})
+
+	if len(failed) != 2 || failed[0] != tokens[1] || failed[1] != tokens[3] {
+		t.Errorf("Expected the gone and insecure endpoints to be invalid, got %v", failed)
+	}
+	var retryable *RetryableTokensError
+	if !errors.As(err, &retryable) || len(retryable.Tokens) != 1 || retryable.Tokens[0] != tokens[2] {
+		t.Errorf("Expected the busy endpoint to be retried, got %v", err)
+	}
+}
+
+func TestUnifiedPushProvider_RefusesNonPublicAddresses(t *testing.T) {
+	received := make(chan receivedPush, 1)
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received <- receivedPush{header: r.Header}
+	}))
+	defer server.Close()
+
+	// The default client, unlike the test distributor's, checks where it connects
+	t.Setenv("UNIFIEDPUSH_ENABLED", "true")
+	provider := NewUnifiedPushProvider()
+	if err := provider.Initialize(context.Background()); err != nil {
+		t.Fatalf("Initialize failed: %v", err)
+	}
+
+	endpoint := server.URL + "/up"
+	failed, err := provider.Send(context.Background(), []string{endpoint}, &Notification{Title: "Hi"})
+	if err != nil || len(failed) != 1 || failed[0] != endpoint {
+		t.Errorf("Expected the loopback endpoint to be invalid, got %v %v", failed, err)
+	}
+	if len(received) != 0 {
+		t.Error("Push should not reach a loopback address")
+	}
+}
+
+func TestUnifiedPushProvider_DoesNotFollowRedirects(t *testing.T) {
+	received := make(chan receivedPush, 1)
+	server, provider := newTestDistributor(t, received)
+
+	failed, err := provider.Send(context.Background(), []string{server.URL + "/moved"}, &Notification{Title: "Hi"})
+	if err != nil || len(failed) != 0 {
+		t.Errorf("Unexpected result %v %v", failed, err)
+	}
+	if len(received) != 0 {
+		t.Error("Redirect should not be followed")
+	}
+}
+
+func TestIsPublicIP(t *testing.T) {
+	for _, tc := range []struct {
+		ip     string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:4700::1111", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"100.64.0.1", false},
+		{"100.127.255.254", false},
+		{"100.128.0.1", true},
+		{"0.0.0.0", false},
+		{"::ffff:127.0.0.1", false},
+	} {
+		if got := isPublicIP(net.ParseIP(tc.ip)); got != tc.public {
+			t.Errorf("isPublicIP(%s) = %v, want %v", tc.ip, got, tc.public)
+		}
+	}
+}
+
+func TestUnifiedPushProvider_OversizedPayload(t *testing.T) {
+	provider := NewUnifiedPushProvider()
+	notification := &Notification{Title: "alice", Body: strings.Repeat("x", MaxUnifiedPushPayload)}
+
+	payload, err := provider.buildPayload(notification)
+	if err != nil {
+		t.Fatalf("buildPayload failed: %v", err)
+	}
+	if len(payload) > MaxUnifiedPushPayload || strings.Contains(string(payload), `"body"`) {
+		t.Errorf("Expected the body to be dropped, got %d bytes", len(payload))
+	}
+
+	notification.Title = strings.Repeat("x", MaxUnifiedPushPayload)
+	if _, err := provider.buildPayload(notification); err == nil {
+		t.Error("A payload that can't be shrunk should be rejected")
+	}
+}
+
+func TestUnifiedPushProvider_RemovesGoneEndpoints(t *testing.T) {
+	server, provider := newTestDistributor(t, nil)
+	q, cleanup := setupPushQueueTest(t, provider)
+	defer cleanup()
+
+	gone := server.URL + "/gone"
+	database.DB.Create(&models.DeviceToken{UserID: "user-1", Token: gone, Platform: models.PlatformAndroid, Provider: ProviderUnifiedPush})
+	enqueueTestPush(t, q, ProviderUnifiedPush, gone)
+	q.ProcessDue()
+
+	var count int64
+	database.DB.Model(&models.DeviceToken{}).Where("token = ?", gone).Count(&count)
+	if count != 0 {
+		t.Error("Endpoint the distributor reports gone should be unregistered")
+	}
+	if q.Stats().Providers[ProviderUnifiedPush].InvalidTokens != 1 {
+		t.Error("Invalid endpoint should be counted")
+	}
+}
@@ -0,0 +1,184 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Headers sent with every webhook push
+const (
+	WebhookPushTimestampHeader = "X-Push-Timestamp"
+	WebhookPushSignatureHeader = "X-Push-Signature"
+)
+
+// WebhookPushProvider implements PushProvider by POSTing notifications to a
+// self-hosted gateway, which delivers them however it likes. Device tokens
+// are passed through untouched. Requests are signed with an HMAC-SHA256 of
+// the timestamp and body so the gateway can reject forged or replayed calls.
+type WebhookPushProvider struct {
+	url     string
+	secret  string
+	client  *http.Client
+	mu      sync.RWMutex
+	enabled bool
+}
+
+// webhookPushRequest is the body POSTed to the gateway
+type webhookPushRequest struct {
+	Tokens       []string               `json:"tokens"`
+	Notification map[string]interface{} `json:"notification"`
+}
+
+// webhookPushResponse is the optional body the gateway answers with. Tokens
+// it lists as invalid are unregistered; tokens to retry are queued again.
+type webhookPushResponse struct {
+	InvalidTokens []string `json:"invalid_tokens"`
+	RetryTokens   []string `json:"retry_tokens"`
+}
+
+// NewWebhookPushProvider creates a new webhook push provider
+func NewWebhookPushProvider() *WebhookPushProvider {
+	return &WebhookPushProvider{}
+}
+
+// NewWebhookPushProviderWithURL creates an enabled webhook provider with
+// explicit settings
+func NewWebhookPushProviderWithURL(url, secret string, timeout time.Duration) *WebhookPushProvider {
+	return &WebhookPushProvider{
+		url:     url,
+		secret:  secret,
+		client:  &http.Client{Timeout: timeout},
+		enabled: true,
+	}
+}
+
+func (p *WebhookPushProvider) Name() string {
+	return ProviderWebhook
+}
+
+func (p *WebhookPushProvider) Initialize(ctx context.Context) error {
+	url := os.Getenv("WEBHOOK_PUSH_URL")
+	secret := os.Getenv("WEBHOOK_PUSH_SECRET")
+
+	if url == "" || secret == "" {
+		return fmt.Errorf("WEBHOOK_PUSH_URL and WEBHOOK_PUSH_SECRET not set")
+	}
+
+	p.mu.Lock()
+	p.url = url
+	p.secret = secret
+	p.client = &http.Client{Timeout: time.Duration(intFromEnv("WEBHOOK_PUSH_TIMEOUT_SECONDS", 10)) * time.Second}
+	p.enabled = true
+	p.mu.Unlock()
+
+	log.Println("Webhook push provider initialized")
+	return nil
+}
+
+func (p *WebhookPushProvider) IsEnabled() bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.enabled
+}
+
+func (p *WebhookPushProvider) SupportsMulticast() bool {
+	return true // All tokens go in one request
+}
+
+func (p *WebhookPushProvider) Send(ctx context.Context, tokens []string, notification *Notification) ([]string, error) {
+	if !p.IsEnabled() {
+		return nil, ErrProviderNotConfigured
+	}
+
+	if len(tokens) == 0 {
+		return nil, nil
+	}
+
+	p.mu.RLock()
+	url := p.url
+	secret := p.secret
+	client := p.client
+	p.mu.RUnlock()
+
+	body, err := json.Marshal(webhookPushRequest{Tokens: tokens, Notification: notification.jsonPayload()})
+	if err != nil {
+		return nil, &PermanentPushError{Err: err}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, &PermanentPushError{Err: fmt.Errorf("failed to create push webhook request: %w", err)}
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookPushTimestampHeader, timestamp)
+	req.Header.Set(WebhookPushSignatureHeader, WebhookPushSignature(secret, timestamp, body))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, &RetryableTokensError{Tokens: tokens, Err: err}
+	}
+	defer resp.Body.Close()
+	detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return nil, &RetryableTokensError{Tokens: tokens, Err: fmt.Errorf("push webhook returned %d", resp.StatusCode)}
+	case resp.StatusCode < 200 || resp.StatusCode >= 300:
+		// Bad signature or payload; retrying the same request won't help
+		return nil, &PermanentPushError{Err: fmt.Errorf("push webhook returned %d: %s", resp.StatusCode, strings.TrimSpace(string(detail[:min(len(detail), 512)])))}
+	}
+
+	var result webhookPushResponse
+	if len(bytes.TrimSpace(detail)) > 0 {
+		if err := json.Unmarshal(detail, &result); err != nil {
+			log.Printf("Webhook push: Ignoring unreadable response: %v", err)
+		}
+	}
+
+	// Only act on tokens that were part of this request
+	sent := make(map[string]bool, len(tokens))
+	for _, token := range tokens {
+		sent[token] = true
+	}
+	var failedTokens, retryTokens []string
+	for _, token := range result.InvalidTokens {
+		if sent[token] {
+			failedTokens = append(failedTokens, token)
+		}
+	}
+	for _, token := range result.RetryTokens {
+		if sent[token] {
+			retryTokens = append(retryTokens, token)
+		}
+	}
+
+	log.Printf("Webhook push: %d sent, %d invalid, %d to retry", len(tokens), len(failedTokens), len(retryTokens))
+	if len(retryTokens) > 0 {
+		return failedTokens, &RetryableTokensError{Tokens: retryTokens, Err: fmt.Errorf("push webhook deferred delivery")}
+	}
+	return failedTokens, nil
+}
+
+// WebhookPushSignature returns the X-Push-Signature value for a request:
+// "sha256=" and the hex HMAC-SHA256 of the timestamp, a dot and the body
+func WebhookPushSignature(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
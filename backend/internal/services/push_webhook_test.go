@@ -0,0 +1,96 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// newTestPushGateway stands in for a push webhook gateway answering every
+// request with status and body
+func newTestPushGateway(t *testing.T, status int, body string, received chan<- receivedPush) *WebhookPushProvider {
+	t.Helper()
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		if received != nil {
+			received <- receivedPush{header: r.Header, body: data}
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}))
+	t.Cleanup(server.Close)
+	return NewWebhookPushProviderWithURL(server.URL, "gateway-secret", 5*time.Second)
+}
+
+func TestWebhookPushProvider_Initialize(t *testing.T) {
+	t.Setenv("WEBHOOK_PUSH_URL", "https://push.example.com/hook")
+	t.Setenv("WEBHOOK_PUSH_SECRET", "")
+	provider := NewWebhookPushProvider()
+	if err := provider.Initialize(context.Background()); err == nil || provider.IsEnabled() {
+		t.Error("Webhook pushes must not be sent unsigned")
+	}
+}
+
+func TestWebhookPushProvider_SignsRequests(t *testing.T) {
+	received := make(chan receivedPush, 1)
+	provider := newTestPushGateway(t, http.StatusOK, "", received)
+
+	failed, err := provider.Send(context.Background(), []string{"device-a", "device-b"}, &Notification{Title: "alice", Body: "Hello"})
+	if err != nil || len(failed) != 0 {
+		t.Fatalf("Send failed: %v %v", failed, err)
+	}
+
+	push := <-received
+	timestamp := push.header.Get(WebhookPushTimestampHeader)
+	if want := WebhookPushSignature("gateway-secret", timestamp, push.body); push.header.Get(WebhookPushSignatureHeader) != want {
+		t.Errorf("Signature mismatch: got %q, want %q", push.header.Get(WebhookPushSignatureHeader), want)
+	}
+	if WebhookPushSignature("other-secret", timestamp, push.body) == push.header.Get(WebhookPushSignatureHeader) {
+		t.Error("Signature should depend on the secret")
+	}
+
+	var request webhookPushRequest
+	json.Unmarshal(push.body, &request)
+	if len(request.Tokens) != 2 || request.Notification["title"] != "alice" || request.Notification["body"] != "Hello" {
+		t.Errorf("Unexpected request %+v", request)
+	}
+}
+
+func TestWebhookPushProvider_Responses(t *testing.T) {
+	tokens := []string{"device-a", "device-b", "device-c"}
+
+	t.Run("invalid and deferred tokens", func(t *testing.T) {
+		provider := newTestPushGateway(t, http.StatusOK, `{"invalid_tokens":["device-b","not-sent"],"retry_tokens":["device-c"]}`, nil)
+		failed, err := provider.Send(context.Background(), tokens, &Notification{Title: "Hi"})
+		if len(failed) != 1 || failed[0] != "device-b" {
+			t.Errorf("Expected only device-b to be invalid, got %v", failed)
+		}
+		var retryable *RetryableTokensError
+		if !errors.As(err, &retryable) || len(retryable.Tokens) != 1 || retryable.Tokens[0] != "device-c" {
+			t.Errorf("Expected device-c to be retried, got %v", err)
+		}
+	})
+
+	t.Run("gateway unavailable", func(t *testing.T) {
+		provider := newTestPushGateway(t, http.StatusBadGateway, "", nil)
+		_, err := provider.Send(context.Background(), tokens, &Notification{Title: "Hi"})
+		var retryable *RetryableTokensError
+		if !errors.As(err, &retryable) || len(retryable.Tokens) != 3 {
+			t.Errorf("Expected every token to be retried, got %v", err)
+		}
+	})
+
+	t.Run("rejected", func(t *testing.T) {
+		provider := newTestPushGateway(t, http.StatusUnauthorized, "bad signature", nil)
+		_, err := provider.Send(context.Background(), tokens, &Notification{Title: "Hi"})
+		var permanent *PermanentPushError
+		if !errors.As(err, &permanent) {
+			t.Errorf("Expected a permanent error, got %v", err)
+		}
+	})
+}
@@ -28,7 +28,7 @@ func NewWebPushProvider() *WebPushProvider {
 }
 
 func (p *WebPushProvider) Name() string {
-	return "webpush"
+	return ProviderWebPush
 }
 
 func (p *WebPushProvider) Initialize(ctx context.Context) error {
@@ -87,7 +87,8 @@ func (p *WebPushProvider) Send(ctx context.Context, tokens []string, notificatio
 	subscriber := p.subscriber
 	p.mu.RUnlock()
 
-	var failedTokens []string
+	var failedTokens, retryTokens []string
+	var retryErr error
 	var successCount, failureCount int
 
 	for _, subscriptionJSON := range tokens {
@@ -102,16 +103,22 @@ func (p *WebPushProvider) Send(ctx context.Context, tokens []string, notificatio
 
 		payload := p.buildPayload(notification)
 
-		resp, err := webpush.SendNotification(payload, &subscription, &webpush.Options{
+		options := &webpush.Options{
 			Subscriber:      subscriber,
 			VAPIDPublicKey:  publicKey,
 			VAPIDPrivateKey: privateKey,
-			TTL:             86400, // 24 hours
-		})
+			TTL:             notification.ttlSeconds(86400), // 24 hours by default
+		}
+		if notification.Call {
+			options.Urgency = webpush.UrgencyHigh
+		}
+		resp, err := webpush.SendNotificationWithContext(ctx, payload, &subscription, options)
 
 		if err != nil {
 			log.Printf("WebPush: Failed to send: %v", err)
 			failureCount++
+			retryTokens = append(retryTokens, subscriptionJSON)
+			retryErr = err
 			continue
 		}
 		defer resp.Body.Close()
@@ -123,6 +130,11 @@ func (p *WebPushProvider) Send(ctx context.Context, tokens []string, notificatio
 			log.Printf("WebPush: Subscription expired (status %d)", resp.StatusCode)
 			failedTokens = append(failedTokens, subscriptionJSON)
 			failureCount++
+		} else if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500 {
+			log.Printf("WebPush: Push service unavailable (status %d)", resp.StatusCode)
+			retryTokens = append(retryTokens, subscriptionJSON)
+			retryErr = fmt.Errorf("push service status %d", resp.StatusCode)
+			failureCount++
 		} else {
 			log.Printf("WebPush: Unexpected status %d", resp.StatusCode)
 			failureCount++
@@ -130,6 +142,9 @@ func (p *WebPushProvider) Send(ctx context.Context, tokens []string, notificatio
 	}
 
 	log.Printf("WebPush: %d success, %d failures", successCount, failureCount)
+	if len(retryTokens) > 0 {
+		return failedTokens, &RetryableTokensError{Tokens: retryTokens, Err: retryErr}
+	}
 	return failedTokens, nil
 }
 
@@ -158,6 +173,16 @@ func (p *WebPushProvider) buildPayload(notification *Notification) []byte {
 	if notification.ImageURL != "" {
 		payload["image"] = notification.ImageURL
 	}
+	if notification.ThreadID != "" {
+		payload["tag"] = notification.ThreadID // Replaces the conversation's previous notification
+		payload["renotify"] = true
+	}
+	if notification.Badge != nil {
+		payload["app_badge"] = *notification.Badge // For navigator.setAppBadge()
+	}
+	if notification.Silent {
+		payload["silent"] = true // The service worker updates the badge without showing anything
+	}
 
 	data, _ := json.Marshal(payload)
 	return data
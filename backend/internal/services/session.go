@@ -0,0 +1,224 @@
+package services
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log"
+	"sync"
+	"time"
+
+	"messenger/internal/database"
+	"messenger/internal/models"
+)
+
+// RefreshTokenTTL is how long a refresh token stays valid. Each rotation
+// extends the session by the same amount.
+const RefreshTokenTTL = 7 * 24 * time.Hour
+
+var (
+	ErrInvalidRefreshToken = errors.New("invalid refresh token")
+	ErrRefreshTokenReuse   = errors.New("refresh token reuse detected, session revoked")
+	ErrSessionRevoked      = errors.New("session has been revoked")
+	ErrSessionNotFound     = errors.New("session not found")
+)
+
+// SessionMeta describes the device a session was created from
+type SessionMeta struct {
+	DeviceName string
+	UserAgent  string
+	IPAddress  string
+}
+
+// SessionRevokedFunc is called after a session is revoked, e.g. to
+// disconnect WebSocket clients authenticated with it
+type SessionRevokedFunc func(userID, sessionID string)
+
+var (
+	sessionRevokedHandler SessionRevokedFunc
+	sessionHandlerMu      sync.RWMutex
+)
+
+// SetSessionRevokedHandler registers the callback invoked when a session is revoked
+func SetSessionRevokedHandler(fn SessionRevokedFunc) {
+	sessionHandlerMu.Lock()
+	defer sessionHandlerMu.Unlock()
+	sessionRevokedHandler = fn
+}
+
+func notifySessionRevoked(userID, sessionID string) {
+	sessionHandlerMu.RLock()
+	fn := sessionRevokedHandler
+	sessionHandlerMu.RUnlock()
+	if fn != nil {
+		fn(userID, sessionID)
+	}
+}
+
+// hashToken returns the SHA-256 hex digest stored in place of a secret token
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// generateOpaqueToken returns a random URL-safe token
+func generateOpaqueToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// createSession starts a new session for a user and returns it with its first refresh token
+func createSession(userID string, meta SessionMeta) (*models.Session, string, error) {
+	now := time.Now()
+	session := models.Session{
+		UserID:     userID,
+		DeviceName: meta.DeviceName,
+		UserAgent:  meta.UserAgent,
+		IPAddress:  meta.IPAddress,
+		LastUsedAt: now,
+		ExpiresAt:  now.Add(RefreshTokenTTL),
+	}
+	if err := database.DB.Create(&session).Error; err != nil {
+		return nil, "", errors.New("failed to create session")
+	}
+
+	refreshToken, err := issueRefreshToken(session.ID)
+	if err != nil {
+		return nil, "", err
+	}
+	return &session, refreshToken, nil
+}
+
+// issueRefreshToken creates a new refresh token in a session's token family
+func issueRefreshToken(sessionID string) (string, error) {
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return "", errors.New("failed to generate refresh token")
+	}
+
+	record := models.RefreshToken{
+		SessionID: sessionID,
+		TokenHash: hashToken(token),
+		ExpiresAt: time.Now().Add(RefreshTokenTTL),
+	}
+	if err := database.DB.Create(&record).Error; err != nil {
+		return "", errors.New("failed to store refresh token")
+	}
+	return token, nil
+}
+
+// rotateRefreshToken consumes a refresh token and issues its replacement.
+// Presenting a token that was already rotated means it was stolen or leaked,
+// so the whole session (token family) is revoked.
+func rotateRefreshToken(refreshToken string) (*models.Session, string, error) {
+	record, err := models.GetRefreshTokenByHash(database.DB, hashToken(refreshToken))
+	if err != nil {
+		return nil, "", ErrInvalidRefreshToken
+	}
+
+	session, err := models.GetSession(database.DB, record.SessionID)
+	if err != nil {
+		return nil, "", ErrInvalidRefreshToken
+	}
+	if session.RevokedAt != nil {
+		return nil, "", ErrSessionRevoked
+	}
+
+	if record.UsedAt != nil {
+		revokeSession(session, models.SessionRevokedTokenReuse)
+		log.Printf("Refresh token reuse detected for session %s (user %s), session revoked", session.ID, session.UserID)
+		return nil, "", ErrRefreshTokenReuse
+	}
+
+	if time.Now().After(record.ExpiresAt) || !session.IsActive() {
+		return nil, "", ErrInvalidRefreshToken
+	}
+
+	// Mark as used atomically so two concurrent refreshes can't both succeed
+	ok, err := models.MarkRefreshTokenUsed(database.DB, record.ID)
+	if err != nil {
+		return nil, "", errors.New("failed to rotate refresh token")
+	}
+	if !ok {
+		revokeSession(session, models.SessionRevokedTokenReuse)
+		log.Printf("Concurrent refresh token reuse for session %s (user %s), session revoked", session.ID, session.UserID)
+		return nil, "", ErrRefreshTokenReuse
+	}
+
+	now := time.Now()
+	session.LastUsedAt = now
+	session.ExpiresAt = now.Add(RefreshTokenTTL)
+	database.DB.Model(session).Updates(map[string]interface{}{
+		"last_used_at": session.LastUsedAt,
+		"expires_at":   session.ExpiresAt,
+	})
+
+	newToken, err := issueRefreshToken(session.ID)
+	if err != nil {
+		return nil, "", err
+	}
+	return session, newToken, nil
+}
+
+func revokeSession(session *models.Session, reason string) error {
+	revoked, err := models.RevokeSession(database.DB, session.ID, reason)
+	if err != nil {
+		return err
+	}
+	if revoked {
+		notifySessionRevoked(session.UserID, session.ID)
+	}
+	return nil
+}
+
+// IsSessionActive returns true if the session exists and has not been revoked or expired
+func IsSessionActive(sessionID string) bool {
+	if sessionID == "" {
+		return false
+	}
+	session, err := models.GetSession(database.DB, sessionID)
+	if err != nil {
+		return false
+	}
+	return session.IsActive()
+}
+
+// ListSessions returns a user's active sessions
+func (s *AuthService) ListSessions(userID string) ([]models.Session, error) {
+	return models.GetActiveSessions(database.DB, userID)
+}
+
+// RevokeSession revokes one of a user's sessions
+func (s *AuthService) RevokeSession(userID, sessionID, reason string) error {
+	session, err := models.GetSession(database.DB, sessionID)
+	if err != nil || session.UserID != userID {
+		return ErrSessionNotFound
+	}
+	return revokeSession(session, reason)
+}
+
+// RevokeAllSessions revokes every active session of a user ("log out everywhere").
+// If exceptSessionID is set, that session stays logged in.
+func (s *AuthService) RevokeAllSessions(userID, exceptSessionID, reason string) (int, error) {
+	sessions, err := models.GetActiveSessions(database.DB, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	for i := range sessions {
+		if sessions[i].ID == exceptSessionID {
+			continue
+		}
+		if err := revokeSession(&sessions[i], reason); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
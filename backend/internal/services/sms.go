@@ -0,0 +1,232 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrSMSNotConfigured is returned when no SMS gateway is set up
+var ErrSMSNotConfigured = errors.New("SMS delivery is not configured")
+
+// SMSSender defines the interface for SMS gateways.
+// Implement this interface to deliver OTP codes through a different provider.
+type SMSSender interface {
+	// Name returns the sender name (e.g., "log", "webhook")
+	Name() string
+
+	// Send delivers a text message to a phone number in E.164 format
+	Send(ctx context.Context, to, message string) error
+}
+
+var (
+	smsSender     SMSSender
+	smsSenderOnce sync.Once
+	smsSenderMu   sync.RWMutex
+)
+
+// GetSMSSender returns the configured SMS sender. SMS_PROVIDER selects the
+// implementation: "log" (default, development only) or "webhook".
+func GetSMSSender() SMSSender {
+	smsSenderOnce.Do(func() {
+		sender := newSMSSenderFromEnv()
+		smsSenderMu.Lock()
+		if smsSender == nil {
+			smsSender = sender
+		}
+		smsSenderMu.Unlock()
+	})
+
+	smsSenderMu.RLock()
+	defer smsSenderMu.RUnlock()
+	return smsSender
+}
+
+// SetSMSSender replaces the SMS sender, e.g. with a custom gateway or in tests.
+// Passing nil restores the sender configured by the environment.
+func SetSMSSender(sender SMSSender) {
+	smsSenderOnce.Do(func() {})
+	if sender == nil {
+		sender = newSMSSenderFromEnv()
+	}
+	smsSenderMu.Lock()
+	defer smsSenderMu.Unlock()
+	smsSender = sender
+}
+
+// newSMSSenderFromEnv reads SMS_PROVIDER. The log sender writes codes to the
+// server log, so outside development SMS is disabled instead.
+func newSMSSenderFromEnv() SMSSender {
+	switch strings.ToLower(getEnvOrDefault("SMS_PROVIDER", "log")) {
+	case "webhook":
+		sender, err := NewWebhookSMSSender()
+		if err != nil {
+			log.Printf("Warning: SMS webhook sender not configured - %v", err)
+			return fallbackSMSSender()
+		}
+		log.Printf("SMS sender: webhook (%s)", sender.url)
+		return sender
+	default:
+		return fallbackSMSSender()
+	}
+}
+
+func fallbackSMSSender() SMSSender {
+	if !IsDevelopment() {
+		log.Println("Warning: No SMS gateway configured - phone verification is disabled. Set SMS_PROVIDER=webhook, or APP_ENV=development to log codes")
+		return disabledSMSSender{}
+	}
+	log.Println("Warning: Using log SMS sender - OTP codes are written to the server log")
+	return NewLogSMSSender()
+}
+
+// disabledSMSSender refuses every message
+type disabledSMSSender struct{}
+
+func (disabledSMSSender) Name() string {
+	return "disabled"
+}
+
+func (disabledSMSSender) Send(ctx context.Context, to, message string) error {
+	return ErrSMSNotConfigured
+}
+
+// SMSMessage is a message captured by LogSMSSender
+type SMSMessage struct {
+	To      string
+	Message string
+	SentAt  time.Time
+}
+
+// LogSMSSender writes messages to the log and keeps them in memory.
+// Use for development and tests only.
+type LogSMSSender struct {
+	mu       sync.Mutex
+	messages []SMSMessage
+	quiet    bool
+}
+
+// NewLogSMSSender creates a logging SMS sender
+func NewLogSMSSender() *LogSMSSender {
+	return &LogSMSSender{}
+}
+
+// NewMemorySMSSender creates a sender that only records messages in memory
+func NewMemorySMSSender() *LogSMSSender {
+	return &LogSMSSender{quiet: true}
+}
+
+func (s *LogSMSSender) Name() string {
+	return "log"
+}
+
+func (s *LogSMSSender) Send(ctx context.Context, to, message string) error {
+	s.mu.Lock()
+	s.messages = append(s.messages, SMSMessage{To: to, Message: message, SentAt: time.Now()})
+	s.mu.Unlock()
+
+	if !s.quiet {
+		log.Printf("[SMS] to=%s message=%q", to, message)
+	}
+	return nil
+}
+
+// Messages returns all messages sent so far
+func (s *LogSMSSender) Messages() []SMSMessage {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	result := make([]SMSMessage, len(s.messages))
+	copy(result, s.messages)
+	return result
+}
+
+// LastMessage returns the most recent message sent to a number
+func (s *LogSMSSender) LastMessage(to string) (SMSMessage, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.messages) - 1; i >= 0; i-- {
+		if s.messages[i].To == to {
+			return s.messages[i], true
+		}
+	}
+	return SMSMessage{}, false
+}
+
+// WebhookSMSSender POSTs messages as JSON to an HTTP endpoint, which can be a
+// real gateway's bridge or a local stand-in during development
+type WebhookSMSSender struct {
+	url    string
+	token  string
+	from   string
+	client *http.Client
+}
+
+// webhookSMSPayload is the request body sent to the webhook
+type webhookSMSPayload struct {
+	To      string `json:"to"`
+	From    string `json:"from,omitempty"`
+	Message string `json:"message"`
+}
+
+// NewWebhookSMSSender creates a webhook sender from SMS_WEBHOOK_URL,
+// SMS_WEBHOOK_TOKEN (sent as a bearer token) and SMS_FROM
+func NewWebhookSMSSender() (*WebhookSMSSender, error) {
+	url := os.Getenv("SMS_WEBHOOK_URL")
+	if url == "" {
+		return nil, fmt.Errorf("SMS_WEBHOOK_URL is not set")
+	}
+
+	timeout := time.Duration(intFromEnv("SMS_WEBHOOK_TIMEOUT_SECONDS", 10)) * time.Second
+	return NewWebhookSMSSenderWithURL(url, os.Getenv("SMS_WEBHOOK_TOKEN"), os.Getenv("SMS_FROM"), timeout), nil
+}
+
+// NewWebhookSMSSenderWithURL creates a webhook sender with explicit settings
+func NewWebhookSMSSenderWithURL(url, token, from string, timeout time.Duration) *WebhookSMSSender {
+	return &WebhookSMSSender{
+		url:    url,
+		token:  token,
+		from:   from,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+func (s *WebhookSMSSender) Name() string {
+	return "webhook"
+}
+
+func (s *WebhookSMSSender) Send(ctx context.Context, to, message string) error {
+	body, err := json.Marshal(webhookSMSPayload{To: to, From: s.from, Message: message})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create SMS webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("SMS webhook request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("SMS webhook returned %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
@@ -0,0 +1,188 @@
+package services
+
+import (
+	"fmt"
+	"log"
+	"strconv"
+
+	"messenger/internal/database"
+	"messenger/internal/models"
+)
+
+// Default storage quotas per role (0 = unlimited)
+const (
+	DefaultUserQuotaMB      = 1024 // 1GB
+	DefaultModeratorQuotaMB = 5120 // 5GB
+	DefaultAdminQuotaMB     = 0    // Unlimited
+)
+
+// QuotaExceededError is returned when an upload would push a user over their storage quota
+type QuotaExceededError struct {
+	QuotaBytes     int64
+	UsedBytes      int64
+	RequestedBytes int64
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("storage quota exceeded: %dMB of %dMB used, upload needs %dMB",
+		bytesToMB(e.UsedBytes), bytesToMB(e.QuotaBytes), bytesToMB(e.RequestedBytes))
+}
+
+// StorageUsage summarises a user's media storage
+type StorageUsage struct {
+	UsedBytes      int64                      `json:"used_bytes"`
+	QuotaBytes     int64                      `json:"quota_bytes"`
+	RemainingBytes int64                      `json:"remaining_bytes"`
+	Unlimited      bool                       `json:"unlimited"`
+	QuotaSource    string                     `json:"quota_source"` // "role" or "override"
+	ByType         []models.MediaTypeUsage    `json:"by_type"`
+	ByConversation []models.ConversationUsage `json:"by_conversation"`
+}
+
+// StorageQuotaService enforces per-user and per-role media storage quotas
+type StorageQuotaService struct {
+	roleQuotas map[models.UserRole]int64 // bytes, 0 = unlimited
+}
+
+// NewStorageQuotaService creates a quota service with role quotas read from
+// STORAGE_QUOTA_USER_MB, STORAGE_QUOTA_MODERATOR_MB and STORAGE_QUOTA_ADMIN_MB
+func NewStorageQuotaService() *StorageQuotaService {
+	return &StorageQuotaService{
+		roleQuotas: map[models.UserRole]int64{
+			models.UserRoleUser:      quotaFromEnv("STORAGE_QUOTA_USER_MB", DefaultUserQuotaMB),
+			models.UserRoleModerator: quotaFromEnv("STORAGE_QUOTA_MODERATOR_MB", DefaultModeratorQuotaMB),
+			models.UserRoleAdmin:     quotaFromEnv("STORAGE_QUOTA_ADMIN_MB", DefaultAdminQuotaMB),
+		},
+	}
+}
+
+func quotaFromEnv(key string, defaultMB int64) int64 {
+	value := getEnvOrDefault(key, "")
+	if value == "" {
+		return defaultMB * 1024 * 1024
+	}
+	mb, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || mb < 0 {
+		log.Printf("Warning: invalid %s=%q, using default of %dMB", key, value, defaultMB)
+		return defaultMB * 1024 * 1024
+	}
+	return mb * 1024 * 1024
+}
+
+func bytesToMB(b int64) int64 {
+	return (b + 1024*1024 - 1) / (1024 * 1024)
+}
+
+// RoleQuota returns the default quota in bytes for a role
+func (s *StorageQuotaService) RoleQuota(role models.UserRole) int64 {
+	if role == "" {
+		role = models.UserRoleUser
+	}
+	if quota, ok := s.roleQuotas[role]; ok {
+		return quota
+	}
+	return s.roleQuotas[models.UserRoleUser]
+}
+
+// GetQuota returns the effective quota in bytes for a user and where it came from
+func (s *StorageQuotaService) GetQuota(userID string) (int64, string, error) {
+	if override, err := models.GetStorageQuota(database.DB, userID); err == nil {
+		return override.QuotaBytes, "override", nil
+	}
+
+	user, err := GetUserByID(userID)
+	if err != nil {
+		return 0, "", fmt.Errorf("user not found: %w", err)
+	}
+	return s.RoleQuota(user.Role), "role", nil
+}
+
+// ReserveUpload creates the record for an upload and reserves its size
+// against the uploader's quota before the file is written to storagePath.
+// Returns a *QuotaExceededError, with no record left behind, if the upload
+// would exceed the quota.
+func (s *StorageQuotaService) ReserveUpload(media *models.Media, storagePath string) error {
+	quota, _, err := s.GetQuota(media.UploaderID)
+	if err != nil {
+		return err
+	}
+
+	// The record doesn't count towards usage until it has a storage path
+	media.StoragePath = ""
+	if err := database.DB.Create(media).Error; err != nil {
+		return fmt.Errorf("failed to create media record: %w", err)
+	}
+	claimed, err := models.ClaimMediaStorage(database.DB, media, storagePath, quota)
+	if err == nil && claimed {
+		return nil
+	}
+	database.DB.Delete(media)
+	if err != nil {
+		return fmt.Errorf("failed to reserve storage: %w", err)
+	}
+
+	used, err := models.GetStorageUsed(database.DB, media.UploaderID)
+	if err != nil {
+		return fmt.Errorf("failed to calculate storage usage: %w", err)
+	}
+	return &QuotaExceededError{
+		QuotaBytes:     quota,
+		UsedBytes:      used,
+		RequestedBytes: media.Size,
+	}
+}
+
+// GetUsage returns a user's storage usage broken down by media type and conversation
+func (s *StorageQuotaService) GetUsage(userID string) (*StorageUsage, error) {
+	quota, source, err := s.GetQuota(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	used, err := models.GetStorageUsed(database.DB, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	byType, err := models.GetStorageUsageByType(database.DB, userID)
+	if err != nil {
+		return nil, err
+	}
+	if byType == nil {
+		byType = []models.MediaTypeUsage{}
+	}
+
+	byConversation, err := models.GetStorageUsageByConversation(database.DB, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	usage := &StorageUsage{
+		UsedBytes:      used,
+		QuotaBytes:     quota,
+		Unlimited:      quota == 0,
+		QuotaSource:    source,
+		ByType:         byType,
+		ByConversation: byConversation,
+	}
+	if quota > 0 && quota > used {
+		usage.RemainingBytes = quota - used
+	}
+	return usage, nil
+}
+
+// SetUserQuota sets a per-user quota override in bytes (0 = unlimited)
+func (s *StorageQuotaService) SetUserQuota(userID string, quotaBytes int64, setBy, reason string) (*models.StorageQuota, error) {
+	if quotaBytes < 0 {
+		return nil, fmt.Errorf("quota cannot be negative")
+	}
+	if _, err := GetUserByID(userID); err != nil {
+		return nil, fmt.Errorf("user not found: %w", err)
+	}
+	return models.SetStorageQuota(database.DB, userID, quotaBytes, setBy, reason)
+}
+
+// ResetUserQuota removes a per-user override so the role default applies again
+func (s *StorageQuotaService) ResetUserQuota(userID string) error {
+	return models.DeleteStorageQuota(database.DB, userID)
+}
@@ -0,0 +1,503 @@
+package services
+
+import (
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"messenger/internal/database"
+	"messenger/internal/models"
+)
+
+// Token signing algorithms, selected with JWT_SIGNING_ALG
+const (
+	SigningAlgEdDSA = "EdDSA"
+	SigningAlgRS256 = "RS256"
+	SigningAlgHS256 = "HS256" // Legacy shared secret: no kid, no JWKS
+)
+
+// Key rotation defaults, each overridable via environment
+const (
+	DefaultKeyRotationInterval = 30 * 24 * time.Hour
+	DefaultKeyVerifyGrace      = 24 * time.Hour
+
+	keyReloadInterval  = 10 * time.Second // Unknown kids re-read the key table at most this often
+	keyRefreshInterval = time.Minute      // The signing key is re-checked this often to follow rotations
+	minJWTSecretLength = 32
+	rsaKeyBits         = 2048
+)
+
+// insecureJWTSecrets are the placeholder secrets shipped in code and docker-compose
+var insecureJWTSecrets = []string{
+	"your-secret-key-change-in-production",
+	"your-super-secret-jwt-key-change-in-production",
+}
+
+var ErrUnknownTokenKey = errors.New("unknown token signing key")
+
+// IsDevelopment reports whether APP_ENV is "development" (or "dev")
+func IsDevelopment() bool {
+	env := strings.ToLower(os.Getenv("APP_ENV"))
+	return env == "development" || env == "dev"
+}
+
+// CheckJWTSecret returns an error if JWT_SECRET is missing, a known placeholder
+// or too short. It is only enforced outside development, where the server
+// refuses to start.
+func CheckJWTSecret() error {
+	secret := os.Getenv("JWT_SECRET")
+	if secret == "" {
+		return errors.New("JWT_SECRET is not set")
+	}
+	for _, insecure := range insecureJWTSecrets {
+		if secret == insecure {
+			return errors.New("JWT_SECRET is set to the default placeholder")
+		}
+	}
+	if len(secret) < minJWTSecretLength {
+		return fmt.Errorf("JWT_SECRET must be at least %d characters", minJWTSecretLength)
+	}
+	return nil
+}
+
+// tokenKey is a loaded signing key
+type tokenKey struct {
+	kid         string
+	alg         string
+	private     crypto.Signer // nil if the key can't be decrypted with our secret
+	public      crypto.PublicKey
+	signUntil   time.Time
+	verifyUntil time.Time
+}
+
+// TokenKeyManager signs and verifies the server's JWTs. Asymmetric keys are
+// stored in the database so every instance shares them, rotate on a schedule
+// and are published as a JWKS so other services can verify our tokens.
+type TokenKeyManager struct {
+	alg      string
+	rotation time.Duration
+	grace    time.Duration
+
+	mu       sync.Mutex
+	keys     map[string]*tokenKey
+	current  *tokenKey
+	loadedAt time.Time
+}
+
+var (
+	tokenKeys     *TokenKeyManager
+	tokenKeysOnce sync.Once
+	tokenKeysMu   sync.RWMutex
+)
+
+// GetTokenKeys returns the key manager configured from JWT_SIGNING_ALG,
+// JWT_KEY_ROTATION_DAYS and JWT_KEY_VERIFY_GRACE_HOURS
+func GetTokenKeys() *TokenKeyManager {
+	tokenKeysOnce.Do(func() {
+		manager := newTokenKeyManagerFromEnv()
+		tokenKeysMu.Lock()
+		if tokenKeys == nil {
+			tokenKeys = manager
+		}
+		tokenKeysMu.Unlock()
+	})
+
+	tokenKeysMu.RLock()
+	defer tokenKeysMu.RUnlock()
+	return tokenKeys
+}
+
+// SetTokenKeys replaces the key manager, e.g. in tests.
+// Passing nil restores the manager configured by the environment.
+func SetTokenKeys(manager *TokenKeyManager) {
+	tokenKeysOnce.Do(func() {})
+	if manager == nil {
+		manager = newTokenKeyManagerFromEnv()
+	}
+	tokenKeysMu.Lock()
+	defer tokenKeysMu.Unlock()
+	tokenKeys = manager
+}
+
+func newTokenKeyManagerFromEnv() *TokenKeyManager {
+	alg := getEnvOrDefault("JWT_SIGNING_ALG", SigningAlgEdDSA)
+	rotation := time.Duration(intFromEnv("JWT_KEY_ROTATION_DAYS", int(DefaultKeyRotationInterval/(24*time.Hour)))) * 24 * time.Hour
+	grace := time.Duration(intFromEnv("JWT_KEY_VERIFY_GRACE_HOURS", int(DefaultKeyVerifyGrace/time.Hour))) * time.Hour
+
+	manager, err := NewTokenKeyManager(alg, rotation, grace)
+	if err != nil {
+		log.Printf("Warning: %v, using %s", err, SigningAlgEdDSA)
+		manager, _ = NewTokenKeyManager(SigningAlgEdDSA, rotation, grace)
+	}
+	if alg == SigningAlgHS256 {
+		log.Println("Warning: Using HS256 token signing - tokens can't be verified by other services and rotating JWT_SECRET logs everyone out")
+	}
+	return manager
+}
+
+// NewTokenKeyManager creates a key manager. Keys sign for rotation and keep
+// verifying for grace afterwards, which must outlast the longest token lifetime.
+func NewTokenKeyManager(alg string, rotation, grace time.Duration) (*TokenKeyManager, error) {
+	switch alg {
+	case SigningAlgEdDSA, SigningAlgRS256, SigningAlgHS256:
+	default:
+		return nil, fmt.Errorf("unsupported JWT_SIGNING_ALG %q", alg)
+	}
+	if rotation <= 0 {
+		rotation = DefaultKeyRotationInterval
+	}
+	if grace < AccessTokenTTL {
+		grace = AccessTokenTTL
+	}
+
+	return &TokenKeyManager{
+		alg:      alg,
+		rotation: rotation,
+		grace:    grace,
+		keys:     make(map[string]*tokenKey),
+	}, nil
+}
+
+// Algorithm returns the algorithm new tokens are signed with
+func (m *TokenKeyManager) Algorithm() string {
+	return m.alg
+}
+
+// Sign signs claims with the current key, setting the kid header
+func (m *TokenKeyManager) Sign(claims jwt.Claims) (string, error) {
+	if m.alg == SigningAlgHS256 {
+		return jwt.NewWithClaims(jwt.SigningMethodHS256, claims).SignedString(jwtSecret)
+	}
+
+	key, err := m.signingKey()
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.alg), claims)
+	token.Header["kid"] = key.kid
+	return token.SignedString(key.private)
+}
+
+// Parse verifies a token's signature and standard claims. Tokens signed by
+// any key still in its verification window are accepted, so rotation doesn't
+// log anyone out.
+func (m *TokenKeyManager) Parse(tokenString string, claims jwt.Claims) (*jwt.Token, error) {
+	if m.alg == SigningAlgHS256 {
+		return jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+			return jwtSecret, nil
+		}, jwt.WithValidMethods([]string{SigningAlgHS256}))
+	}
+
+	return jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, err := m.verificationKey(kid)
+		if err != nil {
+			return nil, err
+		}
+		// The key decides the algorithm, never the token header
+		if token.Method.Alg() != key.alg {
+			return nil, fmt.Errorf("token algorithm %s does not match key %s", token.Method.Alg(), kid)
+		}
+		return key.public, nil
+	}, jwt.WithValidMethods([]string{SigningAlgEdDSA, SigningAlgRS256}))
+}
+
+// Rotate retires the current signing key immediately and starts a new one.
+// The old key keeps verifying until its grace period ends.
+func (m *TokenKeyManager) Rotate() (string, error) {
+	if m.alg == SigningAlgHS256 {
+		return "", errors.New("HS256 signing has no keys to rotate")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Retire every key that is still signing, including ones other instances created
+	now := time.Now()
+	verifyUntil := now.Add(m.grace)
+	err := database.DB.Model(&models.SigningKey{}).Where("sign_until > ?", now).
+		Updates(map[string]interface{}{"sign_until": now, "verify_until": verifyUntil}).Error
+	if err != nil {
+		return "", fmt.Errorf("failed to retire signing keys: %w", err)
+	}
+	for _, key := range m.keys {
+		if now.Before(key.signUntil) {
+			key.signUntil = now
+			key.verifyUntil = verifyUntil
+		}
+	}
+	m.current = nil
+
+	key, err := m.generateLocked(now)
+	if err != nil {
+		return "", err
+	}
+	return key.kid, nil
+}
+
+// signingKey returns the current key, rotating when it has reached SignUntil
+func (m *TokenKeyManager) signingKey() (*tokenKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if m.current != nil && now.Before(m.current.signUntil) && now.Sub(m.loadedAt) < keyRefreshInterval {
+		return m.current, nil
+	}
+
+	// Another instance may have rotated in the meantime
+	m.reloadLocked(now)
+	m.current = nil
+	for _, key := range m.keys {
+		if key.alg != m.alg || key.private == nil || !now.Before(key.signUntil) {
+			continue
+		}
+		if m.current == nil || key.signUntil.After(m.current.signUntil) {
+			m.current = key
+		}
+	}
+	if m.current != nil {
+		return m.current, nil
+	}
+
+	return m.generateLocked(now)
+}
+
+// verificationKey looks up a key by kid, re-reading the key table if it's
+// unknown (throttled) in case another instance rotated
+func (m *TokenKeyManager) verificationKey(kid string) (*tokenKey, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	key, ok := m.keys[kid]
+	if !ok && now.Sub(m.loadedAt) >= keyReloadInterval {
+		m.reloadLocked(now)
+		key, ok = m.keys[kid]
+	}
+	if !ok || !now.Before(key.verifyUntil) {
+		return nil, ErrUnknownTokenKey
+	}
+	return key, nil
+}
+
+// JWK is a public key in JSON Web Key format
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+}
+
+// JWKS returns the public keys that currently verify tokens, newest first.
+// The current signing key is created if there isn't one yet.
+func (m *TokenKeyManager) JWKS() ([]JWK, error) {
+	if m.alg == SigningAlgHS256 {
+		return []JWK{}, nil
+	}
+	if _, err := m.signingKey(); err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(m.loadedAt) >= keyReloadInterval {
+		m.reloadLocked(now)
+	}
+
+	var ordered []*tokenKey
+	for _, key := range m.keys {
+		if now.Before(key.verifyUntil) {
+			ordered = append(ordered, key)
+		}
+	}
+	sort.Slice(ordered, func(i, j int) bool {
+		return ordered[i].signUntil.After(ordered[j].signUntil)
+	})
+
+	keys := make([]JWK, 0, len(ordered))
+	for _, key := range ordered {
+		jwk := JWK{Kid: key.kid, Use: "sig", Alg: key.alg}
+		switch pub := key.public.(type) {
+		case ed25519.PublicKey:
+			jwk.Kty = "OKP"
+			jwk.Crv = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(pub)
+		case *rsa.PublicKey:
+			jwk.Kty = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(pub.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes())
+		default:
+			continue
+		}
+		keys = append(keys, jwk)
+	}
+	return keys, nil
+}
+
+// reloadLocked merges the keys stored in the database into the cache and
+// drops keys past their verification window
+func (m *TokenKeyManager) reloadLocked(now time.Time) {
+	m.loadedAt = now
+	for kid, key := range m.keys {
+		if !now.Before(key.verifyUntil) {
+			delete(m.keys, kid)
+		}
+	}
+
+	if database.DB == nil {
+		return
+	}
+	stored, err := models.GetVerifyingSigningKeys(database.DB, now)
+	if err != nil {
+		log.Printf("Failed to load signing keys: %v", err)
+		return
+	}
+
+	for _, record := range stored {
+		key, err := decodeSigningKey(record)
+		if err != nil {
+			log.Printf("Skipping signing key %s: %v", record.ID, err)
+			continue
+		}
+		m.keys[key.kid] = key
+	}
+}
+
+// generateLocked creates, stores and activates a new signing key
+func (m *TokenKeyManager) generateLocked(now time.Time) (*tokenKey, error) {
+	var private crypto.Signer
+	var err error
+	switch m.alg {
+	case SigningAlgRS256:
+		private, err = rsa.GenerateKey(rand.Reader, rsaKeyBits)
+	default:
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	}
+	if err != nil {
+		return nil, errors.New("failed to generate signing key")
+	}
+
+	privateDER, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return nil, errors.New("failed to encode signing key")
+	}
+	publicDER, err := x509.MarshalPKIXPublicKey(private.Public())
+	if err != nil {
+		return nil, errors.New("failed to encode signing key")
+	}
+	sealed, err := sealSigningKey(privateDER)
+	if err != nil {
+		return nil, err
+	}
+
+	// The kid is derived from the public key so it is stable and unique
+	sum := sha256.Sum256(publicDER)
+	key := &tokenKey{
+		kid:         base64.RawURLEncoding.EncodeToString(sum[:12]),
+		alg:         m.alg,
+		private:     private,
+		public:      private.Public(),
+		signUntil:   now.Add(m.rotation),
+		verifyUntil: now.Add(m.rotation + m.grace),
+	}
+
+	record := models.SigningKey{
+		ID:          key.kid,
+		Algorithm:   key.alg,
+		PrivateKey:  sealed,
+		PublicKey:   publicDER,
+		SignUntil:   key.signUntil,
+		VerifyUntil: key.verifyUntil,
+	}
+	if err := database.DB.Create(&record).Error; err != nil {
+		return nil, errors.New("failed to store signing key")
+	}
+
+	log.Printf("Generated %s token signing key %s (signs until %s)", key.alg, key.kid, key.signUntil.Format(time.RFC3339))
+	m.keys[key.kid] = key
+	m.current = key
+	return key, nil
+}
+
+// decodeSigningKey loads a stored key. A key whose private half can't be
+// decrypted is still usable for verification.
+func decodeSigningKey(record models.SigningKey) (*tokenKey, error) {
+	public, err := x509.ParsePKIXPublicKey(record.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+
+	key := &tokenKey{
+		kid:         record.ID,
+		alg:         record.Algorithm,
+		public:      public,
+		signUntil:   record.SignUntil,
+		verifyUntil: record.VerifyUntil,
+	}
+
+	if der, err := openSigningKey(record.PrivateKey); err == nil {
+		if parsed, err := x509.ParsePKCS8PrivateKey(der); err == nil {
+			key.private, _ = parsed.(crypto.Signer)
+		}
+	}
+	return key, nil
+}
+
+// signingKeyCipher derives the AES-GCM cipher that encrypts private keys at rest
+func signingKeyCipher() (cipher.AEAD, error) {
+	kek := sha256.Sum256(append([]byte("messenger token signing keys\x00"), jwtSecret...))
+	block, err := aes.NewCipher(kek[:])
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func sealSigningKey(plaintext []byte) ([]byte, error) {
+	aead, err := signingKeyCipher()
+	if err != nil {
+		return nil, errors.New("failed to encrypt signing key")
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, errors.New("failed to encrypt signing key")
+	}
+	return aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+func openSigningKey(sealed []byte) ([]byte, error) {
+	aead, err := signingKeyCipher()
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < aead.NonceSize() {
+		return nil, errors.New("sealed key too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, nil)
+}
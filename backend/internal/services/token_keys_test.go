@@ -0,0 +1,218 @@
+package services
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"messenger/internal/database"
+	"messenger/internal/models"
+)
+
+func testClaims() Claims {
+	return Claims{
+		UserID: "user-1",
+		Type:   TokenTypeAccess,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+}
+
+func TestTokenKeyManager_SignAndParse(t *testing.T) {
+	cleanup := setupAuthTestDB(t)
+	defer cleanup()
+
+	for _, alg := range []string{SigningAlgEdDSA, SigningAlgRS256, SigningAlgHS256} {
+		t.Run(alg, func(t *testing.T) {
+			manager, err := NewTokenKeyManager(alg, time.Hour, time.Hour)
+			if err != nil {
+				t.Fatalf("NewTokenKeyManager failed: %v", err)
+			}
+
+			signed, err := manager.Sign(testClaims())
+			if err != nil {
+				t.Fatalf("Sign failed: %v", err)
+			}
+
+			token, err := manager.Parse(signed, &Claims{})
+			if err != nil {
+				t.Fatalf("Parse failed: %v", err)
+			}
+			if token.Method.Alg() != alg {
+				t.Errorf("Expected %s, got %s", alg, token.Method.Alg())
+			}
+			if _, hasKid := token.Header["kid"]; hasKid == (alg == SigningAlgHS256) {
+				t.Errorf("Unexpected kid header for %s: %v", alg, token.Header)
+			}
+		})
+	}
+
+	if _, err := NewTokenKeyManager("none", time.Hour, time.Hour); err == nil {
+		t.Error("Unsupported algorithm should be rejected")
+	}
+}
+
+func TestTokenKeyManager_Rotation(t *testing.T) {
+	cleanup := setupAuthTestDB(t)
+	defer cleanup()
+
+	manager, _ := NewTokenKeyManager(SigningAlgEdDSA, time.Hour, time.Hour)
+	before, _ := manager.Sign(testClaims())
+	oldToken, _ := manager.Parse(before, &Claims{})
+
+	newKid, err := manager.Rotate()
+	if err != nil {
+		t.Fatalf("Rotate failed: %v", err)
+	}
+	if newKid == oldToken.Header["kid"] {
+		t.Fatal("Rotation should create a new key")
+	}
+
+	after, _ := manager.Sign(testClaims())
+	token, _ := manager.Parse(after, &Claims{})
+	if token.Header["kid"] != newKid {
+		t.Error("New tokens should be signed with the new key")
+	}
+
+	if _, err := manager.Parse(before, &Claims{}); err != nil {
+		t.Errorf("Tokens signed before rotation should still verify: %v", err)
+	}
+
+	keys, _ := manager.JWKS()
+	if len(keys) != 2 || keys[0].Kid != newKid {
+		t.Fatalf("Expected both keys in JWKS with the newest first, got %+v", keys)
+	}
+	if keys[0].Kty != "OKP" || keys[0].Crv != "Ed25519" || keys[0].Alg != SigningAlgEdDSA {
+		t.Errorf("Unexpected JWK %+v", keys[0])
+	}
+
+	t.Run("expired keys stop verifying", func(t *testing.T) {
+		database.DB.Model(&models.SigningKey{}).Where("id = ?", oldToken.Header["kid"]).
+			Update("verify_until", time.Now().Add(-time.Minute))
+		fresh, _ := NewTokenKeyManager(SigningAlgEdDSA, time.Hour, time.Hour)
+		if _, err := fresh.Parse(before, &Claims{}); err == nil {
+			t.Error("Token from an expired key should be rejected")
+		}
+	})
+}
+
+func TestTokenKeyManager_RotateFailure(t *testing.T) {
+	cleanup := setupAuthTestDB(t)
+	defer cleanup()
+
+	manager, _ := NewTokenKeyManager(SigningAlgEdDSA, time.Hour, time.Hour)
+	before, _ := manager.Sign(testClaims())
+	oldToken, _ := manager.Parse(before, &Claims{})
+
+	database.DB.Migrator().DropTable(&models.SigningKey{})
+	if _, err := manager.Rotate(); err == nil {
+		t.Fatal("Rotate should fail when the keys can't be retired")
+	}
+
+	after, _ := manager.Sign(testClaims())
+	token, _ := manager.Parse(after, &Claims{})
+	if token == nil || token.Header["kid"] != oldToken.Header["kid"] {
+		t.Error("A failed rotation should leave the current key signing")
+	}
+}
+
+func TestTokenKeyManager_SharedAcrossInstances(t *testing.T) {
+	cleanup := setupAuthTestDB(t)
+	defer cleanup()
+
+	first, _ := NewTokenKeyManager(SigningAlgEdDSA, time.Hour, time.Hour)
+	second, _ := NewTokenKeyManager(SigningAlgEdDSA, time.Hour, time.Hour)
+
+	signed, _ := first.Sign(testClaims())
+	if _, err := second.Parse(signed, &Claims{}); err != nil {
+		t.Errorf("Another instance should verify via the key table: %v", err)
+	}
+
+	a, _ := first.Parse(signed, &Claims{})
+	other, _ := second.Sign(testClaims())
+	b, _ := second.Parse(other, &Claims{})
+	if a.Header["kid"] != b.Header["kid"] {
+		t.Error("Instances should share the current signing key")
+	}
+
+	var stored models.SigningKey
+	database.DB.First(&stored, "id = ?", a.Header["kid"])
+	if _, err := x509.ParsePKCS8PrivateKey(stored.PrivateKey); err == nil {
+		t.Error("Private keys should be encrypted at rest")
+	}
+}
+
+func TestTokenKeyManager_RejectsForgedTokens(t *testing.T) {
+	cleanup := setupAuthTestDB(t)
+	defer cleanup()
+
+	manager, _ := NewTokenKeyManager(SigningAlgEdDSA, time.Hour, time.Hour)
+	keys, _ := manager.JWKS()
+	kid := keys[0].Kid
+
+	t.Run("HMAC keyed with the public key", func(t *testing.T) {
+		public, _ := base64.RawURLEncoding.DecodeString(keys[0].X)
+		token := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims())
+		token.Header["kid"] = kid
+		forged, _ := token.SignedString(public)
+		if _, err := manager.Parse(forged, &Claims{}); err == nil {
+			t.Error("Algorithm confusion should be rejected")
+		}
+	})
+
+	t.Run("shared secret", func(t *testing.T) {
+		forged, _ := jwt.NewWithClaims(jwt.SigningMethodHS256, testClaims()).SignedString(jwtSecret)
+		if _, err := manager.Parse(forged, &Claims{}); err == nil {
+			t.Error("HS256 tokens should be rejected when signing asymmetrically")
+		}
+	})
+
+	t.Run("unknown key", func(t *testing.T) {
+		_, private, _ := ed25519.GenerateKey(nil)
+		token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, testClaims())
+		token.Header["kid"] = "not-ours"
+		forged, _ := token.SignedString(private)
+		if _, err := manager.Parse(forged, &Claims{}); !errors.Is(err, ErrUnknownTokenKey) {
+			t.Errorf("Expected ErrUnknownTokenKey, got %v", err)
+		}
+	})
+
+	t.Run("our kid, different key", func(t *testing.T) {
+		_, private, _ := ed25519.GenerateKey(nil)
+		token := jwt.NewWithClaims(jwt.SigningMethodEdDSA, testClaims())
+		token.Header["kid"] = kid
+		forged, _ := token.SignedString(private)
+		if _, err := manager.Parse(forged, &Claims{}); err == nil {
+			t.Error("Token signed with a different key should be rejected")
+		}
+	})
+}
+
+func TestCheckJWTSecret(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+		ok     bool
+	}{
+		{"unset", "", false},
+		{"code default", "your-secret-key-change-in-production", false},
+		{"compose default", "your-super-secret-jwt-key-change-in-production", false},
+		{"too short", "short-secret", false},
+		{"random", "3q2+7wAAAAC3nKzJ1b0xk9mQpE8s4vZtYlRw6uHf", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("JWT_SECRET", tt.secret)
+			if err := CheckJWTSecret(); (err == nil) != tt.ok {
+				t.Errorf("CheckJWTSecret() = %v, want ok=%v", err, tt.ok)
+			}
+		})
+	}
+}
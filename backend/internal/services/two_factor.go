@@ -0,0 +1,372 @@
+package services
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"golang.org/x/crypto/bcrypt"
+	"messenger/internal/database"
+	"messenger/internal/models"
+)
+
+// TOTP parameters (RFC 6238 defaults understood by all authenticator apps)
+const (
+	totpDigits = 6
+	totpPeriod = 30 // seconds
+	totpSkew   = 1  // Accept codes from one step before/after to allow for clock drift
+
+	// RecoveryCodeCount is the number of recovery codes issued at a time
+	RecoveryCodeCount = 10
+
+	// TwoFactorChallengeTTL is how long the user has to enter their code after a password login
+	TwoFactorChallengeTTL = 5 * time.Minute
+	// TwoFactorChallengeMaxAttempts is how many codes can be tried against one challenge
+	TwoFactorChallengeMaxAttempts = 5
+)
+
+var (
+	ErrInvalidTwoFactorCode    = errors.New("invalid two-factor code")
+	ErrTwoFactorNotEnabled     = errors.New("two-factor authentication is not enabled")
+	ErrTwoFactorAlreadyEnabled = errors.New("two-factor authentication is already enabled")
+	ErrTwoFactorNotPending     = errors.New("no pending two-factor enrolment")
+	ErrInvalidChallenge        = errors.New("invalid or expired challenge token")
+	ErrInvalidPassword         = errors.New("invalid password")
+)
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTOTPSecret returns a random 160-bit secret, base32 encoded
+func GenerateTOTPSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base32NoPadding.EncodeToString(b), nil
+}
+
+// TOTPCode computes the code for a secret at a given time step
+func TOTPCode(secret string, step int64) (string, error) {
+	key, err := base32NoPadding.DecodeString(strings.ToUpper(strings.TrimRight(secret, "=")))
+	if err != nil {
+		return "", fmt.Errorf("invalid TOTP secret: %w", err)
+	}
+
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation (RFC 4226 section 5.3)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < totpDigits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", totpDigits, value%mod), nil
+}
+
+// TOTPStep returns the time step for a moment in time
+func TOTPStep(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+// ValidateTOTP checks a code against a secret, allowing for clock skew.
+// Returns the matching time step so callers can reject replays.
+func ValidateTOTP(secret, code string, now time.Time) (int64, bool) {
+	code = strings.ReplaceAll(strings.TrimSpace(code), " ", "")
+	if len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := TOTPStep(now)
+	for offset := int64(-totpSkew); offset <= totpSkew; offset++ {
+		expected, err := TOTPCode(secret, current+offset)
+		if err != nil {
+			return 0, false
+		}
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return current + offset, true
+		}
+	}
+	return 0, false
+}
+
+// OTPAuthURI builds the otpauth:// URI that authenticator apps import (usually via QR code)
+func OTPAuthURI(issuer, account, secret string) string {
+	label := url.PathEscape(issuer + ":" + account)
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprintf("%d", totpDigits))
+	params.Set("period", fmt.Sprintf("%d", totpPeriod))
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+// generateRecoveryCode returns a random code formatted as xxxxx-xxxxx
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, 7)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	code := strings.ToLower(base32NoPadding.EncodeToString(b))[:10]
+	return code[:5] + "-" + code[5:], nil
+}
+
+// normalizeRecoveryCode strips formatting so codes can be typed loosely
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.TrimSpace(code))
+	code = strings.ReplaceAll(code, "-", "")
+	return strings.ReplaceAll(code, " ", "")
+}
+
+// TwoFactorSetup is returned when a user starts 2FA enrolment
+type TwoFactorSetup struct {
+	Secret     string `json:"secret"`
+	OTPAuthURI string `json:"otpauth_uri"`
+	Issuer     string `json:"issuer"`
+}
+
+// TwoFactorStatus describes a user's 2FA state
+type TwoFactorStatus struct {
+	Enabled                bool  `json:"enabled"`
+	Pending                bool  `json:"pending"`
+	Required               bool  `json:"required"`
+	RecoveryCodesRemaining int64 `json:"recovery_codes_remaining"`
+}
+
+// TwoFactorService manages TOTP enrolment and verification
+type TwoFactorService struct {
+	issuer        string
+	requiredRoles map[models.UserRole]bool
+}
+
+// NewTwoFactorService creates a 2FA service. TOTP_ISSUER sets the name shown
+// in authenticator apps and TWO_FACTOR_REQUIRED_ROLES (e.g. "moderator,admin")
+// forces enrolment for privileged roles.
+func NewTwoFactorService() *TwoFactorService {
+	required := make(map[models.UserRole]bool)
+	for _, role := range strings.Split(getEnvOrDefault("TWO_FACTOR_REQUIRED_ROLES", ""), ",") {
+		if role = strings.TrimSpace(strings.ToLower(role)); role != "" {
+			required[models.UserRole(role)] = true
+		}
+	}
+
+	return &TwoFactorService{
+		issuer:        getEnvOrDefault("TOTP_ISSUER", "Messenger"),
+		requiredRoles: required,
+	}
+}
+
+// IsRequiredForRole returns true if users with the role must enrol in 2FA
+func (s *TwoFactorService) IsRequiredForRole(role models.UserRole) bool {
+	if role == "" {
+		role = models.UserRoleUser
+	}
+	return s.requiredRoles[role]
+}
+
+// Status returns a user's 2FA state
+func (s *TwoFactorService) Status(user *models.User) *TwoFactorStatus {
+	status := &TwoFactorStatus{Required: s.IsRequiredForRole(user.Role)}
+	if tf, err := models.GetTwoFactor(database.DB, user.ID); err == nil {
+		status.Enabled = tf.Enabled
+		status.Pending = !tf.Enabled
+	}
+	if status.Enabled {
+		status.RecoveryCodesRemaining = models.CountUnusedRecoveryCodes(database.DB, user.ID)
+	}
+	return status
+}
+
+// BeginEnrolment generates a new secret for a user. The secret is not active
+// until ConfirmEnrolment succeeds; calling this again replaces a pending secret.
+func (s *TwoFactorService) BeginEnrolment(user *models.User) (*TwoFactorSetup, error) {
+	secret, err := GenerateTOTPSecret()
+	if err != nil {
+		return nil, errors.New("failed to generate secret")
+	}
+
+	existing, err := models.GetTwoFactor(database.DB, user.ID)
+	if err == nil {
+		if existing.Enabled {
+			return nil, ErrTwoFactorAlreadyEnabled
+		}
+		existing.Secret = secret
+		existing.LastUsedStep = 0
+		if err := database.DB.Save(existing).Error; err != nil {
+			return nil, errors.New("failed to save secret")
+		}
+	} else {
+		tf := models.TwoFactor{UserID: user.ID, Secret: secret}
+		if err := database.DB.Create(&tf).Error; err != nil {
+			return nil, errors.New("failed to save secret")
+		}
+	}
+
+	return &TwoFactorSetup{
+		Secret:     secret,
+		OTPAuthURI: OTPAuthURI(s.issuer, user.Username, secret),
+		Issuer:     s.issuer,
+	}, nil
+}
+
+// ConfirmEnrolment enables 2FA once the user proves their authenticator works.
+// Returns the recovery codes, which are only shown this once.
+func (s *TwoFactorService) ConfirmEnrolment(userID, code string) ([]string, error) {
+	tf, err := models.GetTwoFactor(database.DB, userID)
+	if err != nil {
+		return nil, ErrTwoFactorNotPending
+	}
+	if tf.Enabled {
+		return nil, ErrTwoFactorAlreadyEnabled
+	}
+
+	step, ok := ValidateTOTP(tf.Secret, code, time.Now())
+	if !ok {
+		return nil, ErrInvalidTwoFactorCode
+	}
+
+	now := time.Now()
+	if err := database.DB.Model(tf).Updates(map[string]interface{}{
+		"enabled":        true,
+		"confirmed_at":   now,
+		"last_used_step": step,
+	}).Error; err != nil {
+		return nil, errors.New("failed to enable two-factor authentication")
+	}
+
+	return s.issueRecoveryCodes(userID)
+}
+
+// Disable turns off 2FA. The user must re-authenticate with their password
+// and a current code (or recovery code).
+func (s *TwoFactorService) Disable(user *models.User, password, code string) error {
+	if err := s.reauthenticate(user, password, code); err != nil {
+		return err
+	}
+	if err := models.DeleteTwoFactor(database.DB, user.ID); err != nil {
+		return errors.New("failed to disable two-factor authentication")
+	}
+	return nil
+}
+
+// RegenerateRecoveryCodes replaces all recovery codes after re-authentication
+func (s *TwoFactorService) RegenerateRecoveryCodes(user *models.User, password, code string) ([]string, error) {
+	if err := s.reauthenticate(user, password, code); err != nil {
+		return nil, err
+	}
+	return s.issueRecoveryCodes(user.ID)
+}
+
+func (s *TwoFactorService) reauthenticate(user *models.User, password, code string) error {
+	if !models.IsTwoFactorEnabled(database.DB, user.ID) {
+		return ErrTwoFactorNotEnabled
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)); err != nil {
+		return ErrInvalidPassword
+	}
+	return s.Verify(user.ID, code)
+}
+
+// Verify checks a TOTP code or, failing that, a recovery code for a user with 2FA enabled.
+// TOTP codes can only be used once; recovery codes are consumed.
+func (s *TwoFactorService) Verify(userID, code string) error {
+	tf, err := models.GetTwoFactor(database.DB, userID)
+	if err != nil || !tf.Enabled {
+		return ErrTwoFactorNotEnabled
+	}
+
+	if step, ok := ValidateTOTP(tf.Secret, code, time.Now()); ok {
+		fresh, err := models.AdvanceTwoFactorStep(database.DB, userID, step)
+		if err != nil || !fresh {
+			return ErrInvalidTwoFactorCode
+		}
+		return nil
+	}
+
+	normalized := normalizeRecoveryCode(code)
+	if len(normalized) == 10 {
+		used, err := models.UseRecoveryCode(database.DB, userID, hashToken(normalized))
+		if err == nil && used {
+			return nil
+		}
+	}
+
+	return ErrInvalidTwoFactorCode
+}
+
+func (s *TwoFactorService) issueRecoveryCodes(userID string) ([]string, error) {
+	codes := make([]string, RecoveryCodeCount)
+	hashes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, errors.New("failed to generate recovery codes")
+		}
+		codes[i] = code
+		hashes[i] = hashToken(normalizeRecoveryCode(code))
+	}
+
+	if err := models.ReplaceRecoveryCodes(database.DB, userID, hashes); err != nil {
+		return nil, errors.New("failed to store recovery codes")
+	}
+	return codes, nil
+}
+
+// generateChallengeToken issues the short-lived token returned by a password
+// login when a second factor is still needed. The token's jti names a stored
+// challenge, so it can be used once and only for a few attempts.
+func generateChallengeToken(user *models.User) (string, error) {
+	expiresAt := time.Now().Add(TwoFactorChallengeTTL)
+	challenge, err := models.CreateTwoFactorChallenge(database.DB, user.ID, expiresAt)
+	if err != nil {
+		return "", err
+	}
+
+	claims := Claims{
+		UserID:   user.ID,
+		Username: user.Username,
+		Type:     TokenTypeTwoFactorChallenge,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        challenge.ID,
+			ExpiresAt: jwt.NewNumericDate(expiresAt),
+			IssuedAt:  jwt.NewNumericDate(time.Now()),
+		},
+	}
+
+	return GetTokenKeys().Sign(claims)
+}
+
+// validateChallengeToken checks a challenge token and counts a code attempt
+// against it
+func validateChallengeToken(tokenString string) (*Claims, error) {
+	token, err := GetTokenKeys().Parse(tokenString, &Claims{})
+	if err != nil {
+		return nil, ErrInvalidChallenge
+	}
+
+	claims, ok := token.Claims.(*Claims)
+	if !ok || !token.Valid || claims.Type != TokenTypeTwoFactorChallenge || claims.ID == "" {
+		return nil, ErrInvalidChallenge
+	}
+	claimed, err := models.ClaimTwoFactorChallengeAttempt(database.DB, claims.ID, claims.UserID, TwoFactorChallengeMaxAttempts)
+	if err != nil || !claimed {
+		return nil, ErrInvalidChallenge
+	}
+	return claims, nil
+}
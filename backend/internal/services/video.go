@@ -6,6 +6,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 )
@@ -23,11 +24,35 @@ type AudioMetadata struct {
 	Duration int // Duration in seconds
 }
 
+// VideoFrame is a still frame sampled from a video for moderation
+type VideoFrame struct {
+	Path      string  // JPEG file containing the frame
+	Timestamp float64 // Position in the video, in seconds
+}
+
+// DefaultModerationFrames is the number of frames sampled from each video
+const DefaultModerationFrames = 8
+
+// hlsBitrates maps rendition heights to target video bitrates
+var hlsBitrates = map[int]int{
+	240:  400_000,
+	360:  800_000,
+	480:  1_400_000,
+	720:  2_800_000,
+	1080: 5_000_000,
+}
+
 // VideoService handles video processing operations
 type VideoService struct {
 	ffprobePath string
 	ffmpegPath  string
 	outputDir   string
+	// Frames sampled per video for moderation (MODERATION_VIDEO_FRAMES)
+	moderationFrames int
+	// HLS packaging of approved videos (HLS_ENABLED, HLS_RENDITIONS)
+	hlsEnabled    bool
+	hlsRenditions []int
+	hlsDir        string
 }
 
 // NewVideoService creates a new VideoService
@@ -40,6 +65,11 @@ func NewVideoService() *VideoService {
 		ffprobePath: ffprobePath,
 		ffmpegPath:  ffmpegPath,
 		outputDir:   "./uploads/thumbnails",
+
+		moderationFrames: intFromEnv("MODERATION_VIDEO_FRAMES", DefaultModerationFrames),
+		hlsEnabled:       os.Getenv("HLS_ENABLED") == "true",
+		hlsRenditions:    parseRenditions(getEnvOrDefault("HLS_RENDITIONS", "360,720")),
+		hlsDir:           "./uploads/hls",
 	}
 }
 
@@ -203,3 +233,190 @@ func (s *VideoService) ProcessVideo(videoPath, filename string) (*VideoMetadata,
 	metadata.ThumbnailPath = thumbnailPath
 	return metadata, nil
 }
+
+// ExtractKeyframes samples count frames spread evenly across a video and
+// writes them as JPEGs to a temporary directory. A count of 0 uses the
+// configured MODERATION_VIDEO_FRAMES. The caller must remove the returned
+// directory once the frames have been scanned.
+func (s *VideoService) ExtractKeyframes(videoPath string, count int) ([]VideoFrame, string, error) {
+	if !s.IsAvailable() {
+		return nil, "", fmt.Errorf("ffmpeg not found in PATH")
+	}
+	if count <= 0 {
+		count = s.moderationFrames
+	}
+	if count <= 0 {
+		count = DefaultModerationFrames
+	}
+
+	duration, err := s.probeDuration(videoPath)
+	if err != nil {
+		return nil, "", err
+	}
+
+	dir, err := os.MkdirTemp("", "frames-*")
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to create frame directory: %w", err)
+	}
+
+	// Very short clips get a single frame from the start
+	if duration <= 0 {
+		count = 1
+	}
+
+	var frames []VideoFrame
+	for i := 0; i < count; i++ {
+		// Sample from the middle of each segment so the first and last
+		// frames (often black) are not over-represented
+		timestamp := duration * (float64(i) + 0.5) / float64(count)
+		framePath := filepath.Join(dir, fmt.Sprintf("frame_%03d.jpg", i))
+
+		cmd := exec.Command(s.ffmpegPath,
+			"-ss", strconv.FormatFloat(timestamp, 'f', 3, 64), // Seek before input for fast keyframe seeking
+			"-i", videoPath,
+			"-vframes", "1",
+			"-q:v", "3",
+			"-y",
+			framePath,
+		)
+		if err := cmd.Run(); err != nil {
+			continue
+		}
+		if _, err := os.Stat(framePath); err != nil {
+			continue
+		}
+
+		frames = append(frames, VideoFrame{Path: framePath, Timestamp: timestamp})
+	}
+
+	if len(frames) == 0 {
+		os.RemoveAll(dir)
+		return nil, "", fmt.Errorf("failed to extract any frames")
+	}
+
+	return frames, dir, nil
+}
+
+// probeDuration returns the exact duration of a media file in seconds
+func (s *VideoService) probeDuration(path string) (float64, error) {
+	cmd := exec.Command(s.ffprobePath,
+		"-v", "quiet",
+		"-print_format", "json",
+		"-show_format",
+		path,
+	)
+
+	output, err := cmd.Output()
+	if err != nil {
+		return 0, fmt.Errorf("ffprobe failed: %w", err)
+	}
+
+	var probeData struct {
+		Format struct {
+			Duration string `json:"duration"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(output, &probeData); err != nil {
+		return 0, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+
+	if probeData.Format.Duration == "" {
+		return 0, nil
+	}
+	return strconv.ParseFloat(probeData.Format.Duration, 64)
+}
+
+// HLSEnabled reports whether approved videos should be packaged for HLS playback
+func (s *VideoService) HLSEnabled() bool {
+	return s.hlsEnabled && s.IsAvailable()
+}
+
+// GenerateHLS segments a video into one HLS rendition per configured height
+// (skipping heights above the source) and writes a master playlist.
+// Returns the path of the master playlist.
+func (s *VideoService) GenerateHLS(videoPath, mediaID string, sourceWidth, sourceHeight int) (string, error) {
+	if !s.IsAvailable() {
+		return "", fmt.Errorf("ffmpeg not found in PATH")
+	}
+
+	outputDir := filepath.Join(s.hlsDir, mediaID)
+	if err := os.MkdirAll(outputDir, 0755); err != nil {
+		return "", fmt.Errorf("failed to create HLS directory: %w", err)
+	}
+
+	var heights []int
+	for _, h := range s.hlsRenditions {
+		if sourceHeight == 0 || h <= sourceHeight {
+			heights = append(heights, h)
+		}
+	}
+	if len(heights) == 0 && len(s.hlsRenditions) > 0 {
+		// Source is smaller than every rendition; keep the lowest one
+		heights = []int{s.hlsRenditions[0]}
+	}
+
+	var master strings.Builder
+	master.WriteString("#EXTM3U\n#EXT-X-VERSION:3\n")
+
+	for _, height := range heights {
+		bitrate := hlsBitrates[height]
+		if bitrate == 0 {
+			bitrate = height * 4000
+		}
+
+		renditionDir := filepath.Join(outputDir, strconv.Itoa(height))
+		if err := os.MkdirAll(renditionDir, 0755); err != nil {
+			os.RemoveAll(outputDir)
+			return "", fmt.Errorf("failed to create HLS directory: %w", err)
+		}
+
+		cmd := exec.Command(s.ffmpegPath,
+			"-i", videoPath,
+			"-vf", fmt.Sprintf("scale=-2:%d", height),
+			"-c:v", "libx264",
+			"-preset", "veryfast",
+			"-b:v", strconv.Itoa(bitrate),
+			"-c:a", "aac",
+			"-b:a", "128k",
+			"-f", "hls",
+			"-hls_time", "6",
+			"-hls_playlist_type", "vod",
+			"-hls_segment_filename", filepath.Join(renditionDir, "seg_%03d.ts"),
+			"-y",
+			filepath.Join(renditionDir, "index.m3u8"),
+		)
+		if err := cmd.Run(); err != nil {
+			os.RemoveAll(outputDir)
+			return "", fmt.Errorf("failed to segment %dp rendition: %w", height, err)
+		}
+
+		master.WriteString(fmt.Sprintf("#EXT-X-STREAM-INF:BANDWIDTH=%d", bitrate+128_000))
+		if sourceWidth > 0 && sourceHeight > 0 {
+			width := sourceWidth * height / sourceHeight
+			width -= width % 2
+			master.WriteString(fmt.Sprintf(",RESOLUTION=%dx%d", width, height))
+		}
+		master.WriteString(fmt.Sprintf("\n%d/index.m3u8\n", height))
+	}
+
+	masterPath := filepath.Join(outputDir, "master.m3u8")
+	if err := os.WriteFile(masterPath, []byte(master.String()), 0644); err != nil {
+		os.RemoveAll(outputDir)
+		return "", fmt.Errorf("failed to write master playlist: %w", err)
+	}
+
+	return masterPath, nil
+}
+
+// parseRenditions parses a comma-separated list of rendition heights
+func parseRenditions(value string) []int {
+	var heights []int
+	for _, part := range strings.Split(value, ",") {
+		h, err := strconv.Atoi(strings.TrimSpace(part))
+		if err == nil && h > 0 {
+			heights = append(heights, h)
+		}
+	}
+	sort.Ints(heights)
+	return heights
+}
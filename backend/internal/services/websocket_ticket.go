@@ -0,0 +1,152 @@
+package services
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"messenger/internal/database"
+	"messenger/internal/models"
+)
+
+// WebSocketTicketTTL is how long a ticket can be redeemed after it was issued
+const WebSocketTicketTTL = 30 * time.Second
+
+var (
+	ErrInvalidWebSocketTicket      = errors.New("invalid or expired ticket")
+	ErrUnknownDevice               = errors.New("unknown device")
+	ErrWebSocketQueryTokenDisabled = errors.New("access tokens in the URL are disabled; use a ticket")
+)
+
+// WebSocketAuth identifies an authenticated WebSocket connection. ExpiresAt is
+// when the connection must re-authenticate in-band.
+type WebSocketAuth struct {
+	UserID    string
+	Username  string
+	SessionID string
+	DeviceID  string
+	ExpiresAt time.Time
+}
+
+// IssueWebSocketTicket creates a single-use ticket for the caller's session.
+// authExpiresAt is the expiry of the access token the ticket is issued for;
+// the connection has to re-authenticate in-band before then. If deviceID is
+// set it must be one of the user's registered E2EE devices, and the
+// connection must present the same device ID.
+func IssueWebSocketTicket(userID, sessionID string, authExpiresAt time.Time, deviceID string) (string, time.Time, error) {
+	if deviceID != "" {
+		var count int64
+		database.DB.Model(&models.EncryptionDevice{}).
+			Where("user_id = ? AND device_id = ?", userID, deviceID).
+			Count(&count)
+		if count == 0 {
+			return "", time.Time{}, ErrUnknownDevice
+		}
+	}
+
+	token, err := generateOpaqueToken()
+	if err != nil {
+		return "", time.Time{}, errors.New("failed to generate ticket")
+	}
+
+	ticket := models.WebSocketTicket{
+		TicketHash:    hashToken(token),
+		UserID:        userID,
+		SessionID:     sessionID,
+		DeviceID:      deviceID,
+		AuthExpiresAt: authExpiresAt,
+		ExpiresAt:     time.Now().Add(WebSocketTicketTTL),
+	}
+	if err := database.DB.Create(&ticket).Error; err != nil {
+		return "", time.Time{}, errors.New("failed to store ticket")
+	}
+	return token, ticket.ExpiresAt, nil
+}
+
+// RedeemWebSocketTicket consumes a ticket. The ticket's session must still be
+// active, and deviceID must match the device the ticket was issued for.
+func RedeemWebSocketTicket(token, deviceID string) (*WebSocketAuth, error) {
+	ticket, err := models.GetWebSocketTicketByHash(database.DB, hashToken(token))
+	if err != nil {
+		return nil, ErrInvalidWebSocketTicket
+	}
+
+	used, err := models.UseWebSocketTicket(database.DB, ticket.ID)
+	if err != nil || !used {
+		return nil, ErrInvalidWebSocketTicket
+	}
+
+	if ticket.DeviceID != "" && ticket.DeviceID != deviceID {
+		return nil, ErrInvalidWebSocketTicket
+	}
+	if !IsSessionActive(ticket.SessionID) {
+		return nil, ErrSessionRevoked
+	}
+
+	user, err := GetUserByID(ticket.UserID)
+	if err != nil {
+		return nil, ErrInvalidWebSocketTicket
+	}
+
+	return &WebSocketAuth{
+		UserID:    user.ID,
+		Username:  user.Username,
+		SessionID: ticket.SessionID,
+		DeviceID:  ticket.DeviceID,
+		ExpiresAt: ticket.AuthExpiresAt,
+	}, nil
+}
+
+// AuthenticateWebSocketToken authenticates a connection (or an in-band
+// re-authentication) with an access token
+func AuthenticateWebSocketToken(token string) (*WebSocketAuth, error) {
+	claims, err := AuthenticateToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	auth := &WebSocketAuth{
+		UserID:    claims.UserID,
+		Username:  claims.Username,
+		SessionID: claims.SessionID,
+	}
+	if claims.ExpiresAt != nil {
+		auth.ExpiresAt = claims.ExpiresAt.Time
+	}
+	return auth, nil
+}
+
+// AuthenticateWebSocketQueryToken authenticates a connection with an access
+// token passed as ?token=. This is deprecated, since URLs end up in proxy and
+// access logs, and only allowed when WS_ALLOW_QUERY_TOKEN=true.
+func AuthenticateWebSocketQueryToken(token string) (*WebSocketAuth, error) {
+	if getEnvOrDefault("WS_ALLOW_QUERY_TOKEN", "false") != "true" {
+		return nil, ErrWebSocketQueryTokenDisabled
+	}
+
+	auth, err := AuthenticateWebSocketToken(token)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Deprecated: user %s connected to /ws with ?token=; use a ticket from /api/auth/ws-ticket", auth.UserID)
+	return auth, nil
+}
+
+// Reasons returned by SessionEndReason
+const (
+	SessionEndRevoked = "revoked"
+	SessionEndExpired = "expired"
+)
+
+// SessionEndReason explains why a session is no longer usable, or returns ""
+// if it is still active
+func SessionEndReason(sessionID string) string {
+	session, err := models.GetSession(database.DB, sessionID)
+	if err != nil || session.RevokedAt != nil {
+		return SessionEndRevoked
+	}
+	if !time.Now().Before(session.ExpiresAt) {
+		return SessionEndExpired
+	}
+	return ""
+}
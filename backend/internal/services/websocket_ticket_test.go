@@ -0,0 +1,148 @@
+package services
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"messenger/internal/database"
+	"messenger/internal/models"
+)
+
+func TestWebSocketTicket_Redeem(t *testing.T) {
+	cleanup := setupAuthTestDB(t)
+	defer cleanup()
+
+	svc := NewAuthService()
+	resp, _ := svc.Register(RegisterInput{Username: "wsuser", Password: "password123"})
+	authExpiry := time.Now().Add(15 * time.Minute)
+
+	ticket, expiresAt, err := IssueWebSocketTicket(resp.User.ID, resp.SessionID, authExpiry, "")
+	if err != nil {
+		t.Fatalf("IssueWebSocketTicket failed: %v", err)
+	}
+	if time.Until(expiresAt) > WebSocketTicketTTL {
+		t.Errorf("Ticket should expire within %v, got %v", WebSocketTicketTTL, expiresAt)
+	}
+
+	auth, err := RedeemWebSocketTicket(ticket, "")
+	if err != nil {
+		t.Fatalf("RedeemWebSocketTicket failed: %v", err)
+	}
+	if auth.UserID != resp.User.ID || auth.Username != "wsuser" || auth.SessionID != resp.SessionID {
+		t.Errorf("Unexpected auth %+v", auth)
+	}
+	if !auth.ExpiresAt.Equal(authExpiry) {
+		t.Errorf("Expected the access token's expiry %v, got %v", authExpiry, auth.ExpiresAt)
+	}
+
+	t.Run("single use", func(t *testing.T) {
+		if _, err := RedeemWebSocketTicket(ticket, ""); !errors.Is(err, ErrInvalidWebSocketTicket) {
+			t.Errorf("Expected ErrInvalidWebSocketTicket, got %v", err)
+		}
+	})
+
+	t.Run("expired", func(t *testing.T) {
+		expired, _, _ := IssueWebSocketTicket(resp.User.ID, resp.SessionID, authExpiry, "")
+		database.DB.Model(&models.WebSocketTicket{}).Where("ticket_hash = ?", hashToken(expired)).
+			Update("expires_at", time.Now().Add(-time.Second))
+		if _, err := RedeemWebSocketTicket(expired, ""); !errors.Is(err, ErrInvalidWebSocketTicket) {
+			t.Errorf("Expected ErrInvalidWebSocketTicket, got %v", err)
+		}
+	})
+
+	t.Run("unknown ticket", func(t *testing.T) {
+		if _, err := RedeemWebSocketTicket("not-a-ticket", ""); !errors.Is(err, ErrInvalidWebSocketTicket) {
+			t.Errorf("Expected ErrInvalidWebSocketTicket, got %v", err)
+		}
+	})
+
+	t.Run("revoked session", func(t *testing.T) {
+		pending, _, _ := IssueWebSocketTicket(resp.User.ID, resp.SessionID, authExpiry, "")
+		svc.RevokeSession(resp.User.ID, resp.SessionID, models.SessionRevokedLogout)
+		if _, err := RedeemWebSocketTicket(pending, ""); !errors.Is(err, ErrSessionRevoked) {
+			t.Errorf("Expected ErrSessionRevoked, got %v", err)
+		}
+		if reason := SessionEndReason(resp.SessionID); reason != SessionEndRevoked {
+			t.Errorf("Expected %q, got %q", SessionEndRevoked, reason)
+		}
+	})
+}
+
+func TestWebSocketTicket_DeviceBinding(t *testing.T) {
+	cleanup := setupAuthTestDB(t)
+	defer cleanup()
+	database.DB.AutoMigrate(&models.EncryptionDevice{})
+
+	svc := NewAuthService()
+	resp, _ := svc.Register(RegisterInput{Username: "deviceuser", Password: "password123"})
+	database.DB.Create(&models.EncryptionDevice{UserID: resp.User.ID, DeviceID: "phone"})
+
+	if _, _, err := IssueWebSocketTicket(resp.User.ID, resp.SessionID, time.Time{}, "laptop"); !errors.Is(err, ErrUnknownDevice) {
+		t.Errorf("Expected ErrUnknownDevice, got %v", err)
+	}
+
+	ticket, _, _ := IssueWebSocketTicket(resp.User.ID, resp.SessionID, time.Time{}, "phone")
+	if _, err := RedeemWebSocketTicket(ticket, "laptop"); !errors.Is(err, ErrInvalidWebSocketTicket) {
+		t.Errorf("Ticket presented by another device should be rejected, got %v", err)
+	}
+
+	ticket, _, _ = IssueWebSocketTicket(resp.User.ID, resp.SessionID, time.Time{}, "phone")
+	auth, err := RedeemWebSocketTicket(ticket, "phone")
+	if err != nil {
+		t.Fatalf("RedeemWebSocketTicket failed: %v", err)
+	}
+	if auth.DeviceID != "phone" {
+		t.Errorf("Expected device 'phone', got %q", auth.DeviceID)
+	}
+}
+
+func TestWebSocketQueryToken(t *testing.T) {
+	cleanup := setupAuthTestDB(t)
+	defer cleanup()
+
+	svc := NewAuthService()
+	resp, _ := svc.Register(RegisterInput{Username: "wsquery", Password: "password123"})
+
+	t.Run("disabled by default", func(t *testing.T) {
+		if _, err := AuthenticateWebSocketQueryToken(resp.AccessToken); !errors.Is(err, ErrWebSocketQueryTokenDisabled) {
+			t.Errorf("Expected ErrWebSocketQueryTokenDisabled, got %v", err)
+		}
+	})
+
+	t.Run("allowed with WS_ALLOW_QUERY_TOKEN", func(t *testing.T) {
+		t.Setenv("WS_ALLOW_QUERY_TOKEN", "true")
+		auth, err := AuthenticateWebSocketQueryToken(resp.AccessToken)
+		if err != nil {
+			t.Fatalf("AuthenticateWebSocketQueryToken failed: %v", err)
+		}
+		if auth.UserID != resp.User.ID || auth.SessionID != resp.SessionID {
+			t.Errorf("Unexpected auth %+v", auth)
+		}
+		if _, err := AuthenticateWebSocketQueryToken("not-a-token"); err == nil {
+			t.Error("Expected an invalid token to be rejected")
+		}
+	})
+}
+
+func TestSessionEndReason(t *testing.T) {
+	cleanup := setupAuthTestDB(t)
+	defer cleanup()
+
+	svc := NewAuthService()
+	resp, _ := svc.Register(RegisterInput{Username: "enduser", Password: "password123"})
+
+	if reason := SessionEndReason(resp.SessionID); reason != "" {
+		t.Errorf("Active session should have no end reason, got %q", reason)
+	}
+
+	database.DB.Model(&models.Session{}).Where("id = ?", resp.SessionID).
+		Update("expires_at", time.Now().Add(-time.Minute))
+	if reason := SessionEndReason(resp.SessionID); reason != SessionEndExpired {
+		t.Errorf("Expected %q, got %q", SessionEndExpired, reason)
+	}
+
+	if reason := SessionEndReason("missing"); reason != SessionEndRevoked {
+		t.Errorf("Unknown sessions should count as revoked, got %q", reason)
+	}
+}
@@ -0,0 +1,605 @@
+package websocket
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"messenger/internal/database"
+	"messenger/internal/models"
+	"messenger/internal/services"
+)
+
+// activeCall is a call that is ringing or running
+type activeCall struct {
+	record       *models.Call
+	participants map[string]*models.CallParticipant // userID -> participant, caller included
+	ringTimer    *time.Timer
+}
+
+// peerOf returns the other party of a 1:1 call
+func (ac *activeCall) peerOf(userID string) string {
+	for id := range ac.participants {
+		if id != userID {
+			return id
+		}
+	}
+	return ""
+}
+
+// inCall reports whether a user is ringing or joined, i.e. can exchange signals
+func (ac *activeCall) inCall(userID string) bool {
+	p, ok := ac.participants[userID]
+	return ok && (p.Status == models.CallParticipantRinging || p.Status == models.CallParticipantJoined)
+}
+
+func (ac *activeCall) count(status string) int {
+	n := 0
+	for _, p := range ac.participants {
+		if p.Status == status {
+			n++
+		}
+	}
+	return n
+}
+
+// CallManager keeps the signaling state of calls in progress. State is kept
+// per user rather than per connection, so a user is busy whichever device
+// they're calling from, and a call survives moving to a new connection.
+// Database writes and pushes are queued while holding the mutex and run
+// after releasing it, so a slow database doesn't hold up every other call.
+type CallManager struct {
+	hub             *Hub
+	calls           map[string]*activeCall // callID -> call
+	userCalls       map[string]string      // userID -> call they're ringing for or in
+	ringTimeout     time.Duration
+	maxParticipants int
+	mutex           sync.Mutex
+	deferred        []func() // Queued by later, run by unlock
+}
+
+func NewCallManager(hub *Hub) *CallManager {
+	return &CallManager{
+		hub:             hub,
+		calls:           make(map[string]*activeCall),
+		userCalls:       make(map[string]string),
+		ringTimeout:     services.CallRingTimeout(),
+		maxParticipants: services.CallMaxParticipants(),
+	}
+}
+
+// later queues work to run once the mutex is released. Must hold the mutex.
+func (m *CallManager) later(fn func()) {
+	m.deferred = append(m.deferred, fn)
+}
+
+// unlock releases the mutex and runs the work queued while holding it
+func (m *CallManager) unlock() {
+	deferred := m.deferred
+	m.deferred = nil
+	m.mutex.Unlock()
+	for _, fn := range deferred {
+		fn()
+	}
+}
+
+// IsBusy reports whether a user is ringing for or in a call
+func (m *CallManager) IsBusy(userID string) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	_, busy := m.userCalls[userID]
+	return busy
+}
+
+func (c *Client) handleCallSignal(data []byte) {
+	var signal CallSignal
+	if err := json.Unmarshal(data, &signal); err != nil {
+		c.sendError("Invalid message format")
+		return
+	}
+
+	m := c.Hub.calls
+	switch {
+	case signal.Type == "call_offer" && signal.CallID == "":
+		m.start(c, signal)
+	case signal.CallID == "":
+		c.sendError("call_id is required")
+	case signal.Type == "call_answer":
+		m.answer(c, signal)
+	case signal.Type == "call_end":
+		m.end(c, signal)
+	default:
+		m.relay(c, signal)
+	}
+}
+
+// start rings the callee, or every member of a group
+func (m *CallManager) start(c *Client, signal CallSignal) {
+	media := models.CallMedia(signal.Media)
+	if media == "" {
+		media = models.CallMediaAudio
+	}
+	if media != models.CallMediaAudio && media != models.CallMediaVideo {
+		c.sendError("media must be audio or video")
+		return
+	}
+	if signal.To == "" && signal.GroupID == "" {
+		c.sendError("Recipient or group_id is required")
+		return
+	}
+
+	record := &models.Call{CallerID: c.UserID, Media: media, Status: models.CallStatusRinging}
+	var invitees []string
+	if signal.GroupID != "" {
+		var membership models.GroupMember
+		if err := database.DB.Where("group_id = ? AND user_id = ?", signal.GroupID, c.UserID).First(&membership).Error; err != nil {
+			c.sendError("You are not a member of this group")
+			return
+		}
+		members := c.Hub.GetGroupMemberIDs(signal.GroupID)
+		if len(members) > m.maxParticipants {
+			c.sendError("Group is too large for a call")
+			return
+		}
+		for _, id := range members {
+			if id != c.UserID {
+				invitees = append(invitees, id)
+			}
+		}
+		if len(invitees) == 0 {
+			c.sendError("There is nobody else in this group to call")
+			return
+		}
+		record.GroupID = &signal.GroupID
+	} else {
+		if signal.To == c.UserID || signal.To == services.BotUserID {
+			c.sendError("Cannot call this user")
+			return
+		}
+		var callee models.User
+		if err := database.DB.Select("id").First(&callee, "id = ?", signal.To).Error; err != nil {
+			c.sendError("User not found")
+			return
+		}
+		if models.IsUnreachable(database.DB, c.UserID, signal.To) {
+			c.sendError("Cannot call this user")
+			return
+		}
+		invitees = []string{signal.To}
+		record.RecipientID = &signal.To
+	}
+
+	// The record is stored before taking the lock so a slow database doesn't
+	// hold up every other call
+	if m.IsBusy(c.UserID) {
+		c.sendError("You are already in a call")
+		return
+	}
+	if err := database.DB.Create(record).Error; err != nil {
+		c.sendError("Failed to start call")
+		return
+	}
+
+	call, ringing, busy := m.ring(c, signal, record, invitees)
+	if call == nil {
+		database.DB.Delete(record) // Started another call in the meantime
+		c.sendError("You are already in a call")
+		return
+	}
+
+	for _, userID := range ringing {
+		services.PushIncomingCall(record, userID)
+	}
+	if record.IsGroupCall() {
+		for _, userID := range busy {
+			m.hub.SendJSONToUser(c.UserID, CallParticipantEvent{
+				Type:   "call_participant",
+				CallID: record.ID,
+				UserID: userID,
+				Status: models.CallParticipantBusy,
+			})
+		}
+	}
+
+	// A callee who stopped ringing before their push was registered above
+	// still needs it cancelled
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	for _, userID := range ringing {
+		switch call.participants[userID].Status {
+		case models.CallParticipantRinging:
+		case models.CallParticipantJoined, models.CallParticipantLeft:
+			services.PushCallCancelled(record, userID, services.CallCancelReasonAnswered)
+		case models.CallParticipantDeclined:
+			services.PushCallCancelled(record, userID, services.CallCancelReasonDeclined)
+		default:
+			services.PushCallCancelled(record, userID, services.CallCancelReasonCancelled)
+		}
+	}
+}
+
+// ring registers a new call and sends the offer to everyone who isn't busy.
+// Returns the ringing and busy invitees, or a nil call if the caller is
+// already in another one.
+func (m *CallManager) ring(c *Client, signal CallSignal, record *models.Call, invitees []string) (*activeCall, []string, []string) {
+	m.mutex.Lock()
+	defer m.unlock()
+
+	if _, busy := m.userCalls[c.UserID]; busy {
+		return nil, nil, nil
+	}
+
+	now := time.Now()
+	call := &activeCall{record: record, participants: make(map[string]*models.CallParticipant)}
+	call.participants[c.UserID] = &models.CallParticipant{
+		CallID: record.ID, UserID: c.UserID, Status: models.CallParticipantJoined, JoinedAt: &now,
+	}
+	var ringing, busy []string
+	for _, id := range invitees {
+		status := models.CallParticipantRinging
+		if _, inCall := m.userCalls[id]; inCall {
+			status = models.CallParticipantBusy
+			busy = append(busy, id)
+		} else {
+			ringing = append(ringing, id)
+		}
+		call.participants[id] = &models.CallParticipant{CallID: record.ID, UserID: id, Status: status}
+	}
+	for _, p := range call.participants {
+		if p.Status != models.CallParticipantBusy {
+			m.userCalls[p.UserID] = record.ID
+		}
+	}
+	m.calls[record.ID] = call
+
+	m.hub.SendJSONToUser(c.UserID, CallRingingEvent{
+		Type:    "call_ringing",
+		CallID:  record.ID,
+		To:      signal.To,
+		GroupID: signal.GroupID,
+		Media:   string(record.Media),
+	})
+
+	offer := CallSignal{
+		Type:    "call_offer",
+		CallID:  record.ID,
+		From:    c.UserID,
+		GroupID: signal.GroupID,
+		Media:   string(record.Media),
+	}
+	if signal.GroupID == "" {
+		offer.SDP = signal.SDP // Group calls exchange SDP per peer once members join
+	}
+	for _, userID := range ringing {
+		m.hub.SendJSONToUser(userID, offer)
+	}
+
+	if m.settle(call) {
+		return call, ringing, nil // The call record already names who was busy
+	}
+	callID := record.ID
+	call.ringTimer = time.AfterFunc(m.ringTimeout, func() { m.ringTimedOut(callID) })
+	return call, ringing, busy
+}
+
+// answer joins a ringing participant to the call, or relays a later answer
+// between two participants of a group call
+func (m *CallManager) answer(c *Client, signal CallSignal) {
+	m.mutex.Lock()
+	defer m.unlock()
+
+	call, ok := m.calls[signal.CallID]
+	if !ok || !call.inCall(c.UserID) {
+		c.sendError("Call not found")
+		return
+	}
+
+	p := call.participants[c.UserID]
+	if p.Status == models.CallParticipantRinging {
+		now := time.Now()
+		p.Status = models.CallParticipantJoined
+		p.JoinedAt = &now
+		services.PushCallCancelled(call.record, c.UserID, services.CallCancelReasonAnswered)
+		if call.record.Status == models.CallStatusRinging {
+			call.record.Status = models.CallStatusActive
+			call.record.AnsweredAt = &now
+			callID := call.record.ID
+			m.later(func() {
+				// Skip if the call already ended and was stored as such
+				database.DB.Model(&models.Call{}).Where("id = ? AND status = ?", callID, models.CallStatusRinging).
+					Updates(map[string]interface{}{
+						"status":      models.CallStatusActive,
+						"answered_at": now,
+					})
+			})
+		}
+		if call.record.IsGroupCall() {
+			m.notifyParticipant(call, p)
+			// Tell the newcomer who is already in, so peer connections can be set up
+			for id, other := range call.participants {
+				if id != c.UserID && other.Status == models.CallParticipantJoined {
+					m.hub.SendJSONToUser(c.UserID, CallParticipantEvent{
+						Type:   "call_participant",
+						CallID: call.record.ID,
+						UserID: id,
+						Status: other.Status,
+					})
+				}
+			}
+		} else if call.ringTimer != nil {
+			call.ringTimer.Stop()
+		}
+		if signal.To == "" {
+			if call.record.IsGroupCall() {
+				return // Everyone in the call heard about the join
+			}
+			signal.To = call.record.CallerID
+		}
+	}
+
+	m.forward(c, call, signal)
+}
+
+// end declines a ringing call or hangs up
+func (m *CallManager) end(c *Client, signal CallSignal) {
+	m.mutex.Lock()
+	defer m.unlock()
+
+	call, ok := m.calls[signal.CallID]
+	if !ok || !call.inCall(c.UserID) {
+		c.sendError("Call not found")
+		return
+	}
+	m.leave(call, c.UserID)
+}
+
+// Disconnected hangs up the call a user was in when their connection closed,
+// unless they carried on from a new connection in the meantime
+func (m *CallManager) Disconnected(client *Client) {
+	m.mutex.Lock()
+	defer m.unlock()
+
+	if current := m.hub.GetClient(client.UserID); current != nil && current != client {
+		return
+	}
+	if call, ok := m.calls[m.userCalls[client.UserID]]; ok {
+		m.leave(call, client.UserID)
+	}
+}
+
+// leave takes a user out of a call: a decline while ringing, a hang-up once
+// joined. Must hold the mutex.
+func (m *CallManager) leave(call *activeCall, userID string) {
+	p := call.participants[userID]
+	now := time.Now()
+	if p.Status == models.CallParticipantRinging {
+		p.Status = models.CallParticipantDeclined
+		services.PushCallCancelled(call.record, userID, services.CallCancelReasonDeclined)
+	} else {
+		p.Status = models.CallParticipantLeft
+		p.LeftAt = &now
+	}
+	delete(m.userCalls, userID)
+
+	if !m.settle(call) {
+		m.notifyParticipant(call, p)
+	}
+}
+
+// relay passes SDP and ICE candidates between two participants
+func (m *CallManager) relay(c *Client, signal CallSignal) {
+	if signal.Type == "ice_candidate" && len(signal.Candidate) == 0 {
+		c.sendError("candidate is required")
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	call, ok := m.calls[signal.CallID]
+	if !ok || !call.inCall(c.UserID) {
+		c.sendError("Call not found")
+		return
+	}
+	m.forward(c, call, signal)
+}
+
+// forward sends a signal on to its peer. Must hold the mutex.
+func (m *CallManager) forward(c *Client, call *activeCall, signal CallSignal) {
+	to := signal.To
+	if to == "" && !call.record.IsGroupCall() {
+		to = call.peerOf(c.UserID)
+	}
+	if to == "" || to == c.UserID || !call.inCall(to) {
+		c.sendError("Recipient is not in this call")
+		return
+	}
+
+	m.hub.SendJSONToUser(to, CallSignal{
+		Type:      signal.Type,
+		CallID:    call.record.ID,
+		From:      c.UserID,
+		Media:     signal.Media,
+		SDP:       signal.SDP,
+		Candidate: signal.Candidate,
+	})
+}
+
+// ringTimedOut marks everyone who didn't pick up as missed
+func (m *CallManager) ringTimedOut(callID string) {
+	m.mutex.Lock()
+	defer m.unlock()
+
+	call, ok := m.calls[callID]
+	if !ok {
+		return
+	}
+	var missed []string
+	for _, p := range call.participants {
+		if p.Status != models.CallParticipantRinging {
+			continue
+		}
+		p.Status = models.CallParticipantMissed
+		delete(m.userCalls, p.UserID)
+		services.PushCallCancelled(call.record, p.UserID, services.CallCancelReasonMissed)
+		m.hub.SendJSONToUser(p.UserID, CallSignal{
+			Type:   "call_end",
+			CallID: callID,
+			Reason: string(models.CallStatusMissed),
+		})
+		m.notifyParticipant(call, p)
+		missed = append(missed, p.UserID)
+	}
+	m.settle(call)
+	record := call.record
+	m.later(func() {
+		for _, userID := range missed {
+			m.pushMissed(record, userID)
+		}
+	})
+}
+
+// settle ends the call once it can't go on: the caller gave up before anyone
+// answered, nobody is left ringing, or at most one person is left in it.
+// Returns true if the call ended. Must hold the mutex.
+func (m *CallManager) settle(call *activeCall) bool {
+	joined := call.count(models.CallParticipantJoined)
+	ringing := call.count(models.CallParticipantRinging)
+
+	if call.record.Status == models.CallStatusActive {
+		if joined == 0 || (joined == 1 && ringing == 0) {
+			m.finish(call, models.CallStatusEnded)
+			return true
+		}
+		return false
+	}
+
+	// Nobody has answered yet
+	switch {
+	case call.participants[call.record.CallerID].Status != models.CallParticipantJoined:
+		m.finish(call, models.CallStatusCancelled)
+	case ringing > 0:
+		return false
+	case call.count(models.CallParticipantDeclined) > 0:
+		m.finish(call, models.CallStatusDeclined)
+	case call.count(models.CallParticipantMissed) > 0:
+		m.finish(call, models.CallStatusMissed)
+	default:
+		m.finish(call, models.CallStatusBusy)
+	}
+	return true
+}
+
+// finish ends a call: it tells whoever is still involved, and queues storing
+// the record and adding it to the conversation. Must hold the mutex.
+func (m *CallManager) finish(call *activeCall, status models.CallStatus) {
+	if call.ringTimer != nil {
+		call.ringTimer.Stop()
+	}
+	delete(m.calls, call.record.ID)
+
+	now := time.Now()
+	record := call.record
+	record.Status = status
+	record.EndedAt = &now
+	if record.AnsweredAt != nil {
+		record.DurationSeconds = int(now.Sub(*record.AnsweredAt).Seconds())
+	}
+
+	// Whoever is still ringing or joined hasn't heard the call is over
+	var notify, missed []string
+	for _, p := range call.participants {
+		if m.userCalls[p.UserID] == record.ID {
+			delete(m.userCalls, p.UserID)
+		}
+		switch p.Status {
+		case models.CallParticipantRinging:
+			p.Status = models.CallParticipantMissed
+			services.PushCallCancelled(record, p.UserID, services.CallCancelReasonCancelled)
+			missed = append(missed, p.UserID)
+			notify = append(notify, p.UserID)
+		case models.CallParticipantJoined:
+			p.Status = models.CallParticipantLeft
+			p.LeftAt = &now
+			notify = append(notify, p.UserID)
+		}
+		record.Participants = append(record.Participants, *p)
+	}
+
+	end := CallSignal{Type: "call_end", CallID: record.ID, Reason: string(status)}
+	for _, userID := range notify {
+		m.hub.SendJSONToUser(userID, end)
+	}
+
+	// The call is no longer tracked, so nothing changes the record from here on
+	m.later(func() {
+		if err := database.DB.Omit("Participants").Save(record).Error; err != nil {
+			log.Printf("Failed to save call %s: %v", record.ID, err)
+		}
+		if err := database.DB.Create(&record.Participants).Error; err != nil {
+			log.Printf("Failed to save participants of call %s: %v", record.ID, err)
+		}
+		m.postRecord(record)
+		for _, userID := range missed {
+			m.pushMissed(record, userID)
+		}
+	})
+}
+
+// postRecord adds the call to its conversation as a message
+func (m *CallManager) postRecord(record *models.Call) {
+	message := models.Message{
+		SenderID:    record.CallerID,
+		RecipientID: record.RecipientID,
+		GroupID:     record.GroupID,
+		CallID:      &record.ID,
+		Status:      models.MessageStatusSent,
+	}
+	if err := database.DB.Create(&message).Error; err != nil {
+		log.Printf("Failed to save call record message: %v", err)
+		return
+	}
+
+	outMsg := ChatMessage{
+		Type:      "message",
+		ID:        message.ID,
+		From:      record.CallerID,
+		Call:      record,
+		CreatedAt: message.CreatedAt.Format(time.RFC3339),
+	}
+	if record.IsGroupCall() {
+		outMsg.GroupID = *record.GroupID
+		m.hub.BroadcastToGroup(*record.GroupID, outMsg)
+		return
+	}
+	outMsg.To = *record.RecipientID
+	m.hub.SendJSONToUser(record.CallerID, outMsg)
+	m.hub.SendJSONToUser(*record.RecipientID, outMsg)
+}
+
+// pushMissed sends a missed-call push to a participant who isn't connected
+func (m *CallManager) pushMissed(record *models.Call, userID string) {
+	if !m.hub.IsOnline(userID) {
+		services.PushMissedCall(record, userID)
+	}
+}
+
+// notifyParticipant tells the rest of a group call about one participant.
+// Must hold the mutex.
+func (m *CallManager) notifyParticipant(call *activeCall, p *models.CallParticipant) {
+	if !call.record.IsGroupCall() {
+		return
+	}
+	event := CallParticipantEvent{
+		Type:   "call_participant",
+		CallID: call.record.ID,
+		UserID: p.UserID,
+		Status: p.Status,
+	}
+	for id, other := range call.participants {
+		if id != p.UserID && other.Status == models.CallParticipantJoined {
+			m.hub.SendJSONToUser(id, event)
+		}
+	}
+}
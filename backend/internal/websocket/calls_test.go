@@ -0,0 +1,387 @@
+package websocket
+
+import (
+	"encoding/json"
+	"sync"
+	"testing"
+	"time"
+
+	"gorm.io/gorm"
+	"messenger/internal/database"
+	"messenger/internal/models"
+)
+
+func connectTestClient(hub *Hub, userID string) *Client {
+	client := createTestClientWithHub(userID, hub)
+	hub.mutex.Lock()
+	hub.clients[userID] = client
+	hub.mutex.Unlock()
+	return client
+}
+
+// expectEvent reads from a client until a message of the given type arrives
+func expectEvent(t *testing.T, client *Client, msgType string) map[string]interface{} {
+	t.Helper()
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case data := <-client.Send:
+			var event map[string]interface{}
+			json.Unmarshal(data, &event)
+			if event["type"] == msgType {
+				return event
+			}
+		case <-timeout:
+			t.Fatalf("Expected %s event", msgType)
+			return nil
+		}
+	}
+}
+
+func createCallUsers(t *testing.T, names ...string) []*models.User {
+	var users []*models.User
+	for _, name := range names {
+		user := &models.User{Username: name}
+		database.DB.Create(user)
+		users = append(users, user)
+	}
+	return users
+}
+
+func TestCall_OfferAnswerEnd(t *testing.T) {
+	cleanup := setupClientTestDB(t)
+	defer cleanup()
+
+	hub := NewHub()
+	users := createCallUsers(t, "alice", "bob")
+	alice := connectTestClient(hub, users[0].ID)
+	bob := connectTestClient(hub, users[1].ID)
+
+	alice.handleMessage([]byte(`{"type": "call_offer", "to": "` + bob.UserID + `", "media": "video", "sdp": {"type": "offer", "sdp": "v=0"}}`))
+	ringing := expectEvent(t, alice, "call_ringing")
+	callID, _ := ringing["call_id"].(string)
+	if callID == "" {
+		t.Fatal("Expected a call ID")
+	}
+
+	offer := expectEvent(t, bob, "call_offer")
+	if offer["call_id"] != callID || offer["from"] != alice.UserID || offer["media"] != "video" {
+		t.Errorf("Unexpected offer: %v", offer)
+	}
+	if sdp, _ := offer["sdp"].(map[string]interface{}); sdp["sdp"] != "v=0" {
+		t.Errorf("Expected the SDP to be relayed, got %v", offer["sdp"])
+	}
+	if !hub.calls.IsBusy(bob.UserID) {
+		t.Error("Callee should be busy while ringing")
+	}
+
+	// Candidates are relayed to the other party without naming it
+	alice.handleMessage([]byte(`{"type": "ice_candidate", "call_id": "` + callID + `", "candidate": {"candidate": "candidate:1"}}`))
+	if candidate := expectEvent(t, bob, "ice_candidate"); candidate["from"] != alice.UserID {
+		t.Errorf("Unexpected candidate: %v", candidate)
+	}
+
+	bob.handleMessage([]byte(`{"type": "call_answer", "call_id": "` + callID + `", "sdp": {"type": "answer", "sdp": "v=0"}}`))
+	if answer := expectEvent(t, alice, "call_answer"); answer["from"] != bob.UserID {
+		t.Errorf("Unexpected answer: %v", answer)
+	}
+
+	alice.handleMessage([]byte(`{"type": "call_end", "call_id": "` + callID + `"}`))
+	if end := expectEvent(t, bob, "call_end"); end["reason"] != string(models.CallStatusEnded) {
+		t.Errorf("Expected ended, got %v", end["reason"])
+	}
+	if hub.calls.IsBusy(alice.UserID) || hub.calls.IsBusy(bob.UserID) {
+		t.Error("Nobody should be busy after the call")
+	}
+
+	var call models.Call
+	database.DB.First(&call, "id = ?", callID)
+	if call.Status != models.CallStatusEnded || call.AnsweredAt == nil || call.EndedAt == nil {
+		t.Errorf("Unexpected call record: %+v", call)
+	}
+
+	// The call is recorded in the conversation
+	record := expectEvent(t, bob, "message")
+	if history, _ := record["call"].(map[string]interface{}); history["id"] != callID {
+		t.Errorf("Expected call record message, got %v", record)
+	}
+	var message models.Message
+	if err := database.DB.First(&message, "call_id = ?", callID).Error; err != nil {
+		t.Fatal("Call record message was not saved")
+	}
+	if message.SenderID != alice.UserID || message.RecipientID == nil || *message.RecipientID != bob.UserID {
+		t.Errorf("Unexpected call record message: %+v", message)
+	}
+}
+
+func TestCall_Decline(t *testing.T) {
+	cleanup := setupClientTestDB(t)
+	defer cleanup()
+
+	hub := NewHub()
+	users := createCallUsers(t, "alice", "bob")
+	alice := connectTestClient(hub, users[0].ID)
+	bob := connectTestClient(hub, users[1].ID)
+
+	alice.handleMessage([]byte(`{"type": "call_offer", "to": "` + bob.UserID + `"}`))
+	callID := expectEvent(t, alice, "call_ringing")["call_id"].(string)
+
+	bob.handleMessage([]byte(`{"type": "call_end", "call_id": "` + callID + `"}`))
+	if end := expectEvent(t, alice, "call_end"); end["reason"] != string(models.CallStatusDeclined) {
+		t.Errorf("Expected declined, got %v", end["reason"])
+	}
+
+	var call models.Call
+	database.DB.Preload("Participants").First(&call, "id = ?", callID)
+	if call.Status != models.CallStatusDeclined || call.Media != models.CallMediaAudio || len(call.Participants) != 2 {
+		t.Errorf("Unexpected call record: %+v", call)
+	}
+}
+
+func TestCall_Busy(t *testing.T) {
+	cleanup := setupClientTestDB(t)
+	defer cleanup()
+
+	hub := NewHub()
+	users := createCallUsers(t, "alice", "bob", "carol")
+	alice := connectTestClient(hub, users[0].ID)
+	bob := connectTestClient(hub, users[1].ID)
+	carol := connectTestClient(hub, users[2].ID)
+
+	alice.handleMessage([]byte(`{"type": "call_offer", "to": "` + bob.UserID + `"}`))
+	expectEvent(t, alice, "call_ringing")
+	expectEvent(t, bob, "call_offer")
+
+	// Bob is ringing, so Carol gets a busy signal and Bob never hears of her call
+	carol.handleMessage([]byte(`{"type": "call_offer", "to": "` + bob.UserID + `"}`))
+	expectEvent(t, carol, "call_ringing")
+	if end := expectEvent(t, carol, "call_end"); end["reason"] != string(models.CallStatusBusy) {
+		t.Errorf("Expected busy, got %v", end["reason"])
+	}
+	expectEvent(t, bob, "message") // Call record only
+	select {
+	case data := <-bob.Send:
+		t.Errorf("Busy callee should get nothing else, got %s", data)
+	default:
+	}
+
+	// A caller can't start a second call
+	alice.handleMessage([]byte(`{"type": "call_offer", "to": "` + carol.UserID + `"}`))
+	if errMsg := expectEvent(t, alice, "error"); errMsg["error"] != "You are already in a call" {
+		t.Errorf("Unexpected error: %v", errMsg["error"])
+	}
+}
+
+func TestCall_RingTimeout(t *testing.T) {
+	cleanup := setupClientTestDB(t)
+	defer cleanup()
+
+	hub := NewHub()
+	hub.calls.ringTimeout = 50 * time.Millisecond
+	users := createCallUsers(t, "alice", "bob")
+	alice := connectTestClient(hub, users[0].ID)
+	bob := connectTestClient(hub, users[1].ID)
+
+	alice.handleMessage([]byte(`{"type": "call_offer", "to": "` + bob.UserID + `"}`))
+	callID := expectEvent(t, alice, "call_ringing")["call_id"].(string)
+
+	if end := expectEvent(t, bob, "call_end"); end["reason"] != string(models.CallStatusMissed) {
+		t.Errorf("Expected missed for callee, got %v", end["reason"])
+	}
+	if end := expectEvent(t, alice, "call_end"); end["reason"] != string(models.CallStatusMissed) {
+		t.Errorf("Expected missed for caller, got %v", end["reason"])
+	}
+
+	var call models.Call
+	database.DB.First(&call, "id = ?", callID)
+	if call.Status != models.CallStatusMissed || call.AnsweredAt != nil {
+		t.Errorf("Unexpected call record: %+v", call)
+	}
+}
+
+func TestCall_CallerDisconnects(t *testing.T) {
+	cleanup := setupClientTestDB(t)
+	defer cleanup()
+
+	hub := NewHub()
+	users := createCallUsers(t, "alice", "bob")
+	alice := connectTestClient(hub, users[0].ID)
+	bob := connectTestClient(hub, users[1].ID)
+
+	alice.handleMessage([]byte(`{"type": "call_offer", "to": "` + bob.UserID + `"}`))
+	expectEvent(t, alice, "call_ringing")
+
+	// A connection replaced by a new one doesn't hang up
+	stale := alice
+	alice = connectTestClient(hub, alice.UserID)
+	hub.calls.Disconnected(stale)
+	if !hub.calls.IsBusy(bob.UserID) {
+		t.Fatal("Call should survive moving to a new connection")
+	}
+
+	hub.calls.Disconnected(alice)
+	if end := expectEvent(t, bob, "call_end"); end["reason"] != string(models.CallStatusCancelled) {
+		t.Errorf("Expected cancelled, got %v", end["reason"])
+	}
+}
+
+func TestCall_SlowCallerDoesNotBlock(t *testing.T) {
+	cleanup := setupClientTestDB(t)
+	defer cleanup()
+
+	hub := NewHub()
+	users := createCallUsers(t, "alice", "bob")
+	alice := connectTestClient(hub, users[0].ID)
+	bob := connectTestClient(hub, users[1].ID)
+	alice.Send = make(chan []byte) // Nobody is reading from the connection
+
+	done := make(chan struct{})
+	go func() {
+		alice.handleMessage([]byte(`{"type": "call_offer", "to": "` + bob.UserID + `"}`))
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("Starting a call should not wait for the caller's connection")
+	}
+
+	offer := expectEvent(t, bob, "call_offer")
+	bob.handleMessage([]byte(`{"type": "call_end", "call_id": "` + offer["call_id"].(string) + `"}`))
+	if hub.calls.IsBusy(bob.UserID) {
+		t.Error("Other call signals should still be handled")
+	}
+}
+
+func TestCall_SlowDatabaseDoesNotBlock(t *testing.T) {
+	cleanup := setupClientTestDB(t)
+	defer cleanup()
+
+	hub := NewHub()
+	users := createCallUsers(t, "alice", "bob", "carol", "dave")
+	alice := connectTestClient(hub, users[0].ID)
+	bob := connectTestClient(hub, users[1].ID)
+	carol := connectTestClient(hub, users[2].ID)
+	dave := connectTestClient(hub, users[3].ID)
+
+	alice.handleMessage([]byte(`{"type": "call_offer", "to": "` + bob.UserID + `"}`))
+	callID := expectEvent(t, bob, "call_offer")["call_id"].(string)
+	bob.handleMessage([]byte(`{"type": "call_answer", "call_id": "` + callID + `"}`))
+
+	// Storing the ended call hangs until released
+	release := make(chan struct{})
+	releaseOnce := sync.OnceFunc(func() { close(release) })
+	defer releaseOnce()
+	database.DB.Callback().Update().Before("gorm:begin_transaction").Register("test:slow_calls", func(db *gorm.DB) {
+		if db.Statement.Table == "calls" {
+			<-release
+		}
+	})
+
+	hungUp := make(chan struct{})
+	go func() {
+		alice.handleMessage([]byte(`{"type": "call_end", "call_id": "` + callID + `"}`))
+		close(hungUp)
+	}()
+	if end := expectEvent(t, bob, "call_end"); end["reason"] != string(models.CallStatusEnded) {
+		t.Errorf("Expected ended, got %v", end["reason"])
+	}
+
+	started := make(chan struct{})
+	go func() {
+		carol.handleMessage([]byte(`{"type": "call_offer", "to": "` + dave.UserID + `"}`))
+		close(started)
+	}()
+	select {
+	case <-started:
+	case <-time.After(time.Second):
+		t.Fatal("Starting a call should not wait for another call to be stored")
+	}
+	expectEvent(t, dave, "call_offer")
+
+	releaseOnce()
+	<-hungUp
+	var call models.Call
+	database.DB.First(&call, "id = ?", callID)
+	if call.Status != models.CallStatusEnded || call.EndedAt == nil {
+		t.Errorf("Ended call should be stored once the database catches up, got %+v", call)
+	}
+}
+
+func TestCall_Blocked(t *testing.T) {
+	cleanup := setupClientTestDB(t)
+	defer cleanup()
+
+	hub := NewHub()
+	users := createCallUsers(t, "alice", "bob")
+	alice := connectTestClient(hub, users[0].ID)
+	bob := connectTestClient(hub, users[1].ID)
+	database.DB.Create(&models.Block{BlockerID: bob.UserID, BlockedID: alice.UserID})
+
+	alice.handleMessage([]byte(`{"type": "call_offer", "to": "` + bob.UserID + `"}`))
+	if errMsg := expectEvent(t, alice, "error"); errMsg["error"] != "Cannot call this user" {
+		t.Errorf("Unexpected error: %v", errMsg["error"])
+	}
+	if hub.calls.IsBusy(bob.UserID) {
+		t.Error("Blocked call should not ring")
+	}
+}
+
+func TestCall_Group(t *testing.T) {
+	cleanup := setupClientTestDB(t)
+	defer cleanup()
+
+	hub := NewHub()
+	users := createCallUsers(t, "alice", "bob", "carol")
+	group := &models.Group{Name: "Team", CreatedBy: users[0].ID}
+	database.DB.Create(group)
+	for _, user := range users {
+		database.DB.Create(&models.GroupMember{GroupID: group.ID, UserID: user.ID})
+	}
+	alice := connectTestClient(hub, users[0].ID)
+	bob := connectTestClient(hub, users[1].ID)
+	carol := connectTestClient(hub, users[2].ID)
+
+	alice.handleMessage([]byte(`{"type": "call_offer", "group_id": "` + group.ID + `"}`))
+	callID := expectEvent(t, alice, "call_ringing")["call_id"].(string)
+	expectEvent(t, bob, "call_offer")
+	expectEvent(t, carol, "call_offer")
+
+	// Bob joins: Alice hears about him, and he hears who is already in
+	bob.handleMessage([]byte(`{"type": "call_answer", "call_id": "` + callID + `"}`))
+	if joined := expectEvent(t, alice, "call_participant"); joined["user_id"] != bob.UserID || joined["status"] != models.CallParticipantJoined {
+		t.Errorf("Unexpected participant event: %v", joined)
+	}
+	if present := expectEvent(t, bob, "call_participant"); present["user_id"] != alice.UserID {
+		t.Errorf("Unexpected participant event: %v", present)
+	}
+
+	// Peers exchange SDP directly
+	bob.handleMessage([]byte(`{"type": "call_offer", "call_id": "` + callID + `", "to": "` + alice.UserID + `", "sdp": {"type": "offer"}}`))
+	if offer := expectEvent(t, alice, "call_offer"); offer["from"] != bob.UserID {
+		t.Errorf("Unexpected offer: %v", offer)
+	}
+
+	// Carol declines; the call goes on
+	carol.handleMessage([]byte(`{"type": "call_end", "call_id": "` + callID + `"}`))
+	if declined := expectEvent(t, alice, "call_participant"); declined["status"] != models.CallParticipantDeclined {
+		t.Errorf("Unexpected participant event: %v", declined)
+	}
+
+	// Signals can't reach someone who isn't in the call
+	alice.handleMessage([]byte(`{"type": "ice_candidate", "call_id": "` + callID + `", "to": "` + carol.UserID + `", "candidate": {}}`))
+	if errMsg := expectEvent(t, alice, "error"); errMsg["error"] != "Recipient is not in this call" {
+		t.Errorf("Unexpected error: %v", errMsg["error"])
+	}
+
+	// Once Alice hangs up, Bob is alone and the call ends
+	alice.handleMessage([]byte(`{"type": "call_end", "call_id": "` + callID + `"}`))
+	if end := expectEvent(t, bob, "call_end"); end["reason"] != string(models.CallStatusEnded) {
+		t.Errorf("Expected ended, got %v", end["reason"])
+	}
+
+	record := expectEvent(t, carol, "message")
+	if record["group_id"] != group.ID {
+		t.Errorf("Expected call record in the group, got %v", record)
+	}
+}
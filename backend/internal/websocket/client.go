@@ -2,7 +2,9 @@ package websocket
 
 import (
 	"encoding/json"
+	"errors"
 	"log"
+	"sync"
 	"time"
 
 	"github.com/gofiber/contrib/websocket"
@@ -18,24 +20,90 @@ const (
 	maxMessageSize = 65536
 )
 
+// Close codes sent to clients, in the range reserved for applications.
+// Clients should get a new ticket and reconnect after CloseTokenExpired, and
+// log in again after CloseSessionRevoked or CloseSessionExpired.
+const (
+	CloseUnauthorized   = 4001 // Missing or invalid ticket/token
+	CloseTokenExpired   = 4002 // Access token expired without in-band re-authentication
+	CloseSessionRevoked = 4003 // Session was revoked (logout, password change, ...)
+	CloseSessionExpired = 4004 // Session reached its expiry
+	CloseReplaced       = 4008 // Another connection for the same user took over
+)
+
 type Client struct {
 	Hub      *Hub
 	Conn     *websocket.Conn
 	UserID   string
 	Username string
 	Send     chan []byte
+
+	// Set on connect and replaced by in-band re-authentication on the read
+	// pump, while the hub reads them from other goroutines
+	authMu    sync.RWMutex
+	sessionID string // Session the connection authenticated with
+	deviceID  string // E2EE device bound by the connection ticket, if any
+
+	authExpiresAt time.Time      // When the connection must re-authenticate; zero means never
+	authRenewed   chan time.Time // New expiry after in-band re-authentication
+	closeOnce     sync.Once
 }
 
 func NewClient(hub *Hub, conn *websocket.Conn, userID, username string) *Client {
 	return &Client{
-		Hub:      hub,
-		Conn:     conn,
-		UserID:   userID,
-		Username: username,
-		Send:     make(chan []byte, 256),
+		Hub:         hub,
+		Conn:        conn,
+		UserID:      userID,
+		Username:    username,
+		Send:        make(chan []byte, 256),
+		authRenewed: make(chan time.Time, 1),
 	}
 }
 
+// SetSession sets the session and E2EE device the connection authenticated with
+func (c *Client) SetSession(sessionID, deviceID string) {
+	c.authMu.Lock()
+	defer c.authMu.Unlock()
+	c.sessionID = sessionID
+	c.deviceID = deviceID
+}
+
+// SessionID returns the session the connection is currently authenticated with
+func (c *Client) SessionID() string {
+	c.authMu.RLock()
+	defer c.authMu.RUnlock()
+	return c.sessionID
+}
+
+// DeviceID returns the E2EE device bound by the connection ticket, if any
+func (c *Client) DeviceID() string {
+	c.authMu.RLock()
+	defer c.authMu.RUnlock()
+	return c.deviceID
+}
+
+// SetAuthExpiry sets when the connection's credentials expire. Call before
+// starting the pumps; later renewals arrive via in-band "auth" messages.
+func (c *Client) SetAuthExpiry(expiresAt time.Time) {
+	c.authExpiresAt = expiresAt
+}
+
+// CloseWith sends a close frame with the given code and closes the connection.
+// Only the first call has an effect.
+func (c *Client) CloseWith(code int, reason string) {
+	c.closeOnce.Do(func() {
+		if c.Conn == nil {
+			return
+		}
+		c.Conn.WriteControl(
+			websocket.CloseMessage,
+			websocket.FormatCloseMessage(code, reason),
+			time.Now().Add(writeWait),
+		)
+		c.Conn.Close()
+	})
+}
+
 func (c *Client) ReadPump() {
 	defer func() {
 		c.Hub.unregister <- c
@@ -64,13 +132,26 @@ func (c *Client) ReadPump() {
 
 func (c *Client) WritePump() {
 	ticker := time.NewTicker(pingPeriod)
+	authTimer := time.NewTimer(time.Until(c.authExpiresAt))
+	if c.authExpiresAt.IsZero() {
+		authTimer.Stop()
+	}
 	defer func() {
 		ticker.Stop()
+		authTimer.Stop()
 		c.Conn.Close()
 	}()
 
 	for {
 		select {
+		case expiresAt := <-c.authRenewed:
+			authTimer.Stop()
+			authTimer.Reset(time.Until(expiresAt))
+
+		case <-authTimer.C:
+			c.closeExpired()
+			return
+
 		case message, ok := <-c.Send:
 			c.Conn.SetWriteDeadline(time.Now().Add(writeWait))
 			if !ok {
@@ -99,6 +180,8 @@ func (c *Client) handleMessage(data []byte) {
 	}
 
 	switch base.Type {
+	case "auth":
+		c.handleAuth(data)
 	case "message":
 		c.handleChatMessage(data)
 	case "encrypted_message":
@@ -115,6 +198,8 @@ func (c *Client) handleMessage(data []byte) {
 		c.handleMessageDelete(data)
 	case "reaction":
 		c.handleReaction(data)
+	case "call_offer", "call_answer", "ice_candidate", "call_end":
+		c.handleCallSignal(data)
 	default:
 		c.sendError("Unknown message type")
 	}
@@ -168,8 +253,8 @@ func (c *Client) handleDirectMessage(msg ChatMessage) {
 		return
 	}
 
-	// Check if either user has blocked the other
-	if models.IsEitherBlocked(database.DB, c.UserID, msg.To) {
+	// Check if either user has blocked the other or the recipient is gone
+	if models.IsUnreachable(database.DB, c.UserID, msg.To) {
 		c.sendError("Cannot send message to this user")
 		return
 	}
@@ -263,11 +348,12 @@ func (c *Client) handleDirectMessage(msg ChatMessage) {
 		ackBytes, _ := json.Marshal(ack)
 		c.Send <- ackBytes
 
-		// Send push notification to offline user
-		go services.PushMessageToOfflineUser(
+		// Queue push notification for offline user
+		services.PushMessageToOfflineUser(
 			database.DB,
 			msg.To,
 			c.UserID,
+			message.ID,
 			msg.Content,
 			false,
 			msg.To, // conversationID for DM is the other user's ID
@@ -376,6 +462,7 @@ func (c *Client) handleGroupMessage(msg ChatMessage) {
 					database.DB,
 					memberID,
 					c.UserID,
+					message.ID,
 					msg.Content,
 					true,
 					msg.GroupID,
@@ -450,9 +537,12 @@ func (c *Client) handleAckMessage(data []byte) {
 
 	// Update message status (e.g., mark as read)
 	if msg.Status == "read" {
-		database.DB.Model(&models.Message{}).
-			Where("id = ? AND recipient_id = ?", msg.MessageID, c.UserID).
+		marked := database.DB.Model(&models.Message{}).
+			Where("id = ? AND recipient_id = ? AND status != ?", msg.MessageID, c.UserID, models.MessageStatusRead).
 			Update("status", models.MessageStatusRead)
+		if marked.RowsAffected > 0 {
+			services.GetPushService().SyncBadge(c.UserID)
+		}
 
 		// Notify sender that message was read
 		var message models.Message
@@ -702,6 +792,61 @@ func (c *Client) handleReaction(data []byte) {
 	}
 }
 
+// closeExpired closes a connection whose credentials ran out, telling the
+// client whether a new token is enough or it has to log in again
+func (c *Client) closeExpired() {
+	switch services.SessionEndReason(c.SessionID()) {
+	case services.SessionEndRevoked:
+		c.CloseWith(CloseSessionRevoked, "session revoked")
+	case services.SessionEndExpired:
+		c.CloseWith(CloseSessionExpired, "session expired")
+	default:
+		c.CloseWith(CloseTokenExpired, "token expired")
+	}
+}
+
+// handleAuth re-authenticates the connection with a fresh access token so it
+// can outlive the token it was opened with
+func (c *Client) handleAuth(data []byte) {
+	var msg AuthMessage
+	if err := json.Unmarshal(data, &msg); err != nil || msg.Token == "" {
+		c.sendError("Token is required")
+		return
+	}
+
+	auth, err := services.AuthenticateWebSocketToken(msg.Token)
+	if errors.Is(err, services.ErrSessionRevoked) {
+		c.closeExpired()
+		return
+	}
+	if err != nil {
+		c.sendError("Invalid or expired token")
+		return
+	}
+	if auth.UserID != c.UserID {
+		c.CloseWith(CloseUnauthorized, "token belongs to another user")
+		return
+	}
+
+	c.authMu.Lock()
+	c.sessionID = auth.SessionID
+	c.authMu.Unlock()
+	select {
+	case <-c.authRenewed: // Replace a renewal the write pump hasn't picked up yet
+	default:
+	}
+	select {
+	case c.authRenewed <- auth.ExpiresAt:
+	default:
+	}
+
+	reply, _ := json.Marshal(AuthOKMessage{
+		Type:      "auth_ok",
+		ExpiresAt: auth.ExpiresAt.Format(time.RFC3339),
+	})
+	c.Send <- reply
+}
+
 func (c *Client) sendError(message string) {
 	errMsg := ErrorMessage{
 		Type:  "error",
@@ -748,8 +893,8 @@ func (c *Client) handleEncryptedMessage(data []byte) {
 }
 
 func (c *Client) handleEncryptedDirectMessage(msg EncryptedChatMessage) {
-	// Check if either user has blocked the other
-	if models.IsEitherBlocked(database.DB, c.UserID, msg.To) {
+	// Check if either user has blocked the other or the recipient is gone
+	if models.IsUnreachable(database.DB, c.UserID, msg.To) {
 		c.sendError("Cannot send message to this user")
 		return
 	}
@@ -807,6 +952,7 @@ func (c *Client) handleEncryptedDirectMessage(msg EncryptedChatMessage) {
 			database.DB,
 			msg.To,
 			c.UserID,
+			message.ID,
 			"Encrypted message", // Can't show content
 			false,
 			msg.To,
@@ -876,6 +1022,7 @@ func (c *Client) handleEncryptedGroupMessage(msg EncryptedChatMessage) {
 					database.DB,
 					memberID,
 					c.UserID,
+					message.ID,
 					"Encrypted message",
 					true,
 					msg.GroupID,
@@ -977,7 +1124,7 @@ func (c *Client) handleBotMessage(msg ChatMessage) {
 
 	// Generate bot response asynchronously
 	go func() {
-		response, err := botService.GenerateResponse(msg.Content, history)
+		response, err := botService.GenerateResponse(msg.Content, history, services.UserLocale(c.UserID))
 		if err != nil {
 			log.Printf("Bot response error: %v", err)
 			return
@@ -10,6 +10,7 @@ import (
 	"gorm.io/gorm/logger"
 	"messenger/internal/database"
 	"messenger/internal/models"
+	"messenger/internal/services"
 )
 
 func setupClientTestDB(t *testing.T) func() {
@@ -33,6 +34,11 @@ func setupClientTestDB(t *testing.T) func() {
 		&models.Reaction{},
 		&models.MessageDeletion{},
 		&models.ConversationSettings{},
+		&models.Session{},
+		&models.RefreshToken{},
+		&models.SigningKey{},
+		&models.Call{},
+		&models.CallParticipant{},
 	)
 
 	return func() {
@@ -988,3 +994,75 @@ func TestClient_SendError(t *testing.T) {
 		t.Error("Expected error message")
 	}
 }
+
+func TestClient_HandleAuth(t *testing.T) {
+	cleanup := setupClientTestDB(t)
+	defer cleanup()
+
+	svc := services.NewAuthService()
+	first, _ := svc.Register(services.RegisterInput{Username: "authuser", Password: "password123"})
+	second, _ := svc.Login(services.LoginInput{Username: "authuser", Password: "password123"})
+	other, _ := svc.Register(services.RegisterInput{Username: "otheruser", Password: "password123"})
+
+	hub := NewHub()
+	client := NewClient(hub, nil, first.User.ID, "authuser")
+	client.SetSession(first.SessionID, "")
+
+	t.Run("fresh token renews the connection", func(t *testing.T) {
+		data, _ := json.Marshal(AuthMessage{Type: "auth", Token: second.AccessToken})
+		client.handleMessage(data)
+
+		select {
+		case reply := <-client.Send:
+			var ok AuthOKMessage
+			json.Unmarshal(reply, &ok)
+			if ok.Type != "auth_ok" || ok.ExpiresAt == "" {
+				t.Errorf("Unexpected reply %s", reply)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected auth_ok")
+		}
+
+		if client.SessionID() != second.SessionID {
+			t.Errorf("Expected session %s, got %s", second.SessionID, client.SessionID())
+		}
+		select {
+		case <-client.authRenewed:
+		default:
+			t.Error("Write pump should be told about the new expiry")
+		}
+	})
+
+	t.Run("invalid token", func(t *testing.T) {
+		data, _ := json.Marshal(AuthMessage{Type: "auth", Token: "garbage"})
+		client.handleMessage(data)
+
+		select {
+		case reply := <-client.Send:
+			var errMsg ErrorMessage
+			json.Unmarshal(reply, &errMsg)
+			if errMsg.Type != "error" {
+				t.Errorf("Expected error, got %s", reply)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("Expected error message")
+		}
+		if client.SessionID() != second.SessionID {
+			t.Error("Invalid token should not change the session")
+		}
+	})
+
+	t.Run("another user's token", func(t *testing.T) {
+		data, _ := json.Marshal(AuthMessage{Type: "auth", Token: other.AccessToken})
+		client.handleMessage(data)
+
+		select {
+		case reply := <-client.Send:
+			t.Errorf("Expected the connection to be closed, got %s", reply)
+		default:
+		}
+		if client.SessionID() != second.SessionID {
+			t.Error("Another user's token should not change the session")
+		}
+	})
+}
@@ -15,16 +15,19 @@ type Hub struct {
 	register   chan *Client
 	unregister chan *Client
 	broadcast  chan []byte
+	calls      *CallManager
 	mutex      sync.RWMutex
 }
 
 func NewHub() *Hub {
-	return &Hub{
+	h := &Hub{
 		clients:    make(map[string]*Client),
 		register:   make(chan *Client),
 		unregister: make(chan *Client),
 		broadcast:  make(chan []byte),
 	}
+	h.calls = NewCallManager(h)
+	return h
 }
 
 func (h *Hub) Register(client *Client) {
@@ -38,7 +41,7 @@ func (h *Hub) Run() {
 			h.mutex.Lock()
 			// Close existing connection if any
 			if existing, ok := h.clients[client.UserID]; ok {
-				existing.Conn.Close()
+				existing.CloseWith(CloseReplaced, "replaced by a new connection")
 			}
 			h.clients[client.UserID] = client
 			h.mutex.Unlock()
@@ -49,13 +52,21 @@ func (h *Hub) Run() {
 
 		case client := <-h.unregister:
 			h.mutex.Lock()
+			current := false
 			if existing, ok := h.clients[client.UserID]; ok && existing == client {
 				delete(h.clients, client.UserID)
 				close(client.Send)
+				current = true
 			}
 			h.mutex.Unlock()
 			log.Printf("Client disconnected: %s", client.UserID)
 
+			// Hang up unless the user carried on from a new connection. This
+			// writes to the database, so it shouldn't hold up the hub.
+			if current {
+				go h.calls.Disconnected(client)
+			}
+
 			// Update last seen
 			database.DB.Model(&models.User{}).Where("id = ?", client.UserID).Update("last_seen", time.Now())
 
@@ -78,6 +89,21 @@ func (h *Hub) GetClient(userID string) *Client {
 	return h.clients[userID]
 }
 
+// DisconnectSession closes a user's connection if it was authenticated with
+// the given session. Used when a session is revoked.
+func (h *Hub) DisconnectSession(userID, sessionID string) {
+	h.mutex.RLock()
+	client, ok := h.clients[userID]
+	h.mutex.RUnlock()
+
+	if !ok || client.SessionID() != sessionID {
+		return
+	}
+
+	log.Printf("Disconnecting client %s: session %s revoked", userID, sessionID)
+	client.CloseWith(CloseSessionRevoked, "session revoked")
+}
+
 // GetActiveConnectionCount returns the number of currently connected clients
 func (h *Hub) GetActiveConnectionCount() int64 {
 	h.mutex.RLock()
@@ -217,6 +243,7 @@ type ChatMessage struct {
 	LocationName   *string       `json:"location_name,omitempty"`   // Optional place name
 	ScheduledAt    *string       `json:"scheduled_at,omitempty"`    // For scheduled messages
 	ExpiresAt      *string       `json:"expires_at,omitempty"`      // For disappearing messages
+	Call           *models.Call  `json:"call,omitempty"`            // For call history records
 	CreatedAt      string        `json:"created_at,omitempty"`
 }
 
@@ -253,6 +280,18 @@ type ErrorMessage struct {
 	Error string `json:"error"`
 }
 
+// In-band re-authentication: the client sends a fresh access token before
+// the current one expires
+type AuthMessage struct {
+	Type  string `json:"type"`
+	Token string `json:"token"`
+}
+
+type AuthOKMessage struct {
+	Type      string `json:"type"`
+	ExpiresAt string `json:"expires_at"`
+}
+
 // Message editing types
 type EditMessage struct {
 	Type      string `json:"type"`
@@ -347,3 +386,37 @@ type IdentityKeyChanged struct {
 	DeviceID  string `json:"device_id"`
 	NewKey    string `json:"new_key"` // Base64 encoded public key
 }
+
+// Call signaling types
+
+// CallSignal carries call_offer, call_answer, ice_candidate and call_end.
+// SDP and ICE candidates are relayed to the peer untouched.
+type CallSignal struct {
+	Type      string          `json:"type"`
+	CallID    string          `json:"call_id,omitempty"`  // Empty on the call_offer that starts a call
+	To        string          `json:"to,omitempty"`       // Peer user ID; defaults to the other party in 1:1 calls
+	GroupID   string          `json:"group_id,omitempty"` // For starting a group call
+	From      string          `json:"from,omitempty"`     // Sender ID (for outgoing)
+	Media     string          `json:"media,omitempty"`    // "audio" or "video"
+	SDP       json.RawMessage `json:"sdp,omitempty"`
+	Candidate json.RawMessage `json:"candidate,omitempty"`
+	Reason    string          `json:"reason,omitempty"` // For call_end: the call's final status
+}
+
+// CallRingingEvent confirms a new call to the caller, with its ID
+type CallRingingEvent struct {
+	Type    string `json:"type"`
+	CallID  string `json:"call_id"`
+	To      string `json:"to,omitempty"`
+	GroupID string `json:"group_id,omitempty"`
+	Media   string `json:"media"`
+}
+
+// CallParticipantEvent tells a group call's participants that someone
+// joined, left, declined, was busy or didn't answer
+type CallParticipantEvent struct {
+	Type   string `json:"type"`
+	CallID string `json:"call_id"`
+	UserID string `json:"user_id"`
+	Status string `json:"status"`
+}